@@ -7,10 +7,19 @@ import (
 
 //go:generate bundled/gen.sh
 //go:generate protoc -Ibundled/ --include_imports -ogen/google_api_annotations.fdp bundled/google/api/annotations.proto
+//go:generate protoc -Ibundled/ --include_imports -ogen/google_api_resource.fdp bundled/google/api/resource.proto
 //go:generate protoc -Ibundled/ --include_imports -ogen/google_protobuf_empty.fdp bundled/google/protobuf/empty.proto
 //go:generate protoc -Ibundled/ --include_imports -ogen/google_protobuf_timestamp.fdp bundled/google/protobuf/timestamp.proto
 //go:generate protoc -Ibundled/ --include_imports -ogen/google_protobuf_duration.fdp bundled/google/protobuf/duration.proto
+//go:generate protoc -Ibundled/ --include_imports -ogen/google_protobuf_field_mask.fdp bundled/google/protobuf/field_mask.proto
+//go:generate protoc -Ibundled/ --include_imports -ogen/google_protobuf_struct.fdp bundled/google/protobuf/struct.proto
+//go:generate protoc -Ibundled/ --include_imports -ogen/google_protobuf_wrappers.fdp bundled/google/protobuf/wrappers.proto
 //go:generate protoc -Ibundled/ --include_imports -ogen/protoc-gen-openapiv2_options_annotations.fdp bundled/protoc-gen-openapiv2/options/annotations.proto
+//go:generate protoc -Ibundled/ --include_imports -ogen/buf_validate_validate.fdp bundled/buf/validate/validate.proto
+//go:generate protoc -Ibundled/ --include_imports -ogen/validate_validate.fdp bundled/validate/validate.proto
+//go:generate protoc -Ibundled/ --include_imports -ogen/gunk_errors_errors.fdp bundled/gunk/errors/errors.proto
+//go:generate protoc -Ibundled/ --include_imports -ogen/gunk_lifecycle_lifecycle.fdp bundled/gunk/lifecycle/lifecycle.proto
+//go:generate protoc -Ibundled/ --include_imports -ogen/gunk_method_method.fdp bundled/gunk/method/method.proto
 //go:generate cp ../docgen/templates/api.md gen/api.md
 // Assets contains gen project assets.
 //
@@ -4,13 +4,13 @@ SRC=$(realpath $(cd -P "$(dirname "${BASH_SOURCE[0]}")" && pwd))
 
 # grab google protobuf definitions
 mkdir -p $SRC/google/protobuf
-for i in descriptor duration empty timestamp; do
+for i in descriptor duration empty field_mask struct timestamp wrappers; do
   wget -O $SRC/google/protobuf/$i.proto https://raw.githubusercontent.com/protocolbuffers/protobuf/master/src/google/protobuf/$i.proto
 done
 
 # grab google api definitions
 mkdir -p $SRC/google/api
-for i in annotations http; do
+for i in annotations http resource; do
   wget -O $SRC/google/api/$i.proto https://raw.githubusercontent.com/googleapis/googleapis/master/google/api/$i.proto
 done
 
@@ -19,3 +19,10 @@ mkdir -p $SRC/protoc-gen-openapiv2/options
 for i in annotations openapiv2; do
   wget -O $SRC/protoc-gen-openapiv2/options/$i.proto https://raw.githubusercontent.com/grpc-ecosystem/grpc-gateway/master/protoc-gen-openapiv2/options/$i.proto
 done
+
+# grab protoc-gen-validate definitions, pinned to a release
+mkdir -p $SRC/validate
+wget -O $SRC/validate/validate.proto https://raw.githubusercontent.com/bufbuild/protoc-gen-validate/v1.0.4/validate/validate.proto
+
+# grab protovalidate definitions, pinned to a Buf Schema Registry commit
+buf export buf.build/bufbuild/protovalidate:52f32327d4b0 --path buf/validate/validate.proto -o $SRC
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/gunk/gunk/asyncapigen/generate"
+	"github.com/gunk/gunk/plugin"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func main() {
+	plugin.RunMain(new(asyncAPIPlugin))
+}
+
+type asyncAPIPlugin struct{}
+
+func (p *asyncAPIPlugin) Generate(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	var flags flag.FlagSet
+	version := flags.String("version", "1.0.0", "version of the API, as set in the info of the documents")
+	gen, err := protogen.Options{ParamFunc: flags.Set}.New(req)
+	if err != nil {
+		return nil, err
+	}
+	gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+	if err := generate.Generate(gen, *version); err != nil {
+		return nil, err
+	}
+	return gen.Response(), nil
+}
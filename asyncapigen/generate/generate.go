@@ -0,0 +1,248 @@
+package generate
+
+import (
+	"encoding/json"
+
+	"github.com/gunk/gunk/protoutil"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// asyncAPIVersion is the version of the AsyncAPI specification the documents
+// follow.
+const asyncAPIVersion = "2.6.0"
+
+// Generate generates, for each file to generate with streaming methods, an
+// AsyncAPI document with a channel per streaming method, named after its full
+// gRPC method name. From the point of view of the server, the messages sent
+// by the clients are published to the channel, while the ones sent by the
+// server are subscribed to. Unary methods are left to OpenAPI.
+func Generate(gen *protogen.Plugin, version string) error {
+	for _, f := range gen.Files {
+		if !f.Generate {
+			continue
+		}
+		doc := document(f, version)
+		if len(doc.Channels) == 0 {
+			continue
+		}
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+		g := gen.NewGeneratedFile(f.GeneratedFilenamePrefix+".asyncapi.json", "")
+		g.Write(data)
+		g.Write([]byte("\n"))
+	}
+	return nil
+}
+
+// The JSON form of an AsyncAPI document, as documented in
+// https://www.asyncapi.com/docs/reference/specification/v2.6.0.
+type (
+	jsonDocument struct {
+		AsyncAPI           string                 `json:"asyncapi"`
+		Info               jsonInfo               `json:"info"`
+		DefaultContentType string                 `json:"defaultContentType"`
+		Channels           map[string]jsonChannel `json:"channels"`
+		Components         jsonComponents         `json:"components"`
+	}
+	jsonInfo struct {
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		Description string `json:"description,omitempty"`
+	}
+	jsonChannel struct {
+		Description string         `json:"description,omitempty"`
+		Publish     *jsonOperation `json:"publish,omitempty"`
+		Subscribe   *jsonOperation `json:"subscribe,omitempty"`
+	}
+	jsonOperation struct {
+		OperationID string  `json:"operationId"`
+		Summary     string  `json:"summary"`
+		Message     jsonRef `json:"message"`
+		// Stream is whether many messages are sent, rather than a
+		// single one.
+		Stream bool `json:"x-grpc-stream"`
+	}
+	jsonRef struct {
+		Ref string `json:"$ref"`
+	}
+	jsonComponents struct {
+		Messages map[string]jsonMessage `json:"messages"`
+		Schemas  map[string]*jsonSchema `json:"schemas"`
+	}
+	jsonMessage struct {
+		Name        string  `json:"name"`
+		Description string  `json:"description,omitempty"`
+		Payload     jsonRef `json:"payload"`
+	}
+	// jsonSchema is a JSON schema of the protobuf JSON mapping of a type.
+	jsonSchema struct {
+		Ref                  string                 `json:"$ref,omitempty"`
+		Type                 string                 `json:"type,omitempty"`
+		Format               string                 `json:"format,omitempty"`
+		Description          string                 `json:"description,omitempty"`
+		Enum                 []string               `json:"enum,omitempty"`
+		Items                *jsonSchema            `json:"items,omitempty"`
+		Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+		AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	}
+)
+
+// document returns the AsyncAPI document of the streaming methods of f.
+func document(f *protogen.File, version string) jsonDocument {
+	doc := jsonDocument{
+		AsyncAPI: asyncAPIVersion,
+		Info: jsonInfo{
+			Title:       string(f.Desc.Package()),
+			Version:     version,
+			Description: protoutil.Description(protoutil.PackageComments(f.Desc)),
+		},
+		DefaultContentType: "application/json",
+		Channels:           make(map[string]jsonChannel),
+		Components: jsonComponents{
+			Messages: make(map[string]jsonMessage),
+			Schemas:  make(map[string]*jsonSchema),
+		},
+	}
+	for _, srv := range f.Services {
+		for _, method := range srv.Methods {
+			clientStream := method.Desc.IsStreamingClient()
+			serverStream := method.Desc.IsStreamingServer()
+			if !clientStream && !serverStream {
+				continue
+			}
+			id := srv.GoName + method.GoName
+			doc.Channels["/"+string(srv.Desc.FullName())+"/"+string(method.Desc.Name())] = jsonChannel{
+				Description: protoutil.Description(string(method.Comments.Leading)),
+				Publish: &jsonOperation{
+					OperationID: id + "Request",
+					Summary:     summary("client", clientStream),
+					Message:     doc.addMessage(method.Input),
+					Stream:      clientStream,
+				},
+				Subscribe: &jsonOperation{
+					OperationID: id + "Response",
+					Summary:     summary("server", serverStream),
+					Message:     doc.addMessage(method.Output),
+					Stream:      serverStream,
+				},
+			}
+		}
+	}
+	return doc
+}
+
+// summary returns the summary of the operation sending the messages of sender.
+func summary(sender string, stream bool) string {
+	if stream {
+		return "Stream of messages sent by the " + sender + "."
+	}
+	return "Single message sent by the " + sender + "."
+}
+
+// addMessage adds msg to the messages of the document, along with the schemas
+// of its payload, and returns a reference to it.
+func (doc *jsonDocument) addMessage(msg *protogen.Message) jsonRef {
+	name := string(msg.Desc.FullName())
+	if _, ok := doc.Components.Messages[name]; !ok {
+		doc.Components.Messages[name] = jsonMessage{
+			Name:        name,
+			Description: protoutil.Description(string(msg.Comments.Leading)),
+			Payload:     jsonRef{Ref: doc.addSchema(msg.Desc).Ref},
+		}
+	}
+	return jsonRef{Ref: "#/components/messages/" + name}
+}
+
+// addSchema adds the schema of the message or enum desc to the schemas of the
+// document, along with the schemas of the types it refers to, and returns a
+// reference to it.
+func (doc *jsonDocument) addSchema(desc protoreflect.Descriptor) *jsonSchema {
+	name := string(desc.FullName())
+	ref := &jsonSchema{Ref: "#/components/schemas/" + name}
+	if _, ok := doc.Components.Schemas[name]; ok {
+		return ref
+	}
+	schema := &jsonSchema{
+		Description: protoutil.Description(desc.ParentFile().SourceLocations().ByDescriptor(desc).LeadingComments),
+	}
+	// Add the schema before its fields, as they may refer to it.
+	doc.Components.Schemas[name] = schema
+	switch desc := desc.(type) {
+	case protoreflect.EnumDescriptor:
+		schema.Type = "string"
+		values := desc.Values()
+		for i := 0; i < values.Len(); i++ {
+			schema.Enum = append(schema.Enum, string(values.Get(i).Name()))
+		}
+	case protoreflect.MessageDescriptor:
+		schema.Type = "object"
+		schema.Properties = make(map[string]*jsonSchema)
+		fields := desc.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			field := fields.Get(i)
+			schema.Properties[field.JSONName()] = doc.fieldSchema(field)
+		}
+	}
+	return ref
+}
+
+// fieldSchema returns the schema of the values of field.
+func (doc *jsonDocument) fieldSchema(field protoreflect.FieldDescriptor) *jsonSchema {
+	switch {
+	case field.IsMap():
+		return &jsonSchema{
+			Type:                 "object",
+			AdditionalProperties: doc.kindSchema(field.MapValue()),
+		}
+	case field.IsList():
+		return &jsonSchema{
+			Type:  "array",
+			Items: doc.kindSchema(field),
+		}
+	}
+	return doc.kindSchema(field)
+}
+
+// kindSchema returns the schema of a single value of field, following the
+// protobuf JSON mapping.
+func (doc *jsonDocument) kindSchema(field protoreflect.FieldDescriptor) *jsonSchema {
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		return &jsonSchema{Type: "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return &jsonSchema{Type: "integer", Format: "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return &jsonSchema{Type: "integer", Format: "uint32"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		// 64-bit integers are encoded as strings.
+		return &jsonSchema{Type: "string", Format: "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return &jsonSchema{Type: "string", Format: "uint64"}
+	case protoreflect.FloatKind:
+		return &jsonSchema{Type: "number", Format: "float"}
+	case protoreflect.DoubleKind:
+		return &jsonSchema{Type: "number", Format: "double"}
+	case protoreflect.StringKind:
+		return &jsonSchema{Type: "string"}
+	case protoreflect.BytesKind:
+		return &jsonSchema{Type: "string", Format: "byte"}
+	case protoreflect.EnumKind:
+		return doc.addSchema(field.Enum())
+	}
+	msg := field.Message()
+	switch msg.FullName() {
+	case "google.protobuf.Timestamp":
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case "google.protobuf.Duration":
+		return &jsonSchema{Type: "string"}
+	}
+	if msg.ParentFile().Package() == "google.protobuf" {
+		// The other well-known types have special JSON mappings,
+		// such as Struct and Any; leave them unconstrained.
+		return &jsonSchema{}
+	}
+	return doc.addSchema(msg)
+}
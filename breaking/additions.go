@@ -0,0 +1,82 @@
+package breaking
+
+import (
+	"sort"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Additions returns the elements added from prev to cur, such as new
+// messages, fields or methods. Additions don't break existing clients, but
+// are new features of the API.
+func Additions(prev, cur *descriptorpb.FileDescriptorSet) []Change {
+	old := elements(prev)
+	var changes []Change
+	for key, c := range elements(cur) {
+		if _, ok := old[key]; !ok {
+			changes = append(changes, c)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].File != changes[j].File {
+			return changes[i].File < changes[j].File
+		}
+		if changes[i].Name != changes[j].Name {
+			return changes[i].Name < changes[j].Name
+		}
+		return changes[i].Msg < changes[j].Msg
+	})
+	return changes
+}
+
+// elements returns every file, message, field, enum, enum value, service and
+// method in set, keyed by kind and full name. Each is described as if it was
+// added.
+func elements(set *descriptorpb.FileDescriptorSet) map[string]Change {
+	m := make(map[string]Change)
+	for _, f := range set.GetFile() {
+		e := &elementWalker{file: f.GetName(), elems: m}
+		e.add("file", f.GetPackage(), "file added")
+		e.messages(f.GetPackage(), f.GetMessageType())
+		e.enums(f.GetPackage(), f.GetEnumType())
+		for _, s := range f.GetService() {
+			name := f.GetPackage() + "." + s.GetName()
+			e.add("service", name, "service added")
+			for _, meth := range s.GetMethod() {
+				e.add("method", name+"."+meth.GetName(), "method added")
+			}
+		}
+	}
+	return m
+}
+
+type elementWalker struct {
+	file  string
+	elems map[string]Change
+}
+
+func (e *elementWalker) add(kind, name, msg string) {
+	e.elems[kind+" "+name] = Change{File: e.file, Name: name, Msg: msg}
+}
+
+func (e *elementWalker) messages(scope string, msgs []*descriptorpb.DescriptorProto) {
+	for _, msg := range msgs {
+		name := scope + "." + msg.GetName()
+		e.add("message", name, "message added")
+		for _, f := range msg.GetField() {
+			e.add("field", name+"."+f.GetName(), "field added")
+		}
+		e.messages(name, msg.GetNestedType())
+		e.enums(name, msg.GetEnumType())
+	}
+}
+
+func (e *elementWalker) enums(scope string, enums []*descriptorpb.EnumDescriptorProto) {
+	for _, enum := range enums {
+		name := scope + "." + enum.GetName()
+		e.add("enum", name, "enum added")
+		for _, v := range enum.GetValue() {
+			e.add("value", name+"."+v.GetName(), "enum value added")
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// Package breaking compares protobuf descriptors to find changes which break
+// existing clients, either on the wire or in the generated source code.
+package breaking
+
+import (
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Change is a single breaking change between two descriptor sets.
+type Change struct {
+	File string // proto file containing the change
+	Name string // full name of the changed element
+	Msg  string
+	// Wire is true if the change breaks the encoded messages or the RPCs
+	// between existing clients and servers. Other changes only break the
+	// source code generated for them.
+	Wire bool
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s: %s", c.File, c.Name, c.Msg)
+}
+
+// Compare returns the breaking changes from the files in prev to the files in
+// cur. Files only present in cur are new and never breaking, while files only
+// in prev are reported as removed.
+func Compare(prev, cur *descriptorpb.FileDescriptorSet) []Change {
+	curFiles := make(map[string]*descriptorpb.FileDescriptorProto)
+	for _, f := range cur.GetFile() {
+		curFiles[f.GetName()] = f
+	}
+	var changes []Change
+	for _, pf := range prev.GetFile() {
+		cf := curFiles[pf.GetName()]
+		if cf == nil {
+			changes = append(changes, Change{File: pf.GetName(), Name: pf.GetPackage(), Msg: "file removed", Wire: len(pf.GetService()) > 0})
+			continue
+		}
+		changes = append(changes, CompareFiles(pf, cf)...)
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].File != changes[j].File {
+			return changes[i].File < changes[j].File
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+// CompareFiles returns the breaking changes from prev to cur, two versions of
+// the same proto file.
+func CompareFiles(prev, cur *descriptorpb.FileDescriptorProto) []Change {
+	c := &comparer{file: cur.GetName()}
+	if prev.GetPackage() != cur.GetPackage() {
+		c.add(true, prev.GetPackage(), "package renamed to %q", cur.GetPackage())
+		return c.changes
+	}
+	c.messages(prev.GetPackage(), prev.GetMessageType(), cur.GetMessageType())
+	c.enums(prev.GetPackage(), prev.GetEnumType(), cur.GetEnumType())
+	c.services(prev.GetPackage(), prev.GetService(), cur.GetService())
+	return c.changes
+}
+
+type comparer struct {
+	file    string
+	changes []Change
+}
+
+func (c *comparer) add(wire bool, name, format string, args ...interface{}) {
+	c.changes = append(c.changes, Change{File: c.file, Name: name, Msg: fmt.Sprintf(format, args...), Wire: wire})
+}
+
+func (c *comparer) messages(scope string, prev, cur []*descriptorpb.DescriptorProto) {
+	curByName := make(map[string]*descriptorpb.DescriptorProto)
+	for _, m := range cur {
+		curByName[m.GetName()] = m
+	}
+	for _, pm := range prev {
+		name := scope + "." + pm.GetName()
+		cm := curByName[pm.GetName()]
+		if cm == nil {
+			c.add(false, name, "message removed")
+			continue
+		}
+		c.fields(name, pm.GetField(), cm.GetField())
+		c.messages(name, pm.GetNestedType(), cm.GetNestedType())
+		c.enums(name, pm.GetEnumType(), cm.GetEnumType())
+	}
+}
+
+func (c *comparer) fields(scope string, prev, cur []*descriptorpb.FieldDescriptorProto) {
+	curByNumber := make(map[int32]*descriptorpb.FieldDescriptorProto)
+	curByName := make(map[string]*descriptorpb.FieldDescriptorProto)
+	for _, f := range cur {
+		curByNumber[f.GetNumber()] = f
+		curByName[f.GetName()] = f
+	}
+	for _, pf := range prev {
+		name := scope + "." + pf.GetName()
+		// A field keeping its name under a new number can't be decoded
+		// from messages encoded with the old number.
+		moved := curByName[pf.GetName()]
+		if moved != nil && moved.GetNumber() == pf.GetNumber() {
+			moved = nil
+		}
+		cf := curByNumber[pf.GetNumber()]
+		if cf == nil {
+			if moved != nil {
+				c.add(true, name, "field %d renumbered to %d", pf.GetNumber(), moved.GetNumber())
+			} else {
+				c.add(false, name, "field %d removed", pf.GetNumber())
+			}
+			continue
+		}
+		if cf.GetName() != pf.GetName() {
+			if moved != nil {
+				c.add(true, name, "field %d reused by %q", pf.GetNumber(), cf.GetName())
+				continue
+			}
+			c.add(false, name, "field %d renamed to %q", pf.GetNumber(), cf.GetName())
+		}
+		if cf.GetType() != pf.GetType() || cf.GetTypeName() != pf.GetTypeName() {
+			c.add(true, name, "field %d type changed from %s to %s", pf.GetNumber(), fieldType(pf), fieldType(cf))
+		}
+		if cf.GetLabel() != pf.GetLabel() {
+			c.add(true, name, "field %d label changed from %s to %s", pf.GetNumber(), pf.GetLabel(), cf.GetLabel())
+		}
+		if cf.GetJsonName() != pf.GetJsonName() {
+			// The JSON encoding is also part of the wire format.
+			c.add(true, name, "field %d json name changed from %q to %q", pf.GetNumber(), pf.GetJsonName(), cf.GetJsonName())
+		}
+	}
+}
+
+func (c *comparer) enums(scope string, prev, cur []*descriptorpb.EnumDescriptorProto) {
+	curByName := make(map[string]*descriptorpb.EnumDescriptorProto)
+	for _, e := range cur {
+		curByName[e.GetName()] = e
+	}
+	for _, pe := range prev {
+		name := scope + "." + pe.GetName()
+		ce := curByName[pe.GetName()]
+		if ce == nil {
+			c.add(false, name, "enum removed")
+			continue
+		}
+		curByNumber := make(map[int32]string)
+		for _, v := range ce.GetValue() {
+			curByNumber[v.GetNumber()] = v.GetName()
+		}
+		for _, pv := range pe.GetValue() {
+			cv, ok := curByNumber[pv.GetNumber()]
+			if !ok {
+				c.add(false, name+"."+pv.GetName(), "enum value %d removed", pv.GetNumber())
+			} else if cv != pv.GetName() {
+				c.add(false, name+"."+pv.GetName(), "enum value %d renamed to %q", pv.GetNumber(), cv)
+			}
+		}
+	}
+}
+
+func (c *comparer) services(scope string, prev, cur []*descriptorpb.ServiceDescriptorProto) {
+	curByName := make(map[string]*descriptorpb.ServiceDescriptorProto)
+	for _, s := range cur {
+		curByName[s.GetName()] = s
+	}
+	for _, ps := range prev {
+		name := scope + "." + ps.GetName()
+		cs := curByName[ps.GetName()]
+		if cs == nil {
+			c.add(true, name, "service removed")
+			continue
+		}
+		curMethods := make(map[string]*descriptorpb.MethodDescriptorProto)
+		for _, m := range cs.GetMethod() {
+			curMethods[m.GetName()] = m
+		}
+		for _, pm := range ps.GetMethod() {
+			mname := name + "." + pm.GetName()
+			cm := curMethods[pm.GetName()]
+			switch {
+			case cm == nil:
+				c.add(true, mname, "method removed")
+				continue
+			case cm.GetInputType() != pm.GetInputType():
+				c.add(true, mname, "request type changed from %s to %s", pm.GetInputType(), cm.GetInputType())
+			case cm.GetOutputType() != pm.GetOutputType():
+				c.add(true, mname, "response type changed from %s to %s", pm.GetOutputType(), cm.GetOutputType())
+			}
+			if cm.GetClientStreaming() != pm.GetClientStreaming() || cm.GetServerStreaming() != pm.GetServerStreaming() {
+				c.add(true, mname, "streaming changed")
+			}
+		}
+	}
+}
+
+func fieldType(f *descriptorpb.FieldDescriptorProto) string {
+	if f.GetTypeName() != "" {
+		return f.GetTypeName()
+	}
+	return f.GetType().String()
+}
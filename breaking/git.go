@@ -0,0 +1,119 @@
+package breaking
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gunk/gunk/generate"
+	"github.com/gunk/gunk/interrupt"
+	"github.com/gunk/gunk/log"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// CompareRef returns the breaking changes to the Gunk packages pkgPaths, from
+// their versions at the git ref to the ones in the working tree at dir.
+// Packages which didn't exist at ref are skipped.
+func CompareRef(dir, ref string, pkgPaths ...string) ([]Change, error) {
+	prev, err := DescriptorSetAtRef(dir, ref, pkgPaths...)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := descriptorSet(dir, pkgPaths, false, false)
+	if err != nil {
+		return nil, err
+	}
+	return Compare(prev, cur), nil
+}
+
+// DescriptorSetAtRef returns the descriptors of the Gunk packages pkgPaths as
+// they were at the git ref, checking out the ref in a temporary worktree.
+// Packages which didn't exist at ref are skipped.
+func DescriptorSetAtRef(dir, ref string, pkgPaths ...string) (*descriptorpb.FileDescriptorSet, error) {
+	return descriptorSetAtRef(dir, ref, false, pkgPaths...)
+}
+
+// descriptorSetAtRef is like DescriptorSetAtRef, also keeping the imports of
+// the packages if withImports is set.
+func descriptorSetAtRef(dir, ref string, withImports bool, pkgPaths ...string) (*descriptorpb.FileDescriptorSet, error) {
+	top, err := Git(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(top, absDir)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempDir("", "gunk-ref-")
+	if err != nil {
+		return nil, err
+	}
+	worktree := filepath.Join(tmp, "tree")
+	cleanup := interrupt.Register(func() {
+		Git(dir, "worktree", "remove", "--force", worktree)
+		os.RemoveAll(tmp)
+	})
+	defer cleanup.Run()
+	if _, err := Git(dir, "worktree", "add", "--detach", worktree, ref); err != nil {
+		return nil, err
+	}
+	return descriptorSet(filepath.Join(worktree, rel), pkgPaths, true, withImports)
+}
+
+// DescriptorSet returns the unified proto files of the Gunk packages pkgPaths,
+// as found in dir.
+func DescriptorSet(dir string, pkgPaths ...string) (*descriptorpb.FileDescriptorSet, error) {
+	return descriptorSet(dir, pkgPaths, false, false)
+}
+
+// descriptorSet returns the unified proto files of the given Gunk packages.
+// If withImports is set, the files they import are kept too, once each, so
+// that the set is self-contained.
+func descriptorSet(dir string, pkgPaths []string, skipMissing, withImports bool) (*descriptorpb.FileDescriptorSet, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]bool)
+	for _, pkgPath := range pkgPaths {
+		fds, err := generate.FileDescriptorSet(dir, pkgPath)
+		if err != nil {
+			if skipMissing {
+				log.Verbosef("skipping %s: %v", pkgPath, err)
+				continue
+			}
+			return nil, err
+		}
+		for _, f := range fds.File {
+			if seen[f.GetName()] || (!withImports && f.GetName() != pkgPath+"/all.proto") {
+				continue
+			}
+			seen[f.GetName()] = true
+			set.File = append(set.File, f)
+		}
+	}
+	return set, nil
+}
+
+// Git runs a git command in dir, returning its trimmed standard output.
+func Git(dir string, args ...string) (string, error) {
+	cmd := log.ExecCommand("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", log.ExecError("git "+strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Describe formats a list of changes, one per line.
+func Describe(changes []Change) string {
+	var sb strings.Builder
+	for _, c := range changes {
+		fmt.Fprintln(&sb, c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,108 @@
+package breaking
+
+import (
+	"fmt"
+	"go/token"
+	"io"
+	"io/ioutil"
+
+	"github.com/gunk/gunk/loader"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Options configures `gunk breaking`.
+type Options struct {
+	// Against is the git ref to compare against, used if AgainstFile is
+	// empty.
+	Against string
+	// AgainstFile is a FileDescriptorSet to compare against, as written
+	// by `gunk dump`.
+	AgainstFile string
+	// WireOnly only reports the changes which break the wire format.
+	WireOnly bool
+}
+
+// Run compares the Gunk packages matching patterns in dir with their previous
+// version, writing the breaking changes found to w. An error is returned if
+// there were any breaking changes.
+func Run(w io.Writer, dir string, opts Options, patterns ...string) error {
+	pkgPaths, err := packagePaths(dir, patterns...)
+	if err != nil {
+		return err
+	}
+	if len(pkgPaths) == 0 {
+		return fmt.Errorf("no Gunk packages to compare")
+	}
+	var prev *descriptorpb.FileDescriptorSet
+	if opts.AgainstFile != "" {
+		prev, err = readDescriptorSet(opts.AgainstFile, pkgPaths, false)
+	} else {
+		prev, err = DescriptorSetAtRef(dir, opts.Against, pkgPaths...)
+	}
+	if err != nil {
+		return err
+	}
+	cur, err := descriptorSet(dir, pkgPaths, false, false)
+	if err != nil {
+		return err
+	}
+	var changes []Change
+	for _, c := range Compare(prev, cur) {
+		if opts.WireOnly && !c.Wire {
+			continue
+		}
+		changes = append(changes, c)
+	}
+	fmt.Fprint(w, Describe(changes))
+	if len(changes) > 0 {
+		return fmt.Errorf("found %d breaking changes", len(changes))
+	}
+	return nil
+}
+
+// readDescriptorSet reads a saved FileDescriptorSet, keeping the unified
+// proto files of the Gunk packages pkgPaths. Other files, such as the imports
+// included by `gunk dump`, are only kept if withImports is set.
+func readDescriptorSet(path string, pkgPaths []string, withImports bool) (*descriptorpb.FileDescriptorSet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var all descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %w", path, err)
+	}
+	if withImports {
+		return &all, nil
+	}
+	want := make(map[string]bool, len(pkgPaths))
+	for _, pkgPath := range pkgPaths {
+		want[pkgPath+"/all.proto"] = true
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, f := range all.File {
+		if want[f.GetName()] {
+			set.File = append(set.File, f)
+		}
+	}
+	return set, nil
+}
+
+// packagePaths returns the import paths of the Gunk packages matching
+// patterns in dir.
+func packagePaths(dir string, patterns ...string) ([]string, error) {
+	l := loader.Loader{Dir: dir, Fset: token.NewFileSet()}
+	pkgs, err := l.Load(patterns...)
+	if err != nil {
+		return nil, err
+	}
+	if loader.PrintErrors(pkgs) > 0 {
+		return nil, fmt.Errorf("encountered package loading errors")
+	}
+	paths := make([]string, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		paths = append(paths, pkg.PkgPath)
+	}
+	return paths, nil
+}
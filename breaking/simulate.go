@@ -0,0 +1,461 @@
+package breaking
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// SimulateOptions configures `gunk wire-compat`.
+type SimulateOptions struct {
+	// Against is the git ref to compare against, used if AgainstFile is
+	// empty.
+	Against string
+	// AgainstFile is a FileDescriptorSet to compare against, as written
+	// by `gunk dump` with its imports.
+	AgainstFile string
+	// Samples is the number of random messages encoded for each message
+	// type, in each direction.
+	Samples int
+	// Seed seeds the random messages, so that runs are reproducible.
+	Seed int64
+}
+
+// Incompatibility is a field whose value, as written by one version of a
+// message, differs once read by the other version.
+type Incompatibility struct {
+	Field    string // path of the field, from the message written
+	Encoding string // "binary" or "JSON"
+	Writer   string // "baseline" or "current"
+	Reader   string
+	Msg      string
+}
+
+func (i Incompatibility) String() string {
+	return fmt.Sprintf("%s: %s, %s to %s: %s", i.Field, i.Encoding, i.Writer, i.Reader, i.Msg)
+}
+
+// RunSimulate checks the wire compatibility of the Gunk packages matching
+// patterns in dir with their previous version, by encoding random messages
+// with each version and decoding them with the other. The incompatibilities
+// found are written to w, and an error is returned if there were any.
+func RunSimulate(w io.Writer, dir string, opts SimulateOptions, patterns ...string) error {
+	pkgPaths, err := packagePaths(dir, patterns...)
+	if err != nil {
+		return err
+	}
+	if len(pkgPaths) == 0 {
+		return fmt.Errorf("no Gunk packages to compare")
+	}
+	var prev *descriptorpb.FileDescriptorSet
+	if opts.AgainstFile != "" {
+		prev, err = readDescriptorSet(opts.AgainstFile, pkgPaths, true)
+	} else {
+		prev, err = descriptorSetAtRef(dir, opts.Against, true, pkgPaths...)
+	}
+	if err != nil {
+		return err
+	}
+	cur, err := descriptorSet(dir, pkgPaths, false, true)
+	if err != nil {
+		return err
+	}
+	files := make([]string, len(pkgPaths))
+	for i, pkgPath := range pkgPaths {
+		files[i] = pkgPath + "/all.proto"
+	}
+	found, err := Simulate(prev, cur, files, opts.Samples, opts.Seed)
+	if err != nil {
+		return err
+	}
+	for _, inc := range found {
+		fmt.Fprintln(w, inc)
+	}
+	if len(found) > 0 {
+		return fmt.Errorf("found %d wire incompatibilities", len(found))
+	}
+	return nil
+}
+
+// Simulate encodes samples random messages of each type in the files of prev
+// and cur, both self-contained sets, and decodes them with the other version,
+// in the binary and JSON encodings. It returns the fields whose values
+// changed along the way, once for each field, encoding and direction.
+//
+// Messages are matched by full name, along with the requests and responses
+// of the methods of both versions. Fields are matched the way each encoding
+// finds them, and by name, as the application does. Files only present in one
+// of the versions are skipped.
+func Simulate(prev, cur *descriptorpb.FileDescriptorSet, files []string, samples int, seed int64) ([]Incompatibility, error) {
+	prevFiles, err := protodesc.NewFiles(prev)
+	if err != nil {
+		return nil, fmt.Errorf("invalid baseline: %w", err)
+	}
+	curFiles, err := protodesc.NewFiles(cur)
+	if err != nil {
+		return nil, err
+	}
+	s := &simulation{
+		rnd:  rand.New(rand.NewSource(seed)),
+		seen: make(map[string]bool),
+	}
+	var pairs [][2]protoreflect.MessageDescriptor
+	seen := make(map[[2]protoreflect.FullName]bool)
+	addPair := func(p, c protoreflect.MessageDescriptor) {
+		key := [2]protoreflect.FullName{p.FullName(), c.FullName()}
+		if !seen[key] && !p.IsMapEntry() {
+			seen[key] = true
+			pairs = append(pairs, [2]protoreflect.MessageDescriptor{p, c})
+		}
+	}
+	for _, name := range files {
+		pf, err := prevFiles.FindFileByPath(name)
+		if err != nil {
+			continue
+		}
+		if _, err := curFiles.FindFileByPath(name); err != nil {
+			continue
+		}
+		rangeMessages(pf.Messages(), func(pm protoreflect.MessageDescriptor) {
+			if d, err := curFiles.FindDescriptorByName(pm.FullName()); err == nil {
+				if cm, ok := d.(protoreflect.MessageDescriptor); ok {
+					addPair(pm, cm)
+				}
+			}
+		})
+		for i := 0; i < pf.Services().Len(); i++ {
+			methods := pf.Services().Get(i).Methods()
+			for j := 0; j < methods.Len(); j++ {
+				pm := methods.Get(j)
+				d, err := curFiles.FindDescriptorByName(pm.FullName())
+				if err != nil {
+					continue
+				}
+				if cm, ok := d.(protoreflect.MethodDescriptor); ok {
+					addPair(pm.Input(), cm.Input())
+					addPair(pm.Output(), cm.Output())
+				}
+			}
+		}
+	}
+	for _, pair := range pairs {
+		for i := 0; i < samples; i++ {
+			s.check(s.message(pair[0], 0), pair[1], "baseline", "current")
+			s.check(s.message(pair[1], 0), pair[0], "current", "baseline")
+		}
+	}
+	sort.SliceStable(s.found, func(i, j int) bool {
+		return s.found[i].Field < s.found[j].Field
+	})
+	return s.found, nil
+}
+
+func rangeMessages(mds protoreflect.MessageDescriptors, fn func(protoreflect.MessageDescriptor)) {
+	for i := 0; i < mds.Len(); i++ {
+		fn(mds.Get(i))
+		rangeMessages(mds.Get(i).Messages(), fn)
+	}
+}
+
+// maxDepth limits the nesting of the random messages, as messages can be
+// recursive.
+const maxDepth = 3
+
+type simulation struct {
+	rnd   *rand.Rand
+	found []Incompatibility
+	// seen holds the incompatibilities found, so that each is only
+	// reported once rather than for every sample.
+	seen map[string]bool
+
+	// The encoding and direction being checked.
+	encoding, writer, reader string
+}
+
+func (s *simulation) report(field, format string, args ...interface{}) {
+	inc := Incompatibility{
+		Field:    field,
+		Encoding: s.encoding,
+		Writer:   s.writer,
+		Reader:   s.reader,
+		Msg:      fmt.Sprintf(format, args...),
+	}
+	key := inc.Field + "\x00" + inc.Encoding + "\x00" + inc.Writer
+	if !s.seen[key] {
+		s.seen[key] = true
+		s.found = append(s.found, inc)
+	}
+}
+
+// check decodes written with the reader message, in each encoding, and
+// compares the values of their fields.
+func (s *simulation) check(written protoreflect.Message, reader protoreflect.MessageDescriptor, writer, readerName string) {
+	s.writer, s.reader = writer, readerName
+	name := string(written.Descriptor().FullName())
+
+	s.encoding = "binary"
+	if b, err := proto.Marshal(written.Interface()); err != nil {
+		s.report(name, "unable to encode: %v", err)
+	} else {
+		read := dynamicpb.NewMessage(reader)
+		if err := proto.Unmarshal(b, read); err != nil {
+			s.report(name, "unable to decode: %v", err)
+		} else {
+			s.compare(name, written, read)
+		}
+	}
+
+	s.encoding = "JSON"
+	if b, err := protojson.Marshal(written.Interface()); err != nil {
+		s.report(name, "unable to encode: %v", err)
+	} else {
+		read := dynamicpb.NewMessage(reader)
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, read); err != nil {
+			s.report(name, "unable to decode: %v", err)
+		} else {
+			s.compare(name, written, read)
+		}
+	}
+}
+
+// compare reports the fields set in w whose values differ in r. The fields of
+// r are matched the way the encoding finds them, by number or by JSON name,
+// and also by name, as the application does.
+func (s *simulation) compare(path string, w, r protoreflect.Message) {
+	fields := w.Descriptor().Fields()
+	readFields := r.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		wf := fields.Get(i)
+		if !w.Has(wf) {
+			continue
+		}
+		fpath := path + "." + string(wf.Name())
+		var rf protoreflect.FieldDescriptor
+		if s.encoding == "binary" {
+			rf = readFields.ByNumber(wf.Number())
+		} else if rf = readFields.ByJSONName(wf.JSONName()); rf == nil {
+			rf = readFields.ByName(protoreflect.Name(wf.JSONName()))
+		}
+		if rf != nil {
+			s.compareField(fpath, wf, w.Get(wf), rf, r)
+		}
+		if rn := readFields.ByName(wf.Name()); rn != nil && rn != rf {
+			s.compareField(fpath, wf, w.Get(wf), rn, r)
+		}
+	}
+}
+
+// compareField compares the value wv of the field wf with the value of the
+// field rf in r.
+func (s *simulation) compareField(path string, wf protoreflect.FieldDescriptor, wv protoreflect.Value, rf protoreflect.FieldDescriptor, r protoreflect.Message) {
+	rv := r.Get(rf)
+	switch {
+	case wf.IsMap() && rf.IsMap():
+		read := make(map[string]protoreflect.Value)
+		rv.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			read[scalarKey(rf.MapKey(), k.Value())] = v
+			return true
+		})
+		if len(read) != wv.Map().Len() {
+			s.report(path, "wrote %d entries, read %d", wv.Map().Len(), len(read))
+			return
+		}
+		wv.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			rv, ok := read[scalarKey(wf.MapKey(), k.Value())]
+			if !ok {
+				s.report(path, "wrote key %s, read it as missing", render(wf.MapKey(), k.Value()))
+				return false
+			}
+			s.compareValue(path, wf.MapValue(), v, rf.MapValue(), rv, true)
+			return true
+		})
+	case wf.IsList() && rf.IsList() && !wf.IsMap() && !rf.IsMap():
+		wl, rl := wv.List(), rv.List()
+		if wl.Len() != rl.Len() {
+			s.report(path, "wrote %d values, read %d", wl.Len(), rl.Len())
+			return
+		}
+		for i := 0; i < wl.Len(); i++ {
+			s.compareValue(path, wf, wl.Get(i), rf, rl.Get(i), true)
+		}
+	case wf.IsList() || rf.IsList():
+		s.report(path, "wrote %s, read %s", renderField(wf, wv), renderField(rf, rv))
+	default:
+		s.compareValue(path, wf, wv, rf, rv, r.Has(rf))
+	}
+}
+
+// compareValue compares a single value, such as an element of a list. has
+// reports whether the value was read at all.
+func (s *simulation) compareValue(path string, wf protoreflect.FieldDescriptor, wv protoreflect.Value, rf protoreflect.FieldDescriptor, rv protoreflect.Value, has bool) {
+	wm, rm := wf.Message() != nil, rf.Message() != nil
+	switch {
+	case wm && rm && !has:
+		s.report(path, "wrote %s, read nothing", render(wf, wv))
+	case wm && rm:
+		s.compare(path, wv.Message(), rv.Message())
+	case wm != rm, scalarKey(wf, wv) != scalarKey(rf, rv):
+		s.report(path, "wrote %s, read %s", render(wf, wv), render(rf, rv))
+	}
+}
+
+// scalarKey returns a scalar value in a form which is equal for the values
+// encoded the same way, such as an enum and its number, or a string and its
+// bytes.
+func scalarKey(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		if v.Bool() {
+			return "1"
+		}
+		return "0"
+	case protoreflect.EnumKind:
+		return strconv.Itoa(int(v.Enum()))
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.BytesKind:
+		return string(v.Bytes())
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func render(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return "{...}"
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return strconv.Itoa(int(v.Enum()))
+	case protoreflect.StringKind:
+		return strconv.Quote(v.String())
+	case protoreflect.BytesKind:
+		return fmt.Sprintf("%q", v.Bytes())
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func renderField(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch {
+	case fd.IsMap():
+		return fmt.Sprintf("%d entries", v.Map().Len())
+	case fd.IsList():
+		var vals []string
+		for i := 0; i < v.List().Len(); i++ {
+			vals = append(vals, render(fd, v.List().Get(i)))
+		}
+		return "[" + strings.Join(vals, ", ") + "]"
+	}
+	return render(fd, v)
+}
+
+// message returns a random message of type md, with all its fields set to
+// values which aren't zero, and one field of each oneof.
+func (s *simulation) message(md protoreflect.MessageDescriptor, depth int) protoreflect.Message {
+	m := dynamicpb.NewMessage(md)
+	if md.ParentFile().Package() == "google.protobuf" {
+		// The well-known types have special JSON encodings, which
+		// random values don't always fit.
+		if md.FullName() == "google.protobuf.Value" {
+			m.Set(md.Fields().ByName("null_value"), protoreflect.ValueOfEnum(0))
+		}
+		return m
+	}
+	if depth >= maxDepth {
+		return m
+	}
+	oneofs := make(map[protoreflect.OneofDescriptor]bool)
+	for i := 0; i < md.Fields().Len(); i++ {
+		fd := md.Fields().Get(i)
+		if od := fd.ContainingOneof(); od != nil && !od.IsSynthetic() {
+			if oneofs[od] {
+				continue
+			}
+			oneofs[od] = true
+			fd = od.Fields().Get(s.rnd.Intn(od.Fields().Len()))
+		}
+		switch {
+		case fd.IsMap():
+			mp := m.Mutable(fd).Map()
+			for j := 1 + s.rnd.Intn(2); j > 0; j-- {
+				mp.Set(s.scalar(fd.MapKey()).MapKey(), s.value(fd.MapValue(), depth))
+			}
+		case fd.IsList():
+			l := m.Mutable(fd).List()
+			for j := 1 + s.rnd.Intn(3); j > 0; j-- {
+				l.Append(s.value(fd, depth))
+			}
+		default:
+			m.Set(fd, s.value(fd, depth))
+		}
+	}
+	return m
+}
+
+func (s *simulation) value(fd protoreflect.FieldDescriptor, depth int) protoreflect.Value {
+	if fd.Message() != nil {
+		return protoreflect.ValueOfMessage(s.message(fd.Message(), depth+1))
+	}
+	return s.scalar(fd)
+}
+
+// scalar returns a random value for fd, which isn't zero so that it is
+// always encoded. Signed integers can be negative.
+func (s *simulation) scalar(fd protoreflect.FieldDescriptor) protoreflect.Value {
+	signed := func(max int64) int64 {
+		n := 1 + s.rnd.Int63n(max)
+		if s.rnd.Intn(2) == 0 {
+			return -n
+		}
+		return n
+	}
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(true)
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		if values.Len() == 1 {
+			return protoreflect.ValueOfEnum(values.Get(0).Number())
+		}
+		return protoreflect.ValueOfEnum(values.Get(1 + s.rnd.Intn(values.Len()-1)).Number())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(int32(signed(1 << 20)))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(signed(1 << 40))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return protoreflect.ValueOfUint32(uint32(1 + s.rnd.Int63n(1<<20)))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return protoreflect.ValueOfUint64(uint64(1 + s.rnd.Int63n(1<<40)))
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(float32(signed(1<<10)) / 4)
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(float64(signed(1<<20)) / 8)
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s.text())
+	case protoreflect.BytesKind:
+		b := make([]byte, 1+s.rnd.Intn(8))
+		s.rnd.Read(b)
+		return protoreflect.ValueOfBytes(b)
+	}
+	panic(fmt.Sprintf("unexpected kind %s", fd.Kind()))
+}
+
+func (s *simulation) text() string {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	b := make([]byte, 1+s.rnd.Intn(8))
+	for i := range b {
+		b[i] = letters[s.rnd.Intn(len(letters))]
+	}
+	return string(b)
+}
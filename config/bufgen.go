@@ -0,0 +1,206 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// bufRemotePlugins maps the remote plugins of the Buf Schema Registry to the
+// generators which gunk can download at a pinned version.
+var bufRemotePlugins = map[string]string{
+	"buf.build/protocolbuffers/go":       "go",
+	"buf.build/grpc/go":                  "grpc-go",
+	"buf.build/grpc-ecosystem/gateway":   "grpc-gateway",
+	"buf.build/grpc-ecosystem/openapiv2": "openapiv2",
+	"buf.build/grpc/java":                "grpc-java",
+	"buf.build/grpc/python":              "grpc-python",
+	"buf.build/apple/swift":              "swift",
+	"buf.build/grpc/swift":               "grpc-swift",
+}
+
+// bufRevision matches the plugin revision suffixed to the versions of remote
+// plugins, as in "buf.build/protocolbuffers/go:v1.28.1-1".
+var bufRevision = regexp.MustCompile(`-\d+$`)
+
+// bufGenFile is a buf.gen.yaml file, either of version v1 or v2.
+type bufGenFile struct {
+	Version string         `yaml:"version"`
+	Plugins []bufGenPlugin `yaml:"plugins"`
+}
+
+type bufGenPlugin struct {
+	// v1 keys.
+	Plugin     string      `yaml:"plugin"`
+	Name       string      `yaml:"name"`
+	Path       stringOrSeq `yaml:"path"`
+	ProtocPath string      `yaml:"protoc_path"`
+	// v2 keys.
+	Local         stringOrSeq `yaml:"local"`
+	ProtocBuiltin string      `yaml:"protoc_builtin"`
+	// Keys of both versions.
+	Remote   string      `yaml:"remote"`
+	Out      string      `yaml:"out"`
+	Opt      stringOrSeq `yaml:"opt"`
+	Strategy string      `yaml:"strategy"`
+}
+
+// stringOrSeq is a YAML value which can be a single string or a list of
+// strings, like the opt key of buf.gen.yaml.
+type stringOrSeq []string
+
+func (s *stringOrSeq) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*s = stringOrSeq{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+// addBufGen appends the generators of the buf.gen.yaml file set by the
+// buf_gen key of cfg, loaded from dir, after the ones declared in cfg.
+func addBufGen(cfg *Config, dir string) error {
+	if cfg.BufGen == "" {
+		return nil
+	}
+	gens, err := loadBufGen(dir, cfg.BufGen)
+	if err != nil {
+		return err
+	}
+	cfg.Generators = append(cfg.Generators, gens...)
+	return nil
+}
+
+// loadBufGen reads the generators from the buf.gen.yaml file at path,
+// relative to dir, as set by the buf_gen key of a .gunkconfig. Relative out
+// paths are kept relative to the buf.gen.yaml file, like buf does when run
+// from its directory.
+func loadBufGen(dir, path string) ([]Generator, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f bufGenFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", path, err)
+	}
+	switch f.Version {
+	case "v1", "v1beta1", "v2":
+	default:
+		return nil, fmt.Errorf("unsupported version %q in %s", f.Version, path)
+	}
+	gens := make([]Generator, 0, len(f.Plugins))
+	for i, p := range f.Plugins {
+		gen, err := bufGenerator(p)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %d in %s: %v", i+1, path, err)
+		}
+		if gen.Out != "" && !filepath.IsAbs(gen.Out) {
+			gen.Out = filepath.Join(filepath.Dir(path), gen.Out)
+		}
+		gen.Source = path
+		gens = append(gens, *gen)
+	}
+	return gens, nil
+}
+
+// bufGenerator converts a plugin of a buf.gen.yaml file to a generator.
+func bufGenerator(p bufGenPlugin) (*Generator, error) {
+	if p.Strategy == "all" {
+		return nil, fmt.Errorf("strategy all is not supported, as gunk generates each package on its own")
+	}
+	if p.ProtocPath != "" {
+		return nil, fmt.Errorf("protoc_path is not supported; set the path in the [protoc] section instead")
+	}
+	gen := &Generator{Out: p.Out}
+	for _, opt := range p.Opt {
+		for _, param := range strings.Split(opt, ",") {
+			if param = strings.TrimSpace(param); param == "" {
+				continue
+			}
+			kv := strings.SplitN(param, "=", 2)
+			if len(kv) == 1 {
+				kv = append(kv, "")
+			}
+			gen.Params = append(gen.Params, KeyValue{kv[0], kv[1]})
+		}
+	}
+	name := p.Plugin
+	if name == "" {
+		name = p.Name
+	}
+	// In v1, remote plugins are also set with the plugin key.
+	if p.Remote == "" && strings.Contains(name, "/") {
+		p.Remote, name = name, ""
+	}
+	switch {
+	case p.Remote != "":
+		if err := bufRemoteGenerator(gen, p.Remote); err != nil {
+			return nil, err
+		}
+	case p.ProtocBuiltin != "":
+		gen.ProtocGen = p.ProtocBuiltin
+	case len(p.Local) > 0:
+		if len(p.Local) > 1 {
+			return nil, fmt.Errorf("arguments to local plugin %s are not supported", p.Local[0])
+		}
+		gen.Command = p.Local[0]
+	case name != "":
+		if len(p.Path) > 1 {
+			return nil, fmt.Errorf("arguments to plugin %s are not supported", name)
+		}
+		switch {
+		case len(p.Path) == 1:
+			gen.Command = p.Path[0]
+		case ProtocBuiltinLanguages[name]:
+			gen.ProtocGen = name
+		default:
+			gen.Command = "protoc-gen-" + name
+		}
+	default:
+		return nil, fmt.Errorf("no plugin name")
+	}
+	return gen, nil
+}
+
+// bufRemoteGenerator sets up gen to run the remote plugin ref, such as
+// "buf.build/protocolbuffers/go:v1.28.1". The plugins which gunk can
+// download are pinned to the version, and downloaded like with
+// plugin_version; others are run remotely, like with remote.
+func bufRemoteGenerator(gen *Generator, ref string) error {
+	name, version := ref, ""
+	if i := strings.LastIndex(ref, ":"); i >= 0 {
+		name, version = ref[:i], ref[i+1:]
+	}
+	// Older remote plugins live under a "plugins" path, as in
+	// "buf.build/protocolbuffers/plugins/go".
+	name = strings.Replace(name, "/plugins/", "/", 1)
+	if lang := strings.TrimPrefix(name, "buf.build/protocolbuffers/"); ProtocBuiltinLanguages[lang] {
+		// The version of the generators built into protoc is set by
+		// the [protoc] section.
+		gen.ProtocGen = lang
+		return nil
+	}
+	if code, ok := bufRemotePlugins[name]; ok {
+		gen.Command = "protoc-gen-" + code
+		gen.PluginVersion = bufRevision.ReplaceAllString(version, "")
+		return nil
+	}
+	gen.Remote = name
+	if version != "" {
+		gen.Remote += ":" + version
+	}
+	return checkRemote(gen)
+}
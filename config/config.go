@@ -1,16 +1,24 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"go/token"
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"text/template"
 
+	"github.com/gunk/gunk/workspace"
 	"github.com/kenshaw/ini"
 	"github.com/kenshaw/ini/parser"
+	"golang.org/x/mod/semver"
 )
 
 const (
@@ -18,6 +26,9 @@ const (
 	gitFilename   = ".git"
 )
 
+// ErrNoConfig is returned by Load when no .gunkconfig was found.
+var ErrNoConfig = errors.New("no .gunkconfig found")
+
 type KeyValue struct {
 	Key   string
 	Value string
@@ -27,12 +38,39 @@ type Generator struct {
 	ProtocGen     string // The type of protoc generator that should be run; js, python, etc.
 	Command       string
 	PluginVersion string // we can pin a protoc-gen-XX version
+	VersionRange  string // known-good versions, checked with --check-plugins
 	Params        []KeyValue
 	ConfigDir     string
 	Out           string
 	JSONPostProc  bool
 	FixPaths      bool
-	Shortened     bool // only for `gunk vet`
+	// InProcess runs the go or grpc-go generator within gunk, rather than
+	// as a plugin.
+	InProcess bool
+	// OutRoot and OutRootDir are those of the config, see SetOutRoot.
+	OutRoot    string
+	OutRootDir string
+	Shortened  bool // only for `gunk vet`
+	// FilenameTemplate renames the files written by the generator, see
+	// OutFilename.
+	FilenameTemplate *template.Template
+	// PathMap maps the directories of the files written by a plugin
+	// generator, as slash-separated prefixes of their names, to
+	// directories relative to its output directory, overriding where
+	// they would be written by default.
+	PathMap []KeyValue
+	// Source is the config the generator was declared in, as in
+	// Config.Files, or the buf.gen.yaml file it was read from.
+	Source string
+	// Remote is a remote plugin of the Buf Schema Registry, such as
+	// "buf.build/protocolbuffers/go:v1.31.0", which runs the generator
+	// instead of a local binary. See ParseRemotePlugin.
+	Remote string
+	// section is the name of the section the generator was declared in,
+	// and raw its keys and values, used to merge it with a section of the
+	// same name in a config extending it; see mergeGenerators.
+	section string
+	raw     []KeyValue
 }
 
 func (g Generator) IsProtoc() bool {
@@ -90,8 +128,15 @@ func (g Generator) ParamStringWithOut(packageDir string) string {
 
 // OutPath determines the path for a generator to write generated files to. It
 // will use 'packageDir' if no 'out' key was set in the config.
+//
+// If an out root is set, package directories are mirrored under it instead.
 func (g Generator) OutPath(packageDir string) string {
 	if g.Out == "" {
+		if g.OutRoot != "" && packageDir != "" {
+			if rel, err := filepath.Rel(g.OutRootDir, packageDir); err == nil && !isOutside(rel) {
+				return filepath.Join(g.OutRoot, rel)
+			}
+		}
 		return packageDir
 	}
 	if filepath.IsAbs(g.Out) {
@@ -100,20 +145,252 @@ func (g Generator) OutPath(packageDir string) string {
 	return filepath.Join(g.ConfigDir, g.Out)
 }
 
+// FilenameData is the data passed to a generator's filename_template.
+type FilenameData struct {
+	Name string // original file name, e.g. "all.pb.go"
+	Base string // file name up to the first dot, e.g. "all"
+	Ext  string // file name from the first dot, e.g. ".pb.go"
+}
+
+// OutFilename returns the name to write a generated file as, given its
+// original base name. If no filename_template was set in the config, the
+// name is returned unchanged.
+func (g Generator) OutFilename(name string) (string, error) {
+	if g.FilenameTemplate == nil {
+		return name, nil
+	}
+	data := FilenameData{Name: name, Base: name}
+	if i := strings.Index(name, "."); i > 0 {
+		data.Base, data.Ext = name[:i], name[i:]
+	}
+	var sb strings.Builder
+	if err := g.FilenameTemplate.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("cannot execute filename_template: %w", err)
+	}
+	out := sb.String()
+	if out == "" || strings.ContainsAny(out, `/\`) {
+		return "", fmt.Errorf("filename_template produced invalid file name %q", out)
+	}
+	return out, nil
+}
+
 type Config struct {
 	Dir           string
+	Extend        string // reference to a shared config to extend, see loadExtended
+	BufGen        string // buf.gen.yaml file to read more generators from, see loadBufGen
 	Out           string
 	ImportPath    string
 	ProtocPath    string
 	ProtocVersion string
-	Generators    []Generator
+	// RequirePinnedVersions makes generation fail if protoc or any
+	// plugin isn't pinned to a version. It applies if set in any of the
+	// merged configs, so that it can be enforced by a shared config.
+	RequirePinnedVersions bool
+	// GoModulePath is the path of a separate Go module to generate Go code
+	// into, and GoModuleDir is the directory of the .gunkconfig setting it.
+	// Gunk packages under GoModuleDir are mapped to the same relative
+	// package paths under GoModulePath.
+	GoModulePath string
+	GoModuleDir  string
+	// OutRoot is the directory to write all the generated files under,
+	// and OutRootDir is the directory of the .gunkconfig setting it. Gunk
+	// packages under OutRootDir are generated into the same relative
+	// directories under OutRoot, unless their generators set out.
+	OutRoot    string
+	OutRootDir string
+	// GoModule configures the extra files written to make the output of
+	// go_module_path a complete Go module. Nil if there is no [go_module]
+	// section.
+	GoModule *GoModule
+	// Release configures `gunk release`. Nil if there is no [release]
+	// section.
+	Release *Release
+	// Backstage configures the Backstage catalog entities written for
+	// each generated package. Nil if there is no [backstage] section.
+	Backstage *Backstage
+	// Sign configures the detached signatures written for generated
+	// files, so that their consumers can verify them with `gunk verify`.
+	// Nil if there is no [sign] section.
+	Sign *Sign
+	// Vet sets the severity of the rules of `gunk vet` by name, from the
+	// [vet] section: "error", "warning" or "off". Rules not listed keep
+	// their default.
+	Vet map[string]string
+	// VetMaturity is the maturity of the Gunk packages, from the [vet]
+	// section: "experimental", "beta" or "stable". It sets the default
+	// severity of the documentation rules of `gunk vet`.
+	VetMaturity string
+	// Terminology maps terms to their preferred spelling, from the
+	// [vet terminology] section, for the terminology rule of `gunk vet`.
+	// Terms are lowercase, and an empty spelling bans the term.
+	Terminology map[string]string
+	// HTTPVerbs maps method name prefixes, such as "get" or "create", to
+	// the HTTP methods their http.Match bindings may use, from the
+	// [vet verbs] section, for the http_verbs rule of `gunk vet`.
+	HTTPVerbs map[string][]string
+	// VetLimits holds the limits of the limits rule of `gunk vet`, from
+	// the [vet limits] section, such as "max_fields".
+	VetLimits map[string]int
+	// VetDependencies maps patterns of Gunk package paths, such as
+	// ".../public/...", to the patterns of the packages they must not
+	// import, directly or not, from the [vet dependencies] section, for
+	// the dependencies rule of `gunk vet`.
+	VetDependencies map[string][]string
+	// Types maps named Go types, written as their import path and name
+	// such as "github.com/google/uuid.UUID", to the proto types they are
+	// translated to, from the [types] section. This allows using Go types
+	// which aren't declared in Gunk packages.
+	Types map[string]TypeMapping
+	// ProtoDeps are the third-party proto files to vendor with
+	// `gunk vendor`, from the [proto_dep <name>] sections, pinned in the
+	// gunk.lock file next to the .gunkconfig declaring them.
+	ProtoDeps  []ProtoDep
+	Generators []Generator
+	// Files are the merged configs, most specific first: the paths of
+	// the .gunkconfig files, and the references of the extended configs.
+	Files []string
+	// Sources maps the keys set in the merged configs to the config
+	// which set them, as in Files. Keys of sections other than the global
+	// one are prefixed with the section name, as in "protoc.version" or
+	// "vet limits.max_fields"; the [go_module], [release], [backstage],
+	// [sign] and [proto_dep <name>] sections are set as a whole.
+	Sources map[string]string
+	// Warnings are the problems found in the merged configs which don't
+	// stop them from being used, such as deprecated keys, each prefixed
+	// with the config it was found in.
+	Warnings []string
+}
+
+// TypeMapping is the proto type a named Go type is translated to, from the
+// [types] section of a .gunkconfig.
+type TypeMapping struct {
+	// Type is a proto scalar type, such as "string", or the full name of
+	// a message, such as "google.type.Money".
+	Type string
+	// File is the proto file declaring the message, such as
+	// "google/type/money.proto". It is empty for scalar types.
+	File string
+}
+
+// ProtoScalarTypes are the scalar types of proto3, which named Go types can be
+// mapped to in the [types] section of a .gunkconfig.
+var ProtoScalarTypes = []string{
+	"double", "float", "int32", "int64", "uint32", "uint64",
+	"sint32", "sint64", "fixed32", "fixed64", "sfixed32", "sfixed64",
+	"bool", "string", "bytes",
+}
+
+// Release is the [release] section of a .gunkconfig.
+type Release struct {
+	TagPrefix string // prefix for the release tags, e.g. "api/"
+}
+
+// Backstage is the [backstage] section of a .gunkconfig.
+type Backstage struct {
+	Owner     string // owner of the API entities, e.g. "team-a"
+	Lifecycle string // lifecycle of the API entities, e.g. "production"
+	System    string // system the API entities belong to, if any
+	// DescriptorSet is the name of a file to write the FileDescriptorSet
+	// of each package to, in its directory, if any.
+	DescriptorSet string
+}
+
+// The values of the method key of the [sign] section.
+const (
+	SignGPG      = "gpg"
+	SignSigstore = "sigstore"
+)
+
+// Sign is the [sign] section of a .gunkconfig.
+type Sign struct {
+	Method string // SignGPG, the default, or SignSigstore
+	// Key is the GPG key to sign with, such as an email or a key ID, or
+	// the cosign key reference, such as a path or a KMS URI. If empty,
+	// GPG uses its default key, and sigstore signs keylessly.
+	Key string
+	// Files are the patterns of the base names of the generated files
+	// to sign, such as "*.swagger.json".
+	Files []string
+}
+
+// ProtoDep is a [proto_dep <name>] section of a .gunkconfig.
+type ProtoDep struct {
+	Name string // name of the dependency, e.g. "googleapis"
+	// URL is the http(s) URL of a .zip, .tar.gz or .tgz archive of proto
+	// files, or its local path, relative to Dir.
+	URL string
+	// Root is the directory of the archive to use as the include path.
+	// If empty, it is the single top-level directory of the archive, if
+	// it has one, or else the archive root.
+	Root string
+	Dir  string // directory of the .gunkconfig with the section
+}
+
+// GoModule is the [go_module] section of a .gunkconfig.
+type GoModule struct {
+	Dir       string // directory of the .gunkconfig with the section
+	GoVersion string // go directive for a new go.mod, e.g. "1.16"
+	Version   string // version written to version.go, if any
+	License   string // license file to copy to the module root, if any
+	Doc       bool   // whether to write a doc.go for each package
+}
+
+// GoPackagePath returns the Go import path of the code generated for the
+// Gunk package in dir, when go_module_path is set. It returns false if
+// go_module_path isn't set.
+func (c *Config) GoPackagePath(dir string) (string, bool, error) {
+	if c.GoModulePath == "" {
+		return "", false, nil
+	}
+	rel, err := filepath.Rel(c.GoModuleDir, dir)
+	if err != nil {
+		return "", false, err
+	}
+	if isOutside(rel) {
+		return "", false, fmt.Errorf("%s is outside of the go_module_path root %s", dir, c.GoModuleDir)
+	}
+	return path.Join(c.GoModulePath, filepath.ToSlash(rel)), true, nil
+}
+
+// SetOutRoot sets the out root of the config and its generators to root,
+// mirroring the Gunk packages under dir. Both must be absolute paths.
+func (c *Config) SetOutRoot(root, dir string) {
+	c.OutRoot, c.OutRootDir = root, dir
+	for i := range c.Generators {
+		c.Generators[i].OutRoot = root
+		c.Generators[i].OutRootDir = dir
+	}
+}
+
+// CheckOutRoot returns an error if an out root is set, but the Gunk package
+// in dir is not under the directory it mirrors.
+func (c *Config) CheckOutRoot(dir string) error {
+	if c.OutRoot == "" {
+		return nil
+	}
+	rel, err := filepath.Rel(c.OutRootDir, dir)
+	if err != nil {
+		return err
+	}
+	if isOutside(rel) {
+		return fmt.Errorf("%s is outside of %s, which out_root %s mirrors", dir, c.OutRootDir, c.OutRoot)
+	}
+	return nil
+}
+
+// isOutside reports whether the relative path rel, as returned by
+// filepath.Rel, leaves its base directory.
+func isOutside(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	return rel == ".." || strings.HasPrefix(rel, "../")
 }
 
 // Load will attempt to find the .gunkconfig in the 'dir', working
 // its way up to each parent looking for a .gunkconfig. Currently,
 // Load will only stop when it is unable to go any further up the
 // directory structure or until it finds a 'go.mod' file, or a
-// '.git' file or folder.
+// '.git' file or folder. If 'dir' is within a module of a workspace, the
+// .gunkconfig next to its gunk.work file is loaded last.
 //
 // Passing in an empty 'dir' will tell Load to look in the current
 // working directory.
@@ -126,27 +403,22 @@ func Load(dir string) (*Config, error) {
 		}
 	}
 	cfgs := []*Config{}
+	pkgDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	// Directories searched, to only load the workspace config if it
+	// wasn't found already.
+	visited := make(map[string]bool)
 	for {
-		configPath := filepath.Join(dir, ".gunkconfig")
-		reader, err := os.Open(configPath)
-		if err == nil {
-			defer reader.Close()
-			cfg, err := LoadSingle(reader)
-			if err != nil {
-				return nil, fmt.Errorf("error loading %q: %v", configPath, err)
-			}
-			cfg.Dir = dir
-			// Patch in the directory of where to output the generated
-			// files. And patch in the 'out' path if it has been set globally,
-			// and not in the generate section.
-			for i, gen := range cfg.Generators {
-				cfg.Generators[i].ConfigDir = dir
-				if cfg.Out != "" && gen.Out == "" {
-					cfg.Generators[i].Out = cfg.Out
-				}
-			}
-			cfgs = append(cfgs, cfg)
+		if abs, err := filepath.Abs(dir); err == nil {
+			visited[abs] = true
+		}
+		dirCfgs, err := loadDir(dir)
+		if err != nil {
+			return nil, err
 		}
+		cfgs = append(cfgs, dirCfgs...)
 		// Check to see if this directory contains a 'go.mod' file or '.git'
 		// file or folder. If so, we assume that is the root of the project
 		// and we have found all the gunk configs.
@@ -173,9 +445,22 @@ func Load(dir string) (*Config, error) {
 			break
 		}
 	}
+	// The config at the root of a workspace applies to all its modules,
+	// even though the search stops at their go.mod files.
+	ws, err := workspace.Find(pkgDir)
+	if err != nil {
+		return nil, err
+	}
+	if ws != nil && !visited[ws.Dir] && ws.Contains(pkgDir) {
+		wsCfgs, err := loadDir(ws.Dir)
+		if err != nil {
+			return nil, err
+		}
+		cfgs = append(cfgs, wsCfgs...)
+	}
 	// If no configs were found, return an error.
 	if len(cfgs) == 0 {
-		return nil, fmt.Errorf("no .gunkconfig found")
+		return nil, ErrNoConfig
 	}
 	// Merge the found configs.
 	config := cfgs[0]
@@ -190,11 +475,236 @@ func Load(dir string) (*Config, error) {
 		if protocPath := c.ProtocPath; config.ProtocPath == "" {
 			config.ProtocPath = protocPath
 		}
+		if config.GoModulePath == "" {
+			config.GoModulePath = c.GoModulePath
+			config.GoModuleDir = c.GoModuleDir
+		}
+		if config.OutRoot == "" {
+			config.OutRoot = c.OutRoot
+			config.OutRootDir = c.OutRootDir
+		}
+		if c.RequirePinnedVersions {
+			config.RequirePinnedVersions = true
+		}
+		if config.GoModule == nil {
+			config.GoModule = c.GoModule
+		}
+		if config.Release == nil {
+			config.Release = c.Release
+		}
+		if config.Backstage == nil {
+			config.Backstage = c.Backstage
+		}
+		if config.Sign == nil {
+			config.Sign = c.Sign
+		}
+		for rule, severity := range c.Vet {
+			if _, ok := config.Vet[rule]; ok {
+				continue
+			}
+			if config.Vet == nil {
+				config.Vet = make(map[string]string)
+			}
+			config.Vet[rule] = severity
+		}
+		if config.VetMaturity == "" {
+			config.VetMaturity = c.VetMaturity
+		}
+		for term, spelling := range c.Terminology {
+			if _, ok := config.Terminology[term]; ok {
+				continue
+			}
+			if config.Terminology == nil {
+				config.Terminology = make(map[string]string)
+			}
+			config.Terminology[term] = spelling
+		}
+		for limit, max := range c.VetLimits {
+			if _, ok := config.VetLimits[limit]; ok {
+				continue
+			}
+			if config.VetLimits == nil {
+				config.VetLimits = make(map[string]int)
+			}
+			config.VetLimits[limit] = max
+		}
+		for prefix, verbs := range c.HTTPVerbs {
+			if _, ok := config.HTTPVerbs[prefix]; ok {
+				continue
+			}
+			if config.HTTPVerbs == nil {
+				config.HTTPVerbs = make(map[string][]string)
+			}
+			config.HTTPVerbs[prefix] = verbs
+		}
+		for pattern, forbidden := range c.VetDependencies {
+			if _, ok := config.VetDependencies[pattern]; ok {
+				continue
+			}
+			if config.VetDependencies == nil {
+				config.VetDependencies = make(map[string][]string)
+			}
+			config.VetDependencies[pattern] = forbidden
+		}
+		for name, mapping := range c.Types {
+			if _, ok := config.Types[name]; ok {
+				continue
+			}
+			if config.Types == nil {
+				config.Types = make(map[string]TypeMapping)
+			}
+			config.Types[name] = mapping
+		}
+		for _, dep := range c.ProtoDeps {
+			if config.protoDep(dep.Name) == nil {
+				config.ProtoDeps = append(config.ProtoDeps, dep)
+			}
+		}
 		config.Generators = append(config.Generators, c.Generators...)
+		config.Files = append(config.Files, c.Files...)
+		// The merged values are those of the first config setting
+		// them, and so are their sources.
+		for key, source := range c.Sources {
+			if _, ok := config.Sources[key]; !ok {
+				config.Sources[key] = source
+			}
+		}
+		config.Warnings = append(config.Warnings, c.Warnings...)
+	}
+	if config.OutRoot != "" {
+		config.SetOutRoot(config.OutRoot, config.OutRootDir)
 	}
 	return config, nil
 }
 
+// loadDir loads the .gunkconfig in dir, if any, followed by the configs it
+// extends.
+func loadDir(dir string) ([]*Config, error) {
+	configPath := filepath.Join(dir, ".gunkconfig")
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, nil
+	}
+	cfg, err := LoadSingle(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error loading %q: %v", configPath, err)
+	}
+	var extended []*Config
+	if cfg.Extend != "" {
+		// Relative references are resolved against the config's
+		// absolute path, which tells them apart from modules.
+		from, err := filepath.Abs(configPath)
+		if err != nil {
+			return nil, err
+		}
+		extended, err = loadExtended(dir, from, cfg.Extend, map[string]bool{})
+		if err != nil {
+			return nil, fmt.Errorf("error loading %q: %v", configPath, err)
+		}
+		if err := mergeGenerators(cfg, extended); err != nil {
+			return nil, fmt.Errorf("error loading %q: %v", configPath, err)
+		}
+	}
+	if err := addBufGen(cfg, dir); err != nil {
+		return nil, fmt.Errorf("error loading %q: %v", configPath, err)
+	}
+	patchConfig(cfg, dir)
+	cfg.setFile(configPath)
+	return append([]*Config{cfg}, extended...), nil
+}
+
+// setFile records that cfg was loaded from file, prefixing its warnings with
+// it, and recording it as the source of its keys and generators.
+func (c *Config) setFile(file string) {
+	c.Files = []string{file}
+	for i, w := range c.Warnings {
+		c.Warnings[i] = file + ": " + w
+	}
+	c.Sources = make(map[string]string)
+	set := func(key string, ok bool) {
+		if ok {
+			c.Sources[key] = file
+		}
+	}
+	set("import_path", c.ImportPath != "")
+	set("go_module_path", c.GoModulePath != "")
+	set("out_root", c.OutRoot != "")
+	set("require_pinned_versions", c.RequirePinnedVersions)
+	set("protoc.path", c.ProtocPath != "")
+	set("protoc.version", c.ProtocVersion != "")
+	set("go_module", c.GoModule != nil)
+	set("release", c.Release != nil)
+	set("backstage", c.Backstage != nil)
+	set("sign", c.Sign != nil)
+	set("vet.maturity", c.VetMaturity != "")
+	for rule := range c.Vet {
+		set("vet."+rule, true)
+	}
+	for term := range c.Terminology {
+		set("vet terminology."+term, true)
+	}
+	for prefix := range c.HTTPVerbs {
+		set("vet verbs."+prefix, true)
+	}
+	for limit := range c.VetLimits {
+		set("vet limits."+limit, true)
+	}
+	for pattern := range c.VetDependencies {
+		set("vet dependencies."+pattern, true)
+	}
+	for name := range c.Types {
+		set("types."+name, true)
+	}
+	for _, dep := range c.ProtoDeps {
+		set("proto_dep "+dep.Name, true)
+	}
+	for i, gen := range c.Generators {
+		if gen.Source == "" {
+			c.Generators[i].Source = file
+		}
+	}
+}
+
+// patchConfig fills in the fields of a config which depend on the directory
+// of the .gunkconfig it was loaded from.
+func patchConfig(cfg *Config, dir string) {
+	cfg.Dir = dir
+	if cfg.GoModulePath != "" {
+		cfg.GoModuleDir = dir
+	}
+	if cfg.OutRoot != "" {
+		cfg.OutRootDir = dir
+		if !filepath.IsAbs(cfg.OutRoot) {
+			cfg.OutRoot = filepath.Join(dir, cfg.OutRoot)
+		}
+	}
+	if m := cfg.GoModule; m != nil {
+		m.Dir = dir
+		if m.License != "" && !filepath.IsAbs(m.License) {
+			m.License = filepath.Join(dir, m.License)
+		}
+	}
+	if s := cfg.Sign; s != nil && s.Method == SignSigstore && s.Key != "" {
+		// Cosign keys are paths relative to the .gunkconfig, unless
+		// they are URIs such as awskms://.
+		if !strings.Contains(s.Key, "://") && !filepath.IsAbs(s.Key) {
+			s.Key = filepath.Join(dir, s.Key)
+		}
+	}
+	for i := range cfg.ProtoDeps {
+		cfg.ProtoDeps[i].Dir = dir
+	}
+	// Patch in the directory of where to output the generated
+	// files. And patch in the 'out' path if it has been set globally,
+	// and not in the generate section.
+	for i, gen := range cfg.Generators {
+		cfg.Generators[i].ConfigDir = dir
+		if cfg.Out != "" && gen.Out == "" {
+			cfg.Generators[i].Out = cfg.Out
+		}
+	}
+}
+
 // from https://github.com/protocolbuffers/protobuf/blob/master/src/google/protobuf/compiler/main.cc
 // hardcode what languages are built-in in protoc, rest must have their own generator binary
 var ProtocBuiltinLanguages = map[string]bool{
@@ -208,6 +718,57 @@ var ProtocBuiltinLanguages = map[string]bool{
 	"js":     true,
 }
 
+// protocBuiltinChanges lists the protoc versions in which generators were
+// added to or removed from protoc itself, for the languages which aren't
+// built into every version.
+var protocBuiltinChanges = map[string]struct{ added, removed string }{
+	"php":    {added: "v3.3.0"},
+	"kotlin": {added: "v3.17.0"},
+	"pyi":    {added: "v3.20.0"},
+	"js":     {removed: "v3.21.0"},
+}
+
+// ProtocBuiltins returns the sorted names of the generators built into a
+// version of protoc, such as "v3.9.1". Versions like "v21.0" are treated as
+// "v3.21.0", matching what protoc reports for them.
+func ProtocBuiltins(version string) []string {
+	version = canonicalProtocVersion(version)
+	var langs []string
+	for lang := range ProtocBuiltinLanguages {
+		langs = append(langs, lang)
+	}
+	for lang := range protocBuiltinChanges {
+		if !ProtocBuiltinLanguages[lang] {
+			langs = append(langs, lang)
+		}
+	}
+	var builtins []string
+	for _, lang := range langs {
+		if c, ok := protocBuiltinChanges[lang]; ok && semver.IsValid(version) {
+			if c.added != "" && semver.Compare(version, c.added) < 0 {
+				continue
+			}
+			if c.removed != "" && semver.Compare(version, c.removed) >= 0 {
+				continue
+			}
+		}
+		builtins = append(builtins, lang)
+	}
+	sort.Strings(builtins)
+	return builtins
+}
+
+func canonicalProtocVersion(version string) string {
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	if major := semver.Major(version); major != "" && major != "v3" {
+		// Since v21.0, protoc releases drop the "3." prefix.
+		version = "v3." + strings.TrimPrefix(version, "v")
+	}
+	return version
+}
+
 func LoadSingle(reader io.Reader) (*Config, error) {
 	f, err := ini.Load(reader)
 	if err != nil {
@@ -229,30 +790,36 @@ func LoadSingle(reader io.Reader) (*Config, error) {
 			continue
 		case name == "protoc":
 			err = handleProtoc(config, s)
-		case name == "generate":
-			gen, err = handleGenerate(s)
+		case name == "go_module":
+			err = handleGoModule(config, s)
+		case name == "release":
+			err = handleRelease(config, s)
+		case name == "backstage":
+			err = handleBackstage(config, s)
+		case name == "sign":
+			err = handleSign(config, s)
+		case name == "vet":
+			err = handleVet(config, s)
+		case name == "vet terminology":
+			err = handleTerminology(config, s)
+		case name == "vet verbs":
+			err = handleVerbs(config, s)
+		case name == "vet limits":
+			err = handleLimits(config, s)
+		case name == "vet dependencies":
+			err = handleDependencies(config, s)
+		case name == "types":
+			err = handleTypes(config, s)
+		case strings.HasPrefix(name, "proto_dep "):
+			err = handleProtoDep(config, s)
 		case strings.HasPrefix(name, "generate"):
-			// Check to see if we have the shorten version of a generate config:
-			// [generate js].
-			sParts := strings.Split(name, " ")
-			if len(sParts) != 2 {
-				return nil, fmt.Errorf("generate section name should have 2 values, not %d", len(sParts))
-			}
-			gen, err = handleGenerate(s)
-			generator := strings.Trim(sParts[1], "\"")
-			// Is this shortened generator a protoc-gen-* binary, or
-			// should it be passed to protoc.
-			// We ignore the binary path since we don't do the same for the
-			// normal generate section. If we start using the binary path here
-			// we should also use it for the normal generate section.
-			if !ProtocBuiltinLanguages[generator] {
-				gen.Command = "protoc-gen-" + generator
-			} else {
-				gen.ProtocGen = generator
-			}
-			gen.Shortened = true // for vetting
+			var raw []KeyValue
+			for _, k := range s.RawKeys() {
+				raw = append(raw, KeyValue{Key: k, Value: s.GetRaw(k)})
+			}
+			gen, err = handleGenerate(config, name, raw)
 		default:
-			return nil, fmt.Errorf("unknown section %q", s.Name())
+			return nil, fmt.Errorf("unknown section %q%s", s.Name(), sectionSuggestion(name))
 		}
 		if err != nil {
 			return nil, err
@@ -273,20 +840,344 @@ func handleProtoc(config *Config, section *parser.Section) error {
 		case "version":
 			config.ProtocVersion = v
 		default:
-			return fmt.Errorf("unexpected key %q in protoc section", k)
+			return fmt.Errorf("unexpected key %q in protoc section%s", k, DidYouMean(k, protocKeys))
 		}
 	}
 	return nil
 }
 
-func handleGenerate(section *parser.Section) (*Generator, error) {
-	keys := section.RawKeys()
-	gen := &Generator{
-		Params: make([]KeyValue, 0, len(keys)),
+// protoDep returns the proto dependency named name, or nil.
+func (c *Config) protoDep(name string) *ProtoDep {
+	for i := range c.ProtoDeps {
+		if c.ProtoDeps[i].Name == name {
+			return &c.ProtoDeps[i]
+		}
 	}
-	for _, k := range keys {
+	return nil
+}
+
+func handleProtoDep(config *Config, section *parser.Section) error {
+	name := strings.Trim(strings.TrimSpace(strings.TrimPrefix(section.Name(), "proto_dep ")), "\"")
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("invalid proto_dep name %q", name)
+	}
+	if config.protoDep(name) != nil {
+		return fmt.Errorf("duplicate proto_dep %q", name)
+	}
+	dep := ProtoDep{Name: name}
+	for _, k := range section.RawKeys() {
 		v := strings.TrimSpace(section.GetRaw(k))
 		switch k {
+		case "url":
+			dep.URL = v
+		case "root":
+			dep.Root = filepath.ToSlash(filepath.Clean(v))
+			if filepath.IsAbs(v) || isOutside(dep.Root) {
+				return fmt.Errorf("root of proto_dep %q must be a directory within its archive, not %q", name, v)
+			}
+		default:
+			return fmt.Errorf("unexpected key %q in proto_dep section%s", k, DidYouMean(k, protoDepKeys))
+		}
+	}
+	if dep.URL == "" {
+		return fmt.Errorf("proto_dep %q requires a url", name)
+	}
+	config.ProtoDeps = append(config.ProtoDeps, dep)
+	return nil
+}
+
+func handleGoModule(config *Config, section *parser.Section) error {
+	config.GoModule = &GoModule{GoVersion: "1.16"}
+	for _, k := range section.RawKeys() {
+		v := strings.TrimSpace(section.GetRaw(k))
+		switch k {
+		case "go":
+			config.GoModule.GoVersion = v
+		case "version":
+			config.GoModule.Version = v
+		case "license":
+			config.GoModule.License = v
+		case "doc":
+			p, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("cannot parse doc: %w", err)
+			}
+			config.GoModule.Doc = p
+		default:
+			return fmt.Errorf("unexpected key %q in go_module section%s", k, DidYouMean(k, goModuleKeys))
+		}
+	}
+	return nil
+}
+
+func handleRelease(config *Config, section *parser.Section) error {
+	config.Release = &Release{}
+	for _, k := range section.RawKeys() {
+		v := strings.TrimSpace(section.GetRaw(k))
+		switch k {
+		case "tag_prefix":
+			config.Release.TagPrefix = v
+		default:
+			return fmt.Errorf("unexpected key %q in release section%s", k, DidYouMean(k, releaseKeys))
+		}
+	}
+	return nil
+}
+
+func handleBackstage(config *Config, section *parser.Section) error {
+	config.Backstage = &Backstage{Lifecycle: "production"}
+	for _, k := range section.RawKeys() {
+		v := strings.TrimSpace(section.GetRaw(k))
+		switch k {
+		case "owner":
+			config.Backstage.Owner = v
+		case "lifecycle":
+			config.Backstage.Lifecycle = v
+		case "system":
+			config.Backstage.System = v
+		case "descriptor_set":
+			if v == "" || strings.ContainsAny(v, `/\`) {
+				return fmt.Errorf("descriptor_set must be a file name, not %q", v)
+			}
+			config.Backstage.DescriptorSet = v
+		default:
+			return fmt.Errorf("unexpected key %q in backstage section%s", k, DidYouMean(k, backstageKeys))
+		}
+	}
+	// Backstage requires an owner for each API entity.
+	if config.Backstage.Owner == "" {
+		return fmt.Errorf("backstage section requires an owner")
+	}
+	return nil
+}
+
+func handleSign(config *Config, section *parser.Section) error {
+	config.Sign = &Sign{Method: SignGPG}
+	for _, k := range section.RawKeys() {
+		v := strings.TrimSpace(section.GetRaw(k))
+		switch k {
+		case "method":
+			switch v {
+			case SignGPG, SignSigstore:
+			default:
+				return fmt.Errorf("invalid sign method %q, must be %s or %s%s", v, SignGPG, SignSigstore, DidYouMean(v, []string{SignGPG, SignSigstore}))
+			}
+			config.Sign.Method = v
+		case "key":
+			config.Sign.Key = v
+		case "files":
+			for _, pattern := range strings.Split(v, ",") {
+				if pattern = strings.TrimSpace(pattern); pattern == "" {
+					continue
+				}
+				if _, err := path.Match(pattern, ""); err != nil {
+					return fmt.Errorf("invalid sign files pattern %q: %v", pattern, err)
+				}
+				config.Sign.Files = append(config.Sign.Files, pattern)
+			}
+		default:
+			return fmt.Errorf("unexpected key %q in sign section%s", k, DidYouMean(k, signKeys))
+		}
+	}
+	if len(config.Sign.Files) == 0 {
+		return fmt.Errorf("sign section requires files to sign")
+	}
+	return nil
+}
+
+func handleVet(config *Config, section *parser.Section) error {
+	config.Vet = make(map[string]string)
+	for _, k := range section.RawKeys() {
+		v := strings.TrimSpace(section.GetRaw(k))
+		if k == "maturity" {
+			switch v {
+			case "experimental", "beta", "stable":
+			default:
+				return fmt.Errorf("unknown vet maturity %q; must be experimental, beta or stable", v)
+			}
+			config.VetMaturity = v
+			continue
+		}
+		switch v {
+		case "error", "warning", "off":
+		default:
+			// true and false enable and disable the rule.
+			on, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("cannot parse vet rule %s: must be error, warning, off or a boolean", k)
+			}
+			v = "off"
+			if on {
+				v = "error"
+			}
+		}
+		config.Vet[k] = v
+	}
+	return nil
+}
+
+// SetGoModuleVersion rewrites the version key of the [go_module] section in
+// the .gunkconfig file at path, keeping the rest of the file as is.
+func SetGoModuleVersion(path, version string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(data), "\n")
+	section, found := "", false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			section = strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			continue
+		}
+		if section != "go_module" {
+			continue
+		}
+		if kv := strings.SplitN(trimmed, "=", 2); len(kv) == 2 && strings.TrimSpace(kv[0]) == "version" {
+			lines[i] = "version=" + version
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no version in the go_module section of %s", path)
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644)
+}
+
+func handleTerminology(config *Config, section *parser.Section) error {
+	config.Terminology = make(map[string]string)
+	for _, k := range section.RawKeys() {
+		config.Terminology[k] = strings.TrimSpace(section.GetRaw(k))
+	}
+	return nil
+}
+
+func handleVerbs(config *Config, section *parser.Section) error {
+	config.HTTPVerbs = make(map[string][]string)
+	for _, k := range section.RawKeys() {
+		var verbs []string
+		for _, v := range strings.Split(section.GetRaw(k), ",") {
+			if v = strings.ToUpper(strings.TrimSpace(v)); v != "" {
+				verbs = append(verbs, v)
+			}
+		}
+		if len(verbs) == 0 {
+			return fmt.Errorf("no HTTP methods for vet verbs prefix %s", k)
+		}
+		config.HTTPVerbs[k] = verbs
+	}
+	return nil
+}
+
+// vetLimitNames are the limits which can be set in the [vet limits] section.
+var vetLimitNames = []string{"max_fields", "max_depth", "max_enum_values", "max_methods"}
+
+func handleLimits(config *Config, section *parser.Section) error {
+	config.VetLimits = make(map[string]int)
+	for _, k := range section.RawKeys() {
+		known := false
+		for _, name := range vetLimitNames {
+			known = known || k == name
+		}
+		if !known {
+			return fmt.Errorf("unknown vet limit %q%s", k, DidYouMean(k, vetLimitNames))
+		}
+		max, err := strconv.Atoi(strings.TrimSpace(section.GetRaw(k)))
+		if err != nil || max < 0 {
+			return fmt.Errorf("vet limit %s must be a number, or 0 for no limit", k)
+		}
+		config.VetLimits[k] = max
+	}
+	return nil
+}
+
+func handleDependencies(config *Config, section *parser.Section) error {
+	config.VetDependencies = make(map[string][]string)
+	for _, k := range section.RawKeys() {
+		var forbidden []string
+		for _, v := range strings.Split(section.GetRaw(k), ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				forbidden = append(forbidden, v)
+			}
+		}
+		if len(forbidden) == 0 {
+			return fmt.Errorf("no forbidden imports for vet dependencies pattern %s", k)
+		}
+		config.VetDependencies[k] = forbidden
+	}
+	return nil
+}
+
+func handleTypes(config *Config, section *parser.Section) error {
+	config.Types = make(map[string]TypeMapping)
+	for _, raw := range section.RawKeys() {
+		k := strings.TrimSpace(raw)
+		i := strings.LastIndex(k, ".")
+		if i <= 0 || i == len(k)-1 || !token.IsIdentifier(k[i+1:]) {
+			return fmt.Errorf("invalid type %q in types section, must be an import path and a type name, such as github.com/google/uuid.UUID", k)
+		}
+		fields := strings.Fields(section.GetRaw(raw))
+		var mapping TypeMapping
+		switch len(fields) {
+		case 1:
+			mapping.Type = fields[0]
+			scalar := false
+			for _, t := range ProtoScalarTypes {
+				scalar = scalar || t == mapping.Type
+			}
+			if !scalar {
+				return fmt.Errorf("type %s is mapped to %q, which is not a proto scalar type; messages must be followed by their proto file%s", k, mapping.Type, DidYouMean(mapping.Type, ProtoScalarTypes))
+			}
+		case 2:
+			mapping.Type, mapping.File = fields[0], fields[1]
+			if !isFullName(mapping.Type) || !strings.HasSuffix(mapping.File, ".proto") {
+				return fmt.Errorf("type %s must be mapped to a message and its proto file, such as google.type.Money google/type/money.proto", k)
+			}
+		default:
+			return fmt.Errorf("type %s must be mapped to a proto scalar type, or to a message and its proto file", k)
+		}
+		config.Types[k] = mapping
+	}
+	return nil
+}
+
+// isFullName reports whether name is the full name of a proto message, such
+// as "google.type.Money".
+func isFullName(name string) bool {
+	for _, part := range strings.Split(name, ".") {
+		if !token.IsIdentifier(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// handleGenerate parses a generate section, given its name and its raw keys
+// and values.
+func handleGenerate(config *Config, name string, raw []KeyValue) (*Generator, error) {
+	// Check to see if we have the shorten version of a generate config:
+	// [generate js].
+	generator := ""
+	if name != "generate" {
+		sParts := strings.Split(name, " ")
+		if len(sParts) != 2 {
+			return nil, fmt.Errorf("generate section name should have 2 values, not %d", len(sParts))
+		}
+		generator = strings.Trim(sParts[1], "\"")
+	}
+	gen := &Generator{
+		Params:  make([]KeyValue, 0, len(raw)),
+		section: name,
+		raw:     raw,
+	}
+	for _, kv := range raw {
+		k, v := kv.Key, strings.TrimSpace(kv.Value)
+		if renamed, ok := renamedGenerateKeys[k]; ok {
+			config.Warnings = append(config.Warnings, fmt.Sprintf("[%s] %s is deprecated, use %s instead", name, k, renamed))
+			k = renamed
+		}
+		switch k {
 		case "command":
 			if gen.ProtocGen != "" {
 				return nil, fmt.Errorf("only one 'command' or 'protoc' allowed")
@@ -301,6 +1192,8 @@ func handleGenerate(section *parser.Section) (*Generator, error) {
 			gen.PluginVersion = v
 		case "out":
 			gen.Out = v
+		case "version_range":
+			gen.VersionRange = v
 		case "fix_paths_postproc":
 			p, err := strconv.ParseBool(v)
 			if err != nil {
@@ -313,13 +1206,80 @@ func handleGenerate(section *parser.Section) (*Generator, error) {
 				return nil, fmt.Errorf("cannot parse json_tag_postproc: %w", err)
 			}
 			gen.JSONPostProc = p
+		case "in_process":
+			p, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse in_process: %w", err)
+			}
+			gen.InProcess = p
+		case "filename_template":
+			t, err := template.New("filename_template").Option("missingkey=error").Parse(v)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse filename_template: %w", err)
+			}
+			gen.FilenameTemplate = t
+		case "path_map":
+			pathMap, err := parsePathMap(v)
+			if err != nil {
+				return nil, err
+			}
+			gen.PathMap = pathMap
+		case "remote":
+			if _, err := ParseRemotePlugin(v); err != nil {
+				return nil, err
+			}
+			gen.Remote = v
 		default:
+			// Other keys are parameters of the generator, so they
+			// can't be rejected; warn about likely typos instead.
+			if s := DidYouMean(k, generateKeys); s != "" && len(k) > 3 {
+				config.Warnings = append(config.Warnings, fmt.Sprintf("[%s] unknown key %q is passed to the generator as a parameter%s", name, k, s))
+			}
 			gen.Params = append(gen.Params, KeyValue{k, v})
 		}
 	}
+	if gen.Remote != "" {
+		if err := checkRemote(gen); err != nil {
+			return nil, err
+		}
+	}
+	if generator != "" {
+		// Is this shortened generator a protoc-gen-* binary, or
+		// should it be passed to protoc.
+		// We ignore the binary path since we don't do the same for the
+		// normal generate section. If we start using the binary path here
+		// we should also use it for the normal generate section.
+		if !ProtocBuiltinLanguages[generator] || gen.Remote != "" {
+			gen.Command = "protoc-gen-" + generator
+		} else {
+			gen.ProtocGen = generator
+		}
+		gen.Shortened = true // for vetting
+	}
 	return gen, nil
 }
 
+// parsePathMap parses the value of the path_map key of a generator, a
+// comma-separated list of prefix=dir pairs, such as
+// "com/example/api=java,testdata.tld/util=.".
+func parsePathMap(v string) ([]KeyValue, error) {
+	var pathMap []KeyValue
+	for _, pair := range strings.Split(v, ",") {
+		pair = strings.TrimSpace(pair)
+		i := strings.IndexByte(pair, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid path_map entry %q, must be prefix=dir", pair)
+		}
+		prefix := strings.Trim(strings.TrimSpace(pair[:i]), "/")
+		dir := strings.TrimSpace(pair[i+1:])
+		if prefix == "" || dir == "" || path.Clean(prefix) != prefix || filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("invalid path_map entry %q, must be a file name prefix and a relative directory", pair)
+		}
+		pathMap = append(pathMap, KeyValue{prefix, dir})
+	}
+	return pathMap, nil
+}
+
 func handleGlobal(config *Config, section *parser.Section) error {
 	for _, k := range section.RawKeys() {
 		v := strings.TrimSpace(section.GetRaw(k))
@@ -328,8 +1288,22 @@ func handleGlobal(config *Config, section *parser.Section) error {
 			config.Out = v
 		case "import_path":
 			config.ImportPath = v
+		case "go_module_path":
+			config.GoModulePath = v
+		case "out_root":
+			config.OutRoot = v
+		case "extend":
+			config.Extend = v
+		case "buf_gen":
+			config.BufGen = v
+		case "require_pinned_versions":
+			p, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("cannot parse require_pinned_versions: %w", err)
+			}
+			config.RequirePinnedVersions = p
 		default:
-			return fmt.Errorf("unexpected key %q in global section", k)
+			return fmt.Errorf("unexpected key %q in global section%s", k, DidYouMean(k, globalKeys))
 		}
 	}
 	return nil
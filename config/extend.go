@@ -0,0 +1,254 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gunk/gunk/generate/downloader"
+	"github.com/gunk/gunk/log"
+)
+
+// extendMaxAge is how long a config fetched from a URL is cached before
+// fetching it again.
+const extendMaxAge = 24 * time.Hour
+
+// loadExtended loads the config referred to by an 'extend' key in the config
+// file from, and any configs it extends in turn. The configs are returned in
+// order of specificity, like the ones found by Load. They act as if they were
+// in dir, the directory of the .gunkconfig extending them.
+//
+// The reference can be an http(s) URL, a local path relative to the directory
+// of from, or a Go module path with a version and an optional path to the
+// file within the module, such as "github.com/org/defaults@v1.2.0/gunkconfig".
+// By default, the ".gunkconfig" file at the module root is used.
+func loadExtended(dir, from, ref string, seen map[string]bool) ([]*Config, error) {
+	file, err := resolveExtend(from, ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid extended config %q: %w", ref, err)
+	}
+	if seen[file] {
+		return nil, fmt.Errorf("extend cycle with %q", ref)
+	}
+	seen[file] = true
+	data, err := fetchExtended(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch extended config %q: %w", ref, err)
+	}
+	cfg, err := LoadSingle(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error loading extended config %q: %w", ref, err)
+	}
+	var more []*Config
+	if cfg.Extend != "" {
+		if more, err = loadExtended(dir, file, cfg.Extend, seen); err != nil {
+			return nil, err
+		}
+		if err := mergeGenerators(cfg, more); err != nil {
+			return nil, fmt.Errorf("error loading extended config %q: %w", ref, err)
+		}
+	}
+	if err := addBufGen(cfg, dir); err != nil {
+		return nil, fmt.Errorf("error loading extended config %q: %w", ref, err)
+	}
+	// Generators from extended configs act as if they were declared in
+	// the extending config, so relative out paths are kept local.
+	patchConfig(cfg, dir)
+	cfg.setFile(file)
+	return append([]*Config{cfg}, more...), nil
+}
+
+// resolveExtend resolves the reference to an extended config ref, found in
+// the config from, so that relative paths are relative to the directory of
+// from, be it a local file, a URL or a file within a Go module.
+func resolveExtend(from, ref string) (string, error) {
+	if !strings.HasPrefix(ref, ".") {
+		return ref, nil
+	}
+	switch {
+	case isURL(from):
+		base, err := url.Parse(from)
+		if err != nil {
+			return "", err
+		}
+		rel, err := url.Parse(filepath.ToSlash(ref))
+		if err != nil {
+			return "", err
+		}
+		return base.ResolveReference(rel).String(), nil
+	case filepath.IsAbs(from):
+		return filepath.Join(filepath.Dir(from), ref), nil
+	}
+	mod, file := splitModuleRef(from)
+	file = path.Join(path.Dir(file), filepath.ToSlash(ref))
+	if file == ".." || strings.HasPrefix(file, "../") {
+		return "", fmt.Errorf("path is outside of module %s", mod)
+	}
+	return mod + "/" + file, nil
+}
+
+// mergeGenerators merges each generator of cfg declared in a named section,
+// such as [generate go], with the generator of the section with the same name
+// in the configs cfg extends, if any. The keys of the extended section are
+// defaults, which those of cfg override, and the extended generator no
+// longer runs on its own.
+func mergeGenerators(cfg *Config, extended []*Config) error {
+	for i, gen := range cfg.Generators {
+		if gen.section == "" || gen.section == "generate" {
+			// Unnamed sections add generators instead.
+			continue
+		}
+		for _, ext := range extended {
+			j := ext.generatorSection(gen.section)
+			if j < 0 {
+				continue
+			}
+			// The extended generator's warnings were already
+			// reported with its config.
+			merged, err := handleGenerate(&Config{}, gen.section, mergeKeys(ext.Generators[j].raw, gen.raw))
+			if err != nil {
+				return fmt.Errorf("[%s]: %w", gen.section, err)
+			}
+			cfg.Generators[i] = *merged
+			ext.Generators = append(ext.Generators[:j], ext.Generators[j+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// generatorSection returns the index of the generator of c declared in the
+// named section, or -1 if there is none.
+func (c *Config) generatorSection(section string) int {
+	for i, gen := range c.Generators {
+		if gen.section == section {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeKeys returns the keys and values of base, with the values of over
+// replacing those of the same keys, followed by the other keys of over.
+func mergeKeys(base, over []KeyValue) []KeyValue {
+	merged := append([]KeyValue(nil), base...)
+	for _, kv := range over {
+		found := false
+		for i := range merged {
+			if merged[i].Key == kv.Key {
+				merged[i].Value = kv.Value
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, kv)
+		}
+	}
+	return merged
+}
+
+func fetchExtended(ref string) ([]byte, error) {
+	switch {
+	case isURL(ref):
+		return fetchURL(ref)
+	case filepath.IsAbs(ref):
+		return ioutil.ReadFile(ref)
+	}
+	return fetchModule(ref)
+}
+
+// isURL reports whether ref is an http(s) URL.
+func isURL(ref string) bool {
+	return strings.HasPrefix(ref, "https://") || strings.HasPrefix(ref, "http://")
+}
+
+// splitModuleRef splits a reference to a config within a Go module into the
+// module path with its version, and the slash-separated path of the file
+// within the module.
+func splitModuleRef(ref string) (mod, file string) {
+	mod, file = ref, ".gunkconfig"
+	if i := strings.Index(ref, "@"); i >= 0 {
+		if j := strings.Index(ref[i:], "/"); j >= 0 {
+			mod, file = ref[:i+j], ref[i+j+1:]
+		}
+	}
+	return mod, file
+}
+
+// fetchURL downloads a config, caching it in the Gunk cache directory. The
+// cached copy is also used if the download fails.
+func fetchURL(url string) ([]byte, error) {
+	cacheDir, err := downloader.CacheDir()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(url))
+	cachePath := filepath.Join(cacheDir, "extend-"+hex.EncodeToString(sum[:8]))
+	info, statErr := os.Stat(cachePath)
+	if statErr == nil && time.Since(info.ModTime()) < extendMaxAge {
+		return ioutil.ReadFile(cachePath)
+	}
+	data, err := download(url)
+	if err != nil {
+		if statErr == nil {
+			log.Verbosef("using cached %s: %v", url, err)
+			return ioutil.ReadFile(cachePath)
+		}
+		return nil, err
+	}
+	if err := ioutil.WriteFile(cachePath, data, 0o644); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func download(url string) ([]byte, error) {
+	cl := &http.Client{Timeout: 30 * time.Second}
+	res, err := cl.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("could not retrieve %q (%d)", url, res.StatusCode)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+// fetchModule reads a config from a Go module, downloading the module with
+// the go tool, which also takes care of caching and checksums.
+func fetchModule(ref string) ([]byte, error) {
+	i := strings.Index(ref, "@")
+	if i < 0 {
+		return nil, fmt.Errorf("module reference must have a version, like module@v1.0.0")
+	}
+	modVersion, file := splitModuleRef(ref)
+	mod, version := modVersion[:i], modVersion[i+1:]
+	cmd := log.ExecCommand("go", "mod", "download", "-json", mod+"@"+version)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, log.ExecError("go mod download", err)
+	}
+	var info struct {
+		Dir   string
+		Error string
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		return nil, err
+	}
+	if info.Error != "" {
+		return nil, fmt.Errorf("%s", info.Error)
+	}
+	return ioutil.ReadFile(filepath.Join(info.Dir, filepath.FromSlash(file)))
+}
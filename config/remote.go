@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RemotePlugin is a remote plugin of the Buf Schema Registry.
+type RemotePlugin struct {
+	Remote   string // registry hosting the plugin, e.g. "buf.build"
+	Owner    string // e.g. "protocolbuffers"
+	Name     string // e.g. "go"
+	Version  string // e.g. "v1.31.0"; empty for the latest version
+	Revision uint32 // revision of the version; zero for the latest one
+}
+
+// ParseRemotePlugin parses a reference to a remote plugin, such as
+// "buf.build/protocolbuffers/go:v1.31.0". The version is optional, and may
+// be followed by a plugin revision, as in "v1.31.0-1".
+func ParseRemotePlugin(ref string) (RemotePlugin, error) {
+	var p RemotePlugin
+	name := ref
+	if i := strings.LastIndex(ref, ":"); i >= 0 {
+		name, p.Version = ref[:i], ref[i+1:]
+		if m := bufRevision.FindStringIndex(p.Version); m != nil {
+			rev, err := strconv.ParseUint(p.Version[m[0]+1:], 10, 32)
+			if err != nil {
+				return p, fmt.Errorf("invalid revision in remote plugin %q", ref)
+			}
+			p.Version, p.Revision = p.Version[:m[0]], uint32(rev)
+		}
+		if p.Version == "" {
+			return p, fmt.Errorf("empty version in remote plugin %q", ref)
+		}
+	}
+	parts := strings.Split(name, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return p, fmt.Errorf("invalid remote plugin %q; must be like buf.build/protocolbuffers/go:v1.31.0", ref)
+	}
+	p.Remote, p.Owner, p.Name = parts[0], parts[1], parts[2]
+	return p, nil
+}
+
+// String returns the reference to the plugin, without its revision.
+func (p RemotePlugin) String() string {
+	s := p.Remote + "/" + p.Owner + "/" + p.Name
+	if p.Version != "" {
+		s += ":" + p.Version
+	}
+	return s
+}
+
+// checkRemote checks that the other keys of a generator with a remote plugin
+// don't conflict with it, and names its command after the plugin.
+func checkRemote(gen *Generator) error {
+	switch {
+	case gen.ProtocGen != "":
+		return fmt.Errorf("only one 'protoc' or 'remote' allowed")
+	case gen.Command != "":
+		return fmt.Errorf("only one 'command' or 'remote' allowed")
+	case gen.PluginVersion != "":
+		return fmt.Errorf("plugin_version cannot be used with remote; set the version in remote, as in %s:v1.0.0", gen.Remote)
+	case gen.InProcess:
+		return fmt.Errorf("in_process cannot be used with remote")
+	}
+	p, err := ParseRemotePlugin(gen.Remote)
+	if err != nil {
+		return err
+	}
+	gen.Command = "protoc-gen-" + p.Name
+	return nil
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The keys allowed in each section of a .gunkconfig, to suggest the intended
+// key for a misspelled one.
+var (
+	globalKeys    = []string{"out", "import_path", "go_module_path", "out_root", "extend", "buf_gen", "require_pinned_versions"}
+	protocKeys    = []string{"path", "version"}
+	goModuleKeys  = []string{"go", "version", "license", "doc"}
+	releaseKeys   = []string{"tag_prefix"}
+	backstageKeys = []string{"owner", "lifecycle", "system", "descriptor_set"}
+	signKeys      = []string{"method", "key", "files"}
+	protoDepKeys  = []string{"url", "root"}
+	generateKeys  = []string{"command", "protoc", "plugin_version", "out", "version_range", "fix_paths_postproc", "json_tag_postproc", "in_process", "filename_template", "path_map", "remote"}
+	sectionNames  = []string{"protoc", "go_module", "release", "backstage", "sign", "vet", "vet terminology", "vet verbs", "vet limits", "vet dependencies", "types", "proto_dep", "generate"}
+)
+
+// renamedGenerateKeys maps the old names of keys of the generate sections to
+// their current names. The old names still work, with a warning.
+var renamedGenerateKeys = map[string]string{
+	"fix_paths": "fix_paths_postproc",
+}
+
+// DidYouMean returns a suggestion of the known name closest to name, such as
+// ` (did you mean "version"?)`, to append to an error about an unknown name.
+// It returns an empty string if no known name is close enough to be a likely
+// typo.
+func DidYouMean(name string, known []string) string {
+	best, bestDist := "", 0
+	for _, k := range known {
+		d := editDistance(name, k)
+		if best == "" || d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	if best == "" || bestDist > 1+len(best)/5 {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+// sectionSuggestion returns a suggestion for an unknown section name, also
+// covering misspelled named sections such as "genrate go".
+func sectionSuggestion(name string) string {
+	if s := DidYouMean(name, sectionNames); s != "" {
+		return s
+	}
+	fields := strings.Fields(name)
+	if len(fields) != 2 {
+		return ""
+	}
+	for _, section := range []string{"generate", "proto_dep"} {
+		if DidYouMean(fields[0], []string{section}) != "" {
+			return fmt.Sprintf(" (did you mean %q?)", section+" "+fields[1])
+		}
+	}
+	return ""
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
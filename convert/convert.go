@@ -15,7 +15,8 @@ import (
 )
 
 // Run converts proto files or folders to gunk files, saving the files in
-// the same folder as the proto file.
+// the same folder as the proto file. OpenAPI 2 and 3 documents, in JSON or
+// YAML files, are converted to Gunk files scaffolding their services.
 func Run(paths []string, overwrite bool) error {
 	for _, path := range paths {
 		if err := run(path, overwrite); err != nil {
@@ -30,6 +31,10 @@ func run(path string, overwrite bool) error {
 	if err != nil {
 		return err
 	}
+	// OpenAPI documents are converted without protoc.
+	if !fi.IsDir() && isOpenAPIFile(path) {
+		return convertOpenAPIFile(path, overwrite)
+	}
 	// Look for a .gunkconfig
 	absPath, _ := filepath.Abs(path)
 	cfg, err := config.Load(filepath.Dir(absPath))
@@ -0,0 +1,812 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gunk/gunk/format"
+	"github.com/kenshaw/snaker"
+	"gopkg.in/yaml.v3"
+)
+
+// isOpenAPIFile reports whether path is an OpenAPI document, going by its
+// extension.
+func isOpenAPIFile(path string) bool {
+	switch filepath.Ext(path) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// convertOpenAPIFile scaffolds a Gunk file from the OpenAPI 2 or 3 document
+// at path, written next to it.
+func convertOpenAPIFile(path string, overwrite bool) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read file %q: %v", path, err)
+	}
+	fullpath := strings.TrimSuffix(path, filepath.Ext(path)) + ".gunk"
+	if _, err := os.Stat(fullpath); !os.IsNotExist(err) && !overwrite {
+		return fmt.Errorf("path already exists %q, use --overwrite", fullpath)
+	}
+	absDir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if err := ConvertFromOpenAPI(&b, data, filepath.Ext(path) == ".json", packageName(filepath.Base(absDir))); err != nil {
+		return fmt.Errorf("unable to convert %q: %v", path, err)
+	}
+	result, err := format.Source(b.Bytes())
+	if err != nil {
+		// Also print the source being formatted, since the go/format
+		// error often points at a specific error in one of its lines.
+		fmt.Fprintln(os.Stderr, b.String())
+		return err
+	}
+	if err := ioutil.WriteFile(fullpath, result, 0o644); err != nil {
+		return fmt.Errorf("unable to write to file %q: %v", fullpath, err)
+	}
+	return nil
+}
+
+// packageName returns a valid package name from the name of a directory, or
+// "api" if there is none.
+func packageName(dir string) string {
+	var name []rune
+	for _, r := range strings.ToLower(dir) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			name = append(name, r)
+		}
+	}
+	if len(name) == 0 || unicode.IsDigit(name[0]) {
+		return "api"
+	}
+	return string(name)
+}
+
+// ConvertFromOpenAPI scaffolds a Gunk file in the package pkgName from an
+// OpenAPI 2 (Swagger) or 3 document, in JSON if isJSON is set or in YAML
+// otherwise, writing it to w. The schemas are converted to structs and
+// enums, and the operations to the methods of a service per tag, with their
+// request and response structs and http.Match tags. Schemas which can't be
+// represented in Gunk are printed to stderr. As with ConvertFromProto, the
+// output isn't canonically formatted.
+func ConvertFromOpenAPI(w io.Writer, data []byte, isJSON bool, pkgName string) error {
+	var root *yaml.Node
+	if isJSON {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		node, err := decodeJSONNode(dec)
+		if err != nil {
+			return err
+		}
+		root = node
+	} else {
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return err
+		}
+		if len(node.Content) == 0 {
+			return fmt.Errorf("empty document")
+		}
+		root = node.Content[0]
+	}
+	doc := &oaDocument{}
+	if err := root.Decode(doc); err != nil {
+		return err
+	}
+	if doc.Swagger == "" && doc.OpenAPI == "" {
+		return fmt.Errorf("not an OpenAPI document: no swagger or openapi version")
+	}
+	b := &oaBuilder{
+		doc:     doc,
+		names:   map[string]bool{},
+		schemas: map[string]*oaSchema{},
+		types:   map[string]string{},
+		imports: map[string]bool{},
+	}
+	return b.build(w, pkgName)
+}
+
+// oaDocument is an OpenAPI 2 or 3 document. Only what is converted to Gunk is
+// decoded.
+type oaDocument struct {
+	Swagger string `yaml:"swagger"`
+	OpenAPI string `yaml:"openapi"`
+	Info    struct {
+		Title       string `yaml:"title"`
+		Description string `yaml:"description"`
+	} `yaml:"info"`
+	BasePath string `yaml:"basePath"`
+	Servers  []struct {
+		URL string `yaml:"url"`
+	} `yaml:"servers"`
+	Paths       oaPaths                 `yaml:"paths"`
+	Definitions oaSchemas               `yaml:"definitions"`
+	Parameters  map[string]*oaParameter `yaml:"parameters"`
+	Responses   map[string]*oaResponse  `yaml:"responses"`
+	Components  struct {
+		Schemas       oaSchemas                 `yaml:"schemas"`
+		Parameters    map[string]*oaParameter   `yaml:"parameters"`
+		RequestBodies map[string]*oaRequestBody `yaml:"requestBodies"`
+		Responses     map[string]*oaResponse    `yaml:"responses"`
+	} `yaml:"components"`
+}
+
+type oaSchema struct {
+	Ref                  string        `yaml:"$ref"`
+	Type                 oaType        `yaml:"type"`
+	Format               string        `yaml:"format"`
+	Description          string        `yaml:"description"`
+	Enum                 []interface{} `yaml:"enum"`
+	Items                *oaSchema     `yaml:"items"`
+	Properties           oaSchemas     `yaml:"properties"`
+	AdditionalProperties yaml.Node     `yaml:"additionalProperties"`
+	AllOf                []*oaSchema   `yaml:"allOf"`
+	Deprecated           bool          `yaml:"deprecated"`
+}
+
+// oaType is the type of a schema. In OpenAPI 3.1 it may be a list, such as
+// [string, "null"], of which the first type other than null is kept.
+type oaType string
+
+func (t *oaType) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		*t = oaType(node.Value)
+		return nil
+	}
+	for _, n := range node.Content {
+		if n.Value != "null" {
+			*t = oaType(n.Value)
+			break
+		}
+	}
+	return nil
+}
+
+type oaParameter struct {
+	Ref         string    `yaml:"$ref"`
+	Name        string    `yaml:"name"`
+	In          string    `yaml:"in"`
+	Description string    `yaml:"description"`
+	Schema      *oaSchema `yaml:"schema"`
+	// The type of non-body parameters in OpenAPI 2.
+	Type   oaType        `yaml:"type"`
+	Format string        `yaml:"format"`
+	Items  *oaSchema     `yaml:"items"`
+	Enum   []interface{} `yaml:"enum"`
+}
+
+type oaRequestBody struct {
+	Ref     string                  `yaml:"$ref"`
+	Content map[string]*oaMediaType `yaml:"content"`
+}
+
+type oaMediaType struct {
+	Schema *oaSchema `yaml:"schema"`
+}
+
+type oaResponse struct {
+	Ref         string                  `yaml:"$ref"`
+	Description string                  `yaml:"description"`
+	Schema      *oaSchema               `yaml:"schema"`
+	Content     map[string]*oaMediaType `yaml:"content"`
+}
+
+type oaOperation struct {
+	OperationID string         `yaml:"operationId"`
+	Summary     string         `yaml:"summary"`
+	Description string         `yaml:"description"`
+	Tags        []string       `yaml:"tags"`
+	Parameters  []*oaParameter `yaml:"parameters"`
+	RequestBody *oaRequestBody `yaml:"requestBody"`
+	Responses   oaResponses    `yaml:"responses"`
+	Deprecated  bool           `yaml:"deprecated"`
+	// Set from the path item.
+	method, path string
+	pathParams   []*oaParameter
+}
+
+// oaSchemas are named schemas, in the order of the document.
+type oaSchemas []oaNamedSchema
+
+type oaNamedSchema struct {
+	Name   string
+	Schema *oaSchema
+}
+
+func (s *oaSchemas) UnmarshalYAML(node *yaml.Node) error {
+	return eachKey(node, func(key string, value *yaml.Node) error {
+		schema := &oaSchema{}
+		if err := value.Decode(schema); err != nil {
+			return err
+		}
+		*s = append(*s, oaNamedSchema{key, schema})
+		return nil
+	})
+}
+
+// oaResponses are the responses of an operation, by status code, in the
+// order of the document.
+type oaResponses []oaNamedResponse
+
+type oaNamedResponse struct {
+	Code     string
+	Response *oaResponse
+}
+
+func (r *oaResponses) UnmarshalYAML(node *yaml.Node) error {
+	return eachKey(node, func(key string, value *yaml.Node) error {
+		resp := &oaResponse{}
+		if err := value.Decode(resp); err != nil {
+			return err
+		}
+		*r = append(*r, oaNamedResponse{key, resp})
+		return nil
+	})
+}
+
+// oaPaths are the operations of the document, in order.
+type oaPaths []*oaOperation
+
+func (p *oaPaths) UnmarshalYAML(node *yaml.Node) error {
+	return eachKey(node, func(path string, item *yaml.Node) error {
+		var params []*oaParameter
+		var ops []*oaOperation
+		err := eachKey(item, func(key string, value *yaml.Node) error {
+			switch key {
+			case "parameters":
+				return value.Decode(&params)
+			case "get", "put", "post", "delete", "options", "head", "patch":
+				op := &oaOperation{method: strings.ToUpper(key), path: path}
+				if err := value.Decode(op); err != nil {
+					return err
+				}
+				ops = append(ops, op)
+			}
+			return nil
+		})
+		for _, op := range ops {
+			op.pathParams = params
+		}
+		*p = append(*p, ops...)
+		return err
+	})
+}
+
+// eachKey calls fn for each key of the mapping node, in order.
+func eachKey(node *yaml.Node, fn func(key string, value *yaml.Node) error) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping", node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if err := fn(node.Content[i].Value, node.Content[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeJSONNode decodes the next JSON value of dec as a YAML node, which
+// unlike a map keeps the order of the keys of objects.
+func decodeJSONNode(dec *json.Decoder) (*yaml.Node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		if tok == '{' {
+			node = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		for dec.More() {
+			if node.Kind == yaml.MappingNode {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key.(string)})
+			}
+			value, err := decodeJSONNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, value)
+		}
+		// The closing delimiter.
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: tok}, nil
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(tok.String(), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: tok.String()}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(tok)}, nil
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	}
+}
+
+// oaBuilder builds a Gunk file from an OpenAPI document.
+type oaBuilder struct {
+	doc *oaDocument
+	// names are the declared type and constant names.
+	names map[string]bool
+	// schemas are the named schemas of the document, and types the Gunk
+	// types they are declared as, by reference.
+	schemas map[string]*oaSchema
+	types   map[string]string
+	// services and decls are the converted service interfaces and
+	// types, in order.
+	services []string
+	decls    []string
+	imports  map[string]bool
+}
+
+func (b *oaBuilder) build(w io.Writer, pkgName string) error {
+	named := b.doc.Definitions
+	prefix := "#/definitions/"
+	if b.doc.OpenAPI != "" {
+		named, prefix = b.doc.Components.Schemas, "#/components/schemas/"
+	}
+	// Reserve the names of all the schemas first, as they may refer to
+	// each other in any order.
+	for _, s := range named {
+		ref := prefix + s.Name
+		b.schemas[ref] = s.Schema
+		if isStruct(s.Schema) || len(s.Schema.Enum) > 0 {
+			b.types[ref] = b.declName(s.Name)
+		}
+	}
+	for _, s := range named {
+		name, ok := b.types[prefix+s.Name]
+		if !ok {
+			// Other schemas, such as strings, are used in place.
+			continue
+		}
+		if err := b.declare(name, s.Schema); err != nil {
+			return err
+		}
+	}
+	services := map[string]*strings.Builder{}
+	var order []string
+	for _, op := range b.doc.Paths {
+		service := "Service"
+		if len(op.Tags) > 0 {
+			service = snaker.ForceCamelIdentifier(op.Tags[0]) + "Service"
+		}
+		sw, ok := services[service]
+		if !ok {
+			sw = &strings.Builder{}
+			services[service] = sw
+			order = append(order, service)
+		} else {
+			sw.WriteString("\n")
+		}
+		if err := b.method(sw, op); err != nil {
+			return fmt.Errorf("%s %s: %v", op.method, op.path, err)
+		}
+	}
+	for _, name := range order {
+		b.services = append(b.services, fmt.Sprintf("type %s interface {\n%s}", b.declName(name), services[name]))
+	}
+	if desc := b.doc.Info.Description; desc != "" {
+		writeComment(w, "", desc)
+	}
+	fmt.Fprintf(w, "package %s\n", pkgName)
+	if len(b.imports) > 0 {
+		// Standard library imports, such as time, go first.
+		var std, imports []string
+		for imp := range b.imports {
+			if strings.Contains(imp, ".") {
+				imports = append(imports, strconv.Quote(imp))
+			} else {
+				std = append(std, strconv.Quote(imp))
+			}
+		}
+		sort.Strings(std)
+		sort.Strings(imports)
+		if len(std) > 0 && len(imports) > 0 {
+			std = append(std, "")
+		}
+		fmt.Fprintf(w, "\nimport (\n\t%s\n)\n", strings.Join(append(std, imports...), "\n\t"))
+	}
+	for _, decl := range append(b.services, b.decls...) {
+		fmt.Fprintf(w, "\n%s\n", decl)
+	}
+	return nil
+}
+
+// declName returns a unique Go identifier for a declaration named name.
+func (b *oaBuilder) declName(name string) string {
+	base := identifier(name)
+	if base == "" {
+		base = "Type"
+	}
+	name = base
+	for i := 2; b.names[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	b.names[name] = true
+	return name
+}
+
+// identifier returns name as an exported Go identifier, such as PetID for
+// pet_id. Names in all caps, such as enum values, are lowercased first.
+func identifier(name string) string {
+	if strings.ToUpper(name) == name {
+		name = strings.ToLower(name)
+	}
+	var b strings.Builder
+	for _, r := range name {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	id := snaker.ForceCamelIdentifier(b.String())
+	if id != "" && !unicode.IsLetter(rune(id[0])) {
+		id = "X" + id
+	}
+	return id
+}
+
+// isStruct reports whether s is declared as a struct.
+func isStruct(s *oaSchema) bool {
+	return len(s.Properties) > 0 || len(s.AllOf) > 0 || (s.Type == "object" && s.AdditionalProperties.Kind != yaml.MappingNode)
+}
+
+// resolve returns the schema s refers to, if it is a reference.
+func (b *oaBuilder) resolve(s *oaSchema) *oaSchema {
+	for i := 0; s != nil && s.Ref != "" && i < 16; i++ {
+		s = b.schemas[s.Ref]
+	}
+	return s
+}
+
+// declare declares the struct or enum name for the schema s.
+func (b *oaBuilder) declare(name string, s *oaSchema) error {
+	w := &strings.Builder{}
+	writeComment(w, "", s.Description)
+	if len(s.Enum) > 0 {
+		b.enum(w, name, s.Enum)
+		b.decls = append(b.decls, w.String())
+		return nil
+	}
+	fmt.Fprintf(w, "type %s struct {\n", name)
+	var props oaSchemas
+	for _, part := range append([]*oaSchema{s}, s.AllOf...) {
+		if part = b.resolve(part); part != nil {
+			props = append(props, part.Properties...)
+		}
+	}
+	// The struct is added before the types declared for its fields.
+	i := len(b.decls)
+	b.decls = append(b.decls, "")
+	for seq, prop := range props {
+		typ, err := b.goType(name+"_"+prop.Name, prop.Schema)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", name, prop.Name, err)
+		}
+		writeComment(w, "\t", prop.Schema.Description)
+		fmt.Fprintf(w, "\t%s %s `pb:\"%d\" json:\"%s\"`\n", identifier(prop.Name), typ, seq+1, prop.Name)
+	}
+	w.WriteString("}")
+	b.decls[i] = w.String()
+	return nil
+}
+
+// enum writes the enum name with the values of an enum schema. As in proto3,
+// the first value is an unspecified zero value.
+func (b *oaBuilder) enum(w *strings.Builder, name string, values []interface{}) {
+	fmt.Fprintf(w, "type %s int\n\nconst (\n", name)
+	fmt.Fprintf(w, "\t%s %s = iota\n", b.declName(name+"_unspecified"), name)
+	for _, v := range values {
+		fmt.Fprintf(w, "\t%s\n", b.declName(name+identifier(fmt.Sprint(v))))
+	}
+	w.WriteString(")")
+}
+
+// goType returns the Gunk type of a field with the schema s, declaring the
+// types of its inline objects and enums as name.
+func (b *oaBuilder) goType(name string, s *oaSchema) (string, error) {
+	if s == nil {
+		return "", fmt.Errorf("missing schema")
+	}
+	if s.Ref != "" {
+		if typ, ok := b.types[s.Ref]; ok {
+			return typ, nil
+		}
+		target, ok := b.schemas[s.Ref]
+		if !ok {
+			return "", fmt.Errorf("unknown reference %q", s.Ref)
+		}
+		return b.goType(name, target)
+	}
+	if isStruct(s) || len(s.Enum) > 0 {
+		typ := b.declName(name)
+		if err := b.declare(typ, s); err != nil {
+			return "", err
+		}
+		return typ, nil
+	}
+	switch s.Type {
+	case "array":
+		if items := b.resolve(s.Items); items != nil && items.Type == "array" {
+			return b.unsupported(name, "nested arrays are")
+		}
+		typ, err := b.goType(name+"_item", s.Items)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + typ, nil
+	case "object":
+		values := &oaSchema{}
+		if err := s.AdditionalProperties.Decode(values); err != nil {
+			return "", err
+		}
+		typ, err := b.goType(name+"_value", values)
+		if err != nil {
+			return "", err
+		}
+		return "map[string]" + typ, nil
+	case "boolean":
+		return "bool", nil
+	case "integer":
+		switch s.Format {
+		case "int64":
+			return "int64", nil
+		case "uint32":
+			return "uint32", nil
+		case "uint64":
+			return "uint64", nil
+		}
+		return "int", nil
+	case "number":
+		if s.Format == "float" {
+			return "float32", nil
+		}
+		return "float64", nil
+	case "string":
+		switch s.Format {
+		case "byte", "binary":
+			return "[]byte", nil
+		case "date-time":
+			b.imports["time"] = true
+			return "time.Time", nil
+		}
+		return "string", nil
+	}
+	if s.Type == "" {
+		return b.unsupported(name, "schemas without a type, such as oneOf, are")
+	}
+	return b.unsupported(name, fmt.Sprintf("schemas of type %q are", s.Type))
+}
+
+// unsupported warns that the type of the field name can't be represented in
+// Gunk, and returns bytes in its place.
+func (b *oaBuilder) unsupported(name, what string) (string, error) {
+	fmt.Fprintf(os.Stderr, "%s: %s not supported, using bytes\n", name, what)
+	return "[]byte", nil
+}
+
+// method writes the method of the operation op to its service, declaring its
+// request and response structs.
+func (b *oaBuilder) method(w *strings.Builder, op *oaOperation) error {
+	name := op.OperationID
+	if name == "" {
+		name = strings.ToLower(op.method) + " " + op.path
+	}
+	name = identifier(name)
+	// Path templates refer to the fields of the request.
+	path := b.basePath() + op.path
+	for _, p := range append(op.pathParams, op.Parameters...) {
+		if p = b.parameter(p); p != nil && p.In == "path" {
+			path = strings.Replace(path, "{"+p.Name+"}", "{"+identifier(p.Name)+"}", 1)
+		}
+	}
+	req, body, err := b.request(name, op)
+	if err != nil {
+		return err
+	}
+	resp, err := b.response(name, op)
+	if err != nil {
+		return err
+	}
+	b.imports["github.com/gunk/opt/http"] = true
+	writeComment(w, "\t", strings.TrimSpace(op.Summary+"\n\n"+op.Description))
+	if op.Summary != "" || op.Description != "" {
+		w.WriteString("\t//\n")
+	}
+	if op.Deprecated {
+		b.imports["github.com/gunk/opt/method"] = true
+		w.WriteString("\t// +gunk method.Deprecated(true)\n")
+	}
+	fmt.Fprintf(w, "\t// +gunk http.Match{\n\t// Method: %q,\n\t// Path: %q,\n", op.method, path)
+	if body != "" {
+		fmt.Fprintf(w, "\t// Body: %q,\n", body)
+	}
+	fmt.Fprintf(w, "\t// }\n\t%s(%s) %s\n", name, req, resp)
+	return nil
+}
+
+// basePath returns the path the paths of the document are relative to.
+func (b *oaBuilder) basePath() string {
+	base := b.doc.BasePath
+	if len(b.doc.Servers) > 0 {
+		if u, err := url.Parse(b.doc.Servers[0].URL); err == nil {
+			base = u.Path
+		}
+	}
+	return strings.TrimSuffix(base, "/")
+}
+
+// parameter returns p, or the parameter it refers to.
+func (b *oaBuilder) parameter(p *oaParameter) *oaParameter {
+	if p == nil || p.Ref == "" {
+		return p
+	}
+	name := p.Ref[strings.LastIndex(p.Ref, "/")+1:]
+	if b.doc.OpenAPI != "" {
+		return b.doc.Components.Parameters[name]
+	}
+	return b.doc.Parameters[name]
+}
+
+// request returns the request type of the operation op, and the field holding
+// its body, if any. The request is the body itself if the operation has no
+// other parameters. Header and cookie parameters can't be bound by HTTP
+// rules, so they are left out with a warning.
+func (b *oaBuilder) request(method string, op *oaOperation) (typ, body string, _ error) {
+	var params []*oaParameter
+	var bodySchema *oaSchema
+	for _, p := range append(op.pathParams, op.Parameters...) {
+		switch p = b.parameter(p); {
+		case p == nil:
+			return "", "", fmt.Errorf("unknown parameter reference")
+		case p.In == "body":
+			bodySchema = p.Schema
+		case p.In == "path" || p.In == "query":
+			params = append(params, p)
+		default:
+			fmt.Fprintf(os.Stderr, "%s: %s parameter %q skipped\n", method, p.In, p.Name)
+		}
+	}
+	if rb := op.RequestBody; rb != nil {
+		if rb.Ref != "" {
+			rb = b.doc.Components.RequestBodies[rb.Ref[strings.LastIndex(rb.Ref, "/")+1:]]
+		}
+		if rb != nil {
+			bodySchema = jsonSchema(rb.Content)
+		}
+	}
+	if bodySchema != nil && len(params) == 0 {
+		if s := b.resolve(bodySchema); s != nil && isStruct(s) {
+			typ, err := b.goType(method+"Request", bodySchema)
+			return typ, "*", err
+		}
+	}
+	if bodySchema == nil && len(params) == 0 {
+		return "", "", nil
+	}
+	typ = b.declName(method + "Request")
+	w := &strings.Builder{}
+	fmt.Fprintf(w, "type %s struct {\n", typ)
+	i := len(b.decls)
+	b.decls = append(b.decls, "")
+	seq := 0
+	for _, p := range params {
+		schema := p.Schema
+		if schema == nil {
+			schema = &oaSchema{Type: p.Type, Format: p.Format, Items: p.Items, Enum: p.Enum}
+		}
+		ftyp, err := b.goType(typ+"_"+p.Name, schema)
+		if err != nil {
+			return "", "", fmt.Errorf("parameter %s: %v", p.Name, err)
+		}
+		seq++
+		writeComment(w, "\t", p.Description)
+		fmt.Fprintf(w, "\t%s %s `pb:\"%d\" json:\"%s\"`\n", identifier(p.Name), ftyp, seq, p.Name)
+	}
+	if bodySchema != nil {
+		ftyp, err := b.goType(typ+"_body", bodySchema)
+		if err != nil {
+			return "", "", fmt.Errorf("body: %v", err)
+		}
+		seq++
+		body = "Body"
+		fmt.Fprintf(w, "\t%s %s `pb:\"%d\" json:\"body\"`\n", body, ftyp, seq)
+	}
+	w.WriteString("}")
+	b.decls[i] = w.String()
+	return typ, body, nil
+}
+
+// response returns the response type of the successful response of the
+// operation op, if it has any.
+func (b *oaBuilder) response(method string, op *oaOperation) (string, error) {
+	var resp *oaResponse
+	for _, r := range op.Responses {
+		if strings.HasPrefix(r.Code, "2") || (r.Code == "default" && resp == nil) {
+			resp = r.Response
+			if r.Code != "default" {
+				break
+			}
+		}
+	}
+	if resp != nil && resp.Ref != "" {
+		name := resp.Ref[strings.LastIndex(resp.Ref, "/")+1:]
+		if b.doc.OpenAPI != "" {
+			resp = b.doc.Components.Responses[name]
+		} else {
+			resp = b.doc.Responses[name]
+		}
+	}
+	if resp == nil {
+		return "", nil
+	}
+	schema := resp.Schema
+	if schema == nil {
+		schema = jsonSchema(resp.Content)
+	}
+	if schema == nil {
+		return "", nil
+	}
+	if s := b.resolve(schema); s != nil && isStruct(s) {
+		return b.goType(method+"Response", schema)
+	}
+	// Other responses, such as lists, are wrapped in a struct, as methods
+	// return messages.
+	ftyp, err := b.goType(method+"Response_value", schema)
+	if err != nil {
+		return "", fmt.Errorf("response: %v", err)
+	}
+	typ := b.declName(method + "Response")
+	b.decls = append(b.decls, fmt.Sprintf("type %s struct {\n\tValue %s `pb:\"1\" json:\"value\"`\n}", typ, ftyp))
+	return typ, nil
+}
+
+// jsonSchema returns the schema of the JSON media type of content, or of its
+// only media type.
+func jsonSchema(content map[string]*oaMediaType) *oaSchema {
+	if mt, ok := content["application/json"]; ok {
+		return mt.Schema
+	}
+	if len(content) == 1 {
+		for _, mt := range content {
+			return mt.Schema
+		}
+	}
+	return nil
+}
+
+// writeComment writes text as a comment, indented by indent.
+func writeComment(w io.Writer, indent, text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(w, "%s// %s\n", indent, strings.TrimRight(line, " \t"))
+	}
+}
@@ -0,0 +1,273 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gunk/gunk/format"
+	"github.com/gunk/gunk/loader"
+	"github.com/gunk/gunk/protoutil"
+	"golang.org/x/mod/modfile"
+	"google.golang.org/grpc"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// RunReflection converts the proto files of the services served by the gRPC
+// server at addr, fetched over its reflection service, to Gunk files. This
+// allows onboarding services whose proto files aren't available. The files
+// are written below dir, at the paths of the proto files, along with the
+// files they import, other than the well-known ones of Google. The
+// connection is in plaintext.
+func RunReflection(ctx context.Context, addr, dir string, overwrite bool) error {
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	files, err := reflectedFiles(ctx, rpb.NewServerReflectionClient(conn))
+	if err != nil {
+		return fmt.Errorf("unable to fetch the proto files of %s: %w", addr, err)
+	}
+	return convertReflectedFiles(files, dir, overwrite)
+}
+
+// reflectedFiles returns the files declaring the services listed by the
+// reflection service client, along with the files they import, by name.
+func reflectedFiles(ctx context.Context, client rpb.ServerReflectionClient) (map[string]*descriptorpb.FileDescriptorProto, error) {
+	stream, err := client.ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+	call := func(req *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+		if err := stream.Send(req); err != nil {
+			return nil, err
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if e := resp.GetErrorResponse(); e != nil {
+			return nil, fmt.Errorf("%s", e.ErrorMessage)
+		}
+		return resp, nil
+	}
+	resp, err := call(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*descriptorpb.FileDescriptorProto)
+	add := func(resp *rpb.ServerReflectionResponse) error {
+		for _, data := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			f := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(data, f); err != nil {
+				return err
+			}
+			files[f.GetName()] = f
+		}
+		return nil
+	}
+	for _, svc := range resp.GetListServicesResponse().GetService() {
+		if strings.HasPrefix(svc.Name, "grpc.reflection.") {
+			continue
+		}
+		resp, err := call(&rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: svc.Name},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", svc.Name, err)
+		}
+		if err := add(resp); err != nil {
+			return nil, err
+		}
+	}
+	// Servers may leave out the dependencies they already sent, or which
+	// they don't know about, so the missing ones are asked for by name.
+	for {
+		var missing []string
+		for _, f := range files {
+			for _, dep := range f.Dependency {
+				if files[dep] == nil {
+					missing = append(missing, dep)
+				}
+			}
+		}
+		if len(missing) == 0 {
+			return files, nil
+		}
+		for _, name := range missing {
+			if files[name] != nil {
+				continue
+			}
+			resp, err := call(&rpb.ServerReflectionRequest{
+				MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: name},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("file %s: %w", name, err)
+			}
+			if err := add(resp); err != nil {
+				return nil, err
+			}
+			if files[name] == nil {
+				return nil, fmt.Errorf("file %s not sent", name)
+			}
+		}
+	}
+}
+
+// isGoogleFile reports whether name is one of the proto files of Google,
+// such as the well-known types, or of the gRPC and grpc-gateway projects,
+// which aren't converted.
+func isGoogleFile(name string) bool {
+	for _, prefix := range []string{"google/", "grpc/", "protoc-gen-openapiv2/", "protoc-gen-swagger/"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// convertReflectedFiles converts files to Gunk files written below dir,
+// skipping those of Google and those declaring custom options, which can't
+// be declared in Gunk.
+func convertReflectedFiles(files map[string]*descriptorpb.FileDescriptorProto, dir string, overwrite bool) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	modPath, modDir, err := findModule(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name, f := range files {
+		switch {
+		case isGoogleFile(name):
+		case len(f.Extension) > 0:
+			fmt.Fprintf(os.Stderr, "skipping %s, which declares custom options\n", name)
+		default:
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	// The imports of the converted files are imports of the Gunk
+	// packages they are converted to.
+	imports := make(map[string]*descriptorpb.FileDescriptorProto, len(files))
+	for name, f := range files {
+		imports[name] = f
+	}
+	for _, name := range names {
+		f := proto.Clone(files[name]).(*descriptorpb.FileDescriptorProto)
+		pkgDir, err := filepath.Rel(modDir, filepath.Join(dir, filepath.Dir(filepath.FromSlash(name))))
+		if err != nil {
+			return err
+		}
+		if f.Options == nil {
+			f.Options = &descriptorpb.FileOptions{}
+		}
+		f.Options.GoPackage = proto.String(path.Join(modPath, filepath.ToSlash(pkgDir)))
+		imports[name] = f
+	}
+	reg, err := protodesc.NewFiles(&descriptorpb.FileDescriptorSet{File: sortedFiles(files)})
+	if err != nil {
+		return err
+	}
+	p, err := protoutil.NewPrinter(reg)
+	if err != nil {
+		return err
+	}
+	p.Relative = true
+	for _, name := range names {
+		f := proto.Clone(files[name]).(*descriptorpb.FileDescriptorProto)
+		// Gunk derives the Go package from the module instead.
+		pkgName := goPackageName(f)
+		if f.Options != nil {
+			f.Options.GoPackage = nil
+		}
+		fd, err := protodesc.NewFile(f, reg)
+		if err != nil {
+			return err
+		}
+		src, err := p.PrintFile(fd)
+		if err != nil {
+			return err
+		}
+		fullpath := filepath.Join(dir, filepath.FromSlash(strings.TrimSuffix(name, ".proto")+".gunk"))
+		if _, err := os.Stat(fullpath); !os.IsNotExist(err) && !overwrite {
+			return fmt.Errorf("path already exists %q, use --overwrite", fullpath)
+		}
+		var b bytes.Buffer
+		if err := loader.ConvertFromProtoFiles(&b, bytes.NewReader(src), name, pkgName, imports); err != nil {
+			return err
+		}
+		result, err := format.Source(b.Bytes())
+		if err != nil {
+			// Also print the source being formatted, since the
+			// go/format error often points at a specific error in
+			// one of its lines.
+			fmt.Fprintln(os.Stderr, b.String())
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(fullpath), 0o755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(fullpath, result, 0o644); err != nil {
+			return fmt.Errorf("unable to write to file %q: %v", fullpath, err)
+		}
+	}
+	return nil
+}
+
+// sortedFiles returns the files of files, sorted by name.
+func sortedFiles(files map[string]*descriptorpb.FileDescriptorProto) []*descriptorpb.FileDescriptorProto {
+	list := make([]*descriptorpb.FileDescriptorProto, 0, len(files))
+	for _, f := range files {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].GetName() < list[j].GetName() })
+	return list
+}
+
+// goPackageName returns the name of the Gunk package of f: the name of its
+// Go package, or else the last element of its proto package.
+func goPackageName(f *descriptorpb.FileDescriptorProto) string {
+	name := f.GetOptions().GetGoPackage()
+	if i := strings.LastIndex(name, ";"); i >= 0 {
+		name = name[i+1:]
+	} else {
+		name = path.Base(name)
+	}
+	if name == "" || name == "." {
+		name = f.GetPackage()[strings.LastIndex(f.GetPackage(), ".")+1:]
+	}
+	return packageName(name)
+}
+
+// findModule returns the path and directory of the Go module holding the
+// absolute directory dir. The Gunk packages converted outside of a module are
+// imported by their path relative to dir.
+func findModule(dir string) (modPath, modDir string, err error) {
+	for d := dir; ; {
+		data, err := ioutil.ReadFile(filepath.Join(d, "go.mod"))
+		if err == nil {
+			return modfile.ModulePath(data), d, nil
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return "", dir, nil
+		}
+		d = parent
+	}
+}
@@ -0,0 +1,118 @@
+package convert
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gunk/gunk/reflectionserver"
+	"google.golang.org/grpc"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestConvertReflection(t *testing.T) {
+	common := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("acme/common/money.proto"),
+		Package: proto.String("acme.common.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Money"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("Cents"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(),
+				JsonName: proto.String("cents"),
+			}},
+		}},
+	}
+	pets := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("acme/pets/pets.proto"),
+		Package:    proto.String("acme.pets.v1"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"acme/common/money.proto"},
+		Options:    &descriptorpb.FileOptions{GoPackage: proto.String("github.com/acme/pets;pets")},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Pet"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("Price"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+				TypeName: proto.String(".acme.common.v1.Money"),
+				JsonName: proto.String("price"),
+			}},
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Pets"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("GetPet"),
+				InputType:  proto.String(".acme.pets.v1.Pet"),
+				OutputType: proto.String(".acme.pets.v1.Pet"),
+			}},
+		}},
+	}
+	s, err := reflectionserver.New(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{common, pets}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	s.Register(srv)
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	files, err := reflectedFiles(context.Background(), rpb.NewServerReflectionClient(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files["acme/common/money.proto"] == nil {
+		t.Fatalf("dependency not fetched, got %d files", len(files))
+	}
+
+	dir, err := ioutil.TempDir("", "gunk-convert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/apis\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := convertReflectedFiles(files, dir, false); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dir, "acme", "pets", "pets.gunk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`package pets // proto "acme.pets.v1"`,
+		`acme_common_v1 "example.com/apis/acme/common"`,
+		`Price acme_common_v1.Money`,
+		`GetPet(Pet) Pet`,
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("pets.gunk missing %q:\n%s", want, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "acme", "common", "money.gunk")); err != nil {
+		t.Error(err)
+	}
+	// Converted files aren't overwritten by default.
+	if err := convertReflectedFiles(files, dir, false); err == nil || !strings.Contains(err.Error(), "use --overwrite") {
+		t.Errorf("got error %v, want one about --overwrite", err)
+	}
+}
@@ -0,0 +1,433 @@
+// Package doc renders the documentation of Gunk packages, with their services,
+// methods, HTTP routes, messages and enums, from their translated descriptors,
+// as Markdown or HTML.
+package doc
+
+import (
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gunk/gunk/generate"
+	"github.com/gunk/gunk/lifecyclepb"
+	"github.com/gunk/gunk/protoutil"
+	"github.com/gunk/gunk/retrygen/methodpb"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// The formats of the documentation.
+const (
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+)
+
+//go:embed templates/*
+var templates embed.FS
+
+// Options controls the rendering of the documentation.
+type Options struct {
+	// Format is either FormatMarkdown, the default, or FormatHTML. HTML
+	// templates escape what they render.
+	Format string
+	// Template is the path of a Go template to render the documentation
+	// with, rather than the default one of the format. It is executed with
+	// a *Doc.
+	Template string
+}
+
+// Run writes to w the documentation of the Gunk packages matching patterns.
+func Run(w io.Writer, dir string, opts Options, patterns ...string) error {
+	fds, files, err := generate.RequestedFileDescriptorSet(dir, patterns...)
+	if err != nil {
+		return err
+	}
+	d, err := New(fds, files)
+	if err != nil {
+		return err
+	}
+	return d.Render(w, opts)
+}
+
+// Doc is the documentation of a set of packages, as passed to the templates.
+type Doc struct {
+	Packages []*Package
+	// documented holds the full names of the documented messages and
+	// enums, which the types of fields link to.
+	documented map[string]bool
+}
+
+// Package is a documented package, being one Gunk package.
+type Package struct {
+	Name        string
+	Description string
+	Deprecated  bool
+	// Stage is the lifecycle stage of the package, such as "BETA", or
+	// empty.
+	Stage    string
+	Services []*Service
+	Messages []*Message
+	Enums    []*Enum
+}
+
+// Service is a documented service.
+type Service struct {
+	Name        string
+	FullName    string
+	Description string
+	Deprecated  bool
+	// Stage is the lifecycle stage of the service, if it differs from the
+	// one of its package.
+	Stage   string
+	Methods []*Method
+}
+
+// Method is a documented method of a service.
+type Method struct {
+	Name            string
+	Description     string
+	Deprecated      bool
+	Input           *Type
+	Output          *Type
+	ClientStreaming bool
+	ServerStreaming bool
+	// Timeout is the default timeout of the calls to the method, or zero.
+	Timeout time.Duration
+	// Stage is the lifecycle stage of the method, if it differs from the
+	// one of its service.
+	Stage string
+	// Routes are the HTTP bindings of the method, the primary one first.
+	Routes []*Route
+}
+
+// Route is an HTTP binding of a method.
+type Route struct {
+	Method       string
+	Path         string
+	Body         string
+	ResponseBody string
+}
+
+// Message is a documented message. Nested messages are listed along with the
+// top-level ones, named after their parents, such as "Outer.Inner".
+type Message struct {
+	Name        string
+	FullName    string
+	Description string
+	Deprecated  bool
+	Fields      []*Field
+}
+
+// Field is a documented field of a message.
+type Field struct {
+	Name        string
+	JSONName    string
+	Number      int
+	Description string
+	Deprecated  bool
+	// Label is "repeated" for lists, "map" for maps, and "optional" for
+	// proto3 optional fields; it is empty otherwise.
+	Label string
+	// Type is the type of the values of the field, being the value type
+	// of maps, whose key type is KeyType.
+	Type    *Type
+	KeyType *Type
+}
+
+// Type is the type of a field, or the input or output of a method.
+type Type struct {
+	// Name is the scalar type, such as "string", or the full name of the
+	// message or enum, such as "util.Message".
+	Name string
+	// Anchor is the anchor of the documentation of the message or enum,
+	// if it is documented.
+	Anchor string
+}
+
+// Enum is a documented enum.
+type Enum struct {
+	Name        string
+	FullName    string
+	Description string
+	Deprecated  bool
+	Values      []*EnumValue
+}
+
+// EnumValue is a documented value of an enum.
+type EnumValue struct {
+	Name        string
+	Number      int
+	Description string
+	Deprecated  bool
+}
+
+// New returns the documentation of files, which fds must hold along with all
+// their dependencies.
+func New(fds *descriptorpb.FileDescriptorSet, files []string) (*Doc, error) {
+	reg, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, err
+	}
+	d := &Doc{documented: make(map[string]bool)}
+	var fileDescs []protoreflect.FileDescriptor
+	for _, name := range files {
+		fd, err := reg.FindFileByPath(name)
+		if err != nil {
+			return nil, err
+		}
+		fileDescs = append(fileDescs, fd)
+		// Record the documented types first, for the fields of the
+		// packages to link to each other.
+		d.recordTypes(fd.Messages(), fd.Enums())
+	}
+	for _, fd := range fileDescs {
+		d.Packages = append(d.Packages, d.newPackage(fd))
+	}
+	return d, nil
+}
+
+// recordTypes records the messages and enums, along with their nested ones,
+// as documented.
+func (d *Doc) recordTypes(msgs protoreflect.MessageDescriptors, enums protoreflect.EnumDescriptors) {
+	for i := 0; i < enums.Len(); i++ {
+		d.documented[string(enums.Get(i).FullName())] = true
+	}
+	for i := 0; i < msgs.Len(); i++ {
+		msg := msgs.Get(i)
+		if msg.IsMapEntry() {
+			continue
+		}
+		d.documented[string(msg.FullName())] = true
+		d.recordTypes(msg.Messages(), msg.Enums())
+	}
+}
+
+func (d *Doc) newPackage(fd protoreflect.FileDescriptor) *Package {
+	pkg := &Package{
+		Name:        string(fd.Package()),
+		Description: protoutil.Description(protoutil.PackageComments(fd)),
+		Deprecated:  fd.Options().(*descriptorpb.FileOptions).GetDeprecated(),
+		Stage:       stage(fd.Options(), lifecyclepb.E_FileStage),
+	}
+	services := fd.Services()
+	for i := 0; i < services.Len(); i++ {
+		pkg.Services = append(pkg.Services, d.newService(services.Get(i)))
+	}
+	d.addTypes(pkg, fd.Messages(), fd.Enums())
+	return pkg
+}
+
+// stage returns the lifecycle stage set by the option ext of opts, or an
+// empty string.
+func stage(opts protoreflect.ProtoMessage, ext protoreflect.ExtensionType) string {
+	if s, ok := proto.GetExtension(opts, ext).(lifecyclepb.Stage); ok && s != lifecyclepb.Stage_STAGE_UNSPECIFIED {
+		return s.String()
+	}
+	return ""
+}
+
+func (d *Doc) newService(srv protoreflect.ServiceDescriptor) *Service {
+	s := &Service{
+		Name:        string(srv.Name()),
+		FullName:    string(srv.FullName()),
+		Description: protoutil.Description(protoutil.Comments(srv)),
+		Deprecated:  srv.Options().(*descriptorpb.ServiceOptions).GetDeprecated(),
+		Stage:       stage(srv.Options(), lifecyclepb.E_ServiceStage),
+	}
+	methods := srv.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		method := methods.Get(i)
+		m := &Method{
+			Name:            string(method.Name()),
+			Description:     protoutil.Description(protoutil.Comments(method)),
+			Deprecated:      method.Options().(*descriptorpb.MethodOptions).GetDeprecated(),
+			Input:           d.newType(method.Input().FullName()),
+			Output:          d.newType(method.Output().FullName()),
+			ClientStreaming: method.IsStreamingClient(),
+			ServerStreaming: method.IsStreamingServer(),
+			Stage:           stage(method.Options(), lifecyclepb.E_MethodStage),
+		}
+		if d, ok := proto.GetExtension(method.Options(), methodpb.E_Timeout).(*durationpb.Duration); ok && d != nil {
+			m.Timeout = d.AsDuration()
+		}
+		if rule, ok := proto.GetExtension(method.Options(), annotations.E_Http).(*annotations.HttpRule); ok && rule != nil {
+			for _, binding := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+				m.Routes = append(m.Routes, newRoute(binding))
+			}
+		}
+		s.Methods = append(s.Methods, m)
+	}
+	return s
+}
+
+// newRoute returns the route of an HTTP binding.
+func newRoute(binding *annotations.HttpRule) *Route {
+	r := &Route{Body: binding.GetBody(), ResponseBody: binding.GetResponseBody()}
+	switch pattern := binding.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		r.Method, r.Path = "GET", pattern.Get
+	case *annotations.HttpRule_Put:
+		r.Method, r.Path = "PUT", pattern.Put
+	case *annotations.HttpRule_Post:
+		r.Method, r.Path = "POST", pattern.Post
+	case *annotations.HttpRule_Delete:
+		r.Method, r.Path = "DELETE", pattern.Delete
+	case *annotations.HttpRule_Patch:
+		r.Method, r.Path = "PATCH", pattern.Patch
+	case *annotations.HttpRule_Custom:
+		r.Method, r.Path = pattern.Custom.GetKind(), pattern.Custom.GetPath()
+	}
+	return r
+}
+
+// addTypes adds the messages and enums, along with their nested ones, to the
+// documentation of pkg.
+func (d *Doc) addTypes(pkg *Package, msgs protoreflect.MessageDescriptors, enums protoreflect.EnumDescriptors) {
+	for i := 0; i < enums.Len(); i++ {
+		enum := enums.Get(i)
+		e := &Enum{
+			Name:        localName(enum),
+			FullName:    string(enum.FullName()),
+			Description: protoutil.Description(protoutil.Comments(enum)),
+			Deprecated:  enum.Options().(*descriptorpb.EnumOptions).GetDeprecated(),
+		}
+		values := enum.Values()
+		for j := 0; j < values.Len(); j++ {
+			value := values.Get(j)
+			e.Values = append(e.Values, &EnumValue{
+				Name:        string(value.Name()),
+				Number:      int(value.Number()),
+				Description: protoutil.Description(protoutil.Comments(value)),
+				Deprecated:  value.Options().(*descriptorpb.EnumValueOptions).GetDeprecated(),
+			})
+		}
+		pkg.Enums = append(pkg.Enums, e)
+	}
+	for i := 0; i < msgs.Len(); i++ {
+		msg := msgs.Get(i)
+		if msg.IsMapEntry() {
+			continue
+		}
+		m := &Message{
+			Name:        localName(msg),
+			FullName:    string(msg.FullName()),
+			Description: protoutil.Description(protoutil.Comments(msg)),
+			Deprecated:  msg.Options().(*descriptorpb.MessageOptions).GetDeprecated(),
+		}
+		fields := msg.Fields()
+		for j := 0; j < fields.Len(); j++ {
+			m.Fields = append(m.Fields, d.newField(fields.Get(j)))
+		}
+		pkg.Messages = append(pkg.Messages, m)
+		d.addTypes(pkg, msg.Messages(), msg.Enums())
+	}
+}
+
+func (d *Doc) newField(field protoreflect.FieldDescriptor) *Field {
+	f := &Field{
+		Name:        string(field.Name()),
+		JSONName:    field.JSONName(),
+		Number:      int(field.Number()),
+		Description: protoutil.Description(protoutil.Comments(field)),
+		Deprecated:  field.Options().(*descriptorpb.FieldOptions).GetDeprecated(),
+		Type:        d.kindType(field),
+	}
+	switch {
+	case field.IsMap():
+		f.Label = "map"
+		f.KeyType = d.kindType(field.MapKey())
+		f.Type = d.kindType(field.MapValue())
+	case field.IsList():
+		f.Label = "repeated"
+	case field.HasOptionalKeyword():
+		f.Label = "optional"
+	}
+	return f
+}
+
+// kindType returns the type of a single value of field.
+func (d *Doc) kindType(field protoreflect.FieldDescriptor) *Type {
+	switch field.Kind() {
+	case protoreflect.EnumKind:
+		return d.newType(field.Enum().FullName())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return d.newType(field.Message().FullName())
+	}
+	return &Type{Name: field.Kind().String()}
+}
+
+// newType returns the type of the message or enum name, linking to its
+// documentation if it is documented.
+func (d *Doc) newType(name protoreflect.FullName) *Type {
+	t := &Type{Name: string(name)}
+	if d.documented[t.Name] {
+		t.Anchor = anchor(t.Name)
+	}
+	return t
+}
+
+// Render renders the documentation to w.
+func (d *Doc) Render(w io.Writer, opts Options) error {
+	name := "templates/markdown.tmpl"
+	switch opts.Format {
+	case "", FormatMarkdown:
+	case FormatHTML:
+		name = "templates/html.tmpl"
+	default:
+		return fmt.Errorf("unknown format %q, want %s or %s", opts.Format, FormatMarkdown, FormatHTML)
+	}
+	var text []byte
+	var err error
+	if opts.Template != "" {
+		name = opts.Template
+		text, err = ioutil.ReadFile(opts.Template)
+	} else {
+		text, err = templates.ReadFile(name)
+	}
+	if err != nil {
+		return err
+	}
+	funcs := map[string]interface{}{
+		"anchor": anchor,
+		"inline": inline,
+	}
+	if opts.Format == FormatHTML {
+		tpl, err := htmltemplate.New(filepath.Base(name)).Funcs(funcs).Parse(string(text))
+		if err != nil {
+			return err
+		}
+		return tpl.Execute(w, d)
+	}
+	tpl, err := template.New(filepath.Base(name)).Funcs(funcs).Parse(string(text))
+	if err != nil {
+		return err
+	}
+	return tpl.Execute(w, d)
+}
+
+// anchor returns the anchor of the documentation of a message, enum or
+// service with the given full name.
+func anchor(fullName string) string {
+	return strings.ToLower(strings.ReplaceAll(fullName, ".", "-"))
+}
+
+// inline returns text on a single line, for a Markdown table cell.
+func inline(text string) string {
+	return strings.ReplaceAll(strings.Join(strings.Fields(text), " "), "|", "\\|")
+}
+
+// localName returns the name of the message or enum desc within its package,
+// such as "Outer.Inner".
+func localName(desc protoreflect.Descriptor) string {
+	return strings.TrimPrefix(string(desc.FullName()), string(desc.ParentFile().Package())+".")
+}
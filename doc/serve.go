@@ -0,0 +1,167 @@
+package doc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchInterval is how often the Gunk files are checked for changes while
+// serving.
+const watchInterval = 500 * time.Millisecond
+
+// reloadScript is added to the served pages, reloading them once the
+// documentation was rendered again. %d is the version of the page.
+const reloadScript = `<script>
+(function() {
+	var version = "%d";
+	setInterval(function() {
+		fetch("/_version").then(function(resp) { return resp.text(); }).then(function(v) {
+			if (v !== version) {
+				location.reload();
+			}
+		}).catch(function() {});
+	}, 1000);
+})();
+</script>
+`
+
+// Serve serves the HTML documentation of the Gunk packages matching patterns
+// on addr, until ctx is done. The address being listened on is printed to w,
+// along with the errors rendering the documentation. The documentation is
+// rendered again whenever the Gunk files or configs below dir change, and the
+// pages open in browsers reload themselves.
+func Serve(ctx context.Context, w io.Writer, addr, dir string, opts Options, patterns ...string) error {
+	opts.Format = FormatHTML
+	s := &server{render: func(out io.Writer) error {
+		return Run(out, dir, opts, patterns...)
+	}}
+	state, err := gunkFilesState(dir)
+	if err != nil {
+		return err
+	}
+	if err := s.rebuild(); err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: s}
+	fmt.Fprintf(w, "serving documentation on http://%s\n", lis.Addr())
+	go func() {
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				srv.Close()
+				return
+			case <-ticker.C:
+			}
+			newState, err := gunkFilesState(dir)
+			if err != nil || newState == state {
+				continue
+			}
+			state = newState
+			if err := s.rebuild(); err != nil {
+				fmt.Fprintf(w, "error: %v\n", err)
+			} else {
+				fmt.Fprintf(w, "reloaded documentation\n")
+			}
+		}
+	}()
+	if err := srv.Serve(lis); err != nil && ctx.Err() == nil {
+		return err
+	}
+	// Being interrupted is the usual way to stop serving.
+	return nil
+}
+
+// server serves the last rendered page of documentation, and its version at
+// /_version for the pages to reload themselves.
+type server struct {
+	render func(io.Writer) error
+
+	mu      sync.Mutex
+	page    []byte
+	version int
+}
+
+// rebuild renders the documentation again. If that fails, the error is
+// served instead, until the next rebuild.
+func (s *server) rebuild() error {
+	var buf bytes.Buffer
+	err := s.render(&buf)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	page := buf.Bytes()
+	if err != nil {
+		page = []byte("<!DOCTYPE html>\n<html>\n<body>\n<pre>" + html.EscapeString(err.Error()) + "</pre>\n</body>\n</html>\n")
+	}
+	// The script goes at the end of the body, or of the page for custom
+	// templates without one.
+	script := []byte(fmt.Sprintf(reloadScript, s.version))
+	if i := bytes.LastIndex(page, []byte("</body>")); i >= 0 {
+		page = append(page[:i:i], append(script, page[i:]...)...)
+	} else {
+		page = append(page, script...)
+	}
+	s.page = page
+	return err
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	page, version := s.page, s.version
+	s.mu.Unlock()
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+	case "/_version":
+		w.Header().Set("Cache-Control", "no-store")
+		io.WriteString(w, strconv.Itoa(version))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// gunkFilesState returns a checksum of the names, sizes and modification
+// times of the Gunk files and configs below dir, which changes along with
+// them. Directories starting with "." are skipped.
+func gunkFilesState(dir string) (string, error) {
+	if dir == "" {
+		dir = "."
+	}
+	h := sha256.New()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".gunk" && info.Name() != ".gunkconfig" {
+			return nil
+		}
+		fmt.Fprintf(h, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return fmt.Sprintf("%x", h.Sum(nil)), err
+}
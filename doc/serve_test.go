@@ -0,0 +1,91 @@
+package doc
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	body := "<html><body>v1</body></html>"
+	var renderErr error
+	s := &server{render: func(w io.Writer) error {
+		io.WriteString(w, body)
+		return renderErr
+	}}
+	get := func(path string) string {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Body.String()
+	}
+	if err := s.rebuild(); err != nil {
+		t.Fatal(err)
+	}
+	page := get("/")
+	if !strings.HasPrefix(page, "<html><body>v1<script>") || !strings.HasSuffix(page, "</script>\n</body></html>") {
+		t.Errorf("reload script not added at the end of the body:\n%s", page)
+	}
+	if !strings.Contains(page, `var version = "1"`) {
+		t.Errorf("page missing its version:\n%s", page)
+	}
+	if v := get("/_version"); v != "1" {
+		t.Errorf("got version %q, want 1", v)
+	}
+
+	// Errors are served until the next rebuild, and reload the pages too.
+	renderErr = errors.New("bad <gunk>")
+	if err := s.rebuild(); err != renderErr {
+		t.Fatalf("got error %v, want %v", err, renderErr)
+	}
+	if page := get("/"); !strings.Contains(page, "<pre>bad &lt;gunk&gt;</pre>") {
+		t.Errorf("error not served:\n%s", page)
+	}
+	if v := get("/_version"); v != "2" {
+		t.Errorf("got version %q, want 2", v)
+	}
+}
+
+func TestGunkFilesState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gunk-doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state := func() string {
+		s, err := gunkFilesState(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	write("a.gunk", "package a")
+	first := state()
+	write("README.md", "not gunk")
+	write(".git/b.gunk", "package b")
+	if state() != first {
+		t.Error("state changed with files other than Gunk ones")
+	}
+	write("sub/b.gunk", "package b")
+	second := state()
+	if second == first {
+		t.Error("state unchanged with a new Gunk file")
+	}
+	write("a.gunk", "package a // changed")
+	if state() == second {
+		t.Error("state unchanged with a changed Gunk file")
+	}
+}
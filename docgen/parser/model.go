@@ -20,6 +20,7 @@ type File struct {
 	Swagger  *options.Swagger
 	Services map[string]*Service
 	Enums    map[string]*Enum
+	Errors   []*ErrorCatalog
 }
 
 // SwaggerScheme gets scheme that you most probably want.
@@ -130,6 +131,9 @@ type Method struct {
 	Request   *Request
 	Response  *Response
 	Operation *options.Operation
+	// Timeout is the default timeout of the method, such as "30s", if it
+	// has one.
+	Timeout string
 }
 
 func (m *Method) HeaderID() string {
@@ -155,6 +159,15 @@ type Comment struct {
 	Detached []string
 }
 
+// Description returns the leading comment, or the trailing one if there is
+// none, such as for a field documented on its own line.
+func (c *Comment) Description() string {
+	if c.Leading != "" {
+		return c.Leading
+	}
+	return strings.TrimPrefix(strings.ReplaceAll(c.Trailing, "\n", ""), " ")
+}
+
 // Enum describes an enumeration type.
 type Enum struct {
 	Name    string
@@ -167,3 +180,20 @@ type Value struct {
 	Name    string
 	Comment *Comment
 }
+
+// ErrorCatalog describes an error catalog, being an enum with the
+// gunk.errors.catalog option.
+type ErrorCatalog struct {
+	Name    string
+	Domain  string
+	Comment *Comment
+	Errors  []*Error
+}
+
+// Error describes an error of an error catalog.
+type Error struct {
+	Reason     string
+	Code       string
+	HTTPStatus int32
+	Message    string
+}
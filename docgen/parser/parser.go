@@ -11,10 +11,13 @@ import (
 	"strings"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/protoc-gen-openapiv2/options"
+	"github.com/gunk/gunk/errorgen/errorspb"
 	"github.com/gunk/gunk/httprule"
+	"github.com/gunk/gunk/retrygen/methodpb"
 	"google.golang.org/genproto/googleapis/api/annotations"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 const (
@@ -48,6 +51,7 @@ func ParseFile(file *FileDescWrapper, onlyExternal OnlyExternalOpt) (*File, erro
 	f := &File{
 		Services: services,
 		Enums:    enums,
+		Errors:   parseErrorCatalogs(file.FileDescriptorProto),
 	}
 	if proto.HasExtension(file.GetOptions(), options.E_Openapiv2Swagger) {
 		f.Swagger = proto.GetExtension(file.GetOptions(), options.E_Openapiv2Swagger).(*options.Swagger)
@@ -55,6 +59,37 @@ func ParseFile(file *FileDescWrapper, onlyExternal OnlyExternalOpt) (*File, erro
 	return f, nil
 }
 
+// parseErrorCatalogs returns the error catalogs of file, in order.
+func parseErrorCatalogs(file *descriptorpb.FileDescriptorProto) []*ErrorCatalog {
+	var catalogs []*ErrorCatalog
+	comments := parseComments(file.GetSourceCodeInfo())
+	for i, e := range file.GetEnumType() {
+		if !proto.HasExtension(e.GetOptions(), errorspb.E_Catalog) {
+			continue
+		}
+		catalog := proto.GetExtension(e.GetOptions(), errorspb.E_Catalog).(*errorspb.Catalog)
+		c := &ErrorCatalog{
+			Name:    e.GetName(),
+			Domain:  catalog.GetDomain(),
+			Comment: nonNilComment(comments[fmt.Sprintf("%d.%d", enumFlag, i)]),
+		}
+		for _, v := range e.GetValue() {
+			if !proto.HasExtension(v.GetOptions(), errorspb.E_Error) {
+				continue
+			}
+			err := proto.GetExtension(v.GetOptions(), errorspb.E_Error).(*errorspb.Error)
+			c.Errors = append(c.Errors, &Error{
+				Reason:     v.GetName(),
+				Code:       err.GetCode(),
+				HTTPStatus: err.GetHttpStatus(),
+				Message:    err.GetMessage(),
+			})
+		}
+		catalogs = append(catalogs, c)
+	}
+	return catalogs
+}
+
 // GenerateDependencyMap recursively loops through dependencies and creates a map for their names and FileDescriptorProto
 func GenerateDependencyMap(source *descriptorpb.FileDescriptorProto, protos []*descriptorpb.FileDescriptorProto) map[string]*descriptorpb.FileDescriptorProto {
 	// get dependencies recursively
@@ -430,12 +465,16 @@ func parseMethods(pkgName string, messages map[string]*Message, methods []*descr
 				Example: example,
 			}
 		}
-		res[getQualifiedName(pkgName, m.GetName())] = &Method{
+		method := &Method{
 			Name:      m.GetName(),
 			Request:   req,
 			Response:  rsp,
 			Operation: operation,
 		}
+		if d, ok := proto.GetExtension(m.GetOptions(), methodpb.E_Timeout).(*durationpb.Duration); ok && d != nil {
+			method.Timeout = d.AsDuration().String()
+		}
+		res[getQualifiedName(pkgName, m.GetName())] = method
 	}
 	return res, nil
 }
@@ -485,6 +524,9 @@ func parseRequest(rule *annotations.HttpRule, messages map[string]*Message, name
 	case *annotations.HttpRule_Delete:
 		verb = http.MethodDelete
 		uri = p.Delete
+	case *annotations.HttpRule_Custom:
+		verb = p.Custom.GetKind()
+		uri = p.Custom.GetPath()
 	default:
 		return nil, fmt.Errorf("%t not supported", p)
 	}
@@ -3,18 +3,35 @@ package dump
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/gunk/gunk/generate"
 	"github.com/gunk/gunk/protoutil"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
-// Run will generate the FileDescriptorSet for a Gunk package, and
-// output it as required.
-func Run(format, dir string, patterns ...string) error {
-	// Load the Gunk package and generate the FileDescriptorSet for the
-	// Gunk package.
-	fds, err := generate.FileDescriptorSet(dir, patterns...)
+// Run will generate the FileDescriptorSet for the matched Gunk packages, and
+// output it as required. The opts control which files and source info
+// are included in the set.
+//
+// With the source format, the .proto file of each package is written instead,
+// to its path under out, or to stdout if out is empty. If version is set, to
+// the version of Gunk, the files say so in a gunk:version comment, and each of
+// their elements is preceded by a gunk:source comment giving the Gunk file and
+// line declaring it.
+func Run(format, dir, out, version string, opts generate.DescriptorSetOptions, patterns ...string) error {
+	if format == "source" {
+		return writeSource(dir, out, version, patterns...)
+	}
+	if version != "" {
+		return fmt.Errorf("provenance comments are only written with the source format")
+	}
+	// Load the Gunk packages and generate the FileDescriptorSet for the
+	// Gunk packages.
+	fds, err := generate.FileDescriptorSetWithOptions(opts, dir, patterns...)
 	if err != nil {
 		return err
 	}
@@ -39,3 +56,62 @@ func Run(format, dir string, patterns ...string) error {
 	_, err = os.Stdout.Write(bs)
 	return err
 }
+
+// writeSource writes the .proto files of the Gunk packages matching patterns,
+// with their comments, to their paths under out, or one after the other to
+// stdout if out is empty. Provenance comments are added if version is set.
+func writeSource(dir, out, version string, patterns ...string) error {
+	fds, names, positions, err := generate.RequestedFileDescriptorSetPositions(dir, patterns...)
+	if err != nil {
+		return err
+	}
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return err
+	}
+	p, err := protoutil.NewPrinter(files)
+	if err != nil {
+		return err
+	}
+	if version != "" {
+		p.Header = "gunk:version " + version
+		p.Provenance = func(file protoreflect.FileDescriptor, path protoreflect.SourcePath) string {
+			pos, ok := positions[file.Path()][path.String()]
+			if !ok {
+				return ""
+			}
+			return fmt.Sprintf("%s:%d", pos.Filename, pos.Line)
+		}
+	}
+	for i, name := range names {
+		fd, err := files.FindFileByPath(name)
+		if err != nil {
+			return err
+		}
+		content, err := p.PrintFile(fd)
+		if err != nil {
+			return err
+		}
+		if out != "" {
+			path := filepath.Join(out, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(path, content, 0o644); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(names) > 1 {
+			// Tell the files apart.
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("// %s\n\n", name)
+		}
+		if _, err := os.Stdout.Write(content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
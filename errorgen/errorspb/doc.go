@@ -0,0 +1,5 @@
+// Package errorspb holds the Go types of the options of
+// gunk/errors/errors.proto, which declare the error catalogs of packages.
+package errorspb
+
+//go:generate protoc -I../../assets/bundled --go_out=. --go_opt=module=github.com/gunk/gunk/errorgen/errorspb gunk/errors/errors.proto
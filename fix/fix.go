@@ -0,0 +1,173 @@
+// Package fix rewrites Gunk files which use deprecated constructs, such as
+// renamed option packages, to their current forms, much like go fix does for
+// Go code.
+package fix
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gunk/gunk/loader"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// A fix rewrites a deprecated construct in a Gunk file, reporting whether it
+// changed the file.
+type fix struct {
+	name string
+	doc  string
+	fn   func(fset *token.FileSet, file *ast.File) bool
+}
+
+// fixes are the available fixes, in the order they are applied in.
+var fixes = []fix{
+	{
+		name: "openapiv2",
+		doc:  "import github.com/gunk/opt/openapiv2 instead of github.com/gunk/opt/protoc-gen-swagger/options, its former name",
+		fn:   fixOpenAPIv2,
+	},
+	{
+		name: "allowalias",
+		doc:  "remove enum.AllowAlias tags from enums none of whose values share a number, which protoc rejects",
+		fn:   fixAllowAlias,
+	},
+}
+
+// Options are the options of Run.
+type Options struct {
+	// Fixes are the names of the fixes to apply. All of them are applied
+	// if it's empty.
+	Fixes []string
+	// Diff prints the changes to the files as unified diffs, instead of
+	// writing them.
+	Diff bool
+}
+
+// Run applies the fixes to the Gunk files of the packages matching args,
+// printing each file it fixes along with the fixes applied to it.
+func Run(w io.Writer, dir string, opts Options, args ...string) error {
+	apply, err := selectFixes(opts.Fixes)
+	if err != nil {
+		return err
+	}
+	// The packages aren't type-checked, as their deprecated imports may
+	// no longer exist.
+	fset := token.NewFileSet()
+	l := loader.Loader{Dir: dir, Fset: fset}
+	pkgs, err := l.Load(args...)
+	if err != nil {
+		return fmt.Errorf("error on loading: %w", err)
+	}
+	if len(pkgs) == 0 {
+		return fmt.Errorf("no Gunk packages to fix")
+	}
+	if loader.PrintErrors(pkgs) > 0 {
+		return fmt.Errorf("encountered package loading errors")
+	}
+	for _, pkg := range pkgs {
+		for i, file := range pkg.GunkSyntax {
+			path := pkg.GunkFiles[i]
+			var applied []string
+			for _, f := range apply {
+				if f.fn(fset, file) {
+					applied = append(applied, f.name)
+				}
+			}
+			if len(applied) == 0 {
+				continue
+			}
+			orig, err := ioutil.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("error on reading: %w", err)
+			}
+			var buf bytes.Buffer
+			if err := format.Node(&buf, fset, file); err != nil {
+				return fmt.Errorf("error on formatting %s: %w", path, err)
+			}
+			if bytes.Equal(orig, buf.Bytes()) {
+				continue
+			}
+			name := relPath(path)
+			if opts.Diff {
+				diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+					A:        difflib.SplitLines(string(orig)),
+					B:        difflib.SplitLines(buf.String()),
+					FromFile: "a/" + name,
+					ToFile:   "b/" + name,
+					Context:  3,
+				})
+				if err != nil {
+					return err
+				}
+				fmt.Fprint(w, diff)
+				continue
+			}
+			if err := ioutil.WriteFile(path, buf.Bytes(), 0o666); err != nil {
+				return fmt.Errorf("error on writing: %w", err)
+			}
+			fmt.Fprintf(w, "%s: fixed %s\n", name, strings.Join(applied, ", "))
+		}
+	}
+	return nil
+}
+
+// selectFixes returns the fixes with the given names, or all of them if there
+// are none.
+func selectFixes(names []string) ([]fix, error) {
+	if len(names) == 0 {
+		return fixes, nil
+	}
+	var selected []fix
+	for _, f := range fixes {
+		for _, name := range names {
+			if name == f.name {
+				selected = append(selected, f)
+				break
+			}
+		}
+	}
+	for _, name := range names {
+		found := false
+		for _, f := range fixes {
+			found = found || f.name == name
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown fix %q; available fixes are: %s", name, strings.Join(Names(), ", "))
+		}
+	}
+	return selected, nil
+}
+
+// Names returns the names of the available fixes.
+func Names() []string {
+	names := make([]string, len(fixes))
+	for i, f := range fixes {
+		names[i] = f.name
+	}
+	return names
+}
+
+// List prints the available fixes with their descriptions.
+func List(w io.Writer) {
+	for _, f := range fixes {
+		fmt.Fprintf(w, "%s\n\t%s\n", f.name, f.doc)
+	}
+}
+
+// relPath returns path relative to the current directory if possible.
+func relPath(path string) string {
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(path)
+}
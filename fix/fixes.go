@@ -0,0 +1,190 @@
+package fix
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+const (
+	swaggerOptionsPath = "github.com/gunk/opt/protoc-gen-swagger/options"
+	openAPIv2Path      = "github.com/gunk/opt/openapiv2"
+	enumPath           = "github.com/gunk/opt/enum"
+)
+
+// fixOpenAPIv2 imports github.com/gunk/opt/openapiv2 in place of its former
+// path, renaming the package in the tags unless the import is named.
+func fixOpenAPIv2(fset *token.FileSet, file *ast.File) bool {
+	old := importName(file, swaggerOptionsPath)
+	if old == "" {
+		return false
+	}
+	name := importName(file, openAPIv2Path)
+	if name != "" {
+		// The tags can use the existing import of the new path.
+		astutil.DeleteNamedImport(fset, file, namedImport(file, swaggerOptionsPath), swaggerOptionsPath)
+	} else {
+		astutil.RewriteImport(fset, file, swaggerOptionsPath, openAPIv2Path)
+		name = importName(file, openAPIv2Path)
+	}
+	if name != old {
+		for _, t := range fileTags(file) {
+			t.renameQualifier(old, name)
+		}
+	}
+	return true
+}
+
+// fixAllowAlias removes the enum.AllowAlias(true) tags of enums none of
+// whose values share a number, which used to be accepted, and which protoc
+// rejects. The import of github.com/gunk/opt/enum is removed along with the
+// last tag using it.
+func fixAllowAlias(fset *token.FileSet, file *ast.File) bool {
+	name := importName(file, enumPath)
+	if name == "" {
+		return false
+	}
+	numbers := enumNumbers(fset, file)
+	tags := fileTags(file)
+	changed := false
+	for i, t := range tags {
+		if pkg, typ := t.call(); pkg != name || typ != "AllowAlias" {
+			continue
+		}
+		if args := t.expr.(*ast.CallExpr).Args; len(args) != 1 || !isTrue(args[0]) {
+			continue
+		}
+		tspec := documentedType(file, t.group)
+		if tspec == nil {
+			continue
+		}
+		values, ok := numbers[tspec.Name.Name]
+		if !ok || hasAlias(values) {
+			continue
+		}
+		t.remove(file)
+		tags[i] = nil
+		changed = true
+	}
+	if changed && !usesQualifier(tags, name) {
+		astutil.DeleteNamedImport(fset, file, namedImport(file, enumPath), enumPath)
+	}
+	return changed
+}
+
+// enumNumbers returns the numbers of the values of the enums declared in
+// file, by the names of the enums. The file is type-checked on its own, so
+// only the values declared in it are known, as when it's generated.
+func enumNumbers(fset *token.FileSet, file *ast.File) map[string][]int64 {
+	conf := types.Config{
+		// The imports are only used by the tags, or for the types of
+		// fields, which don't matter here.
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			return types.NewPackage(path, path[strings.LastIndex(path, "/")+1:]), nil
+		}),
+		Error: func(error) {},
+	}
+	info := &types.Info{Defs: make(map[*ast.Ident]types.Object)}
+	pkg, _ := conf.Check(file.Name.Name, fset, []*ast.File{file}, info)
+	numbers := make(map[string][]int64)
+	for _, decl := range file.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			for _, ident := range spec.(*ast.ValueSpec).Names {
+				cnst, ok := info.Defs[ident].(*types.Const)
+				if !ok {
+					continue
+				}
+				named, ok := cnst.Type().(*types.Named)
+				if !ok || named.Obj().Pkg() != pkg {
+					continue
+				}
+				n, _ := constant.Int64Val(cnst.Val())
+				typ := named.Obj().Name()
+				numbers[typ] = append(numbers[typ], n)
+			}
+		}
+	}
+	return numbers
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
+
+// isTrue reports whether expr is the true constant.
+func isTrue(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == "true"
+}
+
+// hasAlias reports whether any of the numbers are repeated.
+func hasAlias(numbers []int64) bool {
+	seen := make(map[int64]bool, len(numbers))
+	for _, n := range numbers {
+		if seen[n] {
+			return true
+		}
+		seen[n] = true
+	}
+	return false
+}
+
+// documentedType returns the type spec documented by group, which is the doc
+// of either the spec or of its declaration if it declares a single type.
+func documentedType(file *ast.File, group *ast.CommentGroup) *ast.TypeSpec {
+	for _, decl := range file.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			tspec := spec.(*ast.TypeSpec)
+			if tspec.Doc == group || (len(gd.Specs) == 1 && gd.Doc == group) {
+				return tspec
+			}
+		}
+	}
+	return nil
+}
+
+// usesQualifier reports whether any of the tags, skipping nil ones, refers to
+// the package imported as name.
+func usesQualifier(tags []*tag, name string) bool {
+	for _, t := range tags {
+		if t == nil || t.expr == nil {
+			continue
+		}
+		found := false
+		ast.Inspect(t.expr, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if x, ok := sel.X.(*ast.Ident); ok && x.Name == name {
+					found = true
+				}
+			}
+			return !found
+		})
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// namedImport returns the explicit name file imports path with, if any.
+func namedImport(file *ast.File, path string) string {
+	for _, imp := range file.Imports {
+		if p, _ := strconv.Unquote(imp.Path.Value); p == path && imp.Name != nil {
+			return imp.Name.Name
+		}
+	}
+	return ""
+}
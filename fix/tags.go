@@ -0,0 +1,195 @@
+package fix
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// tag is a +gunk tag in a group of line comments. It spans the comment
+// starting with "+gunk" and those following it, up to the next tag, as the
+// loader splits them.
+type tag struct {
+	group    *ast.CommentGroup
+	comments []*ast.Comment
+	// markers holds the length of the comment marker of each comment,
+	// such as "// ", which isn't part of the tag's source.
+	markers []int
+	// src is the source of the tag's expression, with "+gunk" replaced by
+	// spaces.
+	src  string
+	fset *token.FileSet
+	// expr is nil if the tag isn't a valid expression.
+	expr ast.Expr
+}
+
+// fileTags returns the +gunk tags of the line comments of file.
+func fileTags(file *ast.File) []*tag {
+	var tags []*tag
+	for _, group := range file.Comments {
+		var cur *tag
+		for _, c := range group.List {
+			if !strings.HasPrefix(c.Text, "//") {
+				cur = nil
+				continue
+			}
+			marker := len("//")
+			if strings.HasPrefix(c.Text, "// ") {
+				marker++
+			}
+			line := c.Text[marker:]
+			if strings.HasPrefix(line, "+gunk ") {
+				cur = &tag{group: group}
+				tags = append(tags, cur)
+				line = strings.Replace(line, "+gunk", "     ", 1)
+			} else if cur == nil {
+				continue
+			}
+			cur.comments = append(cur.comments, c)
+			cur.markers = append(cur.markers, marker)
+			if cur.src != "" {
+				cur.src += "\n"
+			}
+			cur.src += line
+		}
+	}
+	for _, t := range tags {
+		t.fset = token.NewFileSet()
+		t.expr, _ = parser.ParseExprFrom(t.fset, "", t.src, 0)
+	}
+	return tags
+}
+
+// call returns the name of the tag's type, qualified by the name its package
+// is imported with, such as "enum" and "AllowAlias" for a tag
+// "enum.AllowAlias(true)".
+func (t *tag) call() (pkg, name string) {
+	call, ok := t.expr.(*ast.CallExpr)
+	if !ok {
+		return "", ""
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return "", ""
+	}
+	x, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return "", ""
+	}
+	return x.Name, sel.Sel.Name
+}
+
+// renameQualifier renames the package qualifier old to new in the tag, such
+// as "options.Swagger" to "openapiv2.Swagger", reporting whether it did.
+func (t *tag) renameQualifier(old, new string) bool {
+	if t.expr == nil {
+		return false
+	}
+	var offsets []int
+	ast.Inspect(t.expr, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok && x.Name == old {
+				offsets = append(offsets, t.fset.Position(x.Pos()).Offset)
+			}
+		}
+		return true
+	})
+	// Edit the comments from the end, so that the offsets of the earlier
+	// qualifiers still hold.
+	for i := len(offsets) - 1; i >= 0; i-- {
+		line, col := t.lineCol(offsets[i])
+		c := t.comments[line]
+		at := t.markers[line] + col
+		c.Text = c.Text[:at] + new + c.Text[at+len(old):]
+	}
+	return len(offsets) > 0
+}
+
+// lineCol returns the line of the tag, and the column within it, of an offset
+// in its source.
+func (t *tag) lineCol(offset int) (line, col int) {
+	start := 0
+	for line = 0; line < len(t.comments)-1; line++ {
+		end := start + len(t.comments[line].Text) - t.markers[line] + len("\n")
+		if offset < end {
+			break
+		}
+		start = end
+	}
+	return line, offset - start
+}
+
+// remove removes the tag's comments from its group, and the group from file
+// and from the node it documents if no comments are left in it.
+func (t *tag) remove(file *ast.File) {
+	removed := make(map[*ast.Comment]bool, len(t.comments))
+	for _, c := range t.comments {
+		removed[c] = true
+	}
+	orig := t.group.List
+	var list []*ast.Comment
+	for _, c := range orig {
+		if !removed[c] {
+			list = append(list, c)
+		}
+	}
+	// Move the comments left down to the positions of the last ones, so
+	// that no gap is left between them and the node they document.
+	for i, c := range list {
+		c.Slash = orig[len(orig)-len(list)+i].Slash
+	}
+	t.group.List = list
+	if len(list) > 0 {
+		return
+	}
+	comments := file.Comments[:0]
+	for _, group := range file.Comments {
+		if group != t.group {
+			comments = append(comments, group)
+		}
+	}
+	file.Comments = comments
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.File:
+			if n.Doc == t.group {
+				n.Doc = nil
+			}
+		case *ast.GenDecl:
+			if n.Doc == t.group {
+				n.Doc = nil
+			}
+		case *ast.TypeSpec:
+			if n.Doc == t.group {
+				n.Doc = nil
+			}
+		case *ast.ValueSpec:
+			if n.Doc == t.group {
+				n.Doc = nil
+			}
+		case *ast.Field:
+			if n.Doc == t.group {
+				n.Doc = nil
+			}
+		}
+		return true
+	})
+}
+
+// importName returns the name file imports path with, or "" if it doesn't
+// import it. Packages of github.com/gunk/opt are named after the last
+// element of their path.
+func importName(file *ast.File, path string) string {
+	for _, imp := range file.Imports {
+		if p, _ := strconv.Unquote(imp.Path.Value); p != path {
+			continue
+		}
+		if imp.Name != nil {
+			return imp.Name.Name
+		}
+		return path[strings.LastIndex(path, "/")+1:]
+	}
+	return ""
+}
@@ -0,0 +1,515 @@
+// Package fixtures loads YAML or JSON fixture files into protobuf messages,
+// following the descriptors of their Gunk packages. The fixtures are checked
+// against the messages as they are decoded, and errors point at the line and
+// field they are about.
+//
+// A fixture file holds either a single message or a list of messages, written
+// with the protobuf JSON mapping; the fields may be named after either their
+// JSON name or their name. As JSON is YAML, JSON fixtures are read the same
+// way.
+package fixtures
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"gopkg.in/yaml.v3"
+)
+
+// Loader loads fixtures into the messages it knows the types of.
+type Loader struct {
+	types *protoregistry.Types
+}
+
+// Default loads fixtures into the generated messages, as registered in
+// protoregistry.GlobalTypes.
+var Default = &Loader{types: protoregistry.GlobalTypes}
+
+// New returns a Loader of dynamicpb messages, of the types declared in fds,
+// which must include all their dependencies, as with gunk generate's
+// FileDescriptorSet.
+func New(fds *descriptorpb.FileDescriptorSet) (*Loader, error) {
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, err
+	}
+	types := new(protoregistry.Types)
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		err = registerTypes(types, fd.Messages(), fd.Enums(), fd.Extensions())
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Loader{types: types}, nil
+}
+
+// registerTypes registers the dynamicpb types of messages, enums and
+// extensions in types, along with the ones nested in the messages.
+func registerTypes(types *protoregistry.Types, messages protoreflect.MessageDescriptors, enums protoreflect.EnumDescriptors, extensions protoreflect.ExtensionDescriptors) error {
+	for i := 0; i < enums.Len(); i++ {
+		if err := types.RegisterEnum(dynamicpb.NewEnumType(enums.Get(i))); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < extensions.Len(); i++ {
+		if err := types.RegisterExtension(dynamicpb.NewExtensionType(extensions.Get(i))); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < messages.Len(); i++ {
+		md := messages.Get(i)
+		if err := types.RegisterMessage(dynamicpb.NewMessageType(md)); err != nil {
+			return err
+		}
+		if err := registerTypes(types, md.Messages(), md.Enums(), md.Extensions()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load reads the fixture file at path, holding one or more messages of the
+// type named message, such as "util.Message".
+func (l *Loader) Load(path string, message protoreflect.FullName) ([]proto.Message, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return l.Unmarshal(path, data, message)
+}
+
+// Unmarshal decodes the fixture data, holding one or more messages of the type
+// named message. name is the name of the fixture in errors, such as its path.
+func (l *Loader) Unmarshal(name string, data []byte, message protoreflect.FullName) ([]proto.Message, error) {
+	mt, err := l.types.FindMessageByName(message)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	node, err := parse(name, data)
+	if err != nil {
+		return nil, err
+	}
+	d := &decoder{name: name, types: l.types}
+	if node.Kind != yaml.SequenceNode {
+		m := mt.New()
+		if err := d.message(node, string(message), m); err != nil {
+			return nil, err
+		}
+		return []proto.Message{m.Interface()}, nil
+	}
+	var msgs []proto.Message
+	for i, elem := range node.Content {
+		m := mt.New()
+		if err := d.message(elem, fmt.Sprintf("[%d]", i), m); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m.Interface())
+	}
+	return msgs, nil
+}
+
+// LoadFile reads the fixture file at path, holding a single message, into m.
+func LoadFile(path string, m proto.Message) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return Unmarshal(path, data, m)
+}
+
+// Unmarshal decodes the fixture data, holding a single message, into m. name
+// is the name of the fixture in errors, such as its path. The messages of Any
+// fields are resolved with protoregistry.GlobalTypes.
+func Unmarshal(name string, data []byte, m proto.Message) error {
+	node, err := parse(name, data)
+	if err != nil {
+		return err
+	}
+	proto.Reset(m)
+	d := &decoder{name: name, types: protoregistry.GlobalTypes}
+	mr := m.ProtoReflect()
+	return d.message(node, string(mr.Descriptor().FullName()), mr)
+}
+
+// parse parses the YAML document of a fixture.
+func parse(name string, data []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("%s: empty fixture", name)
+	}
+	return resolve(doc.Content[0]), nil
+}
+
+// resolve returns the node an alias refers to, or node if it isn't one.
+func resolve(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+// Error is an error in a fixture.
+type Error struct {
+	// Name is the name of the fixture.
+	Name string
+	// Line and Column are the position of the value in error.
+	Line, Column int
+	// Path is the path of the value in error, such as
+	// "util.Message.labels[foo]".
+	Path string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %v", e.Name, e.Line, e.Column, e.Path, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// decoder decodes the nodes of a fixture into messages.
+type decoder struct {
+	name  string
+	types *protoregistry.Types
+}
+
+// errorf returns an Error about node, at path.
+func (d *decoder) errorf(node *yaml.Node, path, format string, args ...interface{}) error {
+	return &Error{
+		Name:   d.name,
+		Line:   node.Line,
+		Column: node.Column,
+		Path:   path,
+		Err:    fmt.Errorf(format, args...),
+	}
+}
+
+// message decodes node into m.
+func (d *decoder) message(node *yaml.Node, path string, m protoreflect.Message) error {
+	node = resolve(node)
+	md := m.Descriptor()
+	if wellKnownFiles[md.ParentFile().Path()] {
+		return d.wellKnown(node, path, m)
+	}
+	if node.Kind != yaml.MappingNode {
+		return d.errorf(node, path, "%s must be a mapping", md.FullName())
+	}
+	fields := md.Fields()
+	seen := make(map[protoreflect.FieldNumber]bool)
+	seenOneofs := make(map[protoreflect.FullName]string)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := resolve(node.Content[i]), resolve(node.Content[i+1])
+		fd := fields.ByJSONName(key.Value)
+		if fd == nil {
+			fd = fields.ByName(protoreflect.Name(key.Value))
+		}
+		if fd == nil {
+			return d.errorf(key, path, "unknown field %q of %s", key.Value, md.FullName())
+		}
+		fieldPath := path + "." + fd.JSONName()
+		if seen[fd.Number()] {
+			return d.errorf(key, fieldPath, "duplicate field")
+		}
+		seen[fd.Number()] = true
+		if isNull(value) && !nullable(fd) {
+			continue
+		}
+		if od := fd.ContainingOneof(); od != nil && !od.IsSynthetic() {
+			if other, ok := seenOneofs[od.FullName()]; ok {
+				return d.errorf(key, fieldPath, "oneof %s is already set by %s", od.Name(), other)
+			}
+			seenOneofs[od.FullName()] = fd.JSONName()
+		}
+		var err error
+		switch {
+		case fd.IsMap():
+			err = d.mapField(value, fieldPath, m.Mutable(fd).Map(), fd)
+		case fd.IsList():
+			err = d.listField(value, fieldPath, m.Mutable(fd).List(), fd)
+		case fd.Message() != nil:
+			err = d.message(value, fieldPath, m.Mutable(fd).Message())
+		default:
+			var v protoreflect.Value
+			v, err = d.scalar(value, fieldPath, fd)
+			if err == nil {
+				m.Set(fd, v)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listField decodes the elements of the list field fd from node into list.
+func (d *decoder) listField(node *yaml.Node, path string, list protoreflect.List, fd protoreflect.FieldDescriptor) error {
+	if node.Kind != yaml.SequenceNode {
+		return d.errorf(node, path, "must be a list")
+	}
+	for i, elem := range node.Content {
+		elem = resolve(elem)
+		elemPath := fmt.Sprintf("%s[%d]", path, i)
+		if fd.Message() != nil {
+			v := list.NewElement()
+			if err := d.message(elem, elemPath, v.Message()); err != nil {
+				return err
+			}
+			list.Append(v)
+			continue
+		}
+		v, err := d.scalar(elem, elemPath, fd)
+		if err != nil {
+			return err
+		}
+		list.Append(v)
+	}
+	return nil
+}
+
+// mapField decodes the entries of the map field fd from node into mp.
+func (d *decoder) mapField(node *yaml.Node, path string, mp protoreflect.Map, fd protoreflect.FieldDescriptor) error {
+	if node.Kind != yaml.MappingNode {
+		return d.errorf(node, path, "must be a mapping")
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := resolve(node.Content[i]), resolve(node.Content[i+1])
+		entryPath := fmt.Sprintf("%s[%s]", path, key.Value)
+		k, err := d.scalar(key, entryPath, fd.MapKey())
+		if err != nil {
+			return err
+		}
+		mk := k.MapKey()
+		if mp.Has(mk) {
+			return d.errorf(key, entryPath, "duplicate key")
+		}
+		if fd.MapValue().Message() != nil {
+			v := mp.NewValue()
+			if err := d.message(value, entryPath, v.Message()); err != nil {
+				return err
+			}
+			mp.Set(mk, v)
+			continue
+		}
+		v, err := d.scalar(value, entryPath, fd.MapValue())
+		if err != nil {
+			return err
+		}
+		mp.Set(mk, v)
+	}
+	return nil
+}
+
+// scalar decodes the value of node for the non-message field fd.
+func (d *decoder) scalar(node *yaml.Node, path string, fd protoreflect.FieldDescriptor) (protoreflect.Value, error) {
+	if node.Kind != yaml.ScalarNode {
+		return protoreflect.Value{}, d.errorf(node, path, "must be a %s", fd.Kind())
+	}
+	s := node.Value
+	var v protoreflect.Value
+	var err error
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		var b bool
+		b, err = strconv.ParseBool(s)
+		v = protoreflect.ValueOfBool(b)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, 32)
+		v = protoreflect.ValueOfInt32(int32(n))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, 64)
+		v = protoreflect.ValueOfInt64(n)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		var n uint64
+		n, err = strconv.ParseUint(s, 10, 32)
+		v = protoreflect.ValueOfUint32(uint32(n))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		var n uint64
+		n, err = strconv.ParseUint(s, 10, 64)
+		v = protoreflect.ValueOfUint64(n)
+	case protoreflect.FloatKind:
+		var f float64
+		f, err = parseFloat(s, 32)
+		v = protoreflect.ValueOfFloat32(float32(f))
+	case protoreflect.DoubleKind:
+		var f float64
+		f, err = parseFloat(s, 64)
+		v = protoreflect.ValueOfFloat64(f)
+	case protoreflect.StringKind:
+		v = protoreflect.ValueOfString(s)
+	case protoreflect.BytesKind:
+		var b []byte
+		b, err = decodeBytes(s)
+		v = protoreflect.ValueOfBytes(b)
+	case protoreflect.EnumKind:
+		return d.enum(node, path, fd.Enum())
+	}
+	if err != nil {
+		return protoreflect.Value{}, d.errorf(node, path, "invalid %s %q", fd.Kind(), s)
+	}
+	return v, nil
+}
+
+// enum decodes the value of node for the enum ed, given either by name or by
+// number.
+func (d *decoder) enum(node *yaml.Node, path string, ed protoreflect.EnumDescriptor) (protoreflect.Value, error) {
+	if ed.FullName() == "google.protobuf.NullValue" && isNull(node) {
+		return protoreflect.ValueOfEnum(0), nil
+	}
+	values := ed.Values()
+	if n, err := strconv.ParseInt(node.Value, 10, 32); err == nil {
+		if values.ByNumber(protoreflect.EnumNumber(n)) == nil {
+			return protoreflect.Value{}, d.errorf(node, path, "unknown number %d of %s", n, ed.FullName())
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	}
+	if vd := values.ByName(protoreflect.Name(node.Value)); vd != nil {
+		return protoreflect.ValueOfEnum(vd.Number()), nil
+	}
+	names := make([]string, values.Len())
+	for i := range names {
+		names[i] = string(values.Get(i).Name())
+	}
+	return protoreflect.Value{}, d.errorf(node, path, "unknown value %q of %s, must be one of %s", node.Value, ed.FullName(), strings.Join(names, ", "))
+}
+
+// wellKnownFiles holds the files of the well-known types with a special JSON
+// mapping.
+var wellKnownFiles = map[string]bool{
+	"google/protobuf/any.proto":        true,
+	"google/protobuf/duration.proto":   true,
+	"google/protobuf/empty.proto":      true,
+	"google/protobuf/field_mask.proto": true,
+	"google/protobuf/struct.proto":     true,
+	"google/protobuf/timestamp.proto":  true,
+	"google/protobuf/wrappers.proto":   true,
+}
+
+// wellKnown decodes node into the well-known type m, such as a Timestamp or a
+// Struct, with its JSON mapping.
+func (d *decoder) wellKnown(node *yaml.Node, path string, m protoreflect.Message) error {
+	v, err := jsonValue(node)
+	if err != nil {
+		return d.errorf(node, path, "%v", err)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return d.errorf(node, path, "%v", err)
+	}
+	opts := protojson.UnmarshalOptions{Resolver: d.types}
+	if err := opts.Unmarshal(data, m.Interface()); err != nil {
+		// protojson's errors start with its name and a position
+		// in the JSON, which don't help here.
+		// It randomizes the space after its name too.
+		msg := strings.TrimPrefix(err.Error(), "proto:")
+		msg = strings.TrimLeft(msg, " \u00a0")
+		if i := strings.Index(msg, "): "); strings.HasPrefix(msg, "(line ") && i >= 0 {
+			msg = msg[i+len("): "):]
+		}
+		return d.errorf(node, path, "invalid %s: %s", m.Descriptor().FullName(), msg)
+	}
+	return nil
+}
+
+// jsonValue returns the JSON value of node, to be marshaled with
+// encoding/json.
+func jsonValue(node *yaml.Node) (interface{}, error) {
+	node = resolve(node)
+	switch node.Kind {
+	case yaml.MappingNode:
+		obj := make(map[string]interface{})
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v, err := jsonValue(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			obj[resolve(node.Content[i]).Value] = v
+		}
+		return obj, nil
+	case yaml.SequenceNode:
+		arr := make([]interface{}, 0, len(node.Content))
+		for _, elem := range node.Content {
+			v, err := jsonValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	}
+	switch node.Tag {
+	case "!!null":
+		return nil, nil
+	case "!!bool", "!!int", "!!float":
+		var v interface{}
+		if err := node.Decode(&v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return node.Value, nil
+}
+
+// isNull reports whether node is a YAML null.
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
+}
+
+// nullable reports whether a null is a value of fd, rather than leaving it
+// unset, as with google.protobuf.Value.
+func nullable(fd protoreflect.FieldDescriptor) bool {
+	if fd.IsList() || fd.IsMap() {
+		return false
+	}
+	if md := fd.Message(); md != nil {
+		return md.FullName() == "google.protobuf.Value"
+	}
+	if ed := fd.Enum(); ed != nil {
+		return ed.FullName() == "google.protobuf.NullValue"
+	}
+	return false
+}
+
+// parseFloat parses a float, also accepting the special values of both the
+// protobuf JSON mapping and YAML.
+func parseFloat(s string, bitSize int) (float64, error) {
+	switch s {
+	case "NaN", ".nan", ".NaN", ".NAN":
+		return math.NaN(), nil
+	case "Infinity", ".inf", ".Inf", ".INF", "+.inf", "+.Inf", "+.INF":
+		return math.Inf(1), nil
+	case "-Infinity", "-.inf", "-.Inf", "-.INF":
+		return math.Inf(-1), nil
+	}
+	return strconv.ParseFloat(s, bitSize)
+}
+
+// decodeBytes decodes base64 bytes, either standard or URL-safe and padded or
+// not, as the protobuf JSON mapping does.
+func decodeBytes(s string) ([]byte, error) {
+	enc := base64.StdEncoding
+	if strings.ContainsAny(s, "-_") {
+		enc = base64.URLEncoding
+	}
+	if len(s)%4 != 0 {
+		enc = enc.WithPadding(base64.NoPadding)
+	}
+	return enc.DecodeString(s)
+}
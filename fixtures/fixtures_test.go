@@ -0,0 +1,218 @@
+package fixtures
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/typepb"
+)
+
+func TestUnmarshal(t *testing.T) {
+	data := []byte(`
+name: util.Message
+fields:
+  - kind: TYPE_STRING
+    cardinality: CARDINALITY_OPTIONAL
+    number: 1
+    name: name
+    json_name: name
+  - &repeated
+    kind: 9
+    cardinality: CARDINALITY_REPEATED
+    number: 2
+    jsonName: tags
+oneofs: [kind]
+sourceContext: null
+syntax: SYNTAX_PROTO3
+`)
+	want := &typepb.Type{
+		Name: "util.Message",
+		Fields: []*typepb.Field{{
+			Kind:        typepb.Field_TYPE_STRING,
+			Cardinality: typepb.Field_CARDINALITY_OPTIONAL,
+			Number:      1,
+			Name:        "name",
+			JsonName:    "name",
+		}, {
+			Kind:        typepb.Field_TYPE_STRING,
+			Cardinality: typepb.Field_CARDINALITY_REPEATED,
+			Number:      2,
+			JsonName:    "tags",
+		}},
+		Oneofs: []string{"kind"},
+		Syntax: typepb.Syntax_SYNTAX_PROTO3,
+	}
+	got := &typepb.Type{Name: "reset"}
+	if err := Unmarshal("type.yaml", data, got); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// utilFiles returns the FileDescriptorSet of a util package, with its
+// dependencies.
+func utilFiles() *descriptorpb.FileDescriptorSet {
+	util := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("testdata.tld/util/all.proto"),
+		Package:    proto.String("util"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Message"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				field("status", 2, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".util.Status"),
+				field("created_at", 3, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Timestamp"),
+				field("size", 4, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+				field("ratio", 5, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, ""),
+				field("data", 6, descriptorpb.FieldDescriptorProto_TYPE_BYTES, ""),
+				repeated(field("labels", 7, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".util.Message.LabelsEntry")),
+				repeated(field("replies", 8, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".util.Message")),
+			},
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("LabelsEntry"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+				},
+				Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+			}},
+		}},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Status"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("Draft"), Number: proto.Int32(0)},
+				{Name: proto.String("Sent"), Number: proto.Int32(1)},
+			},
+		}},
+	}
+	return &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto),
+		util,
+	}}
+}
+
+func field(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+	fd := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:   typ.Enum(),
+	}
+	if typeName != "" {
+		fd.TypeName = proto.String(typeName)
+	}
+	return fd
+}
+
+func repeated(fd *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+	fd.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	return fd
+}
+
+func TestLoaderUnmarshal(t *testing.T) {
+	l, err := New(utilFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(`[
+  {
+    "name": "first",
+    "status": "Sent",
+    "createdAt": "2021-06-01T10:00:00Z",
+    "size": "12",
+    "ratio": "NaN",
+    "data": "aGk",
+    "labels": {"a": 1, "b": 2},
+    "replies": [{"name": "reply", "status": 1}]
+  },
+  {"name": "second"}
+]`)
+	msgs, err := l.Unmarshal("messages.json", data, "util.Message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range msgs {
+		b, err := protojson.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(b))
+	}
+	want := []string{
+		`{"name":"first","status":"Sent","createdAt":"2021-06-01T10:00:00Z","size":"12","ratio":"NaN","data":"aGk=","labels":{"a":1,"b":2},"replies":[{"name":"reply","status":"Sent"}]}`,
+		`{"name":"second"}`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(got), len(want))
+	}
+	for i := range got {
+		// protojson randomizes its spacing.
+		if compact(got[i]) != want[i] {
+			t.Errorf("message %d: got %s, want %s", i, got[i], want[i])
+		}
+	}
+}
+
+func compact(s string) string {
+	var b []byte
+	inString := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' && (i == 0 || s[i-1] != '\\'):
+			inString = !inString
+		case !inString && (c == ' ' || c == '\n'):
+			continue
+		}
+		b = append(b, c)
+	}
+	return string(b)
+}
+
+func TestLoaderErrors(t *testing.T) {
+	l, err := New(utilFiles())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"", "f.yaml: empty fixture"},
+		{"name: [", "f.yaml: yaml: line 1: did not find expected node content"},
+		{"- 1", "f.yaml:1:3: [0]: util.Message must be a mapping"},
+		{"nme: foo", `f.yaml:1:1: util.Message: unknown field "nme" of util.Message`},
+		{"name: a\nname: b", "f.yaml:2:1: util.Message.name: duplicate field"},
+		{"status: Snt", `f.yaml:1:9: util.Message.status: unknown value "Snt" of util.Status, must be one of Draft, Sent`},
+		{"status: 7", "f.yaml:1:9: util.Message.status: unknown number 7 of util.Status"},
+		{"size: 1.5", `f.yaml:1:7: util.Message.size: invalid int64 "1.5"`},
+		{"data: '%%'", `f.yaml:1:7: util.Message.data: invalid bytes "%%"`},
+		{"name: [a]", "f.yaml:1:7: util.Message.name: must be a string"},
+		{"labels: {a: x}", `f.yaml:1:13: util.Message.labels[a]: invalid int32 "x"`},
+		{"labels: {a: 1, a: 2}", "f.yaml:1:16: util.Message.labels[a]: duplicate key"},
+		{"replies: {name: a}", "f.yaml:1:10: util.Message.replies: must be a list"},
+		{"replies:\n  - name: a\n  - status: Snt", `f.yaml:3:13: util.Message.replies[1].status: unknown value "Snt" of util.Status, must be one of Draft, Sent`},
+		{"createdAt: yesterday", `f.yaml:1:12: util.Message.createdAt: invalid google.protobuf.Timestamp: invalid google.protobuf.Timestamp value "yesterday"`},
+	}
+	for _, tc := range tests {
+		_, err := l.Unmarshal("f.yaml", []byte(tc.data), "util.Message")
+		if err == nil {
+			t.Errorf("%q: got no error, want %q", tc.data, tc.want)
+			continue
+		}
+		if got := err.Error(); got != tc.want {
+			t.Errorf("%q: got error %q, want %q", tc.data, got, tc.want)
+		}
+	}
+	if _, err := l.Unmarshal("f.yaml", []byte("name: a"), "util.Missing"); err == nil {
+		t.Errorf("got no error for an unknown message")
+	}
+}
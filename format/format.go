@@ -6,11 +6,12 @@ import (
 	"go/ast"
 	"go/format"
 	"go/parser"
-	"go/printer"
 	"go/token"
 	"io/ioutil"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/gunk/gunk/loader"
 )
@@ -29,6 +30,10 @@ func Run(dir string, args ...string) error {
 	if loader.PrintErrors(pkgs) > 0 {
 		return fmt.Errorf("encountered package loading errors")
 	}
+	// The fields of option tags are sorted as their types declare them,
+	// which are loaded apart so that packages with type errors can still
+	// be formatted.
+	loaded := loadOptionPackages(dir, fset, pkgs)
 	for _, pkg := range pkgs {
 		for i, file := range pkg.GunkSyntax {
 			path := pkg.GunkFiles[i]
@@ -36,7 +41,7 @@ func Run(dir string, args ...string) error {
 			if err != nil {
 				return fmt.Errorf("error on reading: %w", err)
 			}
-			got, err := formatFile(fset, file)
+			got, err := formatFile(fset, file, fileOptionTypes(file, loaded))
 			if err != nil {
 				return fmt.Errorf("error on formating: %w", err)
 			}
@@ -51,17 +56,22 @@ func Run(dir string, args ...string) error {
 }
 
 // Source canonically formats a single Gunk file, returning the result and any
-// error encountered.
+// error encountered. Since the types of its option tags aren't loaded, their
+// fields are kept in order.
 func Source(src []byte) ([]byte, error) {
 	fset := token.NewFileSet()
 	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
 	if err != nil {
 		return nil, err
 	}
-	return formatFile(fset, file)
+	return formatFile(fset, file, nil)
 }
 
-func formatFile(fset *token.FileSet, file *ast.File) (_ []byte, formatErr error) {
+// formatFile formats file: its imports are sorted, the fields of its
+// structs are numbered and their tags are put in canonical order, and its
+// +gunk tags are formatted, with the fields of their struct literals sorted
+// as declared if opts holds their types.
+func formatFile(fset *token.FileSet, file *ast.File, opts optionTypes) (_ []byte, formatErr error) {
 	// Use custom panic values to report errors from the inspect func,
 	// since that's the easiest way to immediately halt the process and
 	// return the error.
@@ -75,19 +85,31 @@ func formatFile(fset *token.FileSet, file *ast.File) (_ []byte, formatErr error)
 			}
 		}
 	}()
+	// The members of oneof groups are numbered along with the struct
+	// declaring the group, so they must not be numbered on their own.
+	oneofs := make(map[*ast.StructType]bool)
 	ast.Inspect(file, func(node ast.Node) bool {
 		switch node := node.(type) {
 		case *ast.CommentGroup:
-			if err := formatComment(fset, node); err != nil {
+			if err := formatComment(fset, node, opts); err != nil {
 				panic(inspectError{err})
 			}
 		case *ast.StructType:
+			if oneofs[node] || node.Fields == nil {
+				break
+			}
+			for _, f := range node.Fields.List {
+				if loader.IsOneof(f) {
+					oneofs[f.Type.(*ast.StructType)] = true
+				}
+			}
 			if err := formatStruct(fset, node); err != nil {
 				panic(inspectError{err})
 			}
 		}
 		return true
 	})
+	ast.SortImports(fset, file)
 	var buf bytes.Buffer
 	if err := format.Node(&buf, fset, file); err != nil {
 		return nil, err
@@ -95,7 +117,7 @@ func formatFile(fset *token.FileSet, file *ast.File) (_ []byte, formatErr error)
 	return buf.Bytes(), nil
 }
 
-func formatComment(fset *token.FileSet, group *ast.CommentGroup) error {
+func formatComment(fset *token.FileSet, group *ast.CommentGroup, opts optionTypes) error {
 	// Split the gunk tag ourselves, so we can support Source.
 	doc, tags, err := loader.SplitGunkTag(nil, fset, group)
 	if err != nil {
@@ -111,14 +133,11 @@ func formatComment(fset *token.FileSet, group *ast.CommentGroup) error {
 		doc += "\n\n"
 	}
 	for i, tag := range tags {
-		var buf bytes.Buffer
-		// Print with space indentation, since all comment lines begin
-		// with "// " and we don't want to mix spaces and tabs.
-		config := printer.Config{Mode: printer.UseSpaces, Tabwidth: 8}
-		if err := config.Fprint(&buf, fset, tag.Expr); err != nil {
+		src, err := formatTag(fset, tag.Expr, opts)
+		if err != nil {
 			return err
 		}
-		doc += "+gunk " + buf.String()
+		doc += "+gunk " + src
 		if i < len(tags)-1 {
 			doc += "\n"
 		}
@@ -133,9 +152,10 @@ func formatStruct(fset *token.FileSet, st *ast.StructType) error {
 	}
 	// Find which struct fields require sequence numbers, and
 	// keep a record of which sequence numbers are already used.
+	fields := loader.NumberedFields(st)
 	usedSequences := []int{}
 	fieldsWithoutSequence := []*ast.Field{}
-	for _, f := range st.Fields.List {
+	for _, f := range fields {
 		tag := f.Tag
 		if tag == nil {
 			fieldsWithoutSequence = append(fieldsWithoutSequence, f)
@@ -158,6 +178,7 @@ func formatStruct(fset *token.FileSet, st *ast.StructType) error {
 			return fmt.Errorf("%s: struct field tag for pb was empty, please remove or add sequence number", errorPos)
 		}
 		// If there isn't a number in 'pb' then return an error.
+		val, _ = loader.SplitPBTag(val)
 		i, err := strconv.Atoi(val)
 		if err != nil {
 			errorPos := fset.Position(tag.Pos())
@@ -169,7 +190,7 @@ func formatStruct(fset *token.FileSet, st *ast.StructType) error {
 	}
 	// Determine missing sequences.
 	missingSequences := []int{}
-	for i := 1; i < len(st.Fields.List)+1; i++ {
+	for i := 1; i < len(fields)+1; i++ {
 		found := false
 		for _, u := range usedSequences {
 			if u == i {
@@ -199,5 +220,75 @@ func formatStruct(fset *token.FileSet, st *ast.StructType) error {
 			f.Tag.Value = fmt.Sprintf("`pb:\"%d\" %s`", nextSequence, tagValueStr)
 		}
 	}
+	for _, f := range st.Fields.List {
+		if f.Tag != nil {
+			f.Tag.Value = canonicalStructTag(f.Tag.Value)
+		}
+	}
 	return nil
 }
+
+// structTagOrder are the keys of struct tags which come first, in order.
+var structTagOrder = []string{"pb", "json"}
+
+// canonicalStructTag returns the struct tag literal lit with its pb and json
+// keys first, followed by the other keys in their order, separated by single
+// spaces. Tags which don't follow the conventional format are left as they
+// are.
+func canonicalStructTag(lit string) string {
+	str, err := strconv.Unquote(lit)
+	if err != nil {
+		return lit
+	}
+	type pair struct{ key, value string }
+	var pairs []pair
+	for tag := str; ; {
+		tag = strings.TrimLeft(tag, " ")
+		if tag == "" {
+			break
+		}
+		// As parsed by reflect.StructTag.Lookup.
+		i := 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			return lit
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			return lit
+		}
+		pairs = append(pairs, pair{key, tag[:i+1]})
+		tag = tag[i+1:]
+	}
+	rank := func(key string) int {
+		for i, k := range structTagOrder {
+			if k == key {
+				return i
+			}
+		}
+		return len(structTagOrder)
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return rank(pairs[i].key) < rank(pairs[j].key) })
+	parts := make([]string, len(pairs))
+	for i, p := range pairs {
+		parts[i] = p.key + ":" + p.value
+	}
+	canonical := strings.Join(parts, " ")
+	if canonical == str {
+		return lit
+	}
+	if strings.Contains(canonical, "`") {
+		return strconv.Quote(canonical)
+	}
+	return "`" + canonical + "`"
+}
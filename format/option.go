@@ -0,0 +1,214 @@
+package format
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gunk/gunk/loader"
+)
+
+// optionTypes holds the packages imported by a Gunk file, such as those of
+// github.com/gunk/opt, by the names the file refers to them with. It is used
+// to find the struct types of the composite literals of +gunk tags.
+type optionTypes map[string]*types.Package
+
+// loadOptionPackages type-checks the packages imported by the files of pkgs,
+// returning them by import path. Those which fail to load are left out, so
+// their tags are formatted as they are.
+func loadOptionPackages(dir string, fset *token.FileSet, pkgs []*loader.GunkPackage) map[string]*types.Package {
+	l := loader.Loader{Dir: dir, Fset: fset, Types: true}
+	loaded := make(map[string]*types.Package)
+	for _, pkg := range pkgs {
+		for _, file := range pkg.GunkSyntax {
+			for _, imp := range file.Imports {
+				path, _ := strconv.Unquote(imp.Path.Value)
+				if _, ok := loaded[path]; ok || loader.IsProtoImport(path) {
+					continue
+				}
+				loaded[path] = nil
+				ipkgs, err := l.Load(path)
+				if err != nil || len(ipkgs) != 1 || len(ipkgs[0].Errors) > 0 {
+					continue
+				}
+				loaded[path] = ipkgs[0].Types
+			}
+		}
+	}
+	return loaded
+}
+
+// fileOptionTypes returns the option types of file, out of the loaded
+// packages.
+func fileOptionTypes(file *ast.File, loaded map[string]*types.Package) optionTypes {
+	opts := make(optionTypes)
+	for _, imp := range file.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		pkg := loaded[path]
+		if pkg == nil {
+			continue
+		}
+		name := pkg.Name()
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		opts[name] = pkg
+	}
+	return opts
+}
+
+// typeOf returns the type named by expr, such as http.Match, or nil if it
+// isn't known.
+func (o optionTypes) typeOf(expr ast.Expr) types.Type {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return nil
+	}
+	x, ok := sel.X.(*ast.Ident)
+	if !ok || o[x.Name] == nil {
+		return nil
+	}
+	tn, ok := o[x.Name].Scope().Lookup(sel.Sel.Name).(*types.TypeName)
+	if !ok {
+		return nil
+	}
+	return tn.Type()
+}
+
+// canonical returns the source of the tag expression expr, of type typ if
+// known, with the keyed fields of its struct literals sorted in the order
+// their struct declares them, along with whether that changed the order of
+// any of them. The result isn't formatted.
+func (o optionTypes) canonical(fset *token.FileSet, expr ast.Expr, typ types.Type) (string, bool, error) {
+	lit, ok := expr.(*ast.CompositeLit)
+	if ok && lit.Type != nil {
+		typ = o.typeOf(lit.Type)
+	}
+	if !ok || typ == nil {
+		src, err := printExpr(fset, expr)
+		return src, false, err
+	}
+	var st *types.Struct
+	var elem types.Type
+	switch u := typ.Underlying().(type) {
+	case *types.Struct:
+		st = u
+	case *types.Slice:
+		elem = u.Elem()
+	case *types.Array:
+		elem = u.Elem()
+	case *types.Map:
+		elem = u.Elem()
+	}
+	type element struct {
+		src   string
+		index int
+	}
+	elts := make([]element, len(lit.Elts))
+	changed := false
+	sortable := st != nil
+	for i, elt := range lit.Elts {
+		value, valueType, prefix := elt, elem, ""
+		index := -1
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			key, err := printExpr(fset, kv.Key)
+			if err != nil {
+				return "", false, err
+			}
+			value, prefix = kv.Value, key+": "
+			if id, ok := kv.Key.(*ast.Ident); ok && st != nil {
+				for j := 0; j < st.NumFields(); j++ {
+					if st.Field(j).Name() == id.Name {
+						index, valueType = j, st.Field(j).Type()
+						break
+					}
+				}
+			}
+		}
+		if index < 0 {
+			sortable = false
+		}
+		src, eltChanged, err := o.canonical(fset, value, valueType)
+		if err != nil {
+			return "", false, err
+		}
+		changed = changed || eltChanged
+		elts[i] = element{prefix + src, index}
+	}
+	if sortable {
+		sorted := sort.SliceIsSorted(elts, func(i, j int) bool { return elts[i].index < elts[j].index })
+		if !sorted {
+			sort.SliceStable(elts, func(i, j int) bool { return elts[i].index < elts[j].index })
+			changed = true
+		}
+	}
+	if !changed {
+		src, err := printExpr(fset, expr)
+		return src, false, err
+	}
+	var buf strings.Builder
+	if lit.Type != nil {
+		typeSrc, err := printExpr(fset, lit.Type)
+		if err != nil {
+			return "", false, err
+		}
+		buf.WriteString(typeSrc)
+	}
+	buf.WriteString("{")
+	// Keep literals spanning several lines that way, with one field per
+	// line.
+	multiline := fset.Position(lit.Lbrace).Line != fset.Position(lit.Rbrace).Line
+	for i, elt := range elts {
+		switch {
+		case multiline:
+			buf.WriteString("\n" + elt.src + ",")
+		case i > 0:
+			buf.WriteString(", " + elt.src)
+		default:
+			buf.WriteString(elt.src)
+		}
+	}
+	if multiline {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}")
+	return buf.String(), true, nil
+}
+
+// formatTag returns the formatted source of the tag expression expr, whose
+// struct literals are sorted if opts is set.
+func formatTag(fset *token.FileSet, expr ast.Expr, opts optionTypes) (string, error) {
+	if opts != nil {
+		src, changed, err := opts.canonical(fset, expr, nil)
+		if err != nil {
+			return "", err
+		}
+		if changed {
+			// Parse the sorted source again, for the printer to lay
+			// it out from its own positions.
+			fset = token.NewFileSet()
+			if expr, err = parser.ParseExprFrom(fset, "", src, 0); err != nil {
+				return "", err
+			}
+		}
+	}
+	return printExpr(fset, expr)
+}
+
+// printExpr prints expr, as the +gunk tags are printed in comments.
+func printExpr(fset *token.FileSet, expr ast.Expr) (string, error) {
+	var buf bytes.Buffer
+	// Print with space indentation, since all comment lines begin with
+	// "// " and we don't want to mix spaces and tabs.
+	config := printer.Config{Mode: printer.UseSpaces, Tabwidth: 8}
+	if err := config.Fprint(&buf, fset, expr); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
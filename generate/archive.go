@@ -0,0 +1,81 @@
+package generate
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/gunk/gunk/config"
+)
+
+// isArchive reports whether an output path is an archive, which generated
+// files are written into instead of a directory. protoc supports the same
+// extensions for its own output.
+func isArchive(path string) bool {
+	switch filepath.Ext(path) {
+	case ".zip", ".jar", ".srcjar":
+		return true
+	}
+	return false
+}
+
+// archiveKey is the key of a file within an archive in Generator.chainFiles.
+func archiveKey(archive, name string) string {
+	return archive + "!/" + name
+}
+
+// writeArchive writes the files of an archive output from chainFiles, in the
+// order they were generated in.
+func (g *Generator) writeArchive(archive string) error {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, path := range g.chainPaths {
+		f := g.chainFiles[path]
+		if f.archive != archive {
+			continue
+		}
+		fw, err := w.Create(strings.TrimPrefix(path, archiveKey(archive, "")))
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := g.writeFile(archive, buf.Bytes()); err != nil {
+		return err
+	}
+	g.recordOutput(archive, buf.Bytes())
+	return nil
+}
+
+// readArchive adds the files of an archive written by gen to chainFiles, as
+// if they were written into the archive output realOut.
+func (g *Generator) readArchive(archive, realOut string, gen config.Generator) error {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		g.addChainFile(archiveKey(realOut, f.Name), realOut, gen, data)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package generate
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/gunk/gunk/config"
+)
+
+func TestArchive(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "out", "gen.srcjar")
+	g := &Generator{chainFiles: make(map[string]*chainFile)}
+	gen := config.Generator{Command: "protoc-gen-go"}
+	g.addChainFile(archiveKey(archive, "a/a.pb.go"), archive, gen, []byte("package a\nvar  x = 1\n"))
+	g.addChainFile(archiveKey(archive, "b.pb.go"), archive, gen, []byte("package b\n"))
+	if err := g.flushChain(""); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	expected := []struct {
+		name    string
+		content string
+	}{
+		{"a/a.pb.go", "package a\n\nvar x = 1\n"},
+		{"b.pb.go", "package b\n"},
+	}
+	if len(r.File) != len(expected) {
+		t.Fatalf("wrong number of files, got %d expected %d", len(r.File), len(expected))
+	}
+	for i, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Name != expected[i].name || string(data) != expected[i].content {
+			t.Errorf("wrong file %d, got %q %q expected %q %q", i, f.Name, data, expected[i].name, expected[i].content)
+		}
+	}
+}
+
+func TestIsArchive(t *testing.T) {
+	for path, expected := range map[string]bool{
+		"out/java.srcjar": true,
+		"out.jar":         true,
+		"out.zip":         true,
+		"out/java":        false,
+		"":                false,
+	} {
+		if res := isArchive(path); res != expected {
+			t.Errorf("isArchive(%q) = %v, expected %v", path, res, expected)
+		}
+	}
+}
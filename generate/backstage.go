@@ -0,0 +1,157 @@
+package generate
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/gunk/gunk/config"
+	"github.com/gunk/gunk/loader"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"gopkg.in/yaml.v3"
+)
+
+// backstageFile is the name of the Backstage catalog file written for each
+// package, the one Backstage discovers by default.
+const backstageFile = "catalog-info.yaml"
+
+// openAPISuffixes are the suffixes of the OpenAPI documents written by
+// generators such as protoc-gen-openapiv2.
+var openAPISuffixes = []string{".swagger.json", ".swagger.yaml", ".openapi.json", ".openapi.yaml"}
+
+// backstageEntity is a Backstage API entity, as described in
+// https://backstage.io/docs/features/software-catalog/descriptor-format.
+type backstageEntity struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name        string            `yaml:"name"`
+		Title       string            `yaml:"title"`
+		Description string            `yaml:"description,omitempty"`
+		Annotations map[string]string `yaml:"annotations"`
+	} `yaml:"metadata"`
+	Spec struct {
+		Type       string `yaml:"type"`
+		Lifecycle  string `yaml:"lifecycle"`
+		Owner      string `yaml:"owner"`
+		System     string `yaml:"system,omitempty"`
+		Definition struct {
+			Text string `yaml:"$text"`
+		} `yaml:"definition"`
+	} `yaml:"spec"`
+}
+
+// anyBackstage reports whether any of the configs has a [backstage] section.
+func anyBackstage(pkgConfigs map[string]*config.Config) bool {
+	for _, cfg := range pkgConfigs {
+		if cfg.Backstage != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// writeBackstageEntity writes a Backstage catalog file to the directory of
+// pkg, registering it as an API entity whose definition is the OpenAPI
+// document generated for it, or else its FileDescriptorSet. It must be
+// called right after generating pkg, as it looks for the OpenAPI document in
+// the outputs of its report.
+func (g *Generator) writeBackstageEntity(pkg *loader.GunkPackage, b *config.Backstage) error {
+	g.curGen = "backstage"
+	var openAPI []string
+	for _, out := range g.curReport.Outputs {
+		for _, suffix := range openAPISuffixes {
+			if strings.HasSuffix(out.Path, suffix) {
+				openAPI = append(openAPI, out.Path)
+			}
+		}
+	}
+	if len(openAPI) > 1 {
+		return fmt.Errorf("backstage: %d OpenAPI documents were generated, but an API entity can only have one: %s",
+			len(openAPI), strings.Join(openAPI, ", "))
+	}
+	var e backstageEntity
+	e.APIVersion = "backstage.io/v1alpha1"
+	e.Kind = "API"
+	// Proto package names are valid entity names, and unique across
+	// the APIs of an organization.
+	e.Metadata.Name = g.allProto[unifiedProtoFile(pkg.PkgPath)].GetPackage()
+	e.Metadata.Title = pkg.PkgPath
+	e.Metadata.Description = packageDoc(pkg)
+	e.Metadata.Annotations = map[string]string{"gunk/package": pkg.PkgPath}
+	e.Spec.Lifecycle = b.Lifecycle
+	e.Spec.Owner = b.Owner
+	e.Spec.System = b.System
+	if b.DescriptorSet != "" {
+		if err := g.writePkgDescriptorSet(pkg, filepath.Join(pkg.Dir, b.DescriptorSet)); err != nil {
+			return err
+		}
+		e.Metadata.Annotations["gunk/descriptor-set"] = "./" + b.DescriptorSet
+	}
+	switch {
+	case len(openAPI) == 1:
+		rel, err := filepath.Rel(pkg.Dir, openAPI[0])
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !strings.HasPrefix(rel, "../") {
+			rel = "./" + rel
+		}
+		e.Spec.Type = "openapi"
+		e.Spec.Definition.Text = rel
+	case b.DescriptorSet != "":
+		e.Spec.Type = "grpc"
+		e.Spec.Definition.Text = "./" + b.DescriptorSet
+	default:
+		return fmt.Errorf("backstage: no OpenAPI document was generated to define the API entity; set descriptor_set to use the FileDescriptorSet instead")
+	}
+	var buf bytes.Buffer
+	buf.WriteString("# Code generated by gunk. DO NOT EDIT.\n")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&e); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	path := filepath.Join(pkg.Dir, backstageFile)
+	if err := g.writeFile(path, data); err != nil {
+		return err
+	}
+	g.recordOutput(path, data)
+	return nil
+}
+
+// writePkgDescriptorSet writes the FileDescriptorSet of pkg, including its
+// dependencies and source info, to path.
+func (g *Generator) writePkgDescriptorSet(pkg *loader.GunkPackage, path string) error {
+	needed := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		if needed[name] {
+			return
+		}
+		needed[name] = true
+		for _, dep := range g.allProto[name].GetDependency() {
+			visit(dep)
+		}
+	}
+	visit(unifiedProtoFile(pkg.PkgPath))
+	fds := &descriptorpb.FileDescriptorSet{}
+	for _, pfile := range g.sortedProtoFiles() {
+		if needed[pfile.GetName()] {
+			fds.File = append(fds.File, pfile)
+		}
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(fds)
+	if err != nil {
+		return err
+	}
+	if err := g.writeFile(path, data); err != nil {
+		return err
+	}
+	g.recordOutput(path, data)
+	return nil
+}
@@ -0,0 +1,35 @@
+package generate
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gunk/gunk/config"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func TestGeneratePluginCanceled(t *testing.T) {
+	plugin := filepath.Join(t.TempDir(), "protoc-gen-slow")
+	if err := ioutil.WriteFile(plugin, []byte("#!/bin/sh\nexec sleep 30\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	g := NewGenerator(t.TempDir())
+	req := &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"testdata.tld/util/all.proto"},
+	}
+	gen := configWithBinary{Generator: config.Generator{Command: plugin}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := g.generatePlugin(ctx, req, gen)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("plugin wasn't killed, took %s", elapsed)
+	}
+}
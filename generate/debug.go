@@ -0,0 +1,49 @@
+package generate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeDebugRequest writes the input given to a generator into
+// Options.DebugRequestsDir, so that it can be replayed outside of Gunk. Files
+// are named after the order generators ran in, the package and the
+// generator, such as "001-example.com_foo-go.request.pb".
+//
+// For plugins, the file holds the CodeGeneratorRequest, which can be replayed
+// with:
+//
+//	protoc-gen-go < 001-example.com_foo-go.request.pb
+//
+// For protoc, it holds the FileDescriptorSet, along with an ".args" file with
+// the protoc arguments to use it with, which can be replayed from dir with:
+//
+//	protoc @002-example.com_foo-python.descriptorset.args
+func (g *Generator) writeDebugRequest(kind string, data []byte, args []string) error {
+	dir := g.opts.DebugRequestsDir
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("unable to create directory %q: %w", dir, err)
+	}
+	g.debugRequests++
+	name := fmt.Sprintf("%03d-%s-%s.%s.pb", g.debugRequests,
+		strings.Replace(g.curGenPkg, "/", "_", -1), g.curGen, kind)
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("unable to write to file %q: %w", path, err)
+	}
+	if args == nil {
+		return nil
+	}
+	argsPath := strings.TrimSuffix(path, ".pb") + ".args"
+	argsData := strings.Replace(strings.Join(args, "\n"), "/dev/stdin", name, -1) + "\n"
+	if err := ioutil.WriteFile(argsPath, []byte(argsData), 0o644); err != nil {
+		return fmt.Errorf("unable to write to file %q: %w", argsPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package downloader
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gunk/gunk/interrupt"
+)
+
+// maxDownloads is the number of tools which may be downloaded or built at
+// once, across all the goroutines of the process.
+const maxDownloads = 4
+
+// downloadSlots limits the downloads and builds running at once to
+// maxDownloads.
+var downloadSlots = make(chan struct{}, maxDownloads)
+
+// acquireSlot blocks until fewer than maxDownloads downloads are running,
+// returning the func to release the slot once the download is done.
+func acquireSlot() (release func()) {
+	downloadSlots <- struct{}{}
+	return func() { <-downloadSlots }
+}
+
+// group deduplicates the concurrent calls fetching the same tool within the
+// process, so that only one of them downloads it while the others wait for
+// its result. The lock files in the cache only serialize them, leaving each
+// waiting goroutine to check the cache again once the lock is released.
+type group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// call is a fetch in flight, or finished, of a group.
+type call struct {
+	done chan struct{}
+	path string
+	err  error
+}
+
+// fetches deduplicates the calls to CheckOrDownloadProtoc and Download.
+var fetches group
+
+// do runs fn, unless a call with the same key is already running, in which
+// case it waits for that call and returns its results instead.
+func (g *group) do(key string, fn func() (string, error)) (string, error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+		return c.path, c.err
+	}
+	c := &call{done: make(chan struct{})}
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.path, c.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(c.done)
+	return c.path, c.err
+}
+
+// writeExecutable atomically writes the contents of r as an executable file
+// at path. The contents are written to a temporary file in the same directory
+// first, which is checked by verify if not nil before being renamed to path,
+// so that path never holds a partially written or broken binary, even if gunk
+// is interrupted or another process runs it meanwhile.
+func writeExecutable(path string, r io.Reader, verify func(path string) error) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	partial := interrupt.Register(func() { os.Remove(tmp.Name()) })
+	defer partial.Run()
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, r); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(0o775); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if verify != nil {
+		if err := verify(tmp.Name()); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// symlinkAtomic makes path a symbolic link to target, replacing any existing
+// file at path in a single step.
+func symlinkAtomic(target, path string) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".link.tmp")
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package downloader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGroupDedupe(t *testing.T) {
+	var g group
+	var runs int32
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	paths := make([]string, 10)
+	for i := range paths {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			paths[i], _ = g.do("protoc v3.9.1", func() (string, error) {
+				atomic.AddInt32(&runs, 1)
+				// Give the other goroutines time to join the call.
+				time.Sleep(50 * time.Millisecond)
+				return "/cache/protoc-v3.9.1", nil
+			})
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	if runs != 1 {
+		t.Errorf("fetch ran %d times, want 1", runs)
+	}
+	for _, path := range paths {
+		if path != "/cache/protoc-v3.9.1" {
+			t.Errorf("got path %q, want the shared result", path)
+		}
+	}
+	// A finished call isn't reused.
+	g.do("protoc v3.9.1", func() (string, error) {
+		atomic.AddInt32(&runs, 1)
+		return "", nil
+	})
+	if runs != 2 {
+		t.Errorf("fetch ran %d times after the first call finished, want 2", runs)
+	}
+}
+
+func TestAcquireSlot(t *testing.T) {
+	var running, most int32
+	var wg sync.WaitGroup
+	for i := 0; i < 3*maxDownloads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := acquireSlot()
+			defer release()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&most)
+				if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+	wg.Wait()
+	if most > maxDownloads {
+		t.Errorf("%d downloads ran at once, want at most %d", most, maxDownloads)
+	}
+}
+
+func TestWriteExecutable(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "protoc")
+
+	errBroken := errors.New("broken")
+	err := writeExecutable(path, strings.NewReader("broken"), func(string) error { return errBroken })
+	if err != errBroken {
+		t.Fatalf("got error %v, want %v", err, errBroken)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("failed write left %d files behind", len(entries))
+	}
+
+	if err := writeExecutable(path, strings.NewReader("protoc"), nil); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&0o111 == 0 {
+		t.Errorf("got mode %v, want an executable", info.Mode())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("got %d files, want only protoc", len(entries))
+	}
+}
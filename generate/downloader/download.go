@@ -5,7 +5,7 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/gunk/gunk/log"
+	"github.com/gunk/gunk/interrupt"
 	"github.com/rogpeppe/go-internal/lockedfile"
 )
 
@@ -20,20 +20,10 @@ func getPaths(name, version string) (*Paths, func(error), error) {
 		return nil, nil, fmt.Errorf("must provide protoc-gen-go version")
 	}
 
-	// Get the OS-specific cache directory.
-	cachePath, err := os.UserCacheDir()
+	cacheDir, err := CacheDir()
 	if err != nil {
 		return nil, nil, err
 	}
-	if dir := os.Getenv("GUNK_CACHE_DIR"); dir != "" {
-		// Allow overriding the cache dir entirely. Mainly for
-		// the tests.
-		cachePath = dir
-	}
-	cacheDir := filepath.Join(cachePath, "gunk")
-	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
-		return nil, nil, err
-	}
 	pname := fmt.Sprintf("protoc-gen-%s-%s", name, version)
 	var p Paths
 	p.buildDir = filepath.Join(cacheDir, fmt.Sprintf("git-%s", pname))
@@ -57,6 +47,26 @@ func getPaths(name, version string) (*Paths, func(error), error) {
 	return &p, cleanup, nil
 }
 
+// CacheDir returns the directory where Gunk caches downloaded tools and
+// files, creating it if needed. It can be overridden with $GUNK_CACHE_DIR.
+func CacheDir() (string, error) {
+	// Get the OS-specific cache directory.
+	cachePath, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	if dir := os.Getenv("GUNK_CACHE_DIR"); dir != "" {
+		// Allow overriding the cache dir entirely. Mainly for
+		// the tests.
+		cachePath = dir
+	}
+	cacheDir := filepath.Join(cachePath, "gunk")
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return "", err
+	}
+	return cacheDir, nil
+}
+
 type Downloader interface {
 	Name() string
 	Download(version string, p Paths) (string, error)
@@ -84,10 +94,16 @@ func Has(name string) bool {
 	return false
 }
 
+// Download returns the path of the plugin protoc-gen-<name> at version,
+// downloading or building it into the cache unless it's already there. It is
+// safe for concurrent use, both within a process and across processes: a
+// plugin is only fetched once, and at most a few plugins are fetched at once.
 func Download(name string, version string) (string, error) {
 	for _, d := range ds {
 		if d.Name() == name {
-			s, err := download(d, version)
+			s, err := fetches.do("plugin "+name+" "+version, func() (string, error) {
+				return download(d, version)
+			})
 			if err != nil {
 				name := fmt.Sprintf("protoc-gen-%s", d.Name())
 				return "", fmt.Errorf("error downloading %s version %s: %w", name, version, err)
@@ -114,6 +130,11 @@ func download(d Downloader, version string) (s string, err error) {
 		}
 		return p.binary, nil
 	}
+	release := acquireSlot()
+	defer release()
+	// Don't leave a partially built binary behind if interrupted.
+	partial := interrupt.Register(func() { os.RemoveAll(p.binary) })
+	defer partial.Release()
 	// remove git clone dir here and not in cleanup,
 	// so we can more easily debug
 	// (ignore error)
@@ -124,12 +145,7 @@ func download(d Downloader, version string) (s string, err error) {
 	}
 	if bin != p.binary {
 		// TODO windows?
-		cpCmd := log.ExecCommand("ln",
-			"-s",
-			bin,
-			p.binary)
-		err = cpCmd.Run()
-		if err != nil {
+		if err := symlinkAtomic(bin, p.binary); err != nil {
 			return "", err
 		}
 	}
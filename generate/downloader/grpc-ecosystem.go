@@ -2,9 +2,7 @@ package downloader
 
 import (
 	"fmt"
-	"io"
 	"net/http"
-	"os"
 	"runtime"
 )
 
@@ -37,16 +35,8 @@ func (ged GrpcEcosystem) Download(version string, p Paths) (string, error) {
 	if res.StatusCode != 200 {
 		return "", fmt.Errorf("download returns status 200")
 	}
-	dstFile, err := os.OpenFile(p.binary, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o775)
-	if err != nil {
-		return "", err
-	}
-	defer dstFile.Close()
 	// Write command to cache.
-	if _, err := io.Copy(dstFile, res.Body); err != nil {
-		return "", err
-	}
-	if err := dstFile.Close(); err != nil {
+	if err := writeExecutable(p.binary, res.Body, nil); err != nil {
 		return "", err
 	}
 	return p.binary, nil
@@ -4,7 +4,6 @@ import (
 	"archive/zip"
 	"bytes"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -17,7 +16,8 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-const defaultProtocVersion = "v3.9.1"
+// DefaultProtocVersion is the version of protoc used if none is configured.
+const DefaultProtocVersion = "v3.9.1"
 
 // CheckOrDownloadProtoc downloads protoc to the specified path, unless it's already
 // been downloaded. If no path is provided, it uses an OS-appropriate user cache.
@@ -26,30 +26,28 @@ const defaultProtocVersion = "v3.9.1"
 // it checks whether the output of `protoc --version` is an exact match.
 //
 // Note that this code is safe for concurrent use between multiple goroutines or
-// processes, since it uses a lock file on disk.
+// processes: concurrent calls for the same path share a single download, and
+// protoc is only written to the path once it's been fully downloaded and
+// verified.
 func CheckOrDownloadProtoc(path, version string) (string, error) {
 	if version == "" {
-		version = defaultProtocVersion
+		version = DefaultProtocVersion
 	}
+	return fetches.do("protoc "+path+" "+version, func() (string, error) {
+		return checkOrDownloadProtoc(path, version)
+	})
+}
+
+func checkOrDownloadProtoc(path, version string) (string, error) {
 	// note - functionality is shared partly with getPaths in download.go
 	// but as that does not test existing binaries (as protoc-gen- binaries do not need to return version)
 	// let's keep it separate
 	dstPath := path
 	if dstPath == "" {
-		// Get the OS-specific cache directory.
-		cachePath, err := os.UserCacheDir()
+		cacheDir, err := CacheDir()
 		if err != nil {
 			return "", err
 		}
-		if dir := os.Getenv("GUNK_CACHE_DIR"); dir != "" {
-			// Allow overriding the cache dir entirely. Mainly for
-			// the tests.
-			cachePath = dir
-		}
-		cacheDir := filepath.Join(cachePath, "gunk")
-		if err := os.MkdirAll(cacheDir, 0o755); err != nil {
-			return "", err
-		}
 		// The proto command path to use or download to.
 		dstPath = filepath.Join(cacheDir, fmt.Sprintf("protoc-%s", version))
 	}
@@ -70,24 +68,20 @@ func CheckOrDownloadProtoc(path, version string) (string, error) {
 		return "", err
 	}
 	defer unlock()
-	// We are the only goroutine with access to dstPath. Check if it already
-	// exists. Using lockedfile.OpenFile allows us to do an atomic write
-	// when it doesn't exist yet.
-	// TODO: isn't this lock entirely superfluous? The first lock already blocks the whole time
-	dstFile, err := lockedfile.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o775)
-	if os.IsExist(err) {
-		// It exists. Because of O_EXCL, we haven't actually opened the
-		// file. Just verify that protoc works and return.
+	// We are the only process with access to dstPath. Since protoc is
+	// only ever renamed into place once complete, if it exists it's a
+	// full download; just verify that protoc works and return.
+	if _, err := os.Stat(dstPath); err == nil {
 		if err := verifyProtocBinary(dstPath, version); err != nil {
 			return "", err
 		}
 		return dstPath, nil
-	}
-	if err != nil {
+	} else if !os.IsNotExist(err) {
 		return "", err
 	}
-	defer dstFile.Close()
-	// The file does not exist. Download it, using dstFile.
+	release := acquireSlot()
+	defer release()
+	// The file does not exist. Download it.
 	url, err := protocDownloadURL(runtime.GOOS, runtime.GOARCH, version)
 	if err != nil {
 		return "", fmt.Errorf("downloading protoc: %w", err)
@@ -127,17 +121,14 @@ func CheckOrDownloadProtoc(path, version string) (string, error) {
 			return "", err
 		}
 		defer fc.Close()
-		// Write protoc command to cache.
-		if _, err := io.Copy(dstFile, fc); err != nil {
-			return "", err
-		}
-		if err := dstFile.Close(); err != nil {
+		// Write protoc command to cache, once it's known to work.
+		err = writeExecutable(dstPath, fc, func(path string) error {
+			return verifyProtocBinary(path, version)
+		})
+		if err != nil {
 			return "", err
 		}
 		log.Verbosef("downloaded protoc to %s", dstPath)
-		if err := verifyProtocBinary(dstPath, version); err != nil {
-			return "", err
-		}
 		return dstPath, nil
 	}
 	return "", fmt.Errorf("unable to download and extract protoc")
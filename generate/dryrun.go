@@ -0,0 +1,112 @@
+package generate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// dryRun holds the files a dry run would have written, in the order they
+// were first written in.
+type dryRun struct {
+	paths []string
+	files map[string][]byte
+}
+
+// writeFile writes data to path, creating its directory if needed. In a dry
+// run, data is kept in memory instead, to be compared with path at the end.
+func (g *Generator) writeFile(path string, data []byte) error {
+	if d := g.dryRun; d != nil {
+		if _, ok := d.files[path]; !ok {
+			d.paths = append(d.paths, path)
+		}
+		d.files[path] = data
+		return nil
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return fmt.Errorf("unable to create directory %q: %w", dir, err)
+		}
+	}
+	if err := ioutil.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("unable to write to file %q: %w", path, err)
+	}
+	return nil
+}
+
+// diff prints a unified diff to w for each file of the dry run which differs
+// from the one on disk, and returns how many files differ.
+func (d *dryRun) diff(w io.Writer) (int, error) {
+	n := 0
+	for _, path := range d.paths {
+		data := d.files[path]
+		name := diffName(path)
+		from := "a/" + name
+		old, err := ioutil.ReadFile(path)
+		switch {
+		case os.IsNotExist(err):
+			from = "/dev/null"
+		case err != nil:
+			return n, err
+		case bytes.Equal(old, data):
+			continue
+		}
+		n++
+		if isBinary(old) || isBinary(data) {
+			fmt.Fprintf(w, "Binary files %s and b/%s differ\n", from, name)
+			continue
+		}
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        splitLines(old),
+			B:        splitLines(data),
+			FromFile: from,
+			ToFile:   "b/" + name,
+			Context:  3,
+		})
+		if err != nil {
+			return n, err
+		}
+		fmt.Fprint(w, diff)
+	}
+	return n, nil
+}
+
+// splitLines splits data into lines for a diff, each ending with a newline.
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if last := lines[len(lines)-1]; last == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		lines[len(lines)-1] = last + "\n"
+	}
+	return lines
+}
+
+// diffName returns the name of path in a diff, relative to the current
+// directory if possible.
+func diffName(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				path = rel
+			}
+		}
+	}
+	return filepath.ToSlash(path)
+}
+
+// isBinary reports whether data should not be diffed as text, such as
+// archives.
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data)
+}
@@ -0,0 +1,96 @@
+package generate
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"reflect"
+	"strconv"
+
+	"github.com/gunk/gunk/loader"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// flattenField converts the embedded message field tagged with the flatten pb
+// tag option, appending the fields of the embedded message to msg, with their
+// numbers offset by the number of the embedded field. Embedded messages
+// without the option are converted by convertField, like any message field.
+func (g *Generator) flattenField(tspec *ast.TypeSpec, msg *descriptorpb.DescriptorProto, field *ast.Field) error {
+	g.curPos = field.Pos()
+	name := loader.EmbeddedName(field)
+	if name == "" {
+		return fmt.Errorf("the %q pb tag option is only valid on embedded messages, not on %s", loader.FlattenOption, field.Names[0].Name)
+	}
+	if len(g.curPkg.GunkTags[field]) > 0 {
+		return fmt.Errorf("gunk tags are not supported on flattened %s", name)
+	}
+	embedded, err := g.embeddedStruct(field)
+	if err != nil {
+		return err
+	}
+	str, _ := strconv.Unquote(field.Tag.Value)
+	offset, err := protoNumber(reflect.StructTag(str))
+	if err != nil {
+		return fmt.Errorf("unable to convert tag to number on %s: %v", name, err)
+	}
+	if *offset < 0 {
+		return fmt.Errorf("the field number offset of flattened %s must not be negative", name)
+	}
+	// Messages flattened into embedded messages add up their offsets.
+	prevOffset := g.numberOffset
+	g.numberOffset += *offset
+	defer func() { g.numberOffset = prevOffset }()
+	for _, f := range embedded.Fields.List {
+		var err error
+		switch {
+		case loader.IsOneof(f):
+			err = g.convertOneof(tspec, msg, f)
+		case hasPBOption(f, loader.FlattenOption):
+			err = g.flattenField(tspec, msg, f)
+		default:
+			_, err = g.convertField(tspec, msg, f)
+		}
+		if err != nil {
+			// Report the error at the embedded message, which
+			// may be declared away from msg.
+			g.curPos = field.Pos()
+			return fmt.Errorf("flattening %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// embeddedStruct returns the struct type declaring the message embedded by
+// field, which must be declared in the package being translated.
+func (g *Generator) embeddedStruct(field *ast.Field) (*ast.StructType, error) {
+	name := loader.EmbeddedName(field)
+	named, ok := g.curPkg.TypesInfo.TypeOf(field.Type).(*types.Named)
+	if !ok {
+		return nil, fmt.Errorf("cannot flatten %s, which isn't a message", name)
+	}
+	if _, ok := named.Underlying().(*types.Struct); !ok {
+		return nil, fmt.Errorf("cannot flatten %s, which isn't a message", name)
+	}
+	obj := named.Obj()
+	if obj.Pkg() != g.curPkg.Types {
+		return nil, fmt.Errorf("cannot flatten %s, declared in another package; embed it without the %q pb tag option to add it as a message field instead", name, loader.FlattenOption)
+	}
+	for _, file := range g.curPkg.GunkSyntax {
+		for _, decl := range file.Decls {
+			gdecl, ok := decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			for _, spec := range gdecl.Specs {
+				tspec, ok := spec.(*ast.TypeSpec)
+				if !ok || g.curPkg.TypesInfo.Defs[tspec.Name] != obj {
+					continue
+				}
+				if st, ok := tspec.Type.(*ast.StructType); ok {
+					return st, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("cannot flatten %s, which isn't a message", name)
+}
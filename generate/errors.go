@@ -0,0 +1,164 @@
+package generate
+
+import (
+	"fmt"
+	"go/token"
+	"sort"
+	"strings"
+
+	"github.com/gunk/gunk/loader"
+	"github.com/gunk/gunk/log"
+)
+
+// ErrorKind is the kind of failure behind an Error, which determines the exit
+// code of gunk generate.
+type ErrorKind int
+
+const (
+	// ConfigError is an invalid or unusable .gunkconfig.
+	ConfigError ErrorKind = iota + 1
+	// LoadError is a failure to load the Gunk packages, such as syntax
+	// or type errors.
+	LoadError
+	// TranslateError is a failure to translate a Gunk package to proto.
+	TranslateError
+	// GeneratorError is a failure to run protoc or a plugin, or to write
+	// their output.
+	GeneratorError
+	// VerifyError is generated output which doesn't match the files on
+	// disk, when only checking that they are up to date.
+	VerifyError
+)
+
+// ExitCode returns the exit code of gunk generate for an error of kind k.
+// Code 1 is left for other errors, such as command line usage errors.
+func (k ErrorKind) ExitCode() int {
+	switch k {
+	case ConfigError:
+		return 3
+	case LoadError:
+		return 4
+	case TranslateError:
+		return 5
+	case GeneratorError:
+		return 6
+	case VerifyError:
+		return 7
+	}
+	return 1
+}
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ConfigError:
+		return "config error"
+	case LoadError:
+		return "load error"
+	case TranslateError:
+		return "translation error"
+	case GeneratorError:
+		return "generator error"
+	case VerifyError:
+		return "verify mismatch"
+	}
+	return "error"
+}
+
+// Error is an error from a generate run, along with its kind.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// errorf returns an Error of the given kind, formatted like fmt.Errorf.
+func errorf(kind ErrorKind, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// posError is an error translating the Gunk declaration at pos.
+type posError struct {
+	pos token.Position
+	err error
+}
+
+func (e *posError) Error() string { return fmt.Sprintf("%s: %v", e.pos, e.err) }
+
+func (e *posError) Unwrap() error { return e.err }
+
+// errorAt returns an error positioned at pos in the package being
+// translated.
+func (g *Generator) errorAt(pos token.Pos, format string, args ...interface{}) error {
+	return &posError{pos: g.Loader.Fset.Position(pos), err: fmt.Errorf(format, args...)}
+}
+
+// translateErrors are the errors found translating a Gunk package, sorted by
+// position, so that they can all be fixed in one pass. Each error is on a
+// line of its own.
+type translateErrors []*posError
+
+func (l translateErrors) Error() string {
+	lines := make([]string, len(l))
+	for i, e := range l {
+		lines[i] = e.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sort sorts the errors by file and offset, keeping the order of errors at
+// the same position.
+func (l translateErrors) sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		a, b := l[i].pos, l[j].pos
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		return a.Offset < b.Offset
+	})
+}
+
+// failures records the packages which failed to generate. Unless keepGoing
+// is set, the first failure stops the run.
+type failures struct {
+	keepGoing bool
+	failed    map[string]bool
+	first     *Error
+}
+
+// add records that pkg failed with err. It returns err if the run should
+// stop; otherwise the error is printed, and pkg should be skipped.
+func (f *failures) add(pkg *loader.GunkPackage, err error) error {
+	if !f.keepGoing {
+		return err
+	}
+	log.Printf("%s: %v", pkg.PkgPath, err)
+	if f.failed == nil {
+		f.failed = make(map[string]bool)
+	}
+	f.failed[pkg.PkgPath] = true
+	if f.first == nil {
+		f.first, _ = err.(*Error)
+	}
+	return nil
+}
+
+// has reports whether pkg has failed.
+func (f *failures) has(pkg *loader.GunkPackage) bool {
+	return f.failed[pkg.PkgPath]
+}
+
+// summary returns nil if no package failed, or an error reporting how many of
+// the total packages did. The error has the kind of the first failure.
+func (f *failures) summary(total int) error {
+	if len(f.failed) == 0 {
+		return nil
+	}
+	kind := GeneratorError
+	if f.first != nil {
+		kind = f.first.Kind
+	}
+	return errorf(kind, "%d of %d packages failed", len(f.failed), total)
+}
@@ -0,0 +1,63 @@
+package generate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// requiredFeatures returns the features a plugin must declare in its
+// CodeGeneratorResponse to correctly handle the files it was asked to
+// generate, as protoc does before accepting a plugin's output.
+func requiredFeatures(req *pluginpb.CodeGeneratorRequest) uint64 {
+	toGenerate := make(map[string]bool)
+	for _, name := range req.GetFileToGenerate() {
+		toGenerate[name] = true
+	}
+	var features uint64
+	for _, pf := range req.GetProtoFile() {
+		if !toGenerate[pf.GetName()] {
+			continue
+		}
+		if messagesUseProto3Optional(pf.GetMessageType()) {
+			features |= uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+		}
+	}
+	return features
+}
+
+func messagesUseProto3Optional(msgs []*descriptorpb.DescriptorProto) bool {
+	for _, msg := range msgs {
+		for _, field := range msg.GetField() {
+			if field.GetProto3Optional() {
+				return true
+			}
+		}
+		if messagesUseProto3Optional(msg.GetNestedType()) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFeatures returns an error if a plugin didn't declare support for all
+// the required features. Plugins which don't know about a feature tend to
+// silently ignore it, such as dropping optional fields, so this is an error
+// rather than a warning.
+func checkFeatures(command string, required, supported uint64) error {
+	missing := required &^ supported
+	if missing == 0 {
+		return nil
+	}
+	var names []string
+	for value, name := range pluginpb.CodeGeneratorResponse_Feature_name {
+		if missing&uint64(value) != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return fmt.Errorf("generator %s does not support %s; upgrade it or stop using the features", command, strings.Join(names, ", "))
+}
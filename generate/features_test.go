@@ -0,0 +1,66 @@
+package generate
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func TestRequiredFeatures(t *testing.T) {
+	optional := &descriptorpb.FileDescriptorProto{
+		Name: proto.String("optional/all.proto"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Outer"),
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Inner"),
+				Field: []*descriptorpb.FieldDescriptorProto{{
+					Name:           proto.String("value"),
+					Proto3Optional: proto.Bool(true),
+				}},
+			}},
+		}},
+	}
+	plain := &descriptorpb.FileDescriptorProto{
+		Name: proto.String("plain/all.proto"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Plain"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name: proto.String("value"),
+			}},
+		}},
+	}
+	proto3Optional := uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+	tests := []struct {
+		generate string
+		expected uint64
+	}{
+		{generate: "plain/all.proto", expected: 0},
+		{generate: "optional/all.proto", expected: proto3Optional},
+	}
+	for _, tc := range tests {
+		req := &pluginpb.CodeGeneratorRequest{
+			FileToGenerate: []string{tc.generate},
+			ProtoFile:      []*descriptorpb.FileDescriptorProto{optional, plain},
+		}
+		if res := requiredFeatures(req); res != tc.expected {
+			t.Errorf("wrong features for %q, got %d expected %d", tc.generate, res, tc.expected)
+		}
+	}
+}
+
+func TestCheckFeatures(t *testing.T) {
+	proto3Optional := uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+	if err := checkFeatures("protoc-gen-x", 0, 0); err != nil {
+		t.Errorf("unexpected error with no required features: %v", err)
+	}
+	if err := checkFeatures("protoc-gen-x", proto3Optional, proto3Optional); err != nil {
+		t.Errorf("unexpected error with supported features: %v", err)
+	}
+	err := checkFeatures("protoc-gen-x", proto3Optional, 0)
+	expected := "generator protoc-gen-x does not support FEATURE_PROTO3_OPTIONAL; upgrade it or stop using the features"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error, got %v expected %q", err, expected)
+	}
+}
@@ -2,158 +2,567 @@ package generate
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/constant"
 	"go/token"
 	"go/types"
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/protoc-gen-openapiv2/options"
 	"github.com/gunk/gunk/config"
 	"github.com/gunk/gunk/generate/downloader"
+	"github.com/gunk/gunk/generate/inprocess"
+	"github.com/gunk/gunk/interrupt"
 	"github.com/gunk/gunk/loader"
 	"github.com/gunk/gunk/log"
+	"github.com/gunk/gunk/protodeps"
 	"github.com/gunk/gunk/protoutil"
 	"github.com/gunk/gunk/reflectutil"
-	"github.com/karelbilek/dirchanges"
 	"google.golang.org/genproto/googleapis/api/annotations"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/types/descriptorpb"
 	"google.golang.org/protobuf/types/pluginpb"
 )
 
+// Options holds the settings for a generate run which aren't part of a
+// package's .gunkconfig, usually set via command line flags.
+type Options struct {
+	// ReportUnusedImports prints the Gunk imports which were pruned from
+	// the translated proto files, since none of their types are used.
+	ReportUnusedImports bool
+	// FailUnusedImports makes the generation fail if any Gunk import is
+	// unused.
+	FailUnusedImports bool
+	// CheckPlugins runs each plugin with --version before generating, and
+	// compares the result with its plugin_version and version_range.
+	// Mismatches are printed if it is CheckPluginsWarn, and are an error
+	// if it is CheckPluginsFail. Empty disables the check.
+	CheckPlugins string
+	// ReportFile, if set, is a file to write a JSON Report of the run to,
+	// even if it fails.
+	ReportFile string
+	// KeepGoing generates as many packages as possible rather than
+	// stopping at the first failing one. Failures are printed as they
+	// happen, and a summary is returned at the end.
+	KeepGoing bool
+	// DebugRequestsDir, if set, is a directory to write the input of each
+	// generator to, so that generators can be debugged outside of Gunk.
+	DebugRequestsDir string
+	// SizeReport prints the size of the code generated for each package
+	// and generator, estimated for each message and service, along with
+	// hints about constructs which generate a lot of code.
+	SizeReport bool
+	// DryRun generates the output files in memory rather than writing
+	// them. A unified diff is printed to DiffOutput, or os.Stdout if nil,
+	// for each file which differs from the one on disk, and a VerifyError
+	// is returned if any does.
+	DryRun     bool
+	DiffOutput io.Writer
+	// OutRoot, if set, is the directory to write all the generated files
+	// under, mirroring the layout of the Gunk packages under the directory
+	// being run in. It overrides out_root in the .gunkconfig files.
+	OutRoot string
+	// Hermetic generates in a temporary copy of the module, or of its
+	// workspace, for generators which misbehave when their outputs are
+	// next to the sources. Only the files which were added or changed in
+	// the copy are written back, once all the packages were generated.
+	// It has no effect in a dry run.
+	Hermetic bool
+	// OnlySymbol, if set, is a top-level service, message or enum, such as
+	// "pkg.Service" with the Go or proto package name of its package, to
+	// generate on its own. The requests only hold the symbol and the types
+	// it uses, and only the generators which support it, such as docs and
+	// API specs, are run; see supportsSymbol.
+	OnlySymbol string
+}
+
 // Run generates the specified Gunk packages via protobuf generators, writing
 // the output files in the same directories.
 func Run(dir string, args ...string) error {
+	return RunWithOptions(Options{}, dir, args...)
+}
+
+// RunWithOptions is like Run, but allows configuring the generation with opts.
+func RunWithOptions(opts Options, dir string, args ...string) error {
+	return RunContext(context.Background(), opts, dir, args...)
+}
+
+// RunContext is like RunWithOptions, but stops once ctx is done. Any protoc
+// or plugin processes still running are killed, and ctx.Err() is returned
+// wrapped. Generators which were killed don't write their output files.
+//
+// Failures are returned as an *Error, whose kind tells which stage failed.
+func RunContext(ctx context.Context, opts Options, dir string, args ...string) (err error) {
+	if opts.Hermetic && !opts.DryRun {
+		return runHermetic(ctx, opts, dir, args...)
+	}
+	if opts.OnlySymbol != "" && !strings.Contains(opts.OnlySymbol, ".") {
+		return errorf(ConfigError, "invalid symbol %q, want pkg.Name", opts.OnlySymbol)
+	}
 	g := NewGenerator(dir)
+	g.opts = opts
+	if opts.DryRun {
+		g.dryRun = &dryRun{files: make(map[string][]byte)}
+	}
+	if opts.ReportFile != "" || opts.SizeReport {
+		// The size report is built from the outputs in the report.
+		g.report = &Report{Packages: []*PackageReport{}}
+	}
+	if opts.ReportFile != "" {
+		start := time.Now()
+		defer func() {
+			if rerr := g.writeReport(opts.ReportFile, start, err); rerr != nil && err == nil {
+				err = rerr
+			}
+		}()
+	}
+	if err := g.useProtoDeps(); err != nil {
+		return errorf(ConfigError, "unable to use proto dependencies: %w", err)
+	}
 	// Check that protoc exists, if not download it.
-	pkgs, err := g.Load(args...)
+	pkgs, err := g.LoadContext(ctx, args...)
 	if err != nil {
-		return fmt.Errorf("error loading packages: %w", err)
+		return errorf(LoadError, "error loading packages: %w", err)
 	}
 	if len(pkgs) == 0 {
-		return fmt.Errorf("no Gunk packages to generate")
+		return errorf(LoadError, "no Gunk packages to generate")
 	}
 	if loader.PrintErrors(pkgs) > 0 {
-		return fmt.Errorf("encountered package loading errors")
+		return errorf(LoadError, "encountered package loading errors")
 	}
 	// Record the loaded packages in gunkPkgs.
 	g.recordPkgs(pkgs...)
 	// Cache of a package directory to its gunkconfig.
 	pkgConfigs := map[string]*config.Config{}
+	// Packages which failed, and are skipped with KeepGoing.
+	failed := &failures{keepGoing: opts.KeepGoing}
 	// Translate the packages from Gunk to Proto.
 	for _, pkg := range pkgs {
-		cfg, err := config.Load(pkg.Dir)
-		if err != nil {
-			return fmt.Errorf("unable to load gunkconfig: %w", err)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := g.pkgReport(pkg); err != nil {
+			return errorf(LoadError, "unable to report inputs: %w", err)
+		}
+		if err := g.configurePkg(pkg, pkgConfigs); err != nil {
+			g.reportFailure(pkg, err)
+			if err := failed.add(pkg, err); err != nil {
+				return err
+			}
+			continue
 		}
-		pkgConfigs[pkg.Dir] = cfg
 		if err := g.translatePkg(pkg.PkgPath); err != nil {
-			return fmt.Errorf("unable to translate pkg: %w", err)
+			err = errorf(TranslateError, "unable to translate pkg: %w", err)
+			g.reportFailure(pkg, err)
+			if err := failed.add(pkg, err); err != nil {
+				return err
+			}
+		}
+	}
+	if g.opts.FailUnusedImports && g.unusedImports > 0 {
+		return errorf(TranslateError, "found %d unused imports", g.unusedImports)
+	}
+	var remaining []*loader.GunkPackage
+	for _, pkg := range pkgs {
+		if !failed.has(pkg) {
+			remaining = append(remaining, pkg)
+		}
+	}
+	if len(remaining) == 0 {
+		return failed.summary(len(pkgs))
+	}
+	// Protoc is only needed to load the proto dependencies which aren't
+	// bundled with Gunk.
+	if deps := g.missingProtoDeps(); !allBundled(deps) {
+		// hack: take protoc config from the first package
+		firstPkg := remaining[0]
+		cfg := pkgConfigs[firstPkg.Dir]
+		protocPath, err := downloader.CheckOrDownloadProtoc(cfg.ProtocPath, cfg.ProtocVersion)
+		if err != nil {
+			return errorf(GeneratorError, "unable to check or download protoc: %w", err)
 		}
+		g.protoLoader.ProtocPath = protocPath
+	}
+	// Load any non-Gunk proto dependencies.
+	if err := g.loadProtoDeps(ctx); err != nil {
+		return errorf(TranslateError, "unable to load protodeps: %w", err)
+	}
+	// The Backstage entities reference the outputs in the report, and
+	// the files to sign are found in them.
+	if g.report == nil && (anyBackstage(pkgConfigs) || anySign(pkgConfigs)) {
+		g.report = &Report{Packages: []*PackageReport{}}
+	}
+	// Finally, run the code generators.
+	var generated []*loader.GunkPackage
+	for _, pkg := range remaining {
+		cfg := pkgConfigs[pkg.Dir]
+		start := time.Now()
+		g.curReport, _ = g.pkgReport(pkg)
+		var protocPath string
+		var err error
+		if usesProtoc(cfg.Generators) {
+			protocPath, err = downloader.CheckOrDownloadProtoc(cfg.ProtocPath, cfg.ProtocVersion)
+		}
+		if err == nil {
+			err = g.GeneratePkg(ctx, pkg.PkgPath, cfg.Generators, protocPath)
+		}
+		if err == nil && cfg.Backstage != nil {
+			err = g.writeBackstageEntity(pkg, cfg.Backstage)
+		}
+		if err == nil && cfg.Sign != nil {
+			err = g.signOutputs(ctx, cfg.Sign)
+		}
+		pr := g.curReport
+		if pr != nil {
+			pr.DurationMS = time.Since(start).Milliseconds()
+		}
+		g.curReport = nil
+		if err != nil {
+			if ctx.Err() != nil {
+				reportInterrupted(generated, len(pkgs))
+				return fmt.Errorf("unable to generate pkg %s: %w", pkg.PkgPath, err)
+			}
+			err = errorf(GeneratorError, "unable to generate pkg %s: %w", pkg.PkgPath, err)
+			g.reportFailure(pkg, err)
+			if err := failed.add(pkg, err); err != nil {
+				return err
+			}
+			continue
+		}
+		generated = append(generated, pkg)
+		log.Verbosef("%s", pkg.PkgPath)
+		if opts.SizeReport {
+			g.printSizeReport(pkg.PkgPath, pr)
+		}
+	}
+	if opts.OnlySymbol != "" && !g.foundSymbol {
+		return errorf(LoadError, "symbol %s not found in the generated packages", opts.OnlySymbol)
+	}
+	if err := g.writeGoModuleStubs(generated, pkgConfigs); err != nil {
+		return errorf(GeneratorError, "unable to write go module files: %w", err)
+	}
+	if g.dryRun != nil {
+		w := opts.DiffOutput
+		if w == nil {
+			w = os.Stdout
+		}
+		n, err := g.dryRun.diff(w)
+		if err != nil {
+			return errorf(GeneratorError, "unable to compare generated files: %w", err)
+		}
+		if err := failed.summary(len(pkgs)); err != nil {
+			return err
+		}
+		if n > 0 {
+			return errorf(VerifyError, "%d generated files are out of date", n)
+		}
+		return nil
+	}
+	return failed.summary(len(pkgs))
+}
+
+// useProtoDeps makes the proto loader load proto files from the proto
+// dependencies vendored with `gunk vendor`, if the gunkconfig of the
+// directory being run in declares any, using the protoc it configures.
+func (g *Generator) useProtoDeps() error {
+	cfg, err := config.Load(g.Loader.Dir)
+	if err != nil {
+		// Missing or broken configs are reported for each package,
+		// by configurePkg.
+		return nil
+	}
+	if len(cfg.ProtoDeps) == 0 {
+		return nil
+	}
+	includes, err := protodeps.Includes(cfg.ProtoDeps)
+	if err != nil {
+		return err
 	}
-	// hack: take protoc config from the first package
-	firstPkg := pkgs[0]
-	cfg := pkgConfigs[firstPkg.Dir]
 	protocPath, err := downloader.CheckOrDownloadProtoc(cfg.ProtocPath, cfg.ProtocVersion)
 	if err != nil {
 		return fmt.Errorf("unable to check or download protoc: %w", err)
 	}
+	g.protoLoader.Includes = includes
 	g.protoLoader.ProtocPath = protocPath
-	// Load any non-Gunk proto dependencies.
-	if err := g.loadProtoDeps(); err != nil {
-		return fmt.Errorf("unable to load protodeps: %w", err)
+	return nil
+}
+
+// configurePkg loads and checks the gunkconfig of pkg, recording it in
+// pkgConfigs.
+func (g *Generator) configurePkg(pkg *loader.GunkPackage, pkgConfigs map[string]*config.Config) error {
+	cfg, err := config.Load(pkg.Dir)
+	if err != nil {
+		return errorf(ConfigError, "unable to load gunkconfig: %w", err)
 	}
-	// Finally, run the code generators.
-	for _, pkg := range pkgs {
-		cfg := pkgConfigs[pkg.Dir]
-		protocPath, err := downloader.CheckOrDownloadProtoc(cfg.ProtocPath, cfg.ProtocVersion)
+	// Configs are shared by many packages, so print their warnings once.
+	for _, w := range cfg.Warnings {
+		if !g.configWarnings[w] {
+			g.configWarnings[w] = true
+			g.warnf("warning: %s", w)
+		}
+	}
+	if err := checkPinned(cfg); err != nil {
+		return &Error{Kind: ConfigError, Err: err}
+	}
+	if err := checkProtocGenerators(cfg); err != nil {
+		return &Error{Kind: ConfigError, Err: err}
+	}
+	if err := checkInProcess(cfg); err != nil {
+		return &Error{Kind: ConfigError, Err: err}
+	}
+	if root := g.opts.OutRoot; root != "" {
+		dir, err := filepath.Abs(g.Loader.Dir)
 		if err != nil {
-			return fmt.Errorf("unable to check or download protoc: %w", err)
+			return err
 		}
-		if err := g.GeneratePkg(pkg.PkgPath, cfg.Generators, protocPath); err != nil {
-			return fmt.Errorf("unable to generate pkg %s: %w", pkg.PkgPath, err)
+		if !filepath.IsAbs(root) {
+			root = filepath.Join(dir, root)
 		}
-		log.Verbosef("%s", pkg.PkgPath)
+		cfg.SetOutRoot(root, dir)
+	}
+	if err := cfg.CheckOutRoot(pkg.Dir); err != nil {
+		return &Error{Kind: ConfigError, Err: err}
 	}
+	pkgConfigs[pkg.Dir] = cfg
 	return nil
 }
 
-// FileDescriptorSet will load a single Gunk package, and return the
-// proto FileDescriptor set of the Gunk package.
-//
-// Currently, we only generate a FileDescriptorSet for one Gunk package.
+// reportInterrupted prints which of the total packages were fully generated
+// before the generation was interrupted. The output of the other packages may
+// be missing or stale.
+func reportInterrupted(generated []*loader.GunkPackage, total int) {
+	if len(generated) == 0 {
+		log.Printf("interrupted before any of the %d packages were generated", total)
+		return
+	}
+	log.Printf("interrupted after generating %d of %d packages:", len(generated), total)
+	for _, pkg := range generated {
+		log.Printf("\t%s", pkg.PkgPath)
+	}
+}
+
+// DescriptorSetOptions controls the contents of the FileDescriptorSet
+// returned by FileDescriptorSetWithOptions. They are equivalent to protoc's
+// --include_imports and --include_source_info flags.
+type DescriptorSetOptions struct {
+	// IncludeImports includes all the dependencies of the Gunk package
+	// in the set, making it self-contained.
+	IncludeImports bool
+	// IncludeSourceInfo keeps the SourceCodeInfo of each file, which
+	// holds the comments and source positions of its declarations.
+	IncludeSourceInfo bool
+}
+
+// FileDescriptorSet will load the Gunk packages matching the given patterns,
+// and return a single proto FileDescriptor set of the Gunk packages, along
+// with all their dependencies and source info.
 func FileDescriptorSet(dir string, args ...string) (*descriptorpb.FileDescriptorSet, error) {
+	return FileDescriptorSetWithOptions(DescriptorSetOptions{
+		IncludeImports:    true,
+		IncludeSourceInfo: true,
+	}, dir, args...)
+}
+
+// FileDescriptorSetWithOptions is like FileDescriptorSet, but allows
+// leaving the dependencies and source info out of the set.
+func FileDescriptorSetWithOptions(opts DescriptorSetOptions, dir string, args ...string) (*descriptorpb.FileDescriptorSet, error) {
+	fds, _, _, err := descriptorSet(opts, dir, args...)
+	return fds, err
+}
+
+// RequestedFileDescriptorSet is like FileDescriptorSet, but also returns the
+// names of the proto files of the Gunk packages matching the given patterns,
+// telling them apart from their dependencies. The names follow the order of
+// the files in the set.
+func RequestedFileDescriptorSet(dir string, args ...string) (*descriptorpb.FileDescriptorSet, []string, error) {
+	fds, names, _, err := RequestedFileDescriptorSetPositions(dir, args...)
+	return fds, names, err
+}
+
+// SourcePositions maps the source paths of the elements of a proto file, as
+// formatted by protoreflect.SourcePath.String, to the positions of their
+// declarations. The file names of the positions are the import path of their
+// Gunk package joined with the name of their Gunk file, such as
+// "example.com/util/util.gunk", so that they don't depend on the machine.
+type SourcePositions map[string]token.Position
+
+// RequestedFileDescriptorSetPositions is like RequestedFileDescriptorSet, but
+// also returns the positions of the elements of the proto files translated
+// from Gunk packages, keyed by file name.
+func RequestedFileDescriptorSetPositions(dir string, args ...string) (*descriptorpb.FileDescriptorSet, []string, map[string]SourcePositions, error) {
+	fds, requested, positions, err := descriptorSet(DescriptorSetOptions{
+		IncludeImports:    true,
+		IncludeSourceInfo: true,
+	}, dir, args...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var names []string
+	for _, pfile := range fds.File {
+		if requested[pfile.GetName()] {
+			names = append(names, pfile.GetName())
+		}
+	}
+	return fds, names, positions, nil
+}
+
+// descriptorSet returns the FileDescriptorSet of the Gunk packages matching
+// args, along with the set of the names of their proto files, and the
+// positions of the elements of the translated files.
+func descriptorSet(opts DescriptorSetOptions, dir string, args ...string) (*descriptorpb.FileDescriptorSet, map[string]bool, map[string]SourcePositions, error) {
 	// TODO: share code with Run; much of this function is identical.
+	protoLoader := &loader.ProtoLoader{}
 	g := &Generator{
 		Loader: loader.Loader{
-			Dir:   dir,
-			Fset:  token.NewFileSet(),
-			Types: true,
+			Dir:         dir,
+			Fset:        token.NewFileSet(),
+			Types:       true,
+			ProtoLoader: protoLoader,
 		},
-		gunkPkgs: make(map[string]*loader.GunkPackage),
-		allProto: make(map[string]*descriptorpb.FileDescriptorProto),
+		gunkPkgs:    make(map[string]*loader.GunkPackage),
+		allProto:    make(map[string]*descriptorpb.FileDescriptorProto),
+		outOfTree:   make(map[string]outOfTreePkg),
+		protoLoader: protoLoader,
+	}
+	if err := g.useProtoDeps(); err != nil {
+		return nil, nil, nil, err
 	}
 	pkgs, err := g.Load(args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
-	if len(pkgs) != 1 {
-		return nil, fmt.Errorf("can only get filedescriptorset for a single Gunk package")
+	if len(pkgs) == 0 {
+		return nil, nil, nil, fmt.Errorf("no Gunk packages to get filedescriptorset for")
 	}
 	if loader.PrintErrors(pkgs) > 0 {
-		return nil, fmt.Errorf("encountered package loading errors")
+		return nil, nil, nil, fmt.Errorf("encountered package loading errors")
 	}
 	// Record the loaded packages in gunkPkgs.
 	g.recordPkgs(pkgs...)
 	// Translate the packages from Gunk to Proto.
+	requested := make(map[string]bool, len(pkgs))
 	for _, pkg := range pkgs {
 		if err := g.translatePkg(pkg.PkgPath); err != nil {
-			return nil, err
+			return nil, nil, nil, err
 		}
+		requested[unifiedProtoFile(pkg.PkgPath)] = true
 	}
 	// Load any non-Gunk proto dependencies.
-	if err := g.loadProtoDeps(); err != nil {
-		return nil, err
+	if err := g.loadProtoDeps(context.Background()); err != nil {
+		return nil, nil, nil, err
 	}
-	// Generate the filedescriptorset for the Gunk package.
-	req := g.requestForPkg(pkgs[0].PkgPath)
-	fds := &descriptorpb.FileDescriptorSet{File: req.ProtoFile}
-	return fds, nil
+	// Generate the filedescriptorset for the Gunk packages. Each proto
+	// file is only held once in allProto, even when several of the
+	// packages depend on it.
+	fds := &descriptorpb.FileDescriptorSet{}
+	for _, pfile := range g.sortedProtoFiles() {
+		if !opts.IncludeImports && !requested[pfile.GetName()] {
+			continue
+		}
+		if !opts.IncludeSourceInfo {
+			pfile = proto.Clone(pfile).(*descriptorpb.FileDescriptorProto)
+			pfile.SourceCodeInfo = nil
+		}
+		fds.File = append(fds.File, pfile)
+	}
+	return fds, requested, g.positions, nil
 }
 
 func NewGenerator(dir string) *Generator {
+	// The .proto imports of Gunk files are loaded the same way as the
+	// other proto dependencies.
+	protoLoader := &loader.ProtoLoader{}
 	return &Generator{
 		Loader: loader.Loader{
-			Dir:   dir,
-			Fset:  token.NewFileSet(),
-			Types: true,
+			Dir:         dir,
+			Fset:        token.NewFileSet(),
+			Types:       true,
+			ProtoLoader: protoLoader,
 		},
-		gunkPkgs:    make(map[string]*loader.GunkPackage),
-		allProto:    make(map[string]*descriptorpb.FileDescriptorProto),
-		protoLoader: &loader.ProtoLoader{},
+		gunkPkgs:       make(map[string]*loader.GunkPackage),
+		allProto:       make(map[string]*descriptorpb.FileDescriptorProto),
+		outOfTree:      make(map[string]outOfTreePkg),
+		checkedPlugins: make(map[string]bool),
+		configWarnings: make(map[string]bool),
+		versions:       make(map[string]string),
+		protoLoader:    protoLoader,
 	}
 }
 
 type Generator struct {
 	loader.Loader
+	opts        Options
 	curPkg      *loader.GunkPackage // current package being translated or generated
 	curPos      token.Pos           // current position of the token being evaluated
 	gfile       *ast.File
 	pfile       *descriptorpb.FileDescriptorProto
 	usedImports map[string]bool // imports being used for the current package
+	// imports being used by each file of the current package
+	fileUsedImports map[*ast.File]map[string]bool
+	// number of unused imports found so far, see checkUnusedImports
+	unusedImports int
+	// errors found translating the current package, see recordError
+	translateErrs translateErrors
+	// detached comments of the next element, see floatingComments
+	detached []string
+	// positions of the elements of each proto file, see addLocation
+	positions map[string]SourcePositions
 	// Maps from package import path to package information.
 	gunkPkgs map[string]*loader.GunkPackage
+	// Maps from Go import path to packages generated into a separate Go
+	// module.
+	outOfTree map[string]outOfTreePkg
+	// Number of requests written so far, see writeDebugRequest.
+	debugRequests int
+	// Plugins already checked, see checkPlugin.
+	checkedPlugins map[string]bool
+	// Config warnings already printed, see configurePkg.
+	configWarnings map[string]bool
+	// Files written by the generators for the current package so far, by
+	// path, which later generators can insert into. They are only post
+	// processed and written once all the generators ran, see flushChain.
+	chainFiles map[string]*chainFile
+	// Paths of chainFiles, in the order they were first written in.
+	chainPaths []string
 	// imported proto files will be loaded using protoLoader
 	// holds the absolute path passed to -I flag from protoc
-	protoLoader  *loader.ProtoLoader
+	protoLoader *loader.ProtoLoader
+	// The report being written, if Options.ReportFile or
+	// Options.SizeReport are set, the report of the package being
+	// generated, and the code of the generator running for it.
+	report    *Report
+	curReport *PackageReport
+	curGen    string
+	// Import path of the package being generated, which the debug
+	// requests are named after, see writeDebugRequest.
+	curGenPkg string
+	// Versions of the generator commands, see commandVersion.
+	versions map[string]string
+	// The proto types of the named Go types mapped in the [types]
+	// section of the gunkconfig of the package being translated.
+	curTypes map[string]config.TypeMapping
+	// The offset added to the numbers of the fields of the embedded
+	// messages being flattened, see flattenField.
+	numberOffset int32
+	// The files written so far, if Options.DryRun is set.
+	dryRun *dryRun
+	// Whether Options.OnlySymbol was found in any package so far.
+	foundSymbol  bool
 	allProto     map[string]*descriptorpb.FileDescriptorProto
 	messageIndex int32
 	serviceIndex int32
@@ -205,19 +614,72 @@ func (g *Generator) findPkg(path string) (*loader.GunkPackage, bool) {
 // It is fine to pass the pluginpb.CodeGeneratorRequest to every protoc generator
 // unaltered; this is what protoc does when calling out to the generators and
 // the generators should already handle the case where they have nothing to do.
-func (g *Generator) GeneratePkg(path string, gens []config.Generator, protocPath string) error {
+//
+// The generators run in the order they are configured in, so a plugin can
+// use insertion points to augment files written by the generators before it,
+// or earlier in its own response. The files are only post processed and
+// written once all the generators ran.
+//
+// Once ctx is done, the running generator is killed and the remaining ones
+// are skipped.
+//
+// Other gunk processes generating the same package wait for it to finish, so
+// that their outputs don't interleave.
+func (g *Generator) GeneratePkg(ctx context.Context, path string, gens []config.Generator, protocPath string) error {
+	if pkg, ok := g.gunkPkgs[path]; ok && g.dryRun == nil {
+		unlock, err := lockPkg(ctx, path, pkg.Dir)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
 	req := g.requestForPkg(path)
+	if g.opts.OnlySymbol != "" {
+		pkg, ok := g.gunkPkgs[path]
+		if !ok {
+			return nil
+		}
+		name, ok := localSymbol(g.opts.OnlySymbol, pkg)
+		if ok {
+			req, ok = pruneRequest(req, name)
+		}
+		if !ok {
+			// Other packages have nothing to generate.
+			return nil
+		}
+		g.foundSymbol = true
+		var symbolGens []config.Generator
+		for _, gen := range gens {
+			if supportsSymbol(gen) {
+				symbolGens = append(symbolGens, gen)
+			} else {
+				log.Verbosef("%s: skipping %s, which doesn't support --only-symbol", path, gen.Code())
+			}
+		}
+		gens = symbolGens
+	}
+	// Files written by the generators so far, which the following ones can
+	// augment via insertion points.
+	g.chainFiles = make(map[string]*chainFile)
+	g.chainPaths = nil
+	g.curGenPkg = path
 	for _, gen := range gens {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		start := time.Now()
+		g.curGen = gen.Code()
 		if gen.IsProtoc() {
 			if gen.PluginVersion != "" {
 				return fmt.Errorf("cannot use pinned version with protoc option")
 			}
-			if err := g.generateProtoc(*req, gen, protocPath); err != nil {
+			if err := g.generateProtoc(ctx, req, gen, protocPath); err != nil {
 				return fmt.Errorf("unable to generate protoc: %w", err)
 			}
+			g.recordGenerator(ctx, configWithBinary{Generator: gen}, protocPath, start)
 		} else {
 			c := configWithBinary{Generator: gen}
-			if gen.PluginVersion != "" {
+			if gen.PluginVersion != "" && !gen.InProcess {
 				has := downloader.Has(gen.Code())
 				if !has {
 					return fmt.Errorf("plugin %s does not support pinned versions", gen.Code())
@@ -228,15 +690,116 @@ func (g *Generator) GeneratePkg(path string, gens []config.Generator, protocPath
 				}
 				c.binary = &bin
 			}
-			if err := g.generatePlugin(*req, c); err != nil {
+			if gen.InProcess && inprocess.Downloaded(gen.Code()) {
+				version := inprocess.Version(gen.Code())
+				if version == "" || version == "(devel)" {
+					return fmt.Errorf("[generate %s] sets in_process, but the version gunk was built with is unknown", gen.Code())
+				}
+				bin, err := downloader.Download(gen.Code(), version)
+				if err != nil {
+					return err
+				}
+				c.binary = &bin
+			}
+			if g.opts.CheckPlugins != "" && !gen.InProcess && gen.Remote == "" {
+				if err := g.checkPlugin(ctx, c); err != nil {
+					return err
+				}
+			}
+			if err := g.generatePlugin(ctx, req, c); err != nil {
 				return fmt.Errorf("unable to generate plugin: %w", err)
 			}
+			g.recordGenerator(ctx, c, protocPath, start)
+		}
+	}
+	return g.flushChain(path)
+}
+
+// checkPinned returns an error if the config has require_pinned_versions set,
+// but protoc or one of the generators may run a version that depends on the
+// machine running Gunk.
+func checkPinned(cfg *config.Config) error {
+	if !cfg.RequirePinnedVersions {
+		return nil
+	}
+	if cfg.ProtocVersion == "" {
+		return fmt.Errorf("%s: require_pinned_versions is set, but protoc is not pinned; set version in [protoc]", cfg.Dir)
+	}
+	for _, gen := range cfg.Generators {
+		switch {
+		case gen.InProcess:
+			// Pinned by the version of gunk.
+		case gen.Remote != "":
+			if p, _ := config.ParseRemotePlugin(gen.Remote); p.Version == "" {
+				return fmt.Errorf("%s: require_pinned_versions is set, but [generate %s] runs the latest version of %s; add a version, as in %s:v1.0.0", gen.ConfigDir, gen.Code(), gen.Remote, gen.Remote)
+			}
+		case gen.IsProtoc():
+			// Builtin languages are pinned by the protoc version;
+			// other plugins are looked up in $PATH.
+			if !config.ProtocBuiltinLanguages[gen.ProtocGen] {
+				return fmt.Errorf("%s: require_pinned_versions is set, but [generate %s] runs protoc-gen-%s from $PATH; use a generator supporting plugin_version", gen.ConfigDir, gen.Code(), gen.ProtocGen)
+			}
+		case gen.PluginVersion == "":
+			if !downloader.Has(gen.Code()) {
+				return fmt.Errorf("%s: require_pinned_versions is set, but [generate %s] runs %s from $PATH and cannot be pinned", gen.ConfigDir, gen.Code(), gen.Command)
+			}
+			return fmt.Errorf("%s: require_pinned_versions is set, but [generate %s] is not pinned; set plugin_version", gen.ConfigDir, gen.Code())
+		}
+	}
+	return nil
+}
+
+// checkInProcess returns an error if a generator has in_process set, but
+// cannot run in process.
+func checkInProcess(cfg *config.Config) error {
+	for _, gen := range cfg.Generators {
+		if !gen.InProcess {
+			continue
+		}
+		if gen.IsProtoc() || !inprocess.Supported(gen.Code()) {
+			return fmt.Errorf("%s: [generate %s] sets in_process, which is only supported by the go and grpc-go generators", gen.ConfigDir, gen.Code())
+		}
+		if gen.PluginVersion != "" {
+			return fmt.Errorf("%s: [generate %s] sets both in_process and plugin_version; the in process version is set by gunk", gen.ConfigDir, gen.Code())
 		}
 	}
 	return nil
 }
 
-func (g *Generator) generateProtoc(req pluginpb.CodeGeneratorRequest, gen config.Generator, protocCommandPath string) error {
+// checkProtocGenerators returns an error if a generator is run via protoc,
+// but it is neither built into the protoc version in use nor available as a
+// protoc-gen-* plugin in $PATH. This would otherwise only be found when
+// protoc fails, after other generators may have run.
+func checkProtocGenerators(cfg *config.Config) error {
+	version := cfg.ProtocVersion
+	if version == "" {
+		version = downloader.DefaultProtocVersion
+	}
+	builtins := config.ProtocBuiltins(version)
+	for _, gen := range cfg.Generators {
+		if !gen.IsProtoc() {
+			continue
+		}
+		found := false
+		for _, lang := range builtins {
+			if lang == gen.ProtocGen {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		if _, err := exec.LookPath("protoc-gen-" + gen.ProtocGen); err == nil {
+			continue
+		}
+		return fmt.Errorf("%s: protoc %s has no builtin %q generator, and protoc-gen-%s is not in $PATH; builtin generators are %s",
+			gen.ConfigDir, version, gen.ProtocGen, gen.ProtocGen, strings.Join(builtins, ", "))
+	}
+	return nil
+}
+
+func (g *Generator) generateProtoc(ctx context.Context, req *pluginpb.CodeGeneratorRequest, gen config.Generator, protocCommandPath string) error {
 	fds := &descriptorpb.FileDescriptorSet{}
 	// Make a copy of the slice, as we may modify the elements within. See
 	// the pf2 copying below.
@@ -282,26 +845,37 @@ func (g *Generator) generateProtoc(req pluginpb.CodeGeneratorRequest, gen config
 	if err != nil {
 		return fmt.Errorf("cannot marshal deterministically: %w", err)
 	}
+	// protoc writes into a temporary directory, from which its output is
+	// read back into the files of the package, like the output of plugin
+	// generators, so that later generators can insert into it and it is
+	// only written once all of them ran.
+	tmp, err := ioutil.TempDir("", "gunk-protoc-")
+	if err != nil {
+		return err
+	}
+	cleanup := interrupt.Register(func() { os.RemoveAll(tmp) })
+	defer cleanup.Run()
+	realOut := gen.OutPath(protocOutputPath)
+	tmpGen := gen
+	tmpGen.Out = tmp
+	if isArchive(realOut) {
+		tmpGen.Out = filepath.Join(tmp, filepath.Base(realOut))
+	}
 	// Build up the protoc command line arguments.
 	args := []string{
-		fmt.Sprintf("--%s_out=%s", gen.ProtocGen, gen.ParamStringWithOut(protocOutputPath)),
+		fmt.Sprintf("--%s_out=%s", gen.ProtocGen, tmpGen.ParamStringWithOut(protocOutputPath)),
 		"--descriptor_set_in=/dev/stdin",
 	}
 	args = append(args, protoFilenames...)
-	var d *dirchanges.Watcher
-	// if we have postproc - try to watch for new files (ignore otherwise)
-	// unfortunately, protoc gives us no hint of what files it generated
-	// so we look for FS changes
-	if gen.HasPostproc() {
-		d = dirchanges.New()
-		if err := d.AddRecursive(protocOutputPath); err != nil {
-			return err
-		}
-		d.FilterOps(dirchanges.Write, dirchanges.Move, dirchanges.Rename, dirchanges.Create)
+	if err := g.writeDebugRequest("descriptorset", bs, args); err != nil {
+		return err
 	}
-	cmd := log.ExecCommand(protocCommandPath, args...)
+	cmd := log.ExecCommandContext(ctx, protocCommandPath, args...)
 	cmd.Stdin = bytes.NewReader(bs)
 	if _, err := cmd.Output(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		// TODO: For now, output the command name directly as
 		// we actually use the /path/to/protoc when executing
 		// the command, but this gives slightly uglier error
@@ -310,50 +884,88 @@ func (g *Generator) generateProtoc(req pluginpb.CodeGeneratorRequest, gen config
 		// errors (which currently don't use the /path/to/protoc-gen).
 		return log.ExecError("protoc", err)
 	}
-	if gen.HasPostproc() {
-		ev, err := d.Diff()
+	return g.readProtocOutput(gen, tmpGen.OutPath(""), realOut)
+}
+
+// readProtocOutput reads the files protoc wrote for gen to out, a temporary
+// directory or archive, into chainFiles, as if they were written to realOut.
+func (g *Generator) readProtocOutput(gen config.Generator, out, realOut string) error {
+	if isArchive(out) {
+		return g.readArchive(out, realOut, gen)
+	}
+	return filepath.Walk(out, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(out, path)
 		if err != nil {
-			return fmt.Errorf("file diff error: %w", err)
-		}
-		for _, ev := range ev {
-			if !ev.IsDir() {
-				bs, err := ioutil.ReadFile(ev.Path)
-				var nbs []byte
-				if nbs, err = postProcess(bs, gen, pkgPath, g.gunkPkgs); err != nil {
-					return fmt.Errorf("failed to execute post processing: %w", err)
-				}
-				if err := ioutil.WriteFile(ev.Path, nbs, ev.Mode()); err != nil {
-					return fmt.Errorf("failed to write to file: %w", err)
-				}
-			}
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
 		}
+		g.addChainFile(filepath.Join(realOut, rel), "", gen, data)
+		return nil
+	})
+}
+
+// runPlugin runs the plugin generator on req, marshalled as bs, and returns
+// its response. Generators with in_process set run within gunk instead, unless
+// they run as their downloaded plugin.
+func (g *Generator) runPlugin(ctx context.Context, req *pluginpb.CodeGeneratorRequest, bs []byte, gen configWithBinary) (*pluginpb.CodeGeneratorResponse, error) {
+	if gen.InProcess && !inprocess.Downloaded(gen.Code()) {
+		return inprocess.Generate(gen.Code(), req)
 	}
-	return nil
+	if gen.Remote != "" {
+		return runRemotePlugin(ctx, gen.Remote, req)
+	}
+	cmd := log.ExecCommandContext(ctx, gen.actualCommand())
+	cmd.Stdin = bytes.NewReader(bs)
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, log.ExecError(gen.actualCommand(), err)
+	}
+	var resp pluginpb.CodeGeneratorResponse
+	if err := proto.Unmarshal(out, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
 }
 
-func (g *Generator) generatePlugin(req pluginpb.CodeGeneratorRequest, gen configWithBinary) error {
+func (g *Generator) generatePlugin(ctx context.Context, req *pluginpb.CodeGeneratorRequest, gen configWithBinary) error {
+	// The request is shared by the generators of the package, and each
+	// of them gets its own parameter.
+	req = &pluginpb.CodeGeneratorRequest{
+		FileToGenerate:  req.FileToGenerate,
+		ProtoFile:       req.ProtoFile,
+		CompilerVersion: req.CompilerVersion,
+	}
 	// Due to problems with some generators (grpc-gateway),
 	// we need to ensure we either send a non-empty string or nil.
 	if ps := gen.ParamString(); ps != "" {
 		req.Parameter = proto.String(ps)
 	}
-	bs, err := protoutil.MarshalDeterministic(&req)
+	bs, err := protoutil.MarshalDeterministic(req)
 	if err != nil {
 		return fmt.Errorf("cannot marshal deterministically: %w", err)
 	}
-	cmd := log.ExecCommand(gen.actualCommand())
-	cmd.Stdin = bytes.NewReader(bs)
-	out, err := cmd.Output()
-	if err != nil {
-		return log.ExecError(gen.actualCommand(), err)
+	if err := g.writeDebugRequest("request", bs, nil); err != nil {
+		return err
 	}
-	var resp pluginpb.CodeGeneratorResponse
-	if err := proto.Unmarshal(out, &resp); err != nil {
+	resp, err := g.runPlugin(ctx, req, bs, gen)
+	if err != nil {
 		return err
 	}
 	if rerr := resp.GetError(); rerr != "" {
 		return fmt.Errorf("error from generator %s: %s", gen.Command, rerr)
 	}
+	if err := checkFeatures(gen.Command, requiredFeatures(req), resp.GetSupportedFeatures()); err != nil {
+		return err
+	}
 	ftgs := req.GetFileToGenerate()
 	if len(ftgs) != 1 {
 		return fmt.Errorf("unexpected lenght of fileToGenerate: %d (%+v)", len(ftgs), ftgs)
@@ -365,68 +977,39 @@ func (g *Generator) generatePlugin(req pluginpb.CodeGeneratorRequest, gen config
 	if !ok {
 		return fmt.Errorf("failed to get main package: %s", mainPkg)
 	}
+	outputs := g.outputMap(req, gen, mainPkg)
 	for _, rf := range resp.File {
-		// some code generators (go) return path with the full package path,
-		// some (java-grpc) return just local path relative
-		// Turn the relative package file path to the absolute
-		// on-disk file path.
-		pkgPath, basename := filepath.Split(*rf.Name)
-		pkgPath = filepath.Clean(pkgPath) // to remove trailing slashes
-
-		var dir string
-
-		gpkg, ok := g.gunkPkgs[pkgPath]
-		if !ok {
-			// for path where some prefix matches
-			// take longest matching
-			matching := ""
-			for path, pkg := range g.gunkPkgs {
-				if strings.HasPrefix(pkgPath, path) {
-					if len(path) > len(matching) {
-						ok = true
-						matching = path
-						gpkg = pkg
-						subdir := strings.TrimPrefix(pkgPath, path)
-						dir = gen.OutPath(gpkg.Dir) + subdir
-					}
-				}
-			}
-
-			if matching == "" {
-				// for local relative path
-				gpkg = mainPkg
-				dir = gen.OutPath(gpkg.Dir)
-			}
-		} else {
-			dir = gen.OutPath(gpkg.Dir)
+		// Plugins name files after what the proto files declare,
+		// such as their go_package import path or their
+		// java_package; see outputMap.
+		basename, err := gen.OutFilename(path.Base(rf.GetName()))
+		if err != nil {
+			return err
 		}
-		isNotPkg := !ok
+		outPath := filepath.Join(outputs.dirOf(rf.GetName()), basename)
 		data := []byte(*rf.Content)
-		if gen.HasPostproc() {
-			if data, err = postProcess(data, gen.Generator, mainPkgPath, g.gunkPkgs); err != nil {
-				return fmt.Errorf("failed to execute post processing: %w", err)
-			}
-		}
 
-		outPath := filepath.Join(dir, basename)
-		if isNotPkg {
-			outPath = filepath.Join(dir, *rf.Name)
+		// Files written into an archive keep the full name the
+		// plugin gave them, like protoc does.
+		archive := ""
+		if isArchive(gen.Out) {
+			archive = gen.OutPath("")
+			outPath = archiveKey(archive, path.Join(path.Dir(*rf.Name), basename))
 		}
 
-		// remove fake path
-		outPath = strings.TrimPrefix(outPath, "fake-path.com/command-line-arguments/")
-
-		// create path if not exists
-		outDir, _ := path.Split(outPath)
-		if outDir != "" {
-			if err := os.MkdirAll(outDir, os.ModePerm); err != nil {
-				return fmt.Errorf("unable to create directory %q: %w", outDir, err)
+		if point := rf.GetInsertionPoint(); point != "" {
+			// Augment a file written earlier by this or a previous
+			// generator of the package, like protoc does.
+			prev, ok := g.chainFiles[outPath]
+			if !ok {
+				return fmt.Errorf("generator %s inserts into %s, which was not generated before it", gen.Command, *rf.Name)
 			}
+			if prev.data, err = insertAtPoint(prev.data, point, data); err != nil {
+				return fmt.Errorf("generator %s cannot insert into %s: %w", gen.Command, *rf.Name, err)
+			}
+			continue
 		}
-
-		if err := ioutil.WriteFile(outPath, data, 0o644); err != nil {
-			return fmt.Errorf("unable to write to file %q: %w", outPath, err)
-		}
+		g.addChainFile(outPath, archive, gen.Generator, data)
 	}
 	return nil
 }
@@ -434,16 +1017,27 @@ func (g *Generator) generatePlugin(req pluginpb.CodeGeneratorRequest, gen config
 func (g *Generator) requestForPkg(pkgPath string) *pluginpb.CodeGeneratorRequest {
 	req := &pluginpb.CodeGeneratorRequest{}
 	req.FileToGenerate = append(req.FileToGenerate, unifiedProtoFile(pkgPath))
-	for _, pfile := range g.allProto {
-		req.ProtoFile = append(req.ProtoFile, pfile)
-	}
-	// ProtoFile must be sorted in topological order, so that each file's
-	// dependencies are satisfied by previous files. This is a requirement
-	// of some generators.
-	req.ProtoFile = topologicalSort(req.ProtoFile)
+	req.ProtoFile = g.sortedProtoFiles()
 	return req
 }
 
+// sortedProtoFiles returns all the proto files known to the generator. The
+// files are sorted in topological order, so that each file's dependencies are
+// satisfied by previous files, which is a requirement of some generators.
+// Files are sorted by name beforehand, so that the order is deterministic.
+func (g *Generator) sortedProtoFiles() []*descriptorpb.FileDescriptorProto {
+	names := make([]string, 0, len(g.allProto))
+	for name := range g.allProto {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	files := make([]*descriptorpb.FileDescriptorProto, 0, len(names))
+	for _, name := range names {
+		files = append(files, g.allProto[name])
+	}
+	return topologicalSort(files)
+}
+
 // topologicalSort sorts a number of protobuf descriptor files so that each
 // file's dependencies can be satisfied by previous files in the list. In other
 // words, it sorts the files incrementally by their dependencies.
@@ -496,7 +1090,13 @@ func (g *Generator) translatePkg(pkgPath string) error {
 		return nil
 	}
 	g.curPkg = gpkg
+	curTypes, err := typeMappings(gpkg)
+	if err != nil {
+		return err
+	}
+	g.curTypes = curTypes
 	g.usedImports = make(map[string]bool)
+	g.fileUsedImports = make(map[*ast.File]map[string]bool)
 	// Get file options for package
 	fo, err := fileOptions(gpkg)
 	if err != nil {
@@ -508,6 +1108,13 @@ func (g *Generator) translatePkg(pkgPath string) error {
 		// go compiler complains about missing slash in package path
 		protoGoPkgPath = "fake-path.com/command-line-arguments"
 	}
+	if goPkgPath, ok, err := g.outOfTreePackage(gpkg); err != nil {
+		return err
+	} else if ok {
+		// Generating into a separate Go module; since every package's
+		// GoPackage is rewritten, so are the imports between them.
+		protoGoPkgPath = goPkgPath
+	}
 
 	// Set the GoPackage file option to be the gunk package name.
 	fo.GoPackage = proto.String(protoGoPkgPath + ";" + gpkg.Name)
@@ -527,10 +1134,17 @@ func (g *Generator) translatePkg(pkgPath string) error {
 	g.messageIndex = 0
 	g.serviceIndex = 0
 	g.enumIndex = 0
+	g.translateErrs = nil
 	for i, fpath := range gpkg.GunkNames {
-		if err := g.appendFile(fpath, gpkg.GunkSyntax[i]); err != nil {
-			return fmt.Errorf("%s: %v", g.Loader.Fset.Position(g.curPos), err)
-		}
+		g.appendFile(fpath, gpkg.GunkSyntax[i])
+	}
+	if errs := g.translateErrs; len(errs) > 0 {
+		g.translateErrs = nil
+		errs.sort()
+		return errs
+	}
+	if g.opts.ReportUnusedImports || g.opts.FailUnusedImports {
+		g.checkUnusedImports(gpkg)
 	}
 	var leftToTranslate []string
 	for _, gfile := range gpkg.GunkSyntax {
@@ -541,6 +1155,14 @@ func (g *Generator) translatePkg(pkgPath string) error {
 			}
 			opath, _ := strconv.Unquote(imp.Path.Value)
 			pkg := g.gunkPkgs[opath]
+			if pkg != nil && pkg.ProtoFile != "" {
+				// A .proto import, loaded along with the other
+				// proto dependencies.
+				if g.usedImports[opath] {
+					g.addProtoDep(pkg.ProtoFile)
+				}
+				continue
+			}
 			if pkg == nil || len(pkg.GunkNames) == 0 {
 				// Not a gunk package, so no joint proto file to
 				// depend on.
@@ -567,6 +1189,37 @@ func (g *Generator) translatePkg(pkgPath string) error {
 	return nil
 }
 
+// outOfTreePkg is a Gunk package whose Go code is generated into the separate
+// Go module set with go_module_path.
+type outOfTreePkg struct {
+	moduleDir string // directory mapped to the root of the Go module
+	rel       string // package directory, relative to moduleDir
+}
+
+// outOfTreePackage returns the Go import path to generate gpkg's Go code at,
+// if its gunkconfig sets go_module_path.
+func (g *Generator) outOfTreePackage(gpkg *loader.GunkPackage) (string, bool, error) {
+	if gpkg.Dir == "" {
+		return "", false, nil
+	}
+	cfg, err := config.Load(gpkg.Dir)
+	if err != nil {
+		// Packages without a gunkconfig, such as dependencies from
+		// other modules, are generated in place.
+		return "", false, nil
+	}
+	goPkgPath, ok, err := cfg.GoPackagePath(gpkg.Dir)
+	if err != nil || !ok {
+		return "", false, err
+	}
+	rel, err := filepath.Rel(cfg.GoModuleDir, gpkg.Dir)
+	if err != nil {
+		return "", false, err
+	}
+	g.outOfTree[goPkgPath] = outOfTreePkg{moduleDir: cfg.GoModuleDir, rel: rel}
+	return goPkgPath, true, nil
+}
+
 // fileOptions will return the proto file options that have been set in the
 // gunk package. These include "JavaPackage", "Deprecated", "PhpNamespace", etc.
 func fileOptions(pkg *loader.GunkPackage) (*descriptorpb.FileOptions, error) {
@@ -628,11 +1281,12 @@ func fileOptions(pkg *loader.GunkPackage) (*descriptorpb.FileOptions, error) {
 }
 
 // appendFile translates a single gunk file to protobuf, appending its contents
-// to the package's proto file.
-func (g *Generator) appendFile(fpath string, file *ast.File) error {
+// to the package's proto file. Errors are recorded with recordError, so that
+// all the declarations of the file are translated.
+func (g *Generator) appendFile(fpath string, file *ast.File) {
 	if _, ok := g.allProto[fpath]; ok {
 		// already translated
-		return nil
+		return
 	}
 	g.gfile = file
 
@@ -640,16 +1294,96 @@ func (g *Generator) appendFile(fpath string, file *ast.File) error {
 		g.pfile.SourceCodeInfo = &descriptorpb.SourceCodeInfo{}
 	}
 
-	g.addDoc(file.Doc.Text(), packagePath)
+	// The package location only holds the package comments, and the
+	// comments above them, as every file in the package shares it.
+	g.floatingComments(nil, token.NoPos, file.Package, file.Doc)
+	var prev *descriptorpb.SourceCodeInfo_Location
+	if file.Doc != nil || len(g.detached) > 0 {
+		prev = g.addLocation(nodeRange{file.Package, file.Name.End()}, file.Doc.Text(), nil, packagePath)
+	}
+	prevEnd := file.Name.End()
 	for _, decl := range file.Decls {
 		g.curPos = decl.Pos()
+		g.floatingComments(prev, prevEnd, decl.Pos(), declDoc(decl))
+		n := len(g.pfile.SourceCodeInfo.Location)
 		if err := g.translateDecl(decl); err != nil {
-			return err
+			g.recordError(err)
+		}
+		// Only a lone type declares a single element to which the
+		// comments after it can belong.
+		prev, prevEnd = nil, decl.End()
+		if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.TYPE && len(gd.Specs) == 1 {
+			prev = g.locationAt(n)
 		}
 	}
+	g.floatingComments(prev, prevEnd, file.End(), nil)
+	g.detached = nil
+}
+
+// floatingComments handles the comments between from, the end of the element
+// at the location prev, if any, and to, the start of the next element, whose
+// doc comment is doc. As with protoc, the comment starting on the line of the
+// previous element or on the next one becomes its trailing comment, unless it
+// has one already. The others, such as section headers, are set as the
+// detached comments of the next element, see addLocation.
+func (g *Generator) floatingComments(prev *descriptorpb.SourceCodeInfo_Location, from, to token.Pos, doc *ast.CommentGroup) {
+	g.detached = nil
+	fromLine := g.Loader.Fset.Position(from).Line
+	for _, cg := range g.gfile.Comments {
+		if cg == doc || cg.Pos() < from || cg.End() > to {
+			continue
+		}
+		text := commentText(cg.Text())
+		if text == "" {
+			continue
+		}
+		line := g.Loader.Fset.Position(cg.Pos()).Line
+		switch {
+		case from.IsValid() && line <= fromLine+1 && prev != nil &&
+			prev.TrailingComments == nil && len(g.detached) == 0:
+			prev.TrailingComments = &text
+		case from.IsValid() && line == fromLine:
+			// The line comment of the previous element, which
+			// is already its trailing comment.
+		default:
+			g.detached = append(g.detached, text)
+		}
+	}
+}
+
+// locationAt returns the n-th location of the current file, or nil if it has
+// fewer locations.
+func (g *Generator) locationAt(n int) *descriptorpb.SourceCodeInfo_Location {
+	if locs := g.pfile.SourceCodeInfo.Location; n < len(locs) {
+		return locs[n]
+	}
 	return nil
 }
 
+// declDoc returns the doc comment of a top-level declaration.
+func declDoc(decl ast.Decl) *ast.CommentGroup {
+	switch decl := decl.(type) {
+	case *ast.GenDecl:
+		return decl.Doc
+	case *ast.FuncDecl:
+		return decl.Doc
+	}
+	return nil
+}
+
+// recordError records err as an error translating the current package, at
+// the position of the declaration being translated. Translation then carries
+// on with the next declaration, and the package fails once all of them were
+// translated, reporting every error at once.
+func (g *Generator) recordError(err error) {
+	if perr, ok := err.(*posError); ok {
+		// Already positioned more precisely.
+		g.translateErrs = append(g.translateErrs, perr)
+		return
+	}
+	g.translateErrs = append(g.translateErrs, &posError{pos: g.Loader.Fset.Position(g.curPos), err: err})
+}
+
 // translateDecl translates a top-level declaration in a gunk file. It
 // only acts on type declarations; struct types become proto messages,
 // interfaces become services, and basic integer types become enums.
@@ -671,38 +1405,99 @@ func (g *Generator) translateDecl(decl ast.Decl) error {
 	for _, spec := range gd.Specs {
 		ts := spec.(*ast.TypeSpec)
 		g.curPos = ts.Pos()
-		switch ts.Type.(type) {
-		case *ast.StructType:
-			msg, err := g.convertMessage(ts)
-			if err != nil {
-				return err
-			}
-			g.pfile.MessageType = append(g.pfile.MessageType, msg)
-		case *ast.InterfaceType:
-			srv, err := g.convertService(ts)
-			if err != nil {
-				return err
-			}
-			g.pfile.Service = append(g.pfile.Service, srv)
-		case *ast.Ident:
-			enum, err := g.convertEnum(ts)
-			if err != nil {
-				return err
-			}
-			// This can happen if the enum has no values.
-			if enum != nil {
-				g.pfile.EnumType = append(g.pfile.EnumType, enum)
-			}
-		default:
-			return fmt.Errorf("invalid declaration type %T", ts.Type)
+		if err := g.translateTypeSpec(ts); err != nil {
+			g.recordError(err)
+		}
+	}
+	return nil
+}
+
+// translateTypeSpec translates a type declared in a gunk file.
+func (g *Generator) translateTypeSpec(ts *ast.TypeSpec) error {
+	switch ts.Type.(type) {
+	case *ast.StructType:
+		msg, err := g.convertMessage(ts)
+		if err != nil {
+			return err
+		}
+		g.pfile.MessageType = append(g.pfile.MessageType, msg)
+	case *ast.InterfaceType:
+		srv, err := g.convertService(ts)
+		if err != nil {
+			return err
+		}
+		g.pfile.Service = append(g.pfile.Service, srv)
+	case *ast.Ident:
+		enum, err := g.convertEnum(ts)
+		if err != nil {
+			return err
 		}
+		// This can happen if the enum has no values.
+		if enum != nil {
+			g.pfile.EnumType = append(g.pfile.EnumType, enum)
+		}
+	default:
+		return fmt.Errorf("invalid declaration type %T", ts.Type)
 	}
 	return nil
 }
 
-func (g *Generator) addDoc(text string, path ...int32) {
+// addLocation adds and returns the source location of the element at path,
+// which was declared by node, along with its doc and trailing comments. The
+// detached comments found before the element by floatingComments are added
+// too.
+func (g *Generator) addLocation(node ast.Node, doc string, comment *ast.CommentGroup, path ...int32) *descriptorpb.SourceCodeInfo_Location {
+	loc := &descriptorpb.SourceCodeInfo_Location{
+		Path:                    path,
+		Span:                    g.span(node),
+		LeadingDetachedComments: g.detached,
+	}
+	g.detached = nil
+	if text := commentText(doc); text != "" {
+		loc.LeadingComments = &text
+	}
+	if text := commentText(comment.Text()); text != "" {
+		loc.TrailingComments = &text
+	}
+	g.pfile.SourceCodeInfo.Location = append(g.pfile.SourceCodeInfo.Location, loc)
+
+	pos := g.Loader.Fset.Position(node.Pos())
+	pos.Filename = g.curPkg.PkgPath + "/" + filepath.Base(pos.Filename)
+	if g.positions == nil {
+		g.positions = make(map[string]SourcePositions)
+	}
+	positions := g.positions[g.pfile.GetName()]
+	if positions == nil {
+		positions = make(SourcePositions)
+		g.positions[g.pfile.GetName()] = positions
+	}
+	positions[protoreflect.SourcePath(path).String()] = pos
+	return loc
+}
+
+// span returns the zero-based span of node in its Gunk file, as start line,
+// start column, end line and end column. The end line is left out if it's
+// the same as the start line.
+func (g *Generator) span(node ast.Node) []int32 {
+	start := g.Loader.Fset.Position(node.Pos())
+	end := g.Loader.Fset.Position(node.End())
+	if start.Line == end.Line {
+		return []int32{int32(start.Line - 1), int32(start.Column - 1), int32(end.Column - 1)}
+	}
+	return []int32{int32(start.Line - 1), int32(start.Column - 1), int32(end.Line - 1), int32(end.Column - 1)}
+}
+
+// nodeRange is an ast.Node spanning the source between two positions, such as
+// a package clause.
+type nodeRange struct{ pos, end token.Pos }
+
+func (r nodeRange) Pos() token.Pos { return r.pos }
+func (r nodeRange) End() token.Pos { return r.end }
+
+// commentText formats the text of a comment as proto's SourceCodeInfo does.
+func commentText(text string) string {
 	if text == "" {
-		return
+		return ""
 	}
 	// go's ast.TypeSpec.Doc.Text() trims left-trailing spaces on each line of multi-line comment,
 	// while proto's LeadingComments needs them
@@ -710,16 +1505,7 @@ func (g *Generator) addDoc(text string, path ...int32) {
 	// block comments still look bad, but that's not a priority now
 	lines := strings.Split(text, "\n")
 	newText := " " + strings.Join(lines, "\n ")
-	newText = strings.TrimRight(newText, " \n")
-
-	g.pfile.SourceCodeInfo.Location = append(g.pfile.SourceCodeInfo.Location,
-		&descriptorpb.SourceCodeInfo_Location{
-			Path:            path,
-			LeadingComments: &newText,
-			// just add some nonsense to satisfy protoc-gen-go
-			Span: []int32{1, 2, 3},
-		},
-	)
+	return strings.TrimRight(newText, " \n")
 }
 
 func (g *Generator) messageOptions(tspec *ast.TypeSpec) (*descriptorpb.MessageOptions, error) {
@@ -779,7 +1565,7 @@ func (g *Generator) fieldOptions(field *ast.Field) (*descriptorpb.FieldOptions,
 }
 
 func (g *Generator) convertMessage(tspec *ast.TypeSpec) (*descriptorpb.DescriptorProto, error) {
-	g.addDoc(tspec.Doc.Text(), messagePath, g.messageIndex)
+	prev := g.addLocation(tspec, tspec.Doc.Text(), tspec.Comment, messagePath, g.messageIndex)
 	msg := &descriptorpb.DescriptorProto{
 		Name: proto.String(tspec.Name.Name),
 	}
@@ -789,72 +1575,182 @@ func (g *Generator) convertMessage(tspec *ast.TypeSpec) (*descriptorpb.Descripto
 	}
 	msg.Options = messageOptions
 	stype := tspec.Type.(*ast.StructType)
-	for i, field := range stype.Fields.List {
-		if len(field.Names) != 1 {
-			return nil, fmt.Errorf("need all fields to have one name")
-		}
-		fieldName := field.Names[0].Name
-		g.addDoc(field.Doc.Text(), messagePath, g.messageIndex, messageFieldPath, int32(i))
-		ftype := g.curPkg.TypesInfo.TypeOf(field.Type)
-		g.curPos = field.Pos()
-		var ptype descriptorpb.FieldDescriptorProto_Type
-		var plabel descriptorpb.FieldDescriptorProto_Label
-		var tname string
-		var msgNestedType *descriptorpb.DescriptorProto
-		// Check to see if the type is a map. Maps need to be made into a
-		// repeated nested message containing key and value fields.
-		if mtype, ok := ftype.(*types.Map); ok {
-			ptype = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
-			plabel = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
-			var err error
-			tname, msgNestedType, err = g.convertMap(tspec.Name.Name, fieldName, mtype)
-			if err != nil {
-				return nil, err
+	prevEnd := stype.Fields.Opening
+	for _, field := range stype.Fields.List {
+		g.floatingComments(prev, prevEnd, field.Pos(), field.Doc)
+		n := len(g.pfile.SourceCodeInfo.Location)
+		prevEnd = field.End()
+		// An invalid field doesn't stop the other fields from being
+		// checked.
+		if loader.IsOneof(field) {
+			if err := g.convertOneof(tspec, msg, field); err != nil {
+				g.recordError(err)
 			}
-			msg.NestedType = append(msg.NestedType, msgNestedType)
-		} else {
-			var err error
-			ptype, plabel, tname, err = g.convertType(ftype)
-			if err != nil {
-				return nil, err
+		} else if hasPBOption(field, loader.FlattenOption) {
+			if err := g.flattenField(tspec, msg, field); err != nil {
+				g.recordError(err)
 			}
+		} else if _, err := g.convertField(tspec, msg, field); err != nil {
+			g.recordError(err)
+		}
+		prev = g.locationAt(n)
+	}
+	g.floatingComments(prev, prevEnd, stype.Fields.Closing, nil)
+	g.detached = nil
+	g.messageIndex++
+	return msg, nil
+}
+
+// convertField converts a struct field to a message field, appending it to
+// msg. Map fields also append their entry type to the nested types of msg.
+func (g *Generator) convertField(tspec *ast.TypeSpec, msg *descriptorpb.DescriptorProto, field *ast.Field) (*descriptorpb.FieldDescriptorProto, error) {
+	g.curPos = field.Pos()
+	var fieldName string
+	switch {
+	case len(field.Names) == 1:
+		fieldName = field.Names[0].Name
+	case loader.EmbeddedName(field) != "":
+		// Embedded messages are fields named after their type, as
+		// in Go, unless they're flattened.
+		fieldName = loader.EmbeddedName(field)
+	default:
+		return nil, fmt.Errorf("need all fields to have one name")
+	}
+	g.addLocation(field, field.Doc.Text(), field.Comment, messagePath, g.messageIndex, messageFieldPath, int32(len(msg.Field)))
+	ftype := g.curPkg.TypesInfo.TypeOf(field.Type)
+	var ptype descriptorpb.FieldDescriptorProto_Type
+	var plabel descriptorpb.FieldDescriptorProto_Label
+	var tname string
+	var msgNestedType *descriptorpb.DescriptorProto
+	if isEmptyInterface(ftype) {
+		// interface{} holds any JSON value, but needs the value option
+		// of the pb tag to say so.
+		if !hasPBOption(field, loader.ValueOption) {
+			return nil, fmt.Errorf("interface{} field %s needs a %q pb tag option, such as `pb:\"1,value\"`", fieldName, loader.ValueOption)
 		}
-		if ptype == 0 {
-			return nil, fmt.Errorf("unsupported field type: %v", ftype)
-		}
-		// Check that the struct field has a tag. We currently
-		// require all struct fields to have a tag; this is used
-		// to assign the position number for a field, ie: `pb:"1"`
-		if field.Tag == nil {
-			return nil, fmt.Errorf("missing required tag on %s", fieldName)
-		}
-		// Can skip the error here because we've already parsed the file.
-		str, _ := strconv.Unquote(field.Tag.Value)
-		tag := reflect.StructTag(str)
-		// TODO: record the position numbers used so we can return an
-		// error if position number is used more than once? This would
-		// also allow us to automatically assign fields a position
-		// number if it is missing one.
-		num, err := protoNumber(tag)
+		g.addProtoDep("google/protobuf/struct.proto")
+		ptype = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+		plabel = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		tname = ".google.protobuf.Value"
+	} else if hasPBOption(field, loader.FieldMaskOption) {
+		// A list of field paths, as used by update methods.
+		if !isStringSlice(ftype) {
+			return nil, fmt.Errorf("the %q pb tag option is only valid on []string fields, not on %s", loader.FieldMaskOption, fieldName)
+		}
+		g.addProtoDep("google/protobuf/field_mask.proto")
+		ptype = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+		plabel = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		tname = ".google.protobuf.FieldMask"
+	} else if mtype, ok := ftype.(*types.Map); ok && !isJSONObject(mtype) {
+		// Maps need to be made into a repeated nested message
+		// containing key and value fields, apart from
+		// map[string]interface{}, which is a google.protobuf.Struct.
+		ptype = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+		plabel = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		var err error
+		tname, msgNestedType, err = g.convertMap(tspec.Name.Name, fieldName, mtype)
 		if err != nil {
-			return nil, fmt.Errorf("unable to convert tag to number on %s: %v", fieldName, err)
+			return nil, err
 		}
-		fieldOptions, err := g.fieldOptions(field)
+		msg.NestedType = append(msg.NestedType, msgNestedType)
+	} else {
+		var err error
+		ptype, plabel, tname, err = g.convertType(ftype)
 		if err != nil {
-			return nil, fmt.Errorf("error getting field options: %v", err)
-		}
-		msg.Field = append(msg.Field, &descriptorpb.FieldDescriptorProto{
-			Name:     proto.String(fieldName),
-			Number:   num,
-			TypeName: protoStringOrNil(tname),
-			Type:     &ptype,
-			Label:    &plabel,
-			JsonName: jsonName(tag),
-			Options:  fieldOptions,
-		})
+			return nil, err
+		}
 	}
-	g.messageIndex++
-	return msg, nil
+	if ptype == 0 {
+		return nil, fmt.Errorf("unsupported field type: %v", ftype)
+	}
+	if hasPBOption(field, loader.ValueOption) && tname != ".google.protobuf.Value" {
+		return nil, fmt.Errorf("the %q pb tag option is only valid on interface{} fields, not on %s", loader.ValueOption, fieldName)
+	}
+	if hasPBOption(field, loader.FieldMaskOption) && tname != ".google.protobuf.FieldMask" {
+		return nil, fmt.Errorf("the %q pb tag option is only valid on []string fields, not on %s", loader.FieldMaskOption, fieldName)
+	}
+	if hasPBOption(field, loader.FlattenOption) {
+		return nil, fmt.Errorf("the %q pb tag option is only valid on embedded messages, not on %s", loader.FlattenOption, fieldName)
+	}
+	// Check that the struct field has a tag. We currently
+	// require all struct fields to have a tag; this is used
+	// to assign the position number for a field, ie: `pb:"1"`
+	if field.Tag == nil {
+		return nil, fmt.Errorf("missing required tag on %s", fieldName)
+	}
+	// Can skip the error here because we've already parsed the file.
+	str, _ := strconv.Unquote(field.Tag.Value)
+	tag := reflect.StructTag(str)
+	num, err := protoNumber(tag)
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert tag to number on %s: %v", fieldName, err)
+	}
+	*num += g.numberOffset
+	fieldOptions, err := g.fieldOptions(field)
+	if err != nil {
+		return nil, fmt.Errorf("error getting field options: %v", err)
+	}
+	fdesc := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(fieldName),
+		Number:   num,
+		TypeName: protoStringOrNil(tname),
+		Type:     &ptype,
+		Label:    &plabel,
+		JsonName: jsonName(tag),
+		Options:  fieldOptions,
+	}
+	if err := g.checkFieldNumber(msg, fdesc, field.Tag.Pos()); err != nil {
+		return nil, err
+	}
+	// Only flattened messages can repeat the name of a field.
+	for _, other := range msg.Field {
+		if other.GetName() == fieldName {
+			return nil, fmt.Errorf("field %s is declared more than once in %s", fieldName, msg.GetName())
+		}
+	}
+	msg.Field = append(msg.Field, fdesc)
+	return fdesc, nil
+}
+
+// convertOneof converts a struct field tagged `pb:"oneof"` to a oneof
+// declaration in msg. Each field of the group's struct type becomes a member
+// of the oneof, numbered alongside the other fields of msg.
+func (g *Generator) convertOneof(tspec *ast.TypeSpec, msg *descriptorpb.DescriptorProto, field *ast.Field) error {
+	g.curPos = field.Pos()
+	if len(field.Names) != 1 {
+		return fmt.Errorf("need all fields to have one name")
+	}
+	oneofName := field.Names[0].Name
+	if len(g.curPkg.GunkTags[field]) > 0 {
+		return fmt.Errorf("gunk tags are not supported on oneof %s", oneofName)
+	}
+	group := field.Type.(*ast.StructType)
+	if group.Fields == nil || len(group.Fields.List) == 0 {
+		return fmt.Errorf("oneof %s must have at least one field", oneofName)
+	}
+	index := int32(len(msg.OneofDecl))
+	g.addLocation(field, field.Doc.Text(), field.Comment, messagePath, g.messageIndex, messageOneofPath, index)
+	msg.OneofDecl = append(msg.OneofDecl, &descriptorpb.OneofDescriptorProto{
+		Name: proto.String(oneofName),
+	})
+	prev := g.locationAt(len(g.pfile.SourceCodeInfo.Location) - 1)
+	prevEnd := group.Fields.Opening
+	for _, member := range group.Fields.List {
+		g.floatingComments(prev, prevEnd, member.Pos(), member.Doc)
+		n := len(g.pfile.SourceCodeInfo.Location)
+		fdesc, err := g.convertField(tspec, msg, member)
+		if err != nil {
+			return err
+		}
+		prev, prevEnd = g.locationAt(n), member.End()
+		if fdesc.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+			return fmt.Errorf("oneof %s cannot contain repeated or map field %s", oneofName, fdesc.GetName())
+		}
+		fdesc.OneofIndex = proto.Int32(index)
+	}
+	g.floatingComments(prev, prevEnd, group.Fields.Closing, nil)
+	g.detached = nil
+	return nil
 }
 
 func (g *Generator) serviceOptions(tspec *ast.TypeSpec) (*descriptorpb.ServiceOptions, error) {
@@ -882,52 +1778,15 @@ func (g *Generator) methodOptions(method *ast.Field) (*descriptorpb.MethodOption
 			oValue := descriptorpb.MethodOptions_IdempotencyLevel(protoEnumValue(tag.Value))
 			o.IdempotencyLevel = &oValue
 		case "github.com/gunk/opt/http.Match":
-			// Capture the values required to use in annotations.HttpRule.
-			// We need to evaluate the entire expression, and then we can
-			// create an annotations.HttpRule.
-			var path string
-			var body string
-			method := "GET"
-			for _, elt := range tag.Expr.(*ast.CompositeLit).Elts {
-				kv := elt.(*ast.KeyValueExpr)
-				val, _ := strconv.Unquote(kv.Value.(*ast.BasicLit).Value)
-				switch name := kv.Key.(*ast.Ident).Name; name {
-				case "Method":
-					method = val
-				case "Path":
-					path = val
-					// TODO: grpc-gateway doesn't allow paths with a trailing "/", should
-					// we return an error here, because the error from grpc-gateway is very
-					// cryptic and unhelpful?
-					// https://github.com/grpc-ecosystem/grpc-gateway/issues/472
-				case "Body":
-					body = val
-				default:
-					return nil, fmt.Errorf("unknown expression key %q", name)
-				}
-			}
-			rule := &annotations.HttpRule{
-				Body: body,
+			rule, err := newHTTPRule(tag.Expr.(*ast.CompositeLit))
+			if err != nil {
+				return nil, err
 			}
 			if httpRule == nil {
 				httpRule = rule
 			} else {
 				httpRule.AdditionalBindings = append(httpRule.AdditionalBindings, rule)
 			}
-			switch method {
-			case "GET":
-				rule.Pattern = &annotations.HttpRule_Get{Get: path}
-			case "POST":
-				rule.Pattern = &annotations.HttpRule_Post{Post: path}
-			case "DELETE":
-				rule.Pattern = &annotations.HttpRule_Delete{Delete: path}
-			case "PUT":
-				rule.Pattern = &annotations.HttpRule_Put{Put: path}
-			case "PATCH":
-				rule.Pattern = &annotations.HttpRule_Patch{Patch: path}
-			default:
-				return nil, fmt.Errorf("unknown method type: %q", method)
-			}
 		case "github.com/gunk/opt/openapiv2.Operation":
 			op := &options.Operation{}
 			reflectutil.UnmarshalAST(op, tag.Expr)
@@ -945,7 +1804,78 @@ func (g *Generator) methodOptions(method *ast.Field) (*descriptorpb.MethodOption
 	return o, nil
 }
 
+// newHTTPRule converts the composite literal of an http.Match to an HTTP rule
+// without additional bindings.
+func newHTTPRule(lit *ast.CompositeLit) (*annotations.HttpRule, error) {
+	// Capture the values required to use in annotations.HttpRule.
+	// We need to evaluate the entire expression, and then we can
+	// create an annotations.HttpRule.
+	var path string
+	var body string
+	method := "GET"
+	for _, elt := range lit.Elts {
+		kv := elt.(*ast.KeyValueExpr)
+		val, _ := strconv.Unquote(kv.Value.(*ast.BasicLit).Value)
+		switch name := kv.Key.(*ast.Ident).Name; name {
+		case "Method":
+			method = val
+		case "Path":
+			path = val
+			// TODO: grpc-gateway doesn't allow paths with a trailing "/", should
+			// we return an error here, because the error from grpc-gateway is very
+			// cryptic and unhelpful?
+			// https://github.com/grpc-ecosystem/grpc-gateway/issues/472
+		case "Body":
+			body = val
+		default:
+			return nil, fmt.Errorf("unknown expression key %q", name)
+		}
+	}
+	rule := &annotations.HttpRule{
+		Body: body,
+	}
+	switch method {
+	case "GET":
+		rule.Pattern = &annotations.HttpRule_Get{Get: path}
+	case "POST":
+		rule.Pattern = &annotations.HttpRule_Post{Post: path}
+	case "DELETE":
+		rule.Pattern = &annotations.HttpRule_Delete{Delete: path}
+	case "PUT":
+		rule.Pattern = &annotations.HttpRule_Put{Put: path}
+	case "PATCH":
+		rule.Pattern = &annotations.HttpRule_Patch{Patch: path}
+	default:
+		// Any other verb, such as HEAD or OPTIONS, is a
+		// custom one.
+		if !isHTTPToken(method) {
+			return nil, fmt.Errorf("unknown method type: %q", method)
+		}
+		rule.Pattern = &annotations.HttpRule_Custom{Custom: &annotations.CustomHttpPattern{
+			Kind: method,
+			Path: path,
+		}}
+	}
+	return rule, nil
+}
+
+// isHTTPToken reports whether s is an uppercase HTTP method token, such as
+// "HEAD" or "PURGE". Methods are case-sensitive, so lowercase verbs are
+// rejected rather than silently bound as custom ones.
+func isHTTPToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'A' || r > 'Z') && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
 func (g *Generator) convertService(tspec *ast.TypeSpec) (*descriptorpb.ServiceDescriptorProto, error) {
+	prev := g.addLocation(tspec, tspec.Doc.Text(), tspec.Comment, servicePath, g.serviceIndex)
 	srv := &descriptorpb.ServiceDescriptorProto{
 		Name: proto.String(tspec.Name.Name),
 	}
@@ -955,35 +1885,53 @@ func (g *Generator) convertService(tspec *ast.TypeSpec) (*descriptorpb.ServiceDe
 	}
 	srv.Options = serviceOptions
 	itype := tspec.Type.(*ast.InterfaceType)
+	prevEnd := itype.Methods.Opening
 	for i, method := range itype.Methods.List {
-		if len(method.Names) != 1 {
-			return nil, fmt.Errorf("need all methods to have one name")
-		}
-		g.addDoc(method.Doc.Text(), servicePath, g.serviceIndex, serviceMethodPath, int32(i))
 		g.curPos = method.Pos()
-		pmethod := &descriptorpb.MethodDescriptorProto{
-			Name: proto.String(method.Names[0].Name),
-		}
-		methodOptions, err := g.methodOptions(method)
-		if err != nil {
-			return nil, fmt.Errorf("error getting method options: %v", err)
-		}
-		pmethod.Options = methodOptions
-		sign := g.curPkg.TypesInfo.TypeOf(method.Type).(*types.Signature)
-		pmethod.InputType, pmethod.ClientStreaming, err = g.convertParameter(sign.Params())
+		g.floatingComments(prev, prevEnd, method.Pos(), method.Doc)
+		n := len(g.pfile.SourceCodeInfo.Location)
+		prevEnd = method.End()
+		pmethod, err := g.convertMethod(i, method)
+		prev = g.locationAt(n)
 		if err != nil {
-			return nil, err
-		}
-		pmethod.OutputType, pmethod.ServerStreaming, err = g.convertParameter(sign.Results())
-		if err != nil {
-			return nil, err
+			// Check the other methods too.
+			g.recordError(err)
+			continue
 		}
 		srv.Method = append(srv.Method, pmethod)
 	}
+	g.floatingComments(prev, prevEnd, itype.Methods.Closing, nil)
+	g.detached = nil
 	g.serviceIndex++
 	return srv, nil
 }
 
+// convertMethod converts the i-th method of a service.
+func (g *Generator) convertMethod(i int, method *ast.Field) (*descriptorpb.MethodDescriptorProto, error) {
+	if len(method.Names) != 1 {
+		return nil, fmt.Errorf("need all methods to have one name")
+	}
+	g.addLocation(method, method.Doc.Text(), method.Comment, servicePath, g.serviceIndex, serviceMethodPath, int32(i))
+	pmethod := &descriptorpb.MethodDescriptorProto{
+		Name: proto.String(method.Names[0].Name),
+	}
+	methodOptions, err := g.methodOptions(method)
+	if err != nil {
+		return nil, fmt.Errorf("error getting method options: %v", err)
+	}
+	pmethod.Options = methodOptions
+	sign := g.curPkg.TypesInfo.TypeOf(method.Type).(*types.Signature)
+	pmethod.InputType, pmethod.ClientStreaming, err = g.convertParameter(sign.Params())
+	if err != nil {
+		return nil, err
+	}
+	pmethod.OutputType, pmethod.ServerStreaming, err = g.convertParameter(sign.Results())
+	if err != nil {
+		return nil, err
+	}
+	return pmethod, nil
+}
+
 // convertMap will translate a Go map to a Protobuf respresentation of a map,
 // returning the nested type name and definition.
 //
@@ -998,19 +1946,32 @@ func (g *Generator) convertMap(parentName, fieldName string, mapTyp *types.Map)
 	if err != nil {
 		return "", nil, err
 	}
-	keyType, _, keyTypeName, err := g.convertType(mapTyp.Key())
+	keyType, keyLabel, keyTypeName, err := g.convertType(mapTyp.Key())
 	if err != nil {
 		return "", nil, err
 	}
-	if keyType == 0 {
-		return "", nil, nil
+	if keyType == 0 || keyLabel == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+		return "", nil, fmt.Errorf("unsupported map key type: %v", mapTyp.Key())
 	}
-	elemType, _, elemTypeName, err := g.convertType(mapTyp.Elem())
+	// Protobuf only allows integral and string map keys; enums, messages,
+	// floats and bytes are all rejected by protoc.
+	switch keyType {
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM,
+		descriptorpb.FieldDescriptorProto_TYPE_MESSAGE,
+		descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+		descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+		descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		return "", nil, fmt.Errorf("invalid map key type: %v", mapTyp.Key())
+	}
+	elemType, elemLabel, elemTypeName, err := g.convertType(mapTyp.Elem())
 	if err != nil {
 		return "", nil, err
 	}
 	if elemType == 0 {
-		return "", nil, nil
+		return "", nil, fmt.Errorf("unsupported map value type: %v", mapTyp.Elem())
+	}
+	if elemLabel == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+		return "", nil, fmt.Errorf("map value type cannot be repeated: %v", mapTyp.Elem())
 	}
 	fieldLabel := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
 	nestedType := &descriptorpb.DescriptorProto{
@@ -1038,6 +1999,10 @@ func (g *Generator) convertMap(parentName, fieldName string, mapTyp *types.Map)
 	return typeName, nestedType, nil
 }
 
+// convertParameter converts the parameters or the results of a method to the
+// name of its input or output message type. A channel of messages marks them
+// as a stream, so that a method taking and returning channels is a
+// bidirectional stream.
 func (g *Generator) convertParameter(tuple *types.Tuple) (*string, *bool, error) {
 	switch tuple.Len() {
 	case 0:
@@ -1097,7 +2062,8 @@ func (g *Generator) enumValueOptions(vspec *ast.ValueSpec) (*descriptorpb.EnumVa
 }
 
 func (g *Generator) convertEnum(tspec *ast.TypeSpec) (*descriptorpb.EnumDescriptorProto, error) {
-	g.addDoc(tspec.Doc.Text(), enumPath, g.enumIndex)
+	nlocs := len(g.pfile.SourceCodeInfo.Location)
+	g.addLocation(tspec, tspec.Doc.Text(), tspec.Comment, enumPath, g.enumIndex)
 	enum := &descriptorpb.EnumDescriptorProto{
 		Name: proto.String(tspec.Name.Name),
 	}
@@ -1107,55 +2073,91 @@ func (g *Generator) convertEnum(tspec *ast.TypeSpec) (*descriptorpb.EnumDescript
 	}
 	enum.Options = enumOptions
 	enumType := g.curPkg.TypesInfo.TypeOf(tspec.Name)
+	// numbers holds the name of the first value of each number, to
+	// report aliases which the enum doesn't allow.
+	numbers := make(map[int64]string)
+	aliased := false
 	for _, decl := range g.gfile.Decls {
 		gd, ok := decl.(*ast.GenDecl)
 		if !ok || gd.Tok != token.CONST {
 			continue
 		}
-		for i, spec := range gd.Specs {
+		// Comments are only kept around the values of this enum,
+		// as the others are translated along with their own enum.
+		var prev *descriptorpb.SourceCodeInfo_Location
+		prevEnd := gd.Pos()
+		for _, spec := range gd.Specs {
 			vs := spec.(*ast.ValueSpec)
+			from := prevEnd
+			prevEnd = vs.End()
 			// .proto files have the same limitation, and it
 			// allows per-value godocs
 			if len(vs.Names) != 1 {
+				g.curPos = vs.Pos()
 				return nil, fmt.Errorf("need all value specs to define one name")
 			}
 			name := vs.Names[0]
 			if g.curPkg.TypesInfo.TypeOf(name) != enumType {
+				prev = nil
 				continue
 			}
+			g.floatingComments(prev, from, vs.Pos(), vs.Doc)
+			prev = nil
 			g.curPos = vs.Pos()
 			docText := vs.Doc.Text()
-			switch {
-			case docText == "":
-				// The original comment only had gunk tags, and
-				// no actual documentation for us to keep.
-			case strings.HasPrefix(docText, name.Name):
+			if strings.HasPrefix(docText, name.Name) {
 				// SomeVal will be exported as SomeType_SomeVal
-				docText = tspec.Name.Name + "_" + vs.Doc.Text()
-				fallthrough
-			default:
-				g.addDoc(docText, enumPath, g.enumIndex,
-					enumValuePath, int32(i))
+				docText = tspec.Name.Name + "_" + docText
 			}
 			val := g.curPkg.TypesInfo.Defs[name].(*types.Const).Val()
-			ival, _ := constant.Int64Val(val)
+			ival, ok := constant.Int64Val(val)
+			if !ok || ival < math.MinInt32 || ival > math.MaxInt32 {
+				g.recordError(fmt.Errorf("enum value %s is %s, which doesn't fit in an int32", name.Name, val))
+				continue
+			}
+			if len(enum.Value) == 0 && ival != 0 {
+				// The zero value is the default of proto3 fields.
+				g.recordError(fmt.Errorf("first value %s of enum %s is %d, but must be zero", name.Name, tspec.Name.Name, ival))
+				continue
+			}
+			if prev, ok := numbers[ival]; ok && !enumOptions.GetAllowAlias() {
+				g.recordError(fmt.Errorf("enum value %s reuses number %d of %s; add +gunk enum.AllowAlias(true) to %s to allow aliases",
+					name.Name, ival, prev, tspec.Name.Name))
+				continue
+			} else if ok {
+				aliased = true
+			} else {
+				numbers[ival] = name.Name
+			}
 			enumValueOptions, err := g.enumValueOptions(vs)
 			if err != nil {
-				return nil, fmt.Errorf("error getting enum value options: %v", err)
+				g.recordError(fmt.Errorf("error getting enum value options: %v", err))
+				continue
 			}
 
+			prev = g.addLocation(vs, docText, vs.Comment, enumPath, g.enumIndex,
+				enumValuePath, int32(len(enum.Value)))
 			enum.Value = append(enum.Value, &descriptorpb.EnumValueDescriptorProto{
 				Name:    proto.String(name.Name),
 				Number:  proto.Int32(int32(ival)),
 				Options: enumValueOptions,
 			})
 		}
+		g.floatingComments(prev, prevEnd, gd.Rparen, nil)
+		g.detached = nil
 	}
-	g.enumIndex++
-	// If an enum doesn't have any values
+	// If an enum doesn't have any values, it's left out of the file, so
+	// its locations would belong to the next enum.
 	if len(enum.Value) == 0 {
+		g.pfile.SourceCodeInfo.Location = g.pfile.SourceCodeInfo.Location[:nlocs]
 		return nil, nil
 	}
+	if enumOptions.GetAllowAlias() && !aliased {
+		// protoc rejects it too.
+		g.curPos = tspec.Pos()
+		return nil, fmt.Errorf("enum %s allows aliases, but none of its values share a number", tspec.Name.Name)
+	}
+	g.enumIndex++
 	return enum, nil
 }
 
@@ -1173,9 +2175,41 @@ func (g *Generator) qualifiedTypeName(typeName string, pkg *types.Package) (stri
 	if !ok {
 		return "", fmt.Errorf("failed to get package %s to get qualified type name", pkg.Path())
 	}
+	if gpkg.ProtoFile != "" {
+		// A .proto import, whose nested types aren't named as in
+		// proto.
+		return gpkg.ProtoTypes[typeName], nil
+	}
 	return "." + gpkg.ProtoName + "." + typeName, nil
 }
 
+// typeMappings returns the proto types of the named Go types mapped in the
+// [types] section of gpkg's gunkconfig, keyed by their import path and name.
+func typeMappings(gpkg *loader.GunkPackage) (map[string]config.TypeMapping, error) {
+	if gpkg.Dir == "" {
+		return nil, nil
+	}
+	cfg, err := config.Load(gpkg.Dir)
+	if errors.Is(err, config.ErrNoConfig) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return cfg.Types, nil
+}
+
+// mappedType returns the proto type and type name of a named Go type mapped
+// in the [types] section of a gunkconfig, adding the proto file declaring it
+// as a dependency if it's a message.
+func (g *Generator) mappedType(m config.TypeMapping) (descriptorpb.FieldDescriptorProto_Type, string) {
+	if m.File == "" {
+		ptyp := descriptorpb.FieldDescriptorProto_Type_value["TYPE_"+strings.ToUpper(m.Type)]
+		return descriptorpb.FieldDescriptorProto_Type(ptyp), ""
+	}
+	g.addProtoDep(m.File)
+	return descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, "." + m.Type
+}
+
 // convertType converts a Go field or parameter type to Protobuf, returning its
 // type descriptor, a label such as "repeated", and a name, if the final type is
 // an enum or a message.
@@ -1205,6 +2239,10 @@ func (g *Generator) convertType(typ types.Type) (descriptorpb.FieldDescriptorPro
 			return descriptorpb.FieldDescriptorProto_TYPE_BOOL, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, "", nil
 		}
 	case *types.Named:
+		if m, ok := g.curTypes[typ.String()]; ok {
+			ptyp, name := g.mappedType(m)
+			return ptyp, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, name, nil
+		}
 		switch typ.String() {
 		case "time.Time":
 			g.addProtoDep("google/protobuf/timestamp.proto")
@@ -1213,11 +2251,18 @@ func (g *Generator) convertType(typ types.Type) (descriptorpb.FieldDescriptorPro
 			g.addProtoDep("google/protobuf/duration.proto")
 			return descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, ".google.protobuf.Duration", nil
 		}
+		if pkg := typ.Obj().Pkg(); pkg != nil && pkg != g.curPkg.Types && g.gunkPkgs[pkg.Path()] == nil {
+			return 0, 0, "", fmt.Errorf("%s isn't declared in a Gunk package; map it to a proto type in the [types] section of .gunkconfig", typ)
+		}
 		fullName, err := g.qualifiedTypeName(typ.Obj().Name(), typ.Obj().Pkg())
 		if err != nil {
 			return 0, 0, "", err
 		}
-		g.usedImports[typ.Obj().Pkg().Path()] = true
+		// Record the import for every named type, including the
+		// ones reached through map keys and values or repeated
+		// fields, so that the proto dependency is added for the
+		// package declaring it.
+		g.markImportUsed(typ.Obj().Pkg())
 		switch u := typ.Underlying().(type) {
 		case *types.Basic:
 			switch u.Kind() {
@@ -1227,12 +2272,28 @@ func (g *Generator) convertType(typ types.Type) (descriptorpb.FieldDescriptorPro
 		case *types.Struct:
 			return descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, fullName, nil
 		}
+	case *types.Map:
+		if isJSONObject(typ) {
+			g.addProtoDep("google/protobuf/struct.proto")
+			return descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, ".google.protobuf.Struct", nil
+		}
+	case *types.Pointer:
+		// Pointers to scalars are nullable, so they use the wrapper
+		// messages from wrappers.proto.
+		if name := wrapperType(typ.Elem()); name != "" {
+			g.addProtoDep("google/protobuf/wrappers.proto")
+			return descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, name, nil
+		}
 	case *types.Slice:
 		if eTyp, ok := typ.Elem().(*types.Basic); ok {
 			if eTyp.Kind() == types.Byte {
 				return descriptorpb.FieldDescriptorProto_TYPE_BYTES, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, "", nil
 			}
 		}
+		if isEmptyInterface(typ.Elem()) {
+			g.addProtoDep("google/protobuf/struct.proto")
+			return descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, ".google.protobuf.ListValue", nil
+		}
 		dtyp, _, name, err := g.convertType(typ.Elem())
 		if err != nil {
 			return 0, 0, "", err
@@ -1245,6 +2306,127 @@ func (g *Generator) convertType(typ types.Type) (descriptorpb.FieldDescriptorPro
 	return 0, 0, "", nil
 }
 
+// isEmptyInterface reports whether typ is interface{}.
+func isEmptyInterface(typ types.Type) bool {
+	iface, ok := typ.(*types.Interface)
+	return ok && iface.Empty()
+}
+
+// isStringSlice reports whether typ is []string.
+func isStringSlice(typ types.Type) bool {
+	slice, ok := typ.(*types.Slice)
+	if !ok {
+		return false
+	}
+	elem, ok := slice.Elem().(*types.Basic)
+	return ok && elem.Kind() == types.String
+}
+
+// isJSONObject reports whether typ is map[string]interface{}, which maps to
+// google.protobuf.Struct.
+func isJSONObject(typ *types.Map) bool {
+	key, ok := typ.Key().(*types.Basic)
+	return ok && key.Kind() == types.String && isEmptyInterface(typ.Elem())
+}
+
+// hasPBOption reports whether the pb tag of field has the option opt.
+func hasPBOption(field *ast.Field, opt string) bool {
+	if field.Tag == nil {
+		return false
+	}
+	str, _ := strconv.Unquote(field.Tag.Value)
+	_, opts := loader.SplitPBTag(reflect.StructTag(str).Get("pb"))
+	for _, o := range opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// wrapperType returns the name of the google.protobuf wrapper message for the
+// scalar type typ, or an empty string if there is none.
+func wrapperType(typ types.Type) string {
+	if slice, ok := typ.(*types.Slice); ok {
+		if elem, ok := slice.Elem().(*types.Basic); ok && elem.Kind() == types.Byte {
+			return ".google.protobuf.BytesValue"
+		}
+		return ""
+	}
+	basic, ok := typ.(*types.Basic)
+	if !ok {
+		return ""
+	}
+	switch basic.Kind() {
+	case types.String:
+		return ".google.protobuf.StringValue"
+	case types.Int, types.Int32:
+		return ".google.protobuf.Int32Value"
+	case types.Uint, types.Uint32:
+		return ".google.protobuf.UInt32Value"
+	case types.Int64:
+		return ".google.protobuf.Int64Value"
+	case types.Uint64:
+		return ".google.protobuf.UInt64Value"
+	case types.Float32:
+		return ".google.protobuf.FloatValue"
+	case types.Float64:
+		return ".google.protobuf.DoubleValue"
+	case types.Bool:
+		return ".google.protobuf.BoolValue"
+	}
+	return ""
+}
+
+// markImportUsed records that the current package uses a type declared in pkg,
+// so that translatePkg adds pkg's proto file as a dependency.
+func (g *Generator) markImportUsed(pkg *types.Package) {
+	if pkg == nil || pkg.Path() == g.curPkg.PkgPath {
+		return
+	}
+	g.usedImports[pkg.Path()] = true
+	if g.gfile != nil {
+		used := g.fileUsedImports[g.gfile]
+		if used == nil {
+			used = make(map[string]bool)
+			g.fileUsedImports[g.gfile] = used
+		}
+		used[pkg.Path()] = true
+	}
+}
+
+// checkUnusedImports reports the Gunk imports of each file in pkg which none
+// of the file's declarations use, and which are thus pruned from the proto
+// file. Imports only used by +gunk tags, such as the option packages, are not
+// reported.
+func (g *Generator) checkUnusedImports(pkg *loader.GunkPackage) {
+	tagImports := make(map[string]bool)
+	for _, tags := range pkg.GunkTags {
+		for _, tag := range tags {
+			if named, ok := tag.Type.(*types.Named); ok && named.Obj().Pkg() != nil {
+				tagImports[named.Obj().Pkg().Path()] = true
+			}
+		}
+	}
+	for _, gfile := range pkg.GunkSyntax {
+		for _, imp := range gfile.Imports {
+			if imp.Name != nil && imp.Name.Name == "_" {
+				continue
+			}
+			opath, _ := strconv.Unquote(imp.Path.Value)
+			ipkg := g.gunkPkgs[opath]
+			if ipkg == nil || (len(ipkg.GunkNames) == 0 && ipkg.ProtoFile == "") {
+				continue
+			}
+			if g.fileUsedImports[gfile][opath] || tagImports[opath] {
+				continue
+			}
+			g.warnf("%s: unused import %q pruned", g.Loader.Fset.Position(imp.Pos()), opath)
+			g.unusedImports++
+		}
+	}
+}
+
 // addProtoDep is called when a gunk file is known to require importing of a
 // proto file, such as when using google.protobuf.Empty.
 func (g *Generator) addProtoDep(protoPath string) {
@@ -1256,9 +2438,9 @@ func (g *Generator) addProtoDep(protoPath string) {
 	g.pfile.Dependency = append(g.pfile.Dependency, protoPath)
 }
 
-// loadProtoDeps loads all the missing proto dependencies added with
-// addProtoDep.
-func (g *Generator) loadProtoDeps() error {
+// missingProtoDeps returns the proto dependencies added with addProtoDep
+// which aren't loaded yet.
+func (g *Generator) missingProtoDeps() []string {
 	loaded := make(map[string]bool)
 	var list []string
 	for _, pfile := range g.allProto {
@@ -1269,7 +2451,34 @@ func (g *Generator) loadProtoDeps() error {
 			}
 		}
 	}
-	files, err := g.protoLoader.LoadProto(list...)
+	return list
+}
+
+// allBundled reports whether all the proto files are bundled with Gunk, so
+// that loading them doesn't need protoc.
+func allBundled(names []string) bool {
+	for _, name := range names {
+		if !loader.IsBundled(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// usesProtoc reports whether any of the generators runs via protoc.
+func usesProtoc(gens []config.Generator) bool {
+	for _, gen := range gens {
+		if gen.IsProtoc() {
+			return true
+		}
+	}
+	return false
+}
+
+// loadProtoDeps loads all the missing proto dependencies added with
+// addProtoDep.
+func (g *Generator) loadProtoDeps(ctx context.Context) error {
+	files, err := g.protoLoader.LoadProtoContext(ctx, g.missingProtoDeps()...)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,189 @@
+package generate
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gunk/gunk/config"
+	"github.com/gunk/gunk/loader"
+)
+
+const generatedHeader = "// Code generated by gunk. DO NOT EDIT.\n\n"
+
+// isGoGenerator reports whether gen writes Go code, and thus contributes to
+// the Go module set with go_module_path.
+func isGoGenerator(gen config.Generator) bool {
+	switch gen.Code() {
+	case "go", "grpc-go", "grpc-gateway":
+		return true
+	}
+	return false
+}
+
+// writeGoModuleStubs writes the files which make the output of go_module_path
+// a complete Go module, as configured in the [go_module] section: a go.mod
+// unless one exists already, a doc.go for each package, the license file and
+// a version.go.
+func (g *Generator) writeGoModuleStubs(pkgs []*loader.GunkPackage, pkgConfigs map[string]*config.Config) error {
+	// The version.go at the root of a module belongs to the package
+	// generated there, if any.
+	rootPkgs := make(map[string]string)
+	for _, pkg := range pkgs {
+		cfg := pkgConfigs[pkg.Dir]
+		if cfg.GoModule == nil || cfg.GoModulePath == "" {
+			continue
+		}
+		if filepath.Clean(pkg.Dir) == filepath.Clean(cfg.GoModuleDir) {
+			rootPkgs[cfg.GoModuleDir] = pkg.Name
+		}
+	}
+	written := make(map[string]bool)
+	for _, pkg := range pkgs {
+		cfg := pkgConfigs[pkg.Dir]
+		if cfg.GoModule == nil || cfg.GoModulePath == "" {
+			continue
+		}
+		rel, err := filepath.Rel(cfg.GoModuleDir, pkg.Dir)
+		if err != nil {
+			return err
+		}
+		for _, gen := range cfg.Generators {
+			if !isGoGenerator(gen) {
+				continue
+			}
+			root := gen.OutPath(cfg.GoModuleDir)
+			if !written[root] {
+				written[root] = true
+				if err := g.writeGoModuleRoot(root, cfg, rootPkgs[cfg.GoModuleDir]); err != nil {
+					return err
+				}
+			}
+			if cfg.GoModule.Doc {
+				if err := g.writeDocGo(filepath.Join(root, rel), pkg); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeGoModuleRoot writes the go.mod, license and version.go files at the
+// root of a module. pkgName is the name of the package generated at the root,
+// if any.
+func (g *Generator) writeGoModuleRoot(root string, cfg *config.Config, pkgName string) error {
+	// Only write go.mod if it doesn't exist yet, as its requirements are
+	// maintained by the go tool afterwards.
+	modPath := filepath.Join(root, "go.mod")
+	if _, err := os.Stat(modPath); os.IsNotExist(err) {
+		mod := fmt.Sprintf("module %s\n\ngo %s\n", cfg.GoModulePath, cfg.GoModule.GoVersion)
+		if err := g.writeFile(modPath, []byte(mod)); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	if license := cfg.GoModule.License; license != "" {
+		data, err := ioutil.ReadFile(license)
+		if err != nil {
+			return fmt.Errorf("unable to read license: %w", err)
+		}
+		dst := filepath.Join(root, filepath.Base(license))
+		if err := g.writeFile(dst, data); err != nil {
+			return err
+		}
+	}
+	if version := cfg.GoModule.Version; version != "" {
+		if pkgName == "" {
+			name, err := existingPackageName(root)
+			if err != nil {
+				return err
+			}
+			pkgName = modulePackageName(cfg.GoModulePath)
+			if name != "" && name != pkgName {
+				return fmt.Errorf("cannot write version.go: package %s is already declared at the root of module %s", name, cfg.GoModulePath)
+			}
+		}
+		src := fmt.Sprintf("%spackage %s\n\n// Version is the version of the %s module.\nconst Version = %q\n",
+			generatedHeader, pkgName, cfg.GoModulePath, version)
+		dst := filepath.Join(root, "version.go")
+		if err := g.writeFile(dst, []byte(src)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDocGo writes a doc.go file holding the package documentation of pkg,
+// taken from the doc comments of its Gunk files.
+func (g *Generator) writeDocGo(dir string, pkg *loader.GunkPackage) error {
+	doc := packageDoc(pkg)
+	if doc == "" {
+		doc = fmt.Sprintf("Package %s contains the code generated from the Gunk package %s.", pkg.Name, pkg.PkgPath)
+	}
+	var sb strings.Builder
+	sb.WriteString(generatedHeader)
+	for _, line := range strings.Split(doc, "\n") {
+		if line == "" {
+			sb.WriteString("//\n")
+			continue
+		}
+		sb.WriteString("// " + line + "\n")
+	}
+	fmt.Fprintf(&sb, "package %s\n", pkg.Name)
+	return g.writeFile(filepath.Join(dir, "doc.go"), []byte(sb.String()))
+}
+
+// packageDoc returns the package documentation of pkg, from the doc comments
+// of its Gunk files.
+func packageDoc(pkg *loader.GunkPackage) string {
+	var doc []string
+	for _, file := range pkg.GunkSyntax {
+		if text := strings.TrimSpace(file.Doc.Text()); text != "" {
+			doc = append(doc, text)
+		}
+	}
+	return strings.Join(doc, "\n\n")
+}
+
+// existingPackageName returns the name of the package declared by the Go
+// files in dir, other than version.go, or an empty string if there are none.
+func existingPackageName(dir string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return "", err
+	}
+	fset := token.NewFileSet()
+	for _, path := range matches {
+		if filepath.Base(path) == "version.go" || strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, path, nil, parser.PackageClauseOnly)
+		if err != nil {
+			return "", err
+		}
+		return f.Name.Name, nil
+	}
+	return "", nil
+}
+
+// modulePackageName returns the package name to use for the root package of a
+// Go module, skipping major version suffixes such as "v2".
+func modulePackageName(modPath string) string {
+	elems := strings.Split(modPath, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		name = elems[len(elems)-2]
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, name)
+}
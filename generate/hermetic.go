@@ -0,0 +1,232 @@
+package generate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gunk/gunk/interrupt"
+	"github.com/gunk/gunk/workspace"
+)
+
+// runHermetic runs the generation with opts in a temporary copy of the
+// module of dir, or of its workspace, and then writes the files which were
+// added or changed in the copy back into the module. Nothing is written into
+// the module if the generation fails.
+func runHermetic(ctx context.Context, opts Options, dir string, args ...string) (err error) {
+	root, err := hermeticRoot(dir)
+	if err != nil {
+		return errorf(LoadError, "unable to find the module to copy: %w", err)
+	}
+	tmp, err := ioutil.TempDir("", "gunk-hermetic-")
+	if err != nil {
+		return errorf(LoadError, "unable to create hermetic workspace: %w", err)
+	}
+	cleanup := interrupt.Register(func() { os.RemoveAll(tmp) })
+	defer cleanup.Run()
+	// The paths listed by the go tool have their symlinks resolved.
+	if tmp, err = filepath.EvalSymlinks(tmp); err != nil {
+		return err
+	}
+	// The copy is made at the path of root below fsRoot, so that relative
+	// paths leading out of the module, such as an out directory next to
+	// it, stay in the temporary directory and are written back too.
+	fsRoot := filepath.Join(tmp, "root")
+	ws := filepath.Join(fsRoot, strings.TrimPrefix(root, filepath.VolumeName(root)))
+	sources, err := copySources(root, ws)
+	if err != nil {
+		return errorf(LoadError, "unable to copy sources to hermetic workspace: %w", err)
+	}
+	// Paths within the module are moved into the copy. Other paths, such
+	// as an out root outside of the module, are written to directly.
+	inWorkspace := func(path string) string {
+		if !filepath.IsAbs(path) {
+			return path
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return path
+		}
+		return filepath.Join(ws, rel)
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	wsArgs := make([]string, len(args))
+	for i, arg := range args {
+		wsArgs[i] = inWorkspace(arg)
+	}
+	inner := opts
+	inner.Hermetic = false
+	inner.OutRoot = inWorkspace(opts.OutRoot)
+	if opts.ReportFile != "" {
+		// The report refers to the files of the copy, so it's
+		// rewritten to refer to those of the module.
+		inner.ReportFile = filepath.Join(tmp, "report.json")
+		defer func() {
+			if rerr := rewriteReport(inner.ReportFile, opts.ReportFile, fsRoot, root); rerr != nil && err == nil {
+				err = rerr
+			}
+		}()
+	}
+	if err := RunContext(ctx, inner, inWorkspace(absDir), wsArgs...); err != nil {
+		return err
+	}
+	if err := syncOutputs(fsRoot, root, sources); err != nil {
+		return errorf(GeneratorError, "unable to write generated files: %w", err)
+	}
+	return nil
+}
+
+// hermeticRoot returns the directory copied for a hermetic run in dir: the
+// directory of its gunk.work file if dir is within a workspace, or else the
+// root of its module. Outside of a module, dir itself is copied.
+func hermeticRoot(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if ws, err := workspace.Find(dir); err != nil {
+		return "", err
+	} else if ws != nil && ws.Contains(dir) {
+		return ws.Dir, nil
+	}
+	for mod := dir; ; {
+		if _, err := os.Stat(filepath.Join(mod, "go.mod")); err == nil {
+			return mod, nil
+		}
+		parent := filepath.Dir(mod)
+		if parent == mod {
+			return dir, nil
+		}
+		mod = parent
+	}
+}
+
+// skipCopy reports whether the directory name is skipped when copying the
+// sources of a module, such as .git and node_modules.
+func skipCopy(name string) bool {
+	return strings.HasPrefix(name, ".") || name == "node_modules"
+}
+
+// copySources copies the files under root to dst, skipping the directories
+// which can't hold Gunk sources or configuration. It returns the checksums of
+// the copied files, by path relative to root.
+func copySources(root, dst string) (map[string][sha256.Size]byte, error) {
+	sums := make(map[string][sha256.Size]byte)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir() && path != root && skipCopy(info.Name()):
+			return filepath.SkipDir
+		case info.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case info.Mode()&os.ModeSymlink != 0:
+			// Copy the contents of linked files, so that writing
+			// to the copy doesn't write to the link's target.
+			fi, err := os.Stat(path)
+			if err != nil || fi.IsDir() {
+				return nil
+			}
+			info = fi
+		case !info.Mode().IsRegular():
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sums[rel] = sha256.Sum256(data)
+		return ioutil.WriteFile(target, data, info.Mode().Perm()|0o200)
+	})
+	return sums, err
+}
+
+// syncOutputs writes the files which the generation added or changed below
+// fsRoot, as told by the checksums of the sources copied from root, to the
+// same paths on disk. Files which are already the same are left as they are,
+// keeping their modification times, and sources left unchanged by the
+// generation are never written, in case they were edited in root meanwhile.
+func syncOutputs(fsRoot, root string, sources map[string][sha256.Size]byte) error {
+	return filepath.Walk(fsRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(fsRoot, path)
+		if err != nil {
+			return err
+		}
+		target := realPath(root, rel)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if srcRel, err := filepath.Rel(root, target); err == nil {
+			if sum, ok := sources[srcRel]; ok && sum == sha256.Sum256(data) {
+				return nil
+			}
+		}
+		if old, err := ioutil.ReadFile(target); err == nil && bytes.Equal(old, data) {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, data, info.Mode().Perm())
+	})
+}
+
+// realPath returns the path on disk of a file copied to the path rel below
+// the fsRoot of root.
+func realPath(root, rel string) string {
+	return filepath.Join(filepath.VolumeName(root)+string(filepath.Separator), rel)
+}
+
+// rewriteReport writes the report of a hermetic run, read from the file
+// from, to the file to, with the paths below fsRoot in the copy of root
+// replaced by their paths on disk.
+func rewriteReport(from, to, fsRoot, root string) error {
+	data, err := ioutil.ReadFile(from)
+	if os.IsNotExist(err) {
+		// The run failed before the report was started.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return err
+	}
+	rewrite := func(files []FileHash) {
+		for i, f := range files {
+			if rel, err := filepath.Rel(fsRoot, f.Path); err == nil && !strings.HasPrefix(rel, "..") {
+				files[i].Path = realPath(root, rel)
+			}
+		}
+	}
+	for _, pr := range report.Packages {
+		rewrite(pr.Inputs)
+		rewrite(pr.Outputs)
+	}
+	if data, err = json.MarshalIndent(report, "", "\t"); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(to, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("unable to write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+#!/bin/bash
+
+# Copies the generator of protoc-gen-go-grpc, which is a main package and so
+# cannot be imported. Its require_unimplemented_servers flag is passed down to
+# the file generator as a parameter instead of a global, so that concurrent
+# generations with different parameters don't race.
+
+SRC=$(realpath $(cd -P "$(dirname "${BASH_SOURCE[0]}")" && pwd))
+
+set -e
+
+VERSION=1.2.0
+MOD=google.golang.org/grpc/cmd/protoc-gen-go-grpc@v$VERSION
+
+go mod download $MOD
+PKGPATH=$(go mod download -json $MOD | sed -n 's/.*"Dir": "\(.*\)".*/\1/p')
+
+sed -e 's/^package main$/package inprocess/' \
+  -e 's/^\(func \(generateFile\|generateFileContent\|genService\)(.*\)) /\1, requireUnimplemented bool) /' \
+  -e 's/\(\tgenerateFileContent(gen, file, g\|\t\tgenService(gen, file, g, service\))$/\1, requireUnimplemented)/' \
+  -e 's/\*requireUnimplemented/requireUnimplemented/' \
+  $PKGPATH/grpc.go > $SRC/grpc.go
+sed -i -e "s/^const version = .*/const version = \"$VERSION\"/" $SRC/inprocess.go
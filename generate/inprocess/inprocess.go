@@ -0,0 +1,87 @@
+// Package inprocess runs the gRPC generator within gunk, rather than as the
+// protoc-gen-go-grpc plugin, so that it doesn't need to be installed or
+// downloaded.
+//
+// The Go generator has no public API, so with in_process it runs as the
+// protoc-gen-go plugin of the protobuf version gunk is built with, downloaded
+// like any pinned plugin.
+package inprocess
+
+//go:generate ./gen.sh
+
+import (
+	"flag"
+	"fmt"
+	"runtime/debug"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// version is the version of protoc-gen-go-grpc which grpc.go is copied from.
+const version = "1.2.0"
+
+// Supported reports whether the generator with the given code, such as "go",
+// can run in process.
+func Supported(code string) bool {
+	switch code {
+	case "go", "grpc-go":
+		return true
+	}
+	return false
+}
+
+// Downloaded reports whether the generator with the given code, when set to
+// run in process, runs as its plugin downloaded at Version instead.
+func Downloaded(code string) bool {
+	return code == "go"
+}
+
+// Version returns the version of the plugin the generator with the given code
+// runs as in process, or an empty string if it is unknown.
+func Version(code string) string {
+	switch code {
+	case "go":
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, dep := range info.Deps {
+				if dep.Path == "google.golang.org/protobuf" {
+					return dep.Version
+				}
+			}
+		}
+	case "grpc-go":
+		return "v" + version
+	}
+	return ""
+}
+
+// Generate runs the generator with the given code on req, like its plugin
+// would. Errors from the generator itself are in the response, as with
+// plugins.
+func Generate(code string, req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	var flags flag.FlagSet
+	var run func(gen *protogen.Plugin) error
+	switch code {
+	case "grpc-go":
+		requireUnimplemented := flags.Bool("require_unimplemented_servers", true, "set to false to match legacy behavior")
+		run = func(gen *protogen.Plugin) error {
+			gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+			for _, f := range gen.Files {
+				if f.Generate {
+					generateFile(gen, f, *requireUnimplemented)
+				}
+			}
+			return nil
+		}
+	default:
+		return nil, fmt.Errorf("generator %s cannot run in process", code)
+	}
+	gen, err := protogen.Options{ParamFunc: flags.Set}.New(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := run(gen); err != nil {
+		gen.Error(err)
+	}
+	return gen.Response(), nil
+}
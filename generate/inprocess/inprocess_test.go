@@ -0,0 +1,61 @@
+package inprocess
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func TestGenerateConcurrentParameters(t *testing.T) {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("example.com/echo/all.proto"),
+		Package: proto.String("echo"),
+		Syntax:  proto.String("proto3"),
+		Options: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/echo")},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Message")},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Echo"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("Echo"),
+				InputType:  proto.String(".echo.Message"),
+				OutputType: proto.String(".echo.Message"),
+			}},
+		}},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		require := i%2 == 0
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			param := "require_unimplemented_servers=false"
+			if require {
+				param = "require_unimplemented_servers=true"
+			}
+			resp, err := Generate("grpc-go", &pluginpb.CodeGeneratorRequest{
+				FileToGenerate: []string{file.GetName()},
+				ProtoFile:      []*descriptorpb.FileDescriptorProto{file},
+				Parameter:      proto.String(param),
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if resp.GetError() != "" || len(resp.File) != 1 {
+				t.Errorf("got error %q and %d files, want one file", resp.GetError(), len(resp.File))
+				return
+			}
+			got := strings.Contains(resp.File[0].GetContent(), "All implementations must embed")
+			if got != require {
+				t.Errorf("with %s, got implementations required to embed UnimplementedEchoServer: %v", param, got)
+			}
+		}()
+	}
+	wg.Wait()
+}
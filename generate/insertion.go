@@ -0,0 +1,107 @@
+package generate
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/gunk/gunk/config"
+)
+
+// chainFile is a file written by a generator of the package being generated,
+// which the generators following it can insert into.
+type chainFile struct {
+	data []byte
+	// gen is the generator which wrote the file, whose post processing
+	// runs on it once all the generators ran.
+	gen config.Generator
+	// archive is the archive output the file is written into, if any.
+	archive string
+}
+
+// addChainFile records the file written by gen at path, replacing any file
+// written there before, like protoc does. Files written into an archive have
+// a path made by archiveKey.
+func (g *Generator) addChainFile(path, archive string, gen config.Generator, data []byte) {
+	if _, ok := g.chainFiles[path]; !ok {
+		g.chainPaths = append(g.chainPaths, path)
+	}
+	g.chainFiles[path] = &chainFile{data: data, gen: gen, archive: archive}
+}
+
+// flushChain post processes the files written by the generators of the
+// package pkgPath and writes them out. Each file is post processed once, by
+// the generator which wrote it, including what later generators inserted
+// into it.
+func (g *Generator) flushChain(pkgPath string) error {
+	// The first file of each archive, which is written once all its
+	// files are processed.
+	var archives []*chainFile
+	seen := make(map[string]bool)
+	for _, path := range g.chainPaths {
+		f := g.chainFiles[path]
+		g.curGen = f.gen.Code()
+		if f.gen.HasPostproc() {
+			data, err := postProcess(f.data, f.gen, pkgPath, g.gunkPkgs)
+			if err != nil {
+				return fmt.Errorf("failed to execute post processing: %w", err)
+			}
+			f.data = data
+		}
+		if f.archive != "" {
+			if !seen[f.archive] {
+				seen[f.archive] = true
+				archives = append(archives, f)
+			}
+			continue
+		}
+		if err := g.writeFile(path, f.data); err != nil {
+			return err
+		}
+		g.recordOutput(path, f.data)
+	}
+	for _, f := range archives {
+		g.curGen = f.gen.Code()
+		if err := g.writeArchive(f.archive); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertAtPoint inserts content into data right before the line holding the
+// "@@protoc_insertion_point(point)" marker, following protoc's semantics:
+// every inserted line is indented by the spaces which indent the marker line,
+// so that a generator can add code without knowing the surrounding nesting.
+func insertAtPoint(data []byte, point string, content []byte) ([]byte, error) {
+	marker := []byte("@@protoc_insertion_point(" + point + ")")
+	i := bytes.Index(data, marker)
+	if i < 0 {
+		return nil, fmt.Errorf("insertion point %q not found", point)
+	}
+	lineStart := bytes.LastIndexByte(data[:i], '\n') + 1
+	indent := 0
+	for lineStart+indent < i && data[lineStart+indent] == ' ' {
+		indent++
+	}
+	prefix := data[lineStart : lineStart+indent]
+	var buf bytes.Buffer
+	buf.Grow(len(data) + len(content))
+	buf.Write(data[:lineStart])
+	for len(content) > 0 {
+		line := content
+		if j := bytes.IndexByte(content, '\n'); j >= 0 {
+			line = content[:j+1]
+		}
+		content = content[len(line):]
+		if len(line) > 1 || line[0] != '\n' {
+			// Like protoc, don't indent empty lines.
+			buf.Write(prefix)
+		}
+		buf.Write(line)
+		if line[len(line)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	buf.Write(data[lineStart:])
+	return buf.Bytes(), nil
+}
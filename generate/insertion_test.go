@@ -0,0 +1,44 @@
+package generate
+
+import "testing"
+
+func TestInsertAtPoint(t *testing.T) {
+	tests := []struct {
+		data     string
+		point    string
+		content  string
+		expected string
+	}{
+		{
+			data:     "a\n// @@protoc_insertion_point(imports)\nb\n",
+			point:    "imports",
+			content:  "import x\n",
+			expected: "a\nimport x\n// @@protoc_insertion_point(imports)\nb\n",
+		},
+		{
+			data:     "class A {\n  // @@protoc_insertion_point(class_scope:A)\n}\n",
+			point:    "class_scope:A",
+			content:  "int x;\n\nint y;",
+			expected: "class A {\n  int x;\n\n  int y;\n  // @@protoc_insertion_point(class_scope:A)\n}\n",
+		},
+		{
+			data:     "// @@protoc_insertion_point(first)\n// @@protoc_insertion_point(second)\n",
+			point:    "second",
+			content:  "x\n",
+			expected: "// @@protoc_insertion_point(first)\nx\n// @@protoc_insertion_point(second)\n",
+		},
+	}
+	for _, tc := range tests {
+		res, err := insertAtPoint([]byte(tc.data), tc.point, []byte(tc.content))
+		if err != nil {
+			t.Errorf("unexpected error inserting at %q: %v", tc.point, err)
+			continue
+		}
+		if string(res) != tc.expected {
+			t.Errorf("wrong insertion at %q, got %q expected %q", tc.point, res, tc.expected)
+		}
+	}
+	if _, err := insertAtPoint([]byte("a\n"), "missing", []byte("x\n")); err == nil {
+		t.Errorf("expected error for missing insertion point")
+	}
+}
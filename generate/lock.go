@@ -0,0 +1,123 @@
+package generate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gunk/gunk/generate/downloader"
+	"github.com/gunk/gunk/log"
+)
+
+// lockWait is how long to wait for the lock of a package before printing
+// which process holds it.
+var lockWait = time.Second
+
+// lockPkg takes an advisory lock on generating the Gunk package pkgPath in
+// dir, waiting for any other gunk process generating it, such as an editor
+// watching the package while a script runs gunk generate. Otherwise, their
+// outputs could interleave and corrupt the generated files.
+//
+// The locks are files in the cache directory, one per package directory,
+// locked with the OS. A lock is released by the OS once the process holding
+// it exits, even if it crashed, so it can't go stale. The holder records its
+// process in the file, which is printed while waiting, and which tells that a
+// previous holder exited without unlocking if it is still there.
+func lockPkg(ctx context.Context, pkgPath, dir string) (unlock func(), err error) {
+	cacheDir, err := downloader.CacheDir()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(dir))
+	path := filepath.Join(cacheDir, "locks", hex.EncodeToString(sum[:8])+".lock")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	type result struct {
+		f   *os.File
+		err error
+	}
+	locked := make(chan result, 1)
+	go func() {
+		f, err := openLocked(path)
+		locked <- result{f, err}
+	}()
+	timer := time.NewTimer(lockWait)
+	defer timer.Stop()
+	for {
+		select {
+		case r := <-locked:
+			if r.err != nil {
+				return nil, fmt.Errorf("unable to lock %s: %w", pkgPath, r.err)
+			}
+			if prev, _ := ioutil.ReadAll(r.f); len(prev) > 0 {
+				log.Verbosef("taking over the lock on %s left by %s, which exited", pkgPath, lockOwner(prev))
+			}
+			host, _ := os.Hostname()
+			if err := writeLockOwner(r.f, fmt.Sprintf("%d %s", os.Getpid(), host)); err != nil {
+				r.f.Close()
+				return nil, err
+			}
+			return func() {
+				writeLockOwner(r.f, "")
+				r.f.Close()
+			}, nil
+		case <-timer.C:
+			// The file is only read to tell who holds the lock, so
+			// it is read without locking it.
+			owner, _ := ioutil.ReadFile(path)
+			log.Printf("waiting for %s, which is generating %s", lockOwner(owner), pkgPath)
+		case <-ctx.Done():
+			// Release the lock once taken, as nothing will use it.
+			go func() {
+				if r := <-locked; r.err == nil {
+					r.f.Close()
+				}
+			}()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// openLocked opens the file at path, creating it if needed, and locks it,
+// waiting for any other process holding its lock. The lock is released by
+// closing the file.
+func openLocked(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// writeLockOwner replaces the contents of the lock file f with owner.
+func writeLockOwner(f *os.File, owner string) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if owner == "" {
+		return nil
+	}
+	_, err := f.WriteAt([]byte(owner+"\n"), 0)
+	return err
+}
+
+// lockOwner describes the process recorded in a lock file, as its pid and
+// host.
+func lockOwner(data []byte) string {
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return "another gunk process"
+	}
+	return fmt.Sprintf("gunk process %s on %s", fields[0], fields[1])
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package generate
+
+import "os"
+
+// lockFile does nothing, as files can't be locked on this platform.
+// Concurrent gunk processes may then interleave their outputs.
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,62 @@
+package generate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gunk/gunk/log"
+)
+
+func TestLockPkg(t *testing.T) {
+	defer os.Setenv("GUNK_CACHE_DIR", os.Getenv("GUNK_CACHE_DIR"))
+	os.Setenv("GUNK_CACHE_DIR", t.TempDir())
+	var out bytes.Buffer
+	defer func(w io.Writer, wait time.Duration) { log.Out, lockWait = w, wait }(log.Out, lockWait)
+	log.Out, lockWait = &out, 10*time.Millisecond
+
+	unlock, err := lockPkg(context.Background(), "testdata.tld/util", "/src/util")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another package isn't locked.
+	unlockOther, err := lockPkg(context.Background(), "testdata.tld/other", "/src/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockOther()
+
+	// The same package is, until unlocked.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := lockPkg(ctx, "testdata.tld/util", "/src/util"); err != context.DeadlineExceeded {
+		t.Fatalf("want the lock to wait until the deadline, got %v", err)
+	}
+	want := fmt.Sprintf("waiting for gunk process %d on ", os.Getpid())
+	if !strings.Contains(out.String(), want) {
+		t.Fatalf("want %q in the output, got %q", want, out.String())
+	}
+	done := make(chan error)
+	go func() {
+		unlock, err := lockPkg(context.Background(), "testdata.tld/util", "/src/util")
+		if err == nil {
+			unlock()
+		}
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock was not released")
+	}
+}
@@ -0,0 +1,331 @@
+package generate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/gunk/gunk/loader"
+)
+
+// The scopes of the +gunk option tags, being the declarations they can be
+// attached to.
+const (
+	ScopeFile      = "file"
+	ScopeMessage   = "message"
+	ScopeField     = "field"
+	ScopeService   = "service"
+	ScopeMethod    = "method"
+	ScopeEnum      = "enum"
+	ScopeEnumValue = "enumvalue"
+)
+
+// supportedOptions lists the option tags accepted by fileOptions,
+// messageOptions, fieldOptions, serviceOptions, methodOptions, enumOptions
+// and enumValueOptions, in the order of their cases, along with the proto
+// option each one sets. It must be updated along with them.
+var supportedOptions = []struct {
+	scope, tag, option string
+}{
+	{ScopeFile, "github.com/gunk/opt/file.OptimizeFor", "optimize_for"},
+	{ScopeFile, "github.com/gunk/opt/file.Deprecated", "deprecated"},
+	{ScopeFile, "github.com/gunk/opt/file/java.Package", "java_package"},
+	{ScopeFile, "github.com/gunk/opt/file/java.OuterClassname", "java_outer_classname"},
+	{ScopeFile, "github.com/gunk/opt/file/java.MultipleFiles", "java_multiple_files"},
+	{ScopeFile, "github.com/gunk/opt/file/java.StringCheckUtf8", "java_string_check_utf8"},
+	{ScopeFile, "github.com/gunk/opt/file/java.GenericServices", "java_generic_services"},
+	{ScopeFile, "github.com/gunk/opt/file/swift.Prefix", "swift_prefix"},
+	// Accepted, but not set in the generated proto file yet.
+	{ScopeFile, "github.com/gunk/opt/file/ruby.Package", ""},
+	{ScopeFile, "github.com/gunk/opt/file/csharp.Namespace", "csharp_namespace"},
+	{ScopeFile, "github.com/gunk/opt/file/objc.ClassPrefix", "objc_class_prefix"},
+	{ScopeFile, "github.com/gunk/opt/file/php.Namespace", "php_namespace"},
+	{ScopeFile, "github.com/gunk/opt/file/php.ClassPrefix", "php_class_prefix"},
+	// Accepted, but not set in the generated proto file yet.
+	{ScopeFile, "github.com/gunk/opt/file/php.MetadataNamespace", ""},
+	{ScopeFile, "github.com/gunk/opt/file/php.GenericServices", "php_generic_services"},
+	{ScopeFile, "github.com/gunk/opt/openapiv2.Swagger", "grpc.gateway.protoc_gen_openapiv2.options.openapiv2_swagger"},
+
+	{ScopeMessage, "github.com/gunk/opt/message.MessageSetWireFormat", "message_set_wire_format"},
+	{ScopeMessage, "github.com/gunk/opt/message.NoStandardDescriptorAccessor", "no_standard_descriptor_accessor"},
+	{ScopeMessage, "github.com/gunk/opt/message.Deprecated", "deprecated"},
+	{ScopeMessage, "github.com/gunk/opt/openapiv2.Schema", "grpc.gateway.protoc_gen_openapiv2.options.openapiv2_schema"},
+
+	{ScopeField, "github.com/gunk/opt/field.Packed", "packed"},
+	{ScopeField, "github.com/gunk/opt/field.Lazy", "lazy"},
+	{ScopeField, "github.com/gunk/opt/field.Deprecated", "deprecated"},
+	{ScopeField, "github.com/gunk/opt/field/cc.Type", "ctype"},
+	{ScopeField, "github.com/gunk/opt/field/js.Type", "jstype"},
+	// Only the JSONSchema field is used.
+	{ScopeField, "github.com/gunk/opt/openapiv2.Schema", "grpc.gateway.protoc_gen_openapiv2.options.openapiv2_field"},
+
+	{ScopeService, "github.com/gunk/opt/service.Deprecated", "deprecated"},
+
+	{ScopeMethod, "github.com/gunk/opt/method.Deprecated", "deprecated"},
+	{ScopeMethod, "github.com/gunk/opt/method.IdempotencyLevel", "idempotency_level"},
+	{ScopeMethod, "github.com/gunk/opt/http.Match", "google.api.http"},
+	{ScopeMethod, "github.com/gunk/opt/openapiv2.Operation", "grpc.gateway.protoc_gen_openapiv2.options.openapiv2_operation"},
+
+	{ScopeEnum, "github.com/gunk/opt/enum.AllowAlias", "allow_alias"},
+	{ScopeEnum, "github.com/gunk/opt/enum.Deprecated", "deprecated"},
+
+	{ScopeEnumValue, "github.com/gunk/opt/enumvalues.Deprecated", "deprecated"},
+}
+
+// OptionCatalog describes the +gunk option tags which Gunk supports, for
+// editors, language servers and documentation.
+type OptionCatalog struct {
+	Options []OptionTag `json:"options"`
+	// Types holds the types of the Gunk option packages which are used by
+	// the fields of the option tags, keyed by their qualified name.
+	Types map[string]*OptionType `json:"types,omitempty"`
+}
+
+// OptionTag is a supported option tag, such as
+// "github.com/gunk/opt/http.Match".
+type OptionTag struct {
+	Tag   string `json:"tag"`
+	Scope string `json:"scope"`
+	// Option is the proto option set by the tag, such as "deprecated" or
+	// "google.api.http". It is empty if the tag sets no option.
+	Option string `json:"option,omitempty"`
+	// OptionType is nil if the tag's type isn't declared by the version
+	// of the Gunk option packages in use.
+	*OptionType
+}
+
+// OptionType describes the Go type of an option tag, or of one of its
+// fields.
+type OptionType struct {
+	Doc string `json:"doc,omitempty"`
+	// Kind is the kind of the type: "enum", "struct", or the underlying
+	// basic type, such as "bool" or "string".
+	Kind string `json:"kind"`
+	// Values are the names of the constants of an enum, in order.
+	Values []string `json:"values,omitempty"`
+	// Fields are the fields of a struct.
+	Fields []OptionField `json:"fields,omitempty"`
+}
+
+// OptionField is a field of an option tag of kind "struct".
+type OptionField struct {
+	Name string `json:"name"`
+	// Type is the type of the field, qualified by the import path if it
+	// is declared in a Gunk option package, as in "[]string" or
+	// "map[string]github.com/gunk/opt/openapiv2.Response".
+	Type string `json:"type"`
+	Doc  string `json:"doc,omitempty"`
+}
+
+// WriteOptionCatalog writes the catalog of the supported option tags as
+// JSON, with their types loaded from the Gunk option packages required by
+// the module in dir.
+func WriteOptionCatalog(ctx context.Context, w io.Writer, dir string) error {
+	catalog, err := LoadOptionCatalog(ctx, dir)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(catalog)
+}
+
+// LoadOptionCatalog returns the catalog of the option tags supported by
+// Gunk, with their types loaded from the Gunk option packages required by
+// the module in dir.
+func LoadOptionCatalog(ctx context.Context, dir string) (*OptionCatalog, error) {
+	c := &optionCataloger{
+		l:     &loader.Loader{Dir: dir, Fset: token.NewFileSet(), Types: true},
+		pkgs:  make(map[string]*optionPackage),
+		errs:  make(map[string]error),
+		types: make(map[string]*OptionType),
+	}
+	catalog := &OptionCatalog{Types: c.types}
+	var loadErr error
+	loaded := false
+	for _, opt := range supportedOptions {
+		i := strings.LastIndex(opt.tag, ".")
+		tag := OptionTag{Tag: opt.tag, Scope: opt.scope, Option: opt.option}
+		pkg, err := c.pkg(ctx, opt.tag[:i])
+		switch {
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case err != nil:
+			// Packages missing from the version of the Gunk option
+			// packages in use are listed without their types.
+			loadErr = err
+		default:
+			loaded = true
+			if obj, ok := pkg.types.Scope().Lookup(opt.tag[i+1:]).(*types.TypeName); ok {
+				tag.OptionType = c.describe(pkg, obj)
+			}
+		}
+		catalog.Options = append(catalog.Options, tag)
+	}
+	if !loaded {
+		return nil, loadErr
+	}
+	return catalog, nil
+}
+
+type optionCataloger struct {
+	l     *loader.Loader
+	pkgs  map[string]*optionPackage
+	errs  map[string]error // packages which failed to load
+	types map[string]*OptionType
+}
+
+// optionPackage is a loaded Gunk option package, with the docs of its
+// declarations.
+type optionPackage struct {
+	types *types.Package
+	docs  map[string]string        // by type name
+	specs map[string]*ast.TypeSpec // by type name
+}
+
+func (c *optionCataloger) pkg(ctx context.Context, path string) (*optionPackage, error) {
+	if pkg, ok := c.pkgs[path]; ok {
+		return pkg, nil
+	}
+	if err := c.errs[path]; err != nil {
+		return nil, err
+	}
+	gpkg, err := c.l.Package(ctx, path)
+	if err == nil && len(gpkg.Errors) > 0 {
+		err = fmt.Errorf("unable to load option package %s: %v", path, gpkg.Errors[0])
+	}
+	if err != nil {
+		c.errs[path] = err
+		return nil, err
+	}
+	pkg := &optionPackage{
+		types: gpkg.Types,
+		docs:  make(map[string]string),
+		specs: make(map[string]*ast.TypeSpec),
+	}
+	for _, f := range gpkg.GunkSyntax {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				doc := ts.Doc
+				if doc == nil && len(gd.Specs) == 1 {
+					doc = gd.Doc
+				}
+				pkg.docs[ts.Name.Name] = strings.TrimSpace(doc.Text())
+				pkg.specs[ts.Name.Name] = ts
+			}
+		}
+	}
+	c.pkgs[path] = pkg
+	return pkg, nil
+}
+
+// describe returns the description of the option type obj, declared in
+// pkg. The types of its fields declared in Gunk option packages are added
+// to the catalog's types.
+func (c *optionCataloger) describe(pkg *optionPackage, obj *types.TypeName) *OptionType {
+	typ := &OptionType{Doc: pkg.docs[obj.Name()]}
+	switch u := obj.Type().Underlying().(type) {
+	case *types.Struct:
+		typ.Kind = "struct"
+		var fieldDocs map[string]string
+		if st, ok := pkg.specs[obj.Name()].Type.(*ast.StructType); ok {
+			fieldDocs = structFieldDocs(st)
+		}
+		for i := 0; i < u.NumFields(); i++ {
+			f := u.Field(i)
+			if !f.Exported() {
+				continue
+			}
+			typ.Fields = append(typ.Fields, OptionField{
+				Name: f.Name(),
+				Type: types.TypeString(f.Type(), nil),
+				Doc:  fieldDocs[f.Name()],
+			})
+			c.addTypes(f.Type())
+		}
+	case *types.Basic:
+		typ.Kind = u.Name()
+		if u.Info()&types.IsInteger != 0 {
+			if values := enumValues(pkg.types, obj.Type()); len(values) > 0 {
+				typ.Kind, typ.Values = "enum", values
+			}
+		}
+	default:
+		typ.Kind = types.TypeString(u, nil)
+	}
+	return typ
+}
+
+// addTypes adds the named types used by t which are declared in Gunk option
+// packages to the catalog's types.
+func (c *optionCataloger) addTypes(t types.Type) {
+	switch t := t.(type) {
+	case *types.Pointer:
+		c.addTypes(t.Elem())
+	case *types.Slice:
+		c.addTypes(t.Elem())
+	case *types.Map:
+		c.addTypes(t.Key())
+		c.addTypes(t.Elem())
+	case *types.Named:
+		obj := t.Obj()
+		name := types.TypeString(t, nil)
+		if obj.Pkg() == nil || c.types[name] != nil {
+			return
+		}
+		pkg, ok := c.pkgs[obj.Pkg().Path()]
+		if !ok {
+			// Not an option package.
+			return
+		}
+		// Reserve the entry first, as types may be recursive.
+		c.types[name] = &OptionType{}
+		*c.types[name] = *c.describe(pkg, obj)
+	}
+}
+
+// structFieldDocs returns the docs of the fields of a struct, by name.
+func structFieldDocs(st *ast.StructType) map[string]string {
+	docs := make(map[string]string)
+	for _, field := range st.Fields.List {
+		doc := field.Doc
+		if doc == nil {
+			doc = field.Comment
+		}
+		for _, name := range field.Names {
+			docs[name.Name] = strings.TrimSpace(doc.Text())
+		}
+	}
+	return docs
+}
+
+// enumValues returns the names of the constants of type t declared in pkg,
+// ordered by value.
+func enumValues(pkg *types.Package, t types.Type) []string {
+	var consts []*types.Const
+	for _, name := range pkg.Scope().Names() {
+		if c, ok := pkg.Scope().Lookup(name).(*types.Const); ok && types.Identical(c.Type(), t) {
+			consts = append(consts, c)
+		}
+	}
+	sort.SliceStable(consts, func(i, j int) bool {
+		return constant.Compare(consts[i].Val(), token.LSS, consts[j].Val())
+	})
+	values := make([]string, len(consts))
+	for i, c := range consts {
+		values[i] = c.Name()
+	}
+	return values
+}
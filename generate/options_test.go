@@ -0,0 +1,61 @@
+package generate
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestSupportedOptions checks that supportedOptions lists the option tags
+// handled by the cases of the option functions, so that the catalog can't
+// get out of sync with them.
+func TestSupportedOptions(t *testing.T) {
+	scopes := map[string]string{
+		"fileOptions":      ScopeFile,
+		"messageOptions":   ScopeMessage,
+		"fieldOptions":     ScopeField,
+		"serviceOptions":   ScopeService,
+		"methodOptions":    ScopeMethod,
+		"enumOptions":      ScopeEnum,
+		"enumValueOptions": ScopeEnumValue,
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "generate.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || scopes[fn.Name.Name] == "" {
+			continue
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			cc, ok := n.(*ast.CaseClause)
+			if !ok {
+				return true
+			}
+			for _, expr := range cc.List {
+				lit, ok := expr.(*ast.BasicLit)
+				if !ok || lit.Kind != token.STRING {
+					continue
+				}
+				s, _ := strconv.Unquote(lit.Value)
+				if strings.HasPrefix(s, "github.com/gunk/opt/") {
+					got = append(got, scopes[fn.Name.Name]+" "+s)
+				}
+			}
+			return true
+		})
+	}
+	var want []string
+	for _, opt := range supportedOptions {
+		want = append(want, opt.scope+" "+opt.tag)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("supportedOptions is out of sync with the option functions:\ngot  %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,117 @@
+package generate
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gunk/gunk/loader"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// outputMap maps the names of the files written by a plugin generator, which
+// are slash-separated paths, to the directories on disk to write them to.
+//
+// Plugins name the files they write after what the proto files declare,
+// usually the proto file's own path (python, ts, openapiv2, or go with
+// paths=source_relative), its go_package import path (go and grpc-go), or its
+// java_package as a path (java). The name of a file is looked up in order:
+//
+//  1. in the path_map of the generator, whose directories are relative to
+//     the output directory of the package being generated;
+//  2. in the go_package import paths and the proto file paths of the Gunk
+//     packages of the request, whose files are written to the output
+//     directory of their package, or of their separate Go module if they're
+//     generated with go_module_path;
+//  3. otherwise, the name is relative to the output directory of the package
+//     being generated, as with protoc, which is where the java_package
+//     directories of java files end up.
+//
+// In the first two steps, the name's directory must be a prefix, or be below
+// one, in which case its remaining directories are kept. The longest prefix
+// matching wins, so that nested packages get their own files.
+type outputMap struct {
+	overrides []outputRule
+	pkgs      []outputRule
+	// dir is the output directory of the package being generated.
+	dir string
+}
+
+// outputRule is a rule of an outputMap, writing the files below prefix to
+// dir.
+type outputRule struct {
+	prefix string
+	dir    string
+}
+
+// dirOf returns the directory to write the file name written by the plugin
+// to.
+func (m *outputMap) dirOf(name string) string {
+	nameDir := path.Dir(name)
+	for _, rules := range [][]outputRule{m.overrides, m.pkgs} {
+		if rule, ok := matchRule(rules, nameDir); ok {
+			rest := strings.TrimPrefix(strings.TrimPrefix(nameDir, rule.prefix), "/")
+			return filepath.Join(rule.dir, filepath.FromSlash(rest))
+		}
+	}
+	return filepath.Join(m.dir, filepath.FromSlash(nameDir))
+}
+
+// matchRule returns the rule with the longest prefix which is dir, or
+// contains it.
+func matchRule(rules []outputRule, dir string) (outputRule, bool) {
+	var match outputRule
+	found := false
+	for _, rule := range rules {
+		if dir != rule.prefix && !strings.HasPrefix(dir, rule.prefix+"/") {
+			continue
+		}
+		if !found || len(rule.prefix) > len(match.prefix) {
+			match, found = rule, true
+		}
+	}
+	return match, found
+}
+
+// outputMap returns the outputMap of the plugin generator gen, run with req
+// to generate mainPkg.
+func (g *Generator) outputMap(req *pluginpb.CodeGeneratorRequest, gen configWithBinary, mainPkg *loader.GunkPackage) *outputMap {
+	m := &outputMap{dir: gen.OutPath(mainPkg.Dir)}
+	for _, kv := range gen.PathMap {
+		m.overrides = append(m.overrides, outputRule{kv.Key, filepath.Join(m.dir, kv.Value)})
+	}
+	// The proto files are sorted, so the rules are too; no two
+	// packages declare the same path.
+	for _, pfile := range req.GetProtoFile() {
+		gpkg, ok := g.gunkPkgs[path.Dir(pfile.GetName())]
+		if !ok || gpkg.ProtoFile != "" || pfile.GetName() != unifiedProtoFile(gpkg.PkgPath) {
+			// Not generated from a Gunk package, so without a
+			// directory to write to.
+			continue
+		}
+		dir := gen.OutPath(gpkg.Dir)
+		m.pkgs = append(m.pkgs, outputRule{gpkg.PkgPath, dir})
+		goPkgPath := goImportPath(pfile)
+		if goPkgPath == "" || goPkgPath == gpkg.PkgPath {
+			continue
+		}
+		if oot, ok := g.outOfTree[goPkgPath]; ok {
+			// Generated into the separate Go module set with
+			// go_module_path, keeping the package layout.
+			dir = filepath.Join(gen.OutPath(oot.moduleDir), oot.rel)
+		}
+		m.pkgs = append(m.pkgs, outputRule{goPkgPath, dir})
+	}
+	return m
+}
+
+// goImportPath returns the import path of the go_package option of pfile,
+// without the package name following it.
+func goImportPath(pfile *descriptorpb.FileDescriptorProto) string {
+	goPkg := pfile.GetOptions().GetGoPackage()
+	if i := strings.IndexByte(goPkg, ';'); i >= 0 {
+		goPkg = goPkg[:i]
+	}
+	return goPkg
+}
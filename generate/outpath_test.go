@@ -0,0 +1,207 @@
+package generate
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gunk/gunk/config"
+	"github.com/gunk/gunk/loader"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func TestOutputMap(t *testing.T) {
+	root := filepath.FromSlash("/src/api")
+	pkgs := []*loader.GunkPackage{
+		{Dir: root},
+		{Dir: filepath.Join(root, "util")},
+		{Dir: filepath.Join(root, "util", "sub")},
+		{Dir: filepath.Join(root, "other")},
+		{Dir: filepath.Join(root, "cmd")},
+		{ProtoFile: "google/type/money.proto"},
+	}
+	pkgs[0].PkgPath = "example.com/api"
+	pkgs[1].PkgPath = "example.com/api/util"
+	pkgs[2].PkgPath = "example.com/api/util/sub"
+	pkgs[3].PkgPath = "example.com/api/other"
+	pkgs[4].PkgPath = "command-line-arguments"
+	pkgs[5].PkgPath = "google/type"
+	goPackages := map[string]string{
+		"example.com/api":          "example.com/api;api",
+		"example.com/api/util":     "example.com/api/util;util",
+		"example.com/api/util/sub": "example.com/api/util/sub;sub",
+		// Generated into a separate Go module with go_module_path.
+		"example.com/api/other":  "example.com/gen/other;other",
+		"command-line-arguments": "fake-path.com/command-line-arguments;cmd",
+		"google/type":            "google.golang.org/genproto/googleapis/type/money;money",
+	}
+	g := &Generator{
+		gunkPkgs: make(map[string]*loader.GunkPackage),
+		outOfTree: map[string]outOfTreePkg{
+			"example.com/gen/other": {moduleDir: filepath.FromSlash("/src/gen"), rel: "other"},
+		},
+	}
+	req := &pluginpb.CodeGeneratorRequest{}
+	for _, pkg := range pkgs {
+		g.gunkPkgs[pkg.PkgPath] = pkg
+		name := unifiedProtoFile(pkg.PkgPath)
+		if pkg.ProtoFile != "" {
+			name = pkg.ProtoFile
+		}
+		req.ProtoFile = append(req.ProtoFile, &descriptorpb.FileDescriptorProto{
+			Name: proto.String(name),
+			Options: &descriptorpb.FileOptions{
+				GoPackage:   proto.String(goPackages[pkg.PkgPath]),
+				JavaPackage: proto.String("com.example.api"),
+			},
+		})
+	}
+
+	tests := []struct {
+		desc string
+		gen  config.Generator
+		pkg  string
+		name string
+		want string
+	}{
+		{
+			desc: "go",
+			gen:  config.Generator{Command: "protoc-gen-go"},
+			pkg:  "example.com/api/util",
+			name: "example.com/api/util/all.pb.go",
+			want: "/src/api/util",
+		},
+		{
+			desc: "go source_relative",
+			gen:  config.Generator{Command: "protoc-gen-go", Params: []config.KeyValue{{Key: "paths", Value: "source_relative"}}},
+			pkg:  "example.com/api/util",
+			name: "example.com/api/util/all.pb.go",
+			want: "/src/api/util",
+		},
+		{
+			desc: "go nested package",
+			gen:  config.Generator{Command: "protoc-gen-go"},
+			pkg:  "example.com/api/util/sub",
+			name: "example.com/api/util/sub/all.pb.go",
+			want: "/src/api/util/sub",
+		},
+		{
+			desc: "go subdirectory of a package",
+			gen:  config.Generator{Command: "protoc-gen-go"},
+			pkg:  "example.com/api/util",
+			name: "example.com/api/util/internal/all.pb.go",
+			want: "/src/api/util/internal",
+		},
+		{
+			desc: "go_module_path",
+			gen:  config.Generator{Command: "protoc-gen-go"},
+			pkg:  "example.com/api/other",
+			name: "example.com/gen/other/all.pb.go",
+			want: "/src/gen/other",
+		},
+		{
+			desc: "go_module_path with out",
+			gen:  config.Generator{Command: "protoc-gen-go", Out: "v1", ConfigDir: filepath.FromSlash("/src")},
+			pkg:  "example.com/api/other",
+			name: "example.com/gen/other/all.pb.go",
+			want: "/src/v1/other",
+		},
+		{
+			desc: "go source_relative with go_module_path",
+			gen:  config.Generator{Command: "protoc-gen-go"},
+			pkg:  "example.com/api/other",
+			name: "example.com/api/other/all.pb.go",
+			want: "/src/api/other",
+		},
+		{
+			desc: "go files listed on the command line",
+			gen:  config.Generator{Command: "protoc-gen-go"},
+			pkg:  "command-line-arguments",
+			name: "fake-path.com/command-line-arguments/all.pb.go",
+			want: "/src/api/cmd",
+		},
+		{
+			desc: "go package which prefixes another's name",
+			gen:  config.Generator{Command: "protoc-gen-go"},
+			pkg:  "example.com/api",
+			name: "example.com/api2/all.pb.go",
+			want: "/src/api/example.com/api2",
+		},
+		{
+			desc: "proto files aren't generated",
+			gen:  config.Generator{Command: "protoc-gen-go"},
+			pkg:  "example.com/api/util",
+			name: "google.golang.org/genproto/googleapis/type/money/money.pb.go",
+			want: "/src/api/util/google.golang.org/genproto/googleapis/type/money",
+		},
+		{
+			desc: "java",
+			gen:  config.Generator{Command: "protoc-gen-java"},
+			pkg:  "example.com/api/util",
+			name: "com/example/api/All.java",
+			want: "/src/api/util/com/example/api",
+		},
+		{
+			desc: "java with out",
+			gen:  config.Generator{Command: "protoc-gen-java", Out: "java", ConfigDir: root},
+			pkg:  "example.com/api/util",
+			name: "com/example/api/All.java",
+			want: "/src/api/java/com/example/api",
+		},
+		{
+			desc: "python",
+			gen:  config.Generator{Command: "protoc-gen-python"},
+			pkg:  "example.com/api/util",
+			name: "example.com/api/util/all_pb2.py",
+			want: "/src/api/util",
+		},
+		{
+			desc: "ts",
+			gen:  config.Generator{Command: "protoc-gen-ts"},
+			pkg:  "example.com/api/util/sub",
+			name: "example.com/api/util/sub/all_pb.d.ts",
+			want: "/src/api/util/sub",
+		},
+		{
+			desc: "ts with out",
+			gen:  config.Generator{Command: "protoc-gen-ts", Out: "ts", ConfigDir: root},
+			pkg:  "example.com/api/util",
+			name: "example.com/api/util/all_pb.d.ts",
+			want: "/src/api/ts",
+		},
+		{
+			desc: "relative name",
+			gen:  config.Generator{Command: "protoc-gen-openapiv2", Params: []config.KeyValue{{Key: "allow_merge", Value: "true"}}},
+			pkg:  "example.com/api/util",
+			name: "apidocs.swagger.json",
+			want: "/src/api/util",
+		},
+		{
+			desc: "path_map",
+			gen:  config.Generator{Command: "protoc-gen-java", PathMap: []config.KeyValue{{Key: "com/example", Value: "src/main/java"}}},
+			pkg:  "example.com/api/util",
+			name: "com/example/api/All.java",
+			want: "/src/api/util/src/main/java/api",
+		},
+		{
+			desc: "path_map over go_package",
+			gen: config.Generator{Command: "protoc-gen-go", PathMap: []config.KeyValue{
+				{Key: "example.com/api", Value: "gen"},
+				{Key: "example.com/api/util", Value: "."},
+			}},
+			pkg:  "example.com/api/util",
+			name: "example.com/api/util/sub/all.pb.go",
+			want: "/src/api/util/sub",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			gen := configWithBinary{Generator: test.gen}
+			m := g.outputMap(req, gen, g.gunkPkgs[test.pkg])
+			if got, want := m.dirOf(test.name), filepath.FromSlash(test.want); got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
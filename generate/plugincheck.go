@@ -0,0 +1,144 @@
+package generate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gunk/gunk/log"
+	"golang.org/x/mod/semver"
+)
+
+// Values for Options.CheckPlugins.
+const (
+	CheckPluginsWarn = "warn"
+	CheckPluginsFail = "fail"
+)
+
+var versionRx = regexp.MustCompile(`v?\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?`)
+
+// checkPlugin runs a plugin with --version, and compares the version it
+// reports with its plugin_version and version_range. Mismatches are printed
+// as warnings, or returned as an error when checking in fail mode.
+//
+// Each plugin is only checked once per run.
+func (g *Generator) checkPlugin(ctx context.Context, gen configWithBinary) error {
+	command := gen.actualCommand()
+	key := command + " " + gen.PluginVersion + " " + gen.VersionRange
+	if g.checkedPlugins[key] {
+		return nil
+	}
+	g.checkedPlugins[key] = true
+	constraints, err := parseVersionRange(gen.VersionRange)
+	if err != nil {
+		return fmt.Errorf("[generate %s]: %w", gen.Code(), err)
+	}
+	problem := pluginProblem(ctx, command, gen.PluginVersion, constraints)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if problem == "" {
+		return nil
+	}
+	if g.opts.CheckPlugins == CheckPluginsFail {
+		return fmt.Errorf("[generate %s]: %s", gen.Code(), problem)
+	}
+	g.warnf("warning: [generate %s]: %s", gen.Code(), problem)
+	return nil
+}
+
+// pluginProblem returns a description of why the plugin doesn't pass the
+// check, or an empty string if it does.
+func pluginProblem(ctx context.Context, command, pin string, constraints []versionConstraint) string {
+	version, err := runVersion(ctx, command)
+	if err != nil {
+		return fmt.Sprintf("cannot run %s: %v", command, err)
+	}
+	if version == "" {
+		if pin == "" && len(constraints) == 0 {
+			// Nothing to compare against.
+			log.Verbosef("unable to determine version of %s", command)
+			return ""
+		}
+		return fmt.Sprintf("unable to determine version of %s from --version", command)
+	}
+	if semver.IsValid(pin) && semver.Compare(version, pin) != 0 {
+		return fmt.Sprintf("%s is version %s, but plugin_version is %s", command, version, pin)
+	}
+	for _, c := range constraints {
+		if !c.matches(version) {
+			return fmt.Sprintf("%s is version %s, which is not %s", command, version, c)
+		}
+	}
+	return ""
+}
+
+// runVersion runs command with --version, returning the version it reports
+// with a "v" prefix, or an empty string if it reports none.
+func runVersion(ctx context.Context, command string) (string, error) {
+	// Stdin is empty, so plugins which don't know about --version will
+	// fail to read a request and exit right away.
+	out, err := log.ExecCommandContext(ctx, command, "--version").CombinedOutput()
+	if err != nil && len(out) == 0 {
+		return "", err
+	}
+	version := versionRx.FindString(string(out))
+	if version != "" && !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	return version, nil
+}
+
+// versionConstraint is a single comparison of a version_range, such as
+// ">=v1.20.0".
+type versionConstraint struct {
+	op      string
+	version string
+}
+
+func (c versionConstraint) String() string {
+	return c.op + c.version
+}
+
+func (c versionConstraint) matches(version string) bool {
+	cmp := semver.Compare(version, c.version)
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	}
+	return cmp == 0
+}
+
+// parseVersionRange parses a version_range, which is a space separated list
+// of comparisons that must all hold, like ">=v1.20.0 <v2.0.0".
+func parseVersionRange(s string) ([]versionConstraint, error) {
+	var constraints []versionConstraint
+	for _, f := range strings.Fields(s) {
+		var c versionConstraint
+		for _, op := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(f, op) {
+				c.op = op
+				break
+			}
+		}
+		c.version = strings.TrimPrefix(f, c.op)
+		if c.op == "" {
+			c.op = "="
+		}
+		if !strings.HasPrefix(c.version, "v") {
+			c.version = "v" + c.version
+		}
+		if !semver.IsValid(c.version) {
+			return nil, fmt.Errorf("invalid version %q in version_range", f)
+		}
+		constraints = append(constraints, c)
+	}
+	return constraints, nil
+}
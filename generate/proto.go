@@ -3,10 +3,13 @@ package generate
 import (
 	"fmt"
 	"go/constant"
+	"go/token"
 	"reflect"
 	"strconv"
 
+	"github.com/gunk/gunk/loader"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
 )
 
 const (
@@ -15,6 +18,7 @@ const (
 	enumPath          = 5 // FileDescriptorProto.EnumType
 	servicePath       = 6 // FileDescriptorProto.Service
 	messageFieldPath  = 2 // DescriptorProto.Field
+	messageOneofPath  = 8 // DescriptorProto.OneofDecl
 	enumValuePath     = 2 // EnumDescriptorProto.Value
 	serviceMethodPath = 2 // ServiceDescriptorProto.Method
 )
@@ -24,6 +28,7 @@ func protoNumber(tag reflect.StructTag) (*int32, error) {
 	if pbTag == "" {
 		return nil, fmt.Errorf("pb tag must be set")
 	}
+	pbTag, _ = loader.SplitPBTag(pbTag)
 	number, err := strconv.Atoi(pbTag)
 	if err != nil {
 		return nil, err
@@ -31,6 +36,35 @@ func protoNumber(tag reflect.StructTag) (*int32, error) {
 	return proto.Int32(int32(number)), nil
 }
 
+// Field numbers 19000 to 19999 are reserved by the protobuf implementation,
+// and numbers can't be larger than 2^29-1.
+const (
+	firstReservedNumber = 19000
+	lastReservedNumber  = 19999
+	maxFieldNumber      = 1<<29 - 1
+)
+
+// checkFieldNumber returns an error if the number of field can't be used in
+// msg: it must be valid, not reserved, and not used by another field of msg,
+// including the members of its oneofs. The fields of map entries, which are
+// nested types of msg, are numbered separately. The error is positioned at
+// pos, the tag of the struct field declaring field.
+func (g *Generator) checkFieldNumber(msg *descriptorpb.DescriptorProto, field *descriptorpb.FieldDescriptorProto, pos token.Pos) error {
+	num := field.GetNumber()
+	switch {
+	case num < 1 || num > maxFieldNumber:
+		return g.errorAt(pos, "field number %d of %s must be between 1 and %d", num, field.GetName(), maxFieldNumber)
+	case num >= firstReservedNumber && num <= lastReservedNumber:
+		return g.errorAt(pos, "field number %d of %s is reserved by protobuf, between %d and %d", num, field.GetName(), firstReservedNumber, lastReservedNumber)
+	}
+	for _, f := range msg.Field {
+		if f.GetNumber() == num {
+			return g.errorAt(pos, "field number %d of %s is already used by %s in %s", num, field.GetName(), f.GetName(), msg.GetName())
+		}
+	}
+	return nil
+}
+
 func jsonName(tag reflect.StructTag) *string {
 	jsonTag := tag.Get("json")
 	if jsonTag == "" {
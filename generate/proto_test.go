@@ -0,0 +1,58 @@
+package generate
+
+import (
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/gunk/gunk/loader"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestCheckFieldNumber(t *testing.T) {
+	msg := &descriptorpb.DescriptorProto{
+		Name: proto.String("Message"),
+		Field: []*descriptorpb.FieldDescriptorProto{{
+			Name:   proto.String("Name"),
+			Number: proto.Int32(1),
+		}},
+		NestedType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("LabelsEntry"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("key"), Number: proto.Int32(1)},
+				{Name: proto.String("value"), Number: proto.Int32(2)},
+			},
+		}},
+	}
+	tests := []struct {
+		number int32
+		err    string
+	}{
+		{2, ""},
+		{4, ""},
+		{1, "field number 1 of Field is already used by Name in Message"},
+		{0, "must be between 1 and 536870911"},
+		{1 << 29, "must be between 1 and 536870911"},
+		{19000, "is reserved by protobuf"},
+		{19999, "is reserved by protobuf"},
+		{20000, ""},
+	}
+	g := &Generator{Loader: loader.Loader{Fset: token.NewFileSet()}}
+	file := g.Fset.AddFile("echo.gunk", -1, 100)
+	for _, tc := range tests {
+		field := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String("Field"),
+			Number: proto.Int32(tc.number),
+		}
+		err := g.checkFieldNumber(msg, field, file.Pos(20))
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("unexpected error for %d: %v", tc.number, err)
+		case tc.err != "" && err == nil:
+			t.Errorf("expected an error for %d", tc.number)
+		case tc.err != "" && (!strings.Contains(err.Error(), tc.err) || !strings.HasPrefix(err.Error(), "echo.gunk:1:21: ")):
+			t.Errorf("wrong error for %d, got %q expected %q", tc.number, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package generate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gunk/gunk/config"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// remoteProcedure is the Connect procedure of the Buf Schema Registry which
+// runs remote plugins.
+const remoteProcedure = "/buf.alpha.registry.v1alpha1.CodeGenerationService/GenerateCode"
+
+// remoteRequest is the JSON form of a GenerateCodeRequest, holding the proto
+// files as an image, with the files which aren't generated marked as imports.
+type remoteRequest struct {
+	Image    remoteImage           `json:"image"`
+	Requests []remotePluginRequest `json:"requests"`
+}
+
+type remoteImage struct {
+	File []json.RawMessage `json:"file"`
+}
+
+type remotePluginRequest struct {
+	PluginReference remotePluginReference `json:"pluginReference"`
+	Parameter       *string               `json:"parameter,omitempty"`
+}
+
+type remotePluginReference struct {
+	Owner    string `json:"owner"`
+	Name     string `json:"name"`
+	Version  string `json:"version,omitempty"`
+	Revision uint32 `json:"revision,omitempty"`
+}
+
+type remoteResponse struct {
+	Responses []struct {
+		Response json.RawMessage `json:"response"`
+	} `json:"responses"`
+}
+
+// remoteError is the body of a failed Connect call.
+type remoteError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// runRemotePlugin runs the remote plugin ref on the Buf Schema Registry
+// hosting it, authenticating with the BUF_TOKEN environment variable if set,
+// as with the buf CLI.
+func runRemotePlugin(ctx context.Context, ref string, req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	p, err := config.ParseRemotePlugin(ref)
+	if err != nil {
+		return nil, err
+	}
+	return generateRemote(ctx, http.DefaultClient, "https://api."+p.Remote, os.Getenv("BUF_TOKEN"), p, req)
+}
+
+// generateRemote calls the code generation API of registry, running the
+// remote plugin p on req.
+func generateRemote(ctx context.Context, client *http.Client, registry, token string, p config.RemotePlugin, req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	generated := make(map[string]bool)
+	for _, name := range req.GetFileToGenerate() {
+		generated[name] = true
+	}
+	var rreq remoteRequest
+	for _, f := range req.GetProtoFile() {
+		data, err := protojson.Marshal(f)
+		if err != nil {
+			return nil, err
+		}
+		if !generated[f.GetName()] {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(data, &fields); err != nil {
+				return nil, err
+			}
+			fields["bufExtension"] = json.RawMessage(`{"isImport":true}`)
+			if data, err = json.Marshal(fields); err != nil {
+				return nil, err
+			}
+		}
+		rreq.Image.File = append(rreq.Image.File, data)
+	}
+	rreq.Requests = []remotePluginRequest{{
+		PluginReference: remotePluginReference{
+			Owner:    p.Owner,
+			Name:     p.Name,
+			Version:  p.Version,
+			Revision: p.Revision,
+		},
+		Parameter: req.Parameter,
+	}}
+	body, err := json.Marshal(rreq)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(registry, "/")+remoteProcedure, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Connect-Protocol-Version", "1")
+	if token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var rerr remoteError
+		if json.Unmarshal(data, &rerr) == nil && rerr.Message != "" {
+			return nil, fmt.Errorf("remote plugin %s failed: %s: %s", p, rerr.Code, rerr.Message)
+		}
+		return nil, fmt.Errorf("remote plugin %s failed: %s", p, resp.Status)
+	}
+	var rresp remoteResponse
+	if err := json.Unmarshal(data, &rresp); err != nil {
+		return nil, fmt.Errorf("unable to decode the response of remote plugin %s: %w", p, err)
+	}
+	if len(rresp.Responses) != 1 {
+		return nil, fmt.Errorf("remote plugin %s returned %d responses, want 1", p, len(rresp.Responses))
+	}
+	var cresp pluginpb.CodeGeneratorResponse
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(rresp.Responses[0].Response, &cresp); err != nil {
+		return nil, fmt.Errorf("unable to decode the response of remote plugin %s: %w", p, err)
+	}
+	return &cresp, nil
+}
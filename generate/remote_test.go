@@ -0,0 +1,81 @@
+package generate
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gunk/gunk/config"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func TestGenerateRemote(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != remoteProcedure {
+			t.Errorf("want path %s, got %s", remoteProcedure, r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code":"unauthenticated","message":"invalid token"}`))
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		var req struct {
+			Image struct {
+				File []struct {
+					Name         string `json:"name"`
+					BufExtension struct {
+						IsImport bool `json:"isImport"`
+					} `json:"bufExtension"`
+				} `json:"file"`
+			} `json:"image"`
+			Requests []remotePluginRequest `json:"requests"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Error(err)
+		}
+		files := req.Image.File
+		if len(files) != 2 || files[0].Name != "dep/all.proto" || !files[0].BufExtension.IsImport || files[1].BufExtension.IsImport {
+			t.Errorf("want dep/all.proto as an import of util/all.proto, got %s", body)
+		}
+		want := remotePluginReference{Owner: "community", Name: "ts", Version: "v1.0.0", Revision: 2}
+		if len(req.Requests) != 1 || req.Requests[0].PluginReference != want || req.Requests[0].Parameter == nil || *req.Requests[0].Parameter != "esm" {
+			t.Errorf("unexpected plugin requests: %s", body)
+		}
+		w.Write([]byte(`{"responses":[{"response":{"file":[{"name":"util/all.ts","content":"export {}"}],"supportedFeatures":"1"}}]}`))
+	}))
+	defer srv.Close()
+
+	p, err := config.ParseRemotePlugin("buf.build/community/ts:v1.0.0-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"util/all.proto"},
+		Parameter:      proto.String("esm"),
+		ProtoFile: []*descriptorpb.FileDescriptorProto{
+			{Name: proto.String("dep/all.proto")},
+			{Name: proto.String("util/all.proto"), Dependency: []string{"dep/all.proto"}},
+		},
+	}
+	resp, err := generateRemote(context.Background(), srv.Client(), srv.URL, "secret", p, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.File) != 1 || resp.File[0].GetName() != "util/all.ts" || resp.GetSupportedFeatures() != 1 {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+	_, err = generateRemote(context.Background(), srv.Client(), srv.URL, "wrong", p, req)
+	if err == nil || !strings.Contains(err.Error(), "remote plugin buf.build/community/ts:v1.0.0 failed: unauthenticated: invalid token") {
+		t.Fatalf("want an unauthenticated error, got %v", err)
+	}
+}
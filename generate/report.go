@@ -0,0 +1,193 @@
+package generate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/gunk/gunk/config"
+	"github.com/gunk/gunk/generate/inprocess"
+	"github.com/gunk/gunk/loader"
+	"github.com/gunk/gunk/log"
+)
+
+// Report describes a generate run, written as JSON to Options.ReportFile so
+// that build systems and caches can tell what to re-run or upload.
+type Report struct {
+	Packages []*PackageReport `json:"packages"`
+	// Warnings printed during the run, such as pruned imports.
+	Warnings   []string `json:"warnings,omitempty"`
+	DurationMS int64    `json:"duration_ms"`
+	// Error is the error the run failed with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// PackageReport describes the generation of a single Gunk package.
+type PackageReport struct {
+	Path string `json:"path"`
+	// Inputs are the Gunk files of the package.
+	Inputs []FileHash `json:"inputs"`
+	// Outputs are the files written by the generators, including
+	// archives.
+	Outputs    []FileHash        `json:"outputs"`
+	Generators []GeneratorReport `json:"generators"`
+	DurationMS int64             `json:"duration_ms"`
+	// Error is the error the package failed with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// GeneratorReport describes a generator run for a package.
+type GeneratorReport struct {
+	// Code is the generator's code, such as "go" or "grpc-gateway".
+	Code    string `json:"code"`
+	Command string `json:"command"`
+	// Version is the version of the generator, if known. For builtin
+	// generators, it is the version of protoc.
+	Version    string `json:"version,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// FileHash is a file path along with the SHA-256 and size of its contents.
+type FileHash struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int    `json:"size"`
+	// Generator is the code of the generator which wrote an output file.
+	Generator string `json:"generator,omitempty"`
+}
+
+func newFileHash(path string, data []byte) FileHash {
+	sum := sha256.Sum256(data)
+	return FileHash{Path: path, SHA256: hex.EncodeToString(sum[:]), Size: len(data)}
+}
+
+// pkgReport returns the report of pkg, adding it if needed. It returns nil if
+// no report is being written.
+func (g *Generator) pkgReport(pkg *loader.GunkPackage) (*PackageReport, error) {
+	if g.report == nil {
+		return nil, nil
+	}
+	for _, pr := range g.report.Packages {
+		if pr.Path == pkg.PkgPath {
+			return pr, nil
+		}
+	}
+	pr := &PackageReport{
+		Path:       pkg.PkgPath,
+		Inputs:     []FileHash{},
+		Outputs:    []FileHash{},
+		Generators: []GeneratorReport{},
+	}
+	for _, path := range pkg.GunkFiles {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pr.Inputs = append(pr.Inputs, newFileHash(path, data))
+	}
+	g.report.Packages = append(g.report.Packages, pr)
+	return pr, nil
+}
+
+// reportFailure records that pkg failed with err.
+func (g *Generator) reportFailure(pkg *loader.GunkPackage, err error) {
+	if pr, _ := g.pkgReport(pkg); pr != nil {
+		pr.Error = err.Error()
+	}
+}
+
+// warnf prints a warning, recording it in the report.
+func (g *Generator) warnf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("%s", msg)
+	if g.report != nil {
+		g.report.Warnings = append(g.report.Warnings, msg)
+	}
+}
+
+// recordOutput records that data was written to path for the current package.
+func (g *Generator) recordOutput(path string, data []byte) {
+	if g.curReport != nil {
+		fh := newFileHash(path, data)
+		fh.Generator = g.curGen
+		g.curReport.Outputs = append(g.curReport.Outputs, fh)
+	}
+}
+
+// recordOutputFile is like recordOutput, reading the data from path.
+func (g *Generator) recordOutputFile(path string) error {
+	if g.curReport == nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	g.recordOutput(path, data)
+	return nil
+}
+
+// recordGenerator records that gen ran for the current package, since start.
+func (g *Generator) recordGenerator(ctx context.Context, gen configWithBinary, protocPath string, start time.Time) {
+	if g.curReport == nil {
+		return
+	}
+	gr := GeneratorReport{
+		Code:       gen.Code(),
+		Command:    gen.actualCommand(),
+		Version:    gen.PluginVersion,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	switch {
+	case gen.IsProtoc():
+		gr.Command = protocPath
+	case gen.InProcess:
+		if !inprocess.Downloaded(gen.Code()) {
+			gr.Command = "gunk (in process)"
+		}
+		gr.Version = inprocess.Version(gen.Code())
+	case gen.Remote != "":
+		p, _ := config.ParseRemotePlugin(gen.Remote)
+		gr.Command = p.Remote + "/" + p.Owner + "/" + p.Name
+		gr.Version = p.Version
+	}
+	if gr.Version == "" && !gen.InProcess && gen.Remote == "" {
+		gr.Version = g.commandVersion(ctx, gr.Command)
+	}
+	g.curReport.Generators = append(g.curReport.Generators, gr)
+}
+
+// commandVersion returns the version command reports with --version, or an
+// empty string if it reports none. Each command is only run once per run.
+func (g *Generator) commandVersion(ctx context.Context, command string) string {
+	if version, ok := g.versions[command]; ok {
+		return version
+	}
+	version, err := runVersion(ctx, command)
+	if err != nil {
+		log.Verbosef("unable to determine version of %s: %v", command, err)
+	}
+	g.versions[command] = version
+	return version
+}
+
+// writeReport writes the report as JSON to path, along with the error the run
+// failed with, if any.
+func (g *Generator) writeReport(path string, start time.Time, runErr error) error {
+	g.report.DurationMS = time.Since(start).Milliseconds()
+	if runErr != nil {
+		g.report.Error = runErr.Error()
+	}
+	data, err := json.MarshalIndent(g.report, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("unable to write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package generate
+
+import (
+	"context"
+
+	"github.com/gunk/gunk/config"
+	"github.com/gunk/gunk/sign"
+)
+
+// anySign reports whether any of the configs has a [sign] section.
+func anySign(pkgConfigs map[string]*config.Config) bool {
+	for _, cfg := range pkgConfigs {
+		if cfg.Sign != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// signOutputs writes the detached signatures of the files generated for the
+// current package which match the files patterns of s. It must be called
+// right after generating the package, as it looks for the files in the
+// outputs of its report.
+func (g *Generator) signOutputs(ctx context.Context, s *config.Sign) error {
+	if g.dryRun != nil {
+		// Nothing was written to sign.
+		return nil
+	}
+	signed := make(map[string]bool)
+	for _, out := range g.curReport.Outputs {
+		if signed[out.Path] || !sign.Match(s, out.Path) {
+			continue
+		}
+		signed[out.Path] = true
+		if _, err := sign.File(ctx, s, out.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package generate
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/gunk/gunk/log"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Limits above which constructs are hinted at in the size report, as they
+// generate a lot of code in most languages.
+const (
+	heavyOneofMembers = 16
+	heavyEnumValues   = 256
+)
+
+// printSizeReport prints the size of the code generated for the package at
+// pkgPath by each generator, from the outputs recorded in pr. The size of each
+// message and service is estimated from the share of the package's descriptor
+// they take, as the code generated for them grows with their descriptors.
+func (g *Generator) printSizeReport(pkgPath string, pr *PackageReport) {
+	if pr == nil {
+		return
+	}
+	file := g.allProto[unifiedProtoFile(pkgPath)]
+	type element struct {
+		name string
+		size int
+	}
+	var elems []element
+	total := 0
+	for _, msg := range file.GetMessageType() {
+		elems = append(elems, element{"message " + msg.GetName(), proto.Size(msg)})
+	}
+	for _, enum := range file.GetEnumType() {
+		elems = append(elems, element{"enum " + enum.GetName(), proto.Size(enum)})
+	}
+	for _, srv := range file.GetService() {
+		elems = append(elems, element{"service " + srv.GetName(), proto.Size(srv)})
+	}
+	for _, e := range elems {
+		total += e.size
+	}
+	sort.SliceStable(elems, func(i, j int) bool { return elems[i].size > elems[j].size })
+
+	var gens []string
+	sizes := make(map[string]int)
+	files := make(map[string]int)
+	for _, out := range pr.Outputs {
+		if _, ok := sizes[out.Generator]; !ok {
+			gens = append(gens, out.Generator)
+		}
+		sizes[out.Generator] += out.Size
+		files[out.Generator]++
+	}
+	log.Printf("%s: generated code size", pkgPath)
+	for _, gen := range gens {
+		log.Printf("\t%s: %s in %d files", gen, formatSize(sizes[gen]), files[gen])
+		if total == 0 {
+			continue
+		}
+		for _, e := range elems {
+			log.Printf("\t\t~%s %s", formatSize(sizes[gen]*e.size/total), e.name)
+		}
+	}
+	for _, hint := range sizeHints(file) {
+		log.Printf("\thint: %s", hint)
+	}
+}
+
+// sizeHints returns hints about the constructs of file which generate a lot
+// of code: oneofs with many members, enums with many values, and maps of
+// messages which have maps themselves.
+func sizeHints(file *descriptorpb.FileDescriptorProto) []string {
+	msgs := make(map[string]*descriptorpb.DescriptorProto)
+	var walk func(scope string, list []*descriptorpb.DescriptorProto)
+	walk = func(scope string, list []*descriptorpb.DescriptorProto) {
+		for _, msg := range list {
+			name := scope + "." + msg.GetName()
+			msgs[name] = msg
+			walk(name, msg.GetNestedType())
+		}
+	}
+	walk("."+file.GetPackage(), file.GetMessageType())
+
+	var hints []string
+	enumHint := func(scope string, enum *descriptorpb.EnumDescriptorProto) {
+		if n := len(enum.GetValue()); n > heavyEnumValues {
+			hints = append(hints, fmt.Sprintf("enum %s%s has %d values; consider splitting it or using strings", scope, enum.GetName(), n))
+		}
+	}
+	for _, enum := range file.GetEnumType() {
+		enumHint("", enum)
+	}
+	for _, msg := range file.GetMessageType() {
+		members := make(map[int32]int)
+		for _, field := range msg.GetField() {
+			if field.OneofIndex != nil {
+				members[field.GetOneofIndex()]++
+			}
+			if entry := msgs[field.GetTypeName()]; entry.GetOptions().GetMapEntry() {
+				value := entry.GetField()[1]
+				if hasMapField(msgs[value.GetTypeName()], msgs) {
+					hints = append(hints, fmt.Sprintf("map field %s.%s holds messages with maps; consider flattening it", msg.GetName(), field.GetName()))
+				}
+			}
+		}
+		for i, oneof := range msg.GetOneofDecl() {
+			if n := members[int32(i)]; n > heavyOneofMembers {
+				hints = append(hints, fmt.Sprintf("oneof %s.%s has %d members, each generating a type in several languages", msg.GetName(), oneof.GetName(), n))
+			}
+		}
+		for _, enum := range msg.GetEnumType() {
+			enumHint(msg.GetName()+".", enum)
+		}
+	}
+	return hints
+}
+
+// hasMapField reports whether msg has a map field.
+func hasMapField(msg *descriptorpb.DescriptorProto, msgs map[string]*descriptorpb.DescriptorProto) bool {
+	for _, field := range msg.GetField() {
+		if msgs[field.GetTypeName()].GetOptions().GetMapEntry() {
+			return true
+		}
+	}
+	return false
+}
+
+// formatSize formats a size in bytes for humans.
+func formatSize(n int) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
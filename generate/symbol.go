@@ -0,0 +1,199 @@
+package generate
+
+import (
+	"strings"
+
+	"github.com/gunk/gunk/config"
+	"github.com/gunk/gunk/loader"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// symbolGenerators are the generators which are run with
+// Options.OnlySymbol, by code. They generate documentation, API specs or
+// mocks, which are still useful for a part of a package, unlike the code of
+// a whole package such as Go types.
+var symbolGenerators = map[string]bool{
+	"docgen":    true,
+	"doc":       true,
+	"openapiv2": true,
+	"swagger":   true,
+	"gomock":    true,
+	"mock":      true,
+}
+
+// supportsSymbol reports whether gen is run with Options.OnlySymbol.
+func supportsSymbol(gen config.Generator) bool {
+	return symbolGenerators[gen.Code()]
+}
+
+// localSymbol returns the name of symbol within pkg, such as Service for
+// "pkg.Service", where pkg is the Go or proto package name of pkg. ok is
+// false if symbol isn't in pkg.
+func localSymbol(symbol string, pkg *loader.GunkPackage) (name string, ok bool) {
+	for _, prefix := range []string{pkg.ProtoName, pkg.Name} {
+		if name := strings.TrimPrefix(symbol, prefix+"."); prefix != "" && name != symbol {
+			return name, !strings.Contains(name, ".")
+		}
+	}
+	return "", false
+}
+
+// pruneRequest returns a copy of req whose files to generate only declare the
+// top-level service, message or enum name, along with the messages and enums
+// it uses, directly or not. The source locations of the files are updated to
+// match. ok is false if none of the files declare name.
+func pruneRequest(req *pluginpb.CodeGeneratorRequest, name string) (_ *pluginpb.CodeGeneratorRequest, ok bool) {
+	messages := make(map[string]*descriptorpb.DescriptorProto)
+	for _, f := range req.ProtoFile {
+		indexMessages(messages, "."+f.GetPackage(), f.MessageType)
+	}
+	generate := make(map[string]bool)
+	for _, name := range req.FileToGenerate {
+		generate[name] = true
+	}
+	pruned := proto.Clone(req).(*pluginpb.CodeGeneratorRequest)
+	for i, f := range pruned.ProtoFile {
+		if !generate[f.GetName()] || !declares(f, name) {
+			continue
+		}
+		ok = true
+		pkg := "." + f.GetPackage() + "."
+		// Types are kept at the top level, along with their nested
+		// types.
+		keep := make(map[string]bool)
+		var use func(typeName string)
+		use = func(typeName string) {
+			if !strings.HasPrefix(typeName, pkg) {
+				// Types of other packages are left as they are.
+				return
+			}
+			top := strings.SplitN(strings.TrimPrefix(typeName, pkg), ".", 2)[0]
+			if keep[top] {
+				return
+			}
+			keep[top] = true
+			if msg, ok := messages[pkg+top]; ok {
+				forEachField(msg, func(field *descriptorpb.FieldDescriptorProto) {
+					if field.TypeName != nil {
+						use(field.GetTypeName())
+					}
+				})
+			}
+		}
+		use(pkg + name)
+		for _, svc := range f.Service {
+			if svc.GetName() == name {
+				for _, m := range svc.Method {
+					use(m.GetInputType())
+					use(m.GetOutputType())
+				}
+			}
+		}
+		pruned.ProtoFile[i] = pruneFile(f, func(name string) bool { return keep[name] })
+	}
+	return pruned, ok
+}
+
+// indexMessages adds msgs, declared in scope, and their nested messages to
+// index, by full name.
+func indexMessages(index map[string]*descriptorpb.DescriptorProto, scope string, msgs []*descriptorpb.DescriptorProto) {
+	for _, msg := range msgs {
+		name := scope + "." + msg.GetName()
+		index[name] = msg
+		indexMessages(index, name, msg.NestedType)
+	}
+}
+
+// forEachField calls fn for each field of msg and of its nested messages.
+func forEachField(msg *descriptorpb.DescriptorProto, fn func(*descriptorpb.FieldDescriptorProto)) {
+	for _, field := range msg.Field {
+		fn(field)
+	}
+	for _, nested := range msg.NestedType {
+		forEachField(nested, fn)
+	}
+}
+
+// declares reports whether f declares a top-level service, message or enum
+// called name.
+func declares(f *descriptorpb.FileDescriptorProto, name string) bool {
+	for _, msg := range f.MessageType {
+		if msg.GetName() == name {
+			return true
+		}
+	}
+	for _, enum := range f.EnumType {
+		if enum.GetName() == name {
+			return true
+		}
+	}
+	for _, svc := range f.Service {
+		if svc.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Field numbers of the top-level declarations of a FileDescriptorProto, as
+// found in the paths of its source locations.
+const (
+	fileMessagePath = 4
+	fileEnumPath    = 5
+	fileServicePath = 6
+)
+
+// pruneFile returns a copy of f keeping only the top-level services, messages
+// and enums whose names are kept, and their source locations, renumbered to
+// match.
+func pruneFile(f *descriptorpb.FileDescriptorProto, keep func(name string) bool) *descriptorpb.FileDescriptorProto {
+	f = proto.Clone(f).(*descriptorpb.FileDescriptorProto)
+	// The new index of the kept declarations, by the field holding them
+	// and their old index.
+	index := map[int32]map[int32]int32{
+		fileMessagePath: {},
+		fileEnumPath:    {},
+		fileServicePath: {},
+	}
+	var msgs []*descriptorpb.DescriptorProto
+	for i, msg := range f.MessageType {
+		if keep(msg.GetName()) {
+			index[fileMessagePath][int32(i)] = int32(len(msgs))
+			msgs = append(msgs, msg)
+		}
+	}
+	var enums []*descriptorpb.EnumDescriptorProto
+	for i, enum := range f.EnumType {
+		if keep(enum.GetName()) {
+			index[fileEnumPath][int32(i)] = int32(len(enums))
+			enums = append(enums, enum)
+		}
+	}
+	var svcs []*descriptorpb.ServiceDescriptorProto
+	for i, svc := range f.Service {
+		if keep(svc.GetName()) {
+			index[fileServicePath][int32(i)] = int32(len(svcs))
+			svcs = append(svcs, svc)
+		}
+	}
+	f.MessageType, f.EnumType, f.Service = msgs, enums, svcs
+	if info := f.SourceCodeInfo; info != nil {
+		var locs []*descriptorpb.SourceCodeInfo_Location
+		for _, loc := range info.Location {
+			if len(loc.Path) >= 2 {
+				if decls, ok := index[loc.Path[0]]; ok {
+					i, ok := decls[loc.Path[1]]
+					if !ok {
+						continue
+					}
+					loc.Path[1] = i
+				}
+			}
+			locs = append(locs, loc)
+		}
+		info.Location = locs
+	}
+	return f
+}
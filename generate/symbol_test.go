@@ -0,0 +1,91 @@
+package generate
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func TestPruneRequest(t *testing.T) {
+	field := func(typeName string) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{TypeName: proto.String(typeName)}
+	}
+	loc := func(path ...int32) *descriptorpb.SourceCodeInfo_Location {
+		return &descriptorpb.SourceCodeInfo_Location{Path: path}
+	}
+	req := &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"pkg/all.proto"},
+		ProtoFile: []*descriptorpb.FileDescriptorProto{{
+			Name:    proto.String("pkg/all.proto"),
+			Package: proto.String("pkg"),
+			MessageType: []*descriptorpb.DescriptorProto{
+				{Name: proto.String("Card")},
+				{Name: proto.String("Account"), Field: []*descriptorpb.FieldDescriptorProto{
+					field(".pkg.Account.Owner"),
+				}, NestedType: []*descriptorpb.DescriptorProto{
+					{Name: proto.String("Owner"), Field: []*descriptorpb.FieldDescriptorProto{
+						field(".pkg.Status"),
+						field(".google.protobuf.Timestamp"),
+					}},
+				}},
+				{Name: proto.String("GetAccountRequest")},
+			},
+			EnumType: []*descriptorpb.EnumDescriptorProto{
+				{Name: proto.String("Color")},
+				{Name: proto.String("Status")},
+			},
+			Service: []*descriptorpb.ServiceDescriptorProto{
+				{Name: proto.String("Cards")},
+				{Name: proto.String("Accounts"), Method: []*descriptorpb.MethodDescriptorProto{{
+					InputType:  proto.String(".pkg.GetAccountRequest"),
+					OutputType: proto.String(".pkg.Account"),
+				}}},
+			},
+			SourceCodeInfo: &descriptorpb.SourceCodeInfo{Location: []*descriptorpb.SourceCodeInfo_Location{
+				loc(2),
+				loc(4, 0),
+				loc(4, 1, 2, 0),
+				loc(4, 2),
+				loc(5, 0),
+				loc(5, 1),
+				loc(6, 0),
+				loc(6, 1, 2, 0),
+			}},
+		}},
+	}
+	pruned, ok := pruneRequest(req, "Accounts")
+	if !ok {
+		t.Fatal("Accounts not found")
+	}
+	f := pruned.ProtoFile[0]
+	var names []string
+	for _, msg := range f.MessageType {
+		names = append(names, msg.GetName())
+	}
+	for _, enum := range f.EnumType {
+		names = append(names, enum.GetName())
+	}
+	for _, svc := range f.Service {
+		names = append(names, svc.GetName())
+	}
+	if want := []string{"Account", "GetAccountRequest", "Status", "Accounts"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("wrong declarations, got %q want %q", names, want)
+	}
+	var paths [][]int32
+	for _, loc := range f.SourceCodeInfo.Location {
+		paths = append(paths, loc.Path)
+	}
+	if want := [][]int32{{2}, {4, 0, 2, 0}, {4, 1}, {5, 0}, {6, 0, 2, 0}}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("wrong source locations, got %v want %v", paths, want)
+	}
+	// The original request is left as it is.
+	if n := len(req.ProtoFile[0].MessageType); n != 3 {
+		t.Errorf("request modified, got %d messages", n)
+	}
+	if _, ok := pruneRequest(req, "Missing"); ok {
+		t.Error("Missing found")
+	}
+}
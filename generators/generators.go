@@ -0,0 +1,66 @@
+package generators
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"text/tabwriter"
+
+	"github.com/gunk/gunk/config"
+	"github.com/gunk/gunk/generate/downloader"
+	"github.com/gunk/gunk/generate/inprocess"
+)
+
+// List writes the generators built into the protoc version configured for
+// dir, followed by the generators configured for dir and how each of them
+// will be run.
+func List(w io.Writer, dir string) error {
+	cfg, err := config.Load(dir)
+	if err != nil {
+		return fmt.Errorf("unable to load gunkconfig: %w", err)
+	}
+	version, pinned := cfg.ProtocVersion, "pinned"
+	if version == "" {
+		version, pinned = downloader.DefaultProtocVersion, "default"
+	}
+	builtins := make(map[string]bool)
+	fmt.Fprintf(w, "protoc %s (%s) builtin generators:\n", version, pinned)
+	for _, lang := range config.ProtocBuiltins(version) {
+		builtins[lang] = true
+		fmt.Fprintf(w, "  %s\n", lang)
+	}
+	if len(cfg.Generators) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "\nconfigured generators:\n")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, gen := range cfg.Generators {
+		fmt.Fprintf(tw, "  %s\t%s\n", gen.Code(), describe(gen, builtins))
+	}
+	return tw.Flush()
+}
+
+func describe(gen config.Generator, builtins map[string]bool) string {
+	switch {
+	case gen.IsProtoc() && builtins[gen.ProtocGen]:
+		return "protoc builtin"
+	case gen.IsProtoc():
+		plugin := "protoc-gen-" + gen.ProtocGen
+		if path, err := exec.LookPath(plugin); err == nil {
+			return fmt.Sprintf("protoc, using %s", path)
+		}
+		return fmt.Sprintf("protoc, but %s is not in $PATH", plugin)
+	case gen.InProcess && inprocess.Downloaded(gen.Code()):
+		return fmt.Sprintf("%s %s, downloaded by gunk", gen.Command, inprocess.Version(gen.Code()))
+	case gen.InProcess:
+		return fmt.Sprintf("in process, %s %s", gen.Command, inprocess.Version(gen.Code()))
+	case gen.Remote != "":
+		return fmt.Sprintf("remote, %s", gen.Remote)
+	case gen.PluginVersion != "":
+		return fmt.Sprintf("%s %s, downloaded by gunk", gen.Command, gen.PluginVersion)
+	}
+	if path, err := exec.LookPath(gen.Command); err == nil {
+		return path
+	}
+	return fmt.Sprintf("%s is not in $PATH", gen.Command)
+}
@@ -0,0 +1,113 @@
+// Package interrupt cleans up after gunk when it is interrupted, such as via
+// Ctrl-C, so that no temporary files or half-written outputs are left behind.
+package interrupt
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ExitCode is the exit code used when gunk has to exit because of an
+// interrupt, following the shell convention of 128 plus SIGINT.
+const ExitCode = 130
+
+// Grace is how long a command has to stop by itself once interrupted, before
+// the pending cleanups are run and gunk exits.
+var Grace = 5 * time.Second
+
+var (
+	mu      sync.Mutex
+	pending = make(map[*Cleanup]bool)
+)
+
+// Cleanup is a func registered to be run if gunk is interrupted.
+type Cleanup struct {
+	once sync.Once
+	fn   func()
+}
+
+// Register registers fn to be run if gunk is interrupted before the returned
+// Cleanup is run or released.
+func Register(fn func()) *Cleanup {
+	c := &Cleanup{fn: fn}
+	mu.Lock()
+	pending[c] = true
+	mu.Unlock()
+	return c
+}
+
+// Run unregisters the cleanup and runs its func, unless it already ran due to
+// an interrupt.
+func (c *Cleanup) Run() {
+	c.unregister()
+	c.once.Do(c.fn)
+}
+
+// Release unregisters the cleanup without running its func, such as once the
+// work it would undo has completed successfully.
+func (c *Cleanup) Release() {
+	c.unregister()
+	c.once.Do(func() {})
+}
+
+func (c *Cleanup) unregister() {
+	mu.Lock()
+	delete(pending, c)
+	mu.Unlock()
+}
+
+// RunAll runs all the pending cleanups.
+func RunAll() {
+	mu.Lock()
+	cs := make([]*Cleanup, 0, len(pending))
+	for c := range pending {
+		cs = append(cs, c)
+	}
+	pending = make(map[*Cleanup]bool)
+	mu.Unlock()
+	for _, c := range cs {
+		c.once.Do(c.fn)
+	}
+}
+
+// NotifyContext returns a copy of parent which is cancelled once gunk receives
+// an interrupt or termination signal, so that the running command can stop
+// its child processes and clean up after itself.
+//
+// If the command hasn't stopped within Grace, or if a second signal arrives,
+// the pending cleanups are run and gunk exits with ExitCode. Calling stop
+// releases the resources and stops listening for signals.
+func NotifyContext(parent context.Context) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(parent)
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-sigs:
+		case <-done:
+			return
+		}
+		cancel()
+		select {
+		case <-sigs:
+		case <-time.After(Grace):
+		case <-done:
+			return
+		}
+		RunAll()
+		os.Exit(ExitCode)
+	}()
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			signal.Stop(sigs)
+			close(done)
+			cancel()
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package interrupt
+
+import "testing"
+
+func TestCleanup(t *testing.T) {
+	var ran, released, pendingRan int
+	run := Register(func() { ran++ })
+	rel := Register(func() { released++ })
+	Register(func() { pendingRan++ })
+
+	run.Run()
+	rel.Release()
+	RunAll()
+	run.Run()
+	RunAll()
+
+	if ran != 1 {
+		t.Errorf("Run ran the func %d times, want 1", ran)
+	}
+	if released != 0 {
+		t.Errorf("released func ran %d times, want 0", released)
+	}
+	if pendingRan != 1 {
+		t.Errorf("RunAll ran the pending func %d times, want 1", pendingRan)
+	}
+}
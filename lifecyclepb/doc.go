@@ -0,0 +1,6 @@
+// Package lifecyclepb holds the Go types of the options of
+// gunk/lifecycle/lifecycle.proto, which declare the lifecycle stages of
+// packages, services and methods.
+package lifecyclepb
+
+//go:generate protoc -I../assets/bundled --go_out=. --go_opt=module=github.com/gunk/gunk/lifecyclepb gunk/lifecycle/lifecycle.proto
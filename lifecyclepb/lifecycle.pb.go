@@ -0,0 +1,226 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.27.1
+// 	protoc        (unknown)
+// source: gunk/lifecycle/lifecycle.proto
+
+package lifecyclepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	descriptorpb "google.golang.org/protobuf/types/descriptorpb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Stage is the lifecycle stage of an API, from the least to the most stable.
+type Stage int32
+
+const (
+	// The stage isn't set.
+	Stage_STAGE_UNSPECIFIED Stage = 0
+	// The API is experimental, and may change or be removed at any time.
+	Stage_ALPHA Stage = 1
+	// The API is feature complete, but may still change in incompatible ways.
+	Stage_BETA Stage = 2
+	// The API is generally available, and only changes in compatible ways.
+	Stage_GA Stage = 3
+)
+
+// Enum value maps for Stage.
+var (
+	Stage_name = map[int32]string{
+		0: "STAGE_UNSPECIFIED",
+		1: "ALPHA",
+		2: "BETA",
+		3: "GA",
+	}
+	Stage_value = map[string]int32{
+		"STAGE_UNSPECIFIED": 0,
+		"ALPHA":             1,
+		"BETA":              2,
+		"GA":                3,
+	}
+)
+
+func (x Stage) Enum() *Stage {
+	p := new(Stage)
+	*p = x
+	return p
+}
+
+func (x Stage) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Stage) Descriptor() protoreflect.EnumDescriptor {
+	return file_gunk_lifecycle_lifecycle_proto_enumTypes[0].Descriptor()
+}
+
+func (Stage) Type() protoreflect.EnumType {
+	return &file_gunk_lifecycle_lifecycle_proto_enumTypes[0]
+}
+
+func (x Stage) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Stage.Descriptor instead.
+func (Stage) EnumDescriptor() ([]byte, []int) {
+	return file_gunk_lifecycle_lifecycle_proto_rawDescGZIP(), []int{0}
+}
+
+var file_gunk_lifecycle_lifecycle_proto_extTypes = []protoimpl.ExtensionInfo{
+	{
+		ExtendedType:  (*descriptorpb.FileOptions)(nil),
+		ExtensionType: (*Stage)(nil),
+		Field:         91500,
+		Name:          "gunk.lifecycle.file_stage",
+		Tag:           "varint,91500,opt,name=file_stage,enum=gunk.lifecycle.Stage",
+		Filename:      "gunk/lifecycle/lifecycle.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.ServiceOptions)(nil),
+		ExtensionType: (*Stage)(nil),
+		Field:         91500,
+		Name:          "gunk.lifecycle.service_stage",
+		Tag:           "varint,91500,opt,name=service_stage,enum=gunk.lifecycle.Stage",
+		Filename:      "gunk/lifecycle/lifecycle.proto",
+	},
+	{
+		ExtendedType:  (*descriptorpb.MethodOptions)(nil),
+		ExtensionType: (*Stage)(nil),
+		Field:         91500,
+		Name:          "gunk.lifecycle.method_stage",
+		Tag:           "varint,91500,opt,name=method_stage,enum=gunk.lifecycle.Stage",
+		Filename:      "gunk/lifecycle/lifecycle.proto",
+	},
+}
+
+// Extension fields to descriptorpb.FileOptions.
+var (
+	// The lifecycle stage of the package, see [Stage][].
+	//
+	// optional gunk.lifecycle.Stage file_stage = 91500;
+	E_FileStage = &file_gunk_lifecycle_lifecycle_proto_extTypes[0]
+)
+
+// Extension fields to descriptorpb.ServiceOptions.
+var (
+	// The lifecycle stage of the service, if it differs from the one of its
+	// package.
+	//
+	// optional gunk.lifecycle.Stage service_stage = 91500;
+	E_ServiceStage = &file_gunk_lifecycle_lifecycle_proto_extTypes[1]
+)
+
+// Extension fields to descriptorpb.MethodOptions.
+var (
+	// The lifecycle stage of the method, if it differs from the one of its
+	// service.
+	//
+	// optional gunk.lifecycle.Stage method_stage = 91500;
+	E_MethodStage = &file_gunk_lifecycle_lifecycle_proto_extTypes[2]
+)
+
+var File_gunk_lifecycle_lifecycle_proto protoreflect.FileDescriptor
+
+var file_gunk_lifecycle_lifecycle_proto_rawDesc = []byte{
+	0x0a, 0x1e, 0x67, 0x75, 0x6e, 0x6b, 0x2f, 0x6c, 0x69, 0x66, 0x65, 0x63, 0x79, 0x63, 0x6c, 0x65,
+	0x2f, 0x6c, 0x69, 0x66, 0x65, 0x63, 0x79, 0x63, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x12, 0x0e, 0x67, 0x75, 0x6e, 0x6b, 0x2e, 0x6c, 0x69, 0x66, 0x65, 0x63, 0x79, 0x63, 0x6c, 0x65,
+	0x1a, 0x20, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x2f, 0x64, 0x65, 0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x6f, 0x72, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x2a, 0x3b, 0x0a, 0x05, 0x53, 0x74, 0x61, 0x67, 0x65, 0x12, 0x15, 0x0a, 0x11, 0x53,
+	0x54, 0x41, 0x47, 0x45, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44,
+	0x10, 0x00, 0x12, 0x09, 0x0a, 0x05, 0x41, 0x4c, 0x50, 0x48, 0x41, 0x10, 0x01, 0x12, 0x08, 0x0a,
+	0x04, 0x42, 0x45, 0x54, 0x41, 0x10, 0x02, 0x12, 0x06, 0x0a, 0x02, 0x47, 0x41, 0x10, 0x03, 0x3a,
+	0x54, 0x0a, 0x0a, 0x66, 0x69, 0x6c, 0x65, 0x5f, 0x73, 0x74, 0x61, 0x67, 0x65, 0x12, 0x1c, 0x2e,
+	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
+	0x46, 0x69, 0x6c, 0x65, 0x4f, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0xec, 0xca, 0x05, 0x20,
+	0x01, 0x28, 0x0e, 0x32, 0x15, 0x2e, 0x67, 0x75, 0x6e, 0x6b, 0x2e, 0x6c, 0x69, 0x66, 0x65, 0x63,
+	0x79, 0x63, 0x6c, 0x65, 0x2e, 0x53, 0x74, 0x61, 0x67, 0x65, 0x52, 0x09, 0x66, 0x69, 0x6c, 0x65,
+	0x53, 0x74, 0x61, 0x67, 0x65, 0x3a, 0x5d, 0x0a, 0x0d, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65,
+	0x5f, 0x73, 0x74, 0x61, 0x67, 0x65, 0x12, 0x1f, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65,
+	0x4f, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0xec, 0xca, 0x05, 0x20, 0x01, 0x28, 0x0e, 0x32,
+	0x15, 0x2e, 0x67, 0x75, 0x6e, 0x6b, 0x2e, 0x6c, 0x69, 0x66, 0x65, 0x63, 0x79, 0x63, 0x6c, 0x65,
+	0x2e, 0x53, 0x74, 0x61, 0x67, 0x65, 0x52, 0x0c, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x53,
+	0x74, 0x61, 0x67, 0x65, 0x3a, 0x5a, 0x0a, 0x0c, 0x6d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x5f, 0x73,
+	0x74, 0x61, 0x67, 0x65, 0x12, 0x1e, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x4d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x4f, 0x70, 0x74,
+	0x69, 0x6f, 0x6e, 0x73, 0x18, 0xec, 0xca, 0x05, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x15, 0x2e, 0x67,
+	0x75, 0x6e, 0x6b, 0x2e, 0x6c, 0x69, 0x66, 0x65, 0x63, 0x79, 0x63, 0x6c, 0x65, 0x2e, 0x53, 0x74,
+	0x61, 0x67, 0x65, 0x52, 0x0b, 0x6d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x53, 0x74, 0x61, 0x67, 0x65,
+	0x42, 0x2e, 0x5a, 0x2c, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x67,
+	0x75, 0x6e, 0x6b, 0x2f, 0x67, 0x75, 0x6e, 0x6b, 0x2f, 0x6c, 0x69, 0x66, 0x65, 0x63, 0x79, 0x63,
+	0x6c, 0x65, 0x70, 0x62, 0x3b, 0x6c, 0x69, 0x66, 0x65, 0x63, 0x79, 0x63, 0x6c, 0x65, 0x70, 0x62,
+	0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_gunk_lifecycle_lifecycle_proto_rawDescOnce sync.Once
+	file_gunk_lifecycle_lifecycle_proto_rawDescData = file_gunk_lifecycle_lifecycle_proto_rawDesc
+)
+
+func file_gunk_lifecycle_lifecycle_proto_rawDescGZIP() []byte {
+	file_gunk_lifecycle_lifecycle_proto_rawDescOnce.Do(func() {
+		file_gunk_lifecycle_lifecycle_proto_rawDescData = protoimpl.X.CompressGZIP(file_gunk_lifecycle_lifecycle_proto_rawDescData)
+	})
+	return file_gunk_lifecycle_lifecycle_proto_rawDescData
+}
+
+var file_gunk_lifecycle_lifecycle_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_gunk_lifecycle_lifecycle_proto_goTypes = []interface{}{
+	(Stage)(0),                          // 0: gunk.lifecycle.Stage
+	(*descriptorpb.FileOptions)(nil),    // 1: google.protobuf.FileOptions
+	(*descriptorpb.ServiceOptions)(nil), // 2: google.protobuf.ServiceOptions
+	(*descriptorpb.MethodOptions)(nil),  // 3: google.protobuf.MethodOptions
+}
+var file_gunk_lifecycle_lifecycle_proto_depIdxs = []int32{
+	1, // 0: gunk.lifecycle.file_stage:extendee -> google.protobuf.FileOptions
+	2, // 1: gunk.lifecycle.service_stage:extendee -> google.protobuf.ServiceOptions
+	3, // 2: gunk.lifecycle.method_stage:extendee -> google.protobuf.MethodOptions
+	0, // 3: gunk.lifecycle.file_stage:type_name -> gunk.lifecycle.Stage
+	0, // 4: gunk.lifecycle.service_stage:type_name -> gunk.lifecycle.Stage
+	0, // 5: gunk.lifecycle.method_stage:type_name -> gunk.lifecycle.Stage
+	6, // [6:6] is the sub-list for method output_type
+	6, // [6:6] is the sub-list for method input_type
+	3, // [3:6] is the sub-list for extension type_name
+	0, // [0:3] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_gunk_lifecycle_lifecycle_proto_init() }
+func file_gunk_lifecycle_lifecycle_proto_init() {
+	if File_gunk_lifecycle_lifecycle_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_gunk_lifecycle_lifecycle_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   0,
+			NumExtensions: 3,
+			NumServices:   0,
+		},
+		GoTypes:           file_gunk_lifecycle_lifecycle_proto_goTypes,
+		DependencyIndexes: file_gunk_lifecycle_lifecycle_proto_depIdxs,
+		EnumInfos:         file_gunk_lifecycle_lifecycle_proto_enumTypes,
+		ExtensionInfos:    file_gunk_lifecycle_lifecycle_proto_extTypes,
+	}.Build()
+	File_gunk_lifecycle_lifecycle_proto = out.File
+	file_gunk_lifecycle_lifecycle_proto_rawDesc = nil
+	file_gunk_lifecycle_lifecycle_proto_goTypes = nil
+	file_gunk_lifecycle_lifecycle_proto_depIdxs = nil
+}
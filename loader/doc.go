@@ -0,0 +1,20 @@
+// Package loader loads Gunk packages, for use by gunk commands and other tools
+// such as editors and linters.
+//
+// A Loader resolves packages from import paths, directories or files, and
+// caches them for its lifetime:
+//
+//	l := &loader.Loader{Dir: dir, Fset: token.NewFileSet()}
+//	pkg, err := l.Package(ctx, "example.com/api/v1")
+//
+// Without Types, only the syntax of the requested packages is parsed, which is
+// enough for tools working with syntax alone. A package can be type-checked
+// later with Check, which also loads its imports and parses its "+gunk" tags,
+// available via GunkPackage.Tags. With Types, each loaded package and its
+// imports are type-checked straight away.
+//
+// Errors found in packages, such as syntax or type errors, are recorded in
+// each package's Errors field rather than returned, and can be printed with
+// PrintErrors. The errors returned by the loader methods are for failures to
+// load at all, including the context being done.
+package loader
@@ -0,0 +1,40 @@
+package loader
+
+import "go/ast"
+
+// EmbeddedName returns the name of the embedded struct field field, which is
+// the name of its type as in Go, such as Base for an embedded Base or
+// common.Base. It returns an empty string if field isn't embedded, or its
+// type can't be a message.
+func EmbeddedName(field *ast.Field) string {
+	if len(field.Names) != 0 {
+		return ""
+	}
+	switch typ := field.Type.(type) {
+	case *ast.Ident:
+		return typ.Name
+	case *ast.SelectorExpr:
+		return typ.Sel.Name
+	}
+	return ""
+}
+
+// structFieldName returns the name of the struct field field, which is the
+// name of its type if it's embedded.
+func structFieldName(field *ast.Field) string {
+	if len(field.Names) > 0 {
+		return field.Names[0].Name
+	}
+	return EmbeddedName(field)
+}
+
+// hasFlatten reports whether the options of a pb struct tag include
+// FlattenOption.
+func hasFlatten(opts []string) bool {
+	for _, opt := range opts {
+		if opt == FlattenOption {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package loader
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"go/ast"
@@ -21,20 +22,52 @@ import (
 	"time"
 
 	"github.com/gunk/gunk/assets"
+	"github.com/gunk/gunk/interrupt"
 	"github.com/gunk/gunk/log"
+	"github.com/gunk/gunk/workspace"
 	"golang.org/x/tools/go/packages"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/descriptorpb"
 )
 
+// Loader loads Gunk packages. A Loader caches the packages it loads, so that
+// each package is only loaded once; it is not safe for concurrent use.
 type Loader struct {
 	Dir  string
 	Fset *token.FileSet
 	// If Types is true, we parse and type-check the given packages and all
 	// transitive dependencies, including gunk tags. Otherwise, we only
-	// parse the given packages.
+	// parse the given packages, and they can be type-checked later with
+	// Check.
 	Types bool
 	cache map[string]*GunkPackage // map from import path to pkg
+	// ProtoLoader loads the .proto files imported by Gunk files. If nil,
+	// they are loaded from the files bundled with Gunk, or with the protoc
+	// in PATH.
+	ProtoLoader *ProtoLoader
+	// ctx is the context of the ongoing LoadContext or Check call, used
+	// when loading imports via Import.
+	ctx context.Context
+	// buildLists are the modules of each main module loaded from, to
+	// resolve the Gunk packages of their dependencies; see
+	// dependencyPackage.
+	buildLists map[string][]*module
+}
+
+// context returns the context of the ongoing load, if any.
+func (l *Loader) context() context.Context {
+	if l.ctx == nil {
+		return context.Background()
+	}
+	return l.ctx
+}
+
+// withContext sets the context of the ongoing load to ctx, returning a func
+// to restore the previous one.
+func (l *Loader) withContext(ctx context.Context) (restore func()) {
+	prev := l.ctx
+	l.ctx = ctx
+	return func() { l.ctx = prev }
 }
 
 var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -56,7 +89,7 @@ func (l *Loader) addTempGoFiles() (undo func(), _ error) {
 	// cleaning up after ourselves.
 	// See https://github.com/golang/go/issues/29047.
 	root := "."
-	cmd := exec.Command("go", "list", "-m", "-f={{.Dir}}")
+	cmd := exec.CommandContext(l.context(), "go", "list", "-m", "-f={{.Dir}}")
 	cmd.Dir = l.Dir
 	// use "." if we encountered an error, for e.g. GOPATH mode
 	if out, err := cmd.Output(); err == nil {
@@ -114,15 +147,21 @@ func (l *Loader) addTempGoFiles() (undo func(), _ error) {
 	}); err != nil {
 		return nil, err
 	}
-	return func() {
-		anyErr := false
+	remove := func() (anyErr bool) {
 		for _, path := range toDelete {
-			if err := os.Remove(path); err != nil {
+			// The files may already be gone if gunk was
+			// interrupted.
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
 				anyErr = true
 				fmt.Fprintf(os.Stderr, "could not delete gunkpkg file: %v", err)
 			}
 		}
-		if anyErr {
+		return anyErr
+	}
+	cleanup := interrupt.Register(func() { remove() })
+	return func() {
+		cleanup.Release()
+		if remove() {
 			panic("could not delete some of the gunkpkg files")
 		}
 	}, nil
@@ -133,16 +172,49 @@ func (l *Loader) addTempGoFiles() (undo func(), _ error) {
 //
 // Similar to Go, if a path begins with ".", it is interpreted as a file system
 // path where a package is located, and "..." patterns are supported.
+//
+// Without patterns, if the directory is within a workspace but outside of its
+// modules, such as at the root of the workspace, the Gunk packages of all its
+// modules are loaded; see the workspace package.
 func (l *Loader) Load(patterns ...string) ([]*GunkPackage, error) {
+	return l.LoadContext(l.context(), patterns...)
+}
+
+// LoadContext is like Load, but stops loading and returns the context's error
+// once ctx is done.
+func (l *Loader) LoadContext(ctx context.Context, patterns ...string) ([]*GunkPackage, error) {
+	defer l.withContext(ctx)()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if len(patterns) == 1 {
 		pkgPath := patterns[0]
 		if pkg := l.cache[pkgPath]; pkg != nil {
 			return []*GunkPackage{pkg}, nil
 		}
 	}
+	if len(patterns) == 0 {
+		// Without patterns, the whole workspace is loaded, unless
+		// within one of its modules, where "." is loaded as usual.
+		ws, err := workspace.Find(l.Dir)
+		if err != nil {
+			return nil, err
+		}
+		if dir, err := filepath.Abs(l.Dir); err == nil && ws != nil && !ws.Contains(dir) {
+			return l.loadWorkspace(ctx, ws)
+		}
+	}
 	var pkgs []*GunkPackage
 	loadFiles := len(patterns) > 0 && strings.HasSuffix(patterns[0], ".gunk")
-	if loadFiles {
+	var dep *GunkPackage
+	if len(patterns) == 1 && !loadFiles {
+		var err error
+		if dep, err = l.dependencyPackage(patterns[0]); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case loadFiles:
 		// If we're given a number of files, construct a
 		// packages.Package manually. go/packages will treat foo.gunk as
 		// an import path instead of a file, as it's not a Go file.
@@ -154,7 +226,11 @@ func (l *Loader) Load(patterns ...string) ([]*GunkPackage, error) {
 			},
 			GunkFiles: patterns,
 		})
-	} else {
+	case dep != nil:
+		// A Gunk package of a module dependency, which go/packages
+		// cannot load without Go files.
+		pkgs = append(pkgs, dep)
+	default:
 		// First, make sure that all Gunk packages have Go files.
 		undo, err := l.addTempGoFiles()
 		if err != nil {
@@ -163,8 +239,9 @@ func (l *Loader) Load(patterns ...string) ([]*GunkPackage, error) {
 		defer undo()
 		// Load the Gunk packages as Go packages.
 		cfg := &packages.Config{
-			Dir:  l.Dir,
-			Mode: packages.LoadFiles,
+			Context: ctx,
+			Dir:     l.Dir,
+			Mode:    packages.LoadFiles,
 		}
 		lpkgs, err := packages.Load(cfg, patterns...)
 		if err != nil {
@@ -182,6 +259,9 @@ func (l *Loader) Load(patterns ...string) ([]*GunkPackage, error) {
 	}
 	// Add the Gunk files to each package.
 	for _, pkg := range pkgs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		l.parseGunkPackage(pkg)
 		l.validatePackage(pkg)
 		if l.cache == nil {
@@ -192,6 +272,52 @@ func (l *Loader) Load(patterns ...string) ([]*GunkPackage, error) {
 	return pkgs, nil
 }
 
+// loadWorkspace loads all the Gunk packages of the modules of ws, in order.
+func (l *Loader) loadWorkspace(ctx context.Context, ws *workspace.Workspace) ([]*GunkPackage, error) {
+	dir := l.Dir
+	defer func() { l.Dir = dir }()
+	var pkgs []*GunkPackage
+	for _, use := range ws.Use {
+		// Load each module from its directory, as go/packages only
+		// sees the packages of the main module.
+		l.Dir = use
+		upkgs, err := l.LoadContext(ctx, "./...")
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, upkgs...)
+	}
+	return pkgs, nil
+}
+
+// Package returns the Gunk package with the given import path. It is only
+// loaded if it wasn't loaded before by l, so that tools can resolve the
+// packages they need one at a time. Loading errors are found in the package's
+// Errors field, as with Load.
+func (l *Loader) Package(ctx context.Context, pkgPath string) (*GunkPackage, error) {
+	pkgs, err := l.LoadContext(ctx, pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("%s is not a Gunk package", pkgPath)
+	}
+	return pkgs[0], nil
+}
+
+// Check type-checks a package loaded without Types, along with its imports,
+// and parses its gunk tags. It does nothing if the package was already
+// type-checked. As with Load, errors found in the package are added to its
+// Errors field, and the returned error is only for ctx being done.
+func (l *Loader) Check(ctx context.Context, pkg *GunkPackage) error {
+	defer l.withContext(ctx)()
+	if pkg.Types != nil || len(pkg.Errors) > 0 {
+		return ctx.Err()
+	}
+	l.checkPackage(pkg)
+	return ctx.Err()
+}
+
 // findGunkFiles fills a package's GunkFiles field with the gunk files found in
 // the package directory. This is used when loading a Gunk package via an import
 // path or a directory.
@@ -236,24 +362,50 @@ const (
 // Aside from that, it is very similar to standard Go importers that load from
 // source.
 func (l *Loader) Import(path string) (*types.Package, error) {
-	if !strings.Contains(path, ".") {
-		cfg := &packages.Config{Mode: packages.LoadTypes}
-		pkgs, err := packages.Load(cfg, path)
+	if IsProtoImport(path) {
+		pkg, err := l.importProto(path)
 		if err != nil {
 			return nil, err
 		}
-		if len(pkgs) != 1 {
-			panic("expected go/packages.Load to return exactly one package")
-		}
-		return pkgs[0].Types, nil
+		return pkg.Types, nil
+	}
+	if !strings.Contains(path, ".") {
+		return l.importGo(path)
 	}
 	pkgs, err := l.Load(path)
 	if err != nil {
 		return nil, err
 	}
+	if len(pkgs) == 0 {
+		// A Go package without Gunk files, whose types can be used
+		// if mapped to proto types in the [types] section of a
+		// .gunkconfig.
+		return l.importGo(path)
+	}
 	if len(pkgs) != 1 {
 		panic("expected Loader.Load to return exactly one package")
 	}
+	if pkgs[0].Types == nil && len(pkgs[0].Errors) == 0 {
+		// Loaded earlier without types; see Check.
+		l.checkPackage(pkgs[0])
+	}
+	return pkgs[0].Types, nil
+}
+
+// importGo loads the types of a Go package, such as one from the standard
+// library.
+func (l *Loader) importGo(path string) (*types.Package, error) {
+	cfg := &packages.Config{Context: l.context(), Dir: l.Dir, Mode: packages.LoadTypes}
+	pkgs, err := packages.Load(cfg, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		panic("expected go/packages.Load to return exactly one package")
+	}
+	if errs := pkgs[0].Errors; len(errs) > 0 {
+		return nil, errs[0]
+	}
 	return pkgs[0].Types, nil
 }
 
@@ -272,6 +424,12 @@ type GunkPackage struct {
 	GunkTags  map[ast.Node][]GunkTag
 	Imports   map[string]*GunkPackage
 	ProtoName string // protobuf package name
+	// ProtoFile is the path of the .proto file imported as this package,
+	// such as "google/type/money.proto", if it isn't a Gunk package.
+	ProtoFile string
+	// ProtoTypes maps the names of the types of a .proto import, such as
+	// Outer_Inner, to their full proto names, such as ".pkg.Outer.Inner".
+	ProtoTypes map[string]string
 }
 
 func (g *GunkPackage) addError(kind packages.ErrorKind, tokenPos token.Pos, fset *token.FileSet, format string, args ...interface{}) {
@@ -286,6 +444,13 @@ func (g *GunkPackage) addError(kind packages.ErrorKind, tokenPos token.Pos, fset
 	})
 }
 
+// Tags returns the "+gunk" tags of a node in GunkSyntax, such as an
+// *ast.TypeSpec or an *ast.Field. Tags are only available once the package
+// has been type-checked, either by loading it with Types or with Check.
+func (g *GunkPackage) Tags(node ast.Node) []GunkTag {
+	return g.GunkTags[node]
+}
+
 type GunkTag struct {
 	ast.Expr                // original expression
 	Type     types.Type     // type of the expression
@@ -336,6 +501,11 @@ func (l *Loader) parseGunkPackage(pkg *GunkPackage) {
 	if !l.Types {
 		return
 	}
+	l.checkPackage(pkg)
+}
+
+// checkPackage type-checks a parsed package, and splits its gunk tags.
+func (l *Loader) checkPackage(pkg *GunkPackage) {
 	pkg.Types = types.NewPackage(pkg.PkgPath, pkg.Name)
 	tconfig := &types.Config{
 		DisableUnusedImportCheck: true,
@@ -376,14 +546,24 @@ func (l *Loader) parseGunkPackage(pkg *GunkPackage) {
 // shared among all gunk commands.
 func (l *Loader) validatePackage(pkg *GunkPackage) {
 	for _, file := range pkg.GunkSyntax {
+		// The members of oneof groups are validated along with the
+		// struct declaring the group, since they share its numbering.
+		oneofs := make(map[*ast.StructType]bool)
 		ast.Inspect(file, func(node ast.Node) bool {
 			st, ok := node.(*ast.StructType)
-			if !ok || st.Fields == nil {
+			if !ok || st.Fields == nil || oneofs[st] {
 				return true
 			}
-			// Look through all fields for anonymous/unnamed types.
 			for _, field := range st.Fields.List {
-				if len(field.Names) < 1 {
+				if IsOneof(field) {
+					oneofs[field.Type.(*ast.StructType)] = true
+				}
+			}
+			fields := NumberedFields(st)
+			// Look through all fields for anonymous/unnamed types.
+			// Embedded messages are named after their type.
+			for _, field := range fields {
+				if structFieldName(field) == "" {
 					pkg.addError(ParseError, st.Pos(), l.Fset, "anonymous struct fields are not supported")
 					return false
 				}
@@ -392,13 +572,17 @@ func (l *Loader) validatePackage(pkg *GunkPackage) {
 			// it is a valid integer, and it is unique in that struct.
 			// The other validation should happen in format and generate
 			// as they both treat the same error cases differently.
-			usedSequences := make(map[int]bool, len(st.Fields.List))
+			usedSequences := make(map[int]bool, len(fields))
 			jsonNamesSeen := map[string]bool{}
-			for _, f := range st.Fields.List {
+			for _, f := range fields {
 				if f.Tag == nil {
 					continue
 				}
-				fieldName := f.Names[0].Name
+				fieldName := structFieldName(f)
+				if IsOneof(f) {
+					pkg.addError(ValidateError, st.Pos(), l.Fset, "oneof %s cannot be nested in another oneof", fieldName)
+					continue
+				}
 				str, _ := strconv.Unquote(f.Tag.Value)
 				if err := validateStructTag(str); err != nil {
 					pkg.addError(ValidateError, st.Pos(), l.Fset, "error in struct tag on %s: %v", fieldName, err)
@@ -421,11 +605,21 @@ func (l *Loader) validatePackage(pkg *GunkPackage) {
 					jsonNamesSeen[valJson] = true
 				}
 
+				val, opts := SplitPBTag(val)
+				if err := checkPBOptions(opts); err != nil {
+					pkg.addError(ValidateError, st.Pos(), l.Fset, "error in struct tag on %s: %v", fieldName, err)
+					continue
+				}
 				sequence, err := strconv.Atoi(val)
 				if err != nil {
 					pkg.addError(ValidateError, st.Pos(), l.Fset, "unable to convert tag to number on %s: %v", fieldName, err)
 					continue
 				}
+				if hasFlatten(opts) {
+					// The number of a flattened message is
+					// an offset, not a field number.
+					continue
+				}
 				if usedSequences[sequence] {
 					pkg.addError(ValidateError, st.Pos(), l.Fset, "sequence %q on %s has already been used in this struct", val, fieldName)
 					continue
@@ -462,12 +656,41 @@ allComments:
 	return "", nil
 }
 
+// bundledProtos maps the proto files bundled with Gunk to their descriptors
+// in the assets, which are loaded without protoc.
+var bundledProtos = map[string]string{
+	"google/api/annotations.proto":                   "google_api_annotations.fdp",
+	"google/api/resource.proto":                      "google_api_resource.fdp",
+	"google/protobuf/empty.proto":                    "google_protobuf_empty.fdp",
+	"google/protobuf/timestamp.proto":                "google_protobuf_timestamp.fdp",
+	"google/protobuf/duration.proto":                 "google_protobuf_duration.fdp",
+	"google/protobuf/field_mask.proto":               "google_protobuf_field_mask.fdp",
+	"google/protobuf/struct.proto":                   "google_protobuf_struct.fdp",
+	"google/protobuf/wrappers.proto":                 "google_protobuf_wrappers.fdp",
+	"protoc-gen-openapiv2/options/annotations.proto": "protoc-gen-openapiv2_options_annotations.fdp",
+	"buf/validate/validate.proto":                    "buf_validate_validate.fdp",
+	"validate/validate.proto":                        "validate_validate.fdp",
+	"gunk/errors/errors.proto":                       "gunk_errors_errors.fdp",
+	"gunk/lifecycle/lifecycle.proto":                 "gunk_lifecycle_lifecycle.fdp",
+	"gunk/method/method.proto":                       "gunk_method_method.fdp",
+}
+
+// IsBundled reports whether the proto file name is bundled with Gunk, so that
+// loading it doesn't need protoc.
+func IsBundled(name string) bool {
+	_, ok := bundledProtos[name]
+	return ok
+}
+
 type ProtoLoader struct {
 	// Dir is the absolute path from where the LoadProto method
 	// will load proto files.
 	// If empty, it will load from executing directory
 	Dir        string
 	ProtocPath string
+	// Includes are more directories to load proto files from, such as
+	// those of the dependencies vendored with `gunk vendor`.
+	Includes []string
 }
 
 // LoadProto loads the specified protobuf packages as if they were dependencies.
@@ -476,6 +699,12 @@ type ProtoLoader struct {
 // files, and the protoc parser to get a FileDescriptorProto out of the proto
 // file content.
 func (l *ProtoLoader) LoadProto(names ...string) ([]*descriptorpb.FileDescriptorProto, error) {
+	return l.LoadProtoContext(context.Background(), names...)
+}
+
+// LoadProtoContext is like LoadProto, but protoc is killed if ctx is done
+// before it exits.
+func (l *ProtoLoader) LoadProtoContext(ctx context.Context, names ...string) ([]*descriptorpb.FileDescriptorProto, error) {
 	tmpl := template.Must(template.New("letter").Parse(`
 syntax = "proto3";
 {{range $_, $name := .}}import "{{$name}}";
@@ -489,20 +718,11 @@ syntax = "proto3";
 	// bundled with Gunk. If so, load the generated libraries. If not, use
 	// protoc to load those libraries from disk.
 	for _, n := range names {
-		switch n {
-		case "google/api/annotations.proto":
-			generatedFilesToLoad = append(generatedFilesToLoad, "google_api_annotations.fdp")
-		case "google/protobuf/empty.proto":
-			generatedFilesToLoad = append(generatedFilesToLoad, "google_protobuf_empty.fdp")
-		case "google/protobuf/timestamp.proto":
-			generatedFilesToLoad = append(generatedFilesToLoad, "google_protobuf_timestamp.fdp")
-		case "google/protobuf/duration.proto":
-			generatedFilesToLoad = append(generatedFilesToLoad, "google_protobuf_duration.fdp")
-		case "protoc-gen-openapiv2/options/annotations.proto":
-			generatedFilesToLoad = append(generatedFilesToLoad, "protoc-gen-openapiv2_options_annotations.fdp")
-		default:
-			filteredNames = append(filteredNames, n)
+		if fdp, ok := bundledProtos[n]; ok {
+			generatedFilesToLoad = append(generatedFilesToLoad, fdp)
+			continue
 		}
+		filteredNames = append(filteredNames, n)
 	}
 	var combinedFset descriptorpb.FileDescriptorSet
 	// Use protoc to load any imports that aren't currently bundles with
@@ -516,13 +736,14 @@ syntax = "proto3";
 		if err != nil {
 			return nil, err
 		}
+		cleanup := interrupt.Register(func() { os.Remove(gunkProtoFile) })
+		defer cleanup.Run()
 		if err := tmpl.Execute(importsFile, filteredNames); err != nil {
 			return nil, err
 		}
 		if err := importsFile.Close(); err != nil {
 			return nil, err
 		}
-		defer os.Remove(gunkProtoFile)
 		// TODO(mvdan): any way to specify stdout while being portable?
 		// See https://github.com/protocolbuffers/protobuf/issues/4163.
 		args := []string{
@@ -532,14 +753,23 @@ syntax = "proto3";
 		}
 		if l.Dir != "" {
 			args = append(args, "-I"+l.Dir)
+		} else if len(l.Includes) > 0 {
+			// protoc only defaults to -I. without any -I flags.
+			args = append(args, "-I.")
+		}
+		for _, dir := range l.Includes {
+			args = append(args, "-I"+dir)
 		}
 		protocPath := "protoc"
 		if l.ProtocPath != "" {
 			protocPath = l.ProtocPath
 		}
-		cmd := log.ExecCommand(protocPath, args...)
+		cmd := log.ExecCommandContext(ctx, protocPath, args...)
 		out, err := cmd.Output()
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			if e, ok := err.(*exec.ExitError); ok {
 				return nil, fmt.Errorf("protoc %s: %s", e, e.Stderr)
 			}
@@ -705,6 +935,13 @@ func SplitGunkTag(pkg *GunkPackage, fset *token.FileSet, comment *ast.CommentGro
 				return "", nil, err
 			}
 			tag.Type, tag.Value = tv.Type, tv.Value
+			// Record the types and values of the parts of the
+			// tag too, such as the fields of a composite literal.
+			if pkg.TypesInfo != nil {
+				if err := types.CheckExpr(fset, pkg.Types, comment.Pos(), expr, pkg.TypesInfo); err != nil {
+					return "", nil, err
+				}
+			}
 		}
 		tags = append(tags, tag)
 	}
@@ -0,0 +1,97 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"go/ast"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestPackageCheck(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod": "module testdata.tld/util\n",
+		"util.gunk": `package util
+
+import "testdata.tld/util/dep"
+
+type Message struct {
+	Dep dep.Dep ` + "`pb:\"1\"`" + `
+}
+`,
+		"dep/dep.gunk": `package dep
+
+// +gunk true
+type Dep struct {
+	Name string ` + "`pb:\"1\"`" + `
+}
+`,
+	})
+	l := &Loader{Dir: dir, Fset: token.NewFileSet()}
+	ctx := context.Background()
+	pkg, err := l.Package(ctx, "testdata.tld/util")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkg.Errors) > 0 {
+		t.Fatal(pkg.Errors)
+	}
+	if pkg.Types != nil {
+		t.Fatal("package was type-checked without Types")
+	}
+	again, err := l.Package(ctx, "testdata.tld/util")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != pkg {
+		t.Fatal("package was loaded twice")
+	}
+	if err := l.Check(ctx, pkg); err != nil {
+		t.Fatal(err)
+	}
+	if len(pkg.Errors) > 0 {
+		t.Fatal(pkg.Errors)
+	}
+	if pkg.Types == nil {
+		t.Fatal("package wasn't type-checked")
+	}
+	dep := pkg.Imports["testdata.tld/util/dep"]
+	if dep == nil {
+		t.Fatal("import wasn't loaded")
+	}
+	tspec := dep.GunkSyntax[0].Decls[0].(*ast.GenDecl).Specs[0]
+	if tags := dep.Tags(tspec); len(tags) != 1 {
+		t.Fatalf("want 1 tag on Dep, got %d", len(tags))
+	}
+}
+
+func TestLoadCanceled(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"go.mod":    "module testdata.tld/util\n",
+		"util.gunk": "package util\n",
+	})
+	l := &Loader{Dir: dir, Fset: token.NewFileSet(), Types: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.LoadContext(ctx, "."); !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package loader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gunk/gunk/log"
+	"github.com/gunk/gunk/workspace"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/tools/go/packages"
+)
+
+// module is the main module, a module it requires, or another module of its
+// workspace.
+type module struct {
+	Path    string
+	Version string
+	Main    bool
+	Dir     string // empty if the module isn't downloaded yet
+	Replace *module
+	// Workspace is set for the modules of the workspace of the main
+	// module, whose Gunk packages are always loaded from their Dir.
+	Workspace bool
+}
+
+// modules returns the main module of l.Dir, the other modules of its
+// workspace, if any, and the modules required by its go.mod, with their
+// replacements. They are read once per main module. Outside of a module, the
+// list is empty.
+//
+// go.mod is read directly rather than via `go list -m all`, which would
+// query the version of every module of the build list.
+func (l *Loader) modules() []*module {
+	dir, err := filepath.Abs(l.Dir)
+	if err != nil {
+		return nil
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+	if mods, ok := l.buildLists[dir]; ok {
+		return mods
+	}
+	if l.buildLists == nil {
+		l.buildLists = make(map[string][]*module)
+	}
+	f, err := readModFile(dir)
+	if err != nil {
+		l.buildLists[dir] = nil
+		return nil
+	}
+	mods := []*module{{Path: f.Module.Mod.Path, Main: true, Dir: dir}}
+	// As with go.work files, the modules of the workspace take
+	// precedence over the required versions.
+	if ws, err := workspace.Find(dir); err == nil && ws != nil && ws.Contains(dir) {
+		for _, use := range ws.Use {
+			if use == dir {
+				continue
+			}
+			if wf, err := readModFile(use); err == nil {
+				mods = append(mods, &module{Path: wf.Module.Mod.Path, Dir: use, Workspace: true})
+			}
+		}
+	}
+	for _, req := range f.Require {
+		mod := &module{Path: req.Mod.Path, Version: req.Mod.Version}
+		for _, r := range f.Replace {
+			if r.Old.Path != mod.Path || (r.Old.Version != "" && r.Old.Version != mod.Version) {
+				continue
+			}
+			if r.New.Version == "" {
+				// Replaced with a directory.
+				mod.Dir = r.New.Path
+				if !filepath.IsAbs(mod.Dir) {
+					mod.Dir = filepath.Join(dir, mod.Dir)
+				}
+			} else {
+				mod.Replace = &module{Path: r.New.Path, Version: r.New.Version}
+			}
+		}
+		mods = append(mods, mod)
+	}
+	l.buildLists[dir] = mods
+	return mods
+}
+
+// readModFile parses the go.mod file of the module in dir.
+func readModFile(dir string) (*modfile.File, error) {
+	gomod := filepath.Join(dir, "go.mod")
+	data, err := ioutil.ReadFile(gomod)
+	if err != nil {
+		return nil, err
+	}
+	f, err := modfile.Parse(gomod, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	if f.Module == nil {
+		return nil, fmt.Errorf("%s: no module directive", gomod)
+	}
+	return f, nil
+}
+
+// dependencyPackage returns the Gunk package with import path pkgPath from a
+// dependency of the main module, such as a module in the module cache at the
+// version required by go.mod, or the directory it is replaced with.
+//
+// Such packages are resolved here, rather than by go/packages, as Gunk
+// packages without Go files are invisible to the go tool, and Go files cannot
+// be added to the read-only module cache. It returns nil if pkgPath isn't a
+// package of a dependency, or if it has Go files, leaving it to go/packages.
+// The packages of the other modules of a workspace are always resolved here,
+// as the main module may not require them.
+func (l *Loader) dependencyPackage(pkgPath string) (*GunkPackage, error) {
+	if pkgPath == "" || strings.HasPrefix(pkgPath, ".") || filepath.IsAbs(pkgPath) || strings.Contains(pkgPath, "...") {
+		return nil, nil
+	}
+	// The module providing the package is the one with the longest
+	// path prefix, as with the go tool.
+	var mod *module
+	for _, m := range l.modules() {
+		if pkgPath != m.Path && !strings.HasPrefix(pkgPath, m.Path+"/") {
+			continue
+		}
+		if mod == nil || len(m.Path) > len(mod.Path) {
+			mod = m
+		}
+	}
+	if mod == nil || mod.Main {
+		return nil, nil
+	}
+	modDir, err := l.moduleDir(mod)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(modDir, filepath.FromSlash(strings.TrimPrefix(pkgPath, mod.Path)))
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil
+	}
+	var gunkFiles []string
+	for _, info := range infos {
+		switch name := info.Name(); {
+		case strings.HasSuffix(name, ".go") && !mod.Workspace:
+			return nil, nil
+		case strings.HasSuffix(name, ".gunk"):
+			gunkFiles = append(gunkFiles, filepath.Join(dir, name))
+		}
+	}
+	if len(gunkFiles) == 0 {
+		return nil, nil
+	}
+	return &GunkPackage{
+		Package: packages.Package{
+			ID:      pkgPath,
+			PkgPath: pkgPath,
+		},
+		Dir:       dir,
+		GunkFiles: gunkFiles,
+	}, nil
+}
+
+// moduleDir returns the directory of mod, downloading it to the module cache
+// if needed.
+func (l *Loader) moduleDir(mod *module) (string, error) {
+	if mod.Dir != "" {
+		return mod.Dir, nil
+	}
+	path, version := mod.Path, mod.Version
+	if r := mod.Replace; r != nil {
+		path, version = r.Path, r.Version
+	}
+	cmd := exec.CommandContext(l.context(), "go", "mod", "download", "-json", path+"@"+version)
+	cmd.Dir = l.Dir
+	out, err := cmd.Output()
+	var info struct {
+		Dir   string
+		Error string
+	}
+	if jerr := json.Unmarshal(out, &info); jerr == nil && info.Error != "" {
+		return "", fmt.Errorf("unable to download %s@%s: %s", path, version, info.Error)
+	}
+	if err != nil {
+		return "", log.ExecError("go mod download", err)
+	}
+	mod.Dir = info.Dir
+	return mod.Dir, nil
+}
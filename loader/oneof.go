@@ -0,0 +1,50 @@
+package loader
+
+import (
+	"go/ast"
+	"reflect"
+	"strconv"
+)
+
+// OneofTag is the value of the pb struct tag which marks a field as a oneof
+// group. The field's type must be an anonymous struct, whose fields are the
+// members of the oneof:
+//
+//	type Shape struct {
+//		Name string `pb:"1"`
+//		Kind struct {
+//			Circle Circle `pb:"2"`
+//			Square Square `pb:"3"`
+//		} `pb:"oneof"`
+//	}
+const OneofTag = "oneof"
+
+// IsOneof reports whether field declares a oneof group.
+func IsOneof(field *ast.Field) bool {
+	if _, ok := field.Type.(*ast.StructType); !ok || field.Tag == nil {
+		return false
+	}
+	str, _ := strconv.Unquote(field.Tag.Value)
+	return reflect.StructTag(str).Get("pb") == OneofTag
+}
+
+// NumberedFields returns the fields of st which take a field number, in
+// declaration order. The members of oneof groups are included in place of the
+// group itself, as they share the numbering of the enclosing message. Oneof
+// groups cannot be nested, so members are not expanded any further.
+func NumberedFields(st *ast.StructType) []*ast.Field {
+	if st.Fields == nil {
+		return nil
+	}
+	var fields []*ast.Field
+	for _, f := range st.Fields.List {
+		if IsOneof(f) {
+			if group := f.Type.(*ast.StructType); group.Fields != nil {
+				fields = append(fields, group.Fields.List...)
+			}
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
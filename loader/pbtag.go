@@ -0,0 +1,64 @@
+package loader
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValueOption is an option of the pb struct tag, following the field number,
+// which maps an interface{} field to google.protobuf.Value, holding any JSON
+// value:
+//
+//	type Event struct {
+//		Payload interface{} `pb:"1,value"`
+//	}
+//
+// The option is required so that interface{} isn't used by mistake.
+const ValueOption = "value"
+
+// FieldMaskOption is an option of the pb struct tag which maps a []string
+// field, holding field paths, to google.protobuf.FieldMask, as used by update
+// methods:
+//
+//	type UpdateBookRequest struct {
+//		Book       Book     `pb:"1"`
+//		UpdateMask []string `pb:"2,fieldmask"`
+//	}
+const FieldMaskOption = "fieldmask"
+
+// FlattenOption is an option of the pb struct tag of an embedded message,
+// which inlines the fields of the embedded message into the enclosing one
+// instead of adding a field holding it. The field number of the embedded
+// message is the offset added to the numbers of its fields, so that the same
+// message can be flattened into messages numbering their own fields
+// differently:
+//
+//	type Base struct {
+//		ID string `pb:"1"`
+//	}
+//
+//	type User struct {
+//		Base  `pb:"100,flatten"` // ID is field 101.
+//		Email string `pb:"1"`
+//	}
+//
+// Without the option, an embedded message is a regular message field named
+// after its type.
+const FlattenOption = "flatten"
+
+// SplitPBTag splits the value of a pb struct tag into the field number and
+// its options, such as "1,value".
+func SplitPBTag(val string) (number string, opts []string) {
+	parts := strings.Split(val, ",")
+	return parts[0], parts[1:]
+}
+
+// checkPBOptions returns an error if opts contains an unknown option.
+func checkPBOptions(opts []string) error {
+	for _, opt := range opts {
+		if opt != ValueOption && opt != FieldMaskOption && opt != FlattenOption {
+			return fmt.Errorf("unknown pb tag option %q", opt)
+		}
+	}
+	return nil
+}
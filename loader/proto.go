@@ -15,6 +15,7 @@ import (
 	"github.com/gunk/gunk/reflectutil"
 	"github.com/gunk/opt/openapiv2"
 	"github.com/kenshaw/snaker"
+	"google.golang.org/protobuf/types/descriptorpb"
 )
 
 var urlVarRegexp = regexp.MustCompile(`\{(.*?)\}`)
@@ -23,24 +24,45 @@ var urlVarRegexp = regexp.MustCompile(`\{(.*?)\}`)
 // generated Gunk file to w. The output isn't canonically formatted, so it's up
 // to the caller to use gunk/format.Source on the result if needed.
 func ConvertFromProto(w io.Writer, r io.Reader, filename string, importPath string, protocPath string) error {
-	// Parse the proto file.
-	parser := proto.NewParser(r)
-	d, err := parser.Parse()
-	if err != nil {
-		return fmt.Errorf("unable to parse proto file %q: %v", filename, err)
-	}
-	// Start converting the proto declarations to gunk.
-	b := builder{
-		filename:      filename,
-		importsUsed:   map[string]string{},
-		existingDecls: map[string]bool{},
-	}
+	b := newBuilder(filename)
 	if importPath != "" {
 		b.protoLoader = &ProtoLoader{
 			Dir:        importPath,
 			ProtocPath: protocPath,
 		}
 	}
+	return b.convert(w, r)
+}
+
+// ConvertFromProtoFiles is like ConvertFromProto, but the files imported by
+// the proto file are looked up in files, by name, rather than loaded with
+// protoc, and the Gunk package is named pkgName. Proto package names which
+// aren't valid Gunk package names, such as "acme.pets.v1", are kept in a
+// proto package comment.
+func ConvertFromProtoFiles(w io.Writer, r io.Reader, filename, pkgName string, files map[string]*descriptorpb.FileDescriptorProto) error {
+	b := newBuilder(filename)
+	b.importFiles = files
+	b.pkgName = pkgName
+	return b.convert(w, r)
+}
+
+func newBuilder(filename string) *builder {
+	return &builder{
+		filename:      filename,
+		importsUsed:   map[string]string{},
+		importedPkgs:  map[string]string{},
+		existingDecls: map[string]bool{},
+	}
+}
+
+func (b *builder) convert(w io.Writer, r io.Reader) error {
+	// Parse the proto file.
+	parser := proto.NewParser(r)
+	d, err := parser.Parse()
+	if err != nil {
+		return fmt.Errorf("unable to parse proto file %q: %v", b.filename, err)
+	}
+	// Start converting the proto declarations to gunk.
 	for _, e := range d.Elements {
 		if err := b.handleProtoType(e); err != nil {
 			return err
@@ -48,8 +70,10 @@ func ConvertFromProto(w io.Writer, r io.Reader, filename string, importPath stri
 	}
 	// Validate that the package name is a a valid
 	// Go package name.
-	if err := b.validatePackageName(); err != nil {
-		return err
+	if b.pkgName == "" {
+		if err := b.validatePackageName(); err != nil {
+			return err
+		}
 	}
 	// Convert the proto package and imports to gunk.
 	translatedPkg, err := b.handlePackage()
@@ -97,6 +121,13 @@ type builder struct {
 	// imported proto files will be loaded using protoLoader
 	// holds the absolute path passed to -I flag from protoc
 	protoLoader *ProtoLoader
+	// Or, if set, they are looked up in importFiles by name, see
+	// ConvertFromProtoFiles.
+	importFiles map[string]*descriptorpb.FileDescriptorProto
+	// The imported proto packages, mapped to their import names.
+	importedPkgs map[string]string
+	// The name of the Gunk package, if it isn't the proto package name.
+	pkgName string
 	// Holds existings declaration to avoid duplicate
 	existingDecls map[string]bool
 }
@@ -181,15 +212,21 @@ func (b *builder) handleProtoType(typ proto.Visitee) error {
 		// a Gunk package decleration.
 		b.pkg = typ
 	case *proto.Import:
-		if b.protoLoader != nil {
-			files, err := b.protoLoader.LoadProto(typ.Filename)
+		if (b.protoLoader != nil || b.importFiles != nil) && convertedImports[typ.Filename] {
+			// The types and options they declare are converted
+			// to those of Gunk.
+			break
+		}
+		if b.protoLoader != nil || b.importFiles != nil {
+			files, err := b.loadImport(typ.Filename)
 			if err != nil {
 				return err
 			}
-			named, source := "", ""
+			named, source, pkg := "", "", ""
 			for _, f := range files {
 				if f != nil && f.GetName() == typ.Filename {
-					named = strings.Replace(f.GetPackage(), ".", "_", -1)
+					pkg = f.GetPackage()
+					named = strings.Replace(pkg, ".", "_", -1)
 					if f.GetOptions() != nil && f.GetOptions().GoPackage != nil {
 						source = *f.GetOptions().GoPackage
 					}
@@ -203,6 +240,7 @@ func (b *builder) handleProtoType(typ proto.Visitee) error {
 			}
 			// Import the go package
 			b.importsUsed[source] = named
+			b.importedPkgs[pkg] = named
 		} else {
 			// All imports need to be grouped and written out together. This
 			// happens at the end.
@@ -222,8 +260,30 @@ func (b *builder) handleProtoType(typ proto.Visitee) error {
 	return err
 }
 
+// formatTags will write the comment of a declaration followed by its Gunk
+// tags, separating them with an empty comment line. The lines following the
+// first one of a tag, such as the fields of a composite literal, must already
+// be commented.
+func (b *builder) formatTags(w *strings.Builder, indent int, comment *proto.Comment, tags []string) {
+	if len(tags) == 0 {
+		b.format(w, indent, comment, "")
+		return
+	}
+	if comment != nil {
+		b.format(w, indent, comment, "//\n")
+	}
+	for _, tag := range tags {
+		for i, line := range strings.Split(strings.TrimSuffix(tag, "\n"), "\n") {
+			if i == 0 {
+				line = "// +gunk " + line
+			}
+			b.format(w, indent, nil, "%s\n", line)
+		}
+	}
+}
+
 // handleMessageField will convert a messages field to gunk.
-func (b *builder) handleMessageField(w *strings.Builder, field proto.Visitee) error {
+func (b *builder) handleMessageField(w *strings.Builder, indent int, field proto.Visitee) error {
 	var (
 		name     string
 		typ      string
@@ -240,6 +300,12 @@ func (b *builder) handleMessageField(w *strings.Builder, field proto.Visitee) er
 		comment = field.Comment
 		repeated = field.Repeated
 		options = field.Options
+	case *proto.OneOfField:
+		name = field.Name
+		typ = b.goType(field.Type)
+		sequence = field.Sequence
+		comment = field.Comment
+		options = field.Options
 	case *proto.MapField:
 		name = field.Field.Name
 		sequence = field.Field.Sequence
@@ -251,46 +317,89 @@ func (b *builder) handleMessageField(w *strings.Builder, field proto.Visitee) er
 	default:
 		return fmt.Errorf("unhandled message field type %T", field)
 	}
-	if comment != nil && strings.HasPrefix(strings.TrimSpace(comment.Message()), name) {
-		comment.Lines[0] = strings.Replace(comment.Message(), name, snaker.ForceCamelIdentifier(name), 1)
-	}
+	camelComment(comment, name)
 	if repeated {
 		typ = "[]" + typ
 	}
+	jsonName := snaker.CamelToSnake(name)
+	var tags []string
 	for _, o := range options {
-		val := o.Constant.Source
-		var impt string
-		var value string
-		switch n := o.Name; n {
-		case "packed":
-			impt = "github.com/gunk/opt/message"
-			value = b.genAnnotation("Packed", val)
-		case "lazy":
-			impt = "github.com/gunk/opt/message"
-			value = b.genAnnotation("Lazy", val)
-		case "deprecated":
-			impt = "github.com/gunk/opt/message"
-			value = b.genAnnotation("Deprecated", val)
-		case "cc_type":
-			impt = "github.com/gunk/opt/message/cc"
-			value = b.genAnnotationString("Type", val)
-		case "js_type":
-			impt = "github.com/gunk/opt/message/js"
-			value = b.genAnnotationString("Type", val)
-		}
-		pkg := b.addImportUsed(impt)
-		b.format(w, 1, nil, fmt.Sprintf("// +gunk %s.%s\n", pkg, value))
+		if tag, ok := b.optionTag(fieldOptionTags, o); ok {
+			tags = append(tags, tag)
+			continue
+		}
+		switch n := o.Name; {
+		case n == "json_name":
+			jsonName = o.Constant.Source
+		case openapiv2Option(n) == "openapiv2_field":
+			schema := &openapiv2.JSONSchema{}
+			reflectutil.UnmarshalProto(schema, &o.Constant)
+			pkg := b.addImportUsed("github.com/gunk/opt/openapiv2")
+			tags = append(tags, fmt.Sprintf("%s.Schema{\n// JSONSchema: %s.JSONSchema{\n%s// },\n// }", pkg, pkg, b.fromStructToAnnotation(schema)))
+		default:
+			fmt.Fprintln(os.Stderr, b.formatError(o.Position, "unhandled field option %q", n))
+		}
 	}
+	b.formatTags(w, indent, comment, tags)
 	// TODO(vishen): Is this correct to explicitly camelcase the variable name and
 	// snakecase the json name???
 	// If we do, gunk should probably have an option to set the variable name
 	// in the proto to something else? That way we can use best practises for
 	// each language???
-	b.format(w, 1, comment, "%s %s", snaker.ForceCamelIdentifier(name), typ)
-	b.format(w, 0, nil, " `pb:\"%d\" json:\"%s\"`\n", sequence, snaker.CamelToSnake(name))
+	b.format(w, indent, nil, "%s %s", snaker.ForceCamelIdentifier(name), typ)
+	b.format(w, 0, nil, " `pb:\"%d\" json:\"%s\"`\n", sequence, jsonName)
 	return nil
 }
 
+// camelComment will rename the field name starting the comment to its Gunk
+// form, as Go doc comments start with the name of what they document.
+func camelComment(comment *proto.Comment, name string) {
+	if comment != nil && strings.HasPrefix(strings.TrimSpace(comment.Message()), name) {
+		comment.Lines[0] = strings.Replace(comment.Message(), name, snaker.ForceCamelIdentifier(name), 1)
+	}
+}
+
+// fieldType will return the type of a field of the message m, renaming the
+// references to nested messages and enums, which are declared at the top
+// level in the form Parent_Child.
+func (b *builder) fieldType(m *proto.Message, typ string, pos scanner.Position) (string, error) {
+	// Check if the type must be renamed in case
+	// of declaration of nested message or enum
+	newType := fmt.Sprintf("%s_%s", m.Name, typ)
+	if b.existingDecls[newType] {
+		return newType, nil
+	}
+	for _, e := range m.Elements {
+		switch e := e.(type) {
+		case *proto.Message:
+			if e.Name == typ || e.Name == newType {
+				return newType, nil
+			}
+		case *proto.Enum:
+			if e.Name == typ || e.Name == newType {
+				return newType, nil
+			}
+		}
+	}
+	if t, ok := b.importedType(typ); ok {
+		return t, nil
+	}
+	if strings.Contains(typ, ".") {
+		ref := strings.Split(typ, ".")[0]
+		if !b.containsImport(ref) {
+			tmp := strings.Replace(typ, ".", "_", -1)
+			// the type is neither found in import and existing decls
+			if _, ok := b.existingDecls[tmp]; !ok {
+				return "", b.formatError(pos, "%s is undefined", typ)
+			}
+			// Handle the use of nested field referenced outside
+			// of its parent; Parent.Type is renamed to Parent_Type in a Go-Derived way
+			return tmp, nil
+		}
+	}
+	return typ, nil
+}
+
 func (b *builder) containsImport(ref string) bool {
 	for _, v := range b.importsUsed {
 		if v == ref {
@@ -308,47 +417,49 @@ func (b *builder) handleMessage(m *proto.Message) error {
 		return b.formatError(m.Position, "%s redeclared in this block", m.Name)
 	}
 	b.existingDecls[m.Name] = true
-	b.format(w, 0, m.Comment, "type %s struct {\n", m.Name)
+	// The message options are written as Gunk tags above the struct.
+	var tags []string
+	for _, e := range m.Elements {
+		if o, ok := e.(*proto.Option); ok {
+			if tag, ok := b.messageOptionTag(o); ok {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	b.formatTags(w, 0, m.Comment, tags)
+	b.format(w, 0, nil, "type %s struct {\n", m.Name)
 	for _, e := range m.Elements {
 		switch e := e.(type) {
 		case *proto.NormalField:
-			// Check if the type must be renamed in case
-			// of declaration of nested message
-			newType := fmt.Sprintf("%s_%s", m.Name, e.Type)
-			if _, ok := b.existingDecls[newType]; ok {
-				e.Type = newType
-			}
-			if strings.Contains(e.Type, ".") {
-				ref := strings.Split(e.Type, ".")[0]
-				if !b.containsImport(ref) {
-					tmp := strings.Replace(e.Type, ".", "_", -1)
-					// the type is neither found in import and existing decls
-					if _, ok := b.existingDecls[tmp]; !ok {
-						return b.formatError(e.Position, "%s is undefined", e.Type)
-					}
-					// Handle the use of nested field referenced outside
-					// of its parent; Parent.Type is renamed to Parent_Type in a Go-Derived way
-					e.Type = tmp
-				}
+			typ, err := b.fieldType(m, e.Type, e.Position)
+			if err != nil {
+				return err
 			}
-			if err := b.handleMessageField(w, e); err != nil {
+			e.Type = typ
+			if err := b.handleMessageField(w, 1, e); err != nil {
 				return b.formatError(e.Position, "error with message field: %v", err)
 			}
+		case *proto.Oneof:
+			if err := b.handleOneof(w, m, e); err != nil {
+				return b.formatError(e.Position, "error with oneof: %v", err)
+			}
 		case *proto.Enum:
-			// Handle the nested enum. This will create a new
-			// top level enum as Gunk doesn't currently support
-			// nested data structures.
-			b.handleEnum(e)
+			// Handle the nested enum. The enum is created at
+			// the top level and renamed in the form Parent_Child,
+			// as Gunk doesn't currently support nested data
+			// structures.
+			e.Name = fmt.Sprintf("%s_%s", m.Name, e.Name)
+			if err := b.handleEnum(e); err != nil {
+				return err
+			}
 		case *proto.Comment:
 			b.format(w, 1, e, "")
 		case *proto.MapField:
-			if err := b.handleMessageField(w, e); err != nil {
+			if err := b.handleMessageField(w, 1, e); err != nil {
 				return b.formatError(e.Position, "error with message field: %v", err)
 			}
 		case *proto.Option:
-			if err := b.handleOption(w, e); err != nil {
-				return b.formatError(e.Position, "error with option field: %v", err)
-			}
+			// Already written above the struct.
 		case *proto.Message:
 			// Handle the nested message. The struct is created at
 			// the top level and renamed in the form Parent_Child
@@ -365,24 +476,50 @@ func (b *builder) handleMessage(m *proto.Message) error {
 	return nil
 }
 
-func (b *builder) handleOption(w *strings.Builder, opt *proto.Option) error {
-	switch n := opt.Name; n {
-	case "(grpc.gateway.protoc_gen_swagger.options.openapiv2_schema)":
+// handleOneof will convert a oneof of the message m to a field whose type is
+// an anonymous struct, holding the members of the oneof, tagged with
+// `pb:"oneof"`.
+func (b *builder) handleOneof(w *strings.Builder, m *proto.Message, o *proto.Oneof) error {
+	camelComment(o.Comment, o.Name)
+	b.format(w, 1, o.Comment, "%s struct {\n", snaker.ForceCamelIdentifier(o.Name))
+	for _, e := range o.Elements {
+		switch e := e.(type) {
+		case *proto.OneOfField:
+			typ, err := b.fieldType(m, e.Type, e.Position)
+			if err != nil {
+				return err
+			}
+			e.Type = typ
+			if err := b.handleMessageField(w, 2, e); err != nil {
+				return b.formatError(e.Position, "error with oneof field: %v", err)
+			}
+		case *proto.Comment:
+			b.format(w, 2, e, "")
+		case *proto.Option:
+			fmt.Fprintln(os.Stderr, b.formatError(e.Position, "unhandled oneof option %q", e.Name))
+		default:
+			return b.formatError(o.Position, "unexpected type %T in oneof", e)
+		}
+	}
+	b.format(w, 1, nil, "} `pb:\"%s\"`\n", OneofTag)
+	return nil
+}
+
+// messageOptionTag will return the Gunk tag of a message option. ok is false
+// if the option is unhandled.
+func (b *builder) messageOptionTag(opt *proto.Option) (tag string, ok bool) {
+	if tag, ok := b.optionTag(messageOptionTags, opt); ok {
+		return tag, true
+	}
+	switch openapiv2Option(opt.Name) {
+	case "openapiv2_schema":
 		schema := &openapiv2.Schema{}
 		reflectutil.UnmarshalProto(schema, &opt.Constant)
 		pkg := b.addImportUsed("github.com/gunk/opt/openapiv2")
-		b.format(w, 1, nil, "// +gunk %s.Schema{\n", pkg)
-		if schema.JSONSchema != nil {
-			b.format(w, 1, nil, "// JSONSchema: %s.JSONSchema{Title:%s, Description:%s}, \n", pkg, schema.JSONSchema.Title, schema.JSONSchema.Description)
-		}
-		if schema.Example != "" {
-			b.format(w, 1, nil, "// Example: %q, \n", schema.Example)
-		}
-		b.format(w, 1, nil, "// }\n")
-	default:
-		fmt.Fprintln(os.Stderr, fmt.Errorf("unhandled message option %q", opt.Name))
+		return fmt.Sprintf("%s.Schema{\n%s// }", pkg, b.fromStructToAnnotation(schema)), true
 	}
-	return nil
+	fmt.Fprintln(os.Stderr, b.formatError(opt.Position, "unhandled message option %q", opt.Name))
+	return "", false
 }
 
 // handleEnum will output a proto enum as a Go const. It will output
@@ -391,31 +528,39 @@ func (b *builder) handleOption(w *strings.Builder, opt *proto.Option) error {
 // conversion.
 func (b *builder) handleEnum(e *proto.Enum) error {
 	w := &strings.Builder{}
-	b.format(w, 0, e.Comment, "type %s int\n", e.Name)
-	b.format(w, 0, nil, "\nconst (\n")
 	// Check to see if we can output the enum using an iota. This is
 	// currently only possible if every enum value is an increment of 1
 	// from the previous enum value.
 	outputIota := true
-	for i, c := range e.Elements {
+	values := 0
+	var tags []string
+	for _, c := range e.Elements {
 		switch c := c.(type) {
 		case *proto.EnumField:
-			if i != c.Integer {
+			if values != c.Integer {
 				outputIota = false
 			}
+			values++
 		case *proto.Option:
+			if tag, ok := b.optionTag(enumOptionTags, c); ok {
+				tags = append(tags, tag)
+				continue
+			}
 			fmt.Fprintln(os.Stderr, b.formatError(c.Position, "unhandled enum option %q", c.Name))
 		default:
 			return b.formatError(e.Position, "unexpected type %T in enum, expected enum field", c)
 		}
 	}
+	b.existingDecls[e.Name] = true
+	b.formatTags(w, 0, e.Comment, tags)
+	b.format(w, 0, nil, "type %s int\n", e.Name)
+	b.format(w, 0, nil, "\nconst (\n")
 	// Now we can output the enum as a const.
-	for i, c := range e.Elements {
+	first := true
+	for _, c := range e.Elements {
 		ef, ok := c.(*proto.EnumField)
 		if !ok {
-			// We should have caught any errors when checking if we can output as
-			// iota (above).
-			// TODO(vishen): handle enum option
+			// The options were already written above the type.
 			continue
 		}
 		// Check if there is already an existing enum field with this name
@@ -424,23 +569,31 @@ func (b *builder) handleEnum(e *proto.Enum) error {
 			ef.Name = e.Name + "_" + ef.Name
 		}
 		b.existingDecls[ef.Name] = true
+		var tags []string
 		for _, e := range ef.Elements {
-			if o, ok := e.(*proto.Option); ok && o != nil {
-				fmt.Fprintln(os.Stderr, b.formatError(o.Position, "unhandled enumvalue option %q", o.Name))
+			o, ok := e.(*proto.Option)
+			if !ok || o == nil {
+				continue
 			}
+			if tag, ok := b.optionTag(enumValueOptionTags, o); ok {
+				tags = append(tags, tag)
+				continue
+			}
+			fmt.Fprintln(os.Stderr, b.formatError(o.Position, "unhandled enumvalue option %q", o.Name))
 		}
-		// If we can't output as an iota.
-		if !outputIota {
-			b.format(w, 1, ef.Comment, "%s %s = %d\n", ef.Name, e.Name, ef.Integer)
-			continue
-		}
-		// If we can output as an iota, output the first element as the
-		// iota and output the rest as just the enum field name.
-		if i == 0 {
-			b.format(w, 1, ef.Comment, "%s %s = iota\n", ef.Name, e.Name)
-		} else {
-			b.format(w, 1, ef.Comment, "%s\n", ef.Name)
+		b.formatTags(w, 1, ef.Comment, tags)
+		switch {
+		case !outputIota:
+			// If we can't output as an iota.
+			b.format(w, 1, nil, "%s %s = %d\n", ef.Name, e.Name, ef.Integer)
+		case first:
+			// If we can output as an iota, output the first element as the
+			// iota and output the rest as just the enum field name.
+			b.format(w, 1, nil, "%s %s = iota\n", ef.Name, e.Name)
+		default:
+			b.format(w, 1, nil, "%s\n", ef.Name)
 		}
+		first = false
 	}
 	b.format(w, 0, nil, ")")
 	b.translatedDeclarations = append(b.translatedDeclarations, w.String())
@@ -449,14 +602,26 @@ func (b *builder) handleEnum(e *proto.Enum) error {
 
 func (b *builder) handleService(s *proto.Service) error {
 	w := &strings.Builder{}
-	b.format(w, 0, s.Comment, "type %s interface {\n", s.Name)
-	for i, e := range s.Elements {
+	var tags []string
+	for _, e := range s.Elements {
+		if o, ok := e.(*proto.Option); ok {
+			if tag, ok := b.optionTag(serviceOptionTags, o); ok {
+				tags = append(tags, tag)
+				continue
+			}
+			fmt.Fprintln(os.Stderr, b.formatError(o.Position, "unhandled service option %q", o.Name))
+		}
+	}
+	b.formatTags(w, 0, s.Comment, tags)
+	b.format(w, 0, nil, "type %s interface {\n", s.Name)
+	methods := 0
+	for _, e := range s.Elements {
 		var r *proto.RPC
 		switch e := e.(type) {
 		case *proto.RPC:
 			r = e
 		case *proto.Option:
-			fmt.Fprintln(os.Stderr, b.formatError(e.Position, "unhandled service option %q", e.Name))
+			// Already written above the interface.
 			continue
 		default:
 			return b.formatError(s.Position, "unexpected type %T in service, expected rpc", e)
@@ -465,72 +630,43 @@ func (b *builder) handleService(s *proto.Service) error {
 		// if there is comments or gunk annotations seperating them. We can assume that
 		// anything in `Elements` will be a gunk annotation, otherwise an error is
 		// returned below.
-		if i > 0 && (r.Comment != nil || len(r.Elements) > 0) {
+		if methods > 0 && (r.Comment != nil || len(r.Elements) > 0) {
 			b.format(w, 0, nil, "\n")
 		}
-		// The comment to translate. It is possible that when we write
-		// the gunk annotations out we also write the comment above the
-		// gunk annotation. If that happens we set the comment to nil
-		// so it doesn't get written out when translating the field.
-		comment := r.Comment
+		methods++
+		var tags []string
 		for _, o := range r.Elements {
 			opt, ok := o.(*proto.Option)
 			if !ok {
 				return b.formatError(r.Position, "unexpected type %T in service rpc, expected option", o)
 			}
-			switch n := opt.Name; n {
-			case "(grpc.gateway.protoc_gen_swagger.options.openapiv2_operation)":
+			if tag, ok := b.optionTag(methodOptionTags, opt); ok {
+				tags = append(tags, tag)
+				continue
+			}
+			switch n := opt.Name; {
+			case openapiv2Option(n) == "openapiv2_operation":
 				op := &openapiv2.Operation{}
 				reflectutil.UnmarshalProto(op, &opt.Constant)
 				pkg := b.addImportUsed("github.com/gunk/opt/openapiv2")
-				if comment != nil {
-					b.format(w, 1, comment, "//\n")
-					comment = nil
-				}
-				b.format(w, 1, nil, "// +gunk %s.Operation{\n", pkg)
-				b.format(w, 1, nil, b.fromStructToAnnotation(*op))
-				b.format(w, 1, nil, "// }\n")
-			case "(google.api.http)":
-				method := ""
-				url := ""
-				body := ""
-				literal := opt.Constant
-				for _, l := range literal.OrderedMap {
-					switch n := l.Name; n {
-					case "body":
-						body = l.Literal.Source
-					default:
-						method = n
-						url = l.Literal.Source
-						url = urlVarRegexp.ReplaceAllStringFunc(url, func(id string) string {
-							return "{" + snaker.ForceCamelIdentifier(id) + "}"
-						})
-					}
-				}
-				// Check if we received a valid google http annotation. If
-				// so we will convert it to gunk http match.
-				if method != "" && url != "" {
-					pkg := b.addImportUsed("github.com/gunk/opt/http")
-					if comment != nil {
-						b.format(w, 1, comment, "//\n")
-						comment = nil
-					}
-					b.format(w, 1, nil, "// +gunk %s.Match{\n", pkg)
-					b.format(w, 1, nil, "// Method: %q,\n", strings.ToUpper(method))
-					b.format(w, 1, nil, "// Path: %q,\n", url)
-					if body != "" {
-						b.format(w, 1, nil, "// Body: %q,\n", body)
-					}
-					b.format(w, 1, nil, "// }\n")
-				}
+				tags = append(tags, fmt.Sprintf("%s.Operation{\n%s// }", pkg, b.fromStructToAnnotation(op)))
+			case n == "(google.api.http)":
+				tags = append(tags, b.httpTags(&opt.Constant)...)
 			default:
 				fmt.Fprintln(os.Stderr, b.formatError(opt.Position, "unhandled method option %q", n))
 			}
 		}
+		b.formatTags(w, 1, r.Comment, tags)
 		// If the request type is the known empty parameter we can convert
 		// this to gunk as an empty function parameter.
 		requestType := r.RequestType
 		returnsType := r.ReturnsType
+		if t, ok := b.importedType(requestType); ok {
+			requestType = t
+		}
+		if t, ok := b.importedType(returnsType); ok {
+			returnsType = t
+		}
 		if requestType == "google.protobuf.Empty" {
 			requestType = ""
 		}
@@ -545,99 +681,108 @@ func (b *builder) handleService(s *proto.Service) error {
 		if r.StreamsReturns {
 			returnsType = "chan " + returnsType
 		}
-		b.format(w, 1, comment, "%s(%s) %s\n", r.Name, requestType, returnsType)
+		b.format(w, 1, nil, "%s(%s) %s\n", r.Name, requestType, returnsType)
 	}
 	b.format(w, 0, nil, "}")
 	b.translatedDeclarations = append(b.translatedDeclarations, w.String())
 	return nil
 }
 
-func (b *builder) genAnnotation(name, value string) string {
-	return fmt.Sprintf("%s(%s)", name, value)
-}
-
-func (b *builder) genAnnotationString(name, value string) string {
-	return fmt.Sprintf("%s(%q)", name, value)
+// httpTags will return the http.Match tags of the HTTP rule of a
+// google.api.http option. As in Gunk files, the first one is the primary
+// binding, and the following ones its additional bindings.
+func (b *builder) httpTags(rule *proto.Literal) []string {
+	method := ""
+	url := ""
+	body := ""
+	var additional []string
+	for _, l := range rule.OrderedMap {
+		switch n := l.Name; n {
+		case "body":
+			body = l.Literal.Source
+		case "response_body":
+			// http.Match can't pick the response body yet.
+		case "additional_bindings":
+			additional = append(additional, b.httpTags(l.Literal)...)
+		case "selector":
+			// Only used in service configurations.
+		case "custom":
+			for _, c := range l.Literal.OrderedMap {
+				switch c.Name {
+				case "kind":
+					method = c.Literal.Source
+				case "path":
+					url = c.Literal.Source
+				}
+			}
+		default:
+			method = n
+			url = l.Literal.Source
+		}
+	}
+	url = urlVarRegexp.ReplaceAllStringFunc(url, func(id string) string {
+		return "{" + snaker.ForceCamelIdentifier(id) + "}"
+	})
+	// Check if we received a valid google http annotation. If
+	// so we will convert it to gunk http match.
+	if method == "" || url == "" {
+		return additional
+	}
+	pkg := b.addImportUsed("github.com/gunk/opt/http")
+	match := &strings.Builder{}
+	b.format(match, 0, nil, "%s.Match{\n", pkg)
+	b.format(match, 0, nil, "// Method: %q,\n", strings.ToUpper(method))
+	b.format(match, 0, nil, "// Path: %q,\n", url)
+	if body != "" {
+		b.format(match, 0, nil, "// Body: %q,\n", body)
+	}
+	b.format(match, 0, nil, "// }")
+	return append([]string{match.String()}, additional...)
 }
 
 func (b *builder) handlePackage() (string, error) {
 	w := &strings.Builder{}
 	var opt *proto.Option
-	gunkAnnotations := []string{}
+	var tags []string
 	for _, o := range b.pkgOpts {
-		val := o.Constant.Source
-		var impt string
-		var value string
-		switch n := o.Name; n {
-		case "go_package":
+		if o.Name == "go_package" {
 			opt = o
 			continue
-		case "deprecated":
-			impt = "github.com/gunk/opt/file"
-			value = b.genAnnotation("Deprecated", val)
-		case "optimize_for":
-			impt = "github.com/gunk/opt/file"
-			value = b.genAnnotation("OptimizeFor", val)
-		case "java_package":
-			impt = "github.com/gunk/opt/file/java"
-			value = b.genAnnotationString("Package", val)
-		case "java_outer_classname":
-			impt = "github.com/gunk/opt/file/java"
-			value = b.genAnnotationString("OuterClassname", val)
-		case "java_multiple_files":
-			impt = "github.com/gunk/opt/file/java"
-			value = b.genAnnotation("MultipleFiles", val)
-		case "java_string_check_utf8":
-			impt = "github.com/gunk/opt/file/java"
-			value = b.genAnnotation("StringCheckUtf8", val)
-		case "java_generic_services":
-			impt = "github.com/gunk/opt/file/java"
-			value = b.genAnnotation("GenericServices", val)
-		case "swift_prefix":
-			impt = "github.com/gunk/opt/file/swift"
-		case "csharp_namespace":
-			impt = "github.com/gunk/opt/file/csharp"
-			value = b.genAnnotationString("Namespace", val)
-		case "objc_class_prefix":
-			impt = "github.com/gunk/opt/file/objc"
-			value = b.genAnnotationString("ClassPrefix", val)
-		case "php_generic_services":
-			impt = "github.com/gunk/opt/file/php"
-			value = b.genAnnotation("GenericServices", val)
-		case "cc_generic_services":
-			impt = "github.com/gunk/opt/file/cc"
-			value = b.genAnnotation("GenericServices", val)
-		case "cc_enable_arenas":
-			impt = "github.com/gunk/opt/file/cc"
-			value = b.genAnnotation("EnableArenas", val)
-		case "(grpc.gateway.protoc_gen_swagger.options.openapiv2_swagger)":
-			impt = "github.com/gunk/opt/openapiv2"
+		}
+		if tag, ok := b.optionTag(fileOptionTags, o); ok {
+			tags = append(tags, tag)
+			continue
+		}
+		switch n := o.Name; {
+		case openapiv2Option(n) == "openapiv2_swagger":
 			swagger := &openapiv2.Swagger{}
 			reflectutil.UnmarshalProto(swagger, &o.Constant)
-			res := &strings.Builder{}
-			b.format(res, 0, nil, "Swagger {\n")
-			b.format(res, 0, nil, b.fromStructToAnnotation(*swagger))
-			b.format(res, 0, nil, "// }")
-			value = res.String()
+			pkg := b.addImportUsed("github.com/gunk/opt/openapiv2")
+			tags = append(tags, fmt.Sprintf("%s.Swagger{\n%s// }", pkg, b.fromStructToAnnotation(swagger)))
+		case strings.HasPrefix(n, "("):
+			// Custom options can't be set in Gunk.
+			fmt.Fprintln(os.Stderr, b.formatError(o.Position, "unhandled file option %q", n))
 		default:
 			return "", b.formatError(o.Position, "%q is an unhandled proto file option", n)
 		}
-		pkg := b.addImportUsed(impt)
-		gunkAnnotations = append(gunkAnnotations, fmt.Sprintf("%s.%s", pkg, value))
 	}
 	// Output the gunk annotations above the package comment. This
 	// should be first lines in the file.
-	for _, ga := range gunkAnnotations {
-		b.format(w, 0, nil, fmt.Sprintf("// +gunk %s\n", ga))
-	}
+	b.formatTags(w, 0, nil, tags)
 	p := b.pkg
 	b.format(w, 0, p.Comment, "")
 	if opt != nil {
 		b.format(w, 0, opt.Comment, "")
 	}
-	b.format(w, 0, nil, "package %s", p.Name)
+	name := p.Name
+	if b.pkgName != "" {
+		name = b.pkgName
+	}
+	b.format(w, 0, nil, "package %s", name)
 	if opt != nil && opt.Constant.Source != "" {
 		b.format(w, 0, nil, " // proto %s", opt.Constant.Source)
+	} else if name != p.Name {
+		b.format(w, 0, nil, " // proto %q", p.Name)
 	}
 	return w.String(), nil
 }
@@ -650,9 +795,12 @@ func indirectType(t reflect.Type) reflect.Type {
 	return t.Elem()
 }
 
+// fromStructToAnnotation will return the non-zero fields of the struct val,
+// or of the struct it points to, as the commented lines of a composite
+// literal in a Gunk tag.
 func (b *builder) fromStructToAnnotation(val interface{}) string {
 	w := &strings.Builder{}
-	v := reflect.ValueOf(val)
+	v := reflect.Indirect(reflect.ValueOf(val))
 	t := v.Type()
 	for i := 0; i < t.NumField(); i++ {
 		name := t.Field(i).Name
@@ -753,6 +901,14 @@ func (b *builder) addImportUsed(i string) string {
 	r := strings.NewReplacer("github.com/gunk/opt", "", "/", "")
 	namedImport := r.Replace(i)
 	pkg := filepath.Base(i)
+	// Keep the name the import was first given, as it may have been
+	// used already.
+	if named, ok := b.importsUsed[i]; ok {
+		if named != "" {
+			return named
+		}
+		return pkg
+	}
 	// Determine if there is a package with the same name
 	// as this one, if there is give this current one a
 	// named import with "/" replaced, eg:
@@ -776,6 +932,57 @@ func (b *builder) addImportUsed(i string) string {
 	return pkg
 }
 
+// convertedImports are the proto files whose types and options are converted
+// to those of Gunk, so that they aren't imported when the imports are loaded.
+var convertedImports = map[string]bool{
+	"google/api/annotations.proto":                   true,
+	"google/api/http.proto":                          true,
+	"google/protobuf/descriptor.proto":               true,
+	"google/protobuf/duration.proto":                 true,
+	"google/protobuf/empty.proto":                    true,
+	"google/protobuf/timestamp.proto":                true,
+	"protoc-gen-openapiv2/options/annotations.proto": true,
+	"protoc-gen-openapiv2/options/openapiv2.proto":   true,
+	"protoc-gen-swagger/options/annotations.proto":   true,
+	"protoc-gen-swagger/options/openapiv2.proto":     true,
+}
+
+// loadImport returns the imported proto file name, along with the files it
+// depends on.
+func (b *builder) loadImport(name string) ([]*descriptorpb.FileDescriptorProto, error) {
+	if b.importFiles == nil {
+		return b.protoLoader.LoadProto(name)
+	}
+	f, ok := b.importFiles[name]
+	if !ok {
+		return nil, fmt.Errorf("imported file %s not found", name)
+	}
+	return []*descriptorpb.FileDescriptorProto{f}, nil
+}
+
+// importedType returns the Gunk type of the proto type typ if it is declared
+// in an imported package, such as pkg_v1.Type for "pkg.v1.Type", or if it is a
+// well-known type which Gunk declares with a Go type, such as time.Time.
+func (b *builder) importedType(typ string) (string, bool) {
+	switch typ {
+	case "google.protobuf.Timestamp":
+		return b.addImportUsed("time") + ".Time", true
+	case "google.protobuf.Duration":
+		return b.addImportUsed("time") + ".Duration", true
+	}
+	// The longest package wins, as packages may be nested.
+	name, pkg := "", ""
+	for p, n := range b.importedPkgs {
+		if strings.HasPrefix(typ, p+".") && len(p) > len(pkg) {
+			name, pkg = n, p
+		}
+	}
+	if pkg == "" {
+		return "", false
+	}
+	return name + "." + strings.Replace(strings.TrimPrefix(typ, pkg+"."), ".", "_", -1), true
+}
+
 func (b *builder) handleImports() string {
 	if len(b.importsUsed) == 0 && len(b.imports) == 0 {
 		return ""
@@ -0,0 +1,129 @@
+package loader
+
+import (
+	"fmt"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"path"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// IsProtoImport reports whether the import path of a Gunk file is that of a
+// .proto file, such as "google/type/money.proto", rather than of a Gunk or Go
+// package.
+func IsProtoImport(path string) bool {
+	return strings.HasSuffix(path, ".proto")
+}
+
+// importProto loads the .proto file at path as a package, so that Gunk files
+// importing it can use its messages and enums as types. The file is loaded
+// with l.ProtoLoader, from the files bundled with Gunk or with protoc.
+//
+// As with protoc-gen-go, nested types are named after their parents, such as
+// Outer_Inner, and enum values after their enum, or their parent message for
+// nested enums, such as Status_ACTIVE. The package is named after the file,
+// so "google/type/money.proto" is imported as money.
+func (l *Loader) importProto(protoPath string) (*GunkPackage, error) {
+	if pkg := l.cache[protoPath]; pkg != nil {
+		return pkg, nil
+	}
+	protoLoader := l.ProtoLoader
+	if protoLoader == nil {
+		protoLoader = &ProtoLoader{}
+	}
+	files, err := protoLoader.LoadProtoContext(l.context(), protoPath)
+	if err != nil {
+		return nil, err
+	}
+	var file *descriptorpb.FileDescriptorProto
+	for _, f := range files {
+		if f.GetName() == protoPath {
+			file = f
+		}
+	}
+	if file == nil {
+		return nil, fmt.Errorf("%s not found", protoPath)
+	}
+	name := strings.TrimSuffix(path.Base(protoPath), ".proto")
+	name = strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, name)
+	pkg := &GunkPackage{
+		Package: packages.Package{
+			ID:      protoPath,
+			Name:    name,
+			PkgPath: protoPath,
+			Types:   types.NewPackage(protoPath, name),
+		},
+		ProtoName:  file.GetPackage(),
+		ProtoFile:  protoPath,
+		ProtoTypes: make(map[string]string),
+	}
+	prefix := "."
+	if file.GetPackage() != "" {
+		prefix += file.GetPackage() + "."
+	}
+	for _, enum := range file.GetEnumType() {
+		addProtoEnum(pkg, enum, "", prefix)
+	}
+	for _, msg := range file.GetMessageType() {
+		addProtoMessage(pkg, msg, "", prefix)
+	}
+	pkg.Types.MarkComplete()
+	if l.cache == nil {
+		l.cache = make(map[string]*GunkPackage)
+	}
+	l.cache[protoPath] = pkg
+	return pkg, nil
+}
+
+// addProtoMessage declares the message msg, along with its nested messages and
+// enums, in the types of pkg. goPrefix and protoPrefix are the prefixes of its
+// Go name and of its full proto name.
+func addProtoMessage(pkg *GunkPackage, msg *descriptorpb.DescriptorProto, goPrefix, protoPrefix string) {
+	if msg.GetOptions().GetMapEntry() {
+		return
+	}
+	goName := goPrefix + msg.GetName()
+	protoName := protoPrefix + msg.GetName()
+	addProtoType(pkg, goName, protoName, types.NewStruct(nil, nil))
+	for _, enum := range msg.GetEnumType() {
+		addProtoEnum(pkg, enum, goName+"_", protoName+".")
+	}
+	for _, nested := range msg.GetNestedType() {
+		addProtoMessage(pkg, nested, goName+"_", protoName+".")
+	}
+}
+
+// addProtoEnum declares the enum and its values in the types of pkg, as with
+// addProtoMessage.
+func addProtoEnum(pkg *GunkPackage, enum *descriptorpb.EnumDescriptorProto, goPrefix, protoPrefix string) {
+	goName := goPrefix + enum.GetName()
+	typ := addProtoType(pkg, goName, protoPrefix+enum.GetName(), types.Typ[types.Int])
+	valuePrefix := goName + "_"
+	if goPrefix != "" {
+		// The values of nested enums are scoped in their message.
+		valuePrefix = goPrefix
+	}
+	for _, value := range enum.GetValue() {
+		val := constant.MakeInt64(int64(value.GetNumber()))
+		pkg.Types.Scope().Insert(types.NewConst(token.NoPos, pkg.Types, valuePrefix+value.GetName(), typ, val))
+	}
+}
+
+// addProtoType declares a named type of pkg, standing for the message or enum
+// protoName.
+func addProtoType(pkg *GunkPackage, goName, protoName string, underlying types.Type) *types.Named {
+	obj := types.NewTypeName(token.NoPos, pkg.Types, goName, nil)
+	typ := types.NewNamed(obj, underlying, nil)
+	pkg.Types.Scope().Insert(obj)
+	pkg.ProtoTypes[goName] = protoName
+	return typ
+}
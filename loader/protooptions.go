@@ -0,0 +1,105 @@
+package loader
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emicklei/proto"
+)
+
+// optionTag is a built-in protobuf option, along with the Gunk option type it
+// is converted to by ConvertFromProto.
+type optionTag struct {
+	Import string // import path of the package declaring the Gunk type
+	Type   string // name of the Gunk type
+	String bool   // whether the value is a string
+	// Consts maps the names of the values of enum options to the names
+	// of the Gunk constants. Numeric values are kept as they are.
+	Consts map[string]string
+}
+
+var fileOptionTags = map[string]optionTag{
+	"deprecated":             {Import: "github.com/gunk/opt/file", Type: "Deprecated"},
+	"optimize_for":           {Import: "github.com/gunk/opt/file", Type: "OptimizeFor", Consts: map[string]string{"SPEED": "Speed", "CODE_SIZE": "CodeSize", "LITE_RUNTIME": "LiteRuntime"}},
+	"java_package":           {Import: "github.com/gunk/opt/file/java", Type: "Package", String: true},
+	"java_outer_classname":   {Import: "github.com/gunk/opt/file/java", Type: "OuterClassname", String: true},
+	"java_multiple_files":    {Import: "github.com/gunk/opt/file/java", Type: "MultipleFiles"},
+	"java_string_check_utf8": {Import: "github.com/gunk/opt/file/java", Type: "StringCheckUtf8"},
+	"java_generic_services":  {Import: "github.com/gunk/opt/file/java", Type: "GenericServices"},
+	"swift_prefix":           {Import: "github.com/gunk/opt/file/swift", Type: "Prefix", String: true},
+	"ruby_package":           {Import: "github.com/gunk/opt/file/ruby", Type: "Package", String: true},
+	"csharp_namespace":       {Import: "github.com/gunk/opt/file/csharp", Type: "Namespace", String: true},
+	"objc_class_prefix":      {Import: "github.com/gunk/opt/file/objc", Type: "ClassPrefix", String: true},
+	"php_namespace":          {Import: "github.com/gunk/opt/file/php", Type: "Namespace", String: true},
+	"php_class_prefix":       {Import: "github.com/gunk/opt/file/php", Type: "ClassPrefix", String: true},
+	"php_metadata_namespace": {Import: "github.com/gunk/opt/file/php", Type: "MetadataNamespace", String: true},
+	"php_generic_services":   {Import: "github.com/gunk/opt/file/php", Type: "GenericServices"},
+	"cc_generic_services":    {Import: "github.com/gunk/opt/file/cc", Type: "GenericServices"},
+	"cc_enable_arenas":       {Import: "github.com/gunk/opt/file/cc", Type: "EnableArenas"},
+}
+
+var messageOptionTags = map[string]optionTag{
+	"message_set_wire_format":         {Import: "github.com/gunk/opt/message", Type: "MessageSetWireFormat"},
+	"no_standard_descriptor_accessor": {Import: "github.com/gunk/opt/message", Type: "NoStandardDescriptorAccessor"},
+	"deprecated":                      {Import: "github.com/gunk/opt/message", Type: "Deprecated"},
+}
+
+var fieldOptionTags = map[string]optionTag{
+	"packed":     {Import: "github.com/gunk/opt/field", Type: "Packed"},
+	"lazy":       {Import: "github.com/gunk/opt/field", Type: "Lazy"},
+	"deprecated": {Import: "github.com/gunk/opt/field", Type: "Deprecated"},
+	"ctype":      {Import: "github.com/gunk/opt/field/cc", Type: "Type", Consts: map[string]string{"STRING": "String", "CORD": "Cord", "STRING_PIECE": "StringPiece"}},
+	"jstype":     {Import: "github.com/gunk/opt/field/js", Type: "Type", Consts: map[string]string{"JS_NORMAL": "Normal", "JS_STRING": "String", "JS_NUMBER": "Number"}},
+}
+
+var enumOptionTags = map[string]optionTag{
+	"allow_alias": {Import: "github.com/gunk/opt/enum", Type: "AllowAlias"},
+	"deprecated":  {Import: "github.com/gunk/opt/enum", Type: "Deprecated"},
+}
+
+var enumValueOptionTags = map[string]optionTag{
+	"deprecated": {Import: "github.com/gunk/opt/enumvalues", Type: "Deprecated"},
+}
+
+var serviceOptionTags = map[string]optionTag{
+	"deprecated": {Import: "github.com/gunk/opt/service", Type: "Deprecated"},
+}
+
+var methodOptionTags = map[string]optionTag{
+	"deprecated":        {Import: "github.com/gunk/opt/method", Type: "Deprecated"},
+	"idempotency_level": {Import: "github.com/gunk/opt/method", Type: "IdempotencyLevel", Consts: map[string]string{"IDEMPOTENCY_UNKNOWN": "Unknown", "NO_SIDE_EFFECTS": "NoSideEffects", "IDEMPOTENT": "Idempotent"}},
+}
+
+// optionTag returns the Gunk tag of opt, a built-in option found in tags,
+// recording the import of its type. ok is false if opt isn't in tags.
+func (b *builder) optionTag(tags map[string]optionTag, opt *proto.Option) (tag string, ok bool) {
+	t, ok := tags[opt.Name]
+	if !ok {
+		return "", false
+	}
+	pkg := b.addImportUsed(t.Import)
+	val := opt.Constant.Source
+	if t.String {
+		val = strconv.Quote(val)
+	} else if name, ok := t.Consts[val]; ok {
+		val = pkg + "." + name
+	}
+	return fmt.Sprintf("%s.%s(%s)", pkg, t.Type, val), true
+}
+
+// openapiv2Option returns the name of the grpc-gateway OpenAPI v2 option
+// set by a custom option named name, such as "openapiv2_schema", or an empty
+// string if it isn't one. The options of both the v1 and v2 grpc-gateway
+// plugins are supported.
+func openapiv2Option(name string) string {
+	for _, prefix := range []string{
+		"(grpc.gateway.protoc_gen_swagger.options.",
+		"(grpc.gateway.protoc_gen_openapiv2.options.",
+	} {
+		if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ")") {
+			return strings.TrimSuffix(strings.TrimPrefix(name, prefix), ")")
+		}
+	}
+	return ""
+}
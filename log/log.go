@@ -1,6 +1,7 @@
 package log
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -28,10 +29,16 @@ func Verbosef(format string, args ...interface{}) {
 }
 
 func ExecCommand(command string, args ...string) *exec.Cmd {
+	return ExecCommandContext(context.Background(), command, args...)
+}
+
+// ExecCommandContext is like ExecCommand, but the command is killed if ctx is
+// done before it exits.
+func ExecCommandContext(ctx context.Context, command string, args ...string) *exec.Cmd {
 	if PrintCommands {
 		Printf(formatCommand(command, args...))
 	}
-	cmd := exec.Command(command, args...)
+	cmd := exec.CommandContext(ctx, command, args...)
 	if Verbose {
 		cmd.Stderr = Out
 	}
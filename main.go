@@ -1,15 +1,32 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/gunk/gunk/breaking"
 	"github.com/gunk/gunk/convert"
+	"github.com/gunk/gunk/doc"
 	"github.com/gunk/gunk/dump"
+	"github.com/gunk/gunk/fix"
 	"github.com/gunk/gunk/format"
 	"github.com/gunk/gunk/generate"
 	"github.com/gunk/gunk/generate/downloader"
+	"github.com/gunk/gunk/generators"
+	"github.com/gunk/gunk/interrupt"
 	"github.com/gunk/gunk/log"
+	"github.com/gunk/gunk/openapi"
+	"github.com/gunk/gunk/protodeps"
+	"github.com/gunk/gunk/push"
+	"github.com/gunk/gunk/query"
+	"github.com/gunk/gunk/reflectionserver"
+	"github.com/gunk/gunk/release"
+	"github.com/gunk/gunk/sign"
+	"github.com/gunk/gunk/snippets"
+	"github.com/gunk/gunk/vet"
 	"github.com/gunk/gunk/vetconfig"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
@@ -19,21 +36,79 @@ var (
 	app                     = kingpin.New("gunk", "The modern frontend and syntax for Protocol Buffers.").UsageTemplate(kingpin.CompactUsageTemplate)
 	gen                     = app.Command("generate", "Generate code from Gunk packages.")
 	genPatterns             = gen.Arg("patterns", "patterns of Gunk packages").Strings()
-	conv                    = app.Command("convert", "Convert Proto file to Gunk file.")
-	convProtoFilesOrFolders = conv.Arg("files_or_folders", "Proto files or folders to convert to Gunk").Strings()
+	conv                    = app.Command("convert", "Convert Proto file, or OpenAPI document, to Gunk file.")
+	convProtoFilesOrFolders = conv.Arg("files_or_folders", "Proto files, OpenAPI files or folders to convert to Gunk").Strings()
 	convOverwriteGunkFile   = conv.Flag("overwrite", "overwrite the converted Gunk file if it exists.").Bool()
+	convGRPCReflection      = conv.Flag("grpc-reflection", "convert the services of a running gRPC server, fetched over its reflection service, into the given folder or the current one").PlaceHolder("HOST:PORT").String()
 	frmt                    = app.Command("format", "Format Gunk code.")
 	frmtPatterns            = frmt.Arg("patterns", "patterns of Gunk packages").Strings()
+	fx                      = app.Command("fix", "Rewrite Gunk packages which use deprecated constructs to their current forms.")
+	fxPatterns              = fx.Arg("patterns", "patterns of Gunk packages").Strings()
+	fxFixes                 = fx.Flag("fix", "comma-separated list of fixes to apply, all of them by default: "+strings.Join(fix.Names(), ", ")).String()
+	fxDiff                  = fx.Flag("diff", "print the changes as unified diffs instead of writing them").Bool()
+	fxList                  = fx.Flag("list", "list the available fixes").Bool()
+	qry                     = app.Command("query", "Run a jq-style query over the API model of Gunk packages, printing each result as JSON.")
+	qryExpr                 = qry.Arg("expression", "query expression, such as '.packages[].services[].methods[].full_name'").Required().String()
+	qryPatterns             = qry.Arg("patterns", "patterns of Gunk packages").Strings()
+	qryRaw                  = qry.Flag("raw", "print string results without quotes").Short('r').Bool()
 	dmp                     = app.Command("dump", "Write a FileDescriptorSet, defined in descriptor.proto")
 	dmpPatterns             = dmp.Arg("patterns", "patterns of Gunk packages").Strings()
-	dmpFormat               = dmp.Flag("format", "output format: proto (default), or json").String()
+	dmpFormat               = dmp.Flag("format", "output format: proto (default), json, or source for the .proto files").String()
+	dmpOut                  = dmp.Flag("out", "directory to write the .proto files to with --format=source, rather than stdout").String()
+	dmpProvenance           = dmp.Flag("provenance", "with --format=source, comment each element with the Gunk file and line declaring it, and the files with the Gunk version").Bool()
+	oapi                    = app.Command("openapi", "Write an OpenAPI 3.1 document of the HTTP bindings of Gunk packages.")
+	oapiPatterns            = oapi.Arg("patterns", "patterns of Gunk packages").Strings()
+	oapiFormat              = oapi.Flag("format", "output format: json, or yaml").Default("json").Enum("json", "yaml")
+	dc                      = app.Command("doc", "Write the Markdown or HTML documentation of Gunk packages.").Alias("docs")
+	dcPatterns              = dc.Arg("patterns", "patterns of Gunk packages").Strings()
+	dcServe                 = dc.Flag("serve", "serve the HTML documentation on an address, such as :8080, reloading it when the Gunk files change").PlaceHolder("ADDR").String()
 	download                = app.Command("download", "Download required tools for Gunk, e.g., protoc")
 	dlAll                   = download.Command("all", "download all required tools")
 	dlProtoc                = download.Command("protoc", "download protoc")
 	dlProtocPath            = dlProtoc.Flag("path", "path to check for protoc binary, or where to download it to").String()
 	dlProtocVer             = dlProtoc.Flag("version", "version of protoc to use").String()
+	vnd                     = app.Command("vendor", "Download the proto dependencies declared in the gunkconfig, pinning them in gunk.lock.")
+	gens                    = app.Command("generators", "Inspect the code generators available to Gunk.")
+	gensList                = gens.Command("list", "list the protoc builtin generators and the configured generators")
+	cnf                     = app.Command("config", "Inspect gunk config files.")
+	cnfCheck                = cnf.Command("check", "check the gunk config files and print the effective config of each Gunk package")
+	cnfPatterns             = cnfCheck.Arg("patterns", "patterns of Gunk packages").Strings()
+	cnfPrint                = cnf.Command("print", "print the gunk config files which apply to each Gunk package, most specific first")
+	cnfPrintPatterns        = cnfPrint.Arg("patterns", "patterns of Gunk packages").Strings()
+	cnfPrintEffective       = cnfPrint.Flag("effective", "print the merged config instead, annotating each value with the config file which set it").Bool()
+	opts                    = app.Command("options", "Write a JSON catalog of the supported +gunk option tags, with their scopes, fields and types.")
+	snp                     = app.Command("snippets", "Write editor snippets for Gunk messages, services, enums and the supported +gunk option tags.")
+	snpEditor               = snp.Flag("editor", "editor to write snippets for: vscode, or nvim (snipMate format)").Required().Enum(snippets.EditorVSCode, snippets.EditorNvim)
 	ver                     = app.Command("version", "Show Gunk version.")
-	vet                     = app.Command("vet", "Vet gunk config files")
+	vt                      = app.Command("vet", "Vet gunk config files and Gunk packages.")
+	vtPatterns              = vt.Arg("patterns", "patterns of Gunk packages").Strings()
+	rel                     = app.Command("release", "Tag a new release of Gunk packages in a git repository.")
+	relVersion              = rel.Arg("version", "version to release, e.g. v1.2.3, or major, minor or patch").Required().String()
+	relPatterns             = rel.Arg("patterns", "patterns of Gunk packages").Strings()
+	relAllowBreaking        = rel.Flag("allow-breaking", "allow breaking changes without a major version bump").Bool()
+	relEnforceSuggested     = rel.Flag("enforce-suggested", "require at least the version suggested by gunk suggest-version").Bool()
+	sug                     = app.Command("suggest-version", "Suggest the next release version of Gunk packages from their changes since the last release.")
+	sugPatterns             = sug.Arg("patterns", "patterns of Gunk packages").Strings()
+	brk                     = app.Command("breaking", "Report breaking changes to Gunk packages since a git ref or a saved FileDescriptorSet.")
+	brkPatterns             = brk.Arg("patterns", "patterns of Gunk packages").Strings()
+	wcp                     = app.Command("wire-compat", "Check the wire compatibility of Gunk packages with a baseline, by encoding random messages with each version and decoding them with the other.")
+	wcpPatterns             = wcp.Arg("patterns", "patterns of Gunk packages").Strings()
+	srf                     = app.Command("serve-reflection", "Serve Gunk packages over the gRPC reflection protocol, for tools such as grpcurl.")
+	srfPatterns             = srf.Arg("patterns", "patterns of Gunk packages").Strings()
+	srfAddr                 = srf.Flag("addr", "address to listen on").Default("localhost:50051").String()
+	psh                     = app.Command("push", "Push Gunk packages as a module to the Buf Schema Registry.")
+	pshPatterns             = psh.Arg("patterns", "patterns of Gunk packages").Strings()
+	vrf                     = app.Command("verify", "Verify the signatures of files signed by gunk generate, such as OpenAPI documents.")
+	vrfFiles                = vrf.Arg("files", "signed files to verify").Required().Strings()
+
+	genOpts     generate.Options
+	genFailFast bool
+	dmpOpts     generate.DescriptorSetOptions
+	dcOpts      doc.Options
+	brkOpts     breaking.Options
+	wcpOpts     breaking.SimulateOptions
+	pshOpts     push.Options
+	vrfOpts     sign.VerifyOptions
 )
 
 func main() {
@@ -71,6 +146,38 @@ func main2() (code int) {
 	app.HelpFlag.Short('h') // allow -h as well as --help
 	gen.Flag("print-commands", "print the commands").Short('x').BoolVar(&log.PrintCommands)
 	gen.Flag("verbose", "print the names of packages as they are generated").Short('v').BoolVar(&log.Verbose)
+	gen.Flag("report-unused-imports", "print the Gunk imports which are pruned as unused").BoolVar(&genOpts.ReportUnusedImports)
+	gen.Flag("fail-unused-imports", "fail if any Gunk import is unused").BoolVar(&genOpts.FailUnusedImports)
+	gen.Flag("debug-requests", "write the input of each generator to a directory, to replay it outside of gunk").PlaceHolder("DIR").StringVar(&genOpts.DebugRequestsDir)
+	gen.Flag("report", "write a JSON report of the packages, files and generators of the run to a file").PlaceHolder("FILE").StringVar(&genOpts.ReportFile)
+	gen.Flag("size-report", "print the size of the generated code by generator, estimated by message and service, with hints to reduce it").BoolVar(&genOpts.SizeReport)
+	gen.Flag("dry-run", "generate in memory, printing a diff of the files which would change, and fail if any would").BoolVar(&genOpts.DryRun)
+	gen.Flag("hermetic", "generate in a temporary copy of the module, writing the changed files back once all packages were generated").BoolVar(&genOpts.Hermetic)
+	gen.Flag("only-symbol", "only generate a service, message or enum and the types it uses, with the generators which support it").PlaceHolder("PKG.NAME").StringVar(&genOpts.OnlySymbol)
+	gen.Flag("out-root", "write all generated files under a directory, mirroring the package directories; overrides out_root").PlaceHolder("DIR").StringVar(&genOpts.OutRoot)
+	gen.Flag("keep-going", "generate as many packages as possible, and report the failures at the end").Short('k').BoolVar(&genOpts.KeepGoing)
+	gen.Flag("fail-fast", "stop at the first package which fails to generate (default)").BoolVar(&genFailFast)
+	gen.Flag("check-plugins", "check plugin versions against plugin_version and version_range before generating").EnumVar(&genOpts.CheckPlugins, generate.CheckPluginsWarn, generate.CheckPluginsFail)
+	dmp.Flag("include-imports", "include all dependencies of the package in the set; disable with --no-include-imports").Default("true").BoolVar(&dmpOpts.IncludeImports)
+	dmp.Flag("include-source-info", "include comments and source positions in the set; disable with --no-include-source-info").Default("true").BoolVar(&dmpOpts.IncludeSourceInfo)
+	dc.Flag("format", "output format: markdown, or html").Default(doc.FormatMarkdown).EnumVar(&dcOpts.Format, doc.FormatMarkdown, doc.FormatHTML)
+	dc.Flag("template", "Go template to render the documentation with, instead of the default one of the format").StringVar(&dcOpts.Template)
+	brk.Flag("against", "git ref to compare against").Default("HEAD").StringVar(&brkOpts.Against)
+	brk.Flag("against-file", "FileDescriptorSet to compare against, as written by gunk dump").PlaceHolder("FILE").StringVar(&brkOpts.AgainstFile)
+	brk.Flag("wire-only", "only report changes which break the wire format").BoolVar(&brkOpts.WireOnly)
+	wcp.Flag("against", "git ref to compare against").Default("HEAD").StringVar(&wcpOpts.Against)
+	wcp.Flag("against-file", "FileDescriptorSet to compare against, as written by gunk dump").PlaceHolder("FILE").StringVar(&wcpOpts.AgainstFile)
+	wcp.Flag("samples", "number of random messages of each type to encode in each direction").Default("20").IntVar(&wcpOpts.Samples)
+	wcp.Flag("seed", "seed of the random messages").Default("1").Int64Var(&wcpOpts.Seed)
+	psh.Flag("module", "module to push to, such as buf.build/acme/api").StringVar(&pshOpts.Module)
+	psh.Flag("registry", "base URL of the registry API (default https://api.<remote>)").StringVar(&pshOpts.Registry)
+	psh.Flag("tag", "tag to attach to the pushed commit; can be repeated").StringsVar(&pshOpts.Tags)
+	psh.Flag("label", "label to attach to the pushed commit; can be repeated").StringsVar(&pshOpts.Labels)
+	psh.Flag("out", "write the module to a directory instead of pushing it").PlaceHolder("DIR").StringVar(&pshOpts.Out)
+	vrf.Flag("keyring", "file of the public GPG keys to trust, as written by gpg --export, instead of the user's keyring").PlaceHolder("FILE").StringVar(&vrfOpts.Keyring)
+	vrf.Flag("key", "public key of sigstore signatures made with a cosign key").StringVar(&vrfOpts.Key)
+	vrf.Flag("identity", "certificate identity of keyless sigstore signatures, such as an email").StringVar(&vrfOpts.Identity)
+	vrf.Flag("issuer", "OIDC issuer of keyless sigstore signatures, such as https://accounts.google.com").StringVar(&vrfOpts.Issuer)
 	download.Flag("verbose", "print details of downloaded tools").Short('v').BoolVar(&log.Verbose)
 	downloadSubcommands := []func() error{
 		downloadProtoc,
@@ -83,19 +190,89 @@ func main2() (code int) {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		return 1
 	}
+	// On an interrupt, stop the commands which support it and clean up
+	// after them, such as by killing the running generators.
+	ctx, stop := interrupt.NotifyContext(context.Background())
+	defer stop()
 	switch command {
 	case ver.FullCommand():
 		fmt.Fprintf(os.Stdout, "gunk %s\n", version)
 	case gen.FullCommand():
-		err = generate.Run("", *genPatterns...)
-	case vet.FullCommand():
-		err = vetconfig.Run(".")
+		if genFailFast && genOpts.KeepGoing {
+			err = fmt.Errorf("--keep-going and --fail-fast cannot be used together")
+			break
+		}
+		err = generate.RunContext(ctx, genOpts, "", *genPatterns...)
+	case vnd.FullCommand():
+		err = protodeps.Run(os.Stdout, "")
+	case gensList.FullCommand():
+		err = generators.List(os.Stdout, ".")
+	case cnfCheck.FullCommand():
+		err = vetconfig.Check(os.Stdout, "", *cnfPatterns...)
+	case cnfPrint.FullCommand():
+		err = vetconfig.Print(os.Stdout, "", *cnfPrintEffective, *cnfPrintPatterns...)
+	case opts.FullCommand():
+		err = generate.WriteOptionCatalog(ctx, os.Stdout, "")
+	case snp.FullCommand():
+		err = snippets.Run(ctx, os.Stdout, "", *snpEditor)
+	case vt.FullCommand():
+		if err = vetconfig.Run("."); err == nil {
+			err = vet.Run(os.Stdout, "", *vtPatterns...)
+		}
+	case rel.FullCommand():
+		err = release.Run("", *relVersion, release.Options{AllowBreaking: *relAllowBreaking, EnforceSuggested: *relEnforceSuggested}, *relPatterns...)
+	case sug.FullCommand():
+		err = release.RunSuggest(os.Stdout, "", *sugPatterns...)
+	case brk.FullCommand():
+		err = breaking.Run(os.Stdout, "", brkOpts, *brkPatterns...)
+	case wcp.FullCommand():
+		err = breaking.RunSimulate(os.Stdout, "", wcpOpts, *wcpPatterns...)
+	case srf.FullCommand():
+		err = reflectionserver.Run(ctx, os.Stderr, *srfAddr, "", *srfPatterns...)
+	case psh.FullCommand():
+		err = push.Run(ctx, os.Stdout, "", pshOpts, *pshPatterns...)
+	case vrf.FullCommand():
+		err = sign.Verify(ctx, os.Stdout, vrfOpts, *vrfFiles...)
 	case conv.FullCommand():
-		err = convert.Run(*convProtoFilesOrFolders, *convOverwriteGunkFile)
+		if *convGRPCReflection == "" {
+			err = convert.Run(*convProtoFilesOrFolders, *convOverwriteGunkFile)
+		} else if len(*convProtoFilesOrFolders) > 1 {
+			err = fmt.Errorf("--grpc-reflection takes at most one folder to write to")
+		} else {
+			dir := "."
+			if len(*convProtoFilesOrFolders) == 1 {
+				dir = (*convProtoFilesOrFolders)[0]
+			}
+			err = convert.RunReflection(ctx, *convGRPCReflection, dir, *convOverwriteGunkFile)
+		}
 	case frmt.FullCommand():
 		err = format.Run("", *frmtPatterns...)
+	case fx.FullCommand():
+		if *fxList {
+			fix.List(os.Stdout)
+			break
+		}
+		var names []string
+		if *fxFixes != "" {
+			names = strings.Split(*fxFixes, ",")
+		}
+		err = fix.Run(os.Stdout, "", fix.Options{Fixes: names, Diff: *fxDiff}, *fxPatterns...)
+	case qry.FullCommand():
+		err = query.Run(os.Stdout, "", *qryExpr, *qryRaw, *qryPatterns...)
 	case dmp.FullCommand():
-		err = dump.Run(*dmpFormat, "", *dmpPatterns...)
+		provenance := ""
+		if *dmpProvenance {
+			provenance = version
+		}
+		err = dump.Run(*dmpFormat, "", *dmpOut, provenance, dmpOpts, *dmpPatterns...)
+	case oapi.FullCommand():
+		err = openapi.Run(os.Stdout, *oapiFormat, "", *oapiPatterns...)
+	case dc.FullCommand():
+		if *dcServe != "" {
+			err = doc.Serve(ctx, os.Stderr, *dcServe, "", dcOpts, *dcPatterns...)
+		} else {
+			err = doc.Run(os.Stdout, "", dcOpts, *dcPatterns...)
+		}
 	case dlAll.FullCommand():
 		for _, dl := range downloadSubcommands {
 			err = dl()
@@ -108,6 +285,13 @@ func main2() (code int) {
 	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		if ctx.Err() != nil {
+			return interrupt.ExitCode
+		}
+		var gerr *generate.Error
+		if errors.As(err, &gerr) {
+			return gerr.Kind.ExitCode()
+		}
 		return 1
 	}
 	return 0
@@ -30,8 +30,18 @@ func TestMain(m *testing.M) {
 		os.Setenv("GOBIN", binDir)
 		os.Setenv("PATH", binDir+string(filepath.ListSeparator)+os.Getenv("PATH"))
 		cmd := exec.Command("go", "install", "-ldflags=-w -s",
+			"./asyncapigen/",
 			"./docgen/",
+			"./mapgen/",
+			"./otelgen/",
+			"./pagegen/",
+			"./policygen/",
+			"./querygen/",
+			"./retrygen/",
+			"./routegen/",
 			"./scopegen/",
+			"./streamgen/",
+			"./validategen/",
 			"./testdata/protoc-gen-strict",
 		)
 		cmd.Stderr = os.Stderr
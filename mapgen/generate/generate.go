@@ -0,0 +1,409 @@
+package generate
+
+import (
+	"fmt"
+	"go/types"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	durationpbPackage  = protogen.GoImportPath("google.golang.org/protobuf/types/known/durationpb")
+	timestamppbPackage = protogen.GoImportPath("google.golang.org/protobuf/types/known/timestamppb")
+)
+
+// Generate generates, for each message of the files to generate which is
+// mapped to a domain type, functions converting between the two, field by
+// field. mappings maps the Go names of messages, such as Book, to domain
+// types, such as example.com/library/domain.Book. Mappings of messages which
+// aren't in the files are ignored, so that a single config can map the
+// messages of several packages.
+func Generate(gen *protogen.Plugin, mappings map[string]string) error {
+	if len(mappings) == 0 {
+		return nil
+	}
+	domains, err := loadDomainTypes(mappings)
+	if err != nil {
+		return err
+	}
+	for _, f := range gen.Files {
+		if !f.Generate {
+			continue
+		}
+		var maps []*mapping
+		mapped := make(map[*protogen.Message]*mapping)
+		for _, msg := range f.Messages {
+			domain := domains[msg.GoIdent.GoName]
+			if domain == nil {
+				continue
+			}
+			m := &mapping{msg: msg, domain: domain}
+			maps = append(maps, m)
+			mapped[msg] = m
+		}
+		if len(maps) == 0 {
+			continue
+		}
+		g := gen.NewGeneratedFile(f.GeneratedFilenamePrefix+".map.go", f.GoImportPath)
+		g.P(`// Code generated by "mapgen"; DO NOT EDIT.`)
+		g.P("// source: ", f.Desc.Path())
+		g.P()
+		g.P("package ", f.GoPackageName)
+		for _, m := range maps {
+			m.generate(g, mapped)
+		}
+	}
+	return nil
+}
+
+// loadDomainTypes loads the domain types of mappings, by message name.
+func loadDomainTypes(mappings map[string]string) (map[string]*types.Named, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	for msg, typ := range mappings {
+		i := strings.LastIndex(typ, ".")
+		if i <= 0 || strings.HasSuffix(typ, "/") {
+			return nil, fmt.Errorf("map.%s: %q is not a type such as example.com/domain.Book", msg, typ)
+		}
+		if path := typ[:i]; !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	pkgs, err := packages.Load(&packages.Config{Mode: packages.LoadSyntax}, paths...)
+	if err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]*types.Package, len(pkgs))
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) > 0 {
+			return nil, fmt.Errorf("unable to load %s: %v", pkg.PkgPath, pkg.Errors[0])
+		}
+		byPath[pkg.PkgPath] = pkg.Types
+	}
+	domains := make(map[string]*types.Named, len(mappings))
+	for msg, typ := range mappings {
+		i := strings.LastIndex(typ, ".")
+		pkg := byPath[typ[:i]]
+		if pkg == nil {
+			return nil, fmt.Errorf("map.%s: package %s not found", msg, typ[:i])
+		}
+		tn, _ := pkg.Scope().Lookup(typ[i+1:]).(*types.TypeName)
+		if tn == nil {
+			return nil, fmt.Errorf("map.%s: type %s not found", msg, typ)
+		}
+		named, _ := tn.Type().(*types.Named)
+		if named == nil {
+			return nil, fmt.Errorf("map.%s: %s is not a struct type", msg, typ)
+		}
+		if _, ok := named.Underlying().(*types.Struct); !ok {
+			return nil, fmt.Errorf("map.%s: %s is not a struct type", msg, typ)
+		}
+		domains[msg] = named
+	}
+	return domains, nil
+}
+
+// mapping is a message mapped to a domain struct type.
+type mapping struct {
+	msg    *protogen.Message
+	domain *types.Named
+}
+
+// conversion converts a value from one type to the other, as the expression
+// expr, where "$" stands for the converted value. The value is only set if
+// cond, if any, holds for the value.
+type conversion struct {
+	expr     string
+	cond     string
+	identity bool
+}
+
+func (c conversion) apply(v string) string { return strings.ReplaceAll(c.expr, "$", v) }
+
+// set returns the statements setting dst to the conversion of src.
+func (c conversion) set(dst, src string) []string {
+	if c.cond == "" {
+		return []string{dst + " = " + c.apply(src)}
+	}
+	return []string{"if " + strings.ReplaceAll(c.cond, "$", src) + " {", dst + " = " + c.apply(src), "}"}
+}
+
+// appendTo returns the statements appending the conversion of v to dst.
+func (c conversion) appendTo(dst, v string) []string {
+	stmt := dst + " = append(" + dst + ", " + c.apply(v) + ")"
+	if c.cond == "" {
+		return []string{stmt}
+	}
+	return []string{"if " + strings.ReplaceAll(c.cond, "$", v) + " {", stmt, "}"}
+}
+
+func (m *mapping) generate(g *protogen.GeneratedFile, mapped map[*protogen.Message]*mapping) {
+	msgName := m.msg.GoIdent.GoName
+	domainType := g.QualifiedGoIdent(protogen.GoIdent{
+		GoName:       m.domain.Obj().Name(),
+		GoImportPath: protogen.GoImportPath(m.domain.Obj().Pkg().Path()),
+	})
+	qualifier := func(pkg *types.Package) string {
+		return strings.TrimSuffix(g.QualifiedGoIdent(protogen.GoIdent{GoImportPath: protogen.GoImportPath(pkg.Path())}), ".")
+	}
+	st := m.domain.Underlying().(*types.Struct)
+	domainFields := make(map[string]*types.Var, st.NumFields())
+	for i := 0; i < st.NumFields(); i++ {
+		if f := st.Field(i); f.Exported() {
+			domainFields[f.Name()] = f
+		}
+	}
+	used := make(map[*types.Var]bool)
+
+	g.P()
+	g.P("// ", msgName, "FromDomain converts ", domainType, " to the ", msgName, " message.")
+	g.P("func ", msgName, "FromDomain(in *", domainType, ") *", msgName, " {")
+	g.P("if in == nil {")
+	g.P("return nil")
+	g.P("}")
+	g.P("out := &", msgName, "{}")
+	for _, field := range m.msg.Fields {
+		df := domainField(domainFields, field.GoName)
+		if df == nil {
+			g.P("// TODO: set out.", field.GoName, ", which ", domainType, " has no field for.")
+			continue
+		}
+		used[df] = true
+		dst, src := "out."+field.GoName, "in."+df.Name()
+		for _, line := range fieldConversion(g, qualifier, mapped, field, df, true, dst, src) {
+			g.P(line)
+		}
+	}
+	g.P("return out")
+	g.P("}")
+
+	g.P()
+	g.P("// ", msgName, "ToDomain converts the ", msgName, " message to ", domainType, ".")
+	g.P("func ", msgName, "ToDomain(in *", msgName, ") *", domainType, " {")
+	g.P("if in == nil {")
+	g.P("return nil")
+	g.P("}")
+	g.P("out := &", domainType, "{}")
+	for _, field := range m.msg.Fields {
+		df := domainField(domainFields, field.GoName)
+		if df == nil {
+			continue
+		}
+		dst, src := "out."+df.Name(), "in."+field.GoName
+		for _, line := range fieldConversion(g, qualifier, mapped, field, df, false, dst, src) {
+			g.P(line)
+		}
+	}
+	for i := 0; i < st.NumFields(); i++ {
+		if f := st.Field(i); f.Exported() && !used[f] {
+			g.P("// TODO: set out.", f.Name(), ", which ", msgName, " has no field for.")
+		}
+	}
+	g.P("return out")
+	g.P("}")
+
+	// Unexported fields of other packages can't be named, so only
+	// domain types with exported fields are checked.
+	for i := 0; i < st.NumFields(); i++ {
+		if !st.Field(i).Exported() {
+			return
+		}
+	}
+	g.P()
+	g.P("// The fields of ", domainType, " as the conversions were generated for. This")
+	g.P("// fails to compile once they change, until the conversions are generated")
+	g.P("// again.")
+	g.P("var _ = struct {")
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		typ := types.TypeString(f.Type(), qualifier)
+		if f.Embedded() {
+			g.P(typ)
+			continue
+		}
+		g.P(f.Name(), " ", typ)
+	}
+	g.P("}(", domainType, "{})")
+}
+
+// domainField returns the domain field named like a message field, ignoring
+// case if no field has the exact name, so that ID matches Id.
+func domainField(fields map[string]*types.Var, name string) *types.Var {
+	if f := fields[name]; f != nil {
+		return f
+	}
+	var names []string
+	for n := range fields {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return fields[n]
+		}
+	}
+	return nil
+}
+
+// fieldConversion returns the statements setting the field dst from src,
+// converting a domain field to a message field if toProto is set, or the
+// other way around. Fields which can't be converted get a TODO comment.
+func fieldConversion(g *protogen.GeneratedFile, qualifier types.Qualifier, mapped map[*protogen.Message]*mapping, field *protogen.Field, df *types.Var, toProto bool, dst, src string) []string {
+	if field.Oneof != nil {
+		// Oneofs and optional fields have no plain value to set.
+		return []string{fmt.Sprintf("// TODO: set %s from %s; oneofs and optional fields aren't converted.", dst, src)}
+	}
+	todo := []string{fmt.Sprintf("// TODO: set %s from %s, whose type %s can't be converted.", dst, src, types.TypeString(df.Type(), qualifier))}
+	fd := field.Desc
+	switch {
+	case fd.IsMap():
+		m, ok := df.Type().Underlying().(*types.Map)
+		if !ok {
+			return todo
+		}
+		key := valueConversion(g, qualifier, mapped, field.Message.Fields[0], m.Key(), toProto)
+		val := valueConversion(g, qualifier, mapped, field.Message.Fields[1], m.Elem(), toProto)
+		if key == nil || val == nil || !key.identity || !val.identity || !types.Identical(df.Type(), m) {
+			return todo
+		}
+		return []string{dst + " = " + src}
+	case fd.IsList():
+		s, ok := df.Type().Underlying().(*types.Slice)
+		if !ok {
+			return todo
+		}
+		c := valueConversion(g, qualifier, mapped, field, s.Elem(), toProto)
+		if c == nil {
+			return todo
+		}
+		if c.identity && types.Identical(df.Type(), s) {
+			return []string{dst + " = " + src}
+		}
+		lines := []string{"for _, v := range " + src + " {"}
+		lines = append(lines, c.appendTo(dst, "v")...)
+		return append(lines, "}")
+	}
+	c := valueConversion(g, qualifier, mapped, field, df.Type(), toProto)
+	if c == nil {
+		return todo
+	}
+	return c.set(dst, src)
+}
+
+// valueConversion returns the conversion of a single value of field, to it
+// from the domain type typ if toProto is set, or the other way around. It
+// returns nil if the types can't be converted.
+func valueConversion(g *protogen.GeneratedFile, qualifier types.Qualifier, mapped map[*protogen.Message]*mapping, field *protogen.Field, typ types.Type, toProto bool) *conversion {
+	domainType := types.TypeString(typ, qualifier)
+	convert := func(protoType string) *conversion {
+		if domainType == protoType {
+			return &conversion{expr: "$", identity: true}
+		}
+		if toProto {
+			return &conversion{expr: protoType + "($)"}
+		}
+		return &conversion{expr: domainType + "($)"}
+	}
+	basic, _ := typ.Underlying().(*types.Basic)
+	switch kind := field.Desc.Kind(); kind {
+	case protoreflect.BoolKind:
+		if basic != nil && basic.Info()&types.IsBoolean != 0 {
+			return convert("bool")
+		}
+	case protoreflect.StringKind:
+		if basic != nil && basic.Info()&types.IsString != 0 {
+			return convert("string")
+		}
+	case protoreflect.BytesKind:
+		if s, ok := typ.Underlying().(*types.Slice); ok {
+			if b, ok := s.Elem().(*types.Basic); ok && b.Kind() == types.Byte {
+				return convert("[]byte")
+			}
+		}
+	case protoreflect.EnumKind:
+		if basic != nil && basic.Info()&types.IsInteger != 0 {
+			return convert(g.QualifiedGoIdent(field.Enum.GoIdent))
+		}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return messageConversion(g, mapped, field.Message, typ, toProto)
+	default:
+		if basic != nil && basic.Info()&types.IsNumeric != 0 && basic.Info()&types.IsComplex == 0 {
+			return convert(scalarGoType(kind))
+		}
+	}
+	return nil
+}
+
+// scalarGoType returns the Go type of the values of a numeric field kind.
+func scalarGoType(kind protoreflect.Kind) string {
+	switch kind {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return "int32"
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return "int64"
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return "uint32"
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return "uint64"
+	case protoreflect.FloatKind:
+		return "float32"
+	}
+	return "float64"
+}
+
+// messageConversion returns the conversion of a value of the message msg, as
+// valueConversion does. Messages convert to and from the domain types they
+// are mapped to, either as values or pointers, and timestamps and durations
+// to and from time.Time and time.Duration.
+func messageConversion(g *protogen.GeneratedFile, mapped map[*protogen.Message]*mapping, msg *protogen.Message, typ types.Type, toProto bool) *conversion {
+	switch msg.Desc.FullName() {
+	case "google.protobuf.Timestamp":
+		if !isTimeType(typ, "Time") {
+			return nil
+		}
+		if toProto {
+			// The zero time is left unset.
+			return &conversion{expr: g.QualifiedGoIdent(timestamppbPackage.Ident("New")) + "($)", cond: "!$.IsZero()"}
+		}
+		return &conversion{expr: "$.AsTime()", cond: "$ != nil"}
+	case "google.protobuf.Duration":
+		if !isTimeType(typ, "Duration") {
+			return nil
+		}
+		if toProto {
+			return &conversion{expr: g.QualifiedGoIdent(durationpbPackage.Ident("New")) + "($)"}
+		}
+		return &conversion{expr: "$.AsDuration()"}
+	}
+	m := mapped[msg]
+	if m == nil {
+		return nil
+	}
+	name := msg.GoIdent.GoName
+	ptr, isPtr := typ.(*types.Pointer)
+	switch {
+	case isPtr && types.Identical(ptr.Elem(), m.domain):
+		if toProto {
+			return &conversion{expr: name + "FromDomain($)"}
+		}
+		return &conversion{expr: name + "ToDomain($)"}
+	case types.Identical(typ, m.domain):
+		if toProto {
+			return &conversion{expr: name + "FromDomain(&$)"}
+		}
+		return &conversion{expr: "*" + name + "ToDomain($)", cond: "$ != nil"}
+	}
+	return nil
+}
+
+// isTimeType reports whether typ is the named type of the time package.
+func isTimeType(typ types.Type, name string) bool {
+	named, ok := typ.(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "time" && named.Obj().Name() == name
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"strings"
+
+	"github.com/gunk/gunk/mapgen/generate"
+	"github.com/gunk/gunk/plugin"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func main() {
+	plugin.RunMain(new(mapPlugin))
+}
+
+type mapPlugin struct{}
+
+func (p *mapPlugin) Generate(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	// Each map.<Message> parameter maps a message to a domain type, as
+	// in map.Book=example.com/library/domain.Book.
+	mappings := make(map[string]string)
+	gen, err := protogen.Options{ParamFunc: func(name, value string) error {
+		msg := strings.TrimPrefix(name, "map.")
+		if msg == name || !token.IsExported(msg) {
+			return fmt.Errorf("unknown parameter %q", name)
+		}
+		mappings[msg] = value
+		return nil
+	}}.New(req)
+	if err != nil {
+		return nil, err
+	}
+	gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+	if err := generate.Generate(gen, mappings); err != nil {
+		return nil, err
+	}
+	return gen.Response(), nil
+}
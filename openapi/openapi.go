@@ -0,0 +1,641 @@
+// Package openapi converts Gunk packages into OpenAPI 3.1 documents, straight
+// from their FileDescriptorSet and HTTP bindings, without going through
+// protoc and protoc-gen-openapiv2.
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/protoc-gen-openapiv2/options"
+	"github.com/gunk/gunk/errorgen/errorspb"
+	"github.com/gunk/gunk/generate"
+	"github.com/gunk/gunk/lifecyclepb"
+	"github.com/gunk/gunk/protoutil"
+	"github.com/gunk/gunk/retrygen/methodpb"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"gopkg.in/yaml.v3"
+)
+
+// openAPIVersion is the version of the OpenAPI specification the documents
+// follow.
+const openAPIVersion = "3.1.0"
+
+// defaultVersion is the version of the API when none is set with
+// openapiv2.Swagger.
+const defaultVersion = "1.0.0"
+
+// statusSchema is the name of the schema of the errors returned by the
+// methods, following grpc-gateway.
+const statusSchema = "google.rpc.Status"
+
+// Run writes to w the OpenAPI document of the Gunk packages matching patterns,
+// in the given format: json, the default, or yaml.
+func Run(w io.Writer, format, dir string, patterns ...string) error {
+	fds, files, err := generate.RequestedFileDescriptorSet(dir, patterns...)
+	if err != nil {
+		return err
+	}
+	data, err := Generate(fds, files, format)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Generate returns the OpenAPI document of the HTTP bindings of the services
+// of files, in the given format: json, the default, or yaml. fds must hold
+// files along with all their dependencies.
+func Generate(fds *descriptorpb.FileDescriptorSet, files []string, format string) ([]byte, error) {
+	reg, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, err
+	}
+	doc := newDocument()
+	for _, name := range files {
+		fd, err := reg.FindFileByPath(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := doc.addFile(fd); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	doc.finish(files, reg)
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case "", "json":
+		return append(data, '\n'), nil
+	case "yaml":
+		return toYAML(data)
+	}
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
+
+// toYAML converts the JSON document data to YAML, keeping the order of its
+// keys.
+func toYAML(data []byte) ([]byte, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	clearStyle(&node)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// clearStyle drops the JSON flow style and quoting of the node, for the
+// encoder to use the usual YAML style.
+func clearStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearStyle(child)
+	}
+}
+
+// The JSON form of an OpenAPI document, as documented in
+// https://spec.openapis.org/oas/v3.1.0.
+type (
+	jsonDocument struct {
+		OpenAPI    string                               `json:"openapi"`
+		Info       jsonInfo                             `json:"info"`
+		Servers    []jsonServer                         `json:"servers,omitempty"`
+		Security   []map[string][]string                `json:"security,omitempty"`
+		Tags       []jsonTag                            `json:"tags,omitempty"`
+		Paths      map[string]map[string]*jsonOperation `json:"paths"`
+		Components jsonComponents                       `json:"components"`
+
+		// info is the first info set with openapiv2.Swagger, if any.
+		info *options.Info
+	}
+	jsonInfo struct {
+		Title          string       `json:"title"`
+		Description    string       `json:"description,omitempty"`
+		TermsOfService string       `json:"termsOfService,omitempty"`
+		Contact        *jsonContact `json:"contact,omitempty"`
+		License        *jsonLicense `json:"license,omitempty"`
+		Version        string       `json:"version"`
+	}
+	jsonContact struct {
+		Name  string `json:"name,omitempty"`
+		URL   string `json:"url,omitempty"`
+		Email string `json:"email,omitempty"`
+	}
+	jsonLicense struct {
+		Name string `json:"name"`
+		URL  string `json:"url,omitempty"`
+	}
+	jsonServer struct {
+		URL string `json:"url"`
+	}
+	jsonTag struct {
+		Name        string `json:"name"`
+		Description string `json:"description,omitempty"`
+	}
+	jsonExternalDocs struct {
+		Description string `json:"description,omitempty"`
+		URL         string `json:"url"`
+	}
+	jsonOperation struct {
+		Tags         []string                `json:"tags,omitempty"`
+		Summary      string                  `json:"summary,omitempty"`
+		Description  string                  `json:"description,omitempty"`
+		ExternalDocs *jsonExternalDocs       `json:"externalDocs,omitempty"`
+		OperationID  string                  `json:"operationId"`
+		Parameters   []jsonParameter         `json:"parameters,omitempty"`
+		RequestBody  *jsonRequestBody        `json:"requestBody,omitempty"`
+		Responses    map[string]jsonResponse `json:"responses"`
+		Deprecated   bool                    `json:"deprecated,omitempty"`
+		Security     []map[string][]string   `json:"security,omitempty"`
+		// Timeout is the default timeout of the method, set with its
+		// gunk.method.timeout option, such as "30s".
+		Timeout string `json:"x-timeout,omitempty"`
+		// Stage is the lifecycle stage of the method, or else of its
+		// service or package, such as "BETA".
+		Stage string `json:"x-stage,omitempty"`
+	}
+	jsonParameter struct {
+		Name        string      `json:"name"`
+		In          string      `json:"in"`
+		Description string      `json:"description,omitempty"`
+		Required    bool        `json:"required,omitempty"`
+		Schema      *jsonSchema `json:"schema"`
+	}
+	jsonRequestBody struct {
+		Required bool                     `json:"required"`
+		Content  map[string]jsonMediaType `json:"content"`
+	}
+	jsonResponse struct {
+		Description string                   `json:"description"`
+		Content     map[string]jsonMediaType `json:"content,omitempty"`
+	}
+	jsonMediaType struct {
+		Schema *jsonSchema `json:"schema"`
+	}
+	jsonComponents struct {
+		Schemas         map[string]*jsonSchema        `json:"schemas,omitempty"`
+		SecuritySchemes map[string]jsonSecurityScheme `json:"securitySchemes,omitempty"`
+	}
+	jsonSecurityScheme struct {
+		Type        string          `json:"type"`
+		Description string          `json:"description,omitempty"`
+		Name        string          `json:"name,omitempty"`
+		In          string          `json:"in,omitempty"`
+		Scheme      string          `json:"scheme,omitempty"`
+		Flows       *jsonOAuthFlows `json:"flows,omitempty"`
+	}
+	jsonOAuthFlows struct {
+		Implicit          *jsonOAuthFlow `json:"implicit,omitempty"`
+		Password          *jsonOAuthFlow `json:"password,omitempty"`
+		ClientCredentials *jsonOAuthFlow `json:"clientCredentials,omitempty"`
+		AuthorizationCode *jsonOAuthFlow `json:"authorizationCode,omitempty"`
+	}
+	jsonOAuthFlow struct {
+		AuthorizationURL string            `json:"authorizationUrl,omitempty"`
+		TokenURL         string            `json:"tokenUrl,omitempty"`
+		Scopes           map[string]string `json:"scopes"`
+	}
+)
+
+func newDocument() *jsonDocument {
+	return &jsonDocument{
+		OpenAPI: openAPIVersion,
+		Paths:   make(map[string]map[string]*jsonOperation),
+		Components: jsonComponents{
+			Schemas:         make(map[string]*jsonSchema),
+			SecuritySchemes: make(map[string]jsonSecurityScheme),
+		},
+	}
+}
+
+// finish fills in the info of the document, once all of files were added.
+func (doc *jsonDocument) finish(files []string, reg *protoregistry.Files) {
+	info := doc.info
+	if info == nil {
+		info = &options.Info{}
+	}
+	doc.Info = jsonInfo{
+		Title:          info.GetTitle(),
+		Description:    info.GetDescription(),
+		TermsOfService: info.GetTermsOfService(),
+		Version:        info.GetVersion(),
+	}
+	if c := info.GetContact(); c != nil {
+		doc.Info.Contact = &jsonContact{Name: c.GetName(), URL: c.GetUrl(), Email: c.GetEmail()}
+	}
+	if l := info.GetLicense(); l != nil {
+		doc.Info.License = &jsonLicense{Name: l.GetName(), URL: l.GetUrl()}
+	}
+	if doc.Info.Title == "" {
+		// Default to the names of the packages, documented with their
+		// comments when there is a single one.
+		var names []string
+		for _, name := range files {
+			fd, _ := reg.FindFileByPath(name)
+			names = append(names, string(fd.Package()))
+			if len(files) == 1 && doc.Info.Description == "" {
+				doc.Info.Description = protoutil.Description(protoutil.PackageComments(fd))
+			}
+		}
+		doc.Info.Title = strings.Join(names, ", ")
+	}
+	if doc.Info.Version == "" {
+		doc.Info.Version = defaultVersion
+	}
+	sort.Slice(doc.Tags, func(i, j int) bool { return doc.Tags[i].Name < doc.Tags[j].Name })
+}
+
+// addFile adds the HTTP bindings of the services of fd to the document, along
+// with its openapiv2.Swagger options.
+func (doc *jsonDocument) addFile(fd protoreflect.FileDescriptor) error {
+	if swagger, ok := proto.GetExtension(fd.Options(), options.E_Openapiv2Swagger).(*options.Swagger); ok && swagger != nil {
+		doc.addSwagger(swagger)
+	}
+	errs := catalogResponses(fd)
+	services := fd.Services()
+	for i := 0; i < services.Len(); i++ {
+		srv := services.Get(i)
+		added := false
+		methods := srv.Methods()
+		for j := 0; j < methods.Len(); j++ {
+			method := methods.Get(j)
+			rule, ok := proto.GetExtension(method.Options(), annotations.E_Http).(*annotations.HttpRule)
+			if !ok || rule == nil {
+				continue
+			}
+			bindings := append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...)
+			for k, binding := range bindings {
+				if err := doc.addBinding(srv, method, binding, k, errs); err != nil {
+					return fmt.Errorf("%s: %v", method.FullName(), err)
+				}
+			}
+			added = true
+		}
+		if added {
+			doc.Tags = append(doc.Tags, jsonTag{
+				Name:        string(srv.Name()),
+				Description: protoutil.Description(protoutil.Comments(srv)),
+			})
+		}
+	}
+	return nil
+}
+
+// catalogResponses returns the descriptions of the error responses of the
+// error catalogs of fd, keyed by HTTP status, listing the reasons of their
+// errors.
+func catalogResponses(fd protoreflect.FileDescriptor) map[string]string {
+	errs := make(map[string]string)
+	enums := fd.Enums()
+	for i := 0; i < enums.Len(); i++ {
+		enum := enums.Get(i)
+		if !proto.HasExtension(enum.Options(), errorspb.E_Catalog) {
+			continue
+		}
+		values := enum.Values()
+		for j := 0; j < values.Len(); j++ {
+			value := values.Get(j)
+			e, _ := proto.GetExtension(value.Options(), errorspb.E_Error).(*errorspb.Error)
+			if e == nil {
+				continue
+			}
+			code := strconv.Itoa(int(e.GetHttpStatus()))
+			if errs[code] != "" {
+				errs[code] += "\n"
+			}
+			errs[code] += fmt.Sprintf("%s: %s", value.Name(), e.GetMessage())
+		}
+	}
+	return errs
+}
+
+// addSwagger adds the servers, security schemes and security requirements set
+// with openapiv2.Swagger to the document. The info of the first file setting
+// one is kept.
+func (doc *jsonDocument) addSwagger(swagger *options.Swagger) {
+	if doc.info == nil && swagger.GetInfo() != nil {
+		doc.info = swagger.GetInfo()
+	}
+	if host, base := swagger.GetHost(), swagger.GetBasePath(); host != "" || base != "" {
+		schemes := swagger.GetSchemes()
+		if len(schemes) == 0 {
+			schemes = []options.Scheme{options.Scheme_HTTPS}
+		}
+		for _, scheme := range schemes {
+			url := base
+			if host != "" {
+				url = strings.ToLower(scheme.String()) + "://" + host + base
+			}
+			doc.addServer(url)
+		}
+	}
+	for name, scheme := range swagger.GetSecurityDefinitions().GetSecurity() {
+		doc.Components.SecuritySchemes[name] = securityScheme(scheme)
+	}
+	doc.Security = append(doc.Security, securityRequirements(swagger.GetSecurity())...)
+}
+
+// addServer adds a server to the document, unless already listed.
+func (doc *jsonDocument) addServer(url string) {
+	for _, srv := range doc.Servers {
+		if srv.URL == url {
+			return
+		}
+	}
+	doc.Servers = append(doc.Servers, jsonServer{URL: url})
+}
+
+// securityScheme returns the OpenAPI 3 form of an OpenAPI 2 security scheme.
+func securityScheme(scheme *options.SecurityScheme) jsonSecurityScheme {
+	s := jsonSecurityScheme{Description: scheme.GetDescription()}
+	switch scheme.GetType() {
+	case options.SecurityScheme_TYPE_BASIC:
+		s.Type = "http"
+		s.Scheme = "basic"
+	case options.SecurityScheme_TYPE_API_KEY:
+		s.Type = "apiKey"
+		s.Name = scheme.GetName()
+		s.In = strings.ToLower(strings.TrimPrefix(scheme.GetIn().String(), "IN_"))
+	case options.SecurityScheme_TYPE_OAUTH2:
+		s.Type = "oauth2"
+		flow := &jsonOAuthFlow{
+			AuthorizationURL: scheme.GetAuthorizationUrl(),
+			TokenURL:         scheme.GetTokenUrl(),
+			Scopes:           scheme.GetScopes().GetScope(),
+		}
+		if flow.Scopes == nil {
+			flow.Scopes = map[string]string{}
+		}
+		s.Flows = &jsonOAuthFlows{}
+		switch scheme.GetFlow() {
+		case options.SecurityScheme_FLOW_IMPLICIT:
+			s.Flows.Implicit = flow
+		case options.SecurityScheme_FLOW_PASSWORD:
+			s.Flows.Password = flow
+		case options.SecurityScheme_FLOW_APPLICATION:
+			s.Flows.ClientCredentials = flow
+		case options.SecurityScheme_FLOW_ACCESS_CODE:
+			s.Flows.AuthorizationCode = flow
+		}
+	}
+	return s
+}
+
+// securityRequirements returns the OpenAPI 3 form of OpenAPI 2 security
+// requirements.
+func securityRequirements(reqs []*options.SecurityRequirement) []map[string][]string {
+	var list []map[string][]string
+	for _, req := range reqs {
+		m := make(map[string][]string)
+		for name, value := range req.GetSecurityRequirement() {
+			scopes := value.GetScope()
+			if scopes == nil {
+				scopes = []string{}
+			}
+			m[name] = scopes
+		}
+		list = append(list, m)
+	}
+	return list
+}
+
+// pathVariable matches the variables of an HTTP path template, such as
+// {Name} or {Name=shelves/*}.
+var pathVariable = regexp.MustCompile(`\{([^}=]+)(=[^}]*)?\}`)
+
+// addBinding adds an operation for binding, the index-th HTTP binding of
+// method, to the document.
+// methodStage returns the lifecycle stage of method, which is the one of its
+// service or package unless set, or an empty string.
+func methodStage(method protoreflect.MethodDescriptor) string {
+	srv := method.Parent().(protoreflect.ServiceDescriptor)
+	for _, opt := range []struct {
+		opts protoreflect.ProtoMessage
+		ext  protoreflect.ExtensionType
+	}{
+		{method.Options(), lifecyclepb.E_MethodStage},
+		{srv.Options(), lifecyclepb.E_ServiceStage},
+		{srv.ParentFile().Options(), lifecyclepb.E_FileStage},
+	} {
+		if s, ok := proto.GetExtension(opt.opts, opt.ext).(lifecyclepb.Stage); ok && s != lifecyclepb.Stage_STAGE_UNSPECIFIED {
+			return s.String()
+		}
+	}
+	return ""
+}
+
+func (doc *jsonDocument) addBinding(srv protoreflect.ServiceDescriptor, method protoreflect.MethodDescriptor, binding *annotations.HttpRule, index int, errs map[string]string) error {
+	verb, path := httpVerbPath(binding)
+	if verb == "" {
+		// OpenAPI can't describe custom verbs other than the
+		// standard ones.
+		return nil
+	}
+	op := &jsonOperation{
+		Tags:        []string{string(srv.Name())},
+		Description: protoutil.Description(protoutil.Comments(method)),
+		OperationID: string(srv.Name()) + "_" + string(method.Name()),
+		Responses:   make(map[string]jsonResponse),
+	}
+	if o, ok := proto.GetExtension(method.Options(), options.E_Openapiv2Operation).(*options.Operation); ok && o != nil {
+		if len(o.GetTags()) > 0 {
+			op.Tags = o.GetTags()
+		}
+		op.Summary = o.GetSummary()
+		if o.GetDescription() != "" {
+			op.Description = o.GetDescription()
+		}
+		if docs := o.GetExternalDocs(); docs != nil {
+			op.ExternalDocs = &jsonExternalDocs{Description: docs.GetDescription(), URL: docs.GetUrl()}
+		}
+		if o.GetOperationId() != "" {
+			op.OperationID = o.GetOperationId()
+		}
+		op.Deprecated = o.GetDeprecated()
+		op.Security = securityRequirements(o.GetSecurity())
+		for code, resp := range o.GetResponses() {
+			op.Responses[code] = jsonResponse{Description: resp.GetDescription()}
+		}
+	}
+	if d, ok := proto.GetExtension(method.Options(), methodpb.E_Timeout).(*durationpb.Duration); ok && d != nil {
+		// As in the JSON form of google.protobuf.Duration.
+		op.Timeout = strconv.FormatFloat(d.AsDuration().Seconds(), 'f', -1, 64) + "s"
+	}
+	op.Stage = methodStage(method)
+	if index > 0 {
+		// Operation IDs must be unique.
+		op.OperationID += fmt.Sprintf("_%d", index)
+	}
+	// The fields of the input not bound to the path or the body are
+	// query parameters.
+	input := method.Input()
+	bound := make(map[string]bool)
+	for _, m := range pathVariable.FindAllStringSubmatch(path, -1) {
+		name := m[1]
+		field, err := fieldByPath(input, name)
+		if err != nil {
+			return err
+		}
+		bound[strings.Split(name, ".")[0]] = true
+		op.Parameters = append(op.Parameters, jsonParameter{
+			Name:        name,
+			In:          "path",
+			Description: protoutil.Description(protoutil.Comments(field)),
+			Required:    true,
+			Schema:      doc.fieldSchema(field),
+		})
+	}
+	switch body := binding.GetBody(); body {
+	case "":
+	case "*":
+		op.RequestBody = doc.requestBody(doc.addSchema(input))
+	default:
+		field, err := fieldByPath(input, body)
+		if err != nil {
+			return err
+		}
+		bound[body] = true
+		op.RequestBody = doc.requestBody(doc.fieldSchema(field))
+	}
+	if binding.GetBody() != "*" {
+		op.Parameters = append(op.Parameters, doc.queryParameters(input, bound)...)
+	}
+	var output *jsonSchema
+	if rb := binding.GetResponseBody(); rb != "" {
+		field, err := fieldByPath(method.Output(), rb)
+		if err != nil {
+			return err
+		}
+		output = doc.fieldSchema(field)
+	} else {
+		output = doc.addSchema(method.Output())
+	}
+	ok := op.Responses["200"]
+	if ok.Description == "" {
+		ok.Description = "A successful response."
+	}
+	ok.Content = map[string]jsonMediaType{"application/json": {Schema: output}}
+	op.Responses["200"] = ok
+	for code, desc := range errs {
+		if _, set := op.Responses[code]; !set {
+			op.Responses[code] = jsonResponse{
+				Description: desc,
+				Content:     map[string]jsonMediaType{"application/json": {Schema: doc.addStatusSchema()}},
+			}
+		}
+	}
+	if _, set := op.Responses["default"]; !set {
+		op.Responses["default"] = jsonResponse{
+			Description: "An unexpected error response.",
+			Content:     map[string]jsonMediaType{"application/json": {Schema: doc.addStatusSchema()}},
+		}
+	}
+	path = pathVariable.ReplaceAllString(path, "{$1}")
+	item := doc.Paths[path]
+	if item == nil {
+		item = make(map[string]*jsonOperation)
+		doc.Paths[path] = item
+	}
+	key := strings.ToLower(verb)
+	if _, dup := item[key]; dup {
+		return fmt.Errorf("%s %s is bound more than once", verb, path)
+	}
+	item[key] = op
+	return nil
+}
+
+// httpVerbPath returns the verb and path template of binding. The verb is
+// empty for custom verbs which OpenAPI can't describe.
+func httpVerbPath(binding *annotations.HttpRule) (verb, path string) {
+	switch pattern := binding.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		return "GET", pattern.Get
+	case *annotations.HttpRule_Put:
+		return "PUT", pattern.Put
+	case *annotations.HttpRule_Post:
+		return "POST", pattern.Post
+	case *annotations.HttpRule_Delete:
+		return "DELETE", pattern.Delete
+	case *annotations.HttpRule_Patch:
+		return "PATCH", pattern.Patch
+	case *annotations.HttpRule_Custom:
+		switch kind := pattern.Custom.GetKind(); kind {
+		case "HEAD", "OPTIONS", "TRACE":
+			return kind, pattern.Custom.GetPath()
+		}
+	}
+	return "", ""
+}
+
+// requestBody returns a required JSON request body with the given schema.
+func (doc *jsonDocument) requestBody(schema *jsonSchema) *jsonRequestBody {
+	return &jsonRequestBody{
+		Required: true,
+		Content:  map[string]jsonMediaType{"application/json": {Schema: schema}},
+	}
+}
+
+// queryParameters returns the query parameters of the fields of msg not
+// bound to the path or the body. Only scalar and enum fields, along with the
+// lists of them, can be set in the query.
+func (doc *jsonDocument) queryParameters(msg protoreflect.MessageDescriptor, bound map[string]bool) []jsonParameter {
+	var params []jsonParameter
+	fields := msg.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		if bound[string(field.Name())] || field.IsMap() || field.Message() != nil {
+			continue
+		}
+		params = append(params, jsonParameter{
+			Name:        field.JSONName(),
+			In:          "query",
+			Description: protoutil.Description(protoutil.Comments(field)),
+			Schema:      doc.fieldSchema(field),
+		})
+	}
+	return params
+}
+
+// fieldByPath returns the field of msg at path, a dot-separated list of field
+// names.
+func fieldByPath(msg protoreflect.MessageDescriptor, path string) (protoreflect.FieldDescriptor, error) {
+	var field protoreflect.FieldDescriptor
+	for _, name := range strings.Split(path, ".") {
+		if msg == nil {
+			return nil, fmt.Errorf("field path %q goes through a non-message field", path)
+		}
+		field = msg.Fields().ByName(protoreflect.Name(name))
+		if field == nil {
+			field = msg.Fields().ByJSONName(name)
+		}
+		if field == nil {
+			return nil, fmt.Errorf("%s has no field %q", msg.FullName(), name)
+		}
+		msg = field.Message()
+	}
+	return field, nil
+}
@@ -0,0 +1,183 @@
+package openapi
+
+import (
+	"encoding/json"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/protoc-gen-openapiv2/options"
+	"github.com/gunk/gunk/protoutil"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// jsonSchema is a JSON schema of the protobuf JSON mapping of a type.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	ReadOnly             bool                   `json:"readOnly,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Examples             []json.RawMessage      `json:"examples,omitempty"`
+}
+
+// addSchema adds the schema of the message or enum desc to the schemas of the
+// document, along with the schemas of the types it refers to, and returns a
+// reference to it.
+func (doc *jsonDocument) addSchema(desc protoreflect.Descriptor) *jsonSchema {
+	name := string(desc.FullName())
+	ref := &jsonSchema{Ref: "#/components/schemas/" + name}
+	if _, ok := doc.Components.Schemas[name]; ok {
+		return ref
+	}
+	schema := &jsonSchema{Description: protoutil.Description(protoutil.Comments(desc))}
+	// Add the schema before its fields, as they may refer to it.
+	doc.Components.Schemas[name] = schema
+	switch desc := desc.(type) {
+	case protoreflect.EnumDescriptor:
+		schema.Type = "string"
+		values := desc.Values()
+		for i := 0; i < values.Len(); i++ {
+			schema.Enum = append(schema.Enum, string(values.Get(i).Name()))
+		}
+	case protoreflect.MessageDescriptor:
+		schema.Type = "object"
+		schema.Properties = make(map[string]*jsonSchema)
+		fields := desc.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			field := fields.Get(i)
+			fs := doc.fieldSchema(field)
+			// From OpenAPI 3.1 on, references may be annotated
+			// too.
+			fs.Description = protoutil.Description(protoutil.Comments(field))
+			if o, ok := proto.GetExtension(field.Options(), options.E_Openapiv2Field).(*options.JSONSchema); ok && o != nil {
+				applyJSONSchema(fs, o)
+			}
+			schema.Properties[field.JSONName()] = fs
+		}
+		if o, ok := proto.GetExtension(desc.Options(), options.E_Openapiv2Schema).(*options.Schema); ok && o != nil {
+			applyJSONSchema(schema, o.GetJsonSchema())
+			for _, name := range o.GetJsonSchema().GetRequired() {
+				// Refer to the fields by their JSON name.
+				if field := desc.Fields().ByName(protoreflect.Name(name)); field != nil {
+					name = field.JSONName()
+				}
+				schema.Required = append(schema.Required, name)
+			}
+			schema.ReadOnly = schema.ReadOnly || o.GetReadOnly()
+			if o.GetExample() != "" {
+				schema.Examples = append(schema.Examples, example(o.GetExample()))
+			}
+		}
+	}
+	return ref
+}
+
+// applyJSONSchema sets the annotations of schema from an openapiv2 JSON
+// schema.
+func applyJSONSchema(schema *jsonSchema, o *options.JSONSchema) {
+	if o.GetTitle() != "" {
+		schema.Title = o.GetTitle()
+	}
+	if o.GetDescription() != "" {
+		schema.Description = o.GetDescription()
+	}
+	if o.GetFormat() != "" {
+		schema.Format = o.GetFormat()
+	}
+	if o.GetPattern() != "" {
+		schema.Pattern = o.GetPattern()
+	}
+	schema.ReadOnly = schema.ReadOnly || o.GetReadOnly()
+	if o.GetExample() != "" {
+		schema.Examples = append(schema.Examples, example(o.GetExample()))
+	}
+}
+
+// example returns the JSON value of an example, which is kept as a string when
+// it isn't valid JSON.
+func example(s string) json.RawMessage {
+	if json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+	data, _ := json.Marshal(s)
+	return data
+}
+
+// addStatusSchema adds the schema of the errors returned by the methods to the
+// document, and returns a reference to it.
+func (doc *jsonDocument) addStatusSchema() *jsonSchema {
+	doc.Components.Schemas[statusSchema] = &jsonSchema{
+		Type:        "object",
+		Description: "The error returned by a method, as a gRPC status.",
+		Properties: map[string]*jsonSchema{
+			"code":    {Type: "integer", Format: "int32"},
+			"message": {Type: "string"},
+			"details": {Type: "array", Items: &jsonSchema{Type: "object"}},
+		},
+	}
+	return &jsonSchema{Ref: "#/components/schemas/" + statusSchema}
+}
+
+// fieldSchema returns the schema of the values of field.
+func (doc *jsonDocument) fieldSchema(field protoreflect.FieldDescriptor) *jsonSchema {
+	switch {
+	case field.IsMap():
+		return &jsonSchema{
+			Type:                 "object",
+			AdditionalProperties: doc.kindSchema(field.MapValue()),
+		}
+	case field.IsList():
+		return &jsonSchema{
+			Type:  "array",
+			Items: doc.kindSchema(field),
+		}
+	}
+	return doc.kindSchema(field)
+}
+
+// kindSchema returns the schema of a single value of field, following the
+// protobuf JSON mapping.
+func (doc *jsonDocument) kindSchema(field protoreflect.FieldDescriptor) *jsonSchema {
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		return &jsonSchema{Type: "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return &jsonSchema{Type: "integer", Format: "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return &jsonSchema{Type: "integer", Format: "uint32"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		// 64-bit integers are encoded as strings.
+		return &jsonSchema{Type: "string", Format: "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return &jsonSchema{Type: "string", Format: "uint64"}
+	case protoreflect.FloatKind:
+		return &jsonSchema{Type: "number", Format: "float"}
+	case protoreflect.DoubleKind:
+		return &jsonSchema{Type: "number", Format: "double"}
+	case protoreflect.StringKind:
+		return &jsonSchema{Type: "string"}
+	case protoreflect.BytesKind:
+		return &jsonSchema{Type: "string", Format: "byte"}
+	case protoreflect.EnumKind:
+		return doc.addSchema(field.Enum())
+	}
+	msg := field.Message()
+	switch msg.FullName() {
+	case "google.protobuf.Timestamp":
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	case "google.protobuf.Duration":
+		return &jsonSchema{Type: "string"}
+	}
+	if msg.ParentFile().Package() == "google.protobuf" {
+		// The other well-known types have special JSON mappings,
+		// such as Struct and Any; leave them unconstrained.
+		return &jsonSchema{}
+	}
+	return doc.addSchema(msg)
+}
@@ -0,0 +1,193 @@
+package generate
+
+import (
+	"errors"
+	"strconv"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+const (
+	attributePackage = protogen.GoImportPath("go.opentelemetry.io/otel/attribute")
+	codesPackage     = protogen.GoImportPath("go.opentelemetry.io/otel/codes")
+	contextPackage   = protogen.GoImportPath("context")
+	metricPackage    = protogen.GoImportPath("go.opentelemetry.io/otel/metric")
+	otelPackage      = protogen.GoImportPath("go.opentelemetry.io/otel")
+	statusPackage    = protogen.GoImportPath("google.golang.org/grpc/status")
+	timePackage      = protogen.GoImportPath("time")
+	tracePackage     = protogen.GoImportPath("go.opentelemetry.io/otel/trace")
+)
+
+// Options selects the instrumentation to generate.
+type Options struct {
+	// Tracing creates a server span for each call.
+	Tracing bool
+	// Metrics records the duration of each call in the
+	// rpc.server.duration histogram.
+	Metrics bool
+}
+
+// Generate generates, for each service of the files to generate, a wrapper
+// of its gRPC server which instruments each call with OpenTelemetry. The
+// attributes of the spans and metrics follow the semantic conventions for
+// RPCs, with the service and method names from the descriptors.
+func Generate(gen *protogen.Plugin, opts Options) error {
+	if !opts.Tracing && !opts.Metrics {
+		return errors.New("tracing and metrics are both disabled")
+	}
+	for _, f := range gen.Files {
+		if !f.Generate || len(f.Services) == 0 {
+			continue
+		}
+		g := gen.NewGeneratedFile(f.GeneratedFilenamePrefix+".otel.go", f.GoImportPath)
+		g.P(`// Code generated by "otelgen"; DO NOT EDIT.`)
+		g.P("// source: ", f.Desc.Path())
+		g.P()
+		g.P("package ", f.GoPackageName)
+		generateInstrumentation(g, string(f.GoImportPath), opts)
+		for _, srv := range f.Services {
+			generateServer(g, srv, opts)
+		}
+	}
+	return nil
+}
+
+// generateInstrumentation generates the instrumentation type shared by the
+// servers of a file, named after its Go package.
+func generateInstrumentation(g *protogen.GeneratedFile, name string, opts Options) {
+	g.P()
+	g.P("// instrumentation records the calls to the servers of this package.")
+	g.P("type instrumentation struct {")
+	if opts.Tracing {
+		g.P("tracer   ", tracePackage.Ident("Tracer"))
+	}
+	if opts.Metrics {
+		g.P("duration ", metricPackage.Ident("Float64Histogram"))
+	}
+	g.P("}")
+	g.P()
+	g.P("// newInstrumentation returns an instrumentation using the global OpenTelemetry")
+	g.P("// providers.")
+	g.P("func newInstrumentation() *instrumentation {")
+	g.P("in := &instrumentation{}")
+	if opts.Tracing {
+		g.P("in.tracer = ", otelPackage.Ident("Tracer"), "(", strconv.Quote(name), ")")
+	}
+	if opts.Metrics {
+		g.P("meter := ", otelPackage.Ident("GetMeterProvider"), "().Meter(", strconv.Quote(name), ")")
+		g.P("duration, err := meter.Float64Histogram(")
+		g.P(`"rpc.server.duration",`)
+		g.P(metricPackage.Ident("WithDescription"), `("Measures the duration of inbound RPC."),`)
+		g.P(metricPackage.Ident("WithUnit"), `("ms"),`)
+		g.P(")")
+		g.P("if err != nil {")
+		g.P(otelPackage.Ident("Handle"), "(err)")
+		g.P("}")
+		g.P("in.duration = duration")
+	}
+	g.P("return in")
+	g.P("}")
+	g.P()
+	g.P("// start starts recording a call to method of service, and returns the context")
+	g.P("// of the call along with the func to call with its error once it is done.")
+	g.P("func (in *instrumentation) start(ctx ", contextPackage.Ident("Context"), ", service, method string) (", contextPackage.Ident("Context"), ", func(error)) {")
+	if opts.Metrics {
+		g.P("begin := ", timePackage.Ident("Now"), "()")
+	}
+	g.P("attrs := []", attributePackage.Ident("KeyValue"), "{")
+	g.P(attributePackage.Ident("String"), `("rpc.system", "grpc"),`)
+	g.P(attributePackage.Ident("String"), `("rpc.service", service),`)
+	g.P(attributePackage.Ident("String"), `("rpc.method", method),`)
+	g.P("}")
+	if opts.Tracing {
+		g.P("ctx, span := in.tracer.Start(ctx, service+\"/\"+method,")
+		g.P(tracePackage.Ident("WithSpanKind"), "(", tracePackage.Ident("SpanKindServer"), "),")
+		g.P(tracePackage.Ident("WithAttributes"), "(attrs...),")
+		g.P(")")
+	}
+	g.P("return ctx, func(err error) {")
+	g.P("code := ", attributePackage.Ident("Int64"), `("rpc.grpc.status_code", int64(`, statusPackage.Ident("Code"), "(err)))")
+	if opts.Tracing {
+		g.P("if err != nil {")
+		g.P("span.RecordError(err)")
+		g.P("span.SetStatus(", codesPackage.Ident("Error"), ", err.Error())")
+		g.P("}")
+		g.P("span.SetAttributes(code)")
+		g.P("span.End()")
+	}
+	if opts.Metrics {
+		g.P("elapsed := float64(", timePackage.Ident("Since"), "(begin)) / float64(", timePackage.Ident("Millisecond"), ")")
+		g.P("in.duration.Record(ctx, elapsed, ", metricPackage.Ident("WithAttributes"), "(append(attrs, code)...))")
+	}
+	g.P("}")
+	g.P("}")
+}
+
+// generateServer generates the instrumented wrapper of the gRPC server of
+// srv, as generated by protoc-gen-go-grpc.
+func generateServer(g *protogen.GeneratedFile, srv *protogen.Service, opts Options) {
+	server := srv.GoName + "Server"
+	wrapper := "instrumented" + server
+	name := string(srv.Desc.FullName())
+	g.P()
+	g.P("// NewInstrumented", server, " returns a ", server, " calling srv, which")
+	g.P("// records each call with OpenTelemetry, using the global providers.")
+	g.P("func NewInstrumented", server, "(srv ", server, ") ", server, " {")
+	g.P("return &", wrapper, "{", server, ": srv, in: newInstrumentation()}")
+	g.P("}")
+	g.P()
+	g.P("type ", wrapper, " struct {")
+	g.P(server)
+	g.P("in *instrumentation")
+	g.P("}")
+	for _, method := range srv.Methods {
+		generateMethod(g, srv, method, name, opts)
+	}
+}
+
+func generateMethod(g *protogen.GeneratedFile, srv *protogen.Service, method *protogen.Method, service string, opts Options) {
+	server := srv.GoName + "Server"
+	wrapper := "instrumented" + server
+	input := g.QualifiedGoIdent(method.Input.GoIdent)
+	names := strconv.Quote(service) + ", " + strconv.Quote(string(method.Desc.Name()))
+	g.P()
+	if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
+		g.P("func (s *", wrapper, ") ", method.GoName, "(ctx ", contextPackage.Ident("Context"), ", req *", input, ") (*", g.QualifiedGoIdent(method.Output.GoIdent), ", error) {")
+		g.P("ctx, done := s.in.start(ctx, ", names, ")")
+		g.P("resp, err := s.", server, ".", method.GoName, "(ctx, req)")
+		g.P("done(err)")
+		g.P("return resp, err")
+		g.P("}")
+		return
+	}
+	stream := srv.GoName + "_" + method.GoName + "Server"
+	args := "stream"
+	if !method.Desc.IsStreamingClient() {
+		g.P("func (s *", wrapper, ") ", method.GoName, "(req *", input, ", stream ", stream, ") error {")
+		args = "req, stream"
+	} else {
+		g.P("func (s *", wrapper, ") ", method.GoName, "(stream ", stream, ") error {")
+	}
+	if opts.Tracing {
+		// The stream is wrapped to pass on the context of the span.
+		g.P("ctx, done := s.in.start(stream.Context(), ", names, ")")
+		g.P("stream = &instrumented", stream, "{", stream, ": stream, ctx: ctx}")
+	} else {
+		g.P("_, done := s.in.start(stream.Context(), ", names, ")")
+	}
+	g.P("err := s.", server, ".", method.GoName, "(", args, ")")
+	g.P("done(err)")
+	g.P("return err")
+	g.P("}")
+	if opts.Tracing {
+		g.P()
+		g.P("type instrumented", stream, " struct {")
+		g.P(stream)
+		g.P("ctx ", contextPackage.Ident("Context"))
+		g.P("}")
+		g.P()
+		g.P("func (s *instrumented", stream, ") Context() ", contextPackage.Ident("Context"), " {")
+		g.P("return s.ctx")
+		g.P("}")
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/gunk/gunk/otelgen/generate"
+	"github.com/gunk/gunk/plugin"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func main() {
+	plugin.RunMain(new(otelPlugin))
+}
+
+type otelPlugin struct{}
+
+func (p *otelPlugin) Generate(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	var flags flag.FlagSet
+	var opts generate.Options
+	flags.BoolVar(&opts.Tracing, "tracing", true, "create a span for each call")
+	flags.BoolVar(&opts.Metrics, "metrics", true, "record the duration of each call")
+	gen, err := protogen.Options{ParamFunc: flags.Set}.New(req)
+	if err != nil {
+		return nil, err
+	}
+	gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+	if err := generate.Generate(gen, opts); err != nil {
+		return nil, err
+	}
+	return gen.Response(), nil
+}
@@ -0,0 +1,234 @@
+package generate
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	contextPackage = protogen.GoImportPath("context")
+	errorsPackage  = protogen.GoImportPath("errors")
+	grpcPackage    = protogen.GoImportPath("google.golang.org/grpc")
+	protoPackage   = protogen.GoImportPath("google.golang.org/protobuf/proto")
+)
+
+// Generate generates, for each paginated method of the files to generate, an
+// iterator over the items of all its pages, fetching the pages as needed. If
+// withGRPC is set, a helper creating the iterator from the gRPC client of the
+// service is generated too.
+func Generate(gen *protogen.Plugin, withGRPC bool) error {
+	for _, f := range gen.Files {
+		if !f.Generate {
+			continue
+		}
+		var methods []*pageMethod
+		for _, srv := range f.Services {
+			for _, method := range srv.Methods {
+				if m := newPageMethod(method); m != nil {
+					methods = append(methods, m)
+				}
+			}
+		}
+		if len(methods) == 0 {
+			continue
+		}
+		g := gen.NewGeneratedFile(f.GeneratedFilenamePrefix+".pages.go", f.GoImportPath)
+		g.P(`// Code generated by "pagegen"; DO NOT EDIT.`)
+		g.P("// source: ", f.Desc.Path())
+		g.P()
+		g.P("package ", f.GoPackageName)
+		g.P()
+		g.P("// IteratorDone is returned by the Next method of iterators once all the items")
+		g.P("// were returned.")
+		g.P("var IteratorDone = ", errorsPackage.Ident("New"), `("no more items in iterator")`)
+		for _, m := range methods {
+			m.generate(g, withGRPC)
+		}
+	}
+	return nil
+}
+
+// pageMethod is a paginated method, along with the fields of its request and
+// response used for pagination.
+type pageMethod struct {
+	method *protogen.Method
+	// pageToken is the page token of the request, and nextPageToken the
+	// one of the response.
+	pageToken     *protogen.Field
+	nextPageToken *protogen.Field
+	// items is the repeated field of the response holding the items of
+	// a page.
+	items *protogen.Field
+}
+
+// newPageMethod returns the pagination fields of method, or nil if it isn't
+// paginated. As in AIP-158, paginated methods have a page_token string and a
+// page_size integer in their request, and a next_page_token string in their
+// response, along with a repeated field holding the items of the page. If
+// the response has several repeated fields, the first one is used.
+func newPageMethod(method *protogen.Method) *pageMethod {
+	if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
+		return nil
+	}
+	m := &pageMethod{method: method}
+	var pageSize *protogen.Field
+	for _, field := range method.Input.Fields {
+		switch fieldKey(field) {
+		case "pagetoken":
+			m.pageToken = field
+		case "pagesize":
+			pageSize = field
+		}
+	}
+	for _, field := range method.Output.Fields {
+		switch {
+		case fieldKey(field) == "nextpagetoken":
+			m.nextPageToken = field
+		case m.items == nil && field.Desc.IsList():
+			m.items = field
+		}
+	}
+	switch {
+	case !isString(m.pageToken), !isString(m.nextPageToken), m.items == nil:
+		return nil
+	case pageSize == nil, pageSize.Desc.IsList(), !isInteger(pageSize.Desc.Kind()):
+		return nil
+	}
+	return m
+}
+
+// fieldKey returns the name of field in lower case and without underscores,
+// so that both PageToken and page_token are recognised.
+func fieldKey(field *protogen.Field) string {
+	return strings.ToLower(strings.ReplaceAll(string(field.Desc.Name()), "_", ""))
+}
+
+func isString(field *protogen.Field) bool {
+	return field != nil && !field.Desc.IsList() && field.Oneof == nil && field.Desc.Kind() == protoreflect.StringKind
+}
+
+func isInteger(kind protoreflect.Kind) bool {
+	switch kind {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return true
+	}
+	return false
+}
+
+func (m *pageMethod) generate(g *protogen.GeneratedFile, withGRPC bool) {
+	srv := m.method.Parent.GoName
+	name := srv + m.method.GoName
+	input := g.QualifiedGoIdent(m.method.Input.GoIdent)
+	output := g.QualifiedGoIdent(m.method.Output.GoIdent)
+	item := itemType(g, m.items)
+	fetch := "func(" + g.QualifiedGoIdent(contextPackage.Ident("Context")) + ", *" + input + ") (*" + output + ", error)"
+
+	g.P()
+	g.P("// ", name, "Iterator iterates over the ", m.items.GoName, " of all the pages of")
+	g.P("// ", srv, ".", m.method.GoName, ", fetching the pages as needed.")
+	g.P("type ", name, "Iterator struct {")
+	g.P("ctx   ", contextPackage.Ident("Context"))
+	g.P("fetch ", fetch)
+	g.P("req   *", input)
+	g.P("resp  *", output)
+	g.P("items []", item)
+	g.P("done  bool")
+	g.P("}")
+
+	g.P()
+	g.P("// New", name, "Iterator returns an iterator over the ", m.items.GoName, " of all the")
+	g.P("// pages of ", srv, ".", m.method.GoName, " from req on, fetching each page with fetch, such")
+	g.P("// as over HTTP. req is not modified.")
+	g.P("func New", name, "Iterator(ctx ", contextPackage.Ident("Context"), ", req *", input, ", fetch ", fetch, ") *", name, "Iterator {")
+	g.P("return &", name, "Iterator{")
+	g.P("ctx:   ctx,")
+	g.P("fetch: fetch,")
+	g.P("req:   ", protoPackage.Ident("Clone"), "(req).(*", input, "),")
+	g.P("}")
+	g.P("}")
+
+	if withGRPC {
+		g.P()
+		g.P("// ", name, "All returns an iterator over the ", m.items.GoName, " of all the pages of")
+		g.P("// ", srv, ".", m.method.GoName, " from req on, fetching each page with client.")
+		g.P("func ", name, "All(ctx ", contextPackage.Ident("Context"), ", client ", srv, "Client, req *", input, ", opts ...", grpcPackage.Ident("CallOption"), ") *", name, "Iterator {")
+		g.P("return New", name, "Iterator(ctx, req, func(ctx ", contextPackage.Ident("Context"), ", req *", input, ") (*", output, ", error) {")
+		g.P("return client.", m.method.GoName, "(ctx, req, opts...)")
+		g.P("})")
+		g.P("}")
+	}
+
+	g.P()
+	g.P("// Next returns the next item, fetching the next page if needed. It returns")
+	g.P("// IteratorDone once all the items were returned.")
+	g.P("func (it *", name, "Iterator) Next() (", item, ", error) {")
+	g.P("for len(it.items) == 0 {")
+	g.P("if it.done {")
+	g.P("return ", zeroValue(m.items), ", IteratorDone")
+	g.P("}")
+	g.P("resp, err := it.fetch(it.ctx, it.req)")
+	g.P("if err != nil {")
+	g.P("return ", zeroValue(m.items), ", err")
+	g.P("}")
+	g.P("it.resp = resp")
+	g.P("it.items = resp.Get", m.items.GoName, "()")
+	g.P("it.req.", m.pageToken.GoName, " = resp.Get", m.nextPageToken.GoName, "()")
+	g.P("it.done = it.req.", m.pageToken.GoName, ` == ""`)
+	g.P("}")
+	g.P("item := it.items[0]")
+	g.P("it.items = it.items[1:]")
+	g.P("return item, nil")
+	g.P("}")
+
+	g.P()
+	g.P("// Response returns the response of the last page fetched, or nil if none was")
+	g.P("// fetched yet.")
+	g.P("func (it *", name, "Iterator) Response() *", output, " {")
+	g.P("return it.resp")
+	g.P("}")
+}
+
+// itemType returns the Go type of the items of the repeated field.
+func itemType(g *protogen.GeneratedFile, field *protogen.Field) string {
+	switch field.Desc.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return "*" + g.QualifiedGoIdent(field.Message.GoIdent)
+	case protoreflect.EnumKind:
+		return g.QualifiedGoIdent(field.Enum.GoIdent)
+	case protoreflect.BoolKind:
+		return "bool"
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return "int32"
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return "int64"
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return "uint32"
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return "uint64"
+	case protoreflect.FloatKind:
+		return "float32"
+	case protoreflect.DoubleKind:
+		return "float64"
+	case protoreflect.BytesKind:
+		return "[]byte"
+	}
+	return "string"
+}
+
+// zeroValue returns the zero value of the items of the repeated field.
+func zeroValue(field *protogen.Field) string {
+	switch field.Desc.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind, protoreflect.BytesKind:
+		return "nil"
+	case protoreflect.BoolKind:
+		return "false"
+	case protoreflect.StringKind:
+		return `""`
+	}
+	return "0"
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/gunk/gunk/pagegen/generate"
+	"github.com/gunk/gunk/plugin"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func main() {
+	plugin.RunMain(new(pagePlugin))
+}
+
+type pagePlugin struct{}
+
+func (p *pagePlugin) Generate(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	var flags flag.FlagSet
+	grpc := flags.Bool("grpc", true, "generate helpers iterating with the gRPC clients")
+	gen, err := protogen.Options{ParamFunc: flags.Set}.New(req)
+	if err != nil {
+		return nil, err
+	}
+	gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+	if err := generate.Generate(gen, *grpc); err != nil {
+		return nil, err
+	}
+	return gen.Response(), nil
+}
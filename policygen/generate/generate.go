@@ -0,0 +1,206 @@
+package generate
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// The handlings of unknown fields and enum values.
+const (
+	Reject  = "reject"
+	Discard = "discard"
+	Keep    = "keep"
+)
+
+const (
+	contextPackage      = protogen.GoImportPath("context")
+	fmtPackage          = protogen.GoImportPath("fmt")
+	grpcPackage         = protogen.GoImportPath("google.golang.org/grpc")
+	codesPackage        = protogen.GoImportPath("google.golang.org/grpc/codes")
+	statusPackage       = protogen.GoImportPath("google.golang.org/grpc/status")
+	protoPackage        = protogen.GoImportPath("google.golang.org/protobuf/proto")
+	protojsonPackage    = protogen.GoImportPath("google.golang.org/protobuf/encoding/protojson")
+	protoreflectPackage = protogen.GoImportPath("google.golang.org/protobuf/reflect/protoreflect")
+	runtimePackage      = protogen.GoImportPath("github.com/grpc-ecosystem/grpc-gateway/v2/runtime")
+)
+
+// Policy is the strictness of an API with the requests it receives.
+type Policy struct {
+	// UnknownFields is Reject to reject the requests with unknown fields,
+	// or Discard to accept them, discarding the unknown fields of JSON
+	// requests.
+	UnknownFields string
+	// UnknownEnums is Keep to accept the enum values unknown to an enum,
+	// as proto3 does, or Reject to reject the requests holding them.
+	UnknownEnums string
+}
+
+// Validate returns an error if the policy has unknown handlings.
+func (p Policy) Validate() error {
+	if p.UnknownFields != Reject && p.UnknownFields != Discard {
+		return fmt.Errorf("unknown_fields must be %s or %s, got %q", Reject, Discard, p.UnknownFields)
+	}
+	if p.UnknownEnums != Keep && p.UnknownEnums != Reject {
+		return fmt.Errorf("unknown_enums must be %s or %s, got %q", Keep, Reject, p.UnknownEnums)
+	}
+	return nil
+}
+
+// describe returns the description of the policy, such as "unknown fields are
+// rejected, and unknown enum values are kept".
+func (p Policy) describe() string {
+	fields := "unknown fields are rejected"
+	if p.UnknownFields == Discard {
+		fields = "unknown fields are discarded"
+	}
+	enums := "unknown enum values are kept"
+	if p.UnknownEnums == Reject {
+		enums = "unknown enum values are rejected"
+	}
+	return fields + ", and " + enums
+}
+
+// Generate generates, for each file to generate with messages, the JSON
+// options and checks following policy, so that the strictness of an API is
+// declared once, in its .gunkconfig. If withGRPC is set, gRPC server
+// interceptors enforcing the policy are generated too, and if withGateway is
+// set, a grpc-gateway option setting the JSON options of its marshaler.
+func Generate(gen *protogen.Plugin, policy Policy, withGRPC, withGateway bool) error {
+	for _, f := range gen.Files {
+		if !f.Generate || len(f.Messages) == 0 {
+			continue
+		}
+		g := gen.NewGeneratedFile(f.GeneratedFilenamePrefix+".policy.go", f.GoImportPath)
+		g.P(`// Code generated by "policygen"; DO NOT EDIT.`)
+		g.P("// source: ", f.Desc.Path())
+		g.P()
+		g.P("package ", f.GoPackageName)
+		g.P()
+		g.P("// PolicyJSONUnmarshalOptions are the options to decode JSON requests with:")
+		g.P("// ", policy.describe(), ".")
+		g.P("var PolicyJSONUnmarshalOptions = ", protojsonPackage.Ident("UnmarshalOptions"), "{")
+		g.P("DiscardUnknown: ", policy.UnknownFields == Discard, ",")
+		g.P("}")
+		g.P()
+		g.P("// PolicyJSONMarshalOptions are the options to encode JSON responses with.")
+		g.P("var PolicyJSONMarshalOptions = ", protojsonPackage.Ident("MarshalOptions"), "{}")
+		generateCheck(g, policy)
+		if withGRPC {
+			generateInterceptors(g)
+		}
+		if withGateway {
+			g.P()
+			g.P("// PolicyGatewayOption returns the grpc-gateway option setting the JSON")
+			g.P("// options of its marshaler to PolicyJSONMarshalOptions and")
+			g.P("// PolicyJSONUnmarshalOptions.")
+			g.P("func PolicyGatewayOption() ", runtimePackage.Ident("ServeMuxOption"), " {")
+			g.P("return ", runtimePackage.Ident("WithMarshalerOption"), "(", runtimePackage.Ident("MIMEWildcard"), ", &", runtimePackage.Ident("JSONPb"), "{")
+			g.P("MarshalOptions:   PolicyJSONMarshalOptions,")
+			g.P("UnmarshalOptions: PolicyJSONUnmarshalOptions,")
+			g.P("})")
+			g.P("}")
+		}
+	}
+	return nil
+}
+
+// generateCheck generates CheckPolicy, checking that requests follow policy.
+func generateCheck(g *protogen.GeneratedFile, policy Policy) {
+	fields := policy.UnknownFields == Reject
+	enums := policy.UnknownEnums == Reject
+	g.P()
+	g.P("// CheckPolicy returns an error if msg, or any message it holds, breaks the")
+	g.P("// policy of this package:")
+	g.P("// ", policy.describe(), ".")
+	g.P("func CheckPolicy(msg ", protoPackage.Ident("Message"), ") error {")
+	if !fields && !enums {
+		g.P("return nil")
+		g.P("}")
+		return
+	}
+	g.P("return checkPolicy(msg.ProtoReflect())")
+	g.P("}")
+	g.P()
+	g.P("func checkPolicy(m ", protoreflectPackage.Ident("Message"), ") error {")
+	if fields {
+		g.P("if len(m.GetUnknown()) > 0 {")
+		g.P("return ", fmtPackage.Ident("Errorf"), `("%s has unknown fields", m.Descriptor().FullName())`)
+		g.P("}")
+	}
+	g.P("var err error")
+	g.P("m.Range(func(fd ", protoreflectPackage.Ident("FieldDescriptor"), ", v ", protoreflectPackage.Ident("Value"), ") bool {")
+	g.P("switch {")
+	g.P("case fd.IsList():")
+	g.P("list := v.List()")
+	g.P("for i := 0; i < list.Len() && err == nil; i++ {")
+	g.P("err = checkPolicyValue(fd, list.Get(i))")
+	g.P("}")
+	g.P("case fd.IsMap():")
+	g.P("v.Map().Range(func(_ ", protoreflectPackage.Ident("MapKey"), ", v ", protoreflectPackage.Ident("Value"), ") bool {")
+	g.P("err = checkPolicyValue(fd.MapValue(), v)")
+	g.P("return err == nil")
+	g.P("})")
+	g.P("default:")
+	g.P("err = checkPolicyValue(fd, v)")
+	g.P("}")
+	g.P("return err == nil")
+	g.P("})")
+	g.P("return err")
+	g.P("}")
+	g.P()
+	g.P("func checkPolicyValue(fd ", protoreflectPackage.Ident("FieldDescriptor"), ", v ", protoreflectPackage.Ident("Value"), ") error {")
+	g.P("switch {")
+	g.P("case fd.Message() != nil:")
+	g.P("return checkPolicy(v.Message())")
+	if enums {
+		g.P("case fd.Enum() != nil && fd.Enum().Values().ByNumber(v.Enum()) == nil:")
+		g.P("return ", fmtPackage.Ident("Errorf"), `("%s has unknown enum value %d", fd.FullName(), v.Enum())`)
+	}
+	g.P("}")
+	g.P("return nil")
+	g.P("}")
+}
+
+// generateInterceptors generates the gRPC server interceptors rejecting the
+// requests breaking the policy.
+func generateInterceptors(g *protogen.GeneratedFile) {
+	g.P()
+	g.P("// PolicyUnaryServerInterceptor returns a gRPC server interceptor rejecting the")
+	g.P("// requests for which CheckPolicy returns an error, as invalid arguments.")
+	g.P("func PolicyUnaryServerInterceptor() ", grpcPackage.Ident("UnaryServerInterceptor"), " {")
+	g.P("return func(ctx ", contextPackage.Ident("Context"), ", req interface{}, info *", grpcPackage.Ident("UnaryServerInfo"), ", handler ", grpcPackage.Ident("UnaryHandler"), ") (interface{}, error) {")
+	g.P("if msg, ok := req.(", protoPackage.Ident("Message"), "); ok {")
+	g.P("if err := CheckPolicy(msg); err != nil {")
+	g.P("return nil, ", statusPackage.Ident("Error"), "(", codesPackage.Ident("InvalidArgument"), ", err.Error())")
+	g.P("}")
+	g.P("}")
+	g.P("return handler(ctx, req)")
+	g.P("}")
+	g.P("}")
+	g.P()
+	g.P("// PolicyStreamServerInterceptor returns a gRPC server interceptor rejecting the")
+	g.P("// streamed requests for which CheckPolicy returns an error, as invalid")
+	g.P("// arguments.")
+	g.P("func PolicyStreamServerInterceptor() ", grpcPackage.Ident("StreamServerInterceptor"), " {")
+	g.P("return func(srv interface{}, ss ", grpcPackage.Ident("ServerStream"), ", info *", grpcPackage.Ident("StreamServerInfo"), ", handler ", grpcPackage.Ident("StreamHandler"), ") error {")
+	g.P("return handler(srv, policyServerStream{ss})")
+	g.P("}")
+	g.P("}")
+	g.P()
+	g.P("type policyServerStream struct {")
+	g.P(grpcPackage.Ident("ServerStream"))
+	g.P("}")
+	g.P()
+	g.P("func (s policyServerStream) RecvMsg(m interface{}) error {")
+	g.P("if err := s.ServerStream.RecvMsg(m); err != nil {")
+	g.P("return err")
+	g.P("}")
+	g.P("if msg, ok := m.(", protoPackage.Ident("Message"), "); ok {")
+	g.P("if err := CheckPolicy(msg); err != nil {")
+	g.P("return ", statusPackage.Ident("Error"), "(", codesPackage.Ident("InvalidArgument"), ", err.Error())")
+	g.P("}")
+	g.P("}")
+	g.P("return nil")
+	g.P("}")
+}
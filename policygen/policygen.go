@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/gunk/gunk/plugin"
+	"github.com/gunk/gunk/policygen/generate"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func main() {
+	plugin.RunMain(new(policyPlugin))
+}
+
+type policyPlugin struct{}
+
+func (p *policyPlugin) Generate(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	var flags flag.FlagSet
+	var policy generate.Policy
+	flags.StringVar(&policy.UnknownFields, "unknown_fields", generate.Reject, "handling of unknown fields: reject or discard")
+	flags.StringVar(&policy.UnknownEnums, "unknown_enums", generate.Keep, "handling of unknown enum values: keep or reject")
+	grpc := flags.Bool("grpc", true, "generate gRPC server interceptors enforcing the policy")
+	gateway := flags.Bool("gateway", false, "generate the grpc-gateway marshaler option following the policy")
+	gen, err := protogen.Options{ParamFunc: flags.Set}.New(req)
+	if err != nil {
+		return nil, err
+	}
+	gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	if err := generate.Generate(gen, policy, *grpc, *gateway); err != nil {
+		return nil, err
+	}
+	return gen.Response(), nil
+}
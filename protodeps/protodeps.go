@@ -0,0 +1,360 @@
+// Package protodeps implements `gunk vendor`, which downloads the third-party
+// proto files declared in the [proto_dep <name>] sections of .gunkconfig
+// files into the Gunk cache, pinning their checksums in gunk.lock files.
+//
+// The vendored files are then added to the include path used to load the
+// proto dependencies of Gunk packages, so that builds use the pinned files
+// rather than whatever protoc include path exists on the machine.
+package protodeps
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gunk/gunk/config"
+	"github.com/gunk/gunk/generate/downloader"
+	"github.com/gunk/gunk/interrupt"
+	"github.com/rogpeppe/go-internal/lockedfile"
+)
+
+// LockFile is the name of the file pinning the proto dependencies of a
+// .gunkconfig, in the same directory.
+const LockFile = "gunk.lock"
+
+// lockHeader starts each lock file.
+const lockHeader = "# Code generated by \"gunk vendor\"; DO NOT EDIT.\n"
+
+// downloadTimeout bounds the download of an archive.
+const downloadTimeout = 5 * time.Minute
+
+// Lock is an entry of a lock file, pinning the archive of a proto dependency
+// to its checksum.
+type Lock struct {
+	Name string
+	URL  string
+	Sum  string // checksum of the archive, as in "sha256:<hex>"
+}
+
+// Run vendors the proto dependencies of the config in dir: it downloads the
+// ones missing from the cache, checks them against their lock entries, and
+// writes the lock files, adding the new dependencies and dropping the ones
+// no longer declared. The vendored dependencies are printed to w.
+//
+// The lock entry of a dependency whose url changed is replaced. To update a
+// dependency whose url is unchanged, remove its lock entry.
+func Run(w io.Writer, dir string) error {
+	cfg, err := config.Load(dir)
+	if err != nil {
+		return err
+	}
+	if len(cfg.ProtoDeps) == 0 {
+		return fmt.Errorf("no proto_dep sections in the gunkconfig")
+	}
+	for _, lockDir := range lockDirs(cfg.ProtoDeps) {
+		lockPath := filepath.Join(lockDir, LockFile)
+		locks, err := ReadLock(lockPath)
+		if err != nil {
+			return err
+		}
+		var updated []Lock
+		for _, dep := range cfg.ProtoDeps {
+			if dep.Dir != lockDir {
+				continue
+			}
+			var sum string
+			if lock, ok := locks[dep.Name]; ok && lock.URL == dep.URL {
+				sum = lock.Sum
+			}
+			sum, _, err = fetch(dep, sum)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s %s\n", dep.Name, sum)
+			updated = append(updated, Lock{Name: dep.Name, URL: dep.URL, Sum: sum})
+		}
+		if err := writeLock(lockPath, updated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Includes returns the include directories of the vendored proto
+// dependencies deps, in order. Each dependency must be pinned in its lock
+// file; if it is missing from the cache, it is downloaded again, and must
+// match its pinned checksum.
+func Includes(deps []config.ProtoDep) ([]string, error) {
+	lockFiles := make(map[string]map[string]Lock)
+	var includes []string
+	for _, dep := range deps {
+		lockPath := filepath.Join(dep.Dir, LockFile)
+		locks, ok := lockFiles[lockPath]
+		if !ok {
+			var err error
+			if locks, err = ReadLock(lockPath); err != nil {
+				return nil, err
+			}
+			lockFiles[lockPath] = locks
+		}
+		lock, ok := locks[dep.Name]
+		if !ok || lock.URL != dep.URL {
+			return nil, fmt.Errorf("proto_dep %q is not pinned in %s; run gunk vendor", dep.Name, lockPath)
+		}
+		_, include, err := fetch(dep, lock.Sum)
+		if err != nil {
+			return nil, err
+		}
+		includes = append(includes, include)
+	}
+	return includes, nil
+}
+
+// lockDirs returns the directories of the configs declaring deps, in order.
+func lockDirs(deps []config.ProtoDep) []string {
+	var dirs []string
+	seen := make(map[string]bool)
+	for _, dep := range deps {
+		if !seen[dep.Dir] {
+			seen[dep.Dir] = true
+			dirs = append(dirs, dep.Dir)
+		}
+	}
+	return dirs
+}
+
+// ReadLock reads the entries of a lock file by name. A missing lock file has
+// no entries.
+func ReadLock(lockPath string) (map[string]Lock, error) {
+	data, err := ioutil.ReadFile(lockPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]Lock{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	locks := make(map[string]Lock)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 || !validSum(fields[2]) {
+			return nil, fmt.Errorf("%s:%d: invalid entry, want <name> <url> sha256:<hex>", lockPath, line)
+		}
+		locks[fields[0]] = Lock{Name: fields[0], URL: fields[1], Sum: fields[2]}
+	}
+	return locks, sc.Err()
+}
+
+// validSum reports whether sum is a checksum as in "sha256:<hex>".
+func validSum(sum string) bool {
+	hexSum := strings.TrimPrefix(sum, "sha256:")
+	b, err := hex.DecodeString(hexSum)
+	return hexSum != sum && err == nil && len(b) == sha256.Size
+}
+
+// writeLock writes the entries of a lock file, sorted by name.
+func writeLock(lockPath string, locks []Lock) error {
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Name < locks[j].Name })
+	var buf bytes.Buffer
+	buf.WriteString(lockHeader)
+	for _, lock := range locks {
+		fmt.Fprintf(&buf, "%s %s %s\n", lock.Name, lock.URL, lock.Sum)
+	}
+	return ioutil.WriteFile(lockPath, buf.Bytes(), 0o644)
+}
+
+// fetch makes sure the archive of dep is extracted in the cache, returning
+// its checksum and include directory. If sum is set, the archive is only
+// downloaded if it isn't cached yet, and must match it.
+func fetch(dep config.ProtoDep, sum string) (string, string, error) {
+	cacheDir, err := downloader.CacheDir()
+	if err != nil {
+		return "", "", err
+	}
+	cacheDir = filepath.Join(cacheDir, "protodeps")
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return "", "", err
+	}
+	if sum != "" {
+		dir := filepath.Join(cacheDir, strings.TrimPrefix(sum, "sha256:"))
+		if _, err := os.Stat(dir); err == nil {
+			include, err := includeDir(dep, dir)
+			return sum, include, err
+		}
+	}
+	data, err := readArchive(dep)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to fetch proto_dep %q: %w", dep.Name, err)
+	}
+	hash := sha256.Sum256(data)
+	got := "sha256:" + hex.EncodeToString(hash[:])
+	if sum != "" && got != sum {
+		return "", "", fmt.Errorf("checksum mismatch for proto_dep %q: %s pins %s, but %s has %s", dep.Name, LockFile, sum, dep.URL, got)
+	}
+	dir := filepath.Join(cacheDir, hex.EncodeToString(hash[:]))
+	if err := extract(dep.URL, data, dir); err != nil {
+		return "", "", fmt.Errorf("unable to extract proto_dep %q: %w", dep.Name, err)
+	}
+	include, err := includeDir(dep, dir)
+	return got, include, err
+}
+
+// readArchive downloads the archive of dep, or reads it from disk if its url
+// is a local path.
+func readArchive(dep config.ProtoDep) ([]byte, error) {
+	if !strings.HasPrefix(dep.URL, "https://") && !strings.HasPrefix(dep.URL, "http://") {
+		file := dep.URL
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dep.Dir, file)
+		}
+		return ioutil.ReadFile(file)
+	}
+	cl := &http.Client{Timeout: downloadTimeout}
+	res, err := cl.Get(dep.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not retrieve %q (%d)", dep.URL, res.StatusCode)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+// includeDir returns the include directory of dep, extracted in dir.
+func includeDir(dep config.ProtoDep, dir string) (string, error) {
+	if dep.Root != "" {
+		include := filepath.Join(dir, filepath.FromSlash(dep.Root))
+		if info, err := os.Stat(include); err != nil || !info.IsDir() {
+			return "", fmt.Errorf("root %q of proto_dep %q is not a directory of its archive", dep.Root, dep.Name)
+		}
+		return include, nil
+	}
+	// Archives such as those of GitHub hold a single top-level directory.
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), nil
+	}
+	return dir, nil
+}
+
+// extract writes the .proto files of the archive data, named name, to dir.
+// The files are extracted to a temporary directory first, so that dir is
+// either complete or missing, even when several processes vendor the same
+// archive.
+func extract(name string, data []byte, dir string) error {
+	unlock, err := lockedfile.MutexAt(dir + ".lock").Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	tmpDir := dir + ".tmp"
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+	// Don't leave a partial extraction behind if interrupted.
+	partial := interrupt.Register(func() { os.RemoveAll(tmpDir) })
+	defer partial.Run()
+	write := func(file string, r io.Reader) error {
+		file = path.Clean(strings.TrimPrefix(file, "/"))
+		if path.Ext(file) != ".proto" {
+			return nil
+		}
+		if file == ".." || strings.HasPrefix(file, "../") {
+			return fmt.Errorf("%s: file outside of the archive", file)
+		}
+		dst := filepath.Join(tmpDir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(dst, content, 0o644)
+	}
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		err = extractZip(data, write)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		err = extractTarGz(data, write)
+	default:
+		err = fmt.Errorf("unknown archive format of %s; must be .zip, .tar.gz or .tgz", name)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		return err
+	}
+	return os.Rename(tmpDir, dir)
+}
+
+func extractZip(data []byte, write func(string, io.Reader) error) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = write(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTarGz(data []byte, write func(string, io.Reader) error) error {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := write(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
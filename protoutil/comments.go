@@ -0,0 +1,32 @@
+package protoutil
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// PackageComments returns the leading comments of the package of fd.
+func PackageComments(fd protoreflect.FileDescriptor) string {
+	return fd.SourceLocations().ByPath(protoreflect.SourcePath{packagePath}).LeadingComments
+}
+
+// Comments returns the leading comments of desc, or its trailing ones if it
+// has none.
+func Comments(desc protoreflect.Descriptor) string {
+	loc := desc.ParentFile().SourceLocations().ByDescriptor(desc)
+	if loc.LeadingComments == "" {
+		return loc.TrailingComments
+	}
+	return loc.LeadingComments
+}
+
+// Description returns the text of the comments, without the leading space of
+// each line.
+func Description(comments string) string {
+	lines := strings.Split(strings.TrimSpace(comments), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,752 @@
+package protoutil
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// packagePath is the source path of the package statement of a file.
+const packagePath = 2
+
+// Printer writes the proto source of file descriptors, for the tools which only
+// accept .proto files, such as the BSR. Type names are written fully
+// qualified, so that they resolve the same way regardless of the enclosing
+// scopes.
+type Printer struct {
+	// Relative writes the names of the types of the file's package
+	// relative to it, and other names without the leading dot, as in
+	// hand-written files, for the tools which don't resolve fully
+	// qualified names.
+	Relative bool
+
+	// Header, if not empty, is written as a comment at the top of each
+	// file, such as "gunk:version v1.0.0".
+	Header string
+
+	// Provenance, if set, returns where the element at path in file was
+	// declared, such as "example.com/util/util.gunk:12", or an empty
+	// string if it's unknown. It is written above the element as a
+	// gunk:source comment, separated from its leading comments.
+	Provenance func(file protoreflect.FileDescriptor, path protoreflect.SourcePath) string
+
+	// types resolves the custom options of the files, so that they can be
+	// printed rather than being left as unknown fields.
+	types *protoregistry.Types
+
+	buf    bytes.Buffer
+	indent int
+	pkg    protoreflect.FullName // package of the file being printed
+}
+
+// NewPrinter returns a Printer for the files in files, resolving the custom
+// options declared by any of them.
+func NewPrinter(files *protoregistry.Files) (*Printer, error) {
+	types := new(protoregistry.Types)
+	var err error
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		err = registerExtensions(types, fd.Extensions())
+		for i := 0; i < fd.Messages().Len() && err == nil; i++ {
+			err = registerNestedExtensions(types, fd.Messages().Get(i))
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Printer{types: types}, nil
+}
+
+func registerNestedExtensions(types *protoregistry.Types, md protoreflect.MessageDescriptor) error {
+	if err := registerExtensions(types, md.Extensions()); err != nil {
+		return err
+	}
+	for i := 0; i < md.Messages().Len(); i++ {
+		if err := registerNestedExtensions(types, md.Messages().Get(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func registerExtensions(types *protoregistry.Types, xds protoreflect.ExtensionDescriptors) error {
+	for i := 0; i < xds.Len(); i++ {
+		if err := types.RegisterExtension(dynamicpb.NewExtensionType(xds.Get(i))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrintFile returns the proto source of fd.
+func (p *Printer) PrintFile(fd protoreflect.FileDescriptor) ([]byte, error) {
+	p.buf.Reset()
+	p.indent = 0
+	if err := p.file(fd); err != nil {
+		return nil, fmt.Errorf("%s: %w", fd.Path(), err)
+	}
+	return append([]byte(nil), p.buf.Bytes()...), nil
+}
+
+// line writes a line of args, indented.
+func (p *Printer) line(args ...interface{}) {
+	line := fmt.Sprint(args...)
+	if line != "" {
+		p.buf.WriteString(strings.Repeat("  ", p.indent))
+		p.buf.WriteString(line)
+	}
+	p.buf.WriteByte('\n')
+}
+
+// comments writes the provenance, detached and leading comments of d, if any.
+func (p *Printer) comments(d protoreflect.Descriptor) {
+	loc := d.ParentFile().SourceLocations().ByDescriptor(d)
+	p.provenance(d.ParentFile(), loc.Path)
+	p.leadingComments(loc)
+}
+
+// provenance writes where the element at path in file was declared, if known.
+func (p *Printer) provenance(file protoreflect.FileDescriptor, path protoreflect.SourcePath) {
+	if p.Provenance == nil || path == nil {
+		return
+	}
+	if source := p.Provenance(file, path); source != "" {
+		// Keep it apart from the trailing comments of the previous
+		// element, and from the leading comments of this one.
+		if b := p.buf.Bytes(); len(b) > 0 && !bytes.HasSuffix(b, []byte("\n\n")) && !bytes.HasSuffix(b, []byte("{\n")) {
+			p.line()
+		}
+		p.line("//gunk:source ", source)
+		p.line()
+	}
+}
+
+// leadingComments writes the detached comments of loc, each followed by an
+// empty line, and its leading comments, if any.
+func (p *Printer) leadingComments(loc protoreflect.SourceLocation) {
+	for _, c := range loc.LeadingDetachedComments {
+		p.commentLines(c)
+		p.line()
+	}
+	p.commentLines(loc.LeadingComments)
+}
+
+// commentLines writes the lines of a comment, if any.
+func (p *Printer) commentLines(text string) {
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		p.line("//", line)
+	}
+}
+
+// declLine writes the line of args declaring d, followed by the trailing
+// comments of d, if any. They start on the same line, so that they are read
+// back as trailing comments.
+func (p *Printer) declLine(d protoreflect.Descriptor, args ...interface{}) {
+	p.trailingLine(d.ParentFile().SourceLocations().ByDescriptor(d), args...)
+}
+
+// trailingLine writes the line of args, followed by the trailing comments of
+// loc, if any.
+func (p *Printer) trailingLine(loc protoreflect.SourceLocation, args ...interface{}) {
+	trailing := loc.TrailingComments
+	if trailing == "" {
+		p.line(args...)
+		return
+	}
+	lines := strings.Split(strings.TrimSuffix(trailing, "\n"), "\n")
+	p.line(append(args, " //", lines[0])...)
+	for _, line := range lines[1:] {
+		p.line("//", line)
+	}
+}
+
+func (p *Printer) file(fd protoreflect.FileDescriptor) error {
+	syntax := "proto3"
+	if fd.Syntax() == protoreflect.Proto2 {
+		syntax = "proto2"
+	}
+	if p.Header != "" {
+		p.line("//", p.Header)
+		p.line()
+	}
+	p.line(`syntax = "`, syntax, `";`)
+	p.pkg = fd.Package()
+	if fd.Package() != "" {
+		p.line()
+		// Gunk keeps the package comments on the package statement.
+		path := protoreflect.SourcePath{packagePath}
+		loc := fd.SourceLocations().ByPath(path)
+		p.provenance(fd, path)
+		p.leadingComments(loc)
+		p.trailingLine(loc, "package ", fd.Package(), ";")
+	}
+	if fd.Imports().Len() > 0 {
+		p.line()
+	}
+	for i := 0; i < fd.Imports().Len(); i++ {
+		imp := fd.Imports().Get(i)
+		switch {
+		case imp.IsPublic:
+			p.line(`import public "`, imp.Path(), `";`)
+		case imp.IsWeak:
+			p.line(`import weak "`, imp.Path(), `";`)
+		default:
+			p.line(`import "`, imp.Path(), `";`)
+		}
+	}
+	opts, err := p.options(fd.Options())
+	if err != nil {
+		return err
+	}
+	if len(opts) > 0 {
+		p.line()
+	}
+	for _, opt := range opts {
+		p.line("option ", opt, ";")
+	}
+	for i := 0; i < fd.Enums().Len(); i++ {
+		p.line()
+		if err := p.enum(fd.Enums().Get(i)); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < fd.Messages().Len(); i++ {
+		p.line()
+		if err := p.message(fd.Messages().Get(i)); err != nil {
+			return err
+		}
+	}
+	if err := p.extensions(fd.Extensions()); err != nil {
+		return err
+	}
+	for i := 0; i < fd.Services().Len(); i++ {
+		p.line()
+		if err := p.service(fd.Services().Get(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Printer) message(md protoreflect.MessageDescriptor) error {
+	p.comments(md)
+	p.declLine(md, "message ", md.Name(), " {")
+	p.indent++
+	opts, err := p.options(md.Options())
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		p.line("option ", opt, ";")
+	}
+	p.reserved(md.ReservedRanges(), md.ReservedNames())
+	done := make(map[protoreflect.OneofDescriptor]bool)
+	for i := 0; i < md.Fields().Len(); i++ {
+		fd := md.Fields().Get(i)
+		od := fd.ContainingOneof()
+		if od == nil || od.IsSynthetic() {
+			if err := p.field(fd, true); err != nil {
+				return err
+			}
+			continue
+		}
+		if done[od] {
+			continue
+		}
+		done[od] = true
+		if err := p.oneof(od); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < md.ExtensionRanges().Len(); i++ {
+		r := md.ExtensionRanges().Get(i)
+		p.line("extensions ", fieldRange(r[0], r[1]), ";")
+	}
+	for i := 0; i < md.Enums().Len(); i++ {
+		if err := p.enum(md.Enums().Get(i)); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < md.Messages().Len(); i++ {
+		if md.Messages().Get(i).IsMapEntry() {
+			continue
+		}
+		if err := p.message(md.Messages().Get(i)); err != nil {
+			return err
+		}
+	}
+	if err := p.extensions(md.Extensions()); err != nil {
+		return err
+	}
+	p.indent--
+	p.line("}")
+	return nil
+}
+
+func (p *Printer) reserved(ranges protoreflect.FieldRanges, names protoreflect.Names) {
+	if ranges.Len() > 0 {
+		var rs []string
+		for i := 0; i < ranges.Len(); i++ {
+			r := ranges.Get(i)
+			rs = append(rs, fieldRange(r[0], r[1]))
+		}
+		p.line("reserved ", strings.Join(rs, ", "), ";")
+	}
+	p.reservedNames(names)
+}
+
+func (p *Printer) reservedNames(names protoreflect.Names) {
+	if names.Len() == 0 {
+		return
+	}
+	var ns []string
+	for i := 0; i < names.Len(); i++ {
+		ns = append(ns, strconv.Quote(string(names.Get(i))))
+	}
+	p.line("reserved ", strings.Join(ns, ", "), ";")
+}
+
+// fieldRange returns a range of field numbers, whose end is exclusive, as in
+// reserved and extensions statements.
+func fieldRange(start, end protoreflect.FieldNumber) string {
+	switch {
+	case end == start+1:
+		return strconv.Itoa(int(start))
+	case end > protowire.MaxValidNumber:
+		return fmt.Sprintf("%d to max", start)
+	}
+	return fmt.Sprintf("%d to %d", start, end-1)
+}
+
+func (p *Printer) oneof(od protoreflect.OneofDescriptor) error {
+	p.comments(od)
+	p.declLine(od, "oneof ", od.Name(), " {")
+	p.indent++
+	opts, err := p.options(od.Options())
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		p.line("option ", opt, ";")
+	}
+	for i := 0; i < od.Fields().Len(); i++ {
+		if err := p.field(od.Fields().Get(i), false); err != nil {
+			return err
+		}
+	}
+	p.indent--
+	p.line("}")
+	return nil
+}
+
+// field writes a field or extension. The label is left out of the fields of
+// oneofs, by setting withLabel to false.
+func (p *Printer) field(fd protoreflect.FieldDescriptor, withLabel bool) error {
+	if fd.Kind() == protoreflect.GroupKind {
+		return fmt.Errorf("%s: groups are not supported", fd.FullName())
+	}
+	p.comments(fd)
+	label := ""
+	switch {
+	case !withLabel, fd.IsMap():
+	case fd.Cardinality() == protoreflect.Repeated:
+		label = "repeated "
+	case fd.Cardinality() == protoreflect.Required:
+		label = "required "
+	case fd.ParentFile().Syntax() == protoreflect.Proto2, fd.HasOptionalKeyword():
+		label = "optional "
+	}
+	typ := p.fieldType(fd)
+	if fd.IsMap() {
+		typ = "map<" + p.fieldType(fd.MapKey()) + ", " + p.fieldType(fd.MapValue()) + ">"
+	}
+	var opts []string
+	if fd.HasDefault() {
+		opts = append(opts, "default = "+defaultValue(fd))
+	}
+	if !fd.IsExtension() && fd.JSONName() != jsonName(fd.Name()) {
+		opts = append(opts, "json_name = "+strconv.Quote(fd.JSONName()))
+	}
+	fieldOpts, err := p.options(fd.Options())
+	if err != nil {
+		return err
+	}
+	opts = append(opts, fieldOpts...)
+	suffix := ""
+	if len(opts) > 0 {
+		suffix = " [" + strings.Join(opts, ", ") + "]"
+	}
+	p.declLine(fd, label, typ, " ", fd.Name(), " = ", fd.Number(), suffix, ";")
+	return nil
+}
+
+// extensions writes extension fields, grouped by the message they extend.
+func (p *Printer) extensions(xds protoreflect.ExtensionDescriptors) error {
+	var extendee protoreflect.FullName
+	for i := 0; i < xds.Len(); i++ {
+		xd := xds.Get(i)
+		if name := xd.ContainingMessage().FullName(); name != extendee {
+			if extendee != "" {
+				p.indent--
+				p.line("}")
+			}
+			extendee = name
+			p.line()
+			p.line("extend ", p.name(name), " {")
+			p.indent++
+		}
+		if err := p.field(xd, true); err != nil {
+			return err
+		}
+	}
+	if extendee != "" {
+		p.indent--
+		p.line("}")
+	}
+	return nil
+}
+
+func (p *Printer) enum(ed protoreflect.EnumDescriptor) error {
+	p.comments(ed)
+	p.declLine(ed, "enum ", ed.Name(), " {")
+	p.indent++
+	opts, err := p.options(ed.Options())
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		p.line("option ", opt, ";")
+	}
+	if ranges := ed.ReservedRanges(); ranges.Len() > 0 {
+		var rs []string
+		for i := 0; i < ranges.Len(); i++ {
+			r := ranges.Get(i)
+			switch {
+			case r[0] == r[1]:
+				rs = append(rs, strconv.Itoa(int(r[0])))
+			case r[1] == math.MaxInt32:
+				rs = append(rs, fmt.Sprintf("%d to max", r[0]))
+			default:
+				rs = append(rs, fmt.Sprintf("%d to %d", r[0], r[1]))
+			}
+		}
+		p.line("reserved ", strings.Join(rs, ", "), ";")
+	}
+	p.reservedNames(ed.ReservedNames())
+	for i := 0; i < ed.Values().Len(); i++ {
+		vd := ed.Values().Get(i)
+		p.comments(vd)
+		opts, err := p.options(vd.Options())
+		if err != nil {
+			return err
+		}
+		suffix := ""
+		if len(opts) > 0 {
+			suffix = " [" + strings.Join(opts, ", ") + "]"
+		}
+		p.declLine(vd, vd.Name(), " = ", vd.Number(), suffix, ";")
+	}
+	p.indent--
+	p.line("}")
+	return nil
+}
+
+func (p *Printer) service(sd protoreflect.ServiceDescriptor) error {
+	p.comments(sd)
+	p.declLine(sd, "service ", sd.Name(), " {")
+	p.indent++
+	opts, err := p.options(sd.Options())
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		p.line("option ", opt, ";")
+	}
+	for i := 0; i < sd.Methods().Len(); i++ {
+		md := sd.Methods().Get(i)
+		p.comments(md)
+		input, output := p.name(md.Input().FullName()), p.name(md.Output().FullName())
+		if md.IsStreamingClient() {
+			input = "stream " + input
+		}
+		if md.IsStreamingServer() {
+			output = "stream " + output
+		}
+		opts, err := p.options(md.Options())
+		if err != nil {
+			return err
+		}
+		if len(opts) == 0 {
+			p.declLine(md, "rpc ", md.Name(), "(", input, ") returns (", output, ");")
+			continue
+		}
+		p.declLine(md, "rpc ", md.Name(), "(", input, ") returns (", output, ") {")
+		p.indent++
+		for _, opt := range opts {
+			p.line("option ", opt, ";")
+		}
+		p.indent--
+		p.line("}")
+	}
+	p.indent--
+	p.line("}")
+	return nil
+}
+
+// options returns the options set in opts, such as `deprecated = true` or
+// `(google.api.http) = { get: "/v1/{name}" }`, ordered by field number.
+// Custom options are resolved with the extensions of the files, and an error
+// is returned if any is unknown, rather than dropping it.
+func (p *Printer) options(opts proto.Message) ([]string, error) {
+	if opts == nil || !opts.ProtoReflect().IsValid() {
+		return nil, nil
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+	m := opts.ProtoReflect().New().Interface()
+	if err := (proto.UnmarshalOptions{Resolver: p.types}).Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	if len(m.ProtoReflect().GetUnknown()) > 0 {
+		return nil, fmt.Errorf("unknown option in %s", m.ProtoReflect().Descriptor().FullName())
+	}
+	var list []string
+	for _, f := range sortedFields(m.ProtoReflect()) {
+		if isDefault(f) {
+			continue
+		}
+		name := string(f.fd.Name())
+		if f.fd.IsExtension() {
+			name = "(." + string(f.fd.FullName()) + ")"
+			if p.Relative {
+				name = "(" + string(f.fd.FullName()) + ")"
+			}
+		}
+		if f.fd.IsList() {
+			// Repeated options are set once per element.
+			l := f.v.List()
+			for i := 0; i < l.Len(); i++ {
+				list = append(list, name+" = "+optionValue(f.fd, l.Get(i)))
+			}
+			continue
+		}
+		list = append(list, name+" = "+optionValue(f.fd, f.v))
+	}
+	return list, nil
+}
+
+// isDefault reports whether f is a standard option set to its default value,
+// such as `deprecated = false`. Gunk sets many of these explicitly, and
+// leaving them out keeps the source readable without changing its meaning.
+func isDefault(f fieldValue) bool {
+	if f.fd.IsExtension() || f.fd.IsList() || f.fd.Message() != nil || f.fd.Kind() == protoreflect.BytesKind {
+		return false
+	}
+	return f.v.Interface() == f.fd.Default().Interface()
+}
+
+type fieldValue struct {
+	fd protoreflect.FieldDescriptor
+	v  protoreflect.Value
+}
+
+// sortedFields returns the fields set in m, ordered by field number, so that
+// the output is deterministic.
+func sortedFields(m protoreflect.Message) []fieldValue {
+	var fields []fieldValue
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		fields = append(fields, fieldValue{fd, v})
+		return true
+	})
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].fd.Number() < fields[j].fd.Number()
+	})
+	return fields
+}
+
+// optionValue returns the value of an option, with messages in the text
+// format between braces.
+func optionValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	if fd.Message() == nil {
+		return scalarValue(fd, v)
+	}
+	text := textMessage(v.Message())
+	if text == "" {
+		return "{}"
+	}
+	return "{ " + text + " }"
+}
+
+// textMessage returns m in the single line text format. It is written here
+// rather than with prototext, whose output is deliberately unstable.
+func textMessage(m protoreflect.Message) string {
+	var parts []string
+	for _, f := range sortedFields(m) {
+		name := string(f.fd.Name())
+		if f.fd.IsExtension() {
+			name = "[" + string(f.fd.FullName()) + "]"
+		}
+		var values []protoreflect.Value
+		switch {
+		case f.fd.IsList():
+			for i := 0; i < f.v.List().Len(); i++ {
+				values = append(values, f.v.List().Get(i))
+			}
+		case f.fd.IsMap():
+			// Map entries are written as messages with a key and a
+			// value, in the order of their keys.
+			var keys []protoreflect.MapKey
+			f.v.Map().Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+				keys = append(keys, k)
+				return true
+			})
+			sort.Slice(keys, func(i, j int) bool {
+				return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+			})
+			for _, k := range keys {
+				parts = append(parts, fmt.Sprintf("%s { key: %s value: %s }", name,
+					scalarValue(f.fd.MapKey(), k.Value()), mapValue(f.fd.MapValue(), f.v.Map().Get(k))))
+			}
+			continue
+		default:
+			values = append(values, f.v)
+		}
+		for _, v := range values {
+			if f.fd.Message() != nil {
+				parts = append(parts, name+" { "+textMessage(v.Message())+" }")
+				continue
+			}
+			parts = append(parts, name+": "+scalarValue(f.fd, v))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func mapValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	if fd.Message() != nil {
+		return "{ " + textMessage(v.Message()) + " }"
+	}
+	return scalarValue(fd, v)
+}
+
+func scalarValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return strconv.Itoa(int(v.Enum()))
+	case protoreflect.StringKind:
+		return quote([]byte(v.String()))
+	case protoreflect.BytesKind:
+		return quote(v.Bytes())
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		f := v.Float()
+		switch {
+		case math.IsInf(f, 1):
+			return "inf"
+		case math.IsInf(f, -1):
+			return "-inf"
+		case math.IsNaN(f):
+			return "nan"
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// defaultValue returns the default value of a proto2 field, as written in
+// its default option.
+func defaultValue(fd protoreflect.FieldDescriptor) string {
+	if fd.Kind() == protoreflect.EnumKind {
+		return string(fd.DefaultEnumValue().Name())
+	}
+	return scalarValue(fd, fd.Default())
+}
+
+// quote returns b as a string literal, escaping quotes, backslashes and
+// bytes which aren't printable ASCII. Unlike strconv.Quote, only the escapes
+// understood by protoc are used.
+func quote(b []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range b {
+		switch c {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(&sb, `\%03o`, c)
+				continue
+			}
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// fieldType returns the type of a field, with messages and enums named as
+// told by p.name.
+func (p *Printer) fieldType(fd protoreflect.FieldDescriptor) string {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return p.name(fd.Message().FullName())
+	case protoreflect.EnumKind:
+		return p.name(fd.Enum().FullName())
+	}
+	return fd.Kind().String()
+}
+
+// name returns the name of the type with the full name name, fully qualified
+// unless p.Relative is set.
+func (p *Printer) name(name protoreflect.FullName) string {
+	if !p.Relative {
+		return "." + string(name)
+	}
+	if p.pkg != "" && strings.HasPrefix(string(name), string(p.pkg)+".") {
+		return strings.TrimPrefix(string(name), string(p.pkg)+".")
+	}
+	return string(name)
+}
+
+// jsonName returns the JSON name protoc gives a field by default, so that
+// json_name is only written when it differs.
+func jsonName(name protoreflect.Name) string {
+	var sb strings.Builder
+	upper := false
+	for _, r := range name {
+		switch {
+		case r == '_':
+			upper = true
+		case upper && 'a' <= r && r <= 'z':
+			sb.WriteRune(r - 'a' + 'A')
+			upper = false
+		default:
+			sb.WriteRune(r)
+			upper = false
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,241 @@
+// Package push implements `gunk push`, which pushes Gunk packages as a module
+// of .proto files to the Buf Schema Registry (BSR), or to any registry
+// implementing its push API.
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gunk/gunk/generate"
+	"github.com/gunk/gunk/protoutil"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// pushProcedure is the Connect procedure of the BSR push API.
+const pushProcedure = "/buf.alpha.registry.v1alpha1.PushService/Push"
+
+// Options configures `gunk push`.
+type Options struct {
+	// Module is the name of the module to push to, such as
+	// buf.build/acme/api.
+	Module string
+	// Registry is the base URL of the registry API. If empty, it is
+	// https://api.<remote>, with the remote of Module.
+	Registry string
+	// Token authenticates to the registry. If empty, the BUF_TOKEN
+	// environment variable is used, as with the buf CLI.
+	Token string
+	// Tags and Labels are attached to the pushed commit.
+	Tags   []string
+	Labels []string
+	// Out writes the module to a directory instead of pushing it, such as
+	// to push it with the buf CLI.
+	Out string
+}
+
+// File is a .proto file of a module.
+type File struct {
+	Path    string
+	Content []byte
+}
+
+// Run pushes the Gunk packages matching patterns in dir as a module, printing
+// the pushed commit to w.
+func Run(ctx context.Context, w io.Writer, dir string, opts Options, patterns ...string) error {
+	// The module name is only optional when writing it to a directory.
+	var owner, repository, remote string
+	if opts.Module != "" || opts.Out == "" {
+		var err error
+		owner, repository, remote, err = parseModule(opts.Module)
+		if err != nil {
+			return err
+		}
+	}
+	fds, err := generate.FileDescriptorSetWithOptions(generate.DescriptorSetOptions{
+		IncludeImports:    true,
+		IncludeSourceInfo: true,
+	}, dir, patterns...)
+	if err != nil {
+		return err
+	}
+	files, err := ModuleFiles(fds)
+	if err != nil {
+		return err
+	}
+	if opts.Out != "" {
+		return writeModule(opts.Out, opts.Module, files)
+	}
+	registry := opts.Registry
+	if registry == "" {
+		registry = "https://api." + remote
+	}
+	token := opts.Token
+	if token == "" {
+		token = os.Getenv("BUF_TOKEN")
+	}
+	if token == "" {
+		return fmt.Errorf("no token to push to %s; set BUF_TOKEN", remote)
+	}
+	commit, err := push(ctx, http.DefaultClient, registry, token, pushRequest{
+		Owner:      owner,
+		Repository: repository,
+		Module:     pushModule{Files: pushFiles(files)},
+		Tags:       opts.Tags,
+		Labels:     opts.Labels,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "pushed %s:%s\n", opts.Module, commit)
+	return nil
+}
+
+// parseModule splits a module name, such as buf.build/acme/api, into its
+// owner, repository and remote.
+func parseModule(name string) (owner, repository, remote string, err error) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", fmt.Errorf("invalid module %q: must be <remote>/<owner>/<repository>", name)
+	}
+	return parts[1], parts[2], parts[0], nil
+}
+
+// ModuleFiles returns the .proto files of the module holding the files of
+// fds, ordered by path. The well-known types under google/protobuf are left
+// out, as the registry provides them. Other imports, such as the googleapis
+// annotations, are part of the module, so that it doesn't have any
+// dependencies.
+func ModuleFiles(fds *descriptorpb.FileDescriptorSet) ([]File, error) {
+	reg, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, err
+	}
+	p, err := protoutil.NewPrinter(reg)
+	if err != nil {
+		return nil, err
+	}
+	var files []File
+	for _, fdp := range fds.File {
+		if strings.HasPrefix(fdp.GetName(), "google/protobuf/") {
+			continue
+		}
+		fd, err := reg.FindFileByPath(fdp.GetName())
+		if err != nil {
+			return nil, err
+		}
+		content, err := p.PrintFile(fd)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, File{Path: fdp.GetName(), Content: content})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// writeModule writes files to dir, along with a buf.yaml naming the module if
+// name is set.
+func writeModule(dir, name string, files []File) error {
+	if name != "" {
+		files = append(files, File{
+			Path:    "buf.yaml",
+			Content: []byte("version: v1\nname: " + name + "\n"),
+		})
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f.Path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, f.Content, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushRequest is the buf.alpha.registry.v1alpha1.PushRequest, in the JSON
+// form of the Connect protocol.
+type pushRequest struct {
+	Owner      string     `json:"owner"`
+	Repository string     `json:"repository"`
+	Module     pushModule `json:"module"`
+	Tags       []string   `json:"tags,omitempty"`
+	Labels     []string   `json:"labels,omitempty"`
+}
+
+type pushModule struct {
+	Files []pushFile `json:"files"`
+}
+
+type pushFile struct {
+	Path    string `json:"path"`
+	Content []byte `json:"content"`
+}
+
+func pushFiles(files []File) []pushFile {
+	list := make([]pushFile, len(files))
+	for i, f := range files {
+		list[i] = pushFile{Path: f.Path, Content: f.Content}
+	}
+	return list
+}
+
+type pushResponse struct {
+	LocalModulePin struct {
+		Commit string `json:"commit"`
+	} `json:"localModulePin"`
+}
+
+// connectError is the body of a failed Connect call.
+type connectError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// push calls the push API of registry, returning the pushed commit.
+func push(ctx context.Context, client *http.Client, registry, token string, req pushRequest) (string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(registry, "/")+pushProcedure, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Connect-Protocol-Version", "1")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var cerr connectError
+		if json.Unmarshal(data, &cerr) == nil && cerr.Message != "" {
+			return "", fmt.Errorf("push to %s failed: %s: %s", registry, cerr.Code, cerr.Message)
+		}
+		return "", fmt.Errorf("push to %s failed: %s", registry, resp.Status)
+	}
+	var pr pushResponse
+	if err := json.Unmarshal(data, &pr); err != nil {
+		return "", fmt.Errorf("unable to decode the push response: %w", err)
+	}
+	return pr.LocalModulePin.Commit, nil
+}
@@ -0,0 +1,196 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	protoparser "github.com/emicklei/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func testDescriptorSet() *descriptorpb.FileDescriptorSet {
+	descriptor := protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto)
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	str := descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+	msgOpts := &descriptorpb.MessageOptions{Deprecated: proto.Bool(false)}
+	// (util.tag) = "a \"quoted\" tag", as an unknown field like in the
+	// descriptors written by gunk.
+	unknown := protowire.AppendTag(nil, 5000, protowire.BytesType)
+	unknown = protowire.AppendString(unknown, `a "quoted" tag`)
+	msgOpts.ProtoReflect().SetUnknown(unknown)
+	util := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("testdata.tld/util/all.proto"),
+		Package:    proto.String("util"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/descriptor.proto"},
+		Options:    &descriptorpb.FileOptions{GoPackage: proto.String("testdata.tld/util")},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Status"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("Unknown"), Number: proto.Int32(0)},
+				{Name: proto.String("Active"), Number: proto.Int32(1), Options: &descriptorpb.EnumValueOptions{Deprecated: proto.Bool(true)}},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name:    proto.String("Message"),
+			Options: msgOpts,
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("Name"), Number: proto.Int32(1), Label: optional, Type: str, JsonName: proto.String("name")},
+				{Name: proto.String("Labels"), Number: proto.Int32(2), Label: repeated, Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".util.Message.LabelsEntry"), JsonName: proto.String("Labels")},
+				{Name: proto.String("Text"), Number: proto.Int32(3), Label: optional, Type: str, JsonName: proto.String("Text"), OneofIndex: proto.Int32(0)},
+				{Name: proto.String("Status"), Number: proto.Int32(4), Label: optional, Type: descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum(), TypeName: proto.String(".util.Status"), JsonName: proto.String("Status"), OneofIndex: proto.Int32(0)},
+				{Name: proto.String("Count"), Number: proto.Int32(5), Label: optional, Type: descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(), JsonName: proto.String("Count"), OneofIndex: proto.Int32(1), Proto3Optional: proto.Bool(true)},
+			},
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("LabelsEntry"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("key"), Number: proto.Int32(1), Label: optional, Type: str, JsonName: proto.String("key")},
+					{Name: proto.String("value"), Number: proto.Int32(2), Label: optional, Type: descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(), JsonName: proto.String("value")},
+				},
+				Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+			}},
+			OneofDecl: []*descriptorpb.OneofDescriptorProto{
+				{Name: proto.String("Value")},
+				{Name: proto.String("_Count")},
+			},
+			ReservedRange: []*descriptorpb.DescriptorProto_ReservedRange{
+				{Start: proto.Int32(6), End: proto.Int32(7)},
+				{Start: proto.Int32(8), End: proto.Int32(11)},
+			},
+			ReservedName: []string{"Old"},
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Util"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{Name: proto.String("Echo"), InputType: proto.String(".util.Message"), OutputType: proto.String(".util.Message")},
+				{Name: proto.String("Watch"), InputType: proto.String(".util.Message"), OutputType: proto.String(".util.Message"), ServerStreaming: proto.Bool(true), Options: &descriptorpb.MethodOptions{IdempotencyLevel: descriptorpb.MethodOptions_NO_SIDE_EFFECTS.Enum()}},
+			},
+		}},
+		Extension: []*descriptorpb.FieldDescriptorProto{{
+			Name:     proto.String("tag"),
+			Number:   proto.Int32(5000),
+			Label:    optional,
+			Type:     str,
+			Extendee: proto.String(".google.protobuf.MessageOptions"),
+			JsonName: proto.String("tag"),
+		}},
+		SourceCodeInfo: &descriptorpb.SourceCodeInfo{
+			Location: []*descriptorpb.SourceCodeInfo_Location{{
+				Path:            []int32{4, 0},
+				Span:            []int32{1, 0, 1},
+				LeadingComments: proto.String(" Message is a message.\n"),
+			}, {
+				Path:            []int32{4, 0, 2, 0},
+				Span:            []int32{2, 0, 1},
+				LeadingComments: proto.String(" Name is the name,\n which is required.\n"),
+			}},
+		},
+	}
+	return &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{descriptor, util}}
+}
+
+const wantUtil = `syntax = "proto3";
+
+package util;
+
+import "google/protobuf/descriptor.proto";
+
+option go_package = "testdata.tld/util";
+
+enum Status {
+  Unknown = 0;
+  Active = 1 [deprecated = true];
+}
+
+// Message is a message.
+message Message {
+  option (.util.tag) = "a \"quoted\" tag";
+  reserved 6, 8 to 10;
+  reserved "Old";
+  // Name is the name,
+  // which is required.
+  string Name = 1 [json_name = "name"];
+  map<string, int32> Labels = 2;
+  oneof Value {
+    string Text = 3;
+    .util.Status Status = 4;
+  }
+  optional int64 Count = 5;
+}
+
+extend .google.protobuf.MessageOptions {
+  string tag = 5000;
+}
+
+service Util {
+  rpc Echo(.util.Message) returns (.util.Message);
+  rpc Watch(.util.Message) returns (stream .util.Message) {
+    option idempotency_level = NO_SIDE_EFFECTS;
+  }
+}
+`
+
+func TestModuleFiles(t *testing.T) {
+	files, err := ModuleFiles(testDescriptorSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// descriptor.proto is left out, as a well-known type.
+	if len(files) != 1 || files[0].Path != "testdata.tld/util/all.proto" {
+		t.Fatalf("want only testdata.tld/util/all.proto, got %v", files)
+	}
+	if got := string(files[0].Content); got != wantUtil {
+		t.Fatalf("want:\n%s\ngot:\n%s", wantUtil, got)
+	}
+	if _, err := protoparser.NewParser(strings.NewReader(wantUtil)).Parse(); err != nil {
+		t.Fatalf("printed source doesn't parse: %v", err)
+	}
+}
+
+func TestPush(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != pushProcedure {
+			t.Errorf("want path %s, got %s", pushProcedure, r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code":"unauthenticated","message":"invalid token"}`))
+			return
+		}
+		var req pushRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		if req.Owner != "acme" || req.Repository != "api" || len(req.Module.Files) != 1 || strings.Join(req.Tags, ",") != "v1.0.0" {
+			t.Errorf("unexpected request: %+v", req)
+		}
+		w.Write([]byte(`{"localModulePin":{"owner":"acme","repository":"api","commit":"a1b2c3"}}`))
+	}))
+	defer srv.Close()
+
+	req := pushRequest{
+		Owner:      "acme",
+		Repository: "api",
+		Module:     pushModule{Files: []pushFile{{Path: "acme/all.proto", Content: []byte(`syntax = "proto3";`)}}},
+		Tags:       []string{"v1.0.0"},
+	}
+	commit, err := push(context.Background(), srv.Client(), srv.URL, "secret", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit != "a1b2c3" {
+		t.Fatalf("want commit a1b2c3, got %q", commit)
+	}
+	_, err = push(context.Background(), srv.Client(), srv.URL, "wrong", req)
+	if err == nil || !strings.Contains(err.Error(), "unauthenticated: invalid token") {
+		t.Fatalf("want an unauthenticated error, got %v", err)
+	}
+}
@@ -0,0 +1,650 @@
+package query
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A filter evaluates a query expression on an input value, producing any
+// number of outputs. As in JSON, values are nil, bools, float64s, strings,
+// []interface{} and map[string]interface{}.
+type filter func(v interface{}) ([]interface{}, error)
+
+// Compile parses a query expression in a small subset of the jq language:
+//
+//	.                  the input
+//	.name, .["name"]   a field of an object, or null if it's missing
+//	.[n]               an element of an array
+//	.[]                all the elements of an array, or values of an object
+//	a | b              b applied to each output of a
+//	a, b               the outputs of a, then those of b
+//	[a]                an array of the outputs of a
+//	==, !=, <, <=, >, >=, and, or
+//	"string", 1.5, true, false, null
+//
+// along with the functions select(f), map(f), length, keys, not, has(key),
+// startswith(s), endswith(s), contains(s) and test(regexp).
+func Compile(expr string) (func(v interface{}) ([]interface{}, error), error) {
+	p := &parser{src: expr}
+	p.next()
+	f, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return f, nil
+}
+
+// parser is a recursive descent parser of query expressions, building their
+// filters as it goes.
+type parser struct {
+	src string
+	// tok is the current token, or "" at the end of the source. Strings
+	// keep their quotes, so that they can't be mistaken for other
+	// tokens.
+	tok string
+	// pos is the offset of tok in src, and end the offset after it.
+	pos, end int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("query: at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// next reads the next token.
+func (p *parser) next() {
+	for p.end < len(p.src) && unicode.IsSpace(rune(p.src[p.end])) {
+		p.end++
+	}
+	p.pos = p.end
+	if p.end == len(p.src) {
+		p.tok = ""
+		return
+	}
+	s := p.src[p.end:]
+	n := 1
+	switch c := s[0]; {
+	case c == '"':
+		for n < len(s) && s[n] != '"' {
+			if s[n] == '\\' {
+				n++
+			}
+			n++
+		}
+		if n < len(s) {
+			n++
+		}
+	case c >= '0' && c <= '9', c == '-' && len(s) > 1 && s[1] >= '0' && s[1] <= '9':
+		for n < len(s) && (s[n] >= '0' && s[n] <= '9' || s[n] == '.') {
+			n++
+		}
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for n < len(s) && (s[n] == '_' || unicode.IsLetter(rune(s[n])) || unicode.IsDigit(rune(s[n]))) {
+			n++
+		}
+	case strings.HasPrefix(s, "=="), strings.HasPrefix(s, "!="),
+		strings.HasPrefix(s, "<="), strings.HasPrefix(s, ">="):
+		n = 2
+	}
+	p.tok = s[:n]
+	p.end += n
+}
+
+// expect consumes tok, or fails if it's not the current token.
+func (p *parser) expect(tok string) error {
+	if p.tok != tok {
+		if p.tok == "" {
+			return p.errorf("expected %s, found the end of the query", tok)
+		}
+		return p.errorf("expected %s, found %s", tok, p.tok)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) parsePipe() (filter, error) {
+	left, err := p.parseComma()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "|" {
+		p.next()
+		right, err := p.parseComma()
+		if err != nil {
+			return nil, err
+		}
+		left = pipe(left, right)
+	}
+	return left, nil
+}
+
+func pipe(left, right filter) filter {
+	return func(v interface{}) ([]interface{}, error) {
+		in, err := left(v)
+		if err != nil {
+			return nil, err
+		}
+		var out []interface{}
+		for _, v := range in {
+			res, err := right(v)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, res...)
+		}
+		return out, nil
+	}
+}
+
+func (p *parser) parseComma() (filter, error) {
+	left, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "," {
+		p.next()
+		right, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(v interface{}) ([]interface{}, error) {
+			out, err := l(v)
+			if err != nil {
+				return nil, err
+			}
+			res, err := right(v)
+			return append(out, res...), err
+		}
+	}
+	return left, nil
+}
+
+// binaryOps holds the binary operators by precedence, from the lowest.
+var binaryOps = [][]string{
+	{"or"},
+	{"and"},
+	{"==", "!=", "<", "<=", ">", ">="},
+}
+
+func (p *parser) parseBinary(prec int) (filter, error) {
+	if prec == len(binaryOps) {
+		return p.parsePostfix()
+	}
+	left, err := p.parseBinary(prec + 1)
+	if err != nil {
+		return nil, err
+	}
+	for isOp(p.tok, binaryOps[prec]) {
+		op := p.tok
+		p.next()
+		right, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binary(op, left, right)
+	}
+	return left, nil
+}
+
+func isOp(tok string, ops []string) bool {
+	for _, op := range ops {
+		if tok == op {
+			return true
+		}
+	}
+	return false
+}
+
+// binary applies op to each pair of the outputs of left and right.
+func binary(op string, left, right filter) filter {
+	return func(v interface{}) ([]interface{}, error) {
+		rs, err := right(v)
+		if err != nil {
+			return nil, err
+		}
+		ls, err := left(v)
+		if err != nil {
+			return nil, err
+		}
+		var out []interface{}
+		for _, r := range rs {
+			for _, l := range ls {
+				res, err := apply(op, l, r)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, res)
+			}
+		}
+		return out, nil
+	}
+}
+
+func apply(op string, l, r interface{}) (interface{}, error) {
+	switch op {
+	case "and":
+		return truthy(l) && truthy(r), nil
+	case "or":
+		return truthy(l) || truthy(r), nil
+	case "==":
+		return reflect.DeepEqual(l, r), nil
+	case "!=":
+		return !reflect.DeepEqual(l, r), nil
+	}
+	var cmp int
+	switch l := l.(type) {
+	case float64:
+		r, ok := r.(float64)
+		if !ok {
+			return nil, fmt.Errorf("query: cannot compare %s with %s", typeName(l), typeName(r))
+		}
+		switch {
+		case l < r:
+			cmp = -1
+		case l > r:
+			cmp = 1
+		}
+	case string:
+		r, ok := r.(string)
+		if !ok {
+			return nil, fmt.Errorf("query: cannot compare %s with %s", typeName(l), typeName(r))
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return nil, fmt.Errorf("query: cannot compare %s with %s", typeName(l), typeName(r))
+	}
+	switch op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default: // ">="
+		return cmp >= 0, nil
+	}
+}
+
+// truthy reports whether v is neither false nor null.
+func truthy(v interface{}) bool {
+	return v != nil && v != false
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func (p *parser) parsePostfix() (filter, error) {
+	f, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.tok == "." && p.end < len(p.src) && p.src[p.end] == '[':
+			p.next()
+		case p.tok == ".":
+			p.next()
+			if !isIdent(p.tok) {
+				return nil, p.errorf("expected a field name after ., found %q", p.tok)
+			}
+			f = pipe(f, field(p.tok))
+			p.next()
+			continue
+		}
+		if p.tok != "[" {
+			return f, nil
+		}
+		if f, err = p.parseBracket(f); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseBracket parses a [] or [index] suffix applied to the outputs of f.
+// The index is evaluated on the input of f, as in jq.
+func (p *parser) parseBracket(f filter) (filter, error) {
+	p.next()
+	if p.tok == "]" {
+		p.next()
+		return pipe(f, iterate), nil
+	}
+	index, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	return func(v interface{}) ([]interface{}, error) {
+		keys, err := index(v)
+		if err != nil {
+			return nil, err
+		}
+		in, err := f(v)
+		if err != nil {
+			return nil, err
+		}
+		var out []interface{}
+		for _, v := range in {
+			for _, key := range keys {
+				res, err := indexValue(v, key)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, res)
+			}
+		}
+		return out, nil
+	}, nil
+}
+
+func isIdent(tok string) bool {
+	return tok != "" && (tok[0] == '_' || unicode.IsLetter(rune(tok[0])))
+}
+
+func field(name string) filter {
+	return func(v interface{}) ([]interface{}, error) {
+		res, err := indexValue(v, name)
+		return []interface{}{res}, err
+	}
+}
+
+func indexValue(v, key interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		if key, ok := key.(string); ok {
+			return v[key], nil
+		}
+	case []interface{}:
+		if n, ok := key.(float64); ok {
+			i := int(n)
+			if i < 0 {
+				i += len(v)
+			}
+			if i < 0 || i >= len(v) {
+				return nil, nil
+			}
+			return v[i], nil
+		}
+	}
+	return nil, fmt.Errorf("query: cannot index %s with %s", typeName(v), typeName(key))
+}
+
+func iterate(v interface{}) ([]interface{}, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		return v, nil
+	case map[string]interface{}:
+		var out []interface{}
+		for _, key := range sortedKeys(v) {
+			out = append(out, v[key])
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("query: cannot iterate over %s", typeName(v))
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func identity(v interface{}) ([]interface{}, error) {
+	return []interface{}{v}, nil
+}
+
+func constant(c interface{}) filter {
+	return func(interface{}) ([]interface{}, error) {
+		return []interface{}{c}, nil
+	}
+}
+
+func (p *parser) parsePrimary() (filter, error) {
+	tok := p.tok
+	switch {
+	case tok == "":
+		return nil, p.errorf("unexpected end of the query")
+	case tok == ".":
+		dotEnd := p.end
+		p.next()
+		if isIdent(p.tok) && p.pos == dotEnd {
+			f := field(p.tok)
+			p.next()
+			return f, nil
+		}
+		return identity, nil
+	case tok == "(":
+		p.next()
+		f, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		return f, p.expect(")")
+	case tok == "[":
+		p.next()
+		if p.tok == "]" {
+			p.next()
+			return constant([]interface{}{}), nil
+		}
+		f, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return func(v interface{}) ([]interface{}, error) {
+			out, err := f(v)
+			if out == nil {
+				out = []interface{}{}
+			}
+			return []interface{}{out}, err
+		}, nil
+	case tok[0] == '"':
+		s, err := strconv.Unquote(tok)
+		if err != nil {
+			return nil, p.errorf("invalid string %s", tok)
+		}
+		p.next()
+		return constant(s), nil
+	case tok[0] >= '0' && tok[0] <= '9', tok[0] == '-':
+		n, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", tok)
+		}
+		p.next()
+		return constant(n), nil
+	case tok == "true", tok == "false":
+		p.next()
+		return constant(tok == "true"), nil
+	case tok == "null":
+		p.next()
+		return constant(nil), nil
+	case isIdent(tok):
+		return p.parseCall()
+	}
+	return nil, p.errorf("unexpected %s", tok)
+}
+
+// funcs are the functions without arguments.
+var funcs = map[string]func(v interface{}) (interface{}, error){
+	"length": func(v interface{}) (interface{}, error) {
+		switch v := v.(type) {
+		case nil:
+			return 0.0, nil
+		case string:
+			return float64(len([]rune(v))), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		}
+		return nil, fmt.Errorf("query: %s has no length", typeName(v))
+	},
+	"keys": func(v interface{}) (interface{}, error) {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("query: %s has no keys", typeName(v))
+		}
+		keys := []interface{}{}
+		for _, key := range sortedKeys(m) {
+			keys = append(keys, key)
+		}
+		return keys, nil
+	},
+	"not": func(v interface{}) (interface{}, error) {
+		return !truthy(v), nil
+	},
+}
+
+// stringFuncs are the functions of a string and a string argument.
+var stringFuncs = map[string]func(s, arg string) (bool, error){
+	"startswith": func(s, arg string) (bool, error) { return strings.HasPrefix(s, arg), nil },
+	"endswith":   func(s, arg string) (bool, error) { return strings.HasSuffix(s, arg), nil },
+	"test": func(s, arg string) (bool, error) {
+		rx, err := regexp.Compile(arg)
+		if err != nil {
+			return false, fmt.Errorf("query: %v", err)
+		}
+		return rx.MatchString(s), nil
+	},
+}
+
+func (p *parser) parseCall() (filter, error) {
+	name, pos := p.tok, p.pos
+	unknown := fmt.Errorf("query: at offset %d: unknown function %s", pos, name)
+	p.next()
+	if fn, ok := funcs[name]; ok {
+		return func(v interface{}) ([]interface{}, error) {
+			res, err := fn(v)
+			return []interface{}{res}, err
+		}, nil
+	}
+	if p.tok != "(" {
+		return nil, unknown
+	}
+	p.next()
+	arg, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	switch name {
+	case "select":
+		return func(v interface{}) ([]interface{}, error) {
+			conds, err := arg(v)
+			if err != nil {
+				return nil, err
+			}
+			var out []interface{}
+			for _, cond := range conds {
+				if truthy(cond) {
+					out = append(out, v)
+				}
+			}
+			return out, nil
+		}, nil
+	case "map":
+		return func(v interface{}) ([]interface{}, error) {
+			res, err := pipe(iterate, arg)(v)
+			if res == nil {
+				res = []interface{}{}
+			}
+			return []interface{}{res}, err
+		}, nil
+	case "has":
+		return withArgs(arg, func(v, key interface{}) (interface{}, error) {
+			switch v := v.(type) {
+			case map[string]interface{}:
+				if key, ok := key.(string); ok {
+					_, ok := v[key]
+					return ok, nil
+				}
+			case []interface{}:
+				if n, ok := key.(float64); ok {
+					return n >= 0 && int(n) < len(v), nil
+				}
+			}
+			return nil, fmt.Errorf("query: cannot check whether %s has a %s key", typeName(v), typeName(key))
+		}), nil
+	case "contains":
+		return withArgs(arg, func(v, elem interface{}) (interface{}, error) {
+			switch v := v.(type) {
+			case string:
+				if elem, ok := elem.(string); ok {
+					return strings.Contains(v, elem), nil
+				}
+			case []interface{}:
+				for _, e := range v {
+					if reflect.DeepEqual(e, elem) {
+						return true, nil
+					}
+				}
+				return false, nil
+			}
+			return nil, fmt.Errorf("query: cannot check whether %s contains %s", typeName(v), typeName(elem))
+		}), nil
+	}
+	fn, ok := stringFuncs[name]
+	if !ok {
+		return nil, unknown
+	}
+	return withArgs(arg, func(v, a interface{}) (interface{}, error) {
+		s, ok := v.(string)
+		sa, ok2 := a.(string)
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("query: %s takes strings, not %s and %s", name, typeName(v), typeName(a))
+		}
+		return fn(s, sa)
+	}), nil
+}
+
+// withArgs returns a filter calling fn with its input and each output of
+// arg, which is evaluated on the input too.
+func withArgs(arg filter, fn func(v, arg interface{}) (interface{}, error)) filter {
+	return func(v interface{}) ([]interface{}, error) {
+		args, err := arg(v)
+		if err != nil {
+			return nil, err
+		}
+		var out []interface{}
+		for _, a := range args {
+			res, err := fn(v, a)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, res)
+		}
+		return out, nil
+	}
+}
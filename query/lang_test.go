@@ -0,0 +1,85 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	const input = `{
+		"name": "util",
+		"items": [
+			{"name": "a", "size": 1, "tags": ["x"]},
+			{"name": "b", "size": 3, "tags": []},
+			{"name": "c", "size": 2, "opts": {"google.api.http": {"get": "/c"}}}
+		]
+	}`
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`.`, `[{"items":[{"name":"a","size":1,"tags":["x"]},{"name":"b","size":3,"tags":[]},{"name":"c","opts":{"google.api.http":{"get":"/c"}},"size":2}],"name":"util"}]`},
+		{`.name`, `["util"]`},
+		{`.missing.name`, `[null]`},
+		{`.items[].name`, `["a","b","c"]`},
+		{`.items[1].name`, `["b"]`},
+		{`.items[-1].name`, `["c"]`},
+		{`.items | length`, `[3]`},
+		{`[.items[] | select(.size >= 2) | .name]`, `[["b","c"]]`},
+		{`.items[] | select(.size > 1 and .name != "b") | .name`, `["c"]`},
+		{`.items[] | select(.name == "a" or .size == 3) | .size`, `[1,3]`},
+		{`.items[] | select(.opts["google.api.http"] == null) | .name`, `["a","b"]`},
+		{`.items[] | select(.opts | not) | .name`, `["a","b"]`},
+		{`.items[] | select(has("opts")) | .name`, `["c"]`},
+		{`.items[] | select(has("tags")) | select(.tags | contains("x")) | .name`, `["a"]`},
+		{`.items[] | select(.name | test("^[ab]$")) | .name`, `["a","b"]`},
+		{`.items[] | .name | startswith("a"), endswith("c")`, `[true,false,false,false,false,true]`},
+		{`.items | map(.size)`, `[[1,3,2]]`},
+		{`.items[2].opts | keys`, `[["google.api.http"]]`},
+		{`.name, (.items | length)`, `["util",3]`},
+		{`[]`, `[[]]`},
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(input), &v); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range tests {
+		query, err := Compile(tc.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		res, err := query(v)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		if res == nil {
+			res = []interface{}{}
+		}
+		got, _ := json.Marshal(res)
+		if string(got) != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`.items[`, "query: at offset 7: unexpected end of the query"},
+		{`.items | {`, "query: at offset 9: unexpected {"},
+		{`select(.a`, "query: at offset 9: expected ), found the end of the query"},
+		{`frobnicate(.a)`, "query: at offset 0: unknown function frobnicate"},
+		{`. | frobnicate`, "query: at offset 4: unknown function frobnicate"},
+		{`.a .`, `query: at offset 4: expected a field name after ., found ""`},
+	}
+	for _, tc := range tests {
+		_, err := Compile(tc.expr)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%s: got error %v, want %q", tc.expr, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package query
+
+import (
+	"bytes"
+	"encoding/base64"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gunk/gunk/generate"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Model is the API model which queries run on: the Gunk packages, with their
+// messages, enums and services. Queries see it as its JSON encoding.
+type Model struct {
+	Packages []*Package `json:"packages"`
+}
+
+// Package is a Gunk package.
+type Package struct {
+	// Path is the import path of the package, such as "example.com/util".
+	Path string `json:"path"`
+	// Name is the proto package name, such as "util".
+	Name     string                 `json:"name"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+	Messages []*Message             `json:"messages"`
+	Enums    []*Enum                `json:"enums"`
+	Services []*Service             `json:"services"`
+}
+
+// Element holds what all the declarations of a package have in common.
+type Element struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Doc      string `json:"doc,omitempty"`
+	// Position is the Gunk file and line declaring the element, such as
+	// "example.com/util/util.gunk:12".
+	Position string `json:"position,omitempty"`
+	// Options are the options set on the element, keyed by the name of
+	// the option, such as "deprecated", or the full name of an
+	// extension, such as "google.api.http".
+	Options map[string]interface{} `json:"options,omitempty"`
+}
+
+// Message is a message, with its fields.
+type Message struct {
+	Element
+	Fields []*Field `json:"fields"`
+}
+
+// Field is a field of a message.
+type Field struct {
+	Element
+	Number int `json:"number"`
+	// Type is the proto type of the field, such as "string", "message"
+	// or "enum", or of its values if it's a map.
+	Type string `json:"type"`
+	// TypeName is the full name of the message or enum type, if any.
+	TypeName string `json:"type_name,omitempty"`
+	// Key is the proto type of the keys of a map.
+	Key      string `json:"key,omitempty"`
+	Repeated bool   `json:"repeated"`
+	Map      bool   `json:"map"`
+	Oneof    string `json:"oneof,omitempty"`
+	JSONName string `json:"json_name"`
+}
+
+// Enum is an enum, with its values.
+type Enum struct {
+	Element
+	Values []*EnumValue `json:"values"`
+}
+
+// EnumValue is a value of an enum.
+type EnumValue struct {
+	Element
+	Number int `json:"number"`
+}
+
+// Service is a service, with its methods.
+type Service struct {
+	Element
+	Methods []*Method `json:"methods"`
+}
+
+// Method is a method of a service.
+type Method struct {
+	Element
+	// Input and Output are the full names of the request and response
+	// messages.
+	Input           string `json:"input"`
+	Output          string `json:"output"`
+	ClientStreaming bool   `json:"client_streaming"`
+	ServerStreaming bool   `json:"server_streaming"`
+}
+
+// builder builds the model of the files of a Gunk package.
+type builder struct {
+	file      protoreflect.FileDescriptor
+	positions generate.SourcePositions
+}
+
+// NewModel builds the model of the proto files of Gunk packages, named by
+// names, with the positions of their elements.
+func NewModel(files *protoregistry.Files, names []string, positions map[string]generate.SourcePositions) (*Model, error) {
+	m := &Model{Packages: []*Package{}}
+	for _, name := range names {
+		file, err := files.FindFileByPath(name)
+		if err != nil {
+			return nil, err
+		}
+		b := &builder{file: file, positions: positions[name]}
+		pkg := &Package{
+			Path:     path.Dir(name),
+			Name:     string(file.Package()),
+			Options:  options(file.Options()),
+			Messages: []*Message{},
+			Enums:    []*Enum{},
+			Services: []*Service{},
+		}
+		for i := 0; i < file.Messages().Len(); i++ {
+			pkg.Messages = append(pkg.Messages, b.message(file.Messages().Get(i)))
+		}
+		for i := 0; i < file.Enums().Len(); i++ {
+			pkg.Enums = append(pkg.Enums, b.enum(file.Enums().Get(i)))
+		}
+		for i := 0; i < file.Services().Len(); i++ {
+			pkg.Services = append(pkg.Services, b.service(file.Services().Get(i)))
+		}
+		m.Packages = append(m.Packages, pkg)
+	}
+	return m, nil
+}
+
+func (b *builder) element(d protoreflect.Descriptor) Element {
+	e := Element{
+		Name:     string(d.Name()),
+		FullName: string(d.FullName()),
+		Options:  options(d.Options()),
+	}
+	loc := b.file.SourceLocations().ByDescriptor(d)
+	e.Doc = strings.TrimSpace(loc.LeadingComments)
+	if e.Doc == "" {
+		e.Doc = strings.TrimSpace(loc.TrailingComments)
+	}
+	if pos, ok := b.positions[loc.Path.String()]; ok {
+		e.Position = pos.Filename + ":" + strconv.Itoa(pos.Line)
+	}
+	return e
+}
+
+func (b *builder) message(md protoreflect.MessageDescriptor) *Message {
+	msg := &Message{Element: b.element(md), Fields: []*Field{}}
+	for i := 0; i < md.Fields().Len(); i++ {
+		fd := md.Fields().Get(i)
+		f := &Field{
+			Element:  b.element(fd),
+			Number:   int(fd.Number()),
+			Repeated: fd.IsList(),
+			Map:      fd.IsMap(),
+			JSONName: fd.JSONName(),
+		}
+		if oneof := fd.ContainingOneof(); oneof != nil {
+			f.Oneof = string(oneof.Name())
+		}
+		typ := fd
+		if fd.IsMap() {
+			f.Key = fd.MapKey().Kind().String()
+			typ = fd.MapValue()
+		}
+		f.Type = typ.Kind().String()
+		switch {
+		case typ.Message() != nil:
+			f.TypeName = string(typ.Message().FullName())
+		case typ.Enum() != nil:
+			f.TypeName = string(typ.Enum().FullName())
+		}
+		msg.Fields = append(msg.Fields, f)
+	}
+	return msg
+}
+
+func (b *builder) enum(ed protoreflect.EnumDescriptor) *Enum {
+	enum := &Enum{Element: b.element(ed), Values: []*EnumValue{}}
+	for i := 0; i < ed.Values().Len(); i++ {
+		vd := ed.Values().Get(i)
+		enum.Values = append(enum.Values, &EnumValue{Element: b.element(vd), Number: int(vd.Number())})
+	}
+	return enum
+}
+
+func (b *builder) service(sd protoreflect.ServiceDescriptor) *Service {
+	svc := &Service{Element: b.element(sd), Methods: []*Method{}}
+	for i := 0; i < sd.Methods().Len(); i++ {
+		md := sd.Methods().Get(i)
+		svc.Methods = append(svc.Methods, &Method{
+			Element:         b.element(md),
+			Input:           string(md.Input().FullName()),
+			Output:          string(md.Output().FullName()),
+			ClientStreaming: md.IsStreamingClient(),
+			ServerStreaming: md.IsStreamingServer(),
+		})
+	}
+	return svc
+}
+
+// options returns the options set in opts, or nil if there are none.
+func options(opts protoreflect.ProtoMessage) map[string]interface{} {
+	if opts == nil {
+		return nil
+	}
+	m := opts.ProtoReflect()
+	if !m.IsValid() {
+		return nil
+	}
+	values := messageValue(m)
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// messageValue returns the fields set in m, keyed by their names, or by the
+// full names of extensions. Scalars set to their default are left out, as
+// Gunk sets all the standard options.
+func messageValue(m protoreflect.Message) map[string]interface{} {
+	values := make(map[string]interface{})
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if isDefault(fd, v) {
+			return true
+		}
+		name := string(fd.Name())
+		if fd.IsExtension() {
+			name = string(fd.FullName())
+		}
+		values[name] = fieldValue(fd, v)
+		return true
+	})
+	return values
+}
+
+func isDefault(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+	switch {
+	case fd.IsList(), fd.IsMap(), fd.Message() != nil:
+		return false
+	case fd.Kind() == protoreflect.BytesKind:
+		return bytes.Equal(v.Bytes(), fd.Default().Bytes())
+	}
+	return v.Interface() == fd.Default().Interface()
+}
+
+func fieldValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		values := make([]interface{}, list.Len())
+		for i := range values {
+			values[i] = singularValue(fd, list.Get(i))
+		}
+		return values
+	case fd.IsMap():
+		values := make(map[string]interface{})
+		v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			values[k.String()] = singularValue(fd.MapValue(), v)
+			return true
+		})
+		return values
+	}
+	return singularValue(fd, v)
+}
+
+func singularValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return messageValue(v.Message())
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return int64(v.Enum())
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(v.Bytes())
+	}
+	return v.Interface()
+}
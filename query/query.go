@@ -0,0 +1,60 @@
+// Package query runs jq-style queries over the API model of Gunk packages,
+// for scripts checking or extracting things from APIs without using the Go
+// packages of Gunk.
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/gunk/gunk/generate"
+	"google.golang.org/protobuf/reflect/protodesc"
+)
+
+// Run evaluates the query expr on the model of the Gunk packages matching
+// patterns, printing each result to w as JSON on its own line. With raw,
+// strings are printed as they are instead.
+func Run(w io.Writer, dir, expr string, raw bool, patterns ...string) error {
+	query, err := Compile(expr)
+	if err != nil {
+		return err
+	}
+	fds, names, positions, err := generate.RequestedFileDescriptorSetPositions(dir, patterns...)
+	if err != nil {
+		return err
+	}
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return err
+	}
+	model, err := NewModel(files, names, positions)
+	if err != nil {
+		return err
+	}
+	// Queries see the model as its JSON encoding.
+	data, err := json.Marshal(model)
+	if err != nil {
+		return err
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	results, err := query(v)
+	if err != nil {
+		return err
+	}
+	for _, res := range results {
+		if s, ok := res.(string); ok && raw {
+			fmt.Fprintln(w, s)
+			continue
+		}
+		data, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\n", data)
+	}
+	return nil
+}
@@ -0,0 +1,422 @@
+package generate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gunk/gunk/httprule"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	base64Package      = protogen.GoImportPath("encoding/base64")
+	fmtPackage         = protogen.GoImportPath("fmt")
+	strconvPackage     = protogen.GoImportPath("strconv")
+	timePackage        = protogen.GoImportPath("time")
+	urlPackage         = protogen.GoImportPath("net/url")
+	durationpbPackage  = protogen.GoImportPath("google.golang.org/protobuf/types/known/durationpb")
+	timestamppbPackage = protogen.GoImportPath("google.golang.org/protobuf/types/known/timestamppb")
+)
+
+// wrappers are the well-known wrapper messages, set from a query parameter
+// like the scalar they wrap.
+var wrappers = map[protoreflect.FullName]bool{
+	"google.protobuf.BoolValue":   true,
+	"google.protobuf.BytesValue":  true,
+	"google.protobuf.DoubleValue": true,
+	"google.protobuf.FloatValue":  true,
+	"google.protobuf.Int32Value":  true,
+	"google.protobuf.Int64Value":  true,
+	"google.protobuf.StringValue": true,
+	"google.protobuf.UInt32Value": true,
+	"google.protobuf.UInt64Value": true,
+}
+
+// Generate generates, for each method of the files to generate with an HTTP
+// binding leaving fields of the request to the URL query, a function encoding
+// those fields as a query and one decoding them from a query, following the
+// rules of grpc-gateway.
+func Generate(gen *protogen.Plugin) error {
+	for _, f := range gen.Files {
+		if !f.Generate {
+			continue
+		}
+		var methods []*queryMethod
+		for _, srv := range f.Services {
+			for _, method := range srv.Methods {
+				m, err := newQueryMethod(method)
+				if err != nil {
+					return err
+				}
+				if m != nil {
+					methods = append(methods, m)
+				}
+			}
+		}
+		if len(methods) == 0 {
+			continue
+		}
+		g := gen.NewGeneratedFile(f.GeneratedFilenamePrefix+".query.go", f.GoImportPath)
+		g.P(`// Code generated by "querygen"; DO NOT EDIT.`)
+		g.P("// source: ", f.Desc.Path())
+		g.P()
+		g.P("package ", f.GoPackageName)
+		for _, m := range methods {
+			m.generate(g)
+		}
+	}
+	return nil
+}
+
+// queryMethod is a method with an HTTP binding, along with the fields of its
+// request which are read from the URL query.
+type queryMethod struct {
+	method *protogen.Method
+	fields []queryField
+}
+
+// queryField is a field read from the URL query, along with the message
+// fields leading to it from the request.
+type queryField []*protogen.Field
+
+// newQueryMethod returns the query fields of method, or nil if it has no
+// HTTP binding or its whole request is the body. Fields bound by the path
+// template or the body aren't read from the query, and neither are maps,
+// repeated messages, messages in oneofs and recursive messages.
+func newQueryMethod(method *protogen.Method) (*queryMethod, error) {
+	if method.Desc.IsStreamingClient() {
+		return nil, nil
+	}
+	rule, ok := proto.GetExtension(method.Desc.Options(), annotations.E_Http).(*annotations.HttpRule)
+	if !ok || rule == nil {
+		return nil, nil
+	}
+	var path string
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		path = p.Get
+	case *annotations.HttpRule_Put:
+		path = p.Put
+	case *annotations.HttpRule_Post:
+		path = p.Post
+	case *annotations.HttpRule_Delete:
+		path = p.Delete
+	case *annotations.HttpRule_Patch:
+		path = p.Patch
+	case *annotations.HttpRule_Custom:
+		path = p.Custom.GetPath()
+	default:
+		return nil, nil
+	}
+	if rule.GetBody() == "*" {
+		return nil, nil
+	}
+	compiler, err := httprule.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path template %q of %s: %w", path, method.Desc.FullName(), err)
+	}
+	var bound [][]string
+	for _, f := range compiler.Compile().Fields {
+		bound = append(bound, strings.Split(f, "."))
+	}
+	if body := rule.GetBody(); body != "" {
+		bound = append(bound, strings.Split(body, "."))
+	}
+	m := &queryMethod{method: method}
+	seen := map[*protogen.Message]bool{method.Input: true}
+	var walk func(msg *protogen.Message, parents queryField)
+	walk = func(msg *protogen.Message, parents queryField) {
+		for _, field := range msg.Fields {
+			qf := append(parents[:len(parents):len(parents)], field)
+			if qf.bound(bound) {
+				continue
+			}
+			fd := field.Desc
+			switch {
+			case fd.IsMap(), field.Oneof != nil && field.Oneof.Desc.IsSynthetic():
+			case fd.Message() == nil || isScalarMessage(fd.Message()):
+				if !fd.IsList() || fd.Message() == nil {
+					m.fields = append(m.fields, qf)
+				}
+			case fd.IsList(), field.Oneof != nil, seen[field.Message]:
+			default:
+				seen[field.Message] = true
+				walk(field.Message, qf)
+				delete(seen, field.Message)
+			}
+		}
+	}
+	walk(method.Input, nil)
+	return m, nil
+}
+
+// isScalarMessage reports whether messages of type msg are read from a single
+// query parameter, like timestamps and wrappers.
+func isScalarMessage(msg protoreflect.MessageDescriptor) bool {
+	switch msg.FullName() {
+	case "google.protobuf.Timestamp", "google.protobuf.Duration":
+		return true
+	}
+	return wrappers[msg.FullName()]
+}
+
+// bound reports whether f is, or is nested in, one of the bound field paths.
+func (f queryField) bound(bound [][]string) bool {
+bound:
+	for _, b := range bound {
+		if len(b) > len(f) {
+			continue
+		}
+		for i, name := range b {
+			if string(f[i].Desc.Name()) != name {
+				continue bound
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// last returns the field read from the query.
+func (f queryField) last() *protogen.Field {
+	return f[len(f)-1]
+}
+
+// key returns the query parameter of f, using the JSON names of the fields.
+func (f queryField) key() string {
+	names := make([]string, len(f))
+	for i, field := range f {
+		names[i] = field.Desc.JSONName()
+	}
+	return strings.Join(names, ".")
+}
+
+// keys returns the query parameters f is decoded from, as a gateway accepts
+// both the JSON and the proto name of each field of the path.
+func (f queryField) keys() []string {
+	keys := []string{""}
+	for i, field := range f {
+		names := []string{string(field.Desc.Name())}
+		if json := field.Desc.JSONName(); json != names[0] {
+			names = append(names, json)
+		}
+		var next []string
+		for _, k := range keys {
+			for _, name := range names {
+				if i > 0 {
+					name = k + "." + name
+				}
+				next = append(next, name)
+			}
+		}
+		keys = next
+	}
+	return keys
+}
+
+func (m *queryMethod) generate(g *protogen.GeneratedFile) {
+	srv := m.method.Parent.GoName
+	name := srv + m.method.GoName
+	input := g.QualifiedGoIdent(m.method.Input.GoIdent)
+
+	g.P()
+	g.P("// ", name, "Query encodes the fields of req which the HTTP binding of")
+	g.P("// ", srv, ".", m.method.GoName, " reads from the URL query, skipping those with zero values.")
+	g.P("func ", name, "Query(req *", input, ") ", urlPackage.Ident("Values"), " {")
+	g.P("q := make(", urlPackage.Ident("Values"), ")")
+	for _, f := range m.fields {
+		m.generateEncode(g, f)
+	}
+	g.P("return q")
+	g.P("}")
+
+	g.P()
+	g.P("// Parse", name, "Query decodes the fields of req which the HTTP binding of")
+	g.P("// ", srv, ".", m.method.GoName, " reads from the URL query. Unknown parameters are ignored.")
+	g.P("func Parse", name, "Query(req *", input, ", query ", urlPackage.Ident("Values"), ") error {")
+	if len(m.fields) > 0 {
+		g.P("for key, values := range query {")
+		g.P("switch key {")
+		for _, f := range m.fields {
+			m.generateDecode(g, f)
+		}
+		g.P("}")
+		g.P("}")
+	}
+	g.P("return nil")
+	g.P("}")
+}
+
+func (m *queryMethod) generateEncode(g *protogen.GeneratedFile, f queryField) {
+	getter := "req"
+	for _, field := range f[:len(f)-1] {
+		getter += ".Get" + field.GoName + "()"
+	}
+	field := f.last()
+	key := fmt.Sprintf("%q", f.key())
+	switch {
+	case field.Desc.IsList():
+		g.P("for _, v := range ", getter, ".Get", field.GoName, "() {")
+		g.P("q.Add(", key, ", ", formatValue(g, field.Desc, "v"), ")")
+	case field.Oneof != nil:
+		g.P("if v, ok := ", getter, ".Get", field.Oneof.GoName, "().(*", g.QualifiedGoIdent(field.GoIdent), "); ok {")
+		g.P("q.Set(", key, ", ", formatValue(g, field.Desc, "v."+field.GoName), ")")
+	default:
+		g.P("if v := ", getter, ".Get", field.GoName, "(); ", nonZero(field.Desc, "v"), " {")
+		g.P("q.Set(", key, ", ", formatValue(g, field.Desc, "v"), ")")
+	}
+	g.P("}")
+}
+
+// nonZero returns a condition which holds if v, a value of field, is not its
+// zero value.
+func nonZero(field protoreflect.FieldDescriptor, v string) string {
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		return v
+	case protoreflect.StringKind:
+		return v + ` != ""`
+	case protoreflect.BytesKind:
+		return "len(" + v + ") > 0"
+	case protoreflect.MessageKind:
+		return v + " != nil"
+	}
+	return v + " != 0"
+}
+
+// formatValue returns an expression formatting v, a value of field, as a
+// query parameter.
+func formatValue(g *protogen.GeneratedFile, field protoreflect.FieldDescriptor, v string) string {
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		return g.QualifiedGoIdent(strconvPackage.Ident("FormatBool")) + "(" + v + ")"
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return g.QualifiedGoIdent(strconvPackage.Ident("FormatInt")) + "(int64(" + v + "), 10)"
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return g.QualifiedGoIdent(strconvPackage.Ident("FormatInt")) + "(" + v + ", 10)"
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return g.QualifiedGoIdent(strconvPackage.Ident("FormatUint")) + "(uint64(" + v + "), 10)"
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return g.QualifiedGoIdent(strconvPackage.Ident("FormatUint")) + "(" + v + ", 10)"
+	case protoreflect.FloatKind:
+		return g.QualifiedGoIdent(strconvPackage.Ident("FormatFloat")) + "(float64(" + v + "), 'g', -1, 32)"
+	case protoreflect.DoubleKind:
+		return g.QualifiedGoIdent(strconvPackage.Ident("FormatFloat")) + "(" + v + ", 'g', -1, 64)"
+	case protoreflect.BytesKind:
+		return g.QualifiedGoIdent(base64Package.Ident("URLEncoding")) + ".EncodeToString(" + v + ")"
+	case protoreflect.EnumKind:
+		return v + ".String()"
+	case protoreflect.MessageKind:
+		switch msg := field.Message(); msg.FullName() {
+		case "google.protobuf.Timestamp":
+			return v + ".AsTime().Format(" + g.QualifiedGoIdent(timePackage.Ident("RFC3339Nano")) + ")"
+		case "google.protobuf.Duration":
+			return g.QualifiedGoIdent(strconvPackage.Ident("FormatFloat")) + "(" + v + ".AsDuration().Seconds(), 'f', -1, 64) + \"s\""
+		default:
+			return formatValue(g, msg.Fields().ByName("value"), v+".GetValue()")
+		}
+	}
+	return v
+}
+
+func (m *queryMethod) generateDecode(g *protogen.GeneratedFile, f queryField) {
+	var keys []string
+	for _, k := range f.keys() {
+		keys = append(keys, fmt.Sprintf("%q", k))
+	}
+	g.P("case ", strings.Join(keys, ", "), ":")
+	dst := "req"
+	for _, field := range f[:len(f)-1] {
+		dst += "." + field.GoName
+		g.P("if ", dst, " == nil {")
+		g.P(dst, " = new(", g.QualifiedGoIdent(field.Message.GoIdent), ")")
+		g.P("}")
+	}
+	field := f.last()
+	if field.Desc.IsList() {
+		dst += "." + field.GoName
+		g.P("for _, s := range values {")
+		v := parseValue(g, field, "s")
+		g.P(dst, " = append(", dst, ", ", v, ")")
+		g.P("}")
+		return
+	}
+	g.P("if len(values) > 1 {")
+	g.P("return ", fmtPackage.Ident("Errorf"), `("too many values for %s: %q", key, values)`)
+	g.P("}")
+	v := parseValue(g, field, "values[0]")
+	if field.Oneof != nil {
+		g.P(dst, ".", field.Oneof.GoName, " = &", g.QualifiedGoIdent(field.GoIdent), "{", field.GoName, ": ", v, "}")
+		return
+	}
+	g.P(dst, ".", field.GoName, " = ", v)
+}
+
+// parseValue generates the statements parsing s as a value of field, and
+// returns an expression for the parsed value.
+func parseValue(g *protogen.GeneratedFile, field *protogen.Field, s string) string {
+	fd := field.Desc
+	if fd.Kind() == protoreflect.MessageKind {
+		switch field.Message.Desc.FullName() {
+		case "google.protobuf.Timestamp":
+			parseCall(g, "v", s, timePackage.Ident("Parse"), "(", timePackage.Ident("RFC3339Nano"), ", ", s, ")")
+			return g.QualifiedGoIdent(timestamppbPackage.Ident("New")) + "(v)"
+		case "google.protobuf.Duration":
+			parseCall(g, "v", s, timePackage.Ident("ParseDuration"), "(", s, ")")
+			return g.QualifiedGoIdent(durationpbPackage.Ident("New")) + "(v)"
+		}
+		value := field.Message.Fields[0]
+		return "&" + g.QualifiedGoIdent(field.Message.GoIdent) + "{Value: " + parseValue(g, value, s) + "}"
+	}
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return s
+	case protoreflect.BoolKind:
+		parseCall(g, "v", s, strconvPackage.Ident("ParseBool"), "(", s, ")")
+		return "v"
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		parseCall(g, "v", s, strconvPackage.Ident("ParseInt"), "(", s, ", 10, 32)")
+		return "int32(v)"
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		parseCall(g, "v", s, strconvPackage.Ident("ParseInt"), "(", s, ", 10, 64)")
+		return "v"
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		parseCall(g, "v", s, strconvPackage.Ident("ParseUint"), "(", s, ", 10, 32)")
+		return "uint32(v)"
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		parseCall(g, "v", s, strconvPackage.Ident("ParseUint"), "(", s, ", 10, 64)")
+		return "v"
+	case protoreflect.FloatKind:
+		parseCall(g, "v", s, strconvPackage.Ident("ParseFloat"), "(", s, ", 32)")
+		return "float32(v)"
+	case protoreflect.DoubleKind:
+		parseCall(g, "v", s, strconvPackage.Ident("ParseFloat"), "(", s, ", 64)")
+		return "v"
+	case protoreflect.BytesKind:
+		parseCall(g, "v", s, base64Package.Ident("URLEncoding"), ".DecodeString(", s, ")")
+		return "v"
+	case protoreflect.EnumKind:
+		// Like a gateway, accept both the names and the numbers of
+		// enum values.
+		enum := g.QualifiedGoIdent(field.Enum.GoIdent)
+		g.P("v, ok := ", enum, "_value[", s, "]")
+		g.P("if !ok {")
+		parseCall(g, "n", s, strconvPackage.Ident("ParseInt"), "(", s, ", 10, 32)")
+		g.P("v = int32(n)")
+		g.P("}")
+		return enum + "(v)"
+	}
+	panic(fmt.Sprintf("unexpected field kind %v", fd.Kind()))
+}
+
+// parseCall generates a call to a function parsing s, assigning its result to
+// v and returning an error if it fails.
+func parseCall(g *protogen.GeneratedFile, v, s string, call ...interface{}) {
+	g.P(append([]interface{}{v, ", err := "}, call...)...)
+	g.P("if err != nil {")
+	g.P("return ", fmtPackage.Ident("Errorf"), `("invalid value %q for %s: %w", `, s, ", key, err)")
+	g.P("}")
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/gunk/gunk/plugin"
+	"github.com/gunk/gunk/querygen/generate"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func main() {
+	plugin.RunMain(new(queryPlugin))
+}
+
+type queryPlugin struct{}
+
+func (q *queryPlugin) Generate(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	gen, err := protogen.Options{}.New(req)
+	if err != nil {
+		return nil, err
+	}
+	// Proto3 optional fields are skipped, like maps.
+	gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+	if err := generate.Generate(gen); err != nil {
+		return nil, err
+	}
+	return gen.Response(), nil
+}
@@ -0,0 +1,210 @@
+// Package reflectionserver serves the gRPC server reflection protocol from a
+// FileDescriptorSet, so that tools such as grpcurl can introspect Gunk
+// packages without any generated code.
+package reflectionserver
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Server is a gRPC reflection service describing the files of a
+// FileDescriptorSet, rather than the services registered in the gRPC server.
+type Server struct {
+	rpb.UnimplementedServerReflectionServer
+
+	files *protoregistry.Files
+	// Encoded FileDescriptorProtos, by file name.
+	encoded  map[string][]byte
+	services []string
+}
+
+// New returns a Server describing the files of fds, which must include all
+// their dependencies.
+func New(fds *descriptorpb.FileDescriptorSet) (*Server, error) {
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{files: files, encoded: make(map[string][]byte)}
+	for _, fd := range fds.GetFile() {
+		data, err := proto.MarshalOptions{Deterministic: true}.Marshal(fd)
+		if err != nil {
+			return nil, err
+		}
+		s.encoded[fd.GetName()] = data
+		for _, srv := range fd.GetService() {
+			name := srv.GetName()
+			if pkg := fd.GetPackage(); pkg != "" {
+				name = pkg + "." + name
+			}
+			s.services = append(s.services, name)
+		}
+	}
+	sort.Strings(s.services)
+	return s, nil
+}
+
+// Services returns the full names of the services described, sorted.
+func (s *Server) Services() []string {
+	return s.services
+}
+
+// Register registers s as the reflection service of srv.
+func (s *Server) Register(srv *grpc.Server) {
+	rpb.RegisterServerReflectionServer(srv, s)
+}
+
+// ServerReflectionInfo implements the reflection service. As with the
+// reflection service of grpc-go, each file is only sent once per stream.
+func (s *Server) ServerReflectionInfo(stream rpb.ServerReflection_ServerReflectionInfoServer) error {
+	sent := make(map[string]bool)
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		out := &rpb.ServerReflectionResponse{
+			ValidHost:       in.Host,
+			OriginalRequest: in,
+		}
+		var fd protoreflect.FileDescriptor
+		switch req := in.MessageRequest.(type) {
+		case *rpb.ServerReflectionRequest_FileByFilename:
+			fd, err = s.files.FindFileByPath(req.FileByFilename)
+			if err != nil {
+				err = fmt.Errorf("unknown file: %v", req.FileByFilename)
+			}
+		case *rpb.ServerReflectionRequest_FileContainingSymbol:
+			var d protoreflect.Descriptor
+			d, err = s.files.FindDescriptorByName(protoreflect.FullName(req.FileContainingSymbol))
+			if err != nil {
+				err = fmt.Errorf("unknown symbol: %v", req.FileContainingSymbol)
+			} else {
+				fd = d.ParentFile()
+			}
+		case *rpb.ServerReflectionRequest_FileContainingExtension:
+			ext := req.FileContainingExtension
+			s.rangeExtensions(ext.ContainingType, func(xd protoreflect.ExtensionDescriptor) {
+				if int32(xd.Number()) == ext.ExtensionNumber {
+					fd = xd.ParentFile()
+				}
+			})
+			if fd == nil {
+				err = fmt.Errorf("unknown extension %d of %v", ext.ExtensionNumber, ext.ContainingType)
+			}
+		case *rpb.ServerReflectionRequest_AllExtensionNumbersOfType:
+			var nums []int32
+			nums, err = s.extensionNumbers(req.AllExtensionNumbersOfType)
+			if err == nil {
+				out.MessageResponse = &rpb.ServerReflectionResponse_AllExtensionNumbersResponse{
+					AllExtensionNumbersResponse: &rpb.ExtensionNumberResponse{
+						BaseTypeName:    req.AllExtensionNumbersOfType,
+						ExtensionNumber: nums,
+					},
+				}
+			}
+		case *rpb.ServerReflectionRequest_ListServices:
+			list := make([]*rpb.ServiceResponse, len(s.services))
+			for i, name := range s.services {
+				list[i] = &rpb.ServiceResponse{Name: name}
+			}
+			out.MessageResponse = &rpb.ServerReflectionResponse_ListServicesResponse{
+				ListServicesResponse: &rpb.ListServiceResponse{Service: list},
+			}
+		default:
+			return fmt.Errorf("invalid MessageRequest: %v", in.MessageRequest)
+		}
+		switch {
+		case err != nil:
+			out.MessageResponse = &rpb.ServerReflectionResponse_ErrorResponse{
+				ErrorResponse: &rpb.ErrorResponse{
+					ErrorCode:    int32(codes.NotFound),
+					ErrorMessage: err.Error(),
+				},
+			}
+		case fd != nil:
+			out.MessageResponse = &rpb.ServerReflectionResponse_FileDescriptorResponse{
+				FileDescriptorResponse: &rpb.FileDescriptorResponse{
+					FileDescriptorProto: s.withDependencies(fd, sent),
+				},
+			}
+		}
+		if err := stream.Send(out); err != nil {
+			return err
+		}
+	}
+}
+
+// withDependencies returns the encoded fd and its transitive dependencies,
+// leaving out the files already sent, and marks them as sent.
+func (s *Server) withDependencies(fd protoreflect.FileDescriptor, sent map[string]bool) [][]byte {
+	var list [][]byte
+	queue := []protoreflect.FileDescriptor{fd}
+	for len(queue) > 0 {
+		fd := queue[0]
+		queue = queue[1:]
+		if sent[fd.Path()] {
+			continue
+		}
+		sent[fd.Path()] = true
+		list = append(list, s.encoded[fd.Path()])
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			queue = append(queue, imports.Get(i).FileDescriptor)
+		}
+	}
+	return list
+}
+
+// extensionNumbers returns the numbers of the extensions of the message named
+// typeName, sorted.
+func (s *Server) extensionNumbers(typeName string) ([]int32, error) {
+	d, err := s.files.FindDescriptorByName(protoreflect.FullName(typeName))
+	if _, ok := d.(protoreflect.MessageDescriptor); err != nil || !ok {
+		return nil, fmt.Errorf("unknown message: %v", typeName)
+	}
+	var nums []int32
+	s.rangeExtensions(typeName, func(xd protoreflect.ExtensionDescriptor) {
+		nums = append(nums, int32(xd.Number()))
+	})
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+	return nums, nil
+}
+
+// rangeExtensions calls fn for each extension of the message named typeName,
+// whether declared at the top level of a file or within a message.
+func (s *Server) rangeExtensions(typeName string, fn func(protoreflect.ExtensionDescriptor)) {
+	visit := func(exts protoreflect.ExtensionDescriptors) {
+		for i := 0; i < exts.Len(); i++ {
+			if xd := exts.Get(i); string(xd.ContainingMessage().FullName()) == typeName {
+				fn(xd)
+			}
+		}
+	}
+	var visitMessages func(msgs protoreflect.MessageDescriptors)
+	visitMessages = func(msgs protoreflect.MessageDescriptors) {
+		for i := 0; i < msgs.Len(); i++ {
+			visit(msgs.Get(i).Extensions())
+			visitMessages(msgs.Get(i).Messages())
+		}
+	}
+	s.files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		visit(fd.Extensions())
+		visitMessages(fd.Messages())
+		return true
+	})
+}
@@ -0,0 +1,141 @@
+package reflectionserver
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestServerReflectionInfo(t *testing.T) {
+	descriptor := protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto)
+	util := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("testdata.tld/util/all.proto"),
+		Package:    proto.String("util"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/descriptor.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Message"),
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Util"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("Echo"),
+				InputType:  proto.String(".util.Message"),
+				OutputType: proto.String(".util.Message"),
+			}},
+		}},
+		Extension: []*descriptorpb.FieldDescriptorProto{{
+			Name:     proto.String("tag"),
+			Number:   proto.Int32(5000),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			Extendee: proto.String(".google.protobuf.MessageOptions"),
+			JsonName: proto.String("tag"),
+		}},
+	}
+	s, err := New(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{descriptor, util}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	s.Register(srv)
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// files returns the names of the files in the response to req, or the
+	// error message prefixed with "error: ".
+	files := func(req *rpb.ServerReflectionRequest) []string {
+		t.Helper()
+		if err := stream.Send(req); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e := resp.GetErrorResponse(); e != nil {
+			return []string{"error: " + e.ErrorMessage}
+		}
+		var names []string
+		for _, data := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			var fd descriptorpb.FileDescriptorProto
+			if err := proto.Unmarshal(data, &fd); err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, fd.GetName())
+		}
+		return names
+	}
+	tests := []struct {
+		req  *rpb.ServerReflectionRequest
+		want []string
+	}{
+		// The dependencies are sent along with the first file.
+		{
+			&rpb.ServerReflectionRequest{MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: "util.Util.Echo"}},
+			[]string{"testdata.tld/util/all.proto", "google/protobuf/descriptor.proto"},
+		},
+		// Files are only sent once per stream.
+		{
+			&rpb.ServerReflectionRequest{MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: "google/protobuf/descriptor.proto"}},
+			nil,
+		},
+		{
+			&rpb.ServerReflectionRequest{MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: "util.Missing"}},
+			[]string{"error: unknown symbol: util.Missing"},
+		},
+		{
+			&rpb.ServerReflectionRequest{MessageRequest: &rpb.ServerReflectionRequest_FileContainingExtension{FileContainingExtension: &rpb.ExtensionRequest{
+				ContainingType:  "google.protobuf.MessageOptions",
+				ExtensionNumber: 4999,
+			}}},
+			[]string{"error: unknown extension 4999 of google.protobuf.MessageOptions"},
+		},
+	}
+	for _, tc := range tests {
+		if got := files(tc.req); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: got %q, want %q", tc.req.MessageRequest, got, tc.want)
+		}
+	}
+
+	if err := stream.Send(&rpb.ServerReflectionRequest{MessageRequest: &rpb.ServerReflectionRequest_ListServices{}}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := resp.GetListServicesResponse().GetService(); len(list) != 1 || list[0].Name != "util.Util" {
+		t.Errorf("unexpected services: %v", list)
+	}
+
+	if err := stream.Send(&rpb.ServerReflectionRequest{MessageRequest: &rpb.ServerReflectionRequest_AllExtensionNumbersOfType{AllExtensionNumbersOfType: "google.protobuf.MessageOptions"}}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nums := resp.GetAllExtensionNumbersResponse().GetExtensionNumber(); !reflect.DeepEqual(nums, []int32{5000}) {
+		t.Errorf("unexpected extension numbers: %v", nums)
+	}
+}
@@ -0,0 +1,43 @@
+package reflectionserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/gunk/gunk/generate"
+	"google.golang.org/grpc"
+)
+
+// Run serves the Gunk packages matching patterns over the gRPC reflection
+// protocol on addr, until ctx is done. The address being listened on is
+// printed to w. Calls to the described services themselves fail, as they are
+// not implemented.
+func Run(ctx context.Context, w io.Writer, addr, dir string, patterns ...string) error {
+	opts := generate.DescriptorSetOptions{IncludeImports: true, IncludeSourceInfo: true}
+	fds, err := generate.FileDescriptorSetWithOptions(opts, dir, patterns...)
+	if err != nil {
+		return err
+	}
+	s, err := New(fds)
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := grpc.NewServer()
+	s.Register(srv)
+	fmt.Fprintf(w, "serving %d services over gRPC reflection on %s\n", len(s.Services()), lis.Addr())
+	go func() {
+		<-ctx.Done()
+		srv.Stop()
+	}()
+	if err := srv.Serve(lis); err != nil && ctx.Err() == nil {
+		return err
+	}
+	// Being interrupted is the usual way to stop serving.
+	return nil
+}
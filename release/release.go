@@ -0,0 +1,228 @@
+// Package release implements `gunk release`, which tags a new version of the
+// Gunk packages in a git repository.
+package release
+
+import (
+	"errors"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gunk/gunk/breaking"
+	"github.com/gunk/gunk/config"
+	"github.com/gunk/gunk/generate"
+	"github.com/gunk/gunk/loader"
+	"github.com/gunk/gunk/log"
+)
+
+// Options configures a release.
+type Options struct {
+	// AllowBreaking allows releasing breaking changes without a major
+	// version bump.
+	AllowBreaking bool
+	// EnforceSuggested requires the version to be at least the one
+	// suggested by Suggest.
+	EnforceSuggested bool
+}
+
+// Run releases the Gunk packages matching patterns in dir as a new version.
+// The version is either a semantic version such as "v1.2.3", or one of
+// "major", "minor" and "patch" to bump the last released version.
+//
+// The working tree must be clean and the generated files up to date. Changes
+// since the last release which break clients require a major version bump.
+// If the .gunkconfig has a [go_module] version, it is updated, the files are
+// regenerated, and the result is committed. Finally, an annotated tag is
+// created for the release.
+//
+// A failed release leaves the tree as it was: the generated files are only
+// checked in memory, the files regenerated for the new version are staged in
+// a temporary copy of the module until they're all generated, and the
+// version bump and its commit are rolled back if anything fails after them.
+func Run(dir, version string, opts Options, patterns ...string) (err error) {
+	cfg, err := config.Load(dir)
+	if err != nil {
+		return fmt.Errorf("unable to load gunkconfig: %w", err)
+	}
+	if err := checkClean(dir, "working tree has uncommitted changes"); err != nil {
+		return err
+	}
+	checkOpts := generate.Options{DryRun: true, DiffOutput: ioutil.Discard}
+	if err := generate.RunWithOptions(checkOpts, dir, patterns...); err != nil {
+		var gerr *generate.Error
+		if errors.As(err, &gerr) && gerr.Kind == generate.VerifyError {
+			return fmt.Errorf("generated files are out of date; commit the output of gunk generate first")
+		}
+		return err
+	}
+	lastTag, last, err := lastRelease(dir, cfg)
+	if err != nil {
+		return err
+	}
+	next, err := nextVersion(last, version)
+	if err != nil {
+		return err
+	}
+	if !last.less(next) {
+		return fmt.Errorf("version %s is not newer than the last release %s", next, last)
+	}
+	if opts.EnforceSuggested && lastTag != "" {
+		s, err := Suggest(dir, patterns...)
+		if err != nil {
+			return err
+		}
+		if next.less(s.next) {
+			return fmt.Errorf("version %s is lower than the suggested %s, due to %s since %s", next, s.Version, s.Reason, lastTag)
+		}
+	}
+	if lastTag != "" {
+		pkgPaths, err := packagePaths(dir, patterns...)
+		if err != nil {
+			return err
+		}
+		changes, err := breaking.CompareRef(dir, lastTag, pkgPaths...)
+		if err != nil {
+			return fmt.Errorf("unable to check for breaking changes: %w", err)
+		}
+		if len(changes) > 0 {
+			log.Printf("breaking changes since %s:\n%s", lastTag, breaking.Describe(changes))
+			if next.major == last.major && last.major > 0 && !opts.AllowBreaking {
+				return fmt.Errorf("breaking changes since %s require a major version bump", lastTag)
+			}
+		}
+	}
+	tag := tagPrefix(cfg) + next.String()
+	head, err := breaking.Git(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		// The tree was clean at head, so this only undoes the
+		// release's own changes.
+		if _, rerr := breaking.Git(dir, "reset", "-q", "--hard", head); rerr != nil {
+			err = fmt.Errorf("%w; rolling back the release also failed: %v", err, rerr)
+		}
+	}()
+	if m := cfg.GoModule; m != nil && m.Version != "" {
+		if err := config.SetGoModuleVersion(filepath.Join(m.Dir, ".gunkconfig"), next.String()); err != nil {
+			return err
+		}
+		genOpts := generate.Options{Hermetic: true}
+		if err := generate.RunWithOptions(genOpts, dir, patterns...); err != nil {
+			return err
+		}
+		if _, err := breaking.Git(dir, "add", "-A"); err != nil {
+			return err
+		}
+		if _, err := breaking.Git(dir, "commit", "-m", "Release "+tag); err != nil {
+			return err
+		}
+	}
+	if _, err := breaking.Git(dir, "tag", "-a", tag, "-m", "Release "+tag); err != nil {
+		return err
+	}
+	log.Printf("tagged %s", tag)
+	return nil
+}
+
+// tagPrefix returns the prefix of the release tags set in cfg.
+func tagPrefix(cfg *config.Config) string {
+	if cfg.Release == nil {
+		return ""
+	}
+	return cfg.Release.TagPrefix
+}
+
+// lastRelease returns the tag and version of the last release, or an empty
+// tag and a zero version if there is none.
+func lastRelease(dir string, cfg *config.Config) (string, semver, error) {
+	prefix := tagPrefix(cfg)
+	lastTag, _ := breaking.Git(dir, "describe", "--tags", "--abbrev=0", "--match", prefix+"v*")
+	if lastTag == "" {
+		return "", semver{}, nil
+	}
+	last, err := parseSemver(strings.TrimPrefix(lastTag, prefix))
+	if err != nil {
+		return "", semver{}, fmt.Errorf("invalid last release tag %q: %w", lastTag, err)
+	}
+	return lastTag, last, nil
+}
+
+func checkClean(dir, msg string) error {
+	status, err := breaking.Git(dir, "status", "--porcelain")
+	if err != nil {
+		return err
+	}
+	if status != "" {
+		return fmt.Errorf("%s:\n%s", msg, status)
+	}
+	return nil
+}
+
+// packagePaths returns the import paths of the Gunk packages matching patterns.
+func packagePaths(dir string, patterns ...string) ([]string, error) {
+	l := loader.Loader{Dir: dir, Fset: token.NewFileSet()}
+	pkgs, err := l.Load(patterns...)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		paths = append(paths, pkg.PkgPath)
+	}
+	return paths, nil
+}
+
+type semver struct {
+	major, minor, patch int
+}
+
+func (v semver) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v.major, v.minor, v.patch)
+}
+
+func (v semver) less(w semver) bool {
+	if v.major != w.major {
+		return v.major < w.major
+	}
+	if v.minor != w.minor {
+		return v.minor < w.minor
+	}
+	return v.patch < w.patch
+}
+
+func parseSemver(s string) (semver, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if !strings.HasPrefix(s, "v") || len(parts) != 3 {
+		return semver{}, fmt.Errorf("%q is not of the form vMAJOR.MINOR.PATCH", s)
+	}
+	var nums [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return semver{}, fmt.Errorf("%q is not of the form vMAJOR.MINOR.PATCH", s)
+		}
+		nums[i] = n
+	}
+	return semver{nums[0], nums[1], nums[2]}, nil
+}
+
+// nextVersion returns the version to release, given the last release and the
+// version argument.
+func nextVersion(last semver, version string) (semver, error) {
+	switch version {
+	case "major":
+		return semver{last.major + 1, 0, 0}, nil
+	case "minor":
+		return semver{last.major, last.minor + 1, 0}, nil
+	case "patch":
+		return semver{last.major, last.minor, last.patch + 1}, nil
+	}
+	return parseSemver(version)
+}
@@ -0,0 +1,117 @@
+package release
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gunk/gunk/breaking"
+	"github.com/gunk/gunk/config"
+	"google.golang.org/protobuf/proto"
+)
+
+// Suggestion is the version suggested for the next release of a set of Gunk
+// packages, based on how their descriptors changed since the last release.
+type Suggestion struct {
+	// LastTag is the tag of the last release, or empty if there is none.
+	LastTag string
+	// Bump is one of "major", "minor" and "patch".
+	Bump string
+	// Version is the suggested version, such as "v1.3.0".
+	Version string
+	// Reason explains why Bump was chosen.
+	Reason string
+	// Breaking and Additions are the changes since the last release.
+	Breaking  []breaking.Change
+	Additions []breaking.Change
+
+	next semver
+}
+
+// Suggest suggests the version of the next release of the Gunk packages
+// matching patterns in dir, comparing them with the last release:
+//
+//   - breaking changes require a major version bump, or a minor one before
+//     v1.0.0, as in Go modules;
+//   - additions, such as new messages, fields or methods, require a minor
+//     version bump;
+//   - any other changes, such as to comments or options, only need a patch
+//     version bump.
+//
+// Without a previous release, v0.1.0 is suggested.
+func Suggest(dir string, patterns ...string) (*Suggestion, error) {
+	cfg, err := config.Load(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load gunkconfig: %w", err)
+	}
+	lastTag, last, err := lastRelease(dir, cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &Suggestion{LastTag: lastTag}
+	if lastTag == "" {
+		return s.bump(last, "minor", "no previous release")
+	}
+	pkgPaths, err := packagePaths(dir, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := breaking.DescriptorSetAtRef(dir, lastTag, pkgPaths...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the last release: %w", err)
+	}
+	cur, err := breaking.DescriptorSet(dir, pkgPaths...)
+	if err != nil {
+		return nil, err
+	}
+	s.Breaking = breaking.Compare(prev, cur)
+	s.Additions = breaking.Additions(prev, cur)
+	switch {
+	case len(s.Breaking) > 0 && last.major == 0:
+		return s.bump(last, "minor", "breaking changes before v1.0.0")
+	case len(s.Breaking) > 0:
+		return s.bump(last, "major", "breaking changes")
+	case len(s.Additions) > 0:
+		return s.bump(last, "minor", "backwards compatible additions")
+	case proto.Equal(prev, cur):
+		return s.bump(last, "patch", "no changes to the API")
+	}
+	return s.bump(last, "patch", "no additions or breaking changes")
+}
+
+func (s *Suggestion) bump(last semver, bump, reason string) (*Suggestion, error) {
+	next, err := nextVersion(last, bump)
+	if err != nil {
+		return nil, err
+	}
+	s.Bump, s.Reason, s.next = bump, reason, next
+	s.Version = next.String()
+	return s, nil
+}
+
+// RunSuggest prints the suggested version for the next release of the Gunk
+// packages matching patterns in dir to w, along with the changes it is based
+// on.
+func RunSuggest(w io.Writer, dir string, patterns ...string) error {
+	s, err := Suggest(dir, patterns...)
+	if err != nil {
+		return err
+	}
+	if s.LastTag != "" {
+		fmt.Fprintf(w, "last release: %s\n", s.LastTag)
+	}
+	printChanges(w, "breaking changes", s.Breaking)
+	printChanges(w, "additions", s.Additions)
+	fmt.Fprintf(w, "suggested version: %s (%s: %s)\n", s.Version, s.Bump, s.Reason)
+	return nil
+}
+
+func printChanges(w io.Writer, title string, changes []breaking.Change) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%s:\n", title)
+	for _, line := range strings.Split(strings.TrimSuffix(breaking.Describe(changes), "\n"), "\n") {
+		fmt.Fprintf(w, "\t%s\n", line)
+	}
+}
@@ -0,0 +1,211 @@
+package generate
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gunk/gunk/retrygen/methodpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const grpcPackage = protogen.GoImportPath("google.golang.org/grpc")
+
+// Policy is the retry policy of the methods which can be retried, along with
+// the default timeout of all methods.
+type Policy struct {
+	MaxAttempts       int
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
+	// RetryableCodes are the canonical names of the status codes on which
+	// calls are retried, such as UNAVAILABLE.
+	RetryableCodes []string
+	// Timeout is the timeout of calls, unless the deadline of their
+	// context is sooner. Zero means no timeout.
+	Timeout time.Duration
+}
+
+// ParseCodes parses the space separated status codes, such as "UNAVAILABLE
+// RESOURCE_EXHAUSTED".
+func ParseCodes(s string) ([]string, error) {
+	var list []string
+	for _, name := range strings.Fields(s) {
+		name = strings.ToUpper(name)
+		var code codes.Code
+		if err := code.UnmarshalJSON([]byte(strconv.Quote(name))); err != nil {
+			return nil, fmt.Errorf("unknown status code %q", name)
+		}
+		if code == codes.OK {
+			return nil, fmt.Errorf("status code %s cannot be retried", name)
+		}
+		list = append(list, name)
+	}
+	return list, nil
+}
+
+// Validate returns an error if the policy would be rejected by gRPC.
+func (p Policy) Validate() error {
+	switch {
+	case p.MaxAttempts < 2:
+		return fmt.Errorf("max_attempts must be at least 2, got %d", p.MaxAttempts)
+	case p.InitialBackoff <= 0:
+		return fmt.Errorf("initial_backoff must be positive, got %s", p.InitialBackoff)
+	case p.MaxBackoff < p.InitialBackoff:
+		return fmt.Errorf("max_backoff must not be below initial_backoff, got %s", p.MaxBackoff)
+	case p.BackoffMultiplier <= 0:
+		return fmt.Errorf("backoff_multiplier must be positive, got %v", p.BackoffMultiplier)
+	case len(p.RetryableCodes) == 0:
+		return fmt.Errorf("retryable_codes must not be empty")
+	case p.Timeout < 0:
+		return fmt.Errorf("timeout must not be negative, got %s", p.Timeout)
+	}
+	return nil
+}
+
+// Generate generates, for each file to generate with services, the gRPC
+// service config retrying the methods which are safe to retry, and a dial
+// option using it.
+func Generate(gen *protogen.Plugin, policy Policy) error {
+	for _, f := range gen.Files {
+		if !f.Generate || len(f.Services) == 0 {
+			continue
+		}
+		data, err := json.MarshalIndent(serviceConfig(f, policy), "", "  ")
+		if err != nil {
+			return err
+		}
+		g := gen.NewGeneratedFile(f.GeneratedFilenamePrefix+".retry.go", f.GoImportPath)
+		g.P(`// Code generated by "retrygen"; DO NOT EDIT.`)
+		g.P("// source: ", f.Desc.Path())
+		g.P()
+		g.P("package ", f.GoPackageName)
+		g.P()
+		g.P("// RetryServiceConfig is the gRPC service config of the services of this")
+		g.P("// package. Methods which are idempotent or have no side effects, as set with")
+		g.P("// their IdempotencyLevel option, are retried with exponential backoff. Each")
+		g.P("// call, along with its retries, is bound by the deadline of its context, and")
+		g.P("// by the timeout of its method, if any.")
+		g.P("const RetryServiceConfig = `", string(data), "`")
+		g.P()
+		g.P("// WithRetries returns a dial option using RetryServiceConfig, unless the name")
+		g.P("// resolver provides a service config.")
+		g.P("func WithRetries() ", grpcPackage.Ident("DialOption"), " {")
+		g.P("return ", grpcPackage.Ident("WithDefaultServiceConfig"), "(RetryServiceConfig)")
+		g.P("}")
+	}
+	return nil
+}
+
+// The JSON form of the gRPC service config, as documented in
+// https://github.com/grpc/grpc/blob/master/doc/service_config.md.
+type (
+	jsonServiceConfig struct {
+		MethodConfig []jsonMethodConfig `json:"methodConfig"`
+	}
+	jsonMethodConfig struct {
+		Name        []jsonName       `json:"name"`
+		Timeout     string           `json:"timeout,omitempty"`
+		RetryPolicy *jsonRetryPolicy `json:"retryPolicy,omitempty"`
+	}
+	jsonName struct {
+		Service string `json:"service"`
+		Method  string `json:"method,omitempty"`
+	}
+	jsonRetryPolicy struct {
+		MaxAttempts          int      `json:"maxAttempts"`
+		InitialBackoff       string   `json:"initialBackoff"`
+		MaxBackoff           string   `json:"maxBackoff"`
+		BackoffMultiplier    float64  `json:"backoffMultiplier"`
+		RetryableStatusCodes []string `json:"retryableStatusCodes"`
+	}
+)
+
+// serviceConfig returns the service config of the services of f. The methods
+// which can be retried share a config with the retry policy, while the other
+// methods only get the timeout, as the default of their service. Methods with
+// a timeout of their own, set with their gunk.method.timeout option, get a
+// config of their own overriding it.
+func serviceConfig(f *protogen.File, policy Policy) jsonServiceConfig {
+	timeout := ""
+	if policy.Timeout > 0 {
+		timeout = duration(policy.Timeout)
+	}
+	var retried, services []jsonName
+	var own []jsonMethodConfig
+	for _, srv := range f.Services {
+		name := string(srv.Desc.FullName())
+		services = append(services, jsonName{Service: name})
+		for _, method := range srv.Methods {
+			methodName := jsonName{Service: name, Method: string(method.Desc.Name())}
+			if d := methodTimeout(method); d > 0 {
+				cfg := jsonMethodConfig{Name: []jsonName{methodName}, Timeout: duration(d)}
+				if canRetry(method) {
+					cfg.RetryPolicy = retryPolicy(policy)
+				}
+				own = append(own, cfg)
+			} else if canRetry(method) {
+				retried = append(retried, methodName)
+			}
+		}
+	}
+	cfg := jsonServiceConfig{MethodConfig: []jsonMethodConfig{}}
+	if len(retried) > 0 {
+		cfg.MethodConfig = append(cfg.MethodConfig, jsonMethodConfig{
+			Name:        retried,
+			Timeout:     timeout,
+			RetryPolicy: retryPolicy(policy),
+		})
+	}
+	cfg.MethodConfig = append(cfg.MethodConfig, own...)
+	if timeout != "" {
+		cfg.MethodConfig = append(cfg.MethodConfig, jsonMethodConfig{
+			Name:    services,
+			Timeout: timeout,
+		})
+	}
+	return cfg
+}
+
+func retryPolicy(policy Policy) *jsonRetryPolicy {
+	return &jsonRetryPolicy{
+		MaxAttempts:          policy.MaxAttempts,
+		InitialBackoff:       duration(policy.InitialBackoff),
+		MaxBackoff:           duration(policy.MaxBackoff),
+		BackoffMultiplier:    policy.BackoffMultiplier,
+		RetryableStatusCodes: policy.RetryableCodes,
+	}
+}
+
+// methodTimeout returns the timeout of method set with its gunk.method.timeout
+// option, or zero if it has none.
+func methodTimeout(method *protogen.Method) time.Duration {
+	d, _ := proto.GetExtension(method.Desc.Options(), methodpb.E_Timeout).(*durationpb.Duration)
+	if d == nil {
+		return 0
+	}
+	return d.AsDuration()
+}
+
+// canRetry reports whether calls to method can be retried safely, as it is
+// idempotent or has no side effects.
+func canRetry(method *protogen.Method) bool {
+	opts, _ := method.Desc.Options().(*descriptorpb.MethodOptions)
+	switch opts.GetIdempotencyLevel() {
+	case descriptorpb.MethodOptions_IDEMPOTENT, descriptorpb.MethodOptions_NO_SIDE_EFFECTS:
+		return true
+	}
+	return false
+}
+
+// duration formats d as in the JSON form of google.protobuf.Duration, such as
+// "0.1s".
+func duration(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
@@ -0,0 +1,5 @@
+// Package methodpb holds the Go types of the options of
+// gunk/method/method.proto, which declare the default timeouts of methods.
+package methodpb
+
+//go:generate protoc -I../../assets/bundled --go_out=. --go_opt=module=github.com/gunk/gunk/retrygen/methodpb gunk/method/method.proto
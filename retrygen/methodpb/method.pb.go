@@ -0,0 +1,102 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.27.1
+// 	protoc        (unknown)
+// source: gunk/method/method.proto
+
+package methodpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	descriptorpb "google.golang.org/protobuf/types/descriptorpb"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	reflect "reflect"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+var file_gunk_method_method_proto_extTypes = []protoimpl.ExtensionInfo{
+	{
+		ExtendedType:  (*descriptorpb.MethodOptions)(nil),
+		ExtensionType: (*durationpb.Duration)(nil),
+		Field:         91400,
+		Name:          "gunk.method.timeout",
+		Tag:           "bytes,91400,opt,name=timeout",
+		Filename:      "gunk/method/method.proto",
+	},
+}
+
+// Extension fields to descriptorpb.MethodOptions.
+var (
+	// The default timeout of the calls to the method, used by clients unless
+	// the deadline of a call is sooner.
+	//
+	// optional google.protobuf.Duration timeout = 91400;
+	E_Timeout = &file_gunk_method_method_proto_extTypes[0]
+)
+
+var File_gunk_method_method_proto protoreflect.FileDescriptor
+
+var file_gunk_method_method_proto_rawDesc = []byte{
+	0x0a, 0x18, 0x67, 0x75, 0x6e, 0x6b, 0x2f, 0x6d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x2f, 0x6d, 0x65,
+	0x74, 0x68, 0x6f, 0x64, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x0b, 0x67, 0x75, 0x6e, 0x6b,
+	0x2e, 0x6d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x1a, 0x20, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x64, 0x65, 0x73, 0x63, 0x72, 0x69, 0x70,
+	0x74, 0x6f, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x1e, 0x67, 0x6f, 0x6f, 0x67, 0x6c,
+	0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x64, 0x75, 0x72, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x3a, 0x55, 0x0a, 0x07, 0x74, 0x69, 0x6d,
+	0x65, 0x6f, 0x75, 0x74, 0x12, 0x1e, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x4d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x4f, 0x70, 0x74,
+	0x69, 0x6f, 0x6e, 0x73, 0x18, 0x88, 0xca, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67,
+	0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44,
+	0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x07, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74,
+	0x42, 0x31, 0x5a, 0x2f, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x67,
+	0x75, 0x6e, 0x6b, 0x2f, 0x67, 0x75, 0x6e, 0x6b, 0x2f, 0x72, 0x65, 0x74, 0x72, 0x79, 0x67, 0x65,
+	0x6e, 0x2f, 0x6d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x70, 0x62, 0x3b, 0x6d, 0x65, 0x74, 0x68, 0x6f,
+	0x64, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var file_gunk_method_method_proto_goTypes = []interface{}{
+	(*descriptorpb.MethodOptions)(nil), // 0: google.protobuf.MethodOptions
+	(*durationpb.Duration)(nil),        // 1: google.protobuf.Duration
+}
+var file_gunk_method_method_proto_depIdxs = []int32{
+	0, // 0: gunk.method.timeout:extendee -> google.protobuf.MethodOptions
+	1, // 1: gunk.method.timeout:type_name -> google.protobuf.Duration
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	1, // [1:2] is the sub-list for extension type_name
+	0, // [0:1] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_gunk_method_method_proto_init() }
+func file_gunk_method_method_proto_init() {
+	if File_gunk_method_method_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_gunk_method_method_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   0,
+			NumExtensions: 1,
+			NumServices:   0,
+		},
+		GoTypes:           file_gunk_method_method_proto_goTypes,
+		DependencyIndexes: file_gunk_method_method_proto_depIdxs,
+		ExtensionInfos:    file_gunk_method_method_proto_extTypes,
+	}.Build()
+	File_gunk_method_method_proto = out.File
+	file_gunk_method_method_proto_rawDesc = nil
+	file_gunk_method_method_proto_goTypes = nil
+	file_gunk_method_method_proto_depIdxs = nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/gunk/gunk/plugin"
+	"github.com/gunk/gunk/retrygen/generate"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func main() {
+	plugin.RunMain(new(retryPlugin))
+}
+
+type retryPlugin struct{}
+
+func (p *retryPlugin) Generate(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	var flags flag.FlagSet
+	var policy generate.Policy
+	flags.IntVar(&policy.MaxAttempts, "max_attempts", 4, "maximum number of attempts of a call, including the first one")
+	flags.DurationVar(&policy.InitialBackoff, "initial_backoff", 100*time.Millisecond, "backoff before the first retry")
+	flags.DurationVar(&policy.MaxBackoff, "max_backoff", time.Second, "maximum backoff between retries")
+	flags.Float64Var(&policy.BackoffMultiplier, "backoff_multiplier", 2, "multiplier of the backoff after each retry")
+	codes := flags.String("retryable_codes", "UNAVAILABLE", "space separated status codes on which calls are retried")
+	flags.DurationVar(&policy.Timeout, "timeout", 0, "default timeout of calls, if shorter than the deadline of their context")
+	gen, err := protogen.Options{ParamFunc: flags.Set}.New(req)
+	if err != nil {
+		return nil, err
+	}
+	gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+	if policy.RetryableCodes, err = generate.ParseCodes(*codes); err != nil {
+		return nil, err
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	if err := generate.Generate(gen, policy); err != nil {
+		return nil, err
+	}
+	return gen.Response(), nil
+}
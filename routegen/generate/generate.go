@@ -0,0 +1,273 @@
+package generate
+
+import (
+	"fmt"
+	"go/token"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gunk/gunk/httprule"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	base64Package  = protogen.GoImportPath("encoding/base64")
+	strconvPackage = protogen.GoImportPath("strconv")
+	stringsPackage = protogen.GoImportPath("strings")
+	urlPackage     = protogen.GoImportPath("net/url")
+)
+
+// Generate generates, for each method of the files to generate with an HTTP
+// binding, constants holding the HTTP method and path template of the
+// binding, and a function building the path of a request from the fields
+// bound by the path template.
+func Generate(gen *protogen.Plugin) error {
+	for _, f := range gen.Files {
+		if !f.Generate {
+			continue
+		}
+		var routes []*route
+		for _, srv := range f.Services {
+			for _, method := range srv.Methods {
+				r, err := newRoute(method)
+				if err != nil {
+					return err
+				}
+				if r != nil {
+					routes = append(routes, r)
+				}
+			}
+		}
+		if len(routes) == 0 {
+			continue
+		}
+		g := gen.NewGeneratedFile(f.GeneratedFilenamePrefix+".routes.go", f.GoImportPath)
+		g.P(`// Code generated by "routegen"; DO NOT EDIT.`)
+		g.P("// source: ", f.Desc.Path())
+		g.P()
+		g.P("package ", f.GoPackageName)
+		for _, r := range routes {
+			r.generate(g)
+		}
+	}
+	return nil
+}
+
+// route is the main HTTP binding of a method.
+type route struct {
+	method   *protogen.Method
+	verb     string
+	template string
+	segments []routeSegment
+}
+
+// routeSegment is a part of a path template: either literal text, or a
+// variable bound to a field of the request.
+type routeSegment struct {
+	literal string
+	// fields leads from the request to the field bound by the variable.
+	fields []*protogen.Field
+	// multi is set for variables matching several path segments, such as
+	// {name=shelves/*/books/*}, whose values keep their slashes.
+	multi bool
+}
+
+// newRoute returns the route of method, or nil if it has no HTTP binding.
+func newRoute(method *protogen.Method) (*route, error) {
+	rule, ok := proto.GetExtension(method.Desc.Options(), annotations.E_Http).(*annotations.HttpRule)
+	if !ok || rule == nil {
+		return nil, nil
+	}
+	r := &route{method: method}
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		r.verb, r.template = "GET", p.Get
+	case *annotations.HttpRule_Put:
+		r.verb, r.template = "PUT", p.Put
+	case *annotations.HttpRule_Post:
+		r.verb, r.template = "POST", p.Post
+	case *annotations.HttpRule_Delete:
+		r.verb, r.template = "DELETE", p.Delete
+	case *annotations.HttpRule_Patch:
+		r.verb, r.template = "PATCH", p.Patch
+	case *annotations.HttpRule_Custom:
+		r.verb, r.template = p.Custom.GetKind(), p.Custom.GetPath()
+	default:
+		return nil, nil
+	}
+	if _, err := httprule.Parse(r.template); err != nil {
+		return nil, fmt.Errorf("invalid path template %q of %s: %w", r.template, method.Desc.FullName(), err)
+	}
+	// The template is valid, so its variables are well formed.
+	rest := r.template
+	for rest != "" {
+		i := strings.Index(rest, "{")
+		if i < 0 {
+			r.segments = append(r.segments, routeSegment{literal: rest})
+			break
+		}
+		if i > 0 {
+			r.segments = append(r.segments, routeSegment{literal: rest[:i]})
+		}
+		end := strings.Index(rest, "}")
+		variable := rest[i+1 : end]
+		rest = rest[end+1:]
+		path, pattern := variable, "*"
+		if j := strings.Index(variable, "="); j >= 0 {
+			path, pattern = variable[:j], variable[j+1:]
+		}
+		fields, err := boundFields(method.Input, path)
+		if err != nil {
+			return nil, fmt.Errorf("path template %q of %s: %w", r.template, method.Desc.FullName(), err)
+		}
+		r.segments = append(r.segments, routeSegment{fields: fields, multi: pattern != "*"})
+	}
+	return r, nil
+}
+
+// boundFields returns the fields leading from msg to the field at path, such
+// as "book.name", which must be a singular scalar or enum.
+func boundFields(msg *protogen.Message, path string) ([]*protogen.Field, error) {
+	var fields []*protogen.Field
+	for _, name := range strings.Split(path, ".") {
+		if msg == nil {
+			return nil, fmt.Errorf("%s is not a message", fields[len(fields)-1].Desc.FullName())
+		}
+		var field *protogen.Field
+		for _, f := range msg.Fields {
+			if string(f.Desc.Name()) == name {
+				field = f
+				break
+			}
+		}
+		if field == nil {
+			return nil, fmt.Errorf("no field %s in %s", name, msg.Desc.FullName())
+		}
+		fields = append(fields, field)
+		msg = field.Message
+	}
+	last := fields[len(fields)-1]
+	if last.Desc.IsList() || last.Desc.IsMap() || last.Desc.Kind() == protoreflect.MessageKind {
+		return nil, fmt.Errorf("field %s bound by the path must be a singular scalar or enum", last.Desc.FullName())
+	}
+	return fields, nil
+}
+
+// param returns the name of the parameter of the path function for the
+// variable, joining the names of its fields, such as bookName.
+func (s routeSegment) param() string {
+	var b strings.Builder
+	for _, f := range s.fields {
+		b.WriteString(f.GoName)
+	}
+	name := b.String()
+	// Lower the leading initialism as a whole, so that ID becomes id and
+	// URLPath becomes urlPath.
+	n := 1
+	for n < len(name) && unicode.IsUpper(rune(name[n])) && (n+1 == len(name) || !unicode.IsLower(rune(name[n+1]))) {
+		n++
+	}
+	name = strings.ToLower(name[:n]) + name[n:]
+	if token.IsKeyword(name) {
+		name += "_"
+	}
+	return name
+}
+
+func (r *route) generate(g *protogen.GeneratedFile) {
+	srv := r.method.Parent.GoName
+	name := srv + r.method.GoName
+
+	g.P()
+	g.P("// ", name, "Method is the HTTP method of the binding of ", srv, ".", r.method.GoName, ".")
+	g.P("const ", name, "Method = ", strconv.Quote(r.verb))
+	g.P()
+	g.P("// ", name, "Route is the path template of the HTTP binding of ", srv, ".", r.method.GoName, ".")
+	g.P("const ", name, "Route = ", strconv.Quote(r.template))
+
+	var params, parts []string
+	for _, s := range r.segments {
+		if s.fields == nil {
+			parts = append(parts, strconv.Quote(s.literal))
+			continue
+		}
+		param := s.param()
+		field := s.fields[len(s.fields)-1]
+		params = append(params, param+" "+goType(g, field))
+		value := formatValue(g, field, param)
+		if s.multi {
+			// Variables matching several segments hold their
+			// slashes, as in "shelves/1/books/2".
+			value = g.QualifiedGoIdent(stringsPackage.Ident("ReplaceAll")) + "(" + value + `, "%2F", "/")`
+		}
+		parts = append(parts, value)
+	}
+	g.P()
+	g.P("// PathFor", name, " returns the path of the HTTP binding of ", srv, ".", r.method.GoName, ",")
+	if len(params) == 0 {
+		g.P("// which has no variables.")
+	} else {
+		g.P("// with its variables set to the given values, escaped.")
+	}
+	g.P("func PathFor", name, "(", strings.Join(params, ", "), ") string {")
+	g.P("return ", strings.Join(parts, " + "))
+	g.P("}")
+}
+
+// goType returns the Go type of the values of field.
+func goType(g *protogen.GeneratedFile, field *protogen.Field) string {
+	switch field.Desc.Kind() {
+	case protoreflect.BoolKind:
+		return "bool"
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return "int32"
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return "int64"
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return "uint32"
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return "uint64"
+	case protoreflect.FloatKind:
+		return "float32"
+	case protoreflect.DoubleKind:
+		return "float64"
+	case protoreflect.BytesKind:
+		return "[]byte"
+	case protoreflect.EnumKind:
+		return g.QualifiedGoIdent(field.Enum.GoIdent)
+	}
+	return "string"
+}
+
+// formatValue returns an expression formatting v, a value of field, as an
+// escaped path segment, as a gateway parses it.
+func formatValue(g *protogen.GeneratedFile, field *protogen.Field, v string) string {
+	escape := g.QualifiedGoIdent(urlPackage.Ident("PathEscape"))
+	switch field.Desc.Kind() {
+	case protoreflect.StringKind:
+		return escape + "(" + v + ")"
+	case protoreflect.BoolKind:
+		return g.QualifiedGoIdent(strconvPackage.Ident("FormatBool")) + "(" + v + ")"
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return g.QualifiedGoIdent(strconvPackage.Ident("FormatInt")) + "(int64(" + v + "), 10)"
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return g.QualifiedGoIdent(strconvPackage.Ident("FormatInt")) + "(" + v + ", 10)"
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return g.QualifiedGoIdent(strconvPackage.Ident("FormatUint")) + "(uint64(" + v + "), 10)"
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return g.QualifiedGoIdent(strconvPackage.Ident("FormatUint")) + "(" + v + ", 10)"
+	case protoreflect.FloatKind:
+		return g.QualifiedGoIdent(strconvPackage.Ident("FormatFloat")) + "(float64(" + v + "), 'g', -1, 32)"
+	case protoreflect.DoubleKind:
+		return g.QualifiedGoIdent(strconvPackage.Ident("FormatFloat")) + "(" + v + ", 'g', -1, 64)"
+	case protoreflect.BytesKind:
+		return g.QualifiedGoIdent(base64Package.Ident("URLEncoding")) + ".EncodeToString(" + v + ")"
+	case protoreflect.EnumKind:
+		return v + ".String()"
+	}
+	return escape + "(" + v + ")"
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/gunk/gunk/plugin"
+	"github.com/gunk/gunk/routegen/generate"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func main() {
+	plugin.RunMain(new(routePlugin))
+}
+
+type routePlugin struct{}
+
+func (r *routePlugin) Generate(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	gen, err := protogen.Options{}.New(req)
+	if err != nil {
+		return nil, err
+	}
+	gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+	if err := generate.Generate(gen); err != nil {
+		return nil, err
+	}
+	return gen.Response(), nil
+}
@@ -0,0 +1,190 @@
+// Package sign writes detached signatures of generated files, such as OpenAPI
+// documents and descriptor sets distributed to API consumers, and implements
+// `gunk verify`, which checks them. Signatures are made with GPG or with
+// sigstore's cosign, run as external commands.
+package sign
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gunk/gunk/config"
+	"github.com/gunk/gunk/log"
+)
+
+// The extensions of the signature files written next to a signed file.
+const (
+	gpgExt      = ".asc"
+	sigstoreExt = ".sigstore.json"
+)
+
+// Match reports whether the generated file at path is to be signed, as its
+// base name matches one of the files patterns of s.
+func Match(s *config.Sign, path string) bool {
+	base := filepath.Base(path)
+	for _, pattern := range s.Files {
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// File writes the detached signature of the file at path as configured by s,
+// returning the path of the signature.
+func File(ctx context.Context, s *config.Sign, path string) (string, error) {
+	var command string
+	var args []string
+	sigPath := path
+	switch s.Method {
+	case config.SignSigstore:
+		command = "cosign"
+		sigPath += sigstoreExt
+		args = []string{"sign-blob", "--yes", "--bundle", sigPath}
+		if s.Key != "" {
+			args = append(args, "--key", s.Key)
+		}
+	default:
+		command = "gpg"
+		sigPath += gpgExt
+		args = []string{"--batch", "--yes", "--armor", "--detach-sign", "--output", sigPath}
+		if s.Key != "" {
+			args = append(args, "--local-user", s.Key)
+		}
+	}
+	args = append(args, path)
+	cmd := log.ExecCommandContext(ctx, command, args...)
+	if _, err := cmd.Output(); err != nil {
+		return "", log.ExecError(command, err)
+	}
+	return sigPath, nil
+}
+
+// VerifyOptions configures `gunk verify`.
+type VerifyOptions struct {
+	// Keyring is a file of the public GPG keys to trust, such as one
+	// written by gpg --export, instead of the keys of the user's own
+	// keyring.
+	Keyring string
+	// Key is the public key of sigstore signatures made with a cosign
+	// key, such as a path or a KMS URI.
+	Key string
+	// Identity and Issuer are the certificate identity and OIDC issuer
+	// of keyless sigstore signatures, such as the email of the API owner
+	// and https://accounts.google.com.
+	Identity string
+	Issuer   string
+}
+
+// Verify checks the signature of each of the files, which is looked up next
+// to it, printing who signed each file to w. It stops at the first file which
+// isn't signed, or whose signature doesn't match.
+func Verify(ctx context.Context, w io.Writer, opts VerifyOptions, paths ...string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("no files to verify")
+	}
+	for _, path := range paths {
+		signer, err := verifyFile(ctx, opts, path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		fmt.Fprintf(w, "%s: signed by %s\n", path, signer)
+	}
+	return nil
+}
+
+// verifyFile checks the signature of the file at path, returning its signer.
+func verifyFile(ctx context.Context, opts VerifyOptions, path string) (string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path + gpgExt); err == nil {
+		return verifyGPG(ctx, opts, path)
+	}
+	if _, err := os.Stat(path + sigstoreExt); err == nil {
+		return verifySigstore(ctx, opts, path)
+	}
+	return "", fmt.Errorf("no signature found, want %s or %s", filepath.Base(path+gpgExt), filepath.Base(path+sigstoreExt))
+}
+
+func verifyGPG(ctx context.Context, opts VerifyOptions, path string) (string, error) {
+	args := []string{"--batch", "--status-fd", "1"}
+	if opts.Keyring != "" {
+		// gpg looks up relative keyrings in its home directory.
+		keyring, err := filepath.Abs(opts.Keyring)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, "--no-default-keyring", "--keyring", keyring)
+	}
+	args = append(args, "--verify", path+gpgExt, path)
+	cmd := log.ExecCommandContext(ctx, "gpg", args...)
+	out, err := cmd.Output()
+	// The status lines tell a bad signature from a missing key, which
+	// both make gpg fail.
+	status := gpgStatus(out)
+	switch {
+	case status["BADSIG"] != "":
+		return "", fmt.Errorf("bad gpg signature from %s", userID(status["BADSIG"]))
+	case status["NO_PUBKEY"] != "":
+		return "", fmt.Errorf("gpg signature from unknown key %s", status["NO_PUBKEY"])
+	case err != nil:
+		return "", log.ExecError("gpg", err)
+	case status["GOODSIG"] == "" || status["VALIDSIG"] == "":
+		return "", fmt.Errorf("gpg didn't report a valid signature")
+	}
+	fingerprint := strings.Fields(status["VALIDSIG"])[0]
+	return fmt.Sprintf("%s (gpg key %s)", userID(status["GOODSIG"]), fingerprint), nil
+}
+
+// gpgStatus returns the arguments of the status lines printed by gpg with
+// --status-fd, keyed by their keyword, such as GOODSIG.
+func gpgStatus(out []byte) map[string]string {
+	status := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimPrefix(sc.Text(), "[GNUPG:] ")
+		keyword, args := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			keyword, args = line[:i], line[i+1:]
+		}
+		status[keyword] = args
+	}
+	return status
+}
+
+// userID returns the user ID of the arguments of a GOODSIG or BADSIG status
+// line, which follows the long key ID.
+func userID(args string) string {
+	if i := strings.IndexByte(args, ' '); i >= 0 {
+		return args[i+1:]
+	}
+	return args
+}
+
+func verifySigstore(ctx context.Context, opts VerifyOptions, path string) (string, error) {
+	args := []string{"verify-blob", "--bundle", path + sigstoreExt}
+	var signer string
+	switch {
+	case opts.Key != "":
+		args = append(args, "--key", opts.Key)
+		signer = "cosign key " + opts.Key
+	case opts.Identity != "" && opts.Issuer != "":
+		args = append(args, "--certificate-identity", opts.Identity, "--certificate-oidc-issuer", opts.Issuer)
+		signer = opts.Identity + " (issued by " + opts.Issuer + ")"
+	default:
+		return "", fmt.Errorf("verifying a sigstore signature needs --key, or --identity and --issuer")
+	}
+	args = append(args, path)
+	cmd := log.ExecCommandContext(ctx, "cosign", args...)
+	if _, err := cmd.Output(); err != nil {
+		return "", log.ExecError("cosign", err)
+	}
+	return signer, nil
+}
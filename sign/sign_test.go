@@ -0,0 +1,44 @@
+package sign
+
+import (
+	"testing"
+
+	"github.com/gunk/gunk/config"
+)
+
+func TestMatch(t *testing.T) {
+	s := &config.Sign{Files: []string{"*.swagger.json", "api.binpb"}}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"docs/all.swagger.json", true},
+		{"api.binpb", true},
+		{"pkg/api.binpb", true},
+		{"docs/all.swagger.yaml", false},
+		{"docs/all.pb.go", false},
+	}
+	for _, tt := range tests {
+		if got := Match(s, tt.path); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestGPGStatus(t *testing.T) {
+	out := []byte(`[GNUPG:] NEWSIG
+[GNUPG:] GOODSIG 53A532FE0A8CD2F2 API Owner <api@example.com>
+[GNUPG:] VALIDSIG 297325F94339698AF54774C353A532FE0A8CD2F2 2026-10-16 1792177957 0 4 0 22 8 00 297325F94339698AF54774C353A532FE0A8CD2F2
+[GNUPG:] TRUST_UNDEFINED 0 pgp
+`)
+	status := gpgStatus(out)
+	if got, want := userID(status["GOODSIG"]), "API Owner <api@example.com>"; got != want {
+		t.Errorf("GOODSIG user ID = %q, want %q", got, want)
+	}
+	if _, ok := status["NEWSIG"]; !ok {
+		t.Errorf("NEWSIG without arguments is missing")
+	}
+	if status["BADSIG"] != "" {
+		t.Errorf("unexpected BADSIG %q", status["BADSIG"])
+	}
+}
@@ -0,0 +1,336 @@
+// Package snippets writes editor snippets for common Gunk patterns, such as
+// messages, services with HTTP bindings, enums, and the supported option
+// tags.
+package snippets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gunk/gunk/generate"
+)
+
+// The editors to write snippets for.
+const (
+	EditorVSCode = "vscode"
+	EditorNvim   = "nvim"
+)
+
+// snippet is an editor snippet, with its body in the TextMate syntax used
+// by VS Code, with tab stops such as ${1:default} and choices such as
+// ${1|true,false|}.
+type snippet struct {
+	name        string
+	prefix      string
+	description string
+	body        []string
+}
+
+// Run writes the snippets for editor to w, with the option tags from the
+// catalog of the Gunk option packages required by the module in dir.
+//
+// For VS Code, the snippets are written as a .code-snippets JSON file. For
+// Neovim, they are written in the snipMate format, as read by LuaSnip and
+// vim-snipmate.
+func Run(ctx context.Context, w io.Writer, dir, editor string) error {
+	catalog, err := generate.LoadOptionCatalog(ctx, dir)
+	if err != nil {
+		return err
+	}
+	snippets, err := build(catalog)
+	if err != nil {
+		return err
+	}
+	switch editor {
+	case EditorVSCode:
+		return writeVSCode(w, snippets)
+	case EditorNvim:
+		return writeSnipMate(w, snippets)
+	}
+	return fmt.Errorf("unknown editor %q", editor)
+}
+
+// build returns the snippets of the common Gunk patterns, and of each
+// option tag in catalog.
+func build(catalog *generate.OptionCatalog) ([]snippet, error) {
+	var httpMatch *generate.OptionTag
+	for i, tag := range catalog.Options {
+		if tag.Tag == "github.com/gunk/opt/http.Match" {
+			httpMatch = &catalog.Options[i]
+		}
+	}
+	if httpMatch == nil || httpMatch.OptionType == nil {
+		return nil, fmt.Errorf("the option catalog has no http.Match")
+	}
+	snippets := []snippet{messageSnippet(), serviceSnippet(catalog, httpMatch), enumSnippet()}
+	// Tags with more than one scope, such as openapiv2.Schema, get a
+	// single snippet.
+	var tags []string
+	scopes := make(map[string][]string)
+	byTag := make(map[string]generate.OptionTag)
+	for _, tag := range catalog.Options {
+		if tag.OptionType == nil {
+			// Not declared by the Gunk option packages in use.
+			continue
+		}
+		if _, ok := byTag[tag.Tag]; !ok {
+			tags = append(tags, tag.Tag)
+			byTag[tag.Tag] = tag
+		}
+		scopes[tag.Tag] = append(scopes[tag.Tag], tag.Scope)
+	}
+	for _, name := range tags {
+		tag := byTag[name]
+		b := &body{}
+		b.tag(catalog, tag, "", nil)
+		description := "+gunk option of " + strings.Join(scopes[name], ", ")
+		if tag.Doc != "" {
+			description += ": " + strings.Join(strings.Fields(tag.Doc), " ")
+		}
+		snippets = append(snippets, snippet{
+			name:        qualify(name),
+			prefix:      "gunk " + qualify(name),
+			description: description,
+			body:        b.lines,
+		})
+	}
+	return snippets, nil
+}
+
+func messageSnippet() snippet {
+	b := &body{}
+	name := b.stop("Message")
+	b.line("// %s %s", b.mirror(name), b.stopf("is a message."))
+	b.line("type %s struct {", b.mirror(name))
+	field := b.stop("Name")
+	b.line("\t// %s %s", b.mirror(field), b.stopf("is the name."))
+	b.line("\t%s %s `pb:\"%s\" json:\"%s\"`$0", b.mirror(field), b.stopf("string"), b.stopf("1"), b.stopf("name"))
+	b.line("}")
+	return snippet{
+		name:        "message",
+		prefix:      "gunk message",
+		description: "Gunk message, with a field tagged with its number and JSON name",
+		body:        b.lines,
+	}
+}
+
+func serviceSnippet(catalog *generate.OptionCatalog, httpMatch *generate.OptionTag) snippet {
+	b := &body{}
+	name := b.stop("Service")
+	b.line("// %s %s", b.mirror(name), b.stopf("is a service."))
+	b.line("type %s interface {", b.mirror(name))
+	method := b.stop("GetResource")
+	b.line("\t// %s %s", b.mirror(method), b.stopf("gets a resource."))
+	b.line("\t//")
+	b.tag(catalog, *httpMatch, "\t", map[string]string{
+		"Method": "GET",
+		"Path":   "/v1/resources/{id}",
+	})
+	b.line("\t%s(%s) %s$0", b.mirror(method), b.stopf("GetResourceRequest"), b.stopf("Resource"))
+	b.line("}")
+	return snippet{
+		name:        "service",
+		prefix:      "gunk service",
+		description: "Gunk service, with a method bound to an HTTP route with http.Match",
+		body:        b.lines,
+	}
+}
+
+func enumSnippet() snippet {
+	b := &body{}
+	name := b.stop("Status")
+	b.line("// %s %s", b.mirror(name), b.stopf("is an enum."))
+	b.line("type %s int", b.mirror(name))
+	b.line("")
+	b.line("const (")
+	b.line("\t%s_UNSPECIFIED %s = iota", b.mirror(name), b.mirror(name))
+	b.line("\t%s$0", b.stopf("ACTIVE"))
+	b.line(")")
+	return snippet{
+		name:        "enum",
+		prefix:      "gunk enum",
+		description: "Gunk enum, with an UNSPECIFIED zero value",
+		body:        b.lines,
+	}
+}
+
+// body builds the lines of a snippet's body, numbering its tab stops.
+type body struct {
+	lines []string
+	stops int
+	// defs are the default values of the tab stops added with stop, until
+	// they are first used.
+	defs map[int]string
+}
+
+func (b *body) line(format string, args ...interface{}) {
+	b.lines = append(b.lines, fmt.Sprintf(format, args...))
+}
+
+// stop adds a tab stop with a default value, used more than once with
+// mirror, returning its number.
+func (b *body) stop(def string) int {
+	b.stops++
+	if b.defs == nil {
+		b.defs = make(map[int]string)
+	}
+	b.defs[b.stops] = def
+	return b.stops
+}
+
+// stopf adds a tab stop with a default value, returning its placeholder.
+func (b *body) stopf(def string) string {
+	b.stops++
+	if def == "" {
+		return fmt.Sprintf("$%d", b.stops)
+	}
+	return fmt.Sprintf("${%d:%s}", b.stops, escape(def))
+}
+
+// mirror returns the placeholder of the tab stop n, with its default
+// value the first time it is used.
+func (b *body) mirror(n int) string {
+	def, ok := b.defs[n]
+	if !ok {
+		return fmt.Sprintf("$%d", n)
+	}
+	delete(b.defs, n)
+	return fmt.Sprintf("${%d:%s}", n, escape(def))
+}
+
+// choice adds a tab stop choosing one of values, returning its
+// placeholder.
+func (b *body) choice(values []string) string {
+	b.stops++
+	return fmt.Sprintf("${%d|%s|}", b.stops, strings.Join(values, ","))
+}
+
+// value returns the placeholder of a value of typ, or false if typ has no
+// literal value, such as a struct or a list.
+func (b *body) value(typ *generate.OptionType, qualifier, def string) (string, bool) {
+	switch typ.Kind {
+	case "bool":
+		return b.choice([]string{"true", "false"}), true
+	case "string":
+		return `"` + b.stopf(def) + `"`, true
+	case "enum":
+		values := make([]string, len(typ.Values))
+		for i, v := range typ.Values {
+			values[i] = qualifier + v
+		}
+		return b.choice(values), true
+	case "int", "int32", "int64", "uint", "uint32", "uint64", "float32", "float64":
+		if def == "" {
+			def = "0"
+		}
+		return b.stopf(def), true
+	}
+	return "", false
+}
+
+// tag adds the lines of a +gunk tag, indented by indent. The fields of a
+// struct with literal values are spelled out, using the values in defaults.
+func (b *body) tag(catalog *generate.OptionCatalog, tag generate.OptionTag, indent string, defaults map[string]string) {
+	name := qualify(tag.Tag)
+	qualifier := name[:strings.Index(name, ".")+1]
+	if tag.Kind != "struct" {
+		v, ok := b.value(tag.OptionType, qualifier, "")
+		if !ok {
+			v = b.stopf("")
+		}
+		b.line("%s// +gunk %s(%s)", indent, name, v)
+		return
+	}
+	b.line("%s// +gunk %s{", indent, name)
+	for _, field := range tag.Fields {
+		if defaults != nil && defaults[field.Name] == "" {
+			continue
+		}
+		typ := &generate.OptionType{Kind: field.Type}
+		fieldQualifier := ""
+		if t, ok := catalog.Types[field.Type]; ok {
+			typ, fieldQualifier = t, qualify(field.Type)
+			fieldQualifier = fieldQualifier[:strings.Index(fieldQualifier, ".")+1]
+		}
+		v, ok := b.value(typ, fieldQualifier, defaults[field.Name])
+		if !ok {
+			continue
+		}
+		b.line("%s// \t%s: %s,", indent, field.Name, v)
+	}
+	b.line("%s// }", indent)
+}
+
+// qualify returns the name of a Gunk option type as written in Gunk files,
+// such as "java.Package" for "github.com/gunk/opt/file/java.Package".
+func qualify(name string) string {
+	return path.Base(name)
+}
+
+// escape escapes the characters with a meaning in the default values of tab
+// stops.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `$`, `\$`, `}`, `\}`).Replace(s)
+}
+
+// writeVSCode writes snippets as a VS Code .code-snippets file.
+func writeVSCode(w io.Writer, snippets []snippet) error {
+	type vscodeSnippet struct {
+		Scope       string   `json:"scope"`
+		Prefix      string   `json:"prefix"`
+		Body        []string `json:"body"`
+		Description string   `json:"description"`
+	}
+	// Encode the snippets in order, as a map would sort them.
+	fmt.Fprintln(w, "{")
+	for i, s := range snippets {
+		name, err := json.Marshal(s.name)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(vscodeSnippet{
+			// Gunk files are often edited as Go files.
+			Scope:       "gunk,go",
+			Prefix:      s.prefix,
+			Body:        s.body,
+			Description: s.description,
+		}, "\t", "\t")
+		if err != nil {
+			return err
+		}
+		sep := ","
+		if i == len(snippets)-1 {
+			sep = ""
+		}
+		fmt.Fprintf(w, "\t%s: %s%s\n", name, data, sep)
+	}
+	fmt.Fprintln(w, "}")
+	return nil
+}
+
+// snipMateChoice matches the choices of tab stops, which snipMate doesn't
+// support.
+var snipMateChoice = regexp.MustCompile(`\$\{(\d+)\|([^,|]*)[^|]*\|\}`)
+
+// writeSnipMate writes snippets in the snipMate format.
+func writeSnipMate(w io.Writer, snippets []snippet) error {
+	for i, s := range snippets {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "snippet %s %q\n", strings.Replace(s.prefix, " ", "-", -1), s.description)
+		for _, line := range s.body {
+			// Choices become their first value, and backticks
+			// would run Vim script.
+			line = snipMateChoice.ReplaceAllString(line, "$${$1:$2}")
+			line = strings.Replace(line, "`", "\\`", -1)
+			fmt.Fprintf(w, "\t%s\n", line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package generate
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+const (
+	contextPackage = protogen.GoImportPath("context")
+	grpcPackage    = protogen.GoImportPath("google.golang.org/grpc")
+	ioPackage      = protogen.GoImportPath("io")
+)
+
+// Generate generates, for each service of the files to generate with streaming
+// methods, the channel-based Go API of those methods, along with adapters
+// between it and the gRPC client and server streams generated by
+// protoc-gen-go-grpc.
+//
+// The API follows the chan signatures of the Gunk methods: a request stream is
+// a receive-only channel, closed by its sender once the stream ends, and a
+// response stream is a send-only channel, which is never closed, the stream
+// ending when the method returns. Errors are returned by the methods, and
+// cancel the context given to the other side of the stream.
+func Generate(gen *protogen.Plugin) error {
+	for _, f := range gen.Files {
+		if !f.Generate || !hasStreams(f) {
+			continue
+		}
+		g := gen.NewGeneratedFile(f.GeneratedFilenamePrefix+".streams.go", f.GoImportPath)
+		g.P(`// Code generated by "streamgen"; DO NOT EDIT.`)
+		g.P("// source: ", f.Desc.Path())
+		g.P()
+		g.P("package ", f.GoPackageName)
+		for _, srv := range f.Services {
+			if methods := streamingMethods(srv); len(methods) > 0 {
+				generateService(g, srv, methods)
+			}
+		}
+	}
+	return nil
+}
+
+// hasStreams reports whether any service of f has streaming methods.
+func hasStreams(f *protogen.File) bool {
+	for _, srv := range f.Services {
+		if len(streamingMethods(srv)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// streamingMethods returns the client, server or bidirectional streaming
+// methods of srv.
+func streamingMethods(srv *protogen.Service) []*protogen.Method {
+	var methods []*protogen.Method
+	for _, method := range srv.Methods {
+		if method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer() {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// signature returns the signature of method in the channel-based API,
+// without its name.
+func signature(g *protogen.GeneratedFile, method *protogen.Method) string {
+	input := "*" + g.QualifiedGoIdent(method.Input.GoIdent)
+	output := "*" + g.QualifiedGoIdent(method.Output.GoIdent)
+	params := "ctx " + g.QualifiedGoIdent(contextPackage.Ident("Context"))
+	if method.Desc.IsStreamingClient() {
+		params += ", in <-chan " + input
+	} else {
+		params += ", req " + input
+	}
+	if !method.Desc.IsStreamingServer() {
+		return "(" + params + ") (" + output + ", error)"
+	}
+	return "(" + params + ", out chan<- " + output + ") error"
+}
+
+func generateService(g *protogen.GeneratedFile, srv *protogen.Service, methods []*protogen.Method) {
+	streams := srv.GoName + "Streams"
+	g.P()
+	g.P("// ", streams, " is the channel-based API of the streaming methods of ", srv.GoName, ",")
+	g.P("// implemented by both the client returned by New", streams, "Client and the")
+	g.P("// handlers served with the Serve", srv.GoName, " funcs. Request streams are closed by")
+	g.P("// their sender once they end, while response streams are never closed, and end")
+	g.P("// when the method returns. Sends must be given up once ctx is done.")
+	g.P("type ", streams, " interface {")
+	for i, method := range methods {
+		if i > 0 {
+			g.P()
+		}
+		g.P(method.Comments.Leading, method.GoName, signature(g, method))
+	}
+	g.P("}")
+	generateClient(g, srv, methods)
+	for _, method := range methods {
+		generateServe(g, srv, method)
+	}
+}
+
+func generateClient(g *protogen.GeneratedFile, srv *protogen.Service, methods []*protogen.Method) {
+	streams := srv.GoName + "Streams"
+	client := lowerFirst(srv.GoName) + "StreamsClient"
+	callOption := g.QualifiedGoIdent(grpcPackage.Ident("CallOption"))
+	g.P()
+	g.P("// New", streams, "Client returns the ", streams, " calling the methods of client,")
+	g.P("// with opts.")
+	g.P("func New", streams, "Client(client ", srv.GoName, "Client, opts ...", callOption, ") ", streams, " {")
+	g.P("return &", client, "{client: client, opts: opts}")
+	g.P("}")
+	g.P()
+	g.P("type ", client, " struct {")
+	g.P("client ", srv.GoName, "Client")
+	g.P("opts   []", callOption)
+	g.P("}")
+	for _, method := range methods {
+		g.P()
+		g.P("func (c *", client, ") ", method.GoName, signature(g, method), " {")
+		switch {
+		case method.Desc.IsStreamingClient() && method.Desc.IsStreamingServer():
+			generateBidiClient(g, method)
+		case method.Desc.IsStreamingClient():
+			generateClientStreamClient(g, method)
+		default:
+			generateServerStreamClient(g, method)
+		}
+		g.P("}")
+	}
+}
+
+// generateServerStreamClient generates the client call of a server streaming
+// method, receiving the responses until the stream ends.
+func generateServerStreamClient(g *protogen.GeneratedFile, method *protogen.Method) {
+	g.P("stream, err := c.client.", method.GoName, "(ctx, req, c.opts...)")
+	g.P("if err != nil {")
+	g.P("return err")
+	g.P("}")
+	generateReceive(g, "return nil", "return err")
+}
+
+// generateReceive generates the loop receiving the messages of stream to out,
+// until it ends with eof, or fails with fail.
+func generateReceive(g *protogen.GeneratedFile, eof, fail string) {
+	g.P("for {")
+	g.P("resp, err := stream.Recv()")
+	g.P("if err == ", ioPackage.Ident("EOF"), " {")
+	g.P(eof)
+	g.P("}")
+	g.P("if err != nil {")
+	g.P(fail)
+	g.P("}")
+	g.P("select {")
+	g.P("case out <- resp:")
+	g.P("case <-ctx.Done():")
+	g.P("return ctx.Err()")
+	g.P("}")
+	g.P("}")
+}
+
+// generateClientStreamClient generates the client call of a client streaming
+// method, sending the requests until in is closed.
+func generateClientStreamClient(g *protogen.GeneratedFile, method *protogen.Method) {
+	g.P("stream, err := c.client.", method.GoName, "(ctx, c.opts...)")
+	g.P("if err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("for {")
+	g.P("select {")
+	g.P("case req, ok := <-in:")
+	g.P("if !ok {")
+	g.P("return stream.CloseAndRecv()")
+	g.P("}")
+	g.P("if err := stream.Send(req); err == ", ioPackage.Ident("EOF"), " {")
+	g.P("// The server ended the stream, with the status received next.")
+	g.P("return stream.CloseAndRecv()")
+	g.P("} else if err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("case <-ctx.Done():")
+	g.P("return nil, ctx.Err()")
+	g.P("}")
+	g.P("}")
+}
+
+// generateBidiClient generates the client call of a bidirectional streaming
+// method, sending the requests in the background while receiving the
+// responses.
+func generateBidiClient(g *protogen.GeneratedFile, method *protogen.Method) {
+	g.P("ctx, cancel := ", contextPackage.Ident("WithCancel"), "(ctx)")
+	g.P("defer cancel()")
+	g.P("stream, err := c.client.", method.GoName, "(ctx, c.opts...)")
+	g.P("if err != nil {")
+	g.P("return err")
+	g.P("}")
+	g.P("// sendErr holds the error of a failed send, which cancels the stream.")
+	g.P("sendErr := make(chan error, 1)")
+	g.P("go func() {")
+	g.P("for {")
+	g.P("select {")
+	g.P("case req, ok := <-in:")
+	g.P("if !ok {")
+	g.P("stream.CloseSend()")
+	g.P("return")
+	g.P("}")
+	g.P("// On io.EOF, the server ended the stream, with the status")
+	g.P("// received next.")
+	g.P("if err := stream.Send(req); err != nil {")
+	g.P("if err != ", ioPackage.Ident("EOF"), " {")
+	g.P("sendErr <- err")
+	g.P("cancel()")
+	g.P("}")
+	g.P("return")
+	g.P("}")
+	g.P("case <-ctx.Done():")
+	g.P("return")
+	g.P("}")
+	g.P("}")
+	g.P("}()")
+	generateReceive(g, "return nil", `select {
+case err = <-sendErr:
+default:
+}
+return err`)
+}
+
+// generateServe generates the func serving a streaming method of the gRPC
+// server with the channel-based handler of a Streams.
+func generateServe(g *protogen.GeneratedFile, srv *protogen.Service, method *protogen.Method) {
+	stream := srv.GoName + "_" + method.GoName + "Server"
+	serve := "Serve" + srv.GoName + method.GoName
+	g.P()
+	g.P("// ", serve, " serves the ", method.GoName, " method of ", srv.GoName, "Server with the")
+	g.P("// ", method.GoName, " handler of h. The context of the handler is canceled once the")
+	g.P("// stream fails.")
+	if !method.Desc.IsStreamingClient() {
+		g.P("func ", serve, "(h ", srv.GoName, "Streams, req *", g.QualifiedGoIdent(method.Input.GoIdent), ", stream ", stream, ") error {")
+	} else {
+		g.P("func ", serve, "(h ", srv.GoName, "Streams, stream ", stream, ") error {")
+	}
+	g.P("ctx, cancel := ", contextPackage.Ident("WithCancel"), "(stream.Context())")
+	g.P("defer cancel()")
+	if method.Desc.IsStreamingClient() {
+		input := "*" + g.QualifiedGoIdent(method.Input.GoIdent)
+		g.P("in := make(chan ", input, ")")
+		g.P("// recvErr holds the error of a failed receive, set before closing in.")
+		g.P("recvErr := make(chan error, 1)")
+		g.P("go func() {")
+		g.P("defer close(in)")
+		g.P("for {")
+		g.P("req, err := stream.Recv()")
+		g.P("if err == ", ioPackage.Ident("EOF"), " {")
+		g.P("return")
+		g.P("}")
+		g.P("if err != nil {")
+		g.P("recvErr <- err")
+		g.P("cancel()")
+		g.P("return")
+		g.P("}")
+		g.P("select {")
+		g.P("case in <- req:")
+		g.P("case <-ctx.Done():")
+		g.P("return")
+		g.P("}")
+		g.P("}")
+		g.P("}()")
+	}
+	if !method.Desc.IsStreamingServer() {
+		g.P("resp, err := h.", method.GoName, "(ctx, in)")
+		generateRecvErr(g)
+		g.P("if err != nil {")
+		g.P("return err")
+		g.P("}")
+		g.P("return stream.SendAndClose(resp)")
+		g.P("}")
+		return
+	}
+	output := "*" + g.QualifiedGoIdent(method.Output.GoIdent)
+	args := "ctx, req, out"
+	if method.Desc.IsStreamingClient() {
+		args = "ctx, in, out"
+	}
+	g.P("out := make(chan ", output, ")")
+	g.P("done := make(chan error, 1)")
+	g.P("go func() {")
+	g.P("done <- h.", method.GoName, "(", args, ")")
+	g.P("}()")
+	g.P("for {")
+	g.P("select {")
+	g.P("case resp := <-out:")
+	g.P("if err := stream.Send(resp); err != nil {")
+	g.P("return err")
+	g.P("}")
+	g.P("case err := <-done:")
+	if method.Desc.IsStreamingClient() {
+		generateRecvErr(g)
+	}
+	g.P("return err")
+	g.P("}")
+	g.P("}")
+	g.P("}")
+}
+
+// generateRecvErr generates the check of the receive error, which takes
+// precedence over the error of the handler, as it may have been caused by it.
+func generateRecvErr(g *protogen.GeneratedFile) {
+	g.P("select {")
+	g.P("case err = <-recvErr:")
+	g.P("default:")
+	g.P("}")
+}
+
+// lowerFirst returns s with its first letter in lower case.
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
@@ -0,0 +1,179 @@
+package generate
+
+import (
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+const (
+	codesPackage      = protogen.GoImportPath("google.golang.org/grpc/codes")
+	contextPackage    = protogen.GoImportPath("context")
+	errdetailsPackage = protogen.GoImportPath("google.golang.org/genproto/googleapis/rpc/errdetails")
+	grpcPackage       = protogen.GoImportPath("google.golang.org/grpc")
+	statusPackage     = protogen.GoImportPath("google.golang.org/grpc/status")
+)
+
+// Generate generates, for each file to generate with services, gRPC server
+// interceptors validating the requests, and a wrapper of each gRPC server
+// doing the same, for grpc-gateway handlers calling the server directly.
+//
+// Requests are validated with their ValidateAll or Validate methods, as
+// generated by protoc-gen-validate, and requests without them are left
+// alone. Violations are returned as InvalidArgument errors, with
+// google.rpc.BadRequest details.
+func Generate(gen *protogen.Plugin) error {
+	for _, f := range gen.Files {
+		if !f.Generate || len(f.Services) == 0 {
+			continue
+		}
+		g := gen.NewGeneratedFile(f.GeneratedFilenamePrefix+".validate.go", f.GoImportPath)
+		g.P(`// Code generated by "validategen"; DO NOT EDIT.`)
+		g.P("// source: ", f.Desc.Path())
+		g.P()
+		g.P("package ", f.GoPackageName)
+		generateValidate(g)
+		generateInterceptors(g)
+		for _, srv := range f.Services {
+			generateServer(g, srv)
+		}
+	}
+	return nil
+}
+
+// generateValidate generates validateRequest, shared by the interceptors and
+// servers of a file.
+func generateValidate(g *protogen.GeneratedFile) {
+	g.P()
+	g.P("// validateRequest validates req with its ValidateAll or Validate method, if it")
+	g.P("// has one. Violations are returned as an InvalidArgument error, with")
+	g.P("// google.rpc.BadRequest details holding a field violation for each of them.")
+	g.P("func validateRequest(req interface{}) error {")
+	g.P("var err error")
+	g.P("switch req := req.(type) {")
+	g.P("case interface{ ValidateAll() error }:")
+	g.P("err = req.ValidateAll()")
+	g.P("case interface{ Validate() error }:")
+	g.P("err = req.Validate()")
+	g.P("}")
+	g.P("if err == nil {")
+	g.P("return nil")
+	g.P("}")
+	g.P("errs := []error{err}")
+	g.P("if multi, ok := err.(interface{ AllErrors() []error }); ok {")
+	g.P("errs = multi.AllErrors()")
+	g.P("}")
+	g.P("details := &", errdetailsPackage.Ident("BadRequest"), "{}")
+	g.P("for _, err := range errs {")
+	g.P("violation := &", errdetailsPackage.Ident("BadRequest_FieldViolation"), "{Description: err.Error()}")
+	g.P("if err, ok := err.(interface {")
+	g.P("Field() string")
+	g.P("Reason() string")
+	g.P("}); ok {")
+	g.P("violation.Field = err.Field()")
+	g.P("violation.Description = err.Reason()")
+	g.P("}")
+	g.P("details.FieldViolations = append(details.FieldViolations, violation)")
+	g.P("}")
+	g.P("st, derr := ", statusPackage.Ident("New"), "(", codesPackage.Ident("InvalidArgument"), ", err.Error()).WithDetails(details)")
+	g.P("if derr != nil {")
+	g.P("return ", statusPackage.Ident("Error"), "(", codesPackage.Ident("InvalidArgument"), ", err.Error())")
+	g.P("}")
+	g.P("return st.Err()")
+	g.P("}")
+}
+
+func generateInterceptors(g *protogen.GeneratedFile) {
+	g.P()
+	g.P("// ValidationUnaryServerInterceptor returns a unary server interceptor which")
+	g.P("// validates the requests before calling the handlers.")
+	g.P("func ValidationUnaryServerInterceptor() ", grpcPackage.Ident("UnaryServerInterceptor"), " {")
+	g.P("return func(ctx ", contextPackage.Ident("Context"), ", req interface{}, info *", grpcPackage.Ident("UnaryServerInfo"), ", handler ", grpcPackage.Ident("UnaryHandler"), ") (interface{}, error) {")
+	g.P("if err := validateRequest(req); err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("return handler(ctx, req)")
+	g.P("}")
+	g.P("}")
+	g.P()
+	g.P("// ValidationStreamServerInterceptor returns a stream server interceptor which")
+	g.P("// validates each message received from the clients.")
+	g.P("func ValidationStreamServerInterceptor() ", grpcPackage.Ident("StreamServerInterceptor"), " {")
+	g.P("return func(srv interface{}, stream ", grpcPackage.Ident("ServerStream"), ", info *", grpcPackage.Ident("StreamServerInfo"), ", handler ", grpcPackage.Ident("StreamHandler"), ") error {")
+	g.P("return handler(srv, &validatingServerStream{stream})")
+	g.P("}")
+	g.P("}")
+	g.P()
+	g.P("type validatingServerStream struct {")
+	g.P(grpcPackage.Ident("ServerStream"))
+	g.P("}")
+	g.P()
+	g.P("func (s *validatingServerStream) RecvMsg(m interface{}) error {")
+	g.P("if err := s.ServerStream.RecvMsg(m); err != nil {")
+	g.P("return err")
+	g.P("}")
+	g.P("return validateRequest(m)")
+	g.P("}")
+}
+
+// generateServer generates the validating wrapper of the gRPC server of srv,
+// as generated by protoc-gen-go-grpc.
+func generateServer(g *protogen.GeneratedFile, srv *protogen.Service) {
+	server := srv.GoName + "Server"
+	wrapper := "validating" + server
+	g.P()
+	g.P("// NewValidating", server, " returns a ", server, " calling srv, which validates")
+	g.P("// the requests first. Unlike the interceptors, it also validates the requests")
+	g.P("// of grpc-gateway handlers registered with Register", server, "HandlerServer.")
+	g.P("func NewValidating", server, "(srv ", server, ") ", server, " {")
+	g.P("return &", wrapper, "{srv}")
+	g.P("}")
+	g.P()
+	g.P("type ", wrapper, " struct {")
+	g.P(server)
+	g.P("}")
+	for _, method := range srv.Methods {
+		generateMethod(g, srv, method)
+	}
+}
+
+func generateMethod(g *protogen.GeneratedFile, srv *protogen.Service, method *protogen.Method) {
+	server := srv.GoName + "Server"
+	wrapper := "validating" + server
+	input := g.QualifiedGoIdent(method.Input.GoIdent)
+	stream := srv.GoName + "_" + method.GoName + "Server"
+	g.P()
+	switch {
+	case !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer():
+		g.P("func (s *", wrapper, ") ", method.GoName, "(ctx ", contextPackage.Ident("Context"), ", req *", input, ") (*", g.QualifiedGoIdent(method.Output.GoIdent), ", error) {")
+		g.P("if err := validateRequest(req); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return s.", server, ".", method.GoName, "(ctx, req)")
+		g.P("}")
+	case !method.Desc.IsStreamingClient():
+		g.P("func (s *", wrapper, ") ", method.GoName, "(req *", input, ", stream ", stream, ") error {")
+		g.P("if err := validateRequest(req); err != nil {")
+		g.P("return err")
+		g.P("}")
+		g.P("return s.", server, ".", method.GoName, "(req, stream)")
+		g.P("}")
+	default:
+		g.P("func (s *", wrapper, ") ", method.GoName, "(stream ", stream, ") error {")
+		g.P("return s.", server, ".", method.GoName, "(&validating", stream, "{stream})")
+		g.P("}")
+		g.P()
+		g.P("type validating", stream, " struct {")
+		g.P(stream)
+		g.P("}")
+		g.P()
+		g.P("func (s *validating", stream, ") Recv() (*", input, ", error) {")
+		g.P("req, err := s.", stream, ".Recv()")
+		g.P("if err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("if err := validateRequest(req); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return req, nil")
+		g.P("}")
+	}
+}
@@ -0,0 +1,870 @@
+// Package vet implements the checks of `gunk vet` on Gunk packages, which
+// report style and correctness issues that don't stop generation.
+package vet
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/utilities"
+	"github.com/gunk/gunk/config"
+	"github.com/gunk/gunk/httprule"
+	"github.com/gunk/gunk/loader"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Severities of the rules, set by name in the [vet] section of a .gunkconfig.
+// Warnings are printed, but only errors make vetting fail.
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	severityOff     = "off"
+)
+
+// rule is a check run on every Gunk package. Rules are errors by default,
+// apart from the documentation rules, whose default depends on the maturity
+// of the package.
+type rule struct {
+	name  string
+	check func(c *checker)
+	doc   bool
+}
+
+var rules = []rule{
+	{"duplicate_numbers", checkDuplicateNumbers, false},
+	{"json_names", checkJSONNames, false},
+	{"enum_zero_value", checkEnumZeroValue, false},
+	{"http_bindings", checkHTTPBindings, false},
+	{"doc_missing", checkDocMissing, true},
+	{"doc_style", checkDocStyle, true},
+	{"terminology", checkTerminology, false},
+	{"http_collisions", checkHTTPCollisions, false},
+	{"idempotency", checkIdempotency, false},
+	{"http_verbs", checkHTTPVerbs, false},
+	{"limits", checkLimits, false},
+	{"dependencies", checkDependencies, false},
+}
+
+// maturitySeverity is the default severity of the documentation rules for
+// each package maturity. Without a maturity, they are off.
+var maturitySeverity = map[string]string{
+	"":             severityOff,
+	"experimental": severityOff,
+	"beta":         severityWarning,
+	"stable":       severityError,
+}
+
+// issue is a problem found by a rule.
+type issue struct {
+	pos     token.Position
+	rule    string
+	msg     string
+	warning bool
+}
+
+func (i issue) String() string {
+	if i.warning {
+		return fmt.Sprintf("%s: warning: %s (%s)", i.pos, i.msg, i.rule)
+	}
+	return fmt.Sprintf("%s: %s (%s)", i.pos, i.msg, i.rule)
+}
+
+// Run vets the Gunk packages matching patterns in dir, writing the issues
+// found to w. An error is returned if there were any issues which aren't
+// warnings. It's not an
+// error for no Gunk packages to match, so that `gunk vet` can be used on
+// directories which only hold a .gunkconfig.
+func Run(w io.Writer, dir string, patterns ...string) error {
+	fset := token.NewFileSet()
+	l := loader.Loader{Dir: dir, Fset: fset, Types: true}
+	pkgs, err := l.Load(patterns...)
+	if err != nil {
+		return err
+	}
+	if loader.PrintErrors(pkgs) > 0 {
+		return fmt.Errorf("encountered package loading errors")
+	}
+	// HTTP bindings are shared by all packages, since they may be served
+	// by the same gateway.
+	bindings := make(map[string]httpBinding)
+	var issues []issue
+	for _, pkg := range pkgs {
+		found, err := vetPackage(fset, pkg, bindings)
+		if err != nil {
+			return err
+		}
+		issues = append(issues, found...)
+	}
+	errs := 0
+	for _, i := range issues {
+		fmt.Fprintln(w, i)
+		if !i.warning {
+			errs++
+		}
+	}
+	if errs > 0 {
+		return fmt.Errorf("found %d vet issues", errs)
+	}
+	return nil
+}
+
+// vetPackage runs the rules enabled by the .gunkconfig of a loaded Gunk
+// package, returning the issues found sorted by position.
+func vetPackage(fset *token.FileSet, pkg *loader.GunkPackage, bindings map[string]httpBinding) ([]issue, error) {
+	cfg, err := config.Load(pkg.Dir)
+	if errors.Is(err, config.ErrNoConfig) {
+		cfg, err = &config.Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to load gunkconfig: %w", err)
+	}
+	severities, err := ruleSeverities(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &checker{fset: fset, pkg: pkg, cfg: cfg, bindings: bindings}
+	for _, r := range rules {
+		if severities[r.name] == severityOff {
+			continue
+		}
+		c.rule = r.name
+		c.warning = severities[r.name] == severityWarning
+		r.check(c)
+	}
+	sort.SliceStable(c.issues, func(i, j int) bool {
+		a, b := c.issues[i].pos, c.issues[j].pos
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		return a.Offset < b.Offset
+	})
+	return c.issues, nil
+}
+
+// CheckConfig returns an error if the [vet] section of cfg, as merged from
+// its config files, sets rules which don't exist.
+func CheckConfig(cfg *config.Config) error {
+	_, err := ruleSeverities(cfg)
+	return err
+}
+
+// ruleSeverities returns the severity of each rule, as set by cfg.
+func ruleSeverities(cfg *config.Config) (map[string]string, error) {
+	severities := make(map[string]string, len(rules))
+	for _, r := range rules {
+		severities[r.name] = severityError
+		if r.doc {
+			severities[r.name] = maturitySeverity[cfg.VetMaturity]
+		}
+	}
+	for name, severity := range cfg.Vet {
+		if _, ok := severities[name]; !ok {
+			names := make([]string, len(rules))
+			for i, r := range rules {
+				names[i] = r.name
+			}
+			return nil, fmt.Errorf("unknown vet rule %q%s", name, config.DidYouMean(name, names))
+		}
+		severities[name] = severity
+	}
+	return severities, nil
+}
+
+type checker struct {
+	fset    *token.FileSet
+	pkg     *loader.GunkPackage
+	cfg     *config.Config
+	rule    string
+	warning bool
+	issues  []issue
+	// bindings holds the HTTP bindings of the packages vetted so far,
+	// keyed by verb and normalized path template.
+	bindings map[string]httpBinding
+}
+
+func (c *checker) report(pos token.Pos, format string, args ...interface{}) {
+	c.issues = append(c.issues, issue{
+		pos:     c.fset.Position(pos),
+		rule:    c.rule,
+		msg:     fmt.Sprintf(format, args...),
+		warning: c.warning,
+	})
+}
+
+// hasTag reports whether node has a +gunk tag of the given option type, such
+// as "github.com/gunk/opt/http.Match".
+func (c *checker) hasTag(node ast.Node, typ string) bool {
+	for _, tag := range c.pkg.GunkTags[node] {
+		if tag.Type.String() == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// typeSpecs calls fn for each type declared in the package.
+func (c *checker) typeSpecs(fn func(tspec *ast.TypeSpec)) {
+	for _, file := range c.pkg.GunkSyntax {
+		for _, decl := range file.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				fn(spec.(*ast.TypeSpec))
+			}
+		}
+	}
+}
+
+// enumValue is a constant declared as a value of an enum.
+type enumValue struct {
+	name *ast.Ident
+	val  int64
+}
+
+// enumValues returns the values of each enum type declared in the package, in
+// declaration order.
+func (c *checker) enumValues() map[types.Type][]enumValue {
+	values := make(map[types.Type][]enumValue)
+	for _, file := range c.pkg.GunkSyntax {
+		for _, decl := range file.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				for _, name := range spec.(*ast.ValueSpec).Names {
+					cnst, ok := c.pkg.TypesInfo.Defs[name].(*types.Const)
+					if !ok {
+						continue
+					}
+					val, _ := constant.Int64Val(cnst.Val())
+					values[cnst.Type()] = append(values[cnst.Type()], enumValue{name, val})
+				}
+			}
+		}
+	}
+	return values
+}
+
+// isEnum reports whether tspec declares an enum type.
+func (c *checker) isEnum(tspec *ast.TypeSpec) bool {
+	basic, ok := c.pkg.TypesInfo.TypeOf(tspec.Name).Underlying().(*types.Basic)
+	return ok && (basic.Kind() == types.Int || basic.Kind() == types.Int32)
+}
+
+// checkDuplicateNumbers reports enum values which reuse the number of a
+// previous value, unless the enum allows aliases. Message fields reusing a
+// number are already rejected when loading the package.
+func checkDuplicateNumbers(c *checker) {
+	values := c.enumValues()
+	c.typeSpecs(func(tspec *ast.TypeSpec) {
+		if !c.isEnum(tspec) || c.hasTag(tspec, "github.com/gunk/opt/enum.AllowAlias") {
+			return
+		}
+		seen := make(map[int64]string)
+		for _, v := range values[c.pkg.TypesInfo.TypeOf(tspec.Name)] {
+			if prev, ok := seen[v.val]; ok {
+				c.report(v.name.Pos(), "enum value %s reuses number %d of %s", v.name.Name, v.val, prev)
+				continue
+			}
+			seen[v.val] = v.name.Name
+		}
+	})
+}
+
+// checkJSONNames reports message fields without a json tag.
+func checkJSONNames(c *checker) {
+	c.typeSpecs(func(tspec *ast.TypeSpec) {
+		st, ok := tspec.Type.(*ast.StructType)
+		if !ok {
+			return
+		}
+		for _, field := range loader.NumberedFields(st) {
+			if len(field.Names) != 1 {
+				continue
+			}
+			str := ""
+			if field.Tag != nil {
+				str, _ = strconv.Unquote(field.Tag.Value)
+			}
+			if reflect.StructTag(str).Get("json") == "" {
+				c.report(field.Pos(), "field %s.%s has no json name", tspec.Name.Name, field.Names[0].Name)
+			}
+		}
+	})
+}
+
+// checkEnumZeroValue reports enums whose zero value isn't named as
+// unspecified, such as STATUS_UNSPECIFIED or StatusUnspecified, since the
+// zero value is also used when a field isn't set.
+func checkEnumZeroValue(c *checker) {
+	values := c.enumValues()
+	c.typeSpecs(func(tspec *ast.TypeSpec) {
+		if !c.isEnum(tspec) {
+			return
+		}
+		enumValues := values[c.pkg.TypesInfo.TypeOf(tspec.Name)]
+		if len(enumValues) == 0 {
+			return
+		}
+		for _, v := range enumValues {
+			if v.val != 0 {
+				continue
+			}
+			if !strings.HasSuffix(v.name.Name, "_UNSPECIFIED") && !strings.HasSuffix(v.name.Name, "Unspecified") {
+				c.report(v.name.Pos(), "zero value %s of enum %s should be named *_UNSPECIFIED", v.name.Name, tspec.Name.Name)
+			}
+			return
+		}
+		c.report(tspec.Pos(), "enum %s has no zero value", tspec.Name.Name)
+	})
+}
+
+// checkHTTPBindings reports service methods without an http.Match option.
+func checkHTTPBindings(c *checker) {
+	c.typeSpecs(func(tspec *ast.TypeSpec) {
+		iface, ok := tspec.Type.(*ast.InterfaceType)
+		if !ok {
+			return
+		}
+		for _, method := range iface.Methods.List {
+			if len(method.Names) != 1 {
+				continue
+			}
+			if len(c.httpMatches(method)) == 0 {
+				c.report(method.Pos(), "method %s.%s has no http.Match binding", tspec.Name.Name, method.Names[0].Name)
+			}
+		}
+	})
+}
+
+// docElement is a service, method, message or field, along with its doc
+// comment.
+type docElement struct {
+	kind string
+	name string
+	full string
+	pos  token.Pos
+	doc  *ast.CommentGroup
+}
+
+// docElements calls fn for each service, method, message and field declared
+// in the package.
+func (c *checker) docElements(fn func(e docElement)) {
+	c.typeSpecs(func(tspec *ast.TypeSpec) {
+		name := tspec.Name.Name
+		switch typ := tspec.Type.(type) {
+		case *ast.InterfaceType:
+			fn(docElement{"service", name, name, tspec.Pos(), tspec.Doc})
+			for _, method := range typ.Methods.List {
+				if len(method.Names) != 1 {
+					continue
+				}
+				mname := method.Names[0].Name
+				fn(docElement{"method", mname, name + "." + mname, method.Pos(), method.Doc})
+			}
+		case *ast.StructType:
+			fn(docElement{"message", name, name, tspec.Pos(), tspec.Doc})
+			for _, field := range loader.NumberedFields(typ) {
+				if len(field.Names) != 1 {
+					continue
+				}
+				fname := field.Names[0].Name
+				fn(docElement{"field", fname, name + "." + fname, field.Pos(), field.Doc})
+			}
+		}
+	})
+}
+
+// docText returns the text of doc, without any +gunk tags.
+func docText(doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+	return strings.TrimSpace(doc.Text())
+}
+
+// checkDocMissing reports services, methods, messages and fields without a
+// doc comment.
+func checkDocMissing(c *checker) {
+	c.docElements(func(e docElement) {
+		if docText(e.doc) == "" {
+			c.report(e.pos, "%s %s has no doc comment", e.kind, e.full)
+		}
+	})
+}
+
+// checkDocStyle reports doc comments which don't start with the name of the
+// element they document, optionally after an article, or which don't end
+// with a full sentence.
+func checkDocStyle(c *checker) {
+	c.docElements(func(e docElement) {
+		text := docText(e.doc)
+		if text == "" {
+			return
+		}
+		words := strings.Fields(text)
+		first := words[0]
+		switch first {
+		case "A", "An", "The":
+			if len(words) > 1 {
+				first = words[1]
+			}
+		}
+		if first != e.name {
+			c.report(e.doc.Pos(), "doc comment of %s %s should start with %q", e.kind, e.full, e.name)
+		}
+		if !strings.ContainsAny(text[len(text)-1:], ".!?") {
+			c.report(e.doc.Pos(), "doc comment of %s %s should end with a period", e.kind, e.full)
+		}
+	})
+}
+
+// comments calls fn for each doc comment which ends up in the proto
+// descriptors: those of the package, types, fields, methods and enum values.
+// The comments of docs with +gunk tags were rewritten without them, so only
+// the position of the whole doc is known; tagged reports whether that's the
+// case.
+func (c *checker) comments(fn func(doc *ast.CommentGroup, tagged bool)) {
+	for _, file := range c.pkg.GunkSyntax {
+		ast.Inspect(file, func(node ast.Node) bool {
+			var doc *ast.CommentGroup
+			switch node := node.(type) {
+			case *ast.File:
+				doc = node.Doc
+			case *ast.TypeSpec:
+				doc = node.Doc
+			case *ast.Field:
+				doc = node.Doc
+			case *ast.ValueSpec:
+				doc = node.Doc
+			}
+			if doc != nil {
+				fn(doc, len(c.pkg.GunkTags[node]) > 0)
+			}
+			return true
+		})
+	}
+}
+
+// checkTerminology reports terms in doc comments which are banned or don't
+// use their preferred spelling, as listed in the [vet terminology] section of
+// the .gunkconfig. Terms match whole words, ignoring case.
+func checkTerminology(c *checker) {
+	if len(c.cfg.Terminology) == 0 {
+		return
+	}
+	terms := make([]string, 0, len(c.cfg.Terminology))
+	for term := range c.cfg.Terminology {
+		terms = append(terms, regexp.QuoteMeta(term))
+	}
+	// Match longer terms first, so that they win over their prefixes.
+	sort.Slice(terms, func(i, j int) bool {
+		if len(terms[i]) != len(terms[j]) {
+			return len(terms[i]) > len(terms[j])
+		}
+		return terms[i] < terms[j]
+	})
+	rx := regexp.MustCompile(`(?i)\b(` + strings.Join(terms, "|") + `)\b`)
+	c.comments(func(doc *ast.CommentGroup, tagged bool) {
+		for _, comment := range doc.List {
+			pos := comment.Pos()
+			if tagged {
+				pos = doc.Pos()
+			}
+			for _, word := range rx.FindAllString(comment.Text, -1) {
+				spelling := c.cfg.Terminology[strings.ToLower(word)]
+				switch {
+				case spelling == "":
+					c.report(pos, "doc comment uses banned term %q", word)
+				case word != spelling:
+					c.report(pos, "doc comment uses %q instead of %q", word, spelling)
+				}
+			}
+		}
+	})
+}
+
+// httpBinding is the HTTP binding of a service method.
+type httpBinding struct {
+	method string // such as "example.com/pkg.Service.Get"
+	pos    token.Position
+}
+
+// checkHTTPCollisions reports service methods binding the same verb and path
+// template as another method, in this or a previous package. Such bindings
+// would collide when served by the same gateway.
+func checkHTTPCollisions(c *checker) {
+	c.typeSpecs(func(tspec *ast.TypeSpec) {
+		iface, ok := tspec.Type.(*ast.InterfaceType)
+		if !ok {
+			return
+		}
+		for _, method := range iface.Methods.List {
+			if len(method.Names) != 1 {
+				continue
+			}
+			for _, match := range c.httpMatches(method) {
+				verb, path, _ := httpMatch(match)
+				key, err := httpBindingKey(verb, path)
+				if err != nil {
+					// Invalid templates are reported by gunk generate.
+					continue
+				}
+				b := httpBinding{
+					method: c.pkg.PkgPath + "." + tspec.Name.Name + "." + method.Names[0].Name,
+					pos:    c.fset.Position(method.Pos()),
+				}
+				if prev, ok := c.bindings[key]; ok {
+					c.report(method.Pos(), "method %s binds %s %s, as does %s at %s", b.method, verb, path, prev.method, prev.pos)
+					continue
+				}
+				c.bindings[key] = b
+			}
+		}
+	})
+}
+
+// httpMatches returns the expressions of the http.Match tags of method, in
+// order.
+func (c *checker) httpMatches(method *ast.Field) []ast.Expr {
+	var list []ast.Expr
+	for _, tag := range c.pkg.GunkTags[method] {
+		if tag.Type.String() == "github.com/gunk/opt/http.Match" {
+			list = append(list, tag.Expr)
+		}
+	}
+	return list
+}
+
+// httpMatch returns the verb, path template and body of an http.Match tag.
+func httpMatch(expr ast.Expr) (verb, path, body string) {
+	verb = "GET"
+	lit, ok := expr.(*ast.CompositeLit)
+	if !ok {
+		return verb, "", ""
+	}
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			continue
+		}
+		key, ok1 := kv.Key.(*ast.Ident)
+		val, ok2 := kv.Value.(*ast.BasicLit)
+		if !ok1 || !ok2 {
+			continue
+		}
+		str, _ := strconv.Unquote(val.Value)
+		switch key.Name {
+		case "Method":
+			verb = strings.ToUpper(str)
+		case "Path":
+			path = str
+		case "Body":
+			body = str
+		}
+	}
+	return verb, path, body
+}
+
+// methodBindings calls fn for each service method, along with the verbs and
+// bodies of its http.Match bindings. Methods without bindings are skipped.
+func (c *checker) methodBindings(fn func(tspec *ast.TypeSpec, method *ast.Field, verbs, bodies []string)) {
+	c.typeSpecs(func(tspec *ast.TypeSpec) {
+		iface, ok := tspec.Type.(*ast.InterfaceType)
+		if !ok {
+			return
+		}
+		for _, method := range iface.Methods.List {
+			if len(method.Names) != 1 {
+				continue
+			}
+			var verbs, bodies []string
+			for _, match := range c.httpMatches(method) {
+				verb, _, body := httpMatch(match)
+				verbs = append(verbs, verb)
+				bodies = append(bodies, body)
+			}
+			if len(verbs) > 0 {
+				fn(tspec, method, verbs, bodies)
+			}
+		}
+	})
+}
+
+// checkIdempotency reports HTTP bindings which contradict the idempotency
+// level of their method: methods without side effects should use GET, or POST
+// if their request needs a body, and idempotent methods can't use POST.
+func checkIdempotency(c *checker) {
+	c.methodBindings(func(tspec *ast.TypeSpec, method *ast.Field, verbs, bodies []string) {
+		var level *loader.GunkTag
+		for i, tag := range c.pkg.GunkTags[method] {
+			if tag.Type.String() == "github.com/gunk/opt/method.IdempotencyLevel" {
+				level = &c.pkg.GunkTags[method][i]
+			}
+		}
+		if level == nil || level.Value == nil {
+			return
+		}
+		name := tspec.Name.Name + "." + method.Names[0].Name
+		val, _ := constant.Int64Val(level.Value)
+		for i, verb := range verbs {
+			switch {
+			case val == int64(descriptorpb.MethodOptions_NO_SIDE_EFFECTS) && verb == "POST" && bodies[i] == "":
+				c.report(method.Pos(), "method %s has no side effects, but binds POST without a body; use GET", name)
+			case val == int64(descriptorpb.MethodOptions_NO_SIDE_EFFECTS) && verb != "GET" && verb != "POST":
+				c.report(method.Pos(), "method %s has no side effects, but binds %s; use GET", name, verb)
+			case val == int64(descriptorpb.MethodOptions_IDEMPOTENT) && verb == "POST":
+				c.report(method.Pos(), "method %s is idempotent, but binds POST, which isn't", name)
+			}
+		}
+	})
+}
+
+// checkHTTPVerbs reports HTTP bindings whose verb isn't allowed for the
+// prefix of their method's name, such as "Get" or "Delete", as listed in the
+// [vet verbs] section of the .gunkconfig. The longest matching prefix is
+// used, and prefixes match whole words of the name, ignoring case.
+func checkHTTPVerbs(c *checker) {
+	if len(c.cfg.HTTPVerbs) == 0 {
+		return
+	}
+	c.methodBindings(func(tspec *ast.TypeSpec, method *ast.Field, verbs, bodies []string) {
+		name := method.Names[0].Name
+		prefix := ""
+		for p := range c.cfg.HTTPVerbs {
+			if len(p) <= len(prefix) || len(p) > len(name) || !strings.EqualFold(name[:len(p)], p) {
+				continue
+			}
+			// "Get" shouldn't match "Getaway".
+			if len(p) < len(name) && !unicode.IsUpper(rune(name[len(p)])) && !unicode.IsDigit(rune(name[len(p)])) {
+				continue
+			}
+			prefix = p
+		}
+		if prefix == "" {
+			return
+		}
+		allowed := c.cfg.HTTPVerbs[prefix]
+	verbs:
+		for _, verb := range verbs {
+			for _, a := range allowed {
+				if verb == a {
+					continue verbs
+				}
+			}
+			c.report(method.Pos(), "method %s.%s binds %s, but %s methods must use %s", tspec.Name.Name, name, verb, name[:len(prefix)], strings.Join(allowed, " or "))
+		}
+	})
+}
+
+// httpBindingKey returns the verb and path template as matched by a gateway,
+// ignoring the names of the fields bound by variables, so that templates such
+// as "/v1/books/{id}" and "/v1/books/{name}" have the same key.
+func httpBindingKey(verb, path string) (string, error) {
+	compiler, err := httprule.Parse(path)
+	if err != nil {
+		return "", err
+	}
+	tmpl := compiler.Compile()
+	var segs []string
+	for i := 0; i+1 < len(tmpl.OpCodes); i += 2 {
+		switch utilities.OpCode(tmpl.OpCodes[i]) {
+		case utilities.OpPush:
+			segs = append(segs, "*")
+		case utilities.OpLitPush:
+			segs = append(segs, tmpl.Pool[tmpl.OpCodes[i+1]])
+		case utilities.OpPushM:
+			segs = append(segs, "**")
+		}
+	}
+	return verb + " /" + strings.Join(segs, "/") + ":" + tmpl.Verb, nil
+}
+
+// checkLimits reports messages, enums and services exceeding the limits set
+// in the [vet limits] section of the .gunkconfig: the number of fields of a
+// message, how deeply messages are nested through their fields, the number of
+// values of an enum, and the number of methods of a service.
+func checkLimits(c *checker) {
+	limits := c.cfg.VetLimits
+	if len(limits) == 0 {
+		return
+	}
+	values := c.enumValues()
+	depths := make(map[*types.Named]int)
+	c.typeSpecs(func(tspec *ast.TypeSpec) {
+		name := tspec.Name.Name
+		switch typ := tspec.Type.(type) {
+		case *ast.StructType:
+			if max := limits["max_fields"]; max > 0 {
+				if n := len(loader.NumberedFields(typ)); n > max {
+					c.report(tspec.Pos(), "message %s has %d fields, more than the limit of %d", name, n, max)
+				}
+			}
+			if max := limits["max_depth"]; max > 0 {
+				named, _ := c.pkg.TypesInfo.TypeOf(tspec.Name).(*types.Named)
+				if d := messageDepth(named, depths); d > max {
+					c.report(tspec.Pos(), "message %s nests messages %d deep, more than the limit of %d", name, d, max)
+				}
+			}
+		case *ast.InterfaceType:
+			if max := limits["max_methods"]; max > 0 {
+				if n := len(typ.Methods.List); n > max {
+					c.report(tspec.Pos(), "service %s has %d methods, more than the limit of %d", name, n, max)
+				}
+			}
+		default:
+			max := limits["max_enum_values"]
+			if max == 0 || !c.isEnum(tspec) {
+				return
+			}
+			if n := len(values[c.pkg.TypesInfo.TypeOf(tspec.Name)]); n > max {
+				c.report(tspec.Pos(), "enum %s has %d values, more than the limit of %d", name, n, max)
+			}
+		}
+	})
+}
+
+// messageDepth returns how deeply messages are nested from the message typ
+// through its fields, including the messages of other packages. A message
+// without message fields has a depth of 1. Recursive messages only count
+// once. Depths are cached in depths, where -1 marks messages being visited.
+func messageDepth(typ *types.Named, depths map[*types.Named]int) int {
+	if typ == nil {
+		return 0
+	}
+	if d, ok := depths[typ]; ok {
+		if d < 0 {
+			return 0
+		}
+		return d
+	}
+	st, ok := typ.Underlying().(*types.Struct)
+	if !ok {
+		return 0
+	}
+	depths[typ] = -1
+	d := 1 + structDepth(st, depths)
+	depths[typ] = d
+	return d
+}
+
+// structDepth returns the depth of the deepest message field of st. Oneof
+// groups are anonymous structs, which don't add to the depth.
+func structDepth(st *types.Struct, depths map[*types.Named]int) int {
+	max := 0
+	for i := 0; i < st.NumFields(); i++ {
+		typ := st.Field(i).Type()
+		for {
+			switch t := typ.(type) {
+			case *types.Slice:
+				typ = t.Elem()
+				continue
+			case *types.Map:
+				typ = t.Elem()
+				continue
+			}
+			break
+		}
+		d := 0
+		switch t := typ.(type) {
+		case *types.Named:
+			d = messageDepth(t, depths)
+		case *types.Struct:
+			d = structDepth(t, depths)
+		}
+		if d > max {
+			max = d
+		}
+	}
+	return max
+}
+
+// checkDependencies reports imports leading to packages which the package
+// must not depend on, as listed in the [vet dependencies] section of the
+// .gunkconfig, such as public packages importing internal ones. Imports are
+// followed through the Gunk packages they import, so that forbidden
+// dependencies can't be hidden behind other packages.
+func checkDependencies(c *checker) {
+	var forbidden []string
+	for pattern, deps := range c.cfg.VetDependencies {
+		if matchPackagePattern(pattern, c.pkg.PkgPath) {
+			forbidden = append(forbidden, deps...)
+		}
+	}
+	if len(forbidden) == 0 {
+		return
+	}
+	sort.Strings(forbidden)
+	for _, file := range c.pkg.GunkSyntax {
+		for _, spec := range file.Imports {
+			path, _ := strconv.Unquote(spec.Path.Value)
+			ipkg := c.pkg.Imports[path]
+			if ipkg == nil {
+				continue
+			}
+			chain := forbiddenDependency(ipkg, forbidden, make(map[string]bool))
+			switch {
+			case len(chain) == 0:
+			case len(chain) == 1:
+				c.report(spec.Pos(), "package %s must not import %s", c.pkg.PkgPath, chain[0])
+			default:
+				c.report(spec.Pos(), "package %s must not import %s, imported through %s",
+					c.pkg.PkgPath, chain[len(chain)-1], strings.Join(chain[:len(chain)-1], " -> "))
+			}
+		}
+	}
+}
+
+// forbiddenDependency returns the chain of imports from pkg to the first
+// package it depends on, pkg included, which matches one of the forbidden
+// patterns, or nil if there is none. Packages already visited are skipped.
+func forbiddenDependency(pkg *loader.GunkPackage, forbidden []string, visited map[string]bool) []string {
+	if visited[pkg.PkgPath] {
+		return nil
+	}
+	visited[pkg.PkgPath] = true
+	for _, pattern := range forbidden {
+		if matchPackagePattern(pattern, pkg.PkgPath) {
+			return []string{pkg.PkgPath}
+		}
+	}
+	paths := make([]string, 0, len(pkg.Imports))
+	for path := range pkg.Imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if chain := forbiddenDependency(pkg.Imports[path], forbidden, visited); chain != nil {
+			return append([]string{pkg.PkgPath}, chain...)
+		}
+	}
+	return nil
+}
+
+// matchPackagePattern reports whether the package path matches pattern,
+// where "..." matches any string, as in Go's package patterns. Like in Go, a
+// trailing "/..." also matches the path before it, so that "a/v1/..." matches
+// "a/v1".
+func matchPackagePattern(pattern, path string) bool {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\.\.\.`, `.*`)
+	if strings.HasSuffix(expr, `/.*`) {
+		expr = strings.TrimSuffix(expr, `/.*`) + `(/.*)?`
+	}
+	matched, _ := regexp.MatchString("^"+expr+"$", path)
+	return matched
+}
@@ -0,0 +1,294 @@
+package vetconfig
+
+import (
+	"fmt"
+	"go/token"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gunk/gunk/config"
+	"github.com/gunk/gunk/loader"
+	"github.com/gunk/gunk/vet"
+)
+
+// Check loads the gunkconfig of each Gunk package matching patterns in dir,
+// writing the effective config which applies to it, merged from all of its
+// config files, in the .gunkconfig format. Warnings, such as deprecated
+// keys, are written as comments. Nothing is generated or written to disk.
+//
+// An error is returned if the config of any package can't be loaded, after
+// checking all of them.
+func Check(w io.Writer, dir string, patterns ...string) error {
+	return printPackages(w, dir, (*printer).config, patterns...)
+}
+
+// Print writes the configs which apply to each Gunk package matching
+// patterns in dir, most specific first. With effective, it writes the
+// effective config instead, like Check, annotating each value with the config
+// which set it.
+func Print(w io.Writer, dir string, effective bool, patterns ...string) error {
+	if !effective {
+		return printPackages(w, dir, (*printer).files, patterns...)
+	}
+	return printPackages(w, dir, func(p *printer) {
+		p.sources = true
+		p.config()
+	}, patterns...)
+}
+
+// printPackages loads the config of each Gunk package matching patterns in
+// dir, printing it with show.
+func printPackages(w io.Writer, dir string, show func(*printer), patterns ...string) error {
+	l := loader.Loader{Dir: dir, Fset: token.NewFileSet()}
+	pkgs, err := l.Load(patterns...)
+	if err != nil {
+		return err
+	}
+	base, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for i, pkg := range pkgs {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "# %s\n", pkg.PkgPath)
+		cfg, err := config.Load(pkg.Dir)
+		if err == nil {
+			err = vet.CheckConfig(cfg)
+		}
+		if err != nil {
+			fmt.Fprintf(w, "# error: %v\n", err)
+			failed++
+			continue
+		}
+		show(&printer{w: w, base: base, cfg: cfg})
+	}
+	if failed > 0 {
+		return fmt.Errorf("unable to load the gunkconfig of %d packages", failed)
+	}
+	return nil
+}
+
+type printer struct {
+	w       io.Writer
+	base    string // directory to print paths relative to
+	cfg     *config.Config
+	sources bool   // whether to annotate values with their source
+	section string // section being printed
+}
+
+// rel returns path relative to the base directory, if it is absolute.
+func (p *printer) rel(path string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	if rel, err := filepath.Rel(p.base, path); err == nil {
+		return rel
+	}
+	return path
+}
+
+// source returns an annotation of the config which set the key of section,
+// if sources are printed.
+func (p *printer) source(section, key string) string {
+	id := key
+	if section != "" {
+		id = section + "." + key
+	}
+	return p.annotation(p.cfg.Sources[id])
+}
+
+// annotation returns an annotation of the config src, if sources are
+// printed.
+func (p *printer) annotation(src string) string {
+	if !p.sources || src == "" {
+		return ""
+	}
+	return "  # " + p.rel(src)
+}
+
+// startSection starts a new section, unless it's the current one.
+func (p *printer) startSection(name string) {
+	if p.section == name {
+		return
+	}
+	p.section = name
+	fmt.Fprintf(p.w, "\n[%s]%s\n", name, p.annotation(p.cfg.Sources[name]))
+}
+
+// key prints a key of a section, skipping empty values.
+func (p *printer) key(section, key, value string) {
+	if value == "" {
+		return
+	}
+	p.keyAlways(section, key, value)
+}
+
+// keyAlways prints a key of a section, even if its value is empty.
+func (p *printer) keyAlways(section, key, value string) {
+	if section != "" {
+		p.startSection(section)
+	}
+	fmt.Fprintf(p.w, "%s=%s%s\n", key, value, p.source(section, key))
+}
+
+// files prints the configs merged into the config, one per line.
+func (p *printer) files() {
+	for _, f := range p.cfg.Files {
+		fmt.Fprintln(p.w, p.rel(f))
+	}
+}
+
+// config prints the config in the .gunkconfig format.
+func (p *printer) config() {
+	cfg := p.cfg
+	files := make([]string, len(cfg.Files))
+	for i, f := range cfg.Files {
+		files[i] = p.rel(f)
+	}
+	fmt.Fprintf(p.w, "# from %s\n", strings.Join(files, ", "))
+	for _, w := range cfg.Warnings {
+		// Warnings start with the path of their config file.
+		w = strings.TrimPrefix(w, p.base+string(filepath.Separator))
+		fmt.Fprintf(p.w, "# warning: %s\n", w)
+	}
+	p.key("", "import_path", cfg.ImportPath)
+	p.key("", "go_module_path", cfg.GoModulePath)
+	p.key("", "out_root", p.rel(cfg.OutRoot))
+	if cfg.RequirePinnedVersions {
+		p.key("", "require_pinned_versions", "true")
+	}
+	p.key("protoc", "path", cfg.ProtocPath)
+	p.key("protoc", "version", cfg.ProtocVersion)
+	if m := cfg.GoModule; m != nil {
+		p.startSection("go_module")
+		p.key("go_module", "go", m.GoVersion)
+		p.key("go_module", "version", m.Version)
+		p.key("go_module", "license", p.rel(m.License))
+		if m.Doc {
+			p.key("go_module", "doc", "true")
+		}
+	}
+	if r := cfg.Release; r != nil {
+		p.startSection("release")
+		p.key("release", "tag_prefix", r.TagPrefix)
+	}
+	if b := cfg.Backstage; b != nil {
+		p.startSection("backstage")
+		p.key("backstage", "owner", b.Owner)
+		p.key("backstage", "lifecycle", b.Lifecycle)
+		p.key("backstage", "system", b.System)
+		p.key("backstage", "descriptor_set", b.DescriptorSet)
+	}
+	if s := cfg.Sign; s != nil {
+		p.startSection("sign")
+		p.key("sign", "method", s.Method)
+		p.key("sign", "key", p.rel(s.Key))
+		p.key("sign", "files", strings.Join(s.Files, ","))
+	}
+	p.key("vet", "maturity", cfg.VetMaturity)
+	for _, k := range sortedKeys(cfg.Vet) {
+		p.key("vet", k, cfg.Vet[k])
+	}
+	for _, k := range sortedKeys(cfg.Terminology) {
+		// An empty spelling bans the term, so it is printed too.
+		p.keyAlways("vet terminology", k, cfg.Terminology[k])
+	}
+	verbs := make(map[string]string, len(cfg.HTTPVerbs))
+	for k, v := range cfg.HTTPVerbs {
+		verbs[k] = strings.Join(v, ",")
+	}
+	for _, k := range sortedKeys(verbs) {
+		p.key("vet verbs", k, verbs[k])
+	}
+	limits := make(map[string]string, len(cfg.VetLimits))
+	for k, v := range cfg.VetLimits {
+		limits[k] = fmt.Sprint(v)
+	}
+	for _, k := range sortedKeys(limits) {
+		p.key("vet limits", k, limits[k])
+	}
+	deps := make(map[string]string, len(cfg.VetDependencies))
+	for k, v := range cfg.VetDependencies {
+		deps[k] = strings.Join(v, ",")
+	}
+	for _, k := range sortedKeys(deps) {
+		p.key("vet dependencies", k, deps[k])
+	}
+	typeMappings := make(map[string]string, len(cfg.Types))
+	for k, m := range cfg.Types {
+		typeMappings[k] = strings.TrimSpace(m.Type + " " + m.File)
+	}
+	for _, k := range sortedKeys(typeMappings) {
+		p.key("types", k, typeMappings[k])
+	}
+	for _, dep := range cfg.ProtoDeps {
+		section := "proto_dep " + dep.Name
+		p.startSection(section)
+		p.key(section, "url", dep.URL)
+		p.key(section, "root", dep.Root)
+	}
+	for _, gen := range cfg.Generators {
+		p.generator(gen)
+	}
+}
+
+// generator prints a generator as a [generate] section, with every key
+// spelled out.
+func (p *printer) generator(gen config.Generator) {
+	// Each generator is a section of its own, set by a single config.
+	p.section = "generate"
+	fmt.Fprintf(p.w, "\n[generate]%s\n", p.annotation(gen.Source))
+	switch {
+	case gen.IsProtoc():
+		p.key("generate", "protoc", gen.ProtocGen)
+	case gen.Remote != "":
+		p.key("generate", "remote", gen.Remote)
+	default:
+		p.key("generate", "command", gen.Command)
+	}
+	p.key("generate", "plugin_version", gen.PluginVersion)
+	p.key("generate", "version_range", gen.VersionRange)
+	if gen.Out != "" {
+		p.key("generate", "out", p.rel(gen.OutPath("")))
+	}
+	if gen.InProcess {
+		p.key("generate", "in_process", "true")
+	}
+	if gen.FixPaths {
+		p.key("generate", "fix_paths_postproc", "true")
+	}
+	if gen.JSONPostProc {
+		p.key("generate", "json_tag_postproc", "true")
+	}
+	if t := gen.FilenameTemplate; t != nil {
+		p.key("generate", "filename_template", t.Root.String())
+	}
+	if len(gen.PathMap) > 0 {
+		pairs := make([]string, len(gen.PathMap))
+		for i, kv := range gen.PathMap {
+			pairs[i] = kv.Key + "=" + kv.Value
+		}
+		p.key("generate", "path_map", strings.Join(pairs, ","))
+	}
+	for _, kv := range gen.Params {
+		if kv.Value == "" {
+			fmt.Fprintln(p.w, kv.Key)
+			continue
+		}
+		fmt.Fprintf(p.w, "%s=%s\n", kv.Key, kv.Value)
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -37,6 +37,9 @@ func Run(dir string) error {
 }
 
 func vetCfg(dir string, cfg *config.Config) {
+	for _, w := range cfg.Warnings {
+		fmt.Printf("%s: %s\n", dir, w)
+	}
 	if cfg.ProtocVersion == "" {
 		fmt.Printf("%s: specify protoc version\n", dir)
 	}
@@ -0,0 +1,135 @@
+// Package workspace reads gunk.work files, which list the modules of a
+// workspace, such as a platform repository aggregating many API modules, to
+// load and generate together.
+//
+// The syntax follows that of go.work files:
+//
+//	// The APIs of the platform.
+//	use (
+//		./payments
+//		./billing
+//	)
+//	use ./shared
+//
+// Each directory is a module with its own go.mod, relative to the gunk.work
+// file. Gunk packages of the modules can import each other without requiring
+// each other in their go.mod files, and the .gunkconfig next to the gunk.work
+// file applies to all of them.
+package workspace
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FileName is the name of workspace manifest files.
+const FileName = "gunk.work"
+
+// Workspace is a parsed gunk.work file.
+type Workspace struct {
+	Dir string   // directory of the gunk.work file
+	Use []string // absolute directories of the modules, in order
+}
+
+// Find returns the workspace of dir, read from the closest gunk.work file in
+// dir or its parent directories. It returns nil if there is none.
+func Find(dir string) (*Workspace, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		file := filepath.Join(dir, FileName)
+		data, err := ioutil.ReadFile(file)
+		if err == nil {
+			return Parse(file, data)
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// Contains reports whether dir is within one of the modules of ws.
+func (ws *Workspace) Contains(dir string) bool {
+	for _, use := range ws.Use {
+		rel, err := filepath.Rel(use, dir)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse parses the contents of the gunk.work file at the absolute path file.
+func Parse(file string, data []byte) (*Workspace, error) {
+	ws := &Workspace{Dir: filepath.Dir(file)}
+	seen := make(map[string]bool)
+	use := func(line int, arg string) error {
+		if unquoted, err := strconv.Unquote(arg); err == nil {
+			arg = unquoted
+		}
+		if arg == "" || strings.ContainsAny(arg, " \t") {
+			return fmt.Errorf("%s:%d: invalid directory %q", file, line, arg)
+		}
+		dir := filepath.FromSlash(arg)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(ws.Dir, dir)
+		}
+		if seen[dir] {
+			return fmt.Errorf("%s:%d: directory %s used more than once", file, line, arg)
+		}
+		seen[dir] = true
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil {
+			return fmt.Errorf("%s:%d: %s is not a module: no go.mod file", file, line, arg)
+		}
+		ws.Use = append(ws.Use, dir)
+		return nil
+	}
+	inBlock := false
+	for i, line := range strings.Split(string(data), "\n") {
+		lineNum := i + 1
+		if j := strings.Index(line, "//"); j >= 0 {
+			line = line[:j]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case inBlock && len(fields) == 1 && fields[0] == ")":
+			inBlock = false
+		case inBlock:
+			if len(fields) != 1 {
+				return nil, fmt.Errorf("%s:%d: expected one directory per line", file, lineNum)
+			}
+			if err := use(lineNum, fields[0]); err != nil {
+				return nil, err
+			}
+		case fields[0] != "use":
+			return nil, fmt.Errorf("%s:%d: unknown directive %q; only use is supported", file, lineNum, fields[0])
+		case len(fields) == 2 && fields[1] == "(":
+			inBlock = true
+		case len(fields) == 2:
+			if err := use(lineNum, fields[1]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: usage: use <dir>, or use ( ... )", file, lineNum)
+		}
+	}
+	if inBlock {
+		return nil, fmt.Errorf("%s: unterminated use block", file)
+	}
+	if len(ws.Use) == 0 {
+		return nil, fmt.Errorf("%s: no modules to use", file)
+	}
+	return ws, nil
+}